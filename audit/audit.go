@@ -0,0 +1,92 @@
+// Package audit keeps an append-only log of the decisions skipper makes, so
+// that why a step was skipped in a given build can be proven later, along
+// with the dependency graph the decision was made with.
+//
+// The log is a single file with one JSON record per line. Records are only
+// ever appended, by every skipper process of every build.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// Record is a decision about a step.
+type Record struct {
+	Time    time.Time
+	BuildID string
+	// Step is the CmdTree name of the step.
+	Step string
+	// Decision is "run" or "skip".
+	Decision string
+	Reason   string `json:",omitempty"`
+	// Graph is the SHA-256 digest of the dependency graph the decision
+	// was made with, or empty if there was none.
+	Graph string `json:",omitempty"`
+}
+
+// Append adds r to the log in file.
+func Append(file string, r *Record) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	// A single write, so that the records of concurrent steps don't mix.
+	_, err = f.Write(append(b, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Filter selects records. Zero fields match every record.
+type Filter struct {
+	BuildID string
+	// Step matches the step names of the records.
+	Step *regexp.Regexp
+	// Since and Until bound the time of the records, inclusively.
+	Since time.Time
+	Until time.Time
+}
+
+func (f *Filter) matches(r *Record) bool {
+	return (f.BuildID == "" || r.BuildID == f.BuildID) &&
+		(f.Step == nil || f.Step.MatchString(r.Step)) &&
+		(f.Since.IsZero() || !r.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || !r.Time.After(f.Until))
+}
+
+// Read returns the records of the log in file that match f, in the order
+// they were appended.
+func Read(file string, f Filter) ([]Record, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	var records []Record
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// A step killed mid-write leaves a partial line.
+			continue
+		}
+		if f.matches(&r) {
+			records = append(records, r)
+		}
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAppendRead(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit", "decisions.ndjson")
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Time: start, BuildID: "B1", Step: `["make"]`, Decision: "run", Reason: "file \"/src/a.c\" changed", Graph: "abc"},
+		{Time: start.Add(time.Minute), BuildID: "B1", Step: `["make","cc"]`, Decision: "skip", Graph: "abc"},
+		{Time: start.Add(time.Hour), BuildID: "B2", Step: `["make","cc"]`, Decision: "run"},
+	}
+	for i := range records {
+		if err := Append(file, &records[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A decision interrupted mid-write.
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"Time":"2020-01-01T02:00:00Z","Bui`)
+	f.Close()
+
+	for _, tc := range []struct {
+		name   string
+		filter Filter
+		want   []Record
+	}{
+		{"all", Filter{}, records},
+		{"build", Filter{BuildID: "B1"}, records[:2]},
+		{"step", Filter{Step: regexp.MustCompile(`"cc"`)}, records[1:]},
+		{"since", Filter{Since: start.Add(time.Minute)}, records[1:]},
+		{"until", Filter{Until: start.Add(time.Minute)}, records[:2]},
+		{"none", Filter{BuildID: "B3"}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Read(file, tc.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected records (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
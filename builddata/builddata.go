@@ -2,31 +2,165 @@
 package builddata
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"strings"
 )
 
+// decompressors are the commands that decompress files to stdout, by the
+// magic bytes files start with. Go has no zstd or xz decompressor in the
+// standard library, so they're decompressed by the zstd and xz tools, which
+// must be in PATH.
+var decompressors = []struct {
+	magic string
+	args  []string
+}{
+	{"\x28\xb5\x2f\xfd", []string{"zstd", "-q", "-d", "-c"}},
+	{"\xfd7zXZ\x00", []string{"xz", "-q", "-d", "-c"}},
+}
+
+const gzipMagic = "\x1f\x8b"
+
 // OpenFile opens a build log or build report file for reading. Files can be
-// gzipped or plain text. Callers are responsible for closing the file.
+// gzipped, compressed with zstd or xz, or plain text. The compression is
+// detected from the first bytes of the file, not from its name, so
+// downloaded files without an extension open too. Callers are responsible
+// for closing the file.
 func OpenFile(file string) (io.ReadCloser, error) {
-	var (
-		f   io.ReadCloser
-		err error
-	)
-	f, err = os.Open(file)
+	f, err := os.Open(file)
 	if err != nil {
 		return nil, err
 	}
-	// I tried using gzip.NewReader + gzip.ErrHeader to find if the file is not a gzip, didn't quite work.
-	// Using the extension is probably OK and predictable enough.
-	if strings.HasSuffix(file, ".gz") {
-		f, err = gzip.NewReader(f)
+	r, err := newReader(f, f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Could not decompress file %v: %v", file, err)
+	}
+	return r, nil
+}
+
+// NewReader returns a reader of the build log or build report read by r,
+// decompressing it like OpenFile. Closing it doesn't close r.
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	return newReader(r, nil)
+}
+
+// newReader returns a reader of the decompressed contents of r. Closing it
+// closes c, if set.
+func newReader(r io.Reader, c io.Closer) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	// Peek returns fewer bytes, and an error, for files shorter than the
+	// longest magic, which are plain text.
+	head, _ := br.Peek(6)
+	if strings.HasPrefix(string(head), gzipMagic) {
+		gz, err := gzip.NewReader(br)
 		if err != nil {
-			return nil, fmt.Errorf("Could not gunzip file %v: %v", file, err)
+			return nil, err
+		}
+		return &gzipFile{Reader: gz, c: c}, nil
+	}
+	for _, d := range decompressors {
+		if strings.HasPrefix(string(head), d.magic) {
+			return decompress(br, c, d.args)
 		}
 	}
-	return f, nil
+	return &plainFile{Reader: br, c: c}, nil
+}
+
+// plainFile reads an uncompressed file through the buffer its first bytes
+// were peeked with.
+type plainFile struct {
+	*bufio.Reader
+	c io.Closer
+}
+
+func (p *plainFile) Close() error {
+	return closeIfSet(p.c)
+}
+
+// gzipFile closes the file along with the gzip reader, which doesn't close
+// its underlying reader.
+type gzipFile struct {
+	*gzip.Reader
+	c io.Closer
+}
+
+func (g *gzipFile) Close() error {
+	err := g.Reader.Close()
+	if err := closeIfSet(g.c); err != nil {
+		return err
+	}
+	return err
+}
+
+func closeIfSet(c io.Closer) error {
+	if c == nil {
+		return nil
+	}
+	return c.Close()
+}
+
+// decompress runs args with r as stdin and returns its stdout. Closing it
+// closes c, if set.
+func decompress(r io.Reader, c io.Closer, args []string) (io.ReadCloser, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = r
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &cmdReader{ReadCloser: out, cmd: cmd, stderr: stderr, c: c}, nil
+}
+
+// cmdReader reads the output of a decompressor. Read returns the
+// decompressor's error at the end of the output, so that corrupt files
+// aren't mistaken for short ones.
+type cmdReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	c      io.Closer
+	waited bool
+}
+
+func (r *cmdReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		if werr := r.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (r *cmdReader) wait() error {
+	if r.waited {
+		return nil
+	}
+	r.waited = true
+	if err := r.cmd.Wait(); err != nil {
+		return fmt.Errorf("%v: %v: %s", r.cmd.Args[0], err, bytes.TrimSpace(r.stderr.Bytes()))
+	}
+	return nil
+}
+
+// Close stops reading. The decompressor is killed if it hasn't finished.
+func (r *cmdReader) Close() error {
+	r.ReadCloser.Close()
+	if !r.waited {
+		r.cmd.Process.Kill()
+		r.waited = true
+		r.cmd.Wait()
+	}
+	return closeIfSet(r.c)
 }
@@ -0,0 +1,99 @@
+package builddata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const report = `{"CmdTree":["make"],"Mode":"R","File":"/src/a.c"}
+`
+
+func TestOpenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builddata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name string, b []byte) string {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	files := []string{write("report.json", []byte(report))}
+	gz := new(bytes.Buffer)
+	w := gzip.NewWriter(gz)
+	w.Write([]byte(report))
+	w.Close()
+	files = append(files, write("report.json.gz", gz.Bytes()))
+	// The compression is detected from the contents, not the name.
+	files = append(files, write("downloaded", gz.Bytes()))
+	files = append(files, write("misnamed.gz", []byte(report)))
+	files = append(files, write("short", []byte("{}")))
+	for ext, tool := range map[string]string{".zst": "zstd", ".xz": "xz"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Logf("%v isn't installed, not testing %v files", tool, ext)
+			continue
+		}
+		p := write("report.json", []byte(report))
+		if out, err := exec.Command(tool, "-q", "-k", "-f", p).CombinedOutput(); err != nil {
+			t.Fatalf("%v: %v: %s", tool, err, out)
+		}
+		files = append(files, p+ext)
+		b, err := ioutil.ReadFile(p + ext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, write("downloaded"+tool, b))
+	}
+
+	for _, f := range files {
+		r, err := OpenFile(f)
+		if err != nil {
+			t.Errorf("OpenFile(%v): %v", filepath.Base(f), err)
+			continue
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("reading %v: %v", filepath.Base(f), err)
+		}
+		if err := r.Close(); err != nil {
+			t.Errorf("closing %v: %v", filepath.Base(f), err)
+		}
+		want := report
+		if filepath.Base(f) == "short" {
+			want = "{}"
+		}
+		if string(b) != want {
+			t.Errorf("%v: got %q wanted %q", filepath.Base(f), b, want)
+		}
+	}
+}
+
+func TestOpenFileCorrupt(t *testing.T) {
+	if _, err := exec.LookPath("xz"); err != nil {
+		t.Skip("xz isn't installed")
+	}
+	f, err := ioutil.TempFile("", "report-*.json.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("\xfd7zXZ\x00 but not xz")
+	f.Close()
+	r, err := OpenFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Errorf("reading a corrupt file succeeded")
+	}
+}
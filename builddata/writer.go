@@ -0,0 +1,152 @@
+package builddata
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// compressors are the commands that compress their stdin to stdout, by the
+// extension of the files they write. Like decompressors, they must be in
+// PATH.
+var compressors = []struct {
+	ext  string
+	args []string
+}{
+	{".zst", []string{"zstd", "-q", "-c"}},
+	{".xz", []string{"xz", "-q", "-c"}},
+}
+
+// Writer writes a build log or build report file, one JSON entry per line.
+// The file is written to a temporary file next to it, which replaces it on
+// Close, so readers never see a partial file and failed writes leave the
+// previous file in place.
+type Writer struct {
+	path string
+	f    *os.File
+	bw   *bufio.Writer
+	enc  *json.Encoder
+	// gz or cmd compress the file, if it's compressed.
+	gz     *gzip.Writer
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr *bytes.Buffer
+	done   bool
+}
+
+// CreateFile creates a build log or build report file at path, compressed
+// according to its extension: .gz files are gzipped, .zst files compressed
+// with zstd and .xz files with xz. Other files are plain text. The caller
+// must call Close to write the file, or Discard to drop it.
+func CreateFile(path string) (*Writer, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{path: path, f: f}
+	var out io.Writer = f
+	if strings.HasSuffix(path, ".gz") {
+		w.gz = gzip.NewWriter(f)
+		out = w.gz
+	}
+	for _, c := range compressors {
+		if !strings.HasSuffix(path, c.ext) {
+			continue
+		}
+		w.cmd = exec.Command(c.args[0], c.args[1:]...)
+		w.cmd.Stdout = f
+		w.stderr = new(bytes.Buffer)
+		w.cmd.Stderr = w.stderr
+		if w.stdin, err = w.cmd.StdinPipe(); err == nil {
+			err = w.cmd.Start()
+		}
+		if err != nil {
+			w.cmd = nil
+			w.Discard()
+			return nil, fmt.Errorf("could not compress %v: %v", path, err)
+		}
+		out = w.stdin
+	}
+	w.bw = bufio.NewWriter(out)
+	w.enc = json.NewEncoder(w.bw)
+	w.enc.SetEscapeHTML(false)
+	return w, nil
+}
+
+// Write writes p to the file as is.
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.bw.Write(p)
+}
+
+// Encode writes v as a line of JSON. HTML characters aren't escaped, so that
+// commands and paths stay readable.
+func (w *Writer) Encode(v any) error {
+	return w.enc.Encode(v)
+}
+
+// Close finishes writing the file and replaces the file at its path with it.
+// If it fails, the file is discarded.
+func (w *Writer) Close() error {
+	if w.done {
+		return nil
+	}
+	if err := w.finish(); err != nil {
+		w.Discard()
+		return err
+	}
+	w.done = true
+	if err := os.Rename(w.f.Name(), w.path); err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+	return nil
+}
+
+// finish flushes the file and closes it.
+func (w *Writer) finish() error {
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			return err
+		}
+	}
+	if w.cmd != nil {
+		w.stdin.Close()
+		cmd := w.cmd
+		w.cmd = nil
+		if err := cmd.Wait(); err != nil {
+			return fmt.Errorf("%v: %v: %s", cmd.Args[0], err, bytes.TrimSpace(w.stderr.Bytes()))
+		}
+	}
+	// Like files created with os.Create, rather than temporary files.
+	if err := w.f.Chmod(0644); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// Discard drops the file, leaving the file at its path as it was. It does
+// nothing once the file is closed, so it can be deferred.
+func (w *Writer) Discard() {
+	if w.done {
+		return
+	}
+	w.done = true
+	if w.cmd != nil {
+		w.stdin.Close()
+		w.cmd.Process.Kill()
+		w.cmd.Wait()
+	}
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
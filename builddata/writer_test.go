@@ -0,0 +1,71 @@
+package builddata
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateFile(t *testing.T) {
+	dir := t.TempDir()
+	entry := struct {
+		CmdTree    []string
+		Mode, File string
+	}{[]string{"make"}, "R", "/src/a.c"}
+	files := []string{"report.json", "report.json.gz"}
+	for ext, tool := range map[string]string{".zst": "zstd", ".xz": "xz"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Logf("%v isn't installed, not testing %v files", tool, ext)
+			continue
+		}
+		files = append(files, "report.json"+ext)
+	}
+	for _, name := range files {
+		path := filepath.Join(dir, name)
+		w, err := CreateFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Encode(entry); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%v exists before it's closed", name)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("closing %v: %v", name, err)
+		}
+		w.Discard()
+		r, err := OpenFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || string(b) != report {
+			t.Errorf("%v: got %q, %v, wanted %q", name, b, err, report)
+		}
+	}
+
+	// Discarding leaves the previous file.
+	path := filepath.Join(dir, "report.json")
+	w, err := CreateFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("partial"))
+	w.Discard()
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != report {
+		t.Errorf("got %q, %v after discarding", b, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != len(files) {
+		t.Errorf("got %d files, wanted %d: temporary files are left", len(entries), len(files))
+	}
+
+	if _, err := CreateFile(filepath.Join(dir, "missing", "report.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v in a missing directory", err)
+	}
+}
@@ -0,0 +1,88 @@
+// Package changes finds the files that changed in a workspace compared to
+// the base build.
+package changes
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// FromGit returns the absolute paths of the files that differ between
+// baseRef and the working tree of the git repository containing the current
+// directory, including untracked files. Both sides of a rename are reported,
+// as are deleted files.
+func FromGit(baseRef string) ([]string, error) {
+	out, err := git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	root := strings.TrimSpace(string(out))
+	diff, err := git("diff", "--name-status", "-z", baseRef)
+	if err != nil {
+		return nil, err
+	}
+	files, err := parseNameStatus(diff, root)
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := git("ls-files", "--others", "--exclude-standard", "--full-name", "-z")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range bytes.Split(untracked, []byte{0}) {
+		if len(f) > 0 {
+			files = append(files, filepath.Join(root, string(f)))
+		}
+	}
+	return files, nil
+}
+
+func git(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %v: %v: %s", strings.Join(args, " "), err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
+
+// parseNameStatus parses the output of `git diff --name-status -z`, which is
+// a sequence of NUL-terminated fields: a status letter followed by one path,
+// or by two paths for renames and copies. Paths are made absolute by joining
+// them to the repository root.
+func parseNameStatus(out []byte, root string) ([]string, error) {
+	fields := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	if len(fields) == 1 && fields[0] == "" {
+		return nil, nil
+	}
+	var files []string
+	for i := 0; i < len(fields); {
+		status := fields[i]
+		if status == "" {
+			return nil, fmt.Errorf("unexpected empty status in git diff output")
+		}
+		paths := 1
+		if status[0] == 'R' || status[0] == 'C' {
+			paths = 2
+		}
+		if i+paths >= len(fields) {
+			return nil, fmt.Errorf("truncated git diff output after status %q", status)
+		}
+		switch status[0] {
+		case 'C':
+			// The copy source is unchanged.
+			files = append(files, filepath.Join(root, fields[i+2]))
+		default:
+			for _, p := range fields[i+1 : i+1+paths] {
+				files = append(files, filepath.Join(root, p))
+			}
+		}
+		i += 1 + paths
+	}
+	return files, nil
+}
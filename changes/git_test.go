@@ -0,0 +1,26 @@
+package changes
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseNameStatus(t *testing.T) {
+	out := "M\x00main.go\x00D\x00old.go\x00R087\x00a/x.go\x00b/x.go\x00C100\x00tmpl.go\x00copy.go\x00A\x00new file.go\x00"
+	got, err := parseNameStatus([]byte(out), "/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/repo/main.go", "/repo/old.go", "/repo/a/x.go", "/repo/b/x.go", "/repo/copy.go", "/repo/new file.go"}
+	if diff := cmp.Diff(got, want); len(diff) > 0 {
+		t.Errorf("got %q wanted %q", got, want)
+	}
+
+	if _, err := parseNameStatus([]byte("R100\x00a.go\x00"), "/repo"); err == nil {
+		t.Errorf("expected an error for a truncated rename")
+	}
+	if got, err := parseNameStatus(nil, "/repo"); err != nil || len(got) != 0 {
+		t.Errorf("got %q, %v for empty output", got, err)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package changes
+
+import (
+	"os"
+	"syscall"
+)
+
+// inode returns the inode number of fi, which changes when a file is
+// replaced, for example by a rename, even if its size and time don't.
+func inode(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package changes
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// ProviderProtocolEnv is set in the environment of change providers to the
+// version of the protocol skipper speaks, so that providers can tell future
+// versions apart.
+const ProviderProtocolEnv = "SKIPPER_CHANGE_PROVIDER_PROTOCOL"
+
+// ProviderProtocol is the version of the change provider protocol.
+const ProviderProtocol = "1"
+
+// FromProvider runs the change provider args and returns the paths of the
+// changes it reports. Providers supply changes from systems skipper doesn't
+// integrate with, like Perforce or Mercurial. They run in the current
+// directory and write one JSON change per line to stdout, in the format of
+// the changes file:
+//
+//	{"Path":"/src/main.c"}
+//	{"Path":"/src/new.c","Type":"rename","OldPath":"/src/old.c"}
+//
+// Blank lines and unknown fields are ignored. Providers that can't tell the
+// changes must exit with a non-zero status, which fails the lookup instead of
+// reporting no change.
+func FromProvider(args []string) ([]string, error) {
+	if len(args) == 0 {
+		return nil, errors.New("empty change provider")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), ProviderProtocolEnv+"="+ProviderProtocol)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("change provider %v: %v: %s", strings.Join(args, " "), err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return parseProviderOutput(out)
+}
+
+// parseProviderOutput returns the paths of the changes a provider wrote.
+func parseProviderOutput(out []byte) ([]string, error) {
+	var files []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	line := 0
+	for scanner.Scan() {
+		line++
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		var ch stepselection.Change
+		if err := json.Unmarshal(b, &ch); err != nil {
+			return nil, fmt.Errorf("invalid change provider output: line %d: %v", line, err)
+		}
+		paths, err := ch.Paths()
+		if err != nil {
+			return nil, fmt.Errorf("invalid change provider output: line %d: %v", line, err)
+		}
+		files = append(files, paths...)
+	}
+	return files, scanner.Err()
+}
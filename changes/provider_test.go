@@ -0,0 +1,59 @@
+package changes
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseProviderOutput(t *testing.T) {
+	out := `{"Path":"/src/main.c"}
+
+{"Path":"/src/new.c","Type":"rename","OldPath":"/src/old.c","Revision":"42"}
+`
+	got, err := parseProviderOutput([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, []string{"/src/main.c", "/src/old.c", "/src/new.c"}); diff != "" {
+		t.Errorf("unexpected files, diff: %v", diff)
+	}
+	for _, bad := range []string{"/src/main.c\n", `{"Type":"add"}`, `{"Path":"/a","Type":"copy"}`} {
+		if _, err := parseProviderOutput([]byte(bad)); err == nil {
+			t.Errorf("no error for output %q", bad)
+		}
+	}
+}
+
+func TestFromProvider(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test provider is a shell script")
+	}
+	dir := t.TempDir()
+	provider := filepath.Join(dir, "provider")
+	script := `#!/bin/sh
+test "$SKIPPER_CHANGE_PROVIDER_PROTOCOL" = 1 || exit 3
+echo '{"Path":"/src/main.c"}'
+`
+	if err := os.WriteFile(provider, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	got, err := FromProvider([]string{provider})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, []string{"/src/main.c"}); diff != "" {
+		t.Errorf("unexpected files, diff: %v", diff)
+	}
+
+	failing := filepath.Join(dir, "failing")
+	if err := os.WriteFile(failing, []byte("#!/bin/sh\necho 'no workspace' >&2\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FromProvider([]string{failing}); err == nil {
+		t.Errorf("no error for a failing provider")
+	}
+}
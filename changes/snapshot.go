@@ -0,0 +1,140 @@
+package changes
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Snapshot records the state of a set of files, so that the ones that
+// changed since can be found without git or a feed of changes. A file
+// changed if its size, modification time or inode differ, or if it was
+// created or deleted. Listed directories also record their entries, so the
+// files added to or removed from them are found too.
+type Snapshot struct {
+	Files []FileState
+}
+
+// FileState is the state of a file in a Snapshot.
+type FileState struct {
+	Path    string
+	Missing bool  `json:",omitempty"`
+	Size    int64 `json:",omitempty"`
+	ModTime time.Time
+	Inode   uint64 `json:",omitempty"`
+	// Entries are the sorted names in a listed directory.
+	Entries []string `json:",omitempty"`
+	// Listed is set for directories whose entries are recorded.
+	Listed bool `json:",omitempty"`
+}
+
+// TakeSnapshot records the state of files and of the directories dirs,
+// along with the entries of dirs.
+func TakeSnapshot(files, dirs []string) *Snapshot {
+	s := &Snapshot{}
+	for _, f := range files {
+		s.Files = append(s.Files, stat(f))
+	}
+	for _, d := range dirs {
+		st := stat(d)
+		st.Listed = true
+		st.Entries = entries(d)
+		s.Files = append(s.Files, st)
+	}
+	return s
+}
+
+// Changed returns the paths, sorted, that changed since the snapshot.
+func (s *Snapshot) Changed() []string {
+	changed := map[string]bool{}
+	for _, old := range s.Files {
+		cur := stat(old.Path)
+		if !old.Listed {
+			if cur.Missing != old.Missing || cur.Size != old.Size || !cur.ModTime.Equal(old.ModTime) || cur.Inode != old.Inode {
+				changed[old.Path] = true
+			}
+			continue
+		}
+		// Only list the directories that may have new or removed entries
+		// again.
+		if cur.Missing == old.Missing && cur.ModTime.Equal(old.ModTime) && cur.Inode == old.Inode {
+			continue
+		}
+		now := map[string]bool{}
+		for _, e := range entries(old.Path) {
+			now[e] = true
+		}
+		for _, e := range old.Entries {
+			if !now[e] {
+				changed[filepath.Join(old.Path, e)] = true
+			}
+			delete(now, e)
+		}
+		for e := range now {
+			changed[filepath.Join(old.Path, e)] = true
+		}
+	}
+	var paths []string
+	for p := range changed {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Write writes the snapshot as a JSON FileState per line.
+func (s *Snapshot) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for i := range s.Files {
+		if err := enc.Encode(&s.Files[i]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadSnapshot reads a snapshot written by Snapshot.Write.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	s := &Snapshot{}
+	dec := json.NewDecoder(r)
+	for {
+		var f FileState
+		if err := dec.Decode(&f); err == io.EOF {
+			return s, nil
+		} else if err != nil {
+			return nil, err
+		}
+		s.Files = append(s.Files, f)
+	}
+}
+
+func stat(path string) FileState {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return FileState{Path: path, Missing: true}
+	}
+	st := FileState{Path: path, ModTime: fi.ModTime().UTC(), Inode: inode(fi)}
+	if !fi.IsDir() {
+		// The size of directories says little and varies by filesystem.
+		st.Size = fi.Size()
+	}
+	return st
+}
+
+func entries(dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, fi := range infos {
+		names = append(names, fi.Name())
+	}
+	return names
+}
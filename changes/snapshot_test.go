@@ -0,0 +1,76 @@
+package changes
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skipper-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := func(name string) string { return filepath.Join(dir, name) }
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(path(name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(path("src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write("src/same.c", "same")
+	write("src/edited.c", "old")
+	write("src/replaced.c", "old")
+	write("src/deleted.c", "old")
+	write("src/unlisted.h", "")
+
+	files := []string{path("src/same.c"), path("src/edited.c"), path("src/replaced.c"), path("src/deleted.c"), path("src/created.c")}
+	s := TakeSnapshot(files, []string{path("src")})
+	buf := new(bytes.Buffer)
+	if err := s.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	s, err = ReadSnapshot(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Changed(); len(got) != 0 {
+		t.Errorf("got changes %q right after the snapshot", got)
+	}
+
+	// Keep the times of edits apart from the snapshot's on coarse clocks.
+	later := time.Now().Add(time.Minute)
+	write("src/edited.c", "new")
+	os.Chtimes(path("src/edited.c"), later, later)
+	// Same size, same time, but a different file.
+	fi, err := os.Stat(path("src/replaced.c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("replaced.tmp", "new")
+	os.Chtimes(path("replaced.tmp"), fi.ModTime(), fi.ModTime())
+	if err := os.Rename(path("replaced.tmp"), path("src/replaced.c")); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path("src/deleted.c"))
+	write("src/created.c", "")
+	write("src/new.h", "")
+	os.Chtimes(path("src"), later, later)
+
+	want := []string{path("src/created.c"), path("src/deleted.c"), path("src/edited.c"), path("src/new.h")}
+	if inode(fi) != 0 {
+		want = append(want, path("src/replaced.c"))
+	}
+	got := s.Changed()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Changed: (-want +got)\n%s", diff)
+	}
+}
@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// The HTTP API of the daemon. Its OpenAPI document is generated from the
+// types below and the routes of apiRoutes, so that they can't disagree.
+
+type apiDecisionRequest struct {
+	// Step is the CmdTree of the step, outermost step first.
+	Step []string `json:"step"`
+	// Changes are the files changed since the base build.
+	Changes []string `json:"changes,omitempty"`
+	// Env is the environment the step runs with, as NAME=value. Without
+	// it, the environment isn't compared with the base build.
+	Env []string `json:"env,omitempty"`
+}
+
+type apiDecision struct {
+	// Step is the name of the step in the graph.
+	Step   string `json:"step"`
+	Run    bool   `json:"run"`
+	Reason string `json:"reason,omitempty"`
+	// Duration is how long the step took in the base build, in
+	// nanoseconds, if known.
+	Duration time.Duration `json:"duration,omitempty"`
+	// Unknown is set if the step isn't in the graph. Clients decide what
+	// to do with such steps, like skipper's --on-unknown-step.
+	Unknown bool   `json:"unknown,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type apiInputs struct {
+	Step  string   `json:"step"`
+	Files []string `json:"files"`
+	Dirs  []string `json:"dirs"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// apiRoute is an operation of the API.
+type apiRoute struct {
+	method, path, summary string
+	// request and response are the types of the bodies, if any.
+	request, response interface{}
+	// params are the path parameters.
+	params  []string
+	handler func(d *daemon, w http.ResponseWriter, r *http.Request)
+}
+
+var apiRoutes = []apiRoute{
+	{
+		method: "POST", path: "/v1/decisions", summary: "Decide whether a step must run",
+		request: apiDecisionRequest{}, response: apiDecision{},
+		handler: (*daemon).apiDecide,
+	},
+	{
+		method: "GET", path: "/v1/steps/{id}/inputs", summary: "List the transitive inputs of a step",
+		params: []string{"id"}, response: apiInputs{},
+		handler: (*daemon).apiInputs,
+	},
+}
+
+// apiHandler serves the HTTP API of the daemon.
+func (d *daemon) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	for _, r := range apiRoutes {
+		handler := r.handler
+		mux.HandleFunc(r.method+" "+r.path, func(w http.ResponseWriter, r *http.Request) {
+			handler(d, w, r)
+		})
+	}
+	mux.HandleFunc("GET /v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, openAPISpec())
+	})
+	return mux
+}
+
+func apiFail(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(apiError{Error: err.Error()})
+}
+
+func (d *daemon) apiDecide(w http.ResponseWriter, r *http.Request) {
+	var req apiDecisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiFail(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return
+	}
+	if len(req.Step) == 0 {
+		apiFail(w, http.StatusBadRequest, errors.New("invalid request: missing step"))
+		return
+	}
+	resp := d.decide(req.Step, req.Changes, req.Env)
+	writeJSON(w, apiDecision{
+		Step:     stepselection.CmdTree(req.Step).Name(),
+		Run:      resp.Run,
+		Reason:   resp.Reason,
+		Duration: resp.Duration,
+		Unknown:  resp.Unknown,
+		Error:    resp.Error,
+	})
+}
+
+func (d *daemon) apiInputs(w http.ResponseWriter, r *http.Request) {
+	var cmdTree stepselection.CmdTree
+	if err := json.Unmarshal([]byte(r.PathValue("id")), &cmdTree); err != nil {
+		apiFail(w, http.StatusBadRequest, fmt.Errorf("invalid step %q: %v", r.PathValue("id"), err))
+		return
+	}
+	g, ok := d.depGraph.(*stepselection.DependencyGraph)
+	if !ok {
+		apiFail(w, http.StatusNotImplemented, fmt.Errorf("the inputs of steps can't be queried in %v", d.depGraph))
+		return
+	}
+	files, dirs, err := g.StepInputs(cmdTree)
+	switch {
+	case errors.Is(err, stepselection.ErrUnknownStep):
+		apiFail(w, http.StatusNotFound, err)
+		return
+	case err != nil:
+		apiFail(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, apiInputs{Step: cmdTree.Name(), Files: files, Dirs: dirs})
+}
+
+// openAPISpec returns the OpenAPI document of the API.
+func openAPISpec() map[string]interface{} {
+	schemas := map[string]interface{}{}
+	ref := func(v interface{}) map[string]interface{} {
+		t := reflect.TypeOf(v)
+		name := strings.TrimPrefix(t.Name(), "api")
+		schemas[name] = jsonSchema(t)
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	content := func(v interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": ref(v)},
+		}
+	}
+	paths := map[string]interface{}{}
+	for _, r := range apiRoutes {
+		op := map[string]interface{}{
+			"summary": r.summary,
+			"responses": map[string]interface{}{
+				"200":     map[string]interface{}{"description": "OK", "content": content(r.response)},
+				"default": map[string]interface{}{"description": "Error", "content": content(apiError{})},
+			},
+		}
+		if r.request != nil {
+			op["requestBody"] = map[string]interface{}{"required": true, "content": content(r.request)}
+		}
+		var params []interface{}
+		for _, p := range r.params {
+			params = append(params, map[string]interface{}{
+				"name": p, "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		item, _ := paths[r.path].(map[string]interface{})
+		if item == nil {
+			item = map[string]interface{}{}
+			paths[r.path] = item
+		}
+		item[strings.ToLower(r.method)] = op
+	}
+	return map[string]interface{}{
+		"openapi":    "3.0.3",
+		"info":       map[string]interface{}{"title": "skipper", "version": "1"},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// jsonSchema returns the JSON schema of the values of t, as encoded by
+// encoding/json.
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == durationType:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case t.Kind() == reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case t.Kind() == reflect.Struct:
+		props := map[string]interface{}{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, opts := f.Name, ""
+			if tag := f.Tag.Get("json"); tag != "" {
+				name = strings.Split(tag, ",")[0]
+				opts = strings.TrimPrefix(tag, name)
+			}
+			props[name] = jsonSchema(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		s := map[string]interface{}{"type": "object", "properties": props}
+		if required != nil {
+			s["required"] = required
+		}
+		return s
+	}
+	return map[string]interface{}{}
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func TestDaemonAPI(t *testing.T) {
+	report := `{"CmdTree":["gen"],"Mode":"R","File":"/src/a.proto"}
+{"CmdTree":["gen"],"Mode":"W","File":"/out/a.go"}
+{"CmdTree":["make"],"Mode":"R","File":"/out/a.go"}
+`
+	g, err := stepselection.NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &daemon{graph: "/base-graph.gz", depGraph: g, metrics: newDaemonMetrics(g)}
+	srv := httptest.NewServer(d.apiHandler())
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		body string
+		code int
+		want apiDecision
+	}{
+		{`{"step": ["make"], "changes": ["/src/a.proto"]}`, 200, apiDecision{Step: `["make"]`, Run: true, Reason: `step "[\"make\"]" has a dependency that uses "/src/a.proto"`}},
+		{`{"step": ["make"], "changes": ["/src/b.proto"]}`, 200, apiDecision{Step: `["make"]`}},
+		{`{"step": ["nope"]}`, 200, apiDecision{Step: `["nope"]`, Run: true, Unknown: true, Error: `unknown step: [nope]`}},
+		{`{"changes": []}`, 400, apiDecision{}},
+	} {
+		resp, err := http.Post(srv.URL+"/v1/decisions", "application/json", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got apiDecision
+		json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if resp.StatusCode != tc.code {
+			t.Errorf("%v: got status %v, wanted %v", tc.body, resp.StatusCode, tc.code)
+			continue
+		}
+		if tc.code != 200 {
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%v: (-want +got)\n%s", tc.body, diff)
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/v1/steps/" + url.PathEscape(`["make"]`) + "/inputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inputs apiInputs
+	json.NewDecoder(resp.Body).Decode(&inputs)
+	resp.Body.Close()
+	if diff := cmp.Diff(apiInputs{Step: `["make"]`, Files: []string{"/out/a.go", "/src/a.proto"}, Dirs: []string{}}, inputs); diff != "" {
+		t.Errorf("inputs: (-want +got)\n%s", diff)
+	}
+
+	resp, err = http.Get(srv.URL + "/v1/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec struct {
+		Paths      map[string]map[string]interface{}
+		Components struct{ Schemas map[string]interface{} }
+	}
+	json.NewDecoder(resp.Body).Decode(&spec)
+	resp.Body.Close()
+	if spec.Paths["/v1/decisions"]["post"] == nil || spec.Paths["/v1/steps/{id}/inputs"]["get"] == nil {
+		t.Errorf("the OpenAPI document is missing operations: %v", spec.Paths)
+	}
+	if spec.Components.Schemas["Decision"] == nil {
+		t.Errorf("the OpenAPI document is missing schemas: %v", spec.Components.Schemas)
+	}
+}
@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yourbase/skipper/audit"
+	"github.com/yourbase/skipper/journal"
+)
+
+var (
+	auditLogFlag   string
+	auditBuildFlag string
+	auditStepFlag  string
+	auditSinceFlag string
+	auditUntilFlag string
+	auditJSONFlag  bool
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Query the decision audit log",
+}
+
+var auditShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the decisions in the audit log",
+	Long: `Prints the decisions appended to --audit-log, oldest first: when each was
+made, in which build, whether the step ran or was skipped and why, and the
+SHA-256 digest of the dependency graph it was made with, which can be
+compared to the digest of an archived graph. The digest is of the graph as
+loaded: of build reports uncompressed, like the hash "skipper graph push"
+names them after, and of compiled and SQLite graphs as they're stored.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		file := auditLog()
+		if file == "" {
+			fmt.Fprintln(os.Stderr, "The audit log is disabled, set --audit-log")
+			os.Exit(1)
+		}
+		f := audit.Filter{BuildID: auditBuildFlag}
+		if auditStepFlag != "" {
+			re, err := regexp.Compile(auditStepFlag)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid --step %q: %v\n", auditStepFlag, err)
+				os.Exit(1)
+			}
+			f.Step = re
+		}
+		now := time.Now()
+		var err error
+		if auditSinceFlag != "" {
+			if f.Since, err = parseAuditTime("since", auditSinceFlag, now); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		if auditUntilFlag != "" {
+			if f.Until, err = parseAuditTime("until", auditUntilFlag, now); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		records, err := audit.Read(file, f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not read the audit log: %v\n", err)
+			os.Exit(1)
+		}
+		if err := writeAuditRecords(os.Stdout, records, auditJSONFlag); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// parseAuditTime parses the value s of --since or --until, a duration before
+// now like 24h or an RFC 3339 time.
+func parseAuditTime(flag, s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --%v %q: it must be a duration like 24h or a time like 2006-01-02T15:04:05Z", flag, s)
+	}
+	return t, nil
+}
+
+// writeAuditRecords writes records to w as a table, or as JSON lines like in
+// the log.
+func writeAuditRecords(w io.Writer, records []audit.Record, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		for i := range records {
+			if err := enc.Encode(&records[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tBUILD\tDECISION\tSTEP\tGRAPH\tREASON")
+	for _, r := range records {
+		graph := r.Graph
+		if len(graph) > 12 {
+			graph = graph[:12]
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n", r.Time.Format(time.RFC3339), r.BuildID, r.Decision, r.Step, graph, r.Reason)
+	}
+	return tw.Flush()
+}
+
+// auditDecision appends the decision e to the audit log, if it's enabled.
+// Failing to audit doesn't affect the build.
+func auditDecision(e *journal.Entry) {
+	file := auditLog()
+	if file == "" {
+		return
+	}
+	r := &audit.Record{Time: e.Time, BuildID: e.BuildID, Step: e.Step, Decision: "skip", Reason: e.Reason}
+	if e.Run {
+		r.Decision = "run"
+	}
+	r.Graph = loadedGraphDigest()
+	if err := audit.Append(file, r); err != nil {
+		logger.Warn("could not audit the decision", "step", e.Step, "err", err)
+	}
+}
+
+// loadedGraph holds the hex SHA-256 digest of the dependency graph the
+// decisions are made with, for the audit log. It's computed as the graph is
+// loaded, from the bytes read, or comes from the daemon that loaded it.
+var loadedGraph struct {
+	sync.Mutex
+	digest string
+}
+
+func setGraphDigest(digest string) {
+	loadedGraph.Lock()
+	defer loadedGraph.Unlock()
+	loadedGraph.digest = digest
+}
+
+// loadedGraphDigest returns the digest of the dependency graph loaded, or ""
+// if none was.
+func loadedGraphDigest() string {
+	loadedGraph.Lock()
+	defer loadedGraph.Unlock()
+	return loadedGraph.digest
+}
+
+// digestReader hashes the graph read through it, and makes it the loaded
+// graph once it's read to the end.
+type digestReader struct {
+	io.ReadCloser
+	h    hash.Hash
+	done bool
+}
+
+func newDigestReader(r io.ReadCloser) *digestReader {
+	setGraphDigest("")
+	return &digestReader{ReadCloser: r, h: sha256.New()}
+}
+
+func (r *digestReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.h.Write(p[:n])
+	if err == io.EOF && !r.done {
+		r.done = true
+		setGraphDigest(hex.EncodeToString(r.h.Sum(nil)))
+	}
+	return n, err
+}
+
+// digestGraphFile makes file the loaded graph, if the audit log is enabled.
+// It's for the graphs that aren't read in full when loaded, like SQLite
+// graphs, so they're read once more.
+func digestGraphFile(file string) error {
+	if auditLog() == "" {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(ioutil.Discard, newDigestReader(f))
+	return err
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&auditLogFlag, "audit-log", "", "file every decision is appended to, with the build, the reason and the digest of the dependency graph, for \"skipper audit show\". SQLite graphs are read in full once more to hash them. Defaults to the \"audit_log\" config key; empty disables the audit log")
+	auditShowCmd.Flags().StringVar(&auditBuildFlag, "build", "", "only print the decisions of this build ID")
+	auditShowCmd.Flags().StringVar(&auditStepFlag, "step", "", "only print the decisions about steps whose name matches this regular expression")
+	auditShowCmd.Flags().StringVar(&auditSinceFlag, "since", "", "only print the decisions made since this time, like 2006-01-02T15:04:05Z, or for this long, like 24h")
+	auditShowCmd.Flags().StringVar(&auditUntilFlag, "until", "", "only print the decisions made until this time, or until this long ago")
+	auditShowCmd.Flags().BoolVar(&auditJSONFlag, "json", false, "print the records as JSON lines")
+	auditCmd.AddCommand(auditShowCmd)
+	rootCmd.AddCommand(auditCmd)
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/yourbase/skipper/audit"
+	"github.com/yourbase/skipper/journal"
+)
+
+func TestAuditDecision(t *testing.T) {
+	dir := t.TempDir()
+	graph := filepath.Join(dir, "graph.json")
+	content := `{"CmdTree":["make"],"Mode":"R","File":"/src/a.c"}` + "\n"
+	if err := ioutil.WriteFile(graph, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(log, g string) { auditLogFlag, graphFileFlag = log, g }(auditLogFlag, graphFileFlag)
+	auditLogFlag = filepath.Join(dir, "audit.ndjson")
+	graphFileFlag = graph
+	if _, err := loadGraph(graph); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	auditDecision(&journal.Entry{BuildID: "B1", Time: now, Step: `["make"]`, Run: true, Reason: "file \"/src/a.c\" changed"})
+	auditDecision(&journal.Entry{BuildID: "B1", Time: now, Step: `["make","cc"]`})
+
+	got, err := audit.Read(auditLogFlag, audit.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+	want := []audit.Record{
+		{Time: now, BuildID: "B1", Step: `["make"]`, Decision: "run", Reason: "file \"/src/a.c\" changed", Graph: digest},
+		{Time: now, BuildID: "B1", Step: `["make","cc"]`, Decision: "skip", Graph: digest},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected records (-want +got):\n%v", diff)
+	}
+}
+
+func TestLoadedGraphDigest(t *testing.T) {
+	dir := t.TempDir()
+	content := `{"CmdTree":["make"],"Mode":"R","File":"/src/a.c"}` + "\n"
+	report := filepath.Join(dir, "graph.json")
+	if err := ioutil.WriteFile(report, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(content))
+	zw.Close()
+	gzipped := filepath.Join(dir, "pushed.json.gz")
+	if err := ioutil.WriteFile(gzipped, gz.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(log string) { auditLogFlag = log }(auditLogFlag)
+	auditLogFlag = filepath.Join(dir, "audit.ndjson")
+	digest := fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+
+	for _, file := range []string{report, gzipped} {
+		if _, err := loadGraph(file); err != nil {
+			t.Fatal(err)
+		}
+		if got := loadedGraphDigest(); got != digest {
+			t.Errorf("loaded %v: got digest %q, wanted %q", file, got, digest)
+		}
+		setGraphDigest("")
+		if _, err := loadStepGraph(file, []string{"make"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := loadedGraphDigest(); got != digest {
+			t.Errorf("loaded %v for a step: got digest %q, wanted %q", file, got, digest)
+		}
+	}
+
+	// A compiled graph is digested as stored.
+	if err := compileGraph(report, compiledGraphPath(report)); err != nil {
+		t.Fatal(err)
+	}
+	compiled, err := ioutil.ReadFile(compiledGraphPath(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadGraph(report); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := loadedGraphDigest(), fmt.Sprintf("%x", sha256.Sum256(compiled)); got != want {
+		t.Errorf("loaded the compiled graph: got digest %q, wanted %q", got, want)
+	}
+
+	// A graph that fails to load leaves no digest.
+	if _, err := loadGraph(filepath.Join(dir, "missing.json")); err == nil {
+		t.Fatal("loaded a missing graph")
+	}
+	if got := loadedGraphDigest(); got != "" {
+		t.Errorf("got digest %q after failing to load the graph", got)
+	}
+}
+
+func TestParseAuditTime(t *testing.T) {
+	now := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	for s, want := range map[string]time.Time{
+		"24h":                  time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		"2019-12-31T12:00:00Z": time.Date(2019, 12, 31, 12, 0, 0, 0, time.UTC),
+	} {
+		got, err := parseAuditTime("since", s, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseAuditTime(%q) = %v, %v wanted %v", s, got, err, want)
+		}
+	}
+	if _, err := parseAuditTime("since", "yesterday", now); err == nil {
+		t.Error("parseAuditTime(yesterday) succeeded")
+	}
+}
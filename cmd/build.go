@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yourbase/skipper/journal"
+)
+
+var buildCmd = &cobra.Command{
+	Use:   "build",
+	Short: "Start and finish builds",
+	Long: `Delimits a build, so that every skipper invocation between "skipper build
+start" and "skipper build finish" shares the build ID, and the next build gets
+a new one. Without them, the build ID is kept in the build ID file until it's
+removed by hand.`,
+}
+
+var buildStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start a new build and print its ID",
+	Long: `Creates a new build ID, or uses the one given by --id or the environment, and
+saves it to the build ID file, where later skipper invocations find it,
+replacing the ID of the previous build. The branch, commit, user and CI job of
+the build are recorded in --journal-dir, for "skipper build finish".
+
+The ID is printed, so that it can be exported as SKIPPER_BUILD_ID to builds
+that don't run in the project.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		id := buildIDFlag
+		if id == "" {
+			id, _ = buildIDFromEnv()
+		}
+		if id == "" {
+			var err error
+			if id, err = newBuildULID(); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not create a new build ID: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		idFile, err := buildIDFile()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not find the build ID file: %v\n", err)
+			os.Exit(1)
+		}
+		unlock, err := lockBuildIDFile(idFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not lock the build ID file: %v\n", err)
+			os.Exit(1)
+		}
+		err = saveBuildULID(idFile, id)
+		unlock()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not save build ULID to %v: %v\n", idFile, err)
+			os.Exit(1)
+		}
+		if journalDirFlag != "" {
+			s := buildSession(id, time.Now())
+			if err := journal.WriteSession(journalDirFlag, s); err != nil {
+				logger.Warn("could not record the build session", "id", id, "err", err)
+			}
+		}
+		fmt.Println(id)
+	},
+}
+
+var buildFinishCmd = &cobra.Command{
+	Use:   "finish",
+	Short: "Finish the current build",
+	Long: `Marks the current build as finished in --journal-dir, along with the number of
+steps that ran and were skipped, prints its report like "skipper report" and
+removes the build ID file, so that the next build gets a new ID.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		idFile, err := buildIDFile()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not find the build ID file: %v\n", err)
+			os.Exit(1)
+		}
+		if err := finishBuild(idFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// finishBuild finishes the build given by --id or the environment, or else
+// the one in idFile, and removes idFile.
+func finishBuild(idFile string) error {
+	unlock, err := lockBuildIDFile(idFile)
+	if err != nil {
+		return fmt.Errorf("could not lock the build ID file: %v", err)
+	}
+	defer unlock()
+	id, err := currentBuildID(idFile)
+	if err != nil {
+		return err
+	}
+	if journalDirFlag != "" {
+		if _, err := journal.FinishSession(journalDirFlag, id, time.Now()); err != nil {
+			return fmt.Errorf("could not finish the journal of build %v: %v", id, err)
+		}
+		if err := writeBuildReport(os.Stdout, journalDirFlag, id, false, reportTopFlag); err != nil {
+			logger.Warn("could not report on the build", "id", id, "err", err)
+		}
+	}
+	if err := os.Remove(idFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// currentBuildID returns the build given by --id or the environment, or else
+// the one in idFile, without creating one.
+func currentBuildID(idFile string) (string, error) {
+	id := buildIDFlag
+	if id == "" {
+		id, _ = buildIDFromEnv()
+	}
+	if id == "" {
+		var err error
+		if id, err = buildULIDFromFile(idFile); err != nil {
+			return "", fmt.Errorf("unexpected error reading from %v: %v", idFile, err)
+		}
+	}
+	if id == "" {
+		return "", errors.New("no build was started")
+	}
+	return id, nil
+}
+
+// buildSessionEnvs are the environment variables of common CI systems giving
+// the branch, the commit and the job of a build, in order of preference.
+var buildSessionEnvs = struct{ branch, commit, user, job []string }{
+	branch: []string{"GITHUB_HEAD_REF", "GITHUB_REF_NAME", "CI_COMMIT_REF_NAME", "BUILDKITE_BRANCH", "CIRCLE_BRANCH"},
+	commit: []string{"GITHUB_SHA", "CI_COMMIT_SHA", "BUILDKITE_COMMIT", "CIRCLE_SHA1"},
+	user:   []string{"GITHUB_ACTOR", "GITLAB_USER_LOGIN", "BUILDKITE_BUILD_CREATOR", "CIRCLE_USERNAME"},
+	job:    []string{"GITHUB_JOB", "CI_JOB_ID", "BUILDKITE_JOB_ID", "CIRCLE_BUILD_NUM"},
+}
+
+// buildSession returns the session of the build id starting at start. The
+// branch and the commit come from the CI environment, or from the git
+// repository of the current directory.
+func buildSession(id string, start time.Time) *journal.Session {
+	s := &journal.Session{
+		BuildID: id,
+		Start:   start,
+		Branch:  firstEnv(buildSessionEnvs.branch),
+		Commit:  firstEnv(buildSessionEnvs.commit),
+		User:    firstEnv(buildSessionEnvs.user),
+		CIJob:   firstEnv(buildSessionEnvs.job),
+	}
+	if s.Branch == "" {
+		s.Branch = gitOutput("rev-parse", "--abbrev-ref", "HEAD")
+	}
+	if s.Commit == "" {
+		s.Commit = gitOutput("rev-parse", "HEAD")
+	}
+	if s.User == "" {
+		if u, err := user.Current(); err == nil {
+			s.User = u.Username
+		}
+	}
+	return s
+}
+
+// firstEnv returns the value of the first of names that is set, or "".
+func firstEnv(names []string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// gitOutput returns the output of git with args, or "" if it fails, like
+// outside of git repositories.
+func gitOutput(args ...string) string {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func init() {
+	buildCmd.AddCommand(buildStartCmd)
+	buildCmd.AddCommand(buildFinishCmd)
+	rootCmd.AddCommand(buildCmd)
+}
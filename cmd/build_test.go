@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildSession(t *testing.T) {
+	for _, name := range append(append(append(buildSessionEnvs.branch, buildSessionEnvs.commit...), buildSessionEnvs.user...), buildSessionEnvs.job...) {
+		t.Setenv(name, "")
+	}
+	t.Setenv("CI_COMMIT_REF_NAME", "feature")
+	t.Setenv("CI_COMMIT_SHA", "abc123")
+	t.Setenv("GITLAB_USER_LOGIN", "ci-user")
+	t.Setenv("CI_JOB_ID", "42")
+	start := time.Now()
+	s := buildSession("B1", start)
+	if s.BuildID != "B1" || !s.Start.Equal(start) || s.Branch != "feature" || s.Commit != "abc123" || s.User != "ci-user" || s.CIJob != "42" {
+		t.Errorf("got session %+v", s)
+	}
+}
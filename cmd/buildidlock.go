@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// buildIDLockTimeout is how long skipper waits for another skipper to
+	// release the lock of the build ID file.
+	buildIDLockTimeout = 10 * time.Second
+	// staleBuildIDLockAge is the age past which a lock is considered left
+	// behind by a skipper that died, even if its PID was reused since.
+	// Skippers only hold it while reading or writing the ID.
+	staleBuildIDLockAge = time.Minute
+)
+
+// lockBuildIDFile takes the advisory lock of the build ID file idFile,
+// waiting for concurrent skippers to release it, and returns the function
+// releasing it. The lock is a file next to idFile holding the host and PID
+// of its owner, see buildIDLockOwner. Locks whose owner exited, or older
+// than staleBuildIDLockAge, are broken, see breakBuildIDLock.
+func lockBuildIDFile(idFile string) (unlock func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(idFile), 0755); err != nil {
+		return nil, err
+	}
+	lock := idFile + ".lock"
+	deadline := time.Now().Add(buildIDLockTimeout)
+	for {
+		f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = fmt.Fprint(f, buildIDLockOwner())
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(lock)
+				return nil, err
+			}
+			return func() { os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if fi, err := os.Stat(lock); err == nil && staleBuildIDLock(lock) {
+			if err := breakBuildIDLock(lock, fi); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for the lock %v, remove it if no skipper is running", lock)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// buildIDLockOwner returns the content of the locks of this process, its host
+// name and PID. The directory of the build ID file may be shared by several
+// hosts, like a home directory on NFS, where the PID of another host says
+// nothing about its owner.
+func buildIDLockOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "?"
+	}
+	return fmt.Sprintf("%v %d", host, os.Getpid())
+}
+
+// staleBuildIDLock reports whether the lock file was left behind by a
+// skipper that died while holding it. Whether the owner is still running is
+// only checked on its host, the locks of other hosts go stale with age.
+func staleBuildIDLock(lock string) bool {
+	fi, err := os.Stat(lock)
+	if err != nil {
+		// Released meanwhile.
+		return false
+	}
+	if time.Since(fi.ModTime()) > staleBuildIDLockAge {
+		return true
+	}
+	content, err := ioutil.ReadFile(lock)
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) != 2 {
+		// Still being written, unless it's old.
+		return false
+	}
+	pid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return false
+	}
+	if host, err := os.Hostname(); err != nil || fields[0] != host {
+		return false
+	}
+	return !processExists(pid)
+}
+
+// brokenBuildIDLocks numbers the locks broken by this process, to move each
+// to a unique name.
+var brokenBuildIDLocks int64
+
+// breakBuildIDLock removes the stale lock, found stale as fi. It's moved to a
+// unique name first, so that of the skippers breaking it at once only one
+// does, and a lock taken since it was found stale is put back rather than
+// removed.
+func breakBuildIDLock(lock string, fi os.FileInfo) error {
+	aside := fmt.Sprintf("%v.%d.%d", lock, os.Getpid(), atomic.AddInt64(&brokenBuildIDLocks, 1))
+	if err := os.Rename(lock, aside); os.IsNotExist(err) {
+		// Broken by another skipper.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// Inodes are reused, but not with the same modification time.
+	if moved, err := os.Stat(aside); err != nil || !os.SameFile(fi, moved) || !moved.ModTime().Equal(fi.ModTime()) {
+		return os.Rename(aside, lock)
+	}
+	logger.Warn("breaking a stale lock of the build ID file", "lock", lock)
+	return os.Remove(aside)
+}
+
+// loadOrCreateBuildULID returns the build ID in idFile, creating and saving
+// a new one if there's none. Concurrent skippers get the same ID.
+func loadOrCreateBuildULID(idFile string) (string, error) {
+	unlock, err := lockBuildIDFile(idFile)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	id, err := buildULIDFromFile(idFile)
+	if err != nil || id != "" {
+		return id, err
+	}
+	if id, err = newBuildULID(); err != nil {
+		return "", fmt.Errorf("could not create a new build ID: %v", err)
+	}
+	if err := saveBuildULID(idFile, id); err != nil {
+		return "", fmt.Errorf("could not save build ULID to %v: %v", idFile, err)
+	}
+	return id, nil
+}
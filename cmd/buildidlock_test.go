@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadOrCreateBuildULIDConcurrently(t *testing.T) {
+	idFile := filepath.Join(t.TempDir(), ".skipper", "build-id")
+	ids := make([]string, 20)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id, err := loadOrCreateBuildULID(idFile)
+			if err != nil {
+				t.Error(err)
+			}
+			ids[i] = id
+		}(i)
+	}
+	wg.Wait()
+	for _, id := range ids {
+		if id == "" || id != ids[0] {
+			t.Fatalf("got IDs %q, wanted a single one", ids)
+		}
+	}
+	if saved, _ := buildULIDFromFile(idFile); saved != ids[0] {
+		t.Errorf("saved %q, wanted %q", saved, ids[0])
+	}
+	if _, err := os.Stat(idFile + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("the lock wasn't released: %v", err)
+	}
+}
+
+func TestStaleBuildIDLock(t *testing.T) {
+	dir := t.TempDir()
+	idFile := filepath.Join(dir, "build-id")
+	lock := idFile + ".lock"
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A lock held by a live process isn't broken.
+	if err := os.WriteFile(lock, []byte(host+" 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if staleBuildIDLock(lock) && processExists(1) {
+		t.Errorf("the lock of a running process is stale")
+	}
+
+	// A lock held by a process that exited is.
+	cm := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	exited := strconv.Itoa(cm.Process.Pid)
+	if err := os.WriteFile(lock, []byte(host+" "+exited), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !staleBuildIDLock(lock) {
+		t.Errorf("the lock of an exited process isn't stale")
+	}
+
+	// Not one of another host, or without a host, whose PID could be of
+	// any process.
+	for _, owner := range []string{"other-" + host + " " + exited, exited} {
+		if err := os.WriteFile(lock, []byte(owner), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if staleBuildIDLock(lock) {
+			t.Errorf("the lock %q is stale", owner)
+		}
+	}
+
+	// Not one being written.
+	if err := os.WriteFile(lock, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if staleBuildIDLock(lock) {
+		t.Errorf("an empty lock is stale")
+	}
+
+	// Old locks are stale, whatever their PID.
+	old := time.Now().Add(-2 * staleBuildIDLockAge)
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if !staleBuildIDLock(lock) {
+		t.Errorf("an old lock isn't stale")
+	}
+	stale, err := os.Stat(lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := lockBuildIDFile(idFile)
+	if err != nil {
+		t.Fatalf("could not break the stale lock: %v", err)
+	}
+
+	// A lock taken since it was found stale is put back.
+	if err := breakBuildIDLock(lock, stale); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(lock); err != nil || string(b) != buildIDLockOwner() {
+		t.Errorf("got lock %q, %v, wanted the lock taken after breaking the stale one", b, err)
+	}
+	unlock()
+	if aside, _ := filepath.Glob(lock + ".*"); len(aside) > 0 {
+		t.Errorf("locks were left aside: %q", aside)
+	}
+}
+
+func TestBreakBuildIDLockConcurrently(t *testing.T) {
+	idFile := filepath.Join(t.TempDir(), "build-id")
+	lock := idFile + ".lock"
+	if err := os.WriteFile(lock, []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * staleBuildIDLockAge)
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu      sync.Mutex
+		holders int
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := lockBuildIDFile(idFile)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			holders++
+			if holders > 1 {
+				t.Error("the lock is held twice")
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			holders--
+			mu.Unlock()
+			unlock()
+		}()
+	}
+	wg.Wait()
+}
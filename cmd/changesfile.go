@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+var changesFormatFlag string
+
+// readChanges reads changed files from r. In the "lines" format, there's a
+// path per line, or a JSON stepselection.Change, which gives the type of the
+// change and the old path of renames. In the "null" format, paths are
+// NUL-terminated, like the output of "git diff --name-only -z", so they can
+// contain new lines. The "auto" format is "null" if the start of r has a NUL
+// before any empty line, see detectChangesFormat.
+//
+// If framed is set, the changes end at the first empty line or path and r is
+// left after it. Otherwise empty lines and paths are ignored.
+func readChanges(r *bufio.Reader, format string, framed bool) (map[string]bool, error) {
+	if format == "auto" {
+		format = detectChangesFormat(r)
+	}
+	var delim byte
+	switch format {
+	case "lines":
+		delim = '\n'
+	case "null":
+		delim = 0
+	default:
+		return nil, fmt.Errorf("invalid --changes-format %q, must be auto, lines or null", format)
+	}
+	m := map[string]bool{}
+	for {
+		record, err := r.ReadString(delim)
+		record = strings.TrimSuffix(record, string(delim))
+		if delim == '\n' {
+			record = strings.TrimSuffix(record, "\r")
+		}
+		switch {
+		case delim == '\n' && strings.HasPrefix(record, "{"):
+			var ch stepselection.Change
+			if err := json.Unmarshal([]byte(record), &ch); err != nil {
+				return nil, fmt.Errorf("invalid change %q: %v", record, err)
+			}
+			paths, err := ch.Paths()
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range paths {
+				m[p] = true
+			}
+		case record != "":
+			m[record] = true
+		case framed && err == nil:
+			return m, nil
+		}
+		if err == io.EOF {
+			return m, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// detectChangesFormat looks at the buffered start of r for a NUL. Only the
+// part before the first empty line counts, since a compressed build report
+// may follow the changes on stdin.
+func detectChangesFormat(r *bufio.Reader) string {
+	// Peek fills the buffer.
+	r.Peek(1)
+	buf, _ := r.Peek(r.Buffered())
+	if end := bytes.Index(buf, []byte("\n\n")); end >= 0 {
+		buf = buf[:end]
+	}
+	if bytes.HasPrefix(buf, []byte("\n")) || bytes.IndexByte(buf, 0) < 0 {
+		return "lines"
+	}
+	return "null"
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"bufio"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadChanges(t *testing.T) {
+	input := "/src/a.c\n\n/src/b.c\r\n"
+	r := bufio.NewReader(strings.NewReader(input))
+	got, err := readChanges(r, "auto", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]bool{"/src/a.c": true, "/src/b.c": true}, got); diff != "" {
+		t.Errorf("unframed (-want +got)\n%s", diff)
+	}
+
+	input = `/src/a.c
+{"Path":"/src/new.c","Type":"rename","OldPath":"/src/old.c"}
+{"Path":"/src/gone","Type":"delete"}
+`
+	got, err = readChanges(bufio.NewReader(strings.NewReader(input)), "auto", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"/src/a.c": true, "/src/new.c": true, "/src/old.c": true, "/src/gone": true}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("JSON (-want +got)\n%s", diff)
+	}
+	for _, bad := range []string{`{"Path":"/a","Type":"move"}`, `{"Path":"/a","Type":"rename"}`, `{"Path":`} {
+		if _, err := readChanges(bufio.NewReader(strings.NewReader(bad)), "auto", false); err == nil {
+			t.Errorf("readChanges(%v) succeeded", bad)
+		}
+	}
+
+	input = "/src/a.c\n\n{\"CmdTree\":[\"make\"]}\n"
+	r = bufio.NewReader(strings.NewReader(input))
+	got, err = readChanges(r, "auto", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]bool{"/src/a.c": true}, got); diff != "" {
+		t.Errorf("framed (-want +got)\n%s", diff)
+	}
+	rest, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "{\"CmdTree\":[\"make\"]}\n" {
+		t.Errorf("after the changes got %q", rest)
+	}
+}
+
+func TestReadChangesNull(t *testing.T) {
+	input := "src/new\nline.c\x00src/b.c\x00\x00{\"CmdTree\":[\"make\"]}\n"
+	for _, format := range []string{"auto", "null"} {
+		r := bufio.NewReader(strings.NewReader(input))
+		got, err := readChanges(r, format, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(map[string]bool{"src/new\nline.c": true, "src/b.c": true}, got); diff != "" {
+			t.Errorf("%v (-want +got)\n%s", format, diff)
+		}
+		rest, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rest) != "{\"CmdTree\":[\"make\"]}\n" {
+			t.Errorf("%v: after the changes got %q", format, rest)
+		}
+	}
+	// NULs after the changes, in a compressed build report, don't count.
+	got, err := readChanges(bufio.NewReader(strings.NewReader("a.c\n\n\x1f\x8b\x00")), "auto", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]bool{"a.c": true}, got); diff != "" {
+		t.Errorf("auto lines (-want +got)\n%s", diff)
+	}
+	if _, err := readChanges(bufio.NewReader(strings.NewReader("a.c")), "csv", false); err == nil {
+		t.Errorf("readChanges with an invalid format succeeded")
+	}
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/yourbase/skipper/builddata"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+var (
+	compileOutputFlag  string
+	compileSignKeyFlag string
+)
+
+var compileGraphCmd = &cobra.Command{
+	Use:   "compile-graph",
+	Short: "Compile the build report into a binary graph for fast loading",
+	Long: `Parses the build report given with --dep-graph once and writes the
+resulting graph in a compact binary format, by default next to the report with
+a .bin extension. Skipper then loads the compiled graph instead of parsing the
+report, as long as the report and the overlays haven't changed since.
+
+With --sign-key, the compiled graph is signed, see "skipper graph sign".`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		opts, err := graphOptions()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		file, err := singleGraphFile()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		out := compileOutputFlag
+		if out == "" {
+			out = compiledGraphPath(file)
+		}
+		if err := compileGraph(file, out, opts...); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not compile the dependency graph: %v\n", err)
+			os.Exit(1)
+		}
+		keyFile := compileSignKeyFlag
+		if keyFile == "" {
+			keyFile = viper.GetString("graph_signing_key")
+		}
+		if keyFile != "" {
+			if err := signFile(out, keyFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not sign the compiled graph: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		fmt.Printf("skipper: compiled %v to %v\n", file, out)
+	},
+}
+
+// compileGraph compiles the build report in logFile to out. The file is
+// replaced atomically so that concurrent skipper runs never load a partial
+// graph.
+func compileGraph(logFile, out string, opts ...stepselection.Option) error {
+	fi, err := os.Stat(logFile)
+	if err != nil {
+		return err
+	}
+	buildReport, err := builddata.OpenFile(logFile)
+	if err != nil {
+		return err
+	}
+	defer buildReport.Close()
+	tmp := out + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	w := bufio.NewWriter(f)
+	src := stepselection.CompiledSource{Size: fi.Size(), ModTime: fi.ModTime()}
+	if err := stepselection.CompileGraph(w, buildReport, src, opts...); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, out)
+}
+
+func init() {
+	compileGraphCmd.Flags().StringVar(&compileSignKeyFlag, "sign-key", "", "PEM file of the ed25519 private key to sign the compiled graph with (default is the \"graph_signing_key\" config key)")
+	compileGraphCmd.Flags().StringVarP(&compileOutputFlag, "output", "o", "", "compiled graph to write. Defaults to the build report with a .bin extension")
+	rootCmd.AddCommand(compileGraphCmd)
+}
@@ -0,0 +1,842 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/viper"
+	"github.com/yourbase/skipper/importer"
+	"github.com/yourbase/skipper/outputcache"
+	"github.com/yourbase/skipper/remotecache"
+	"github.com/yourbase/skipper/signing"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// stepTagger builds a Tagger from the "tags" rules in the config file and
+// from the step manifest, if one was given with --manifest or the "manifest"
+// config key. Example config:
+//
+//	tags:
+//	  - pattern: "^go test"
+//	    tags: [unit-tests]
+//
+// The manifest is a YAML, TOML or JSON file with a list of steps:
+//
+//	steps:
+//	  - command: "go generate ./..."
+//	    tags: [codegen]
+func stepTagger() (*stepselection.Tagger, error) {
+	var rules []struct {
+		Pattern string
+		Tags    []string
+	}
+	if err := viper.UnmarshalKey("tags", &rules); err != nil {
+		return nil, fmt.Errorf("invalid tags config: %v", err)
+	}
+	t := &stepselection.Tagger{Manifest: map[string][]string{}}
+	for _, r := range rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag pattern %q: %v", r.Pattern, err)
+		}
+		t.Rules = append(t.Rules, stepselection.TagRule{Pattern: re, Tags: r.Tags})
+	}
+
+	manifest := manifestFlag
+	if manifest == "" {
+		manifest = viper.GetString("manifest")
+	}
+	if manifest == "" {
+		return t, nil
+	}
+	m := viper.New()
+	m.SetConfigFile(manifest)
+	if err := m.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("could not read step manifest %v: %v", manifest, err)
+	}
+	var steps []struct {
+		Command string
+		Tags    []string
+	}
+	if err := m.UnmarshalKey("steps", &steps); err != nil {
+		return nil, fmt.Errorf("invalid step manifest %v: %v", manifest, err)
+	}
+	for _, s := range steps {
+		t.Manifest[s.Command] = append(t.Manifest[s.Command], s.Tags...)
+	}
+	return t, nil
+}
+
+// alwaysRunRules reads the "always_run" rules from the config file, along
+// with the tagger needed to match rules by tags. Example config:
+//
+//	always_run:
+//	  - pattern: "^deploy"
+//	    reason: deploys have side effects
+//	  - tags: [network]
+func alwaysRunRules() ([]stepselection.AlwaysRunRule, *stepselection.Tagger, error) {
+	var rules []stepRuleConfig
+	if err := viper.UnmarshalKey("always_run", &rules); err != nil {
+		return nil, nil, fmt.Errorf("invalid always_run config: %v", err)
+	}
+	out, needTags, err := stepRules("always_run", rules)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !needTags {
+		return out, nil, nil
+	}
+	tagger, err := stepTagger()
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, tagger, nil
+}
+
+// stepRuleConfig is a rule matching steps by command or tags in the config
+// file.
+type stepRuleConfig struct {
+	Pattern string
+	Tags    []string
+	Reason  string
+}
+
+// stepRules compiles the rules of the config key, and reports whether any
+// of them match tags.
+func stepRules(key string, rules []stepRuleConfig) ([]stepselection.AlwaysRunRule, bool, error) {
+	var out []stepselection.AlwaysRunRule
+	needTags := false
+	for _, r := range rules {
+		rule := stepselection.AlwaysRunRule{Tags: r.Tags, Reason: r.Reason}
+		if r.Pattern != "" {
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid %v pattern %q: %v", key, r.Pattern, err)
+			}
+			rule.Pattern = re
+		} else if len(r.Tags) == 0 {
+			return nil, false, fmt.Errorf("invalid %v rule: it needs a pattern or tags", key)
+		}
+		needTags = needTags || len(r.Tags) > 0
+		out = append(out, rule)
+	}
+	return out, needTags, nil
+}
+
+// stepOverrides reads the "step_overrides" config key, which changes how the
+// steps whose own command matches a pattern are named and decided. Example
+// config:
+//
+//	step_overrides:
+//	  # The base build ran the step through a wrapper script.
+//	  - pattern: "^make all$"
+//	    alias: ./ci/build.sh
+//	  - pattern: "^deploy"
+//	    run: always
+//	    reason: deploys have side effects
+//	  - pattern: "^lint-docs"
+//	    run: never
+//	  # Files the recorder can't see, like the ones read by a server.
+//	  # Relative paths are relative to the config file.
+//	  - pattern: "^go generate"
+//	    inputs: [/src/api/schema.graphql]
+//	    outputs: [api/generated.go]
+func stepOverrides() ([]stepselection.StepOverride, error) {
+	var cfg []struct {
+		Pattern string
+		Alias   string
+		Run     string
+		Reason  string
+		Inputs  []string
+		Outputs []string
+	}
+	if err := viper.UnmarshalKey("step_overrides", &cfg); err != nil {
+		return nil, fmt.Errorf("invalid step_overrides config: %v", err)
+	}
+	var out []stepselection.StepOverride
+	for _, c := range cfg {
+		if c.Pattern == "" {
+			return nil, errors.New("invalid step_overrides rule: it needs a pattern")
+		}
+		re, err := regexp.Compile(c.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid step_overrides pattern %q: %v", c.Pattern, err)
+		}
+		switch c.Run {
+		case "", "always", "never":
+		default:
+			return nil, fmt.Errorf("invalid step_overrides run %q: it must be \"always\" or \"never\"", c.Run)
+		}
+		inputs, err := configPaths("step_overrides", c.Inputs)
+		if err != nil {
+			return nil, err
+		}
+		outputs, err := configPaths("step_overrides", c.Outputs)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, stepselection.StepOverride{Pattern: re, Alias: c.Alias, Run: c.Run, Reason: c.Reason, Inputs: inputs, Outputs: outputs})
+	}
+	return out, nil
+}
+
+// configDirs maps the config keys to the absolute directory of the config
+// file that set them, for configPaths. A key of the project config replaces
+// the one of the user's.
+var configDirs = map[string]string{}
+
+// recordConfigFile records in configDirs the keys that the config file file
+// sets.
+func recordConfigFile(file string) error {
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		return err
+	}
+	for _, key := range v.AllKeys() {
+		configDirs[key] = dir
+	}
+	return nil
+}
+
+// mergeConfigFile merges the config file file over the config read so far.
+func mergeConfigFile(file string) error {
+	viper.SetConfigFile(file)
+	if err := viper.MergeInConfig(); err != nil {
+		return err
+	}
+	return recordConfigFile(file)
+}
+
+// configPaths returns the files of the config key key named as in the graph.
+// Relative paths are relative to the directory of the config file that set
+// key, and are rejected when it wasn't set by a config file.
+func configPaths(key string, paths []string) ([]string, error) {
+	var out []string
+	for _, p := range paths {
+		if filepath.IsAbs(p) {
+			out = append(out, p)
+			continue
+		}
+		dir, ok := configDirs[key]
+		if !ok {
+			return nil, fmt.Errorf("invalid %v path %q: it must be absolute outside of config files", key, p)
+		}
+		p = filepath.Join(dir, p)
+		// In the form of graph nodes, like changed files.
+		roots, err := rootMapping()
+		if err != nil {
+			return nil, err
+		}
+		if roots != nil {
+			p = roots.Map(p)
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// networkPolicy reads the "network" policy from the config file. tagger is
+// the tagger loaded so far, if any; the one returned can match the policy's
+// rules. Example config:
+//
+//	network:
+//	  # "run", the default, makes the steps that connected to the network
+//	  # in the base build always run. "ignore" treats them like any other.
+//	  policy: run
+//	  # Steps whose network accesses don't affect their results.
+//	  hermetic:
+//	    - pattern: "^go mod download"
+//	    - tags: [fetch]
+func networkPolicy(tagger *stepselection.Tagger) (*stepselection.NetworkPolicy, *stepselection.Tagger, error) {
+	var cfg struct {
+		Policy   string
+		Hermetic []stepRuleConfig
+	}
+	if err := viper.UnmarshalKey("network", &cfg); err != nil {
+		return nil, nil, fmt.Errorf("invalid network config: %v", err)
+	}
+	p := &stepselection.NetworkPolicy{}
+	switch cfg.Policy {
+	case "", "run":
+	case "ignore":
+		p.Ignore = true
+	default:
+		return nil, nil, fmt.Errorf("invalid network policy %q: it must be \"run\" or \"ignore\"", cfg.Policy)
+	}
+	hermetic, needTags, err := stepRules("network.hermetic", cfg.Hermetic)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.Hermetic = hermetic
+	if needTags && tagger == nil {
+		if tagger, err = stepTagger(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return p, tagger, nil
+}
+
+// outputCache returns the output cache given with --output-cache or the
+// "output_cache" config key, backed by the remote cache if any, or nil if
+// there's none. With a remote cache, the local one defaults to
+// ~/.skipper/outputs.
+func outputCache() *outputcache.Cache {
+	dir := outputCacheFlag
+	if dir == "" {
+		dir = viper.GetString("output_cache")
+	}
+	remote := remoteCache()
+	if dir == "" && remote != nil {
+		home, err := homedir.Dir()
+		if err != nil {
+			logger.Warn("could not find the local output cache", "err", err)
+			return nil
+		}
+		dir = filepath.Join(home, ".skipper", "outputs")
+	}
+	if dir == "" {
+		return nil
+	}
+	root, err := workspaceRoot()
+	if err != nil {
+		logger.Warn("could not find the workspace of the output cache", "err", err)
+		return nil
+	}
+	return &outputcache.Cache{Dir: dir, Root: root, Remote: remote}
+}
+
+// remoteCache returns the Bazel remote cache given with --remote-cache or
+// the "remote_cache" config key, or nil if there's none.
+func remoteCache() *remotecache.Client {
+	u := remoteCacheFlag
+	if u == "" {
+		u = viper.GetString("remote_cache")
+	}
+	if u == "" {
+		return nil
+	}
+	return &remotecache.Client{URL: u}
+}
+
+// changeProvider returns the command of the change provider, split on
+// spaces, from --change-provider or the "change_provider" config key, or nil
+// if there's none. The config key doesn't override --changes or
+// --changes-from-git given on the command line, and a relative path to the
+// provider in it is relative to the workspace root, since skipper runs in
+// any of its directories. See changes.FromProvider for the protocol.
+func changeProvider() ([]string, error) {
+	if changeProviderFlag != "" {
+		return strings.Fields(changeProviderFlag), nil
+	}
+	if changesGitFlag != "" || changesFileGiven {
+		return nil, nil
+	}
+	args := strings.Fields(viper.GetString("change_provider"))
+	if len(args) == 0 || filepath.IsAbs(args[0]) || filepath.Base(args[0]) == args[0] {
+		// Commands without a path are looked up in $PATH.
+		return args, nil
+	}
+	root, err := workspaceRoot()
+	if err != nil {
+		return nil, err
+	}
+	args[0] = filepath.Join(root, args[0])
+	return args, nil
+}
+
+// auditLog returns the audit log file, from --audit-log or the "audit_log"
+// config key, or "" if decisions aren't audited.
+func auditLog() string {
+	if auditLogFlag != "" {
+		return auditLogFlag
+	}
+	return viper.GetString("audit_log")
+}
+
+// baseID returns the ID of the base build whose graph to load from the graph
+// store, from --base-id or the "base_id" config key, or "" to load
+// --dep-graph.
+func baseID() string {
+	if baseIDFlag != "" {
+		return baseIDFlag
+	}
+	return viper.GetString("base_id")
+}
+
+// graphStoreDir returns the directory of the graph store, where each build
+// has a directory named after its ID, from the "graph_store" config key or
+// ~/.skipper/graphs.
+func graphStoreDir() (string, error) {
+	if dir := viper.GetString("graph_store"); dir != "" {
+		return homedir.Expand(dir)
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".skipper", "graphs"), nil
+}
+
+// unknownStepPolicy returns what to do with steps that aren't in the base
+// dependency graph, from --on-unknown-step or the "on_unknown_step" config
+// key: "run", the default, "skip" or "fail".
+func unknownStepPolicy() (string, error) {
+	policy := onUnknownStepFlag
+	if policy == "" {
+		policy = viper.GetString("on_unknown_step")
+	}
+	switch policy {
+	case "":
+		return "run", nil
+	case "run", "skip", "fail":
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid unknown step policy %q, want run, skip or fail", policy)
+}
+
+// staleGraphPolicy returns what to do when the base graph is stale, from the
+// "stale_graph" config key: "warn", the default, or "run" every step.
+func staleGraphPolicy() (string, error) {
+	switch policy := viper.GetString("stale_graph"); policy {
+	case "":
+		return "warn", nil
+	case "warn", "run":
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid stale graph policy %q, want warn or run", policy)
+	}
+}
+
+// graphStalenessLimits returns how many days and commits old the base graph
+// can be before it's stale, from the "max_graph_age_days" and
+// "max_graph_commits_behind" config keys. Zero disables the limit.
+func graphStalenessLimits() (maxDays, maxCommits int) {
+	return viper.GetInt("max_graph_age_days"), viper.GetInt("max_graph_commits_behind")
+}
+
+// graphPublicKeyFile returns the public key file graphs must be signed with,
+// from --graph-public-key or the "graph_public_key" config key, or "" if
+// they needn't be signed.
+func graphPublicKeyFile() string {
+	if graphPublicKeyFlag != "" {
+		return graphPublicKeyFlag
+	}
+	return viper.GetString("graph_public_key")
+}
+
+// graphPublicKey returns the public key of graphPublicKeyFile, or nil if
+// graphs needn't be signed.
+func graphPublicKey() (ed25519.PublicKey, error) {
+	keyFile := graphPublicKeyFile()
+	if keyFile == "" {
+		return nil, nil
+	}
+	return signing.ReadPublicKey(keyFile)
+}
+
+// readVerifiedGraphFile reads the graph file and checks its signature by
+// key. The graph must be loaded from the bytes returned, which are the ones
+// checked, rather than from the file, which may have changed since. Graphs
+// read from stdin can't be verified.
+func readVerifiedGraphFile(file string, key ed25519.PublicKey) ([]byte, error) {
+	if file == stdinName {
+		return nil, errors.New("the dependency graph can't be verified when read from stdin")
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := signing.Verify(file, b, key); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ignoreMatcher matches the files skipper ignores: the default patterns and
+// the "ignore" patterns in the config file. Example config:
+//
+//	ignore:
+//	  - "**/*.log"
+//	  - /tmp/**
+func ignoreMatcher() (*stepselection.PathMatcher, error) {
+	patterns := append([]string{}, stepselection.DefaultIgnorePatterns...)
+	patterns = append(patterns, viper.GetStringSlice("ignore")...)
+	m, err := stepselection.NewPathMatcher(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ignore config: %v", err)
+	}
+	return m, nil
+}
+
+// hermeticMatcher matches the files whose changes no step depends on, the
+// "hermetic_prefixes" patterns in the config file or, if the key isn't
+// set, stepselection.DefaultHermeticPatterns. Plain paths match everything
+// under them. It matches nothing with --toolchain-changed. Example config:
+//
+//	hermetic_prefixes:
+//	  - /usr
+//	  - /opt/toolchains
+//	  - "**/.cache/go-build/**"
+func hermeticMatcher() (*stepselection.PathMatcher, error) {
+	if toolchainChangedFlag {
+		return nil, nil
+	}
+	patterns := stepselection.DefaultHermeticPatterns
+	if viper.IsSet("hermetic_prefixes") {
+		patterns = nil
+		for _, p := range viper.GetStringSlice("hermetic_prefixes") {
+			if !strings.ContainsAny(p, "*?[") {
+				p = strings.TrimSuffix(p, "/") + "/**"
+			}
+			patterns = append(patterns, p)
+		}
+	}
+	m, err := stepselection.NewPathMatcher(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hermetic_prefixes config: %v", err)
+	}
+	return m, nil
+}
+
+// tempMatcher matches the temporary files whose entries are dropped from the
+// graph when only the steps writing them read them, the "temp_patterns" in
+// the config file or, if the key isn't set, stepselection.DefaultTempPatterns
+// and $TMPDIR. Plain paths match everything under them. Example config:
+//
+//	temp_patterns:
+//	  - /tmp
+//	  - /scratch
+//	  - "**/.cache/**"
+func tempMatcher() (*stepselection.PathMatcher, error) {
+	patterns := append([]string{}, stepselection.DefaultTempPatterns...)
+	if dir := os.Getenv("TMPDIR"); dir != "" {
+		patterns = append(patterns, dir)
+	}
+	if viper.IsSet("temp_patterns") {
+		patterns = viper.GetStringSlice("temp_patterns")
+	}
+	for i, p := range patterns {
+		if !strings.ContainsAny(p, "*?[") {
+			patterns[i] = strings.TrimSuffix(p, "/") + "/**"
+		}
+	}
+	m, err := stepselection.NewPathMatcher(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid temp_patterns config: %v", err)
+	}
+	return m, nil
+}
+
+// nameNormalizer applies stepselection.DefaultNameRules and the rules of the
+// "normalize" config key to step names. Example config:
+//
+//	normalize:
+//	  - pattern: "--build-id=[0-9a-f]+"
+//	    replacement: "--build-id=*"
+func nameNormalizer() (*stepselection.Normalizer, error) {
+	var rules []struct {
+		Pattern     string
+		Replacement string
+	}
+	if err := viper.UnmarshalKey("normalize", &rules); err != nil {
+		return nil, fmt.Errorf("invalid normalize config: %v", err)
+	}
+	n := &stepselection.Normalizer{Rules: append([]stepselection.NameRule{}, stepselection.DefaultNameRules...)}
+	for _, r := range rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid normalize pattern %q: %v", r.Pattern, err)
+		}
+		n.Rules = append(n.Rules, stepselection.NameRule{Pattern: re, Replacement: r.Replacement})
+	}
+	return n, nil
+}
+
+// rootMapping returns how paths move from the checkout the graph was
+// recorded in to this one, from --graph-root and --workspace-root or the
+// "graph_root" and "workspace_root" config keys, or nil if neither is set.
+// The workspace root defaults to the project containing the current
+// directory.
+func rootMapping() (*stepselection.RootMapping, error) {
+	m := &stepselection.RootMapping{Graph: graphRootFlag, Workspace: workspaceRootFlag}
+	if m.Graph == "" {
+		m.Graph = viper.GetString("graph_root")
+	}
+	if m.Workspace == "" {
+		m.Workspace = viper.GetString("workspace_root")
+	}
+	if m.Graph == "" && m.Workspace == "" {
+		return nil, nil
+	}
+	workspace, err := workspaceRoot()
+	if err != nil {
+		return nil, err
+	}
+	m.Workspace = workspace
+	if m.Graph != "" && !filepath.IsAbs(m.Graph) {
+		return nil, fmt.Errorf("invalid graph root %q: it must be absolute", m.Graph)
+	}
+	return m, nil
+}
+
+// workspaceRoot returns the absolute root of the current checkout, from
+// --workspace-root or the "workspace_root" config key, or else the project
+// containing the current directory, or the current directory outside of
+// projects.
+func workspaceRoot() (string, error) {
+	workspace := workspaceRootFlag
+	if workspace == "" {
+		workspace = viper.GetString("workspace_root")
+	}
+	if workspace == "" {
+		dir, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		workspace = dir
+		if root, ok := projectRoot(dir); ok {
+			workspace = root
+		}
+	}
+	abs, err := filepath.Abs(workspace)
+	if err != nil {
+		return "", fmt.Errorf("invalid workspace root: %v", err)
+	}
+	return abs, nil
+}
+
+// graphOptions returns the options for loading the dependency graph, based
+// on flags and the config file.
+func graphOptions() ([]stepselection.Option, error) {
+	ignore, err := ignoreMatcher()
+	if err != nil {
+		return nil, err
+	}
+	normalizer, err := nameNormalizer()
+	if err != nil {
+		return nil, err
+	}
+	hermetic, err := hermeticMatcher()
+	if err != nil {
+		return nil, err
+	}
+	temp, err := tempMatcher()
+	if err != nil {
+		return nil, err
+	}
+	roots, err := rootMapping()
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := stepOverrides()
+	if err != nil {
+		return nil, err
+	}
+	opts := []stepselection.Option{
+		stepselection.WithIgnore(ignore),
+		stepselection.WithHermetic(hermetic),
+		stepselection.WithTemp(temp),
+		stepselection.WithNormalizer(normalizer),
+		stepselection.WithRootMapping(roots),
+		stepselection.WithStepOverrides(overrides),
+	}
+	if skipCorruptLines() {
+		opts = append(opts, stepselection.WithSkipCorruptLines())
+	}
+	overlays := append(viper.GetStringSlice("overlays"), overlayFlag...)
+	for _, path := range overlays {
+		o, err := loadOverlay(path)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, stepselection.WithOverlay(o))
+	}
+	coverage, err := coverageOverlays()
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range coverage {
+		opts = append(opts, stepselection.WithOverlay(o))
+	}
+	return opts, nil
+}
+
+// coverageOverlays returns overlays adding the files covered by test steps,
+// from the "coverage" config key and --coverage. Example:
+//
+//	coverage:
+//	  - step: "go test ./server"
+//	    file: coverage/server.out
+//	  - tree: ["make test", "npm test"]
+//	    file: coverage/lcov.info
+//
+// Go import paths and relative lcov paths are resolved from the project root.
+func coverageOverlays() ([]*stepselection.Overlay, error) {
+	var profiles []struct {
+		Step string
+		Tree []string
+		File string
+	}
+	if err := viper.UnmarshalKey("coverage", &profiles); err != nil {
+		return nil, fmt.Errorf("invalid coverage config: %v", err)
+	}
+	var overlays []*stepselection.Overlay
+	add := func(step stepselection.CmdTree, file string) error {
+		o, err := loadCoverage(step, file)
+		if err != nil {
+			return err
+		}
+		overlays = append(overlays, o)
+		return nil
+	}
+	for _, p := range profiles {
+		step := stepselection.CmdTree(p.Tree)
+		if p.Step != "" {
+			step = append(step, p.Step)
+		}
+		if len(step) == 0 || p.File == "" {
+			return nil, fmt.Errorf("invalid coverage config: each profile needs a step and a file")
+		}
+		if err := add(step, p.File); err != nil {
+			return nil, err
+		}
+	}
+	for _, arg := range coverageFlag {
+		i := strings.LastIndex(arg, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid --coverage %q, must be <step>=<file>", arg)
+		}
+		step, err := parseStepArg(arg[:i])
+		if err != nil {
+			return nil, err
+		}
+		if err := add(step, arg[i+1:]); err != nil {
+			return nil, err
+		}
+	}
+	return overlays, nil
+}
+
+// loadCoverage reads a coverage profile of step into an overlay.
+func loadCoverage(step stepselection.CmdTree, file string) (*stepselection.Overlay, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read coverage: %v", err)
+	}
+	defer f.Close()
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if root, ok := projectRoot(dir); ok {
+		dir = root
+	}
+	edit := stepselection.OverlayEdit{Step: step}
+	err = importer.Coverage(f, step, dir, func(bog *stepselection.BuildLog) error {
+		edit.Reads = append(edit.Reads, bog.File)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid coverage %v: %v", file, err)
+	}
+	logger.Debug("coverage loaded", "step", step.Name(), "file", file, "files", len(edit.Reads))
+	return &stepselection.Overlay{Source: file, Add: []stepselection.OverlayEdit{edit}}, nil
+}
+
+// streamGraph returns whether wrapped steps only load the part of the build
+// report they depend on, from --stream-graph or the "stream_graph" config key.
+func streamGraph() bool {
+	return streamGraphFlag || viper.GetBool("stream_graph")
+}
+
+// skipCorruptLines returns whether the lines of build reports that don't
+// parse are skipped, from --skip-corrupt-lines or the "skip_corrupt_lines"
+// config key.
+func skipCorruptLines() bool {
+	return skipCorruptLinesFlag || viper.GetBool("skip_corrupt_lines")
+}
+
+// lookupLimits returns the limits of the dependency lookups of in-memory
+// graphs, from the "max_lookup_depth" and "max_lookup_files" config keys.
+// Steps whose lookups reach them are run.
+func lookupLimits() stepselection.LookupLimits {
+	return stepselection.LookupLimits{
+		MaxDepth: viper.GetInt("max_lookup_depth"),
+		MaxFiles: viper.GetInt("max_lookup_files"),
+	}
+}
+
+// fuzzyMatch returns how in-memory graphs match steps they don't have, from
+// the "fuzzy_match" config key. Example config:
+//
+//	fuzzy_match:
+//	  command: true
+//	  similarity: 0.8
+//	  prefix: true
+func fuzzyMatch() stepselection.FuzzyMatch {
+	return stepselection.FuzzyMatch{
+		Command:       viper.GetBool("fuzzy_match.command"),
+		MinSimilarity: viper.GetFloat64("fuzzy_match.similarity"),
+		Prefix:        viper.GetBool("fuzzy_match.prefix"),
+	}
+}
+
+// loadOverlay reads a graph overlay from a YAML or TOML file. Example:
+//
+//	add:
+//	  - step: "go test ./..."
+//	    reads: [/src/testdata/golden.txt]
+//	remove:
+//	  - step: "go build ./..."
+//	    reads: [/tmp/go-build-cache]
+//	  - files: [/var/log/build.log]
+//
+// Nested steps are given with "tree" instead of "step", for example
+// tree: ["make all", "go test ./..."].
+func loadOverlay(path string) (*stepselection.Overlay, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("could not read overlay %v: %v", path, err)
+	}
+	o := &stepselection.Overlay{Source: path}
+	for _, key := range []string{"add", "remove"} {
+		var edits []struct {
+			Step   string
+			Tree   []string
+			Reads  []string
+			Writes []string
+			Files  []string
+		}
+		if err := v.UnmarshalKey(key, &edits); err != nil {
+			return nil, fmt.Errorf("invalid overlay %v: %v", path, err)
+		}
+		for _, e := range edits {
+			edit := stepselection.OverlayEdit{Step: e.Tree, Reads: e.Reads, Writes: e.Writes, Files: e.Files}
+			if e.Step != "" {
+				edit.Step = append(edit.Step, e.Step)
+			}
+			if key == "add" {
+				if len(edit.Step) == 0 {
+					return nil, fmt.Errorf("invalid overlay %v: added edges need a step", path)
+				}
+				o.Add = append(o.Add, edit)
+			} else {
+				o.Remove = append(o.Remove, edit)
+			}
+		}
+	}
+	return o, nil
+}
@@ -0,0 +1,408 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// The daemon protocol is one JSON daemonRequest per connection, answered by
+// one JSON daemonResponse.
+
+type daemonRequest struct {
+	// Graph is the absolute path of the dependency graph the client would
+	// have loaded. The daemon only answers if it's serving the same graph.
+	Graph string
+	// Options is the digest of the client's decision options, see
+	// decisionOptions. The daemon only answers if it decides with the same.
+	Options string
+	Step    []string
+	Changes []string
+	// Env holds the variables of the client's env_allowlist the step runs
+	// with, see stepselection.EnvAllowlist.Snapshot. Without it, the
+	// environment of the step isn't compared with the base build.
+	Env []string
+}
+
+type daemonResponse struct {
+	Graph   string
+	Options string
+	Run     bool
+	Reason  string
+	Error   string
+	// Duration is how long the step took in the base build, if known.
+	Duration time.Duration `json:",omitempty"`
+	// Build is the ID of the build the step was recorded in, if known,
+	// whose outputs are restored when it's skipped.
+	Build string `json:",omitempty"`
+	// GraphDigest is the digest of the graph the daemon loaded, for the
+	// audit log of the client.
+	GraphDigest string `json:",omitempty"`
+	// Unknown is true if the step isn't in the graph, in which case the
+	// client applies its --on-unknown-step policy.
+	Unknown bool `json:",omitempty"`
+}
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Serve skip decisions from an in-memory dependency graph",
+	Long: `Loads the dependency graph once and answers skip decisions over the Unix
+socket given by --socket. While the daemon runs, skipper invocations that use
+the same --dep-graph and --socket query it instead of parsing the graph
+themselves, which saves a lot of time per step for large graphs. Invocations
+whose config, overlays, coverage or re-recorded steps differ from the
+daemon's decide by themselves.
+
+With --metrics-addr, the daemon also serves Prometheus metrics over HTTP at
+/metrics: decisions by outcome, decision latency, the size of the graph and
+why steps ran without being known to depend on the changes (always_run,
+unknown_step, lookup_limit or error).
+
+With --http-addr, the daemon also answers over HTTP, so that a single daemon
+can serve the builds of many machines:
+
+	POST /v1/decisions         decides whether a step must run, given a JSON
+	                           body like {"step": ["make"], "changes": ["/src/a.c"]}
+	GET /v1/steps/{id}/inputs  the transitive inputs of a step, see
+	                           "skipper inputs", where id is the step's name,
+	                           like ["make"], escaped
+	GET /v1/openapi.json       the OpenAPI document of the API`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		opts, err := graphOptions()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		alwaysRun, tagger, err := alwaysRunRules()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		network, tagger, err := networkPolicy(tagger)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		overrides, err := stepOverrides()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		graph, err := absGraphFiles(graphFileFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		g, err := loadGraph(graph, opts...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not load the dependency graph: %v\n", err)
+			os.Exit(1)
+		}
+		digest := loadedGraphDigest()
+		options, err := decisionOptions(graph)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		stale, err := staleGraphReason(graph)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		invalidateAll, err := invalidateAllMatcher()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		l, err := listenUnix(socketFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigs
+			// Closing the listener removes the socket file.
+			l.Close()
+		}()
+		d := &daemon{graph: graph, digest: digest, options: options, depGraph: g, alwaysRun: alwaysRun, network: network, tagger: tagger, overrides: overrides, toolchains: newToolchainFingerprints(toolchainCommands()), stale: stale, staleChecked: time.Now(), invalidateAll: invalidateAll, metrics: newDaemonMetrics(g)}
+		if daemonMetricsAddrFlag != "" {
+			ml, err := net.Listen("tcp", daemonMetricsAddrFlag)
+			if err != nil {
+				l.Close()
+				fmt.Fprintf(os.Stderr, "Could not serve metrics: %v\n", err)
+				os.Exit(1)
+			}
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", d.metrics)
+			go http.Serve(ml, mux)
+			fmt.Printf("skipper: serving metrics on http://%v/metrics\n", ml.Addr())
+		}
+		if daemonHTTPAddrFlag != "" {
+			hl, err := net.Listen("tcp", daemonHTTPAddrFlag)
+			if err != nil {
+				l.Close()
+				fmt.Fprintf(os.Stderr, "Could not serve the HTTP API: %v\n", err)
+				os.Exit(1)
+			}
+			go http.Serve(hl, d.apiHandler())
+			fmt.Printf("skipper: serving the HTTP API on http://%v/v1/\n", hl.Addr())
+		}
+		fmt.Printf("skipper: serving %v on %v\n", g, socketFlag)
+		if err := d.serve(l); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+type daemon struct {
+	graph string
+	// digest is the digest of the graph, see loadedGraphDigest.
+	digest string
+	// options is the digest of the options the daemon decides with, see
+	// decisionOptions.
+	options   string
+	depGraph  stepselection.Graph
+	alwaysRun []stepselection.AlwaysRunRule
+	network   *stepselection.NetworkPolicy
+	tagger    *stepselection.Tagger
+	overrides []stepselection.StepOverride
+	// toolchains is shared by the requests, so that the fingerprints are
+	// only computed again when the tools change.
+	toolchains *toolchainFingerprints
+	// stale is why the graph is too stale to decide from, see
+	// staleGraphReason, as of staleChecked. They're guarded by staleMu.
+	staleMu      sync.Mutex
+	stale        string
+	staleChecked time.Time
+	// invalidateAll matches the changes that make every step run.
+	invalidateAll *stepselection.PathMatcher
+	metrics       *daemonMetrics
+}
+
+// staleCheckInterval is how often the daemon checks again whether its graph
+// is too stale to decide from: the graph ages, and HEAD moves, while the
+// daemon runs.
+const staleCheckInterval = time.Minute
+
+// staleReason returns why the graph is too stale to decide from, or "",
+// checking again at most every staleCheckInterval.
+func (d *daemon) staleReason() string {
+	d.staleMu.Lock()
+	defer d.staleMu.Unlock()
+	if time.Since(d.staleChecked) < staleCheckInterval {
+		return d.stale
+	}
+	stale, err := staleGraphReason(d.graph)
+	if err != nil {
+		logger.Warn("could not check whether the graph is stale", "err", err)
+		return d.stale
+	}
+	d.stale, d.staleChecked = stale, time.Now()
+	return d.stale
+}
+
+// defaultSocket returns the default socket of the daemon, in a directory
+// only the current user can write to, so that no other user can answer the
+// decisions of the user's skippers.
+func defaultSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "skipper.sock")
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "skipper", "skipper.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("skipper-%d", os.Getuid()), "skipper.sock")
+}
+
+// listenUnix listens on the socket at path, replacing a stale socket file
+// left by a daemon that didn't exit cleanly. Its directory is created
+// accessible to the current user only, if missing.
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("a daemon is already listening on %v", path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return net.Listen("unix", path)
+}
+
+// serve answers requests until l is closed.
+func (d *daemon) serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			// The listener was closed.
+			return nil
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *daemon) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	req := &daemonRequest{}
+	resp := &daemonResponse{Graph: d.graph, Options: d.options}
+	if err := json.NewDecoder(conn).Decode(req); err != nil {
+		resp.Error = fmt.Sprintf("invalid request: %v", err)
+	} else if req.Graph == d.graph && req.Options == d.options {
+		resp = d.decide(req.Step, req.Changes, req.Env)
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// decide decides whether step must run given the changes and its
+// environment, if known, and records the decision in the metrics.
+func (d *daemon) decide(step, changes, env []string) *daemonResponse {
+	updated := map[string]bool{}
+	for _, f := range changes {
+		updated[f] = true
+	}
+	start := time.Now()
+	stale := d.staleReason()
+	s := &stepSkipper{updatedNodes: updated, depGraph: d.depGraph, alwaysRun: d.alwaysRun, network: d.network, tagger: d.tagger, env: env, envAllowlist: envAllowlist(), toolchains: d.toolchains, overrides: d.overrides, stale: stale, invalidateAll: d.invalidateAll}
+	run, reason, err := s.shouldRun(step)
+	resp := &daemonResponse{Graph: d.graph, Options: d.options, GraphDigest: d.digest, Run: run, Reason: reason, Duration: s.stepDuration(step), Build: s.stepBuildID(step)}
+	if err != nil {
+		resp.Error = err.Error()
+		resp.Unknown = errors.Is(err, stepselection.ErrUnknownStep)
+	}
+	overrideRun, _, overridden := stepselection.OverrideDecision(d.overrides, step)
+	invalidated := stale != "" || invalidatingChange(d.invalidateAll, updated) != ""
+	alwaysRun := overridden && overrideRun || !overridden && invalidated ||
+		stepselection.MatchAlwaysRun(d.alwaysRun, step, d.tagger) != nil ||
+		d.network.MustRun(d.depGraph, step, d.tagger) != nil
+	d.metrics.observe(run, fallbackReason(alwaysRun, err), time.Since(start))
+	return resp
+}
+
+// queryDaemon asks the daemon listening on --socket whether stepName should
+// run. It returns an error if there's no daemon or if the daemon serves a
+// different graph than --dep-graph, or decides with different options, in
+// which case the caller should decide by itself.
+func queryDaemon(stepName []string, changed map[string]bool) (*daemonResponse, error) {
+	if graphFileFlag == stdinName {
+		return nil, errors.New("the dependency graph is read from stdin")
+	}
+	graph, err := absGraphFiles(graphFileFlag)
+	if err != nil {
+		return nil, err
+	}
+	options, err := decisionOptions(graph)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSocketOwner(socketFlag); err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("unix", socketFlag, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	req := &daemonRequest{Graph: graph, Options: options, Step: stepName, Env: envAllowlist().Snapshot(os.Environ())}
+	for f := range changed {
+		req.Changes = append(req.Changes, f)
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	resp := &daemonResponse{}
+	if err := json.NewDecoder(conn).Decode(resp); err != nil {
+		return nil, err
+	}
+	if resp.Graph != graph {
+		return nil, fmt.Errorf("daemon serves graph %v, not %v", resp.Graph, graph)
+	}
+	if resp.Options != options {
+		return nil, errors.New("daemon decides with different options")
+	}
+	return resp, nil
+}
+
+// decisionOptions returns the digest of what decisions about the steps of
+// graph depend on besides the graph and the request: the config, the flags
+// that change how the graph is loaded, and the files loaded with it, like
+// overlays, coverage profiles and re-recorded steps. A daemon only decides
+// for the clients that would decide with the same.
+func decisionOptions(graph string) (string, error) {
+	workspace, err := workspaceRoot()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%v\n", viper.AllSettings())
+	fmt.Fprintf(h, "%q %v %q %q %q %v\n", workspace, toolchainChangedFlag, graphRootFlag, learnDir(), graphPublicKeyFlag, skipCorruptLines())
+	files := append(viper.GetStringSlice("overlays"), overlayFlag...)
+	var profiles []struct{ File string }
+	if err := viper.UnmarshalKey("coverage", &profiles); err != nil {
+		return "", fmt.Errorf("invalid coverage config: %v", err)
+	}
+	for _, p := range profiles {
+		files = append(files, p.File)
+	}
+	for _, arg := range coverageFlag {
+		if i := strings.LastIndex(arg, "="); i > 0 {
+			fmt.Fprintf(h, "%q\n", arg[:i])
+			files = append(files, arg[i+1:])
+		}
+	}
+	if dir := learnDir(); dir != "" {
+		reports, err := learnedReports(dir, graphModTime(graph))
+		if err != nil {
+			return "", err
+		}
+		files = append(files, reports...)
+	}
+	for _, f := range files {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			return "", err
+		}
+		if fi, err := os.Stat(abs); err == nil {
+			fmt.Fprintf(h, "%q %d %d\n", abs, fi.Size(), fi.ModTime().UnixNano())
+		} else {
+			fmt.Fprintf(h, "%q missing\n", abs)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+var (
+	daemonMetricsAddrFlag string
+	daemonHTTPAddrFlag    string
+)
+
+func init() {
+	daemonCmd.Flags().StringVar(&daemonMetricsAddrFlag, "metrics-addr", "", "address to serve Prometheus metrics on, like localhost:9464. Empty disables metrics")
+	daemonCmd.Flags().StringVar(&daemonHTTPAddrFlag, "http-addr", "", "address to serve the HTTP JSON API on, like :8080. Empty disables it")
+	rootCmd.AddCommand(daemonCmd)
+}
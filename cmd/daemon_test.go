@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func TestDaemon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skipper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	report := `{"CmdTree":["make"],"Mode":"R","File":"/src/a.c"}`
+	g, err := stepselection.NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSocket, oldGraph := socketFlag, graphFileFlag
+	defer func() { socketFlag, graphFileFlag = oldSocket, oldGraph }()
+	socketFlag = filepath.Join(dir, "skipper.sock")
+	graphFileFlag = "/base-graph.gz"
+
+	l, err := listenUnix(socketFlag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	options, err := decisionOptions(graphFileFlag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &daemon{graph: graphFileFlag, digest: "d1g35t", options: options, depGraph: g, metrics: newDaemonMetrics(g)}
+	go d.serve(l)
+
+	resp, err := queryDaemon([]string{"make"}, map[string]bool{"/src/a.c": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Run || resp.Error != "" || resp.GraphDigest != "d1g35t" {
+		t.Errorf("got %+v, wanted the step to run with the daemon's graph digest", resp)
+	}
+	resp, err = queryDaemon([]string{"make"}, map[string]bool{"/src/b.c": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Run {
+		t.Errorf("got %+v, wanted the step to be skipped", resp)
+	}
+
+	resp, err = queryDaemon([]string{"make", "cc"}, map[string]bool{"/src/b.c": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Run || resp.Error == "" || !resp.Unknown {
+		t.Errorf("got %+v, wanted an unknown step to run with an error", resp)
+	}
+
+	buf := new(strings.Builder)
+	d.metrics.write(buf)
+	for _, want := range []string{
+		`skipper_decisions_total{decision="run"} 2`,
+		`skipper_decisions_total{decision="skip"} 1`,
+		`skipper_fallback_runs_total{reason="unknown_step"} 1`,
+		`skipper_decision_duration_seconds_count 3`,
+		`skipper_graph_steps 1`,
+		`skipper_graph_files 1`,
+	} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("metrics don't have %q:\n%s", want, buf)
+		}
+	}
+
+	// The client would decide differently.
+	defer func(overlays []string) { overlayFlag = overlays }(overlayFlag)
+	toolchainChangedFlag = true
+	_, err = queryDaemon([]string{"make"}, nil)
+	toolchainChangedFlag = false
+	if err == nil {
+		t.Errorf("expected an error when the client keeps the toolchain reads")
+	}
+	overlayFlag = []string{filepath.Join(dir, "overlay.json")}
+	if _, err := queryDaemon([]string{"make"}, nil); err == nil {
+		t.Errorf("expected an error when the client has an overlay")
+	}
+	overlayFlag = nil
+	viper.Set("ignore", []string{"/src/gen/**"})
+	_, err = queryDaemon([]string{"make"}, nil)
+	viper.Set("ignore", nil)
+	if err == nil {
+		t.Errorf("expected an error when the client ignores other files")
+	}
+
+	graphFileFlag = "/other-graph.gz"
+	if _, err := queryDaemon([]string{"make"}, nil); err == nil {
+		t.Errorf("expected an error when the daemon serves a different graph")
+	}
+}
+
+func TestDaemonSocket(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	if got, want := defaultSocket(), filepath.Join(dir, "skipper.sock"); got != want {
+		t.Errorf("got socket %v, wanted %v", got, want)
+	}
+
+	socket := filepath.Join(dir, "sub", "skipper.sock")
+	l, err := listenUnix(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if fi, err := os.Stat(filepath.Dir(socket)); err != nil || fi.Mode().Perm() != 0700 {
+		t.Errorf("got socket directory %v, %v, wanted it private", fi, err)
+	}
+	if err := checkSocketOwner(socket); err != nil {
+		t.Errorf("the socket of the current user is refused: %v", err)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	// Owned by root, or by another user when running as root.
+	other := "/"
+	if os.Getuid() == 0 {
+		other = filepath.Join(dir, "other.sock")
+		if err := os.WriteFile(other, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chown(other, 12345, 12345); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := checkSocketOwner(other); err == nil {
+		t.Errorf("a file of another user is accepted")
+	}
+}
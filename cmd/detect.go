@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/yourbase/skipper/changes"
+)
+
+var (
+	detectSnapshotFlag string
+	detectSaveFlag     bool
+	detectOutputFlag   string
+)
+
+var detectChangesCmd = &cobra.Command{
+	Use:   "detect-changes",
+	Short: "Find the changed files by comparing them with a snapshot",
+	Long: `Finds the changes to the workspace without git or an external feed of
+changes. After the base build, "skipper detect-changes --save" records the
+size, modification time and inode of every file in --dep-graph, and the
+entries of the directories steps listed, in --snapshot. Later,
+"skipper detect-changes" compares the workspace with the snapshot and writes
+the files that changed, were created in listed directories or were deleted,
+one per line, ready for --changes:
+
+	skipper detect-changes -o changes.txt
+	skipper --changes changes.txt -- make
+
+Files touched without changing, like by a fresh checkout, are reported too,
+so the snapshot must be taken in the workspace the changes are detected in.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		snapshot := detectSnapshotFlag
+		if snapshot == "" {
+			graph, err := singleGraphFile()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v, or --snapshot must be given\n", err)
+				os.Exit(1)
+			}
+			snapshot = snapshotPath(graph)
+		}
+		if detectSaveFlag {
+			n, err := saveSnapshot(snapshot)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Could not save the snapshot: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "skipper: saved the state of %d files to %v\n", n, snapshot)
+			return
+		}
+		f, err := os.Open(snapshot)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not read the snapshot: %v\n", err)
+			os.Exit(1)
+		}
+		s, err := changes.ReadSnapshot(bufio.NewReader(f))
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid snapshot %v: %v\n", snapshot, err)
+			os.Exit(1)
+		}
+		if err := writeChanges(detectOutputFlag, s.Changed()); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write the changes: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// snapshotPath returns where the snapshot of the files of logFile is kept by
+// default: next to it, with a .snapshot extension.
+func snapshotPath(logFile string) string {
+	return strings.TrimSuffix(logFile, filepath.Ext(logFile)) + ".snapshot"
+}
+
+// saveSnapshot records the state of the files of the graph in path and
+// returns how many files it has.
+func saveSnapshot(path string) (int, error) {
+	opts, err := graphOptions()
+	if err != nil {
+		return 0, err
+	}
+	g, err := loadDependencyGraph(graphFileFlag, opts...)
+	if err != nil {
+		return 0, fmt.Errorf("could not load the base dependency graph: %v", err)
+	}
+	s := changes.TakeSnapshot(g.Files(), g.ListedDirs())
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+	if err := s.Write(f); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	return len(s.Files), os.Rename(tmp, path)
+}
+
+// writeChanges writes paths, one per line, to the file out, or to stdout if
+// out is "-".
+func writeChanges(out string, paths []string) error {
+	var w io.WriteCloser = os.Stdout
+	if out != "-" {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	for _, p := range paths {
+		fmt.Fprintln(bw, p)
+	}
+	err := bw.Flush()
+	if out != "-" {
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func init() {
+	detectChangesCmd.Flags().StringVar(&detectSnapshotFlag, "snapshot", "", "snapshot of the files of the graph (default is --dep-graph with a .snapshot extension)")
+	detectChangesCmd.Flags().BoolVar(&detectSaveFlag, "save", false, "save a new snapshot of the files of --dep-graph instead of detecting changes")
+	detectChangesCmd.Flags().StringVarP(&detectOutputFlag, "output", "o", "-", "file to write the changes to, \"-\" for stdout")
+	rootCmd.AddCommand(detectChangesCmd)
+}
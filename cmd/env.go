@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// envReportEnv is the environment variable through which "skipper record"
+// asks the skipper wrappers of the recorded build to report the environment
+// of their steps. It names a file they append entries of type "env" to.
+const envReportEnv = "SKIPPER_ENV_REPORT"
+
+// envAllowlist returns the environment variables recorded with each step
+// and compared when deciding, from the "env_allowlist" config key, or
+// stepselection.DefaultEnvAllowlist. An empty list disables the comparison.
+// Example config:
+//
+//	env_allowlist: [CC, GOFLAGS, "MYAPP_*"]
+func envAllowlist() stepselection.EnvAllowlist {
+	if viper.IsSet("env_allowlist") {
+		return viper.GetStringSlice("env_allowlist")
+	}
+	return stepselection.DefaultEnvAllowlist
+}
+
+// stepEnvEntries returns the entries recording that stepName runs with the
+// current environment.
+func stepEnvEntries(stepName []string) []*stepselection.BuildLog {
+	var entries []*stepselection.BuildLog
+	for _, e := range envAllowlist().Snapshot(os.Environ()) {
+		entries = append(entries, &stepselection.BuildLog{CmdTree: stepName, File: e, Type: "env"})
+	}
+	return entries
+}
+
+// reportStepEnv appends the environment of stepName to the file named by
+// envReportEnv, if it's set.
+func reportStepEnv(stepName []string) error {
+	path := os.Getenv(envReportEnv)
+	if path == "" {
+		return nil
+	}
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	for _, bog := range stepEnvEntries(stepName) {
+		if err := enc.Encode(bog); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	// A single write, so that the entries of steps running in parallel
+	// don't interleave.
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readEnvReport calls emit for each entry of the file written by
+// reportStepEnv.
+func readEnvReport(path string, emit func(*stepselection.BuildLog) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		bog := &stepselection.BuildLog{}
+		if err := json.Unmarshal([]byte(line), bog); err != nil {
+			return err
+		}
+		if err := emit(bog); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var explainCmd = &cobra.Command{
+	Use:   "explain -- <step args>",
+	Short: "Explain why a step would run or be skipped",
+	Long: `Loads the dependency graph and prints the full dependency chain from each
+changed file to the step, going through the steps that write the files it
+reads and the files those steps read.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		stepName, err := currentStepName(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not determine step name: %v\n", err)
+			os.Exit(1)
+		}
+		opts, err := graphOptions()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		changed, err := changedNodes()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not determine the changed files: %v\n", err)
+			os.Exit(1)
+		}
+		g, err := loadDependencyGraph(graphFileFlag, opts...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not load the base dependency graph: %v\n", err)
+			os.Exit(1)
+		}
+		g.SetFuzzyMatch(fuzzyMatch())
+		var files []string
+		for f := range changed {
+			files = append(files, f)
+		}
+		chains, err := g.DependencyChains(stepName, files)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if len(chains) == 0 {
+			fmt.Printf("step %q does not depend on any of the %d changed files and would be skipped\n", stepName, len(files))
+			return
+		}
+		fmt.Printf("step %q would run because it depends on %d changed files:\n", stepName, len(chains))
+		for _, c := range chains {
+			fmt.Println(" ", c)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(explainCmd)
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/yourbase/skipper/gitlab"
+)
+
+var (
+	graphFetchOutputFlag string
+	graphFetchRefFlag    string
+)
+
+var graphFetchCmd = &cobra.Command{
+	Use:   "fetch",
+	Short: "Download the base build report from GitLab CI",
+	Long: `Downloads the build report saved as an artifact by the latest successful
+pipeline of the base branch, using the GitLab API. It's configured by the
+"gitlab" config key:
+
+	gitlab:
+	  job: build
+	  artifact: .skipper/base-graph.gz
+	  project: group/project
+	  ref: main
+	  url: https://gitlab.example.com/api/v4
+
+Only the job and the path of the artifact in its archive are required. In
+GitLab CI jobs, the project and API URL default to the ones of the pipeline
+and the ref to the target branch of merge requests or else the default
+branch. --ref overrides the ref.
+
+The token is taken from GITLAB_TOKEN, an access token with the read_api
+scope, or else from CI_JOB_TOKEN. The report is written to -o, by default
+--dep-graph. If no pipeline saved the artifact yet, nothing is written and
+steps run as they do without a base graph.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		out := graphFetchOutputFlag
+		if out == "" {
+			var err error
+			if out, err = singleGraphFile(); err != nil {
+				fmt.Fprintf(os.Stderr, "%v, or -o must be given\n", err)
+				os.Exit(1)
+			}
+		}
+		src := &gitlab.Source{
+			APIURL:   viper.GetString("gitlab.url"),
+			Project:  viper.GetString("gitlab.project"),
+			Ref:      viper.GetString("gitlab.ref"),
+			Job:      viper.GetString("gitlab.job"),
+			Artifact: viper.GetString("gitlab.artifact"),
+		}
+		if graphFetchRefFlag != "" {
+			src.Ref = graphFetchRefFlag
+		}
+		src.FromEnv()
+		token, job := os.Getenv("GITLAB_TOKEN"), false
+		if token == "" {
+			token, job = os.Getenv("CI_JOB_TOKEN"), true
+		}
+		n, err := fetchGraph(src, token, job, out)
+		if errors.Is(err, gitlab.ErrNotFound) {
+			logger.Warn("no base graph to fetch", "err", err)
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not fetch the base graph: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("skipper: fetched %d bytes from job %q on %v to %v\n", n, src.Job, src.Ref, out)
+	},
+}
+
+// fetchGraph downloads the artifact of src to path, replacing it only once
+// the download is complete.
+func fetchGraph(src *gitlab.Source, token string, job bool, path string) (int64, error) {
+	rc, err := gitlab.Fetch(src, token, job)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+	n, err := io.Copy(f, rc)
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmp, path)
+}
+
+func init() {
+	graphFetchCmd.Flags().StringVarP(&graphFetchOutputFlag, "output", "o", "", "where to write the build report (default is --dep-graph)")
+	graphFetchCmd.Flags().StringVar(&graphFetchRefFlag, "ref", "", "branch or tag whose latest successful pipeline has the report (default is the \"gitlab.ref\" config key)")
+	graphCmd.AddCommand(graphFetchCmd)
+}
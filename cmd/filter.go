@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+var filterCmd = &cobra.Command{
+	Use:   "filter",
+	Short: "Print the steps read from stdin that must run",
+	Long: `Reads candidate steps from stdin, one command per line as it would be given
+to "skipper --", and prints back the ones that must run, in the same order.
+The graph is loaded and walked once for all the steps, which is much faster
+than running skipper for each of them. For example:
+
+	skipper filter < steps.txt | while read step; do $step; done
+
+Steps that aren't in the graph, match an always-run rule or, with --tags,
+have none of the tags are always printed. If the graph can't be loaded, is
+too stale with "stale_graph: run", or a file of the "invalidate_all" config
+key changed, every step is printed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var candidates []string
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				candidates = append(candidates, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not read the steps: %v\n", err)
+			os.Exit(1)
+		}
+		keep, err := filterSteps(candidates)
+		if err != nil {
+			logger.Warn("keeping every step", "err", err)
+			keep = candidates
+		}
+		w := bufio.NewWriter(os.Stdout)
+		for _, c := range keep {
+			fmt.Fprintln(w, c)
+		}
+		if err := w.Flush(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// filterSteps returns the candidates that must run.
+func filterSteps(candidates []string) ([]string, error) {
+	changed, err := changedNodes()
+	if err != nil {
+		return nil, fmt.Errorf("could not determine the changed files: %v", err)
+	}
+	opts, err := graphOptions()
+	if err != nil {
+		return nil, err
+	}
+	g, err := loadDependencyGraph(graphFileFlag, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not load the base dependency graph: %v", err)
+	}
+	g.SetFuzzyMatch(fuzzyMatch())
+	skipCheck, err := graphSkipper(g, changed)
+	if err != nil {
+		return nil, err
+	}
+	tagger := skipCheck.tagger
+	if len(tagsFlag) > 0 && tagger == nil {
+		if tagger, err = stepTagger(); err != nil {
+			return nil, fmt.Errorf("could not load step tags: %v", err)
+		}
+	}
+	var files []string
+	for f := range changed {
+		files = append(files, f)
+	}
+	affected := map[string]bool{}
+	for _, name := range g.StepsAffectedBy(files) {
+		affected[name] = true
+	}
+
+	var keep []string
+	for _, c := range candidates {
+		stepName, err := currentStepName([]string{c})
+		if err != nil {
+			return nil, err
+		}
+		cmdTree := stepselection.CmdTree(stepName)
+		if run, reason, ok := skipCheck.policyDecision(cmdTree); ok {
+			if run {
+				keep = append(keep, c)
+			} else {
+				logger.Debug("filtered out", "step", cmdTree.Name(), "reason", reason)
+			}
+			continue
+		}
+		switch {
+		case len(tagsFlag) > 0 && !tagger.HasAnyTag(stepName, tagsFlag),
+			!g.HasStep(cmdTree),
+			affected[cmdTree.Name()]:
+			keep = append(keep, c)
+		default:
+			logger.Debug("filtered out", "step", cmdTree.Name())
+		}
+	}
+	return keep, nil
+}
+
+func init() {
+	rootCmd.AddCommand(filterCmd)
+}
@@ -0,0 +1,549 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/yourbase/skipper/builddata"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Inspect the dependency graph",
+}
+
+var (
+	graphExportFormatFlag string
+	graphExportOutputFlag string
+)
+
+var graphExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the dependency graph for visualization",
+	Long: `Serializes the dependency graph loaded from --dep-graph. Steps are nodes
+connected to the files they read and write. The only supported format is
+Graphviz DOT, which can be rendered with e.g. "dot -Tsvg".`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if graphExportFormatFlag != "dot" {
+			fmt.Fprintf(os.Stderr, "Unsupported export format %q\n", graphExportFormatFlag)
+			os.Exit(1)
+		}
+		opts, err := graphOptions()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		g, err := loadDependencyGraph(graphFileFlag, opts...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not load the dependency graph: %v\n", err)
+			os.Exit(1)
+		}
+		var w io.Writer = os.Stdout
+		if graphExportOutputFlag != "" {
+			f, err := os.Create(graphExportOutputFlag)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := g.WriteDOT(w); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not export the dependency graph: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var (
+	graphStatsTopFlag  int
+	graphStatsJSONFlag bool
+)
+
+var graphStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Print the size and shape of the dependency graph",
+	Long: `Prints the number of steps, files and edges of the dependency graph loaded
+from --dep-graph, the files read and written by the most steps, the deepest
+chains of steps depending on each other and an estimate of the memory the
+graph takes. Files read by most steps, or very deep chains, usually mean the
+build report is too noisy for steps to be skipped, and many steps reading no
+files that it's too sparse to be trusted.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		opts, err := graphOptions()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		g, err := loadDependencyGraph(graphFileFlag, opts...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not load the dependency graph: %v\n", err)
+			os.Exit(1)
+		}
+		st := g.Stats(graphStatsTopFlag)
+		if graphStatsJSONFlag {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.SetEscapeHTML(false)
+			enc.Encode(st)
+			return
+		}
+		writeGraphStats(os.Stdout, st)
+	},
+}
+
+// writeGraphStats prints st for humans.
+func writeGraphStats(w io.Writer, st *stepselection.GraphStats) {
+	fmt.Fprintf(w, "steps: %d, %d reading no files\n", st.Steps, st.NoReads)
+	fmt.Fprintf(w, "files: %d, and %d listed directories\n", st.Files, st.ListedDirs)
+	fmt.Fprintf(w, "edges: %d reads, %d writes, %d listings\n", st.Reads, st.Writes, st.Lists)
+	fmt.Fprintf(w, "estimated memory: %v\n", formatBytes(st.Memory))
+	if len(st.MostRead) > 0 {
+		fmt.Fprintln(w, "most read files:")
+		for _, fc := range st.MostRead {
+			fmt.Fprintf(w, "  %v: %d steps\n", fc.File, fc.Steps)
+		}
+	}
+	if len(st.MostWritten) > 0 {
+		fmt.Fprintln(w, "most written files:")
+		for _, fc := range st.MostWritten {
+			fmt.Fprintf(w, "  %v: %d steps\n", fc.File, fc.Steps)
+		}
+	}
+	if len(st.DeepestChains) > 0 {
+		fmt.Fprintln(w, "deepest dependency chains:")
+		for _, c := range st.DeepestChains {
+			fmt.Fprintf(w, "  %d steps: %v\n", len(c), c)
+		}
+	}
+}
+
+// formatBytes formats n bytes in the largest binary unit under n.
+func formatBytes(n int64) string {
+	const units = "KMGT"
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	v, i := float64(n)/1024, 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", v, units[i])
+}
+
+var graphConvertOutputFlag string
+
+var graphConvertCmd = &cobra.Command{
+	Use:   "convert -o <graph.db>",
+	Short: "Store the dependency graph in a SQLite database",
+	Long: `Converts the build report given with --dep-graph, with its overlays
+applied, into a SQLite database. Passing the database as --dep-graph makes
+skipper query it instead of loading the whole graph in memory, which is faster
+for very large builds. The sqlite3 command must be installed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !isSQLiteGraph(graphConvertOutputFlag) {
+			fmt.Fprintln(os.Stderr, "The output file name must end in .db")
+			os.Exit(1)
+		}
+		opts, err := graphOptions()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		buildReport, err := openBuildReports(graphFileFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not open the build report: %v\n", err)
+			os.Exit(1)
+		}
+		defer buildReport.Close()
+		if err := stepselection.WriteSQLiteGraph(graphConvertOutputFlag, buildReport, opts...); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not convert the dependency graph: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var (
+	graphMergeOutputFlag  string
+	graphMergeLearnedFlag bool
+)
+
+var graphMergeCmd = &cobra.Command{
+	Use:   "merge <build report>...",
+	Short: "Fold new build reports into the base dependency graph",
+	Long: `Loads the base graph from --dep-graph, merges the given build reports
+into it and writes the result, by default replacing --dep-graph. Steps in the
+new reports replace their previous edges, so recording only the steps that
+changed keeps the base graph fresh. Overlays are not applied, since they're
+applied whenever the merged graph is loaded.
+
+With --learned, the steps re-recorded in --learn-dir since the base graph was
+written are merged too, and the re-recorded steps are removed once merged.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 && !graphMergeLearnedFlag {
+			fmt.Fprintln(os.Stderr, "Build reports to merge, or --learned, must be given")
+			os.Exit(1)
+		}
+		dir := learnDir()
+		if graphMergeLearnedFlag && dir == "" {
+			fmt.Fprintln(os.Stderr, "--learned needs --learn-dir or the \"learn_dir\" config key")
+			os.Exit(1)
+		}
+		out := graphMergeOutputFlag
+		if out == "" {
+			var err error
+			if out, err = singleGraphFile(); err != nil {
+				fmt.Fprintf(os.Stderr, "%v, or -o must be given\n", err)
+				os.Exit(1)
+			}
+		}
+		if isSQLiteGraph(out) || filepath.Ext(out) == ".bin" {
+			fmt.Fprintln(os.Stderr, "The merged graph can only be written as a build report")
+			os.Exit(1)
+		}
+		g, err := loadDependencyGraph(graphFileFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not load the base dependency graph: %v\n", err)
+			os.Exit(1)
+		}
+		reports := args
+		if graphMergeLearnedFlag {
+			learned, err := learnedReports(dir, graphModTime(graphFileFlag))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Could not list the re-recorded steps: %v\n", err)
+				os.Exit(1)
+			}
+			reports = append(reports, learned...)
+		}
+		for _, file := range reports {
+			if err := mergeReport(g, file); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not merge %v: %v\n", file, err)
+				os.Exit(1)
+			}
+		}
+		g.SetReportMetadata(baseReportMetadata())
+		if err := writeReportFile(out, func(w *builddata.Writer) error { return g.WriteReport(w) }); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write the merged graph: %v\n", err)
+			os.Exit(1)
+		}
+		if graphMergeLearnedFlag {
+			// The older ones were recorded before the base graph, which
+			// supersedes them.
+			if err := removeLearnedReports(dir); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not remove the merged re-recorded steps: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		fmt.Printf("skipper: merged %d build reports into %v\n", len(reports), out)
+	},
+}
+
+// baseReportMetadata returns the header of the build report of the base
+// graph, whose creation time and commit the graphs derived from it keep, or
+// nil if the base graph isn't a single build report.
+func baseReportMetadata() *stepselection.ReportHeader {
+	files, err := graphFiles(graphFileFlag)
+	if err != nil || len(files) != 1 || files[0] == stdinName || isSQLiteGraph(files[0]) || filepath.Ext(files[0]) == ".bin" {
+		return nil
+	}
+	return readReportMetadata(files[0])
+}
+
+func mergeReport(g *stepselection.DependencyGraph, file string) error {
+	r, err := openBuildReport(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return g.Merge(r)
+}
+
+// writeReportFile replaces the build report in path with the output of
+// write, compressed according to its extension, see builddata.CreateFile.
+func writeReportFile(path string, write func(*builddata.Writer) error) error {
+	w, err := builddata.CreateFile(path)
+	if err != nil {
+		return err
+	}
+	defer w.Discard()
+	if err := write(w); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+var (
+	graphPruneKeepFlag   int
+	graphPruneOutputFlag string
+)
+
+var graphPruneCmd = &cobra.Command{
+	Use:   "prune --keep-builds <n>",
+	Short: "Remove steps that no recent build recorded",
+	Long: `Loads the base graph from --dep-graph and removes the steps that weren't
+recorded by any of the last --keep-builds builds, along with the files only
+they used. Builds are identified by the build IDs that "skipper record" adds to
+every entry. Entries without one are older than every build.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		out := graphPruneOutputFlag
+		if out == "" {
+			var err error
+			if out, err = singleGraphFile(); err != nil {
+				fmt.Fprintf(os.Stderr, "%v, or -o must be given\n", err)
+				os.Exit(1)
+			}
+		}
+		if isSQLiteGraph(out) || filepath.Ext(out) == ".bin" {
+			fmt.Fprintln(os.Stderr, "The pruned graph can only be written as a build report")
+			os.Exit(1)
+		}
+		g, err := loadDependencyGraph(graphFileFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not load the base dependency graph: %v\n", err)
+			os.Exit(1)
+		}
+		n, err := g.Prune(graphPruneKeepFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not prune the graph: %v\n", err)
+			os.Exit(1)
+		}
+		g.SetReportMetadata(baseReportMetadata())
+		if err := writeReportFile(out, func(w *builddata.Writer) error { return g.WriteReport(w) }); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write the pruned graph: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("skipper: pruned %d steps, wrote %v\n", n, out)
+	},
+}
+
+var graphUpgradeOutputFlag string
+
+var graphUpgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Rewrite the build report in the current format",
+	Long: `Reads the build report in --dep-graph, written by any version of skipper
+this one supports, and writes it in the current format, by default replacing
+--dep-graph. Entries that don't fit the format are reported instead of being
+misread. Signed reports must be signed again afterwards, and compiled graphs
+are upgraded by running compile-graph again.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		in, err := singleGraphFile()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if isSQLiteGraph(in) || filepath.Ext(in) == ".bin" {
+			fmt.Fprintln(os.Stderr, "Only build reports can be upgraded. Run compile-graph or graph convert again instead")
+			os.Exit(1)
+		}
+		out := graphUpgradeOutputFlag
+		if out == "" {
+			out = in
+		}
+		r, err := builddata.OpenFile(in)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not open the build report: %v\n", err)
+			os.Exit(1)
+		}
+		defer r.Close()
+		var from int
+		err = writeReportFile(out, func(w *builddata.Writer) error {
+			from, err = stepselection.UpgradeReport(w, r)
+			return err
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not upgrade %v: %v\n", in, err)
+			os.Exit(1)
+		}
+		fmt.Printf("skipper: upgraded %v from version %d to %d, wrote %v\n", in, from, stepselection.ReportVersion, out)
+	},
+}
+
+var graphLintJSONFlag bool
+
+var graphLintCmd = &cobra.Command{
+	Use:   "lint [build report]...",
+	Short: "Check the build report for problems",
+	Long: `Checks the build report in --dep-graph for entries and steps that are likely
+to make skipper decide wrong: entries that don't parse, relative paths, steps
+that read no files, steps that read files they write, files written by many
+unrelated steps and suspiciously broad reads, like listing the root directory.
+
+Build reports recorded by other runs of the same build, without changes in
+between, can be given as arguments. Steps that read or wrote different files
+in some of the runs, like steps reading timestamps, caches or temporary files
+with random names, are reported as nondeterministic.
+
+With --json, findings are printed as one JSON object per line. Exits with
+status 1 if there are any findings.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, err := singleGraphFile()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if isSQLiteGraph(file) || filepath.Ext(file) == ".bin" {
+			fmt.Fprintln(os.Stderr, "Only build reports can be linted")
+			os.Exit(1)
+		}
+		if file == stdinName && len(args) > 0 {
+			fmt.Fprintln(os.Stderr, "Build reports can't be compared with a build report read from stdin")
+			os.Exit(1)
+		}
+		buildReport, err := openBuildReport(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not open the build report: %v\n", err)
+			os.Exit(1)
+		}
+		findings, err := stepselection.Lint(buildReport, stepselection.DefaultLintOptions)
+		buildReport.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not read the build report: %v\n", err)
+			os.Exit(1)
+		}
+		if len(args) > 0 {
+			nondeterministic, err := lintNondeterminism(append([]string{file}, args...))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			findings = append(findings, nondeterministic...)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		for _, f := range findings {
+			if graphLintJSONFlag {
+				enc.Encode(f)
+			} else {
+				fmt.Println(f)
+			}
+		}
+		if len(findings) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// lintNondeterminism compares the build reports in files, see
+// stepselection.LintNondeterminism.
+func lintNondeterminism(files []string) ([]stepselection.Finding, error) {
+	var readers []io.Reader
+	for _, file := range files {
+		r, err := builddata.OpenFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not open the build report: %v", err)
+		}
+		defer r.Close()
+		readers = append(readers, r)
+	}
+	return stepselection.LintNondeterminism(readers)
+}
+
+var (
+	graphQueryReadsOfFlag   string
+	graphQueryWritersOfFlag string
+	graphQueryReadersOfFlag string
+)
+
+var graphQueryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "Query the edges of the dependency graph",
+	Long: `Prints the files read by a step, with --reads-of, or the steps that write
+or read a file, with --writers-of and --readers-of, one per line. Steps are
+given either as a command, like "go test ./...", or as the step name printed
+by skipper, like ["make","go test ./..."]. Steps inherit the edges of the
+steps nested in them.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		set := 0
+		for _, f := range []string{graphQueryReadsOfFlag, graphQueryWritersOfFlag, graphQueryReadersOfFlag} {
+			if f != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			fmt.Fprintln(os.Stderr, "Exactly one of --reads-of, --writers-of and --readers-of must be given")
+			os.Exit(1)
+		}
+		opts, err := graphOptions()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		g, err := loadDependencyGraph(graphFileFlag, opts...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not load the dependency graph: %v\n", err)
+			os.Exit(1)
+		}
+		var lines []string
+		switch {
+		case graphQueryReadsOfFlag != "":
+			cmdTree, err := parseStepArg(graphQueryReadsOfFlag)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			if lines, err = g.StepReads(cmdTree); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		case graphQueryWritersOfFlag != "":
+			lines = g.FileWriters(graphQueryWritersOfFlag)
+		case graphQueryReadersOfFlag != "":
+			lines = g.FileReaders(graphQueryReadersOfFlag)
+		}
+		for _, l := range lines {
+			fmt.Println(l)
+		}
+	},
+}
+
+// parseStepArg parses a step given on the command line, either as a step name
+// or as the command of the step.
+func parseStepArg(arg string) (stepselection.CmdTree, error) {
+	var cmdTree stepselection.CmdTree
+	if strings.HasPrefix(arg, "[") {
+		if err := json.Unmarshal([]byte(arg), &cmdTree); err != nil {
+			return nil, fmt.Errorf("invalid step name %q: %v", arg, err)
+		}
+		return cmdTree, nil
+	}
+	return currentStepName([]string{arg})
+}
+
+func init() {
+	graphLintCmd.Flags().BoolVar(&graphLintJSONFlag, "json", false, "print findings as JSON")
+	graphCmd.AddCommand(graphLintCmd)
+	graphQueryCmd.Flags().StringVar(&graphQueryReadsOfFlag, "reads-of", "", "print the files read by this step")
+	graphQueryCmd.Flags().StringVar(&graphQueryWritersOfFlag, "writers-of", "", "print the steps that write this file")
+	graphQueryCmd.Flags().StringVar(&graphQueryReadersOfFlag, "readers-of", "", "print the steps that read this file")
+	graphCmd.AddCommand(graphQueryCmd)
+	graphUpgradeCmd.Flags().StringVarP(&graphUpgradeOutputFlag, "output", "o", "", "where to write the upgraded build report (default is --dep-graph)")
+	graphCmd.AddCommand(graphUpgradeCmd)
+	graphPruneCmd.Flags().IntVar(&graphPruneKeepFlag, "keep-builds", 10, "number of recent builds whose steps are kept")
+	graphPruneCmd.Flags().StringVarP(&graphPruneOutputFlag, "output", "o", "", "where to write the pruned build report (default is --dep-graph)")
+	graphCmd.AddCommand(graphPruneCmd)
+	graphMergeCmd.Flags().StringVarP(&graphMergeOutputFlag, "output", "o", "", "where to write the merged build report (default is --dep-graph)")
+	graphMergeCmd.Flags().BoolVar(&graphMergeLearnedFlag, "learned", false, "also merge the steps re-recorded in --learn-dir, and remove them")
+	graphCmd.AddCommand(graphMergeCmd)
+	graphConvertCmd.Flags().StringVarP(&graphConvertOutputFlag, "output", "o", "", "SQLite database to create")
+	graphCmd.AddCommand(graphConvertCmd)
+	graphExportCmd.Flags().StringVar(&graphExportFormatFlag, "format", "dot", "output format. Only \"dot\" is supported")
+	graphExportCmd.Flags().StringVarP(&graphExportOutputFlag, "output", "o", "", "file to write to instead of stdout")
+	graphCmd.AddCommand(graphExportCmd)
+	graphStatsCmd.Flags().IntVar(&graphStatsTopFlag, "top", 5, "number of files and chains of each kind to list")
+	graphStatsCmd.Flags().BoolVar(&graphStatsJSONFlag, "json", false, "print the stats as JSON")
+	graphCmd.AddCommand(graphStatsCmd)
+	rootCmd.AddCommand(graphCmd)
+}
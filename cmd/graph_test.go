@@ -0,0 +1,18 @@
+package cmd
+
+import "testing"
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:          "0 B",
+		1023:       "1023 B",
+		1536:       "1.5 KiB",
+		3 << 20:    "3.0 MiB",
+		5 << 30:    "5.0 GiB",
+		2048 << 40: "2048.0 TiB",
+	} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q wanted %q", n, got, want)
+		}
+	}
+}
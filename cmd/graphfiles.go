@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// graphFilesValue is the value of --dep-graph. The flag can be repeated, and
+// each value can be a comma-separated list, for builds whose trace is split
+// across shards or containers. The files are kept comma-separated in
+// graphFileFlag, so that a single file is a plain path.
+type graphFilesValue struct {
+	p   *string
+	set bool
+}
+
+func (v *graphFilesValue) Set(s string) error {
+	if v.set {
+		*v.p += "," + s
+	} else {
+		*v.p = s
+		v.set = true
+	}
+	return nil
+}
+
+func (v *graphFilesValue) String() string {
+	return *v.p
+}
+
+func (v *graphFilesValue) Type() string {
+	return "files"
+}
+
+// graphFiles returns the build reports in flag, a comma-separated list of
+// files and directories. Directories hold a report per file, except for
+// hidden files, compiled graphs, signatures and temporary files.
+func graphFiles(flag string) ([]string, error) {
+	var files []string
+	for _, f := range strings.Split(flag, ",") {
+		if f == "" {
+			continue
+		}
+		fi, err := os.Stat(f)
+		if err != nil || !fi.IsDir() {
+			// Missing files are reported when they're opened.
+			files = append(files, f)
+			continue
+		}
+		entries, err := ioutil.ReadDir(f)
+		if err != nil {
+			return nil, err
+		}
+		var dirFiles []string
+		for _, e := range entries {
+			name := e.Name()
+			if !e.Mode().IsRegular() || strings.HasPrefix(name, ".") || filepath.Ext(name) == ".bin" || filepath.Ext(name) == ".tmp" || filepath.Ext(name) == ".sig" {
+				continue
+			}
+			dirFiles = append(dirFiles, filepath.Join(f, name))
+		}
+		sort.Strings(dirFiles)
+		files = append(files, dirFiles...)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no build reports in --dep-graph")
+	}
+	return files, nil
+}
+
+// isMultiGraph returns whether logFile names several build reports.
+func isMultiGraph(logFile string) bool {
+	files, err := graphFiles(logFile)
+	return err != nil || len(files) != 1 || files[0] != logFile
+}
+
+// singleGraphFile returns --dep-graph for commands that need a single file.
+func singleGraphFile() (string, error) {
+	if isMultiGraph(graphFileFlag) {
+		return "", errors.New("--dep-graph must be a single file for this command")
+	}
+	return graphFileFlag, nil
+}
+
+// openBuildReports opens the build reports in logFile as a single report,
+// so that the steps of all of them end up in the same graph.
+func openBuildReports(logFile string) (io.ReadCloser, error) {
+	files, err := graphFiles(logFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 1 {
+		return openBuildReport(files[0])
+	}
+	r := &multiReport{}
+	var readers []io.Reader
+	for _, f := range files {
+		if isSQLiteGraph(f) || filepath.Ext(f) == ".bin" {
+			r.Close()
+			return nil, errors.New("several --dep-graph files must all be build reports")
+		}
+		br, err := openBuildReport(f)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.files = append(r.files, br)
+		// Reports end with a new line, but be safe with ones that don't:
+		// empty lines are skipped.
+		readers = append(readers, br, strings.NewReader("\n"))
+	}
+	r.Reader = io.MultiReader(readers...)
+	return r, nil
+}
+
+// multiReport reads several build reports one after the other.
+type multiReport struct {
+	io.Reader
+	files []io.ReadCloser
+}
+
+func (r *multiReport) Close() error {
+	var err error
+	for _, f := range r.files {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// absGraphFiles returns logFile with absolute paths, to identify the graph
+// regardless of the working directory.
+func absGraphFiles(logFile string) (string, error) {
+	var abs []string
+	for _, f := range strings.Split(logFile, ",") {
+		a, err := filepath.Abs(f)
+		if err != nil {
+			return "", err
+		}
+		abs = append(abs, a)
+	}
+	return strings.Join(abs, ","), nil
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/pflag"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func TestGraphFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graphfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	shards := filepath.Join(dir, "shards")
+	if err := os.Mkdir(shards, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(p, content string) {
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The second shard doesn't end with a new line.
+	write(filepath.Join(shards, "b.json"), `{"CmdTree":["make","b"],"Mode":"R","File":"/src/b.c"}`)
+	write(filepath.Join(shards, "a.json"), `{"CmdTree":["make","a"],"Mode":"R","File":"/src/a.c"}`+"\n")
+	write(filepath.Join(shards, "a.bin"), "compiled")
+	write(filepath.Join(shards, ".hidden"), "")
+	other := filepath.Join(dir, "c.json")
+	write(other, `{"CmdTree":["make"],"Mode":"R","File":"/src/c.c"}`+"\n")
+
+	var flag string
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.Var(&graphFilesValue{p: &flag}, "dep-graph", "")
+	flag = "default.gz"
+	if err := fs.Parse([]string{"--dep-graph", shards, "--dep-graph", other + ",missing.json"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := graphFiles(flag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(shards, "a.json"), filepath.Join(shards, "b.json"), other, "missing.json"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("graphFiles (-want +got)\n%s", diff)
+	}
+	if !isMultiGraph(flag) || isMultiGraph(other) {
+		t.Errorf("isMultiGraph is wrong")
+	}
+
+	g, err := loadDependencyGraph(shards + "," + other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []stepselection.CmdTree{{"make", "a"}, {"make", "b"}} {
+		if !g.HasStep(s) {
+			t.Errorf("step %v is missing", s.Name())
+		}
+	}
+	// make reads the files of both shards, and its own.
+	reads, err := g.StepReads(stepselection.CmdTree{"make"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"/src/a.c", "/src/b.c", "/src/c.c"}, reads); diff != "" {
+		t.Errorf("reads of make (-want +got)\n%s", diff)
+	}
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+var graphFormatFlag string
+
+// graphFormat returns the format of the build report in file, whose start is
+// buffered in r: json for regular build reports, or csv and tsv for tables of
+// StepName,FilePath,Mode records, see stepselection.ConvertCSVReport.
+// Unless --graph-format says otherwise, tables are detected by the .csv and
+// .tsv extensions, before any compression extension, or by their first line.
+func graphFormat(file string, r *bufio.Reader) (string, error) {
+	switch graphFormatFlag {
+	case "json", "csv", "tsv":
+		return graphFormatFlag, nil
+	case "auto":
+	default:
+		return "", fmt.Errorf("invalid --graph-format %q, must be auto, json, csv or tsv", graphFormatFlag)
+	}
+	name := file
+	for _, ext := range []string{".gz", ".zst", ".xz"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	switch filepath.Ext(name) {
+	case ".csv":
+		return "csv", nil
+	case ".tsv":
+		return "tsv", nil
+	}
+	// Peek fills the buffer.
+	r.Peek(1)
+	buf, _ := r.Peek(r.Buffered())
+	for len(buf) > 0 {
+		line := buf
+		if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+			line, buf = buf[:i], buf[i+1:]
+		} else {
+			buf = nil
+		}
+		line = bytes.TrimSpace(line)
+		switch {
+		case len(line) == 0 || line[0] == '#':
+			continue
+		case line[0] == '{':
+			return "json", nil
+		case bytes.IndexByte(line, '\t') >= 0:
+			return "tsv", nil
+		case bytes.IndexByte(line, ',') >= 0:
+			return "csv", nil
+		default:
+			// Only the first line that isn't a comment tells.
+			return "json", nil
+		}
+	}
+	return "json", nil
+}
+
+// convertGraphFormat returns the build report of the table in rc, if file is
+// a table rather than a build report, see graphFormat.
+func convertGraphFormat(file string, rc io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(rc)
+	format, err := graphFormat(file, br)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	if format == "json" {
+		return &graphReader{Reader: br, Closer: rc}, nil
+	}
+	defer rc.Close()
+	comma := ','
+	if format == "tsv" {
+		comma = '\t'
+	}
+	// Tables are small, written by hand or by scripts.
+	report := new(bytes.Buffer)
+	enc := json.NewEncoder(report)
+	enc.SetEscapeHTML(false)
+	if err := stepselection.ConvertCSVReport(enc, br, comma); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(report), nil
+}
+
+// graphReader reads a build report and closes the file it comes from.
+type graphReader struct {
+	io.Reader
+	io.Closer
+}
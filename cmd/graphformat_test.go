@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"bufio"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func TestGraphFormat(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	gz := filepath.Join(dir, "graph.tsv.gz")
+	f, err := os.Create(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	zw.Write([]byte("cc\t/src/c.c\tR\n"))
+	zw.Close()
+	f.Close()
+	graphs := []string{
+		write("graph.json", `{"CmdTree":["cc"],"Mode":"R","File":"/src/c.c"}`+"\n"),
+		// Detected by its first line.
+		write("graph", "\n# Written by hand.\ncc,/src/c.c,R\n"),
+		write("graph.csv", "StepName,FilePath,Mode\ncc,/src/c.c,R\n"),
+		gz,
+	}
+	for _, file := range graphs {
+		g, err := loadDependencyGraph(file)
+		if err != nil {
+			t.Errorf("loading %v: %v", file, err)
+			continue
+		}
+		deps, _, err := g.StepDependsOnFiles(stepselection.CmdTree{"cc"}, []string{"/src/c.c"})
+		if err != nil || !deps {
+			t.Errorf("%v: got %v, %v, wanted cc to depend on /src/c.c", file, deps, err)
+		}
+	}
+
+	defer func(old string) { graphFormatFlag = old }(graphFormatFlag)
+	graphFormatFlag = "json"
+	if _, err := loadDependencyGraph(graphs[2]); err == nil {
+		t.Errorf("loading a table with --graph-format=json worked")
+	}
+	graphFormatFlag = "xml"
+	if _, err := loadDependencyGraph(graphs[0]); err == nil {
+		t.Errorf("loading with an invalid --graph-format worked")
+	}
+}
+
+func TestGraphFormatFirstLine(t *testing.T) {
+	defer func(old string) { graphFormatFlag = old }(graphFormatFlag)
+	graphFormatFlag = "auto"
+	for content, want := range map[string]string{
+		"# Written by hand.\ncc,/src/c.c,R\n": "csv",
+		"cc\t/src/c.c\tR\n":                   "tsv",
+		// Later lines don't tell.
+		"garbage\ncc,/src/c.c,R\n": "json",
+		"\n# comment\nx\ny\tz\n":   "json",
+	} {
+		got, err := graphFormat("graph", bufio.NewReader(strings.NewReader(content)))
+		if err != nil || got != want {
+			t.Errorf("graphFormat(%q) = %v, %v, wanted %v", content, got, err, want)
+		}
+	}
+}
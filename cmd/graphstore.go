@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yourbase/skipper/remotecache"
+)
+
+// storedReportName is the name of the build report in the directory of each
+// build of the graph store.
+const storedReportName = "report.gz"
+
+var baseIDFlag string
+
+// storedGraph is a graph of the graph store.
+type storedGraph struct {
+	ID       string
+	Recorded time.Time
+	Size     int64
+}
+
+// storedGraphFile returns the build report of the build id in the graph
+// store dir.
+func storedGraphFile(dir, id string) (string, error) {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return "", fmt.Errorf("invalid build ID %q", id)
+	}
+	return filepath.Join(dir, id, storedReportName), nil
+}
+
+// storedGraphs returns the graphs of the graph store dir, oldest first.
+func storedGraphs(dir string) ([]storedGraph, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var graphs []storedGraph
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(dir, e.Name(), storedReportName))
+		if err != nil {
+			// Being recorded, or not a graph.
+			continue
+		}
+		graphs = append(graphs, storedGraph{ID: e.Name(), Recorded: fi.ModTime(), Size: fi.Size()})
+	}
+	sort.SliceStable(graphs, func(i, j int) bool {
+		if !graphs[i].Recorded.Equal(graphs[j].Recorded) {
+			return graphs[i].Recorded.Before(graphs[j].Recorded)
+		}
+		return graphs[i].ID < graphs[j].ID
+	})
+	return graphs, nil
+}
+
+// resolveBaseGraph returns the build report of the base build id in the
+// graph store dir. "latest" is the most recently recorded one.
+func resolveBaseGraph(dir, id string) (string, error) {
+	if id == "latest" {
+		graphs, err := storedGraphs(dir)
+		if err != nil {
+			return "", err
+		}
+		if len(graphs) == 0 {
+			return "", fmt.Errorf("no graph in the graph store %v", dir)
+		}
+		id = graphs[len(graphs)-1].ID
+	}
+	return storedGraphFile(dir, id)
+}
+
+// storedGraphKey is the key of the action result listing the graph of the
+// build id, or the latest stored graph, in the remote cache.
+func storedGraphKey(id string) string {
+	return remotecache.Key("skipper graph\x00" + id)
+}
+
+// pushStoredGraph stores the graph of the build id of the graph store dir in
+// the remote cache c, as the latest one too.
+func pushStoredGraph(c *remotecache.Client, dir, id string) error {
+	file, err := storedGraphFile(dir, id)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	d, err := c.PutFile(f)
+	if err != nil {
+		return err
+	}
+	ar := &remotecache.ActionResult{OutputFiles: []remotecache.OutputFile{{Path: id + "/" + storedReportName, Digest: d}}}
+	if err := c.PutActionResult(storedGraphKey(id), ar); err != nil {
+		return err
+	}
+	return c.PutActionResult(storedGraphKey("latest"), ar)
+}
+
+// pullStoredGraph copies the graph of the build id, or the latest one, from
+// the remote cache c into the graph store dir, unless it's there already. It
+// returns the ID of the build, or id if the remote cache doesn't have it.
+func pullStoredGraph(c *remotecache.Client, dir, id string) (string, error) {
+	ar, err := c.GetActionResult(storedGraphKey(id))
+	if errors.Is(err, remotecache.ErrNotFound) {
+		return id, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(ar.OutputFiles) != 1 {
+		return "", fmt.Errorf("the remote cache has %d files for the graph of build %v", len(ar.OutputFiles), id)
+	}
+	out := ar.OutputFiles[0]
+	file, err := storedGraphFile(dir, path.Dir(out.Path))
+	if err != nil {
+		return "", err
+	}
+	storedID := filepath.Base(filepath.Dir(file))
+	if _, err := os.Stat(file); err == nil {
+		return storedID, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.Create(file + ".tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := c.GetBlob(out.Digest.Hash, tmp); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return storedID, os.Rename(tmp.Name(), file)
+}
+
+// selectBaseGraph points --dep-graph to the graph of the base build given by
+// --base-id or the "base_id" config key, if any, fetching it from the remote
+// cache if there's one. It runs once the config is read.
+func selectBaseGraph() {
+	id := baseID()
+	if id == "" {
+		return
+	}
+	if rootCmd.PersistentFlags().Changed("dep-graph") {
+		if baseIDFlag != "" {
+			fmt.Fprintln(os.Stderr, "Only one of --dep-graph and --base-id can be given")
+			os.Exit(1)
+		}
+		// The flag overrides the config.
+		return
+	}
+	dir, err := graphStoreDir()
+	if c := remoteCache(); c != nil && err == nil {
+		if id, err = pullStoredGraph(c, dir, id); err != nil {
+			err = fmt.Errorf("could not fetch it from the remote cache: %v", err)
+		}
+	}
+	if err == nil {
+		graphFileFlag, err = resolveBaseGraph(dir, id)
+	}
+	if err != nil {
+		// Like with a missing --dep-graph, steps run, and the graph
+		// store can still be managed.
+		logger.Warn("could not select the graph of the base build", "id", id, "err", err)
+		graphFileFlag = ""
+		return
+	}
+	logger.Debug("using the graph of the base build", "id", id, "file", graphFileFlag)
+}
+
+var graphListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the graphs of the graph store",
+	Long: `Lists the graphs stored by "skipper record --store", oldest first, with the
+ID of the build that recorded them, which --base-id selects.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, err := graphStoreDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not find the graph store: %v\n", err)
+			os.Exit(1)
+		}
+		graphs, err := storedGraphs(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not list the graph store: %v\n", err)
+			os.Exit(1)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tRECORDED\tSIZE")
+		for _, g := range graphs {
+			fmt.Fprintf(tw, "%v\t%v\t%v\n", g.ID, g.Recorded.Format(time.RFC3339), formatBytes(g.Size))
+		}
+		tw.Flush()
+	},
+}
+
+var graphRmCmd = &cobra.Command{
+	Use:   "rm <build ID>...",
+	Short: "Remove graphs from the graph store",
+	Long: `Removes the graphs of the given builds from the graph store, along with the
+files stored next to them, like compiled graphs and signatures.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, err := graphStoreDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not find the graph store: %v\n", err)
+			os.Exit(1)
+		}
+		for _, id := range args {
+			if err := removeStoredGraph(dir, id); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not remove the graph of build %v: %v\n", id, err)
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+// removeStoredGraph removes the graph of the build id from the graph store
+// dir.
+func removeStoredGraph(dir, id string) error {
+	file, err := storedGraphFile(dir, id)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return errors.New("no such graph")
+	}
+	return os.RemoveAll(filepath.Dir(file))
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&baseIDFlag, "base-id", "", "ID of the base build whose graph to load from the graph store, where \"skipper record --store\" saves them, instead of --dep-graph. With --remote-cache, it's fetched from the remote cache if it has it. \"latest\" is the most recently recorded one. Defaults to the \"base_id\" config key")
+	graphCmd.AddCommand(graphListCmd)
+	graphCmd.AddCommand(graphRmCmd)
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yourbase/skipper/remotecache"
+)
+
+func TestGraphStore(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	// B sorts first but was recorded last.
+	for i, id := range []string{"C", "B"} {
+		file, err := storedGraphFile(dir, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte("report"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := start.Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Being recorded.
+	if err := os.Mkdir(filepath.Join(dir, "A"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	graphs, err := storedGraphs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graphs) != 2 || graphs[0].ID != "C" || graphs[1].ID != "B" || graphs[1].Size != 6 {
+		t.Errorf("got graphs %+v", graphs)
+	}
+	latest, err := resolveBaseGraph(dir, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "B", storedReportName); latest != want {
+		t.Errorf("got latest graph %v wanted %v", latest, want)
+	}
+	for _, id := range []string{"", "..", "a/b"} {
+		if _, err := resolveBaseGraph(dir, id); err == nil {
+			t.Errorf("resolveBaseGraph(%q) succeeded", id)
+		}
+	}
+
+	if err := removeStoredGraph(dir, "B"); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeStoredGraph(dir, "A"); err == nil {
+		t.Error("removed A, which isn't a graph")
+	}
+	if graphs, _ := storedGraphs(dir); len(graphs) != 1 || graphs[0].ID != "C" {
+		t.Errorf("got graphs %+v after removing B", graphs)
+	}
+	if _, err := resolveBaseGraph(t.TempDir(), "latest"); err == nil {
+		t.Error("resolved the latest graph of an empty store")
+	}
+}
+
+func TestRemoteGraphStore(t *testing.T) {
+	entries := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			entries[r.URL.Path], _ = ioutil.ReadAll(r.Body)
+			return
+		}
+		b, ok := entries[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	}))
+	defer srv.Close()
+	c := &remotecache.Client{URL: srv.URL}
+
+	dir := t.TempDir()
+	file, _ := storedGraphFile(dir, "A")
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte("report"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := pushStoredGraph(c, dir, "A"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another machine, with an empty graph store.
+	other := t.TempDir()
+	for _, id := range []string{"A", "latest"} {
+		got, err := pullStoredGraph(c, other, id)
+		if err != nil || got != "A" {
+			t.Errorf("pullStoredGraph(%q) = %q, %v, wanted A", id, got, err)
+		}
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(other, "A", storedReportName)); err != nil || string(b) != "report" {
+		t.Errorf("got stored graph %q, %v", b, err)
+	}
+	// Left to the local graph store.
+	if got, err := pullStoredGraph(c, other, "B"); err != nil || got != "B" {
+		t.Errorf("pullStoredGraph(B) = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+var impactCmd = &cobra.Command{
+	Use:   "impact <file>...",
+	Short: "Print the steps affected by changes to files",
+	Long: `Prints every step of the base dependency graph that changes to the files
+would make run, directly or through the files written by other affected
+steps. Each step is followed by the shortest dependency chain from every one
+of the files it depends on, like "skipper explain" prints them:
+
+	$ skipper impact src/a.c src/b.c
+	["cc a.c"]
+	  /src/a.c -> read by ["cc a.c"]
+	["cc b.c"]
+	  /src/b.c -> read by ["cc b.c"]
+	["ld -o app"]
+	  /src/a.c -> read by ["cc a.c"], which writes /src/a.o -> read by ["ld -o app"]
+	  /src/b.c -> read by ["cc b.c"], which writes /src/b.o -> read by ["ld -o app"]
+
+Relative paths are relative to the current directory. Always-run rules and
+the network policy aren't taken into account, only the files.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		files, err := impactFiles(args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		opts, err := graphOptions()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		g, err := loadDependencyGraph(graphFileFlag, opts...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not load the base dependency graph: %v\n", err)
+			os.Exit(1)
+		}
+		w := bufio.NewWriter(os.Stdout)
+		for _, name := range g.StepsAffectedBy(files) {
+			fmt.Fprintln(w, name)
+			var cmdTree stepselection.CmdTree
+			if err := json.Unmarshal([]byte(name), &cmdTree); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid step name %v: %v\n", name, err)
+				os.Exit(1)
+			}
+			chains, err := g.DependencyChains(cmdTree, files)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			for _, c := range chains {
+				fmt.Fprintln(w, " ", c)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// impactFiles returns the absolute paths of files, as they're named in the
+// graph, without the ignored ones.
+func impactFiles(files []string) ([]string, error) {
+	ignore, err := ignoreMatcher()
+	if err != nil {
+		return nil, err
+	}
+	roots, err := rootMapping()
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, f := range files {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			return nil, err
+		}
+		if roots != nil {
+			abs = roots.Map(abs)
+		}
+		if ignore.Match(abs) {
+			logger.Info("ignoring the file", "file", abs)
+			continue
+		}
+		out = append(out, abs)
+	}
+	return out, nil
+}
+
+// shortestChain returns the chain of chains with the fewest links, the first
+// one if several do.
+func shortestChain(chains []stepselection.Chain) stepselection.Chain {
+	shortest := chains[0]
+	for _, c := range chains[1:] {
+		if len(c) < len(shortest) {
+			shortest = c
+		}
+	}
+	return shortest
+}
+
+func init() {
+	rootCmd.AddCommand(impactCmd)
+}
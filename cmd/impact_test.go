@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestImpact(t *testing.T) {
+	dir := t.TempDir()
+	graph := filepath.Join(dir, "graph.json")
+	report := `{"CmdTree":["cc a.c"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["cc a.c"],"Mode":"W","File":"/src/a.o"}
+{"CmdTree":["cc b.c"],"Mode":"R","File":"/src/b.c"}
+{"CmdTree":["cc b.c"],"Mode":"W","File":"/src/b.o"}
+{"CmdTree":["ld -o app"],"Mode":"R","File":"/src/a.o"}
+{"CmdTree":["ld -o app"],"Mode":"R","File":"/src/b.o"}
+{"CmdTree":["ld -o app"],"Mode":"W","File":"/src/app"}
+{"CmdTree":["cc c.c"],"Mode":"R","File":"/src/c.c"}
+`
+	if err := os.WriteFile(graph, []byte(report), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cm := skipperCommand(dir, "impact", "--dep-graph", graph, "/src/a.c", "/src/b.c")
+	stderr := new(bytes.Buffer)
+	cm.Stderr = stderr
+	out, err := cm.Output()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, stderr)
+	}
+	want := []string{
+		`["cc a.c"]`,
+		`  /src/a.c -> read by ["cc a.c"]`,
+		`["cc b.c"]`,
+		`  /src/b.c -> read by ["cc b.c"]`,
+		`["ld -o app"]`,
+		`  /src/a.c -> read by ["cc a.c"], which writes /src/a.o -> read by ["ld -o app"]`,
+		`  /src/b.c -> read by ["cc b.c"], which writes /src/b.o -> read by ["ld -o app"]`,
+	}
+	if diff := cmp.Diff(want, strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yourbase/skipper/builddata"
+	"github.com/yourbase/skipper/importer"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+var (
+	importStepFlag   string
+	importDirFlag    string
+	importOutputFlag string
+
+	importGoStepFlag        string
+	importGoPackageStepFlag string
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import --step <step> <file>...",
+	Short: "Create a build report from ninja deps logs and depfiles",
+	Long: `Creates a build report from the dependency files build tools already write,
+instead of tracing the build: ninja deps logs (.ninja_deps) and GCC or Clang
+depfiles (.d). Every output and input is attributed to --step, given either
+as the command skipper wraps, like "ninja -C out", or as a step name, like
+["ninja -C out"].
+
+Relative paths are relative to --dir, which defaults to the directory of
+each ninja deps log, the build directory, and to the current directory for
+depfiles. The report is written to -o, by default --dep-graph.
+
+For Go projects, see "skipper import go".`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if importStepFlag == "" {
+			fmt.Fprintln(os.Stderr, "--step is required")
+			os.Exit(1)
+		}
+		step, err := parseStepArg(importStepFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		writeImport(func(emit func(*stepselection.BuildLog) error) error {
+			for _, file := range args {
+				if err := importFile(file, step, emit); err != nil {
+					return fmt.Errorf("%v: %v", file, err)
+				}
+			}
+			return nil
+		})
+	},
+}
+
+var importGoCmd = &cobra.Command{
+	Use:   "go [packages]",
+	Short: "Create a build report from the packages of a Go module",
+	Long: `Creates a build report from "go list -deps -test -json", without tracing the
+build. --step reads the source files of the packages, their tests and
+everything they depend on outside the standard library, including embedded
+files and the module's go.mod and go.sum. The packages default to ./...,
+resolved in --dir, by default the current directory.
+
+With --package-step, every package also gets its own step, reading only
+what the package and its tests depend on. The step is the format with %s
+replaced by the import path, so that with the default "go test %s" changing
+a file only reruns the tests of the packages that depend on it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		step, err := parseStepArg(importGoStepFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		var packageStep func(string) stepselection.CmdTree
+		if importGoPackageStepFlag != "" {
+			if !strings.Contains(importGoPackageStepFlag, "%s") {
+				fmt.Fprintf(os.Stderr, "--package-step must contain %%s where the import path goes\n")
+				os.Exit(1)
+			}
+			packageStep = func(importPath string) stepselection.CmdTree {
+				return stepselection.CmdTree{strings.Replace(importGoPackageStepFlag, "%s", importPath, -1)}
+			}
+		}
+		if len(args) == 0 {
+			args = []string{"./..."}
+		}
+		var stdout bytes.Buffer
+		goList := exec.Command("go", append([]string{"list", "-deps", "-test", "-json"}, args...)...)
+		goList.Dir = importDirFlag
+		goList.Stdout = &stdout
+		goList.Stderr = os.Stderr
+		if err := goList.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not list the packages: %v\n", err)
+			os.Exit(1)
+		}
+		writeImport(func(emit func(*stepselection.BuildLog) error) error {
+			return importer.GoList(&stdout, step, packageStep, emit)
+		})
+	},
+}
+
+// writeImport writes the entries emitted by f to the report given by -o,
+// exiting if it fails.
+func writeImport(f func(emit func(*stepselection.BuildLog) error) error) {
+	out := importOutputFlag
+	if out == "" {
+		var err error
+		if out, err = singleGraphFile(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v, or -o must be given\n", err)
+			os.Exit(1)
+		}
+	}
+	buildID, err := reportBuildID()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	n := 0
+	err = writeReportFile(out, func(w *builddata.Writer) error {
+		if err := stepselection.WriteRecordedReportHeader(w, time.Now(), gitOutput("rev-parse", "HEAD")); err != nil {
+			return err
+		}
+		return f(func(bog *stepselection.BuildLog) error {
+			n++
+			bog.BuildID = buildID
+			return w.Encode(bog)
+		})
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not import: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("skipper: imported %d entries to %v\n", n, out)
+}
+
+// importFile emits the entries of a ninja deps log or a depfile, told apart
+// by the signature of ninja deps logs.
+func importFile(file string, step stepselection.CmdTree, emit func(*stepselection.BuildLog) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	head, _ := r.Peek(len(importer.NinjaDepsSignature))
+	if string(head) == importer.NinjaDepsSignature {
+		dir := importDirFlag
+		if dir == "" {
+			dir = filepath.Dir(file)
+		}
+		if dir, err = filepath.Abs(dir); err != nil {
+			return err
+		}
+		return importer.NinjaDeps(r, step, dir, emit)
+	}
+	dir, err := filepath.Abs(importDirFlag)
+	if err != nil {
+		return err
+	}
+	return importer.Depfile(r, step, dir, emit)
+}
+
+func init() {
+	importCmd.Flags().StringVar(&importStepFlag, "step", "", "step the imported files belong to")
+	importCmd.PersistentFlags().StringVar(&importDirFlag, "dir", "", "directory relative paths are relative to")
+	importCmd.PersistentFlags().StringVarP(&importOutputFlag, "output", "o", "", "where to write the build report (default is --dep-graph)")
+	importGoCmd.Flags().StringVar(&importGoStepFlag, "step", "go test ./...", "step that reads the source files of all the packages")
+	importGoCmd.Flags().StringVar(&importGoPackageStepFlag, "package-step", "", `format of the step of each package, like "go test %s"`)
+	importCmd.AddCommand(importGoCmd)
+	rootCmd.AddCommand(importCmd)
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+var inputsSourcesFlag bool
+
+var inputsCmd = &cobra.Command{
+	Use:   "inputs -- <step args>",
+	Short: "Print every file a step depends on",
+	Long: `Prints the files the step reads, and the files read by the steps that write
+them, transitively, one per line, followed by the directories those steps
+list, with a trailing separator. These are all the inputs of the step, for
+auditing what it depends on or for computing a cache key for it.
+
+With --sources, only the files that no step of the graph writes are printed:
+the inputs of the whole chain of steps leading to the step.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		stepName, err := currentStepName(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not determine step name: %v\n", err)
+			os.Exit(1)
+		}
+		opts, err := graphOptions()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		g, err := loadDependencyGraph(graphFileFlag, opts...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not load the base dependency graph: %v\n", err)
+			os.Exit(1)
+		}
+		g.SetLookupLimits(lookupLimits())
+		files, dirs, err := g.StepInputs(stepName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		w := bufio.NewWriter(os.Stdout)
+		for _, f := range files {
+			if inputsSourcesFlag && len(g.FileWriters(f)) > 0 {
+				continue
+			}
+			fmt.Fprintln(w, filepath.FromSlash(f))
+		}
+		for _, d := range dirs {
+			fmt.Fprintln(w, filepath.FromSlash(d)+string(filepath.Separator))
+		}
+		if err := w.Flush(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	inputsCmd.Flags().BoolVar(&inputsSourcesFlag, "sources", false, "only print the files no step writes")
+	rootCmd.AddCommand(inputsCmd)
+}
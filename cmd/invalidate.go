@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/viper"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// invalidateAllMatcher matches the changed files that make every step run:
+// the "invalidate_all" patterns in the config file, and the config file
+// itself. They're the build definition, like a Dockerfile, the build scripts
+// or lockfiles, whose changes alter how every step runs in ways the base
+// graph can't tell. Example config:
+//
+//	invalidate_all:
+//	  - Dockerfile
+//	  - /src/build/*.sh
+//	  - "*.lock"
+func invalidateAllMatcher() (*stepselection.PathMatcher, error) {
+	patterns := append([]string{}, viper.GetStringSlice("invalidate_all")...)
+	if file := viper.ConfigFileUsed(); file != "" {
+		// Changed files are named as in the graph, see changedNodes.
+		roots, err := rootMapping()
+		if err != nil {
+			return nil, err
+		}
+		if roots != nil {
+			file = roots.Map(file)
+		}
+		patterns = append(patterns, file)
+	}
+	m, err := stepselection.NewPathMatcher(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid invalidate_all config: %v", err)
+	}
+	return m, nil
+}
+
+// invalidatingChange returns the first of the changed files, sorted, that
+// matches m, or "" if none does.
+func invalidatingChange(m *stepselection.PathMatcher, changed map[string]bool) string {
+	if m == nil {
+		return ""
+	}
+	var files []string
+	for f := range changed {
+		if m.Match(f) {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return ""
+	}
+	sort.Strings(files)
+	return files[0]
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/viper"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func TestInvalidateAll(t *testing.T) {
+	report := `{"CmdTree":["cc a.c"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["deploy"],"Mode":"R","File":"/src/app"}
+`
+	g, err := stepselection.NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("invalidate_all", []string{"Dockerfile", "/src/build/*.sh"})
+	defer viper.Set("invalidate_all", nil)
+	m, err := invalidateAllMatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	overrides := []stepselection.StepOverride{{Pattern: regexp.MustCompile("^deploy$"), Run: "never", Reason: "deploys are manual"}}
+	for _, tc := range []struct {
+		changed []string
+		step    string
+		run     bool
+		reason  string
+	}{
+		{[]string{"/src/b.c"}, "cc a.c", false, ""},
+		{[]string{"/src/b.c", "/src/ci/Dockerfile"}, "cc a.c", true, "/src/ci/Dockerfile changed, which invalidates every step"},
+		{[]string{"/src/build/gen.sh", "/src/Dockerfile"}, "cc a.c", true, "/src/Dockerfile changed"},
+		{[]string{"/src/build/sub/gen.sh"}, "cc a.c", false, ""},
+		// Overrides still decide the steps they apply to.
+		{[]string{"/src/Dockerfile"}, "deploy", false, "deploys are manual"},
+	} {
+		changed := map[string]bool{}
+		for _, f := range tc.changed {
+			changed[f] = true
+		}
+		s := &stepSkipper{updatedNodes: changed, depGraph: g, overrides: overrides, invalidateAll: m}
+		run, reason, err := s.shouldRun([]string{tc.step})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if run != tc.run || !strings.Contains(reason, tc.reason) {
+			t.Errorf("%v changed: got %v, %q for %v, wanted %v, %q", tc.changed, run, reason, tc.step, tc.run, tc.reason)
+		}
+	}
+
+	viper.Set("invalidate_all", []string{""})
+	if _, err := invalidateAllMatcher(); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestInvalidateAllCommands(t *testing.T) {
+	report := `{"CmdTree":["cc a.c"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["cc b.c"],"Mode":"R","File":"/src/b.c"}
+{"CmdTree":["go test ./a"],"Mode":"R","File":"/src/a/a_test.go"}
+`
+	graph := filepath.Join(t.TempDir(), "report.json")
+	if err := os.WriteFile(graph, []byte(report), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(file string, changed []string) { graphFileFlag, changedFileFlag = file, changed }(graphFileFlag, changedFileFlag)
+	graphFileFlag = graph
+	viper.Set("invalidate_all", []string{"Dockerfile"})
+	defer viper.Set("invalidate_all", nil)
+
+	for _, tc := range []struct {
+		changed string
+		want    []string
+	}{
+		{"/src/a.c", []string{"cc a.c"}},
+		{"/src/Dockerfile", []string{"cc a.c", "cc b.c", "go test ./a"}},
+	} {
+		changedFileFlag = []string{tc.changed}
+		got, err := filterSteps([]string{"cc a.c", "cc b.c", "go test ./a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("filter with %v changed: (-want +got)\n%s", tc.changed, diff)
+		}
+		plan, err := buildPlan(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var planned []string
+		for _, s := range plan {
+			planned = append(planned, s.Command)
+		}
+		if diff := cmp.Diff(tc.want, planned); diff != "" {
+			t.Errorf("plan with %v changed: (-want +got)\n%s", tc.changed, diff)
+		}
+	}
+	sel, err := selectTests("go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"./a"}, sel.Run); diff != "" {
+		t.Errorf("tests with /src/Dockerfile changed: (-want +got)\n%s", diff)
+	}
+}
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/yourbase/skipper/builddata"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// learnDir returns the directory where the steps that run are re-recorded,
+// from --learn-dir or the "learn_dir" config key, or "" if they aren't.
+func learnDir() string {
+	if learnDirFlag != "" {
+		return learnDirFlag
+	}
+	return viper.GetString("learn_dir")
+}
+
+// learnRecorder returns the name of the recorder that re-records steps, from
+// the "recorder" config key, or the default recorder of "skipper record".
+func learnRecorder() string {
+	if name := viper.GetString("recorder"); name != "" {
+		return name
+	}
+	return defaultRecorder()
+}
+
+// learnedReportPath returns where the report of the step stepID, re-recorded
+// in build buildID, is written in dir. A step run twice in a build keeps the
+// report of its last run.
+func learnedReportPath(dir, buildID, stepID string) string {
+	sum := sha256.Sum256([]byte(stepID))
+	return filepath.Join(dir, fmt.Sprintf("%v-%x.gz", buildID, sum[:8]))
+}
+
+// learnStep runs args, the command of stepName, with the learning recorder,
+// and writes the accesses it made to a build report in dir, for
+// mergeLearned. The command is stopped when ctx is done. It returns the
+// command's error, like runCommand.
+func learnStep(ctx context.Context, dir, buildID string, stepName stepselection.CmdTree, args []string) error {
+	rec, err := newRecorder(learnRecorder())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// The recorder runs the command with skipper's environment, where
+	// nested skippers find their parent step.
+	if err := os.Setenv(stepPathEnv, stepName.Name()); err != nil {
+		return err
+	}
+	var entries []*stepselection.BuildLog
+	tools := newToolchainFingerprints(toolchainCommands())
+	recordErr := rec.Record(ctx, args, func(bog *stepselection.BuildLog) error {
+		bog.CmdTree = learnedStepTree(stepName, bog.CmdTree)
+		bog.BuildID = buildID
+		if tool := tools.entry(bog); tool != nil {
+			entries = append(entries, tool)
+		}
+		entries = append(entries, bog)
+		return nil
+	})
+	if len(entries) == 0 {
+		// The recorder failed to start the command.
+		return recordErr
+	}
+	// Merging the report replaces the environment of the step too.
+	for _, bog := range stepEnvEntries(stepName) {
+		bog.BuildID = buildID
+		entries = append(entries, bog)
+	}
+	err = writeReportFile(learnedReportPath(dir, buildID, stepName.Name()), func(w *builddata.Writer) error {
+		if err := stepselection.WriteReportHeader(w); err != nil {
+			return err
+		}
+		for _, bog := range entries {
+			if err := w.Encode(bog); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Warn("could not save the re-recorded step", "step", stepName.Name(), "err", err)
+	}
+	return recordErr
+}
+
+// learnedStepTree returns the CmdTree, in the graph, of an entry recorded
+// while running stepName. Recorders name the steps as if the command was a
+// whole build: the command itself, or the steps of the skipper wrappers
+// nested in it, which are nested in stepName.
+func learnedStepTree(stepName, recorded stepselection.CmdTree) stepselection.CmdTree {
+	tree := append(stepselection.CmdTree(nil), stepName[:len(stepName)-1]...)
+	if len(recorded) == 0 || recorded[0] != stepName[len(stepName)-1] {
+		tree = append(tree, stepName[len(stepName)-1])
+	}
+	return append(tree, recorded...)
+}
+
+// mergeLearned merges into g the reports of the steps re-recorded since the
+// reports in logFile were written, so that the graph follows the steps that
+// changed until the next base build. Learned reports aren't signed, so
+// they're left out when graphs must be.
+func mergeLearned(g *stepselection.DependencyGraph, logFile string) error {
+	dir := learnDir()
+	if dir == "" {
+		return nil
+	}
+	if graphPublicKeyFile() != "" {
+		logger.Debug("not merging the re-recorded steps, which aren't signed")
+		return nil
+	}
+	reports, err := learnedReports(dir, graphModTime(logFile))
+	if err != nil {
+		return err
+	}
+	for _, file := range reports {
+		if err := mergeReport(g, file); err != nil {
+			return fmt.Errorf("could not merge the re-recorded step in %v: %v", file, err)
+		}
+	}
+	if len(reports) > 0 {
+		logger.Debug("merged the re-recorded steps", "reports", len(reports))
+	}
+	return nil
+}
+
+// learnedReports returns the reports in dir modified after since, oldest
+// first, so that newer recordings of a step replace older ones.
+func learnedReports(dir string, since time.Time) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var reports []string
+	modTimes := map[string]time.Time{}
+	for _, e := range entries {
+		// Reports being written end with .tmp.
+		if filepath.Ext(e.Name()) != ".gz" {
+			continue
+		}
+		fi, err := e.Info()
+		if os.IsNotExist(err) {
+			// Replaced by a newer recording meanwhile.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if fi.ModTime().After(since) {
+			file := filepath.Join(dir, e.Name())
+			reports = append(reports, file)
+			modTimes[file] = fi.ModTime()
+		}
+	}
+	sort.SliceStable(reports, func(i, j int) bool {
+		return modTimes[reports[i]].Before(modTimes[reports[j]])
+	})
+	return reports, nil
+}
+
+// removeLearnedReports removes the reports in dir, all of them since they're
+// either merged or older than the base graph.
+func removeLearnedReports(dir string) error {
+	reports, err := learnedReports(dir, time.Time{})
+	if err != nil {
+		return err
+	}
+	for _, file := range reports {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// graphModTime returns when the newest of the reports in logFile was
+// written, or the zero time if it can't tell, like for stdin.
+func graphModTime(logFile string) time.Time {
+	var t time.Time
+	if logFile == stdinName {
+		return t
+	}
+	files, err := graphFiles(logFile)
+	if err != nil {
+		return t
+	}
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil && fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func TestLearnedStepTree(t *testing.T) {
+	step := stepselection.CmdTree{"make", "cc a.c"}
+	for _, tc := range []struct {
+		recorded stepselection.CmdTree
+		want     stepselection.CmdTree
+	}{
+		{stepselection.CmdTree{"cc a.c"}, stepselection.CmdTree{"make", "cc a.c"}},
+		// Skipper wrappers nested in the command.
+		{stepselection.CmdTree{"as a.s"}, stepselection.CmdTree{"make", "cc a.c", "as a.s"}},
+		{stepselection.CmdTree{"as a.s", "ld"}, stepselection.CmdTree{"make", "cc a.c", "as a.s", "ld"}},
+	} {
+		if got := learnedStepTree(step, tc.recorded); !cmp.Equal(got, tc.want) {
+			t.Errorf("learnedStepTree(%q) = %q, want %q", tc.recorded, got, tc.want)
+		}
+	}
+}
+
+func TestLearnedReports(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Now().Add(-time.Hour)
+	for i, name := range []string{"new.gz", "old.gz", "newest.gz", "partial.gz.tmp", "stale.gz"} {
+		f := filepath.Join(dir, name)
+		if err := os.WriteFile(f, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := base.Add(time.Duration([]int{3, 2, 4, 5, -1}[i]) * time.Minute)
+		if err := os.Chtimes(f, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := learnedReports(dir, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "old.gz"), filepath.Join(dir, "new.gz"), filepath.Join(dir, "newest.gz")}
+	if !cmp.Equal(got, want) {
+		t.Errorf("got reports %q, want %q", got, want)
+	}
+	if got, err := learnedReports(filepath.Join(dir, "missing"), base); err != nil || got != nil {
+		t.Errorf("got reports %q and error %v in a missing directory, want none", got, err)
+	}
+}
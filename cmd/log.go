@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+var (
+	logLevelFlag  string
+	logFormatFlag string
+	logFileFlag   string
+)
+
+// logger reports what skipper decides and why. Until setupLogging runs, it
+// writes info messages to stderr.
+var logger = slog.New(newCLIHandler(os.Stderr, slog.LevelInfo))
+
+// setupLogging configures logger from the --log-* flags. Debug level also
+// traces the dependency lookups of the stepselection package.
+func setupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevelFlag)); err != nil {
+		return fmt.Errorf("invalid --log-level %q: %v", logLevelFlag, err)
+	}
+	var w io.Writer = os.Stderr
+	if logFileFlag != "" {
+		f, err := os.OpenFile(logFileFlag, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		// The file stays open until skipper exits.
+		w = f
+	}
+	var h slog.Handler
+	switch logFormatFlag {
+	case "text":
+		h = newCLIHandler(w, level)
+	case "json":
+		h = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+	default:
+		return fmt.Errorf("invalid --log-format %q, must be text or json", logFormatFlag)
+	}
+	logger = slog.New(h)
+	stepselection.SetLogger(logger)
+	return nil
+}
+
+// cliHandler formats records for people reading a build log: the message
+// prefixed with "skipper:", followed by the attributes as key=value pairs.
+// Timestamps are left out since build logs usually have their own.
+type cliHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Level
+	// attrs holds the attributes added with WithAttrs, already formatted.
+	attrs  string
+	prefix string
+}
+
+func newCLIHandler(w io.Writer, level slog.Level) *cliHandler {
+	return &cliHandler{mu: new(sync.Mutex), w: w, level: level}
+}
+
+func (h *cliHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *cliHandler) Handle(_ context.Context, r slog.Record) error {
+	buf := new(strings.Builder)
+	buf.WriteString("skipper: ")
+	if r.Level != slog.LevelInfo {
+		buf.WriteString(r.Level.String() + ": ")
+	}
+	buf.WriteString(r.Message)
+	buf.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		h.writeAttr(buf, h.prefix, a)
+		return true
+	})
+	buf.WriteString("\n")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, buf.String())
+	return err
+}
+
+func (h *cliHandler) writeAttr(buf *strings.Builder, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		for _, ga := range v.Group() {
+			h.writeAttr(buf, prefix+a.Key+".", ga)
+		}
+		return
+	}
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	s := v.String()
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		s = fmt.Sprintf("%q", s)
+	}
+	fmt.Fprintf(buf, " %s%s=%s", prefix, a.Key, s)
+}
+
+func (h *cliHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	buf := new(strings.Builder)
+	for _, a := range attrs {
+		h.writeAttr(buf, h.prefix, a)
+	}
+	h2.attrs += buf.String()
+	return &h2
+}
+
+func (h *cliHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix += name + "."
+	return &h2
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&logLevelFlag, "log-level", "info", "minimum level of the messages logged: debug, info, warn or error. Debug traces every dependency lookup")
+	rootCmd.PersistentFlags().StringVar(&logFormatFlag, "log-format", "text", "format of the messages logged: text or json")
+	rootCmd.PersistentFlags().StringVar(&logFileFlag, "log-file", "", "append log messages to this file instead of writing them to stderr")
+}
@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"testing"
+)
+
+func TestCLIHandler(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := slog.New(newCLIHandler(buf, slog.LevelInfo))
+	l.Debug("hidden")
+	l.Info("decided we should skip", "step", `["make test"]`)
+	l.With("graph", "/base-graph.gz").WithGroup("cache").Warn("not restored", "err", errors.New("missing"), "outputs", 2)
+
+	want := `skipper: decided we should skip step="[\"make test\"]"
+skipper: WARN: not restored graph=/base-graph.gz cache.err=missing cache.outputs=2
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got %q wanted %q", got, want)
+	}
+}
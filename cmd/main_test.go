@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain runs skipper instead of the tests when the test binary is run by
+// runSkipper.
+func TestMain(m *testing.M) {
+	if os.Getenv("SKIPPER_TEST_RUN_SKIPPER") == "1" {
+		Execute()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// skipperCommand returns the command running skipper with args in dir,
+// isolated from the config and caches of the user.
+func skipperCommand(dir string, args ...string) *exec.Cmd {
+	cm := exec.Command(os.Args[0], args...)
+	cm.Dir = dir
+	cm.Env = append(os.Environ(),
+		"SKIPPER_TEST_RUN_SKIPPER=1",
+		"SKIPPER_BUILD_ID=test",
+		"HOME="+dir,
+		"XDG_CACHE_HOME="+filepath.Join(dir, "cache"),
+		"XDG_RUNTIME_DIR="+dir,
+	)
+	return cm
+}
+
+// runSkipper runs skipper with args in dir, see skipperCommand, and returns
+// its exit code and stderr.
+func runSkipper(t *testing.T, dir string, args ...string) (int, string) {
+	t.Helper()
+	cm := skipperCommand(dir, args...)
+	stderr := new(bytes.Buffer)
+	cm.Stderr = stderr
+	err := cm.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), stderr.String()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return 0, stderr.String()
+}
+
+func TestDecisionExitCodes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the wrapped command is a shell")
+	}
+	dir := t.TempDir()
+	graph := filepath.Join(dir, "graph.json")
+	report := `{"CmdTree":["sh -c exit 3"],"Mode":"R","File":"/src/a.c"}` + "\n"
+	if err := os.WriteFile(graph, []byte(report), 0644); err != nil {
+		t.Fatal(err)
+	}
+	step := []string{"--", "sh", "-c", "exit 3"}
+	for _, tc := range []struct {
+		args []string
+		want int
+	}{
+		// Skipped.
+		{[]string{"--changed-file", "/src/b.c"}, 0},
+		{[]string{"--changed-file", "/src/b.c", "--decision-exit-codes"}, 86},
+		{[]string{"--changed-file", "/src/b.c", "--decision-exit-codes", "--skip-exit-code", "7"}, 7},
+		// Run, with the command's own exit status.
+		{[]string{"--changed-file", "/src/a.c"}, 3},
+		{[]string{"--changed-file", "/src/a.c", "--decision-exit-codes"}, 3},
+	} {
+		args := append(append([]string{"--dep-graph", graph}, tc.args...), step...)
+		if got, stderr := runSkipper(t, dir, args...); got != tc.want {
+			t.Errorf("%q: got exit code %d, wanted %d\n%s", tc.args, got, tc.want, stderr)
+		}
+	}
+
+	// Exit codes that can't be told apart from success or aren't exit
+	// codes are refused.
+	for _, code := range []string{"0", "-1", "256", "x"} {
+		args := append([]string{"--dep-graph", graph, "--changed-file", "/src/b.c", "--decision-exit-codes", "--skip-exit-code", code}, step...)
+		if got, stderr := runSkipper(t, dir, args...); got != 1 || !strings.Contains(stderr, "skip-exit-code") {
+			t.Errorf("--skip-exit-code %v: got exit code %d, wanted the flag to be refused\n%s", code, got, stderr)
+		}
+	}
+}
+
+func TestLearnTimeout(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the preload recorder is Linux only")
+	}
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("no C compiler")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".skipper.yaml"), []byte("recorder: preload\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	graph := filepath.Join(dir, "graph.json")
+	report := `{"CmdTree":["sleep 10"],"Mode":"R","File":"/src/a.c"}` + "\n"
+	if err := os.WriteFile(graph, []byte(report), 0644); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	got, stderr := runSkipper(t, dir, "--dep-graph", graph, "--changed-file", "/src/a.c",
+		"--learn-dir", filepath.Join(dir, "learned"), "--timeout", "500ms", "--", "sleep", "10")
+	if got != timedOutExitCode || !strings.Contains(stderr, "timed out") {
+		t.Errorf("got exit code %d, wanted %d\n%s", got, timedOutExitCode, stderr)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the learned step was stopped after %v", elapsed)
+	}
+}
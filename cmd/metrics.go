@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// decisionBuckets are the upper bounds, in seconds, of the buckets of the
+// decision latency histogram.
+var decisionBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// daemonMetrics counts the decisions of the daemon, exposed in the
+// Prometheus text format. They're few and simple enough not to need the
+// Prometheus client library.
+type daemonMetrics struct {
+	mu        sync.Mutex
+	decisions map[string]int64 // by decision, "run" or "skip"
+	fallbacks map[string]int64 // by reason
+	buckets   []int64          // cumulative counts, one per decisionBuckets
+	count     int64
+	sum       time.Duration
+	// steps and files are the size of the graph, or -1 if unknown.
+	steps, files int
+}
+
+func newDaemonMetrics(g stepselection.Graph) *daemonMetrics {
+	m := &daemonMetrics{
+		decisions: map[string]int64{"run": 0, "skip": 0},
+		fallbacks: map[string]int64{},
+		buckets:   make([]int64, len(decisionBuckets)),
+		steps:     -1,
+		files:     -1,
+	}
+	if dg, ok := g.(*stepselection.DependencyGraph); ok {
+		m.steps, m.files = dg.Size()
+	}
+	return m
+}
+
+// observe records a decision that took d. Steps that run although they might
+// not depend on the changes have a fallback reason.
+func (m *daemonMetrics) observe(run bool, fallback string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if run {
+		m.decisions["run"]++
+	} else {
+		m.decisions["skip"]++
+	}
+	if fallback != "" {
+		m.fallbacks[fallback]++
+	}
+	for i, b := range decisionBuckets {
+		if d.Seconds() <= b {
+			m.buckets[i]++
+		}
+	}
+	m.count++
+	m.sum += d
+}
+
+// fallbackReason classifies why a step runs without being known to depend
+// on the changes, or returns "" if it does depend on them.
+func fallbackReason(alwaysRun bool, err error) string {
+	switch {
+	case alwaysRun:
+		return "always_run"
+	case err == nil:
+		return ""
+	case errors.Is(err, stepselection.ErrUnknownStep):
+		return "unknown_step"
+	case errors.Is(err, stepselection.ErrLookupLimit):
+		return "lookup_limit"
+	default:
+		return "error"
+	}
+}
+
+// write writes the metrics in the Prometheus text format.
+func (m *daemonMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintln(w, "# HELP skipper_decisions_total Skip decisions answered, by decision.")
+	fmt.Fprintln(w, "# TYPE skipper_decisions_total counter")
+	for _, d := range []string{"run", "skip"} {
+		fmt.Fprintf(w, "skipper_decisions_total{decision=%q} %d\n", d, m.decisions[d])
+	}
+	fmt.Fprintln(w, "# HELP skipper_fallback_runs_total Steps run without being known to depend on the changes, by reason.")
+	fmt.Fprintln(w, "# TYPE skipper_fallback_runs_total counter")
+	var reasons []string
+	for r := range m.fallbacks {
+		reasons = append(reasons, r)
+	}
+	sort.Strings(reasons)
+	for _, r := range reasons {
+		fmt.Fprintf(w, "skipper_fallback_runs_total{reason=%q} %d\n", r, m.fallbacks[r])
+	}
+	fmt.Fprintln(w, "# HELP skipper_decision_duration_seconds Time taken to decide whether a step runs.")
+	fmt.Fprintln(w, "# TYPE skipper_decision_duration_seconds histogram")
+	for i, b := range decisionBuckets {
+		fmt.Fprintf(w, "skipper_decision_duration_seconds_bucket{le=\"%g\"} %d\n", b, m.buckets[i])
+	}
+	fmt.Fprintf(w, "skipper_decision_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.count)
+	fmt.Fprintf(w, "skipper_decision_duration_seconds_sum %g\n", m.sum.Seconds())
+	fmt.Fprintf(w, "skipper_decision_duration_seconds_count %d\n", m.count)
+	if m.steps >= 0 {
+		fmt.Fprintln(w, "# HELP skipper_graph_steps Steps in the dependency graph served.")
+		fmt.Fprintln(w, "# TYPE skipper_graph_steps gauge")
+		fmt.Fprintf(w, "skipper_graph_steps %d\n", m.steps)
+		fmt.Fprintln(w, "# HELP skipper_graph_files Files in the dependency graph served.")
+		fmt.Fprintln(w, "# TYPE skipper_graph_files gauge")
+		fmt.Fprintf(w, "skipper_graph_files %d\n", m.files)
+	}
+}
+
+func (m *daemonMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+var (
+	planDepthFlag int
+	planJSONFlag  bool
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Print the steps that must run, in dependency order",
+	Long: `Prints the commands of the steps of the base dependency graph that must
+run because of the changes, one per line, each after the steps that write
+files it reads. Running them in order is an incremental build:
+
+	skipper plan --changes changes.txt | while read step; do $step; done
+
+Only the steps nested --depth deep are planned: the default, 1, plans the
+top-level steps. Steps that match an always-run rule or, with --tags, have
+none of the tags are planned too, and every step is if the graph is too
+stale with "stale_graph: run" or a file of the "invalidate_all" config key
+changed. Steps that depend on each other in a cycle are ordered by name.
+
+With --json, the plan is printed as a plan for "skipper run-plan", with the
+steps each step must run after, so that independent steps run in parallel:
+
+	skipper plan --json > plan.json
+	skipper run-plan plan.json`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if planDepthFlag < 1 {
+			fmt.Fprintf(os.Stderr, "invalid --depth %d, must be at least 1\n", planDepthFlag)
+			os.Exit(1)
+		}
+		plan, err := buildPlan(planDepthFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		w := bufio.NewWriter(os.Stdout)
+		if planJSONFlag {
+			err = writePlanJSON(w, plan)
+		} else {
+			for _, s := range plan {
+				fmt.Fprintln(w, s.Command)
+			}
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// buildPlan returns the steps nested depth deep that must run, in
+// dependency order.
+func buildPlan(depth int) ([]stepselection.PlanStep, error) {
+	changed, err := changedNodes()
+	if err != nil {
+		return nil, fmt.Errorf("could not determine the changed files: %v", err)
+	}
+	opts, err := graphOptions()
+	if err != nil {
+		return nil, err
+	}
+	g, err := loadDependencyGraph(graphFileFlag, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not load the base dependency graph: %v", err)
+	}
+	skipCheck, err := graphSkipper(g, changed)
+	if err != nil {
+		return nil, err
+	}
+	tagger := skipCheck.tagger
+	if len(tagsFlag) > 0 && tagger == nil {
+		if tagger, err = stepTagger(); err != nil {
+			return nil, fmt.Errorf("could not load step tags: %v", err)
+		}
+	}
+	var files []string
+	for f := range changed {
+		files = append(files, f)
+	}
+	affected := map[string]bool{}
+	for _, name := range g.StepsAffectedBy(files) {
+		affected[name] = true
+	}
+	var names []string
+	for _, name := range g.Steps() {
+		var cmdTree stepselection.CmdTree
+		if err := json.Unmarshal([]byte(name), &cmdTree); err != nil || len(cmdTree) != depth {
+			continue
+		}
+		run, _, ok := skipCheck.policyDecision(cmdTree)
+		if !ok {
+			run = affected[name] || len(tagsFlag) > 0 && !tagger.HasAnyTag(cmdTree, tagsFlag)
+		}
+		if run {
+			names = append(names, name)
+		}
+	}
+	return g.Plan(names), nil
+}
+
+// writePlanJSON writes plan as a plan for "skipper run-plan". Steps are
+// named after their commands, unless several steps share a command.
+func writePlanJSON(w *bufio.Writer, plan []stepselection.PlanStep) error {
+	type runStep struct {
+		Name    string   `json:"name,omitempty"`
+		Command string   `json:"command"`
+		After   []string `json:"after,omitempty"`
+	}
+	commands := map[string]int{}
+	for _, s := range plan {
+		commands[s.Command]++
+	}
+	names := map[string]string{}
+	var out struct {
+		Steps []runStep `json:"steps"`
+	}
+	for _, s := range plan {
+		r := runStep{Command: s.Command}
+		names[s.Name] = s.Command
+		if commands[s.Command] > 1 {
+			r.Name, names[s.Name] = s.Name, s.Name
+		}
+		for _, a := range s.After {
+			r.After = append(r.After, names[a])
+		}
+		out.Steps = append(out.Steps, r)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&out)
+}
+
+func init() {
+	planCmd.Flags().IntVar(&planDepthFlag, "depth", 1, "how deep the planned steps are nested")
+	planCmd.Flags().BoolVar(&planJSONFlag, "json", false, "print the plan for \"skipper run-plan\"")
+	rootCmd.AddCommand(planCmd)
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/viper"
+)
+
+func TestBuildPlanTags(t *testing.T) {
+	report := `{"CmdTree":["go generate ./..."],"Mode":"R","File":"/src/gen.go"}
+{"CmdTree":["go test ./a"],"Mode":"R","File":"/src/a/a_test.go"}
+{"CmdTree":["go test ./b"],"Mode":"R","File":"/src/b/b_test.go"}
+`
+	graph := filepath.Join(t.TempDir(), "report.json")
+	if err := os.WriteFile(graph, []byte(report), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(file string, changed, tags []string) {
+		graphFileFlag, changedFileFlag, tagsFlag = file, changed, tags
+	}(graphFileFlag, changedFileFlag, tagsFlag)
+	graphFileFlag, changedFileFlag = graph, []string{"/src/a/a_test.go"}
+	viper.Set("tags", []map[string]any{{"pattern": "^go test", "tags": []string{"unit-tests"}}})
+	defer viper.Set("tags", nil)
+
+	for _, tc := range []struct {
+		tags []string
+		want []string
+	}{
+		{nil, []string{"go test ./a"}},
+		// Steps without the tags aren't decided, so they're planned.
+		{[]string{"unit-tests"}, []string{"go generate ./...", "go test ./a"}},
+		{[]string{"codegen"}, []string{"go generate ./...", "go test ./a", "go test ./b"}},
+	} {
+		tagsFlag = tc.tags
+		plan, err := buildPlan(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, s := range plan {
+			got = append(got, s.Command)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("--tags %v: (-want +got)\n%s", tc.tags, diff)
+		}
+	}
+}
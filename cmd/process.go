@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// timedOutExitCode is the exit code of skipper when --timeout kills the
+// wrapped command, like timeout(1).
+const timedOutExitCode = 124
+
+// exitCodeValue is the value of flags setting the exit code skipper reports
+// something with, which must tell it apart from success and stay within
+// what shells report: 1 to 255.
+type exitCodeValue struct {
+	p *int
+}
+
+func (v exitCodeValue) Set(s string) error {
+	code, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	if code < 1 || code > 255 {
+		return fmt.Errorf("exit code %d out of range, it must be between 1 and 255", code)
+	}
+	*v.p = code
+	return nil
+}
+
+func (v exitCodeValue) String() string {
+	return strconv.Itoa(*v.p)
+}
+
+func (v exitCodeValue) Type() string {
+	return "int"
+}
+
+// killGracePeriod is how long a stopped command has to exit before it's
+// killed.
+var killGracePeriod = 10 * time.Second
+
+// commandContext returns a command running args in a process group of its
+// own, see setProcessGroup. When ctx is done, the whole group is asked to
+// terminate, so the children of the command stop too, and the command is
+// killed if it's still running after killGracePeriod. The command must be
+// run with runCommand.
+func commandContext(ctx context.Context, args []string) *exec.Cmd {
+	cm := exec.CommandContext(ctx, args[0], args[1:]...)
+	setProcessGroup(cm)
+	cm.Cancel = func() error {
+		return signalProcessGroup(cm.Process, syscall.SIGTERM)
+	}
+	cm.WaitDelay = killGracePeriod
+	return cm
+}
+
+// runCommand runs a command from commandContext, forwarding the signals
+// of f to its process group, and takes the terminal back once it's done.
+func runCommand(cm *exec.Cmd, f *signalForwarder) error {
+	if err := f.start(cm); err != nil {
+		return err
+	}
+	defer restoreForeground(cm)
+	return cm.Wait()
+}
+
+// signalExitCode is the exit code shells give to commands killed by sig.
+func signalExitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
+
+// signalForwarder forwards the interrupt and termination signals skipper
+// receives to the process group of the wrapped command, which would
+// otherwise keep running after skipper exits. Until the command starts,
+// they call exit instead, so that an interrupted skipper doesn't start it.
+type signalForwarder struct {
+	mu sync.Mutex
+	p  *os.Process
+	// shared is set once the command runs in skipper's process group, see
+	// share.
+	shared bool
+	exit   func(code int)
+}
+
+func forwardSignals(exit func(code int)) *signalForwarder {
+	f := &signalForwarder{exit: exit}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for sig := range sigs {
+			f.mu.Lock()
+			p, shared := f.p, f.shared
+			if p == nil && !shared {
+				// exit doesn't return, so the command never starts.
+				f.exit(signalExitCode(sig))
+			}
+			f.mu.Unlock()
+			if shared {
+				continue
+			}
+			if err := signalProcessGroup(p, sig); err != nil {
+				logger.Debug("could not forward a signal to the command", "signal", sig, "err", err)
+			}
+		}
+	}()
+	return f
+}
+
+// start starts cm, whose process group gets the signals from then on.
+func (f *signalForwarder) start(cm *exec.Cmd) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := cm.Start(); err != nil {
+		return err
+	}
+	f.p = cm.Process
+	return nil
+}
+
+// share tells f that a command runs in skipper's process group, which
+// already gets the signals sent to the group, like Ctrl-C. f ignores the
+// signals from then on, so that skipper outlives the command.
+func (f *signalForwarder) share() {
+	f.mu.Lock()
+	f.shared = true
+	f.mu.Unlock()
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCommandContextStopsProcessGroup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no process groups on Windows")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	// The shell waits for its child, so the command only stops early if
+	// the child is terminated too.
+	cm := commandContext(ctx, []string{"sh", "-c", "sleep 10 & wait"})
+	start := time.Now()
+	if err := cm.Run(); err == nil {
+		t.Errorf("the command succeeded, want it stopped")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("the command stopped after %v, want it stopped at the timeout", d)
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no sh on Windows")
+	}
+	for _, tc := range []struct {
+		script string
+		want   int
+	}{
+		{"exit 3", 3},
+		{"kill -TERM $$", 128 + 15},
+	} {
+		cm := exec.Command("sh", "-c", tc.script)
+		err := cm.Run()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			t.Fatalf("%q: got error %v, want an exit error", tc.script, err)
+		}
+		if got := exitStatus(exitErr.ProcessState); got != tc.want {
+			t.Errorf("%q: got exit status %d, want %d", tc.script, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+// setProcessGroup makes cm start a new process group, whose ID is its PID.
+// If skipper is in the foreground of the terminal on its stdin, the new
+// group takes the terminal over, so that the command can read it and gets
+// the signals typed in it, like Ctrl-C.
+func setProcessGroup(cm *exec.Cmd) {
+	cm.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if pgrp, err := terminalProcessGroup(); err == nil && pgrp == syscall.Getpgrp() {
+		cm.SysProcAttr.Foreground = true
+		cm.SysProcAttr.Ctty = int(os.Stdin.Fd())
+	}
+}
+
+// restoreForeground puts skipper's process group back in the foreground of
+// the terminal, if cm took it over.
+func restoreForeground(cm *exec.Cmd) {
+	if cm.SysProcAttr == nil || !cm.SysProcAttr.Foreground {
+		return
+	}
+	// Background processes changing the foreground group get SIGTTOU,
+	// which stops them by default.
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	pgrp := syscall.Getpgrp()
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdin.Fd(), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&pgrp))); errno != 0 {
+		logger.Debug("could not take the terminal back", "err", errno)
+	}
+}
+
+// terminalProcessGroup returns the foreground process group of the
+// terminal on stdin. It fails if stdin isn't a terminal.
+func terminalProcessGroup() (int, error) {
+	var pgrp int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdin.Fd(), syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgrp))); errno != 0 {
+		return 0, errno
+	}
+	return int(pgrp), nil
+}
+
+// signalProcessGroup sends sig to the process group p leads.
+func signalProcessGroup(p *os.Process, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return p.Signal(sig)
+	}
+	return syscall.Kill(-p.Pid, s)
+}
+
+// exitStatus returns the exit code of a command, or the code shells give
+// to commands killed by a signal.
+func exitStatus(ps *os.ProcessState) int {
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return signalExitCode(ws.Signal())
+	}
+	return ps.ExitCode()
+}
+
+// processExists reports whether a process with the given PID is running.
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// checkSocketOwner returns an error unless the socket at path is owned by the
+// current user, since whoever listens on it decides which steps are skipped.
+func checkSocketOwner(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("the socket %v isn't owned by the current user", path)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup does nothing: Windows has no process groups that can be
+// signaled like Unix ones.
+func setProcessGroup(cm *exec.Cmd) {}
+
+// restoreForeground does nothing, see setProcessGroup.
+func restoreForeground(cm *exec.Cmd) {}
+
+// signalProcessGroup kills p, the only signal Windows can send. Its
+// children are left running.
+func signalProcessGroup(p *os.Process, sig os.Signal) error {
+	return p.Kill()
+}
+
+// exitStatus returns the exit code of a command.
+func exitStatus(ps *os.ProcessState) int {
+	return ps.ExitCode()
+}
+
+// processExists reports whether a process with the given PID is running.
+// FindProcess opens the process on Windows, which fails once it exited.
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+// checkSocketOwner does nothing: sockets in the user cache directory, the
+// default, are protected by its permissions.
+func checkSocketOwner(path string) error {
+	return nil
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/yourbase/skipper/signing"
+	"github.com/yourbase/skipper/upload"
+)
+
+// latestGraphName is the file of the destinations of "skipper graph push"
+// holding the name of the latest graph pushed.
+const latestGraphName = "latest"
+
+var graphPushKeyFlag string
+
+var graphPushCmd = &cobra.Command{
+	Use:   "push <dest URL>",
+	Short: "Upload the base build report to a bucket or HTTP server",
+	Long: `Uploads the build report of --dep-graph, gzipped, under the destination URL,
+named after the SHA-256 hash of its contents, like <hash>.json.gz. The name is
+then written to the "latest" file next to it, so that later builds can pull
+the freshest graph. It's meant to run at the end of base builds in CI.
+
+With --key or the "graph_signing_key" config key, the upload is signed with
+that ed25519 private key and its signature uploaded next to it, like
+<hash>.json.gz.sig. When graphs must be signed, with --graph-public-key or the
+"graph_public_key" config key, nothing is pushed without a signing key.
+
+The destination is one of:
+
+	s3://bucket/prefix    authenticated with AWS_ACCESS_KEY_ID,
+	                      AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, in
+	                      AWS_REGION, through AWS_ENDPOINT_URL if set
+	gs://bucket/prefix    authenticated with GOOGLE_OAUTH_ACCESS_TOKEN, like
+	                      the output of "gcloud auth print-access-token"
+	https://host/prefix   uploaded with PUT requests, authenticated with the
+	                      credentials of the URL, if any`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if isSQLiteGraph(graphFileFlag) || filepath.Ext(graphFileFlag) == ".bin" {
+			fmt.Fprintln(os.Stderr, "Only build reports can be pushed")
+			os.Exit(1)
+		}
+		name, size, err := pushGraph(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not push the base graph: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("skipper: pushed %d bytes to %v/%v\n", size, strings.TrimSuffix(args[0], "/"), name)
+	},
+}
+
+// pushGraph uploads the build reports of --dep-graph to dest, signed if a
+// signing key is set, and points the latest file of dest to them. It returns
+// the name of the upload and its size.
+func pushGraph(dest string) (string, int64, error) {
+	keyFile := graphPushKeyFlag
+	if keyFile == "" {
+		keyFile = viper.GetString("graph_signing_key")
+	}
+	if keyFile == "" && graphPublicKeyFile() != "" {
+		return "", 0, errors.New("graphs must be signed, but no signing key is set with --key or the graph_signing_key config key")
+	}
+	r, err := openBuildReports(graphFileFlag)
+	if err != nil {
+		return "", 0, err
+	}
+	defer r.Close()
+	tmp, err := ioutil.TempFile("", "skipper-push-*.json.gz")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	h := sha256.New()
+	gz := gzip.NewWriter(tmp)
+	if _, err := io.Copy(io.MultiWriter(gz, h), r); err != nil {
+		return "", 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return "", 0, err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	name := hex.EncodeToString(h.Sum(nil)) + ".json.gz"
+	if err := upload.Put(dest, name, tmp, size); err != nil {
+		return "", 0, err
+	}
+	if keyFile != "" {
+		if err := pushSignature(dest, name, tmp.Name(), keyFile); err != nil {
+			return "", 0, fmt.Errorf("could not sign the graph: %v", err)
+		}
+	}
+	// Only once the graph is complete.
+	latest := name + "\n"
+	if err := upload.Put(dest, latestGraphName, strings.NewReader(latest), int64(len(latest))); err != nil {
+		return "", 0, err
+	}
+	return name, size, nil
+}
+
+// pushSignature signs file with the private key in keyFile, and uploads the
+// signature to dest as the one of name.
+func pushSignature(dest, name, file, keyFile string) error {
+	if err := signFile(file, keyFile); err != nil {
+		return err
+	}
+	defer os.Remove(signing.SigPath(file))
+	sig, err := ioutil.ReadFile(signing.SigPath(file))
+	if err != nil {
+		return err
+	}
+	return upload.Put(dest, signing.SigPath(name), bytes.NewReader(sig), int64(len(sig)))
+}
+
+func init() {
+	graphPushCmd.Flags().StringVar(&graphPushKeyFlag, "key", "", "PEM file of the ed25519 private key to sign the upload with. Defaults to the \"graph_signing_key\" config key")
+	graphCmd.AddCommand(graphPushCmd)
+}
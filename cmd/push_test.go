@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/yourbase/skipper/signing"
+)
+
+func TestPushGraph(t *testing.T) {
+	uploads := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uploads[r.URL.Path], _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+	report := `{"SkipperReport":1}
+{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}
+`
+	file := filepath.Join(t.TempDir(), "graph.json")
+	if err := ioutil.WriteFile(file, []byte(report), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(g string) { graphFileFlag = g }(graphFileFlag)
+	graphFileFlag = file
+
+	name, size, err := pushGraph(srv.URL + "/graphs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(report))
+	if want := hex.EncodeToString(sum[:]) + ".json.gz"; name != want {
+		t.Errorf("got name %v, wanted %v", name, want)
+	}
+	if got := string(uploads["/graphs/latest"]); got != name+"\n" {
+		t.Errorf("got latest %q, wanted %v", got, name)
+	}
+	gzipped := uploads["/graphs/"+name]
+	if int64(len(gzipped)) != size {
+		t.Errorf("uploaded %d bytes, but reported %d", len(gzipped), size)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(gzipped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(zr); err != nil || string(b) != report {
+		t.Errorf("got report %q, %v", b, err)
+	}
+
+	// The same contents have the same name.
+	if again, _, err := pushGraph(srv.URL + "/graphs"); err != nil || again != name {
+		t.Errorf("pushing again: got %v, %v, wanted %v", again, err, name)
+	}
+}
+
+func TestPushGraphSigned(t *testing.T) {
+	uploads := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploads[r.URL.Path], _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "graph.json")
+	if err := ioutil.WriteFile(file, []byte(`{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, err := signing.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile, pubFile := filepath.Join(dir, "key"), filepath.Join(dir, "key.pub")
+	if err := ioutil.WriteFile(keyFile, priv, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pubFile, pub, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := signFile(file, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	defer func(g, k string) { graphFileFlag, graphPushKeyFlag = g, k }(graphFileFlag, graphPushKeyFlag)
+	graphFileFlag = file
+	viper.Set("graph_public_key", pubFile)
+	defer viper.Set("graph_public_key", nil)
+
+	if _, _, err := pushGraph(srv.URL); err == nil || len(uploads) > 0 {
+		t.Fatalf("pushed %d files without a signing key when graphs must be signed, error %v", len(uploads), err)
+	}
+	graphPushKeyFlag = keyFile
+	name, _, err := pushGraph(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The pulled graph verifies against the public key.
+	pulled := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(pulled, uploads["/"+name], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(signing.SigPath(pulled), uploads["/"+name+".sig"], 0644); err != nil {
+		t.Fatal(err)
+	}
+	key, err := signing.ReadPublicKey(pubFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signing.VerifyFile(pulled, key); err != nil {
+		t.Error(err)
+	}
+}
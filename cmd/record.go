@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yourbase/skipper/builddata"
+	"github.com/yourbase/skipper/outputcache"
+	"github.com/yourbase/skipper/recorder"
+	"github.com/yourbase/skipper/recorder/dtrace"
+	"github.com/yourbase/skipper/recorder/ebpf"
+	"github.com/yourbase/skipper/recorder/fuse"
+	"github.com/yourbase/skipper/recorder/preload"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+var (
+	recorderFlag     string
+	recordOutputFlag string
+	recordStoreFlag  bool
+)
+
+var recordCmd = &cobra.Command{
+	Use:   "record -- <cmd>",
+	Short: "Record a build's file accesses into a base dependency graph",
+	Long: `Runs the command while tracing the files read and written by it and its
+child processes, and writes the result as a build report that can be used as
+--dep-graph by later builds. Steps wrapped with "skipper --" inside the build
+are recorded as separate steps, along with the environment variables of the
+"env_allowlist" config key they run with, and the fingerprints of the tools of
+the "toolchains" config key they run, so that steps run again when their
+compiler or interpreter changes. Entries are tagged with the build
+ID, given with --id or generated, which "skipper graph prune" uses to find
+stale steps.
+
+With --store, the build report is saved in the graph store, the directory of
+the "graph_store" config key or ~/.skipper/graphs, under the build ID, for
+later builds to select with --base-id, unless the command fails. With
+--remote-cache, it's stored in the remote cache too, for builds on other
+machines.
+
+With --output-cache, the files written by each step in the workspace are
+stored in the cache under the build ID, to be restored when the step is
+skipped by a build using this build's graph. With --remote-cache, they're
+stored in the remote cache too.
+
+The output is compressed according to its extension: gzipped if it ends with
+.gz, or compressed with the zstd or xz tools if it ends with .zst or .xz.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rec, err := newRecorder(recorderFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		out := recordOutputFlag
+		if recordStoreFlag {
+			if out != "" {
+				fmt.Fprintln(os.Stderr, "Only one of -o and --store can be given")
+				os.Exit(1)
+			}
+			if out, err = storedReportFile(); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not store the build report: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if out == "" {
+			if out, err = singleGraphFile(); err != nil {
+				fmt.Fprintf(os.Stderr, "%v, or -o must be given\n", err)
+				os.Exit(1)
+			}
+		}
+		n, err := recordBuild(rec, args, out, outputCache())
+		if err != nil && recordStoreFlag {
+			// Don't leave the graph of a failed build, or a broken one,
+			// for --base-id latest.
+			os.RemoveAll(filepath.Dir(out))
+		}
+		if err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				if recordStoreFlag {
+					fmt.Fprintf(os.Stderr, "skipper: the command failed, so its build report isn't stored: %v\n", err)
+				} else {
+					fmt.Fprintf(os.Stderr, "skipper: recorded %d entries to %v, but the command failed: %v\n", n, out, err)
+				}
+				os.Exit(exitErr.ExitCode())
+			}
+			fmt.Fprintf(os.Stderr, "skipper: could not record %q: %v\n", args, err)
+			os.Exit(1)
+		}
+		fmt.Printf("skipper: recorded %d entries to %v\n", n, out)
+		if c := remoteCache(); c != nil && recordStoreFlag {
+			if err := pushStoredGraph(c, filepath.Dir(filepath.Dir(out)), buildIDFlag); err != nil {
+				fmt.Fprintf(os.Stderr, "skipper: could not store the build report in the remote cache: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+func defaultRecorder() string {
+	if runtime.GOOS == "darwin" {
+		return "dtrace"
+	}
+	return "strace"
+}
+
+func newRecorder(name string) (recorder.Recorder, error) {
+	switch name {
+	case "strace":
+		return recorder.Strace{}, nil
+	case "ebpf":
+		return ebpf.Recorder{}, nil
+	case "dtrace":
+		return dtrace.Recorder{}, nil
+	case "preload":
+		return preload.Recorder{}, nil
+	case "fuse":
+		return fuse.Recorder{}, nil
+	}
+	return nil, fmt.Errorf("unknown recorder %q", name)
+}
+
+// reportBuildID returns the build ID for the entries of a new build report:
+// --id, the build ID of the environment, or a new one.
+func reportBuildID() (string, error) {
+	if buildIDFlag != "" {
+		return buildIDFlag, nil
+	}
+	if id, _ := buildIDFromEnv(); id != "" {
+		return id, nil
+	}
+	return newBuildULID()
+}
+
+// storedReportFile returns the file of the graph store to record the build
+// report to, creating its directory. It sets --id, so that the entries are
+// tagged with the build ID the report is stored under.
+func storedReportFile() (string, error) {
+	id, err := reportBuildID()
+	if err != nil {
+		return "", err
+	}
+	dir, err := graphStoreDir()
+	if err != nil {
+		return "", err
+	}
+	file, err := storedGraphFile(dir, id)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return "", err
+	}
+	buildIDFlag = id
+	return file, nil
+}
+
+// recordBuild records args with rec and writes the build report to out. If
+// cache isn't nil, the files written by each step are stored in it. It
+// returns the number of entries written.
+func recordBuild(rec recorder.Recorder, args []string, out string, cache *outputcache.Cache) (int, error) {
+	buildID, err := reportBuildID()
+	if err != nil {
+		return 0, err
+	}
+	report, err := builddata.CreateFile(out)
+	if err != nil {
+		return 0, err
+	}
+	defer report.Discard()
+	if err := stepselection.WriteRecordedReportHeader(report, time.Now(), gitOutput("rev-parse", "HEAD")); err != nil {
+		return 0, err
+	}
+	// The skipper wrappers of the build report the environment of their
+	// steps in envFile.
+	envFile, err := os.CreateTemp("", "skipper-env-*.json")
+	if err != nil {
+		return 0, err
+	}
+	envFile.Close()
+	defer os.Remove(envFile.Name())
+	if err := os.Setenv(envReportEnv, envFile.Name()); err != nil {
+		return 0, err
+	}
+	defer os.Unsetenv(envReportEnv)
+	root := stepselection.CmdTree{strings.Join(args, " ")}
+	rootStep := false
+	n := 0
+	// outputs holds the files written by each step, including the files
+	// written by its descendants, since skipping a step skips them too.
+	outputs := map[string][]string{}
+	tools := newToolchainFingerprints(toolchainCommands())
+	recordErr := rec.Record(context.Background(), args, func(bog *stepselection.BuildLog) error {
+		n++
+		bog.BuildID = buildID
+		rootStep = rootStep || len(bog.CmdTree) == 1 && bog.CmdTree[0] == root[0]
+		if cache != nil && bog.Mode == "W" {
+			for i := range bog.CmdTree {
+				name := stepselection.CmdTree(bog.CmdTree[:i+1]).Name()
+				outputs[name] = append(outputs[name], bog.File)
+			}
+		}
+		if tool := tools.entry(bog); tool != nil {
+			n++
+			if err := report.Encode(tool); err != nil {
+				return err
+			}
+		}
+		return report.Encode(bog)
+	})
+	if _, ok := recordErr.(*exec.ExitError); recordErr != nil && !ok {
+		return n, recordErr
+	}
+	emitEnv := func(bog *stepselection.BuildLog) error {
+		n++
+		bog.BuildID = buildID
+		return report.Encode(bog)
+	}
+	if err := readEnvReport(envFile.Name(), emitEnv); err != nil {
+		return n, fmt.Errorf("could not read the environment of the steps: %v", err)
+	}
+	if rootStep {
+		// The recorded command is a step of its own when it doesn't use
+		// skipper wrappers.
+		for _, bog := range stepEnvEntries(root) {
+			if err := emitEnv(bog); err != nil {
+				return n, err
+			}
+		}
+	}
+	for step, files := range outputs {
+		if _, err := cache.Store(buildID, step, files); err != nil {
+			return n, fmt.Errorf("could not cache the outputs of %v: %v", step, err)
+		}
+	}
+	if err := report.Close(); err != nil {
+		return n, err
+	}
+	return n, recordErr
+}
+
+func init() {
+	recordCmd.Flags().StringVar(&recorderFlag, "recorder", defaultRecorder(), "how to trace the build: on Linux, \"strace\", \"ebpf\", which is faster but needs root and bpftrace, \"preload\", which needs neither root nor ptrace but only sees dynamically linked programs, or \"fuse\", which needs /dev/fuse and only sees the workspace; on macOS, \"dtrace\", which needs root")
+	recordCmd.Flags().StringVarP(&recordOutputFlag, "output", "o", "", "where to write the build report (default is --dep-graph)")
+	recordCmd.Flags().BoolVar(&recordStoreFlag, "store", false, "save the build report in the graph store under the build ID, instead of -o")
+	rootCmd.AddCommand(recordCmd)
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/yourbase/skipper/journal"
+)
+
+var (
+	reportJSONFlag bool
+	reportTopFlag  int
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Summarize the decisions of the current build",
+	Long: `Prints a Markdown summary of the decisions journaled in --journal-dir for the
+build given by --id, the environment or the build ID file, fit for a pull
+request comment: the number of steps evaluated and skipped, an estimate of the
+time saved, based on how long the skipped steps took when they ran in any
+build, and the top reasons steps ran. Reasons are counted without the step
+they're about, so that steps that ran because of the same changed file are
+counted together.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if journalDirFlag == "" {
+			fmt.Fprintln(os.Stderr, "The journal is disabled, set --journal-dir")
+			os.Exit(1)
+		}
+		idFile, err := buildIDFile()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not find the build ID file: %v\n", err)
+			os.Exit(1)
+		}
+		id, err := currentBuildID(idFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := writeBuildReport(os.Stdout, journalDirFlag, id, reportJSONFlag, reportTopFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not report on build %v: %v\n", id, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// writeBuildReport writes the report of the build id journaled in dir to w,
+// as Markdown or JSON.
+func writeBuildReport(w io.Writer, dir, id string, asJSON bool, top int) error {
+	history, err := journal.Read(dir)
+	if err != nil {
+		return err
+	}
+	r := journal.BuildReport(id, history)
+	if !asJSON {
+		return r.WriteMarkdown(w, top)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(r)
+}
+
+func init() {
+	reportCmd.Flags().BoolVar(&reportJSONFlag, "json", false, "print the report as JSON, with every reason")
+	reportCmd.Flags().IntVar(&reportTopFlag, "top", 5, "number of top reasons steps ran to list")
+	rootCmd.AddCommand(reportCmd)
+}
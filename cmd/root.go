@@ -2,12 +2,19 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
@@ -15,15 +22,45 @@ import (
 	"github.com/oklog/ulid"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"github.com/yourbase/skipper/builddata"
+	"github.com/yourbase/skipper/changes"
+	"github.com/yourbase/skipper/journal"
 	"github.com/yourbase/skipper/stepselection"
+	"github.com/yourbase/skipper/tracing"
 )
 
 var (
-	cfgFileFlag     string
-	buildIDFlag     string
-	graphFileFlag   string
-	changesFileFlag string
+	cfgFileFlag        string
+	buildIDFlag        string
+	graphFileFlag      string
+	changesFileFlag    string
+	manifestFlag       string
+	tagsFlag           []string
+	overlayFlag        []string
+	coverageFlag       []string
+	changesGitFlag     string
+	changedFileFlag    []string
+	changeProviderFlag string
+	socketFlag         string
+
+	decisionExitCodesFlag bool
+	skipExitCodeFlag      int
+	outputCacheFlag       string
+	remoteCacheFlag       string
+	streamGraphFlag       bool
+	skipCorruptLinesFlag  bool
+	journalDirFlag        string
+	onUnknownStepFlag     string
+	graphRootFlag         string
+	workspaceRootFlag     string
+	toolchainChangedFlag  bool
+	graphPublicKeyFlag    string
+	timeoutFlag           time.Duration
+	forceRunFlag          bool
+	learnDirFlag          string
+
+	// changesFileGiven is whether --changes was given on the command line,
+	// rather than its default.
+	changesFileGiven bool
 )
 
 // Skipper needs to be run with a --id <buildId>. If that flag wasn't set, we spawn a child skipper process with that flag.
@@ -51,16 +88,101 @@ func newBuildULID() (string, error) {
 // I don't love the .txt extension but I didn't want to use /yourbase because
 // that would prevent us from having a directory with that name.
 // Also this should probably be set in the flag definition and not here.
-const buildIDFilePath = "~/yourbase.txt"
+var buildIDFilePath = defaultBuildIDFilePath()
 
-// buildULIDFromFile looks for a /yourbase file and check if it contains an
-// ULID. If it contains anything but an ULID, it's an error. If the file
-// doesn't exist or it's empty, returns an empty ULID and nil error.
-func buildULIDFromFile() (string, error) {
-	f, err := homedir.Expand(buildIDFilePath)
-	if err != nil {
-		return "", err
+func defaultBuildIDFilePath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(dataDir(), "build-id.txt")
 	}
+	return "~/yourbase.txt"
+}
+
+// dataDir is where skipper finds the base graph and the changes by default:
+// the root directory, where CI images put them, or %LOCALAPPDATA%\skipper
+// on Windows, which has no shared root directory.
+func dataDir() string {
+	if runtime.GOOS == "windows" {
+		if dir, err := os.UserCacheDir(); err == nil {
+			return filepath.Join(dir, "skipper")
+		}
+	}
+	return string(filepath.Separator)
+}
+
+// buildIDEnvs are the environment variables holding the build ID, in order
+// of preference. Besides skipper's own, they're the run identifiers of common
+// CI systems, which are the same for every step of a CI build. attempt, if
+// set, names the variable that tells reruns of the same build apart.
+//
+// "skipper graph prune" expects build IDs to sort by time, like ULIDs do. CI
+// run numbers only do while they have the same number of digits.
+var buildIDEnvs = []struct{ name, attempt string }{
+	{"SKIPPER_BUILD_ID", ""},
+	{"GITHUB_RUN_ID", "GITHUB_RUN_ATTEMPT"},
+	{"CI_PIPELINE_ID", ""},
+	{"BUILDKITE_BUILD_ID", ""},
+	{"CIRCLE_WORKFLOW_ID", ""},
+}
+
+// buildIDFromEnv returns the build ID given by the environment, and the
+// variable it comes from. It returns an empty ID if there's none.
+func buildIDFromEnv() (id, name string) {
+	for _, e := range buildIDEnvs {
+		id := os.Getenv(e.name)
+		if id == "" {
+			continue
+		}
+		if e.attempt != "" {
+			if a := os.Getenv(e.attempt); a != "" {
+				id += "-" + a
+			}
+		}
+		return id, e.name
+	}
+	return "", ""
+}
+
+// projectBuildIDFile is where the build ID of a project is kept, relative to
+// the project's root directory.
+const projectBuildIDFile = ".skipper/build-id"
+
+// buildIDFile returns the file holding the current build ID. Inside a
+// project, that is under a directory with a .skipper directory or a git
+// repository, it's the project's own file, so that concurrent builds of
+// different projects don't clobber each other's IDs. Elsewhere it's
+// buildIDFilePath.
+func buildIDFile() (string, error) {
+	if cwd, err := os.Getwd(); err == nil {
+		if root, ok := projectRoot(cwd); ok {
+			return filepath.Join(root, filepath.FromSlash(projectBuildIDFile)), nil
+		}
+	}
+	return homedir.Expand(buildIDFilePath)
+}
+
+// projectRoot returns the closest directory to dir, dir included, that has a
+// .skipper directory or a .git directory or file.
+func projectRoot(dir string) (string, bool) {
+	for {
+		if fi, err := os.Stat(filepath.Join(dir, ".skipper")); err == nil && fi.IsDir() {
+			return dir, true
+		}
+		// .git is a file in worktrees and submodules.
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// buildULIDFromFile checks if the file f contains an ULID. If it contains
+// anything but an ULID, it's an error. If the file doesn't exist or it's
+// empty, returns an empty ULID and nil error.
+func buildULIDFromFile(f string) (string, error) {
 	content, err := ioutil.ReadFile(f)
 	if os.IsNotExist(err) || len(content) == 0 {
 		return "", nil
@@ -69,37 +191,103 @@ func buildULIDFromFile() (string, error) {
 	return strings.TrimSpace(string(content)), nil
 }
 
-func saveBuildULID(id string) error {
-	fp, err := homedir.Expand(buildIDFilePath)
-	if err != nil {
+// saveBuildULID replaces the build ID in the file fp with id. The new file
+// is written next to it and renamed over it, so readers never see a partial
+// ID. Callers hold the lock of the file, see lockBuildIDFile.
+func saveBuildULID(fp, id string) error {
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
 		return err
 	}
-	f, err := os.OpenFile(fp, os.O_TRUNC|os.O_RDWR|os.O_CREATE, 0700)
+	f, err := ioutil.TempFile(filepath.Dir(fp), filepath.Base(fp)+".tmp*")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer os.Remove(f.Name())
 	// No trailing newline, makes things simpler for programs.
-	_, err = io.WriteString(f, id)
-	return err
+	if _, err := io.WriteString(f, id); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), fp)
+}
+
+// stepPathEnv is the environment variable through which skipper passes the
+// CmdTree of a step to the commands it runs. Skipper invocations nested in the
+// step prepend it to their own step, so their names match the nested CmdTrees
+// in the graph.
+const stepPathEnv = "SKIPPER_STEP_PATH"
+
+// disableEnv set to 1 makes skipper run every step it wraps, like
+// --force-run. Unlike the flag, it's inherited by the skipper invocations
+// nested in the steps.
+const disableEnv = "SKIPPER_DISABLE"
+
+// forcedRun returns why steps run regardless of the decision, with
+// --force-run or SKIPPER_DISABLE=1, or "" if they don't.
+func forcedRun() string {
+	switch {
+	case forceRunFlag:
+		return "--force-run"
+	case os.Getenv(disableEnv) == "1":
+		return disableEnv + "=1"
+	}
+	return ""
 }
 
+// currentStepName returns the CmdTree of the step running args, including
+// the steps of the skipper invocations it's nested in. A step override with
+// an alias renames the step to its name in the graph.
 func currentStepName(args []string) ([]string, error) {
-		// XXX missing parents
-		stepName := strings.Join(args, " ")
-		return []string{stepName}, nil
+	stepName := strings.Join(args, " ")
+	var parents []string
+	if path := os.Getenv(stepPathEnv); path != "" {
+		if err := json.Unmarshal([]byte(path), &parents); err != nil {
+			return nil, fmt.Errorf("invalid %v %q: %v", stepPathEnv, path, err)
+		}
+	}
+	overrides, err := stepOverrides()
+	if err != nil {
+		return nil, err
+	}
+	return stepselection.AliasStep(overrides, append(parents, stepName)), nil
 }
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "skipper",
 	Short: "A program that can skip unnecessary build steps",
-	Long:  `A program that looks at a project's build graph and skips unnecessary build steps.`,
+	Long: `A program that looks at a project's build graph and skips unnecessary build steps.
+
+When OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set,
+wrapped steps are traced: a span per invocation, with the graph load, the
+decision and the wrapped command as children, exported with OTLP over HTTP.`,
+	// Anything that isn't a subcommand is the command being wrapped.
+	Args: cobra.ArbitraryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
 			return
 		}
+		// Loading the graph may take a while. If skipper is interrupted
+		// meanwhile, it stops right away without running the command. The
+		// decompressors and other helpers it started are in its process
+		// group, which a parent skipper signals along with it.
+		signals := forwardSignals(func(code int) {
+			logger.Warn("interrupted before running the command")
+			exitTraced(code)
+		})
 
+		if buildIDFlag == "" {
+			// There's no need for a child skipper if the environment
+			// already identifies the build.
+			if id, name := buildIDFromEnv(); id != "" {
+				logger.Debug("using the build ID from the environment", "id", id, "env", name)
+				buildIDFlag = id
+			}
+		}
+		buildID := buildIDFlag
 		parentSkipper := false
 		// If we have trouble fork-bombing ourselves, we can add a
 		// check to look at the parent process of the current process
@@ -108,74 +296,299 @@ var rootCmd = &cobra.Command{
 		// mess-up on the flag-passing + flag-parsing logic.
 		if buildIDFlag == "" {
 			parentSkipper = true
-			// TODO: Inspect /yourbase file first. Also update it otherwise.
-			id, err := buildULIDFromFile()
+			idFile, err := buildIDFile()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Unexpected error reading from %v: %v\n", buildIDFilePath, err)
+				fmt.Fprintf(os.Stderr, "Could not find the build ID file: %v\n", err)
 				os.Exit(1)
 			}
-			if id == "" {
-				id, err = newBuildULID()
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Could not create a new build ID: %v\n", err)
-					os.Exit(1)
-				}
-				if err = saveBuildULID(id); err != nil {
-					fmt.Fprintf(os.Stderr, "Could not save build ULID to %v: %v\n", buildIDFilePath, err)
-					os.Exit(1)
-				}
+			// Parallel steps started without an ID must agree on it.
+			id, err := loadOrCreateBuildULID(idFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Could not get the build ID from %v: %v\n", idFile, err)
+				os.Exit(1)
 			}
 			// TODO: Write to buildULIDFilePath.
+			buildID = id
 
 			// os.Args, not args because args is incomplete for us.
 			args = childSkipperArgs(id, os.Args)
 		}
+		// env is the environment of the wrapped command, which defaults to
+		// ours.
+		var env []string
+		// entry is the decision journaled once the step is skipped or
+		// done running.
+		var entry *journal.Entry
+		// learnTree is the step re-recorded when it runs, if learning.
+		var learnTree stepselection.CmdTree
 		run := func() {
-			cm := exec.Command(args[0], args[1:]...)
+			invocationSpan.SetAttr("skipper.run", true)
+			span := tracer.Start("run", invocationSpan)
+			span.SetAttr("process.command_line", strings.Join(args, " "))
+			cmdCtx := context.Background()
+			// A parent skipper leaves the timeout to its child, which
+			// runs the command itself, so that loading the graph doesn't
+			// count.
+			if timeoutFlag > 0 && !parentSkipper {
+				var cancel context.CancelFunc
+				cmdCtx, cancel = context.WithTimeout(cmdCtx, timeoutFlag)
+				defer cancel()
+			}
+			cm := commandContext(cmdCtx, args)
+			cm.Env = env
+			if tracer != nil {
+				if cm.Env == nil {
+					cm.Env = os.Environ()
+				}
+				// Nested skippers continue the trace under this span.
+				cm.Env = append(cm.Env, tracing.TraceparentEnv+"="+span.Traceparent())
+			}
+			// A child skipper may read --changes or --dep-graph from
+			// stdin first, the command gets the rest.
+			cm.Stdin = os.Stdin
 			cm.Stdout = os.Stdout
 			cm.Stderr = os.Stderr
-			if err := cm.Run(); err != nil {
+			start := time.Now()
+			var err error
+			if learnTree != nil {
+				// The recorder runs the command in skipper's process
+				// group, which gets the signals typed in the terminal.
+				signals.share()
+				err = learnStep(cmdCtx, learnDir(), buildIDFlag, learnTree, args)
+			} else {
+				err = runCommand(cm, signals)
+			}
+			if entry != nil {
+				entry.Run, entry.Duration = true, time.Since(start)
+				journalDecision(entry)
+			}
+			if cm.ProcessState != nil {
+				span.SetAttr("process.exit_code", cm.ProcessState.ExitCode())
+			}
+			span.SetError(err)
+			span.End()
+			if errors.Is(cmdCtx.Err(), context.DeadlineExceeded) {
+				fmt.Fprintf(os.Stderr, "%v timed out after %v\n", strings.Join(args, " "), timeoutFlag)
+				exitTraced(timedOutExitCode)
+			}
+			if err != nil {
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					// Pass the exit status through, including the skip
+					// exit code of a child skipper.
+					exitTraced(exitStatus(exitErr.ProcessState))
+				}
 				fmt.Fprintln(os.Stderr, err.Error())
-				os.Exit(1)
+				exitTraced(1)
 			}
 		}
 		if parentSkipper {
+			startTrace(buildID, "")
+			defer finishTrace()
 			run()
 			return
 		}
 		stepName, err := currentStepName(args)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "skipper: decided to run because could not determine current step name: %v\n", err)
+			startTrace(buildIDFlag, "")
+			defer finishTrace()
+			logger.Warn("running because the current step name could not be determined", "err", err)
 			run()
 			return
 		}
+		stepID := stepselection.CmdTree(stepName).Name()
+		startTrace(buildIDFlag, stepID)
+		defer finishTrace()
+		entry = &journal.Entry{BuildID: buildIDFlag, Time: time.Now(), Step: stepID}
+		if err := reportStepEnv(stepName); err != nil {
+			logger.Warn("could not report the environment of the step", "step", stepID, "err", err)
+		}
+		if learnDir() != "" {
+			learnTree = stepName
+		}
+		env = append(os.Environ(), stepPathEnv+"="+stepID)
+		if len(tagsFlag) > 0 {
+			tagger, err := stepTagger()
+			if err != nil {
+				logger.Warn("running because the step tags could not be loaded", "step", stepID, "err", err)
+				run()
+				return
+			}
+			if !tagger.HasAnyTag(stepName, tagsFlag) {
+				logger.Info("running because the step has none of the tags", "step", stepID, "tags", strings.Join(tagsFlag, ","))
+				run()
+				return
+			}
+		}
+		changed, err := changedNodes()
+		if err != nil {
+			logger.Warn("running because the changed files could not be determined", "step", stepID, "err", err)
+			run()
+			return
+		}
+		// duration is how long the step took in the base build, or zero
+		// if unknown, and base the ID of the build it was recorded in,
+		// whose outputs are restored when it's skipped.
+		decided := func(shouldRun bool, reason string, duration time.Duration, base string, err error) {
+			if reason != "" {
+				invocationSpan.SetAttr("skipper.reason", reason)
+			}
+			if errors.Is(err, stepselection.ErrUnknownStep) {
+				entry.Unknown = true
+				policy, perr := unknownStepPolicy()
+				switch {
+				case perr != nil:
+					logger.Warn("running because of a configuration error", "step", stepID, "err", perr)
+					run()
+					return
+				case policy == "fail" && forcedRun() == "":
+					fmt.Fprintf(os.Stderr, "Step %v isn't in the base dependency graph %v\n", stepID, graphFileFlag)
+					exitTraced(1)
+				case policy == "skip":
+					logger.Warn("skipping because the step isn't in the base dependency graph", "step", stepID, "unknown_step", true)
+					shouldRun, err = false, nil
+				default:
+					logger.Warn("running because the step isn't in the base dependency graph", "step", stepID, "unknown_step", true)
+					entry.Reason = "unknown step"
+					run()
+					return
+				}
+			}
+			if err != nil {
+				logger.Warn("running because the decision failed", "step", stepID, "err", err)
+				run()
+				return
+			}
+			if shouldRun {
+				entry.Reason = reason
+				logger.Info("decided that we should run", "step", stepID, "reason", reason)
+				run()
+				return
+			}
+			if forced := forcedRun(); forced != "" {
+				// The decision is still logged, to find bad skips.
+				entry.Reason = "forced by " + forced
+				logger.Info("running although we decided we should skip", "step", stepID, "reason", reason, "forced_by", forced)
+				run()
+				return
+			}
+			if cache := outputCache(); cache != nil {
+				outputs, err := cache.Restore(base, stepID)
+				switch {
+				case os.IsNotExist(err):
+					// The step was never recorded with a cache.
+				case err != nil:
+					logger.Warn("running because the step outputs could not be restored", "step", stepID, "err", err)
+					run()
+					return
+				default:
+					logger.Info("restored outputs from the output cache", "step", stepID, "outputs", len(outputs))
+				}
+			}
+			attrs := []any{"step", stepID}
+			if duration > 0 {
+				attrs = append(attrs, "saves", duration.Round(time.Second))
+			}
+			if reason != "" {
+				// Like the step override that skips it.
+				entry.Reason = reason
+				attrs = append(attrs, "reason", reason)
+			}
+			logger.Info("decided we should skip", attrs...)
+			journalDecision(entry)
+			invocationSpan.SetAttr("skipper.run", false)
+			if decisionExitCodesFlag {
+				exitTraced(skipExitCodeFlag)
+			}
+		}
+		span := tracer.Start("decide", invocationSpan)
+		if resp, err := queryDaemon(stepName, changed); err == nil {
+			span.SetAttr("skipper.daemon", true)
+			var decisionErr error
+			switch {
+			case resp.Unknown:
+				decisionErr = fmt.Errorf("%w: %v", stepselection.ErrUnknownStep, stepName)
+			case resp.Error != "":
+				decisionErr = errors.New(resp.Error)
+			}
+			span.SetError(decisionErr)
+			span.End()
+			setGraphDigest(resp.GraphDigest)
+			decided(resp.Run, resp.Reason, resp.Duration, resp.Build, decisionErr)
+			return
+		}
 		// TODO(nictuku): is there a better moment to create this?
 		// Perhaps if the skipper becomes noticeably slow, we can move
-		// steps like this to asynchronous ones.
-		skipCheck, err := newStepSkipper(graphFileFlag, changesFileFlag)
+		// steps like this to asynchronous ones. Or run "skipper daemon".
+		opts, err := graphOptions()
+		if err != nil {
+			span.SetError(err)
+			span.End()
+			logger.Warn("running because of a configuration error", "step", stepID, "err", err)
+			run()
+			return
+		}
+		alwaysRun, tagger, err := alwaysRunRules()
+		if err != nil {
+			span.SetError(err)
+			span.End()
+			logger.Warn("running because of a configuration error", "step", stepID, "err", err)
+			run()
+			return
+		}
+		network, tagger, err := networkPolicy(tagger)
+		if err != nil {
+			span.SetError(err)
+			span.End()
+			logger.Warn("running because of a configuration error", "step", stepID, "err", err)
+			run()
+			return
+		}
+		loadSpan := tracer.Start("load graph", span)
+		skipCheck, err := newStepSkipper(graphFileFlag, changed, stepselection.CmdTree(stepName), opts...)
+		loadSpan.SetError(err)
+		loadSpan.End()
 		if err != nil {
+			span.SetError(err)
+			span.End()
 			if os.IsNotExist(err) {
-				fmt.Printf("skipper: defaulting to running command %q because the base dependency graph is missing\n", args)
+				logger.Info("running because the base dependency graph is missing", "step", stepID, "graph", graphFileFlag)
 			} else {
-				fmt.Fprintf(os.Stderr, "skipper: defaulting to running command %q because could not open base dependency graph: %v\n", args, err)
+				logger.Warn("running because the base dependency graph could not be opened", "step", stepID, "err", err)
 			}
 			run()
 			return
 		}
-		shouldRun, err := skipCheck.shouldRun(stepName)
+		overrides, err := stepOverrides()
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err.Error())
-			fmt.Printf("skipper: could not decide if we should run %q. Falling back to running\n", stepName)
+			span.SetError(err)
+			span.End()
+			logger.Warn("running because of a configuration error", "step", stepID, "err", err)
 			run()
 			return
 		}
-		if shouldRun {
-			fmt.Printf("skipper: decided that we should run: %q\n", stepName)
+		stale, err := staleGraphReason(graphFileFlag)
+		if err != nil {
+			span.SetError(err)
+			span.End()
+			logger.Warn("running because of a configuration error", "step", stepID, "err", err)
 			run()
 			return
 		}
-		fmt.Printf("skipper: decided we should skip: %q\n", stepName)
-		return
+		invalidateAll, err := invalidateAllMatcher()
+		if err != nil {
+			span.SetError(err)
+			span.End()
+			logger.Warn("running because of a configuration error", "step", stepID, "err", err)
+			run()
+			return
+		}
+		skipCheck.alwaysRun, skipCheck.network, skipCheck.tagger = alwaysRun, network, tagger
+		skipCheck.overrides, skipCheck.stale, skipCheck.invalidateAll = overrides, stale, invalidateAll
+		shouldRun, reason, err := skipCheck.shouldRun(stepName)
+		span.SetError(err)
+		span.End()
+		decided(shouldRun, reason, skipCheck.stepDuration(stepName), skipCheck.stepBuildID(stepName), err)
 	},
 }
 
@@ -189,16 +602,49 @@ func Execute() {
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
+	cobra.OnInitialize(initConfig, selectBaseGraph, func() {
+		changesFileGiven = rootCmd.PersistentFlags().Changed("changes")
+	})
 
-	rootCmd.PersistentFlags().StringVar(&cfgFileFlag, "config", "", "config file (default is $HOME/.skipper.yaml)")
-	rootCmd.PersistentFlags().StringVar(&buildIDFlag, "id", "", "ID for this build. If empty, it looks for a /yourbase file with a build ID otherwise it creates one with a random build ID. Once a build ID is determined, skipper spawns a child process of itself but passing --id <id> accordingly")
-	rootCmd.PersistentFlags().StringVar(&graphFileFlag, "dep-graph", "/base-graph.gz", "build graph from the base build")
-	rootCmd.PersistentFlags().StringVar(&changesFileFlag, "changes", "/changes", "changes to the current repo compared to the base build")
+	rootCmd.PersistentFlags().StringVar(&cfgFileFlag, "config", "", "config file (default is $HOME/.skipper.yaml, overridden by the .skipper.yaml closest to the current directory in its project)")
+	rootCmd.PersistentFlags().StringVar(&buildIDFlag, "id", "", "ID for this build. If empty, it's taken from the first of the SKIPPER_BUILD_ID, GITHUB_RUN_ID, CI_PIPELINE_ID, BUILDKITE_BUILD_ID and CIRCLE_WORKFLOW_ID environment variables that is set, otherwise it looks for a build ID in the .skipper/build-id file of the project, found by walking up from the current directory to a directory with a .skipper directory or a git repository, or in ~/yourbase.txt outside of projects, which \"skipper build start\" replaces and \"skipper build finish\" removes, otherwise it creates one with a random build ID and saves it there. Once a build ID is determined, skipper spawns a child process of itself but passing --id <id> accordingly")
+	graphFileFlag = filepath.Join(dataDir(), "base-graph.gz")
+	rootCmd.PersistentFlags().Var(&graphFilesValue{p: &graphFileFlag}, "dep-graph", "build graph from the base build. Reports compressed with gzip, zstd or xz are decompressed, the last two with the zstd and xz tools. Files ending in .db are SQLite graphs created by \"skipper graph convert\", which already include their overlays. A graph compiled by \"skipper compile-graph\" next to the report is used when it is up to date. \"-\" reads the report from stdin. The flag can be repeated, or given a comma-separated list or a directory of reports, to load the reports of a sharded build as one graph")
+	rootCmd.PersistentFlags().StringVar(&graphFormatFlag, "graph-format", "auto", "format of the --dep-graph build reports: json, written by \"skipper record\", csv or tsv, tables of StepName,FilePath,Mode records for graphs written by hand or by scripts, where Mode is R, W, X or L, or auto, which detects tables by their .csv or .tsv extension or their first line")
+	rootCmd.PersistentFlags().StringVar(&changesFileFlag, "changes", filepath.Join(dataDir(), "changes"), "changes to the current repo compared to the base build, one file per line, or one JSON object per line like {\"Path\":\"/src/new.go\",\"Type\":\"rename\",\"OldPath\":\"/src/old.go\"} with types add, modify, delete and rename. \"-\" reads them from stdin; if --dep-graph is \"-\" too, the changes end at the first empty line and the build report follows")
+	rootCmd.PersistentFlags().StringVar(&changesFormatFlag, "changes-format", "auto", "format of --changes: lines, with a path or JSON change per line, null, with NUL-terminated paths like \"git diff --name-only -z\" writes, or auto, which detects null when the start of the changes has a NUL")
+	rootCmd.PersistentFlags().StringVar(&changesGitFlag, "changes-from-git", "", "if set, compute the changes by diffing the working tree against this git ref instead of reading --changes")
+	rootCmd.PersistentFlags().StringArrayVar(&changedFileFlag, "changed-file", nil, "a file changed compared to the base build. Can be repeated. If set, the changes are these files instead of --changes, --change-provider or --changes-from-git")
+	rootCmd.PersistentFlags().StringVar(&changeProviderFlag, "change-provider", "", "command, split on spaces, that writes the changes compared to the base build to stdout, one JSON change per line like in --changes, for version control systems skipper doesn't support. It's used instead of --changes or --changes-from-git, and runs with SKIPPER_CHANGE_PROVIDER_PROTOCOL=1. Defaults to the \"change_provider\" config key, unless --changes or --changes-from-git are given, where a relative path to the command is relative to the workspace root")
+	rootCmd.PersistentFlags().BoolVar(&decisionExitCodesFlag, "decision-exit-codes", false, "exit with --skip-exit-code when the step is skipped, instead of 0, so scripts can tell the decision apart. The wrapped command's exit status is passed through either way")
+	skipExitCodeFlag = 86
+	rootCmd.PersistentFlags().Var(exitCodeValue{&skipExitCodeFlag}, "skip-exit-code", "exit code for skipped steps with --decision-exit-codes, from 1 to 255")
+	rootCmd.PersistentFlags().BoolVar(&streamGraphFlag, "stream-graph", false, "only load the part of the build report the step depends on, reading the report several times, to bound memory on large reports. Also set by the \"stream_graph\" config key")
+	rootCmd.PersistentFlags().BoolVar(&skipCorruptLinesFlag, "skip-corrupt-lines", false, "skip the lines of the build report that don't parse, like a truncated last line, logging how many there are, instead of running every step. Also set by the \"skip_corrupt_lines\" config key")
+	rootCmd.PersistentFlags().StringVar(&outputCacheFlag, "output-cache", "", "directory where \"skipper record\" stores the outputs of steps, which are restored when steps are skipped. Defaults to the \"output_cache\" config key")
+	rootCmd.PersistentFlags().StringVar(&remoteCacheFlag, "remote-cache", "", "URL of a remote cache speaking the HTTP protocol of Bazel remote caches, like bazel-remote or BuildBuddy, where step outputs and the graphs of the graph store are shared between machines. Credentials can be given in the URL. Defaults to the \"remote_cache\" config key")
+	rootCmd.PersistentFlags().StringVar(&journalDirFlag, "journal-dir", defaultJournalDir(), "directory where decisions are journaled for \"skipper stats\", one file per build. Empty disables the journal")
+	rootCmd.PersistentFlags().StringVar(&socketFlag, "socket", defaultSocket(), "Unix socket of the skipper daemon, in $XDG_RUNTIME_DIR or else in the user cache directory by default. If a daemon is listening on a socket owned by the current user, skip decisions are delegated to it")
+	rootCmd.PersistentFlags().StringVar(&manifestFlag, "manifest", "", "step manifest file listing steps and their tags (default is the \"manifest\" config key)")
+	rootCmd.PersistentFlags().StringSliceVar(&overlayFlag, "overlay", nil, "graph overlay files with edges to add to or remove from the base dependency graph, applied after the ones in the \"overlays\" config key")
+	rootCmd.PersistentFlags().StringArrayVar(&coverageFlag, "coverage", nil, "coverage of a test step as <step>=<file>, a Go coverage profile or an lcov tracefile. The step depends on every file with covered lines, in addition to the files it was traced reading. Can be repeated, and adds to the \"coverage\" config key")
+	rootCmd.PersistentFlags().StringVar(&onUnknownStepFlag, "on-unknown-step", "", "what to do with steps that aren't in the base dependency graph: run, skip or fail. Defaults to the \"on_unknown_step\" config key, or run")
+	rootCmd.PersistentFlags().StringVar(&graphRootFlag, "graph-root", "", "root of the checkout the base build was recorded in, like /workspace/project. Files under it in the graph and in the changes are moved under --workspace-root. Defaults to the \"graph_root\" config key")
+	rootCmd.PersistentFlags().StringVar(&workspaceRootFlag, "workspace-root", "", "root of the current checkout, which relative paths in the graph and in the changes are relative to when --graph-root or --workspace-root is set. Defaults to the \"workspace_root\" config key, or the project containing the current directory")
+	rootCmd.PersistentFlags().BoolVar(&toolchainChangedFlag, "toolchain-changed", false, "make steps depend on the system files and toolchain caches they read, the \"hermetic_prefixes\" config key, whose changes are left out otherwise. Use it with changes that include such files, like a compiler upgrade")
+	rootCmd.PersistentFlags().StringVar(&graphPublicKeyFlag, "graph-public-key", "", "PEM file of the ed25519 public key graphs must be signed with, see \"skipper graph sign\". Graph files without a valid signature next to them aren't loaded, so every step runs. Defaults to the \"graph_public_key\" config key")
+	rootCmd.PersistentFlags().DurationVar(&timeoutFlag, "timeout", 0, "stop the wrapped command if it runs for longer than this, e.g. 30m: its process group is sent SIGTERM, and it's killed if it hasn't exited 10s later. Skipper then exits with code 124. Zero means no timeout")
+	rootCmd.PersistentFlags().StringVar(&learnDirFlag, "learn-dir", "", "re-record the steps that run with the recorder of the \"recorder\" config key, and save their file accesses to this directory. Decisions merge the steps re-recorded since the base graph was written into it, and \"skipper graph merge --learned\" folds them into the base graph. Defaults to the \"learn_dir\" config key")
+	rootCmd.PersistentFlags().BoolVar(&forceRunFlag, "force-run", false, "run the wrapped command regardless of the decision, which is still logged, to bypass skipper when a step may have been skipped wrongly. SKIPPER_DISABLE=1 does the same for every skipper invocation that inherits it")
+	rootCmd.PersistentFlags().StringSliceVar(&tagsFlag, "tags", nil, "only consider steps with at least one of these tags, e.g. --tags unit-tests,codegen. Steps without them always run")
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
+	if err := setupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	if cfgFileFlag != "" {
 		// Use config file from the flag.
 		viper.SetConfigFile(cfgFileFlag)
@@ -219,72 +665,471 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		logger.Debug("using config file", "file", viper.ConfigFileUsed())
+		if err := recordConfigFile(viper.ConfigFileUsed()); err != nil {
+			logger.Warn("could not read the config file", "file", viper.ConfigFileUsed(), "err", err)
+		}
+	}
+	if cfgFileFlag != "" {
+		return
+	}
+	// The project's config is shared by its team, and overrides the
+	// user's.
+	cwd, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	if file, ok := projectConfigFile(cwd); ok && file != viper.ConfigFileUsed() {
+		if err := mergeConfigFile(file); err != nil {
+			logger.Warn("could not read the project config file", "file", file, "err", err)
+			return
+		}
+		logger.Debug("using project config file", "file", file)
+	}
+}
+
+// projectConfigFile returns the .skipper.yaml, or .skipper file of another
+// format viper reads, closest to dir, looking up to the root of its git
+// repository. Outside of projects, see projectRoot, there's none.
+func projectConfigFile(dir string) (string, bool) {
+	if _, ok := projectRoot(dir); !ok {
+		return "", false
+	}
+	for {
+		for _, ext := range viper.SupportedExts {
+			file := filepath.Join(dir, ".skipper."+ext)
+			if fi, err := os.Stat(file); err == nil && !fi.IsDir() {
+				return file, true
+			}
+		}
+		// .git is a file in worktrees and submodules.
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return "", false
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
 	}
 }
 
+// updatedNodes reads the changed files in filePath, see readChanges, or on
+// stdin if filePath is "-".
 func updatedNodes(filePath string) (map[string]bool, error) {
+	if filePath == stdinName {
+		return readStdinChanges()
+	}
 	f, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
+	return readChanges(bufio.NewReader(f), changesFormatFlag, false)
+}
 
+// changedNodes returns the files changed since the base build, either given
+// with --changed-file, reported by the change provider, from git when
+// --changes-from-git is set or from the --changes file. Ignored files are
+// left out.
+func changedNodes() (map[string]bool, error) {
+	ignore, err := ignoreMatcher()
+	if err != nil {
+		return nil, err
+	}
+	provider, err := changeProvider()
+	if err != nil {
+		return nil, err
+	}
 	m := map[string]bool{}
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		m[scanner.Text()] = true
+	switch {
+	case len(changedFileFlag) > 0:
+		for _, f := range changedFileFlag {
+			m[f] = true
+		}
+	case len(provider) > 0:
+		files, err := changes.FromProvider(provider)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			m[f] = true
+		}
+	case changesGitFlag != "":
+		files, err := changes.FromGit(changesGitFlag)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			m[f] = true
+		}
+	default:
+		if m, err = updatedNodes(changesFileFlag); err != nil {
+			return nil, err
+		}
 	}
-	if err := scanner.Err(); err != nil {
+	roots, err := rootMapping()
+	if err != nil {
 		return nil, err
 	}
+	if roots != nil {
+		mapped := map[string]bool{}
+		for f := range m {
+			mapped[roots.Map(f)] = true
+		}
+		m = mapped
+	}
+	// Ignored files aren't in the graph, but they would still match the
+	// directories listed by steps.
+	for f := range m {
+		if ignore.Match(f) {
+			delete(m, f)
+		}
+	}
 	return m, nil
 }
 
 // TODO(nictuku): Move this to stepselection.
 
 type stepSkipper struct {
-	buildReport  io.ReadCloser
 	updatedNodes map[string]bool
-	depGraph     *stepselection.DependencyGraph
+	depGraph     stepselection.Graph
+	// alwaysRun lists the steps that run regardless of the graph. tagger
+	// gives their tags.
+	alwaysRun []stepselection.AlwaysRunRule
+	// network decides whether the steps that connected to the network in
+	// the base build run regardless of the graph.
+	network *stepselection.NetworkPolicy
+	tagger  *stepselection.Tagger
+	// env is the environment the step runs with, as NAME=value, or nil if
+	// it's unknown. Steps whose variables of envAllowlist changed since
+	// the base build run.
+	env          []string
+	envAllowlist stepselection.EnvAllowlist
+	// toolchains fingerprints the tools steps ran. Steps that ran a tool
+	// whose fingerprint changed since the base build run. It's nil if no
+	// tool is fingerprinted.
+	toolchains *toolchainFingerprints
+	// overrides decide the steps they apply to with Run set.
+	overrides []stepselection.StepOverride
+	// stale is why the base graph is too stale to decide from, see
+	// staleGraphReason, in which case every step not overridden runs.
+	stale string
+	// invalidateAll matches the files whose changes make every step not
+	// overridden run, see invalidateAllMatcher.
+	invalidateAll *stepselection.PathMatcher
 }
 
-func (s *stepSkipper) Close() {
-	s.buildReport.Close()
+// newStepSkipper loads the graph in logFile for deciding cmdTree. With
+// --stream-graph, only the part of a build report cmdTree depends on is
+// loaded.
+func newStepSkipper(logFile string, updatedNodes map[string]bool, cmdTree stepselection.CmdTree, opts ...stepselection.Option) (*stepSkipper, error) {
+	var depGraph stepselection.Graph
+	var err error
+	if streamGraph() && logFile != stdinName && !isSQLiteGraph(logFile) && filepath.Ext(logFile) != ".bin" {
+		depGraph, err = loadStepGraph(logFile, cmdTree, opts...)
+	} else {
+		depGraph, err = loadGraph(logFile, opts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stepSkipper{
+		updatedNodes: updatedNodes,
+		depGraph:     depGraph,
+		env:          os.Environ(),
+		envAllowlist: envAllowlist(),
+		toolchains:   newToolchainFingerprints(toolchainCommands()),
+	}, nil
 }
 
-func newStepSkipper(logFile string, upFile string) (*stepSkipper, error) {
-	buildReport, err := builddata.OpenFile(logFile)
+// graphSkipper returns a stepSkipper deciding from g, the graph of
+// --dep-graph, given the changed files, with the policies of the config file.
+// It's for the commands that decide many steps at once, see
+// stepSkipper.policyDecision.
+func graphSkipper(g stepselection.Graph, changed map[string]bool) (*stepSkipper, error) {
+	alwaysRun, tagger, err := alwaysRunRules()
+	if err != nil {
+		return nil, err
+	}
+	network, tagger, err := networkPolicy(tagger)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := stepOverrides()
 	if err != nil {
 		return nil, err
 	}
-	updatedNodes, err := updatedNodes(upFile)
+	invalidateAll, err := invalidateAllMatcher()
 	if err != nil {
 		return nil, err
 	}
-	depGraph, err := stepselection.NewDependencyGraph(buildReport)
+	stale, err := staleGraphReason(graphFileFlag)
 	if err != nil {
 		return nil, err
 	}
 	return &stepSkipper{
-		buildReport:  buildReport,
-		updatedNodes: updatedNodes,
-		depGraph:     depGraph,
+		updatedNodes:  changed,
+		depGraph:      g,
+		alwaysRun:     alwaysRun,
+		network:       network,
+		tagger:        tagger,
+		overrides:     overrides,
+		env:           os.Environ(),
+		envAllowlist:  envAllowlist(),
+		toolchains:    newToolchainFingerprints(toolchainCommands()),
+		stale:         stale,
+		invalidateAll: invalidateAll,
 	}, nil
 }
 
-func (s *stepSkipper) shouldRun(stepName []string) (bool, error) {
+// loadGraph opens the graph in logFile for making decisions. Files ending in
+// .db are SQLite graphs, which are queried in place. Other files are build
+// reports loaded in memory. Several reports, see graphFiles, are loaded as
+// a single graph.
+func loadGraph(logFile string, opts ...stepselection.Option) (stepselection.Graph, error) {
+	if isSQLiteGraph(logFile) && !isMultiGraph(logFile) {
+		key, err := graphPublicKey()
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			if err := digestGraphFile(logFile); err != nil {
+				return nil, err
+			}
+			return stepselection.OpenSQLiteGraph(logFile, opts...)
+		}
+		g, err := loadVerifiedSQLiteGraph(logFile, key, opts...)
+		if err != nil {
+			return nil, err
+		}
+		g.SetLookupLimits(lookupLimits())
+		g.SetFuzzyMatch(fuzzyMatch())
+		return g, nil
+	}
+	g, err := loadDependencyGraph(logFile, opts...)
+	if err != nil {
+		setGraphDigest("")
+		return nil, err
+	}
+	if err := mergeLearned(g, logFile); err != nil {
+		logger.Warn("not using the re-recorded steps", "err", err)
+	}
+	g.SetLookupLimits(lookupLimits())
+	g.SetFuzzyMatch(fuzzyMatch())
+	return g, nil
+}
+
+// loadStepGraph loads the part of the build reports in logFile that cmdTree
+// depends on, reading the reports once per level of dependencies.
+func loadStepGraph(logFile string, cmdTree stepselection.CmdTree, opts ...stepselection.Option) (stepselection.Graph, error) {
+	open := func() (io.ReadCloser, error) {
+		r, err := openBuildReports(logFile)
+		if err != nil {
+			return nil, err
+		}
+		return newDigestReader(r), nil
+	}
+	g, err := stepselection.NewStepDependencyGraph(open, cmdTree, opts...)
+	if err != nil {
+		setGraphDigest("")
+		return nil, err
+	}
+	g.SetLookupLimits(lookupLimits())
+	g.SetFuzzyMatch(fuzzyMatch())
+	return g, nil
+}
+
+func isSQLiteGraph(logFile string) bool {
+	return strings.HasSuffix(logFile, ".db")
+}
+
+// loadDependencyGraph loads the whole dependency graph in logFile in memory.
+func loadDependencyGraph(logFile string, opts ...stepselection.Option) (*stepselection.DependencyGraph, error) {
+	multi := isMultiGraph(logFile)
+	if isSQLiteGraph(logFile) && !multi {
+		key, err := graphPublicKey()
+		if err != nil {
+			return nil, err
+		}
+		if key != nil {
+			return loadVerifiedSQLiteGraph(logFile, key, opts...)
+		}
+		if err := digestGraphFile(logFile); err != nil {
+			return nil, err
+		}
+		g, err := stepselection.OpenSQLiteGraph(logFile, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return g.Load()
+	}
+	if !multi && logFile != stdinName {
+		if g, err := loadCompiledGraph(logFile, opts...); err == nil {
+			return g, nil
+		} else if !os.IsNotExist(err) {
+			logger.Warn("not using the compiled graph", "err", err)
+		}
+	}
+	buildReport, err := openBuildReports(logFile)
+	if err != nil {
+		return nil, err
+	}
+	defer buildReport.Close()
+	return stepselection.NewDependencyGraph(newDigestReader(buildReport), opts...)
+}
+
+// loadVerifiedSQLiteGraph loads the SQLite graph logFile in memory, once its
+// signature by key is checked. sqlite3 reads graphs by name, so it's given a
+// private copy of the bytes checked, removed once the graph is loaded.
+func loadVerifiedSQLiteGraph(logFile string, key ed25519.PublicKey, opts ...stepselection.Option) (*stepselection.DependencyGraph, error) {
+	b, err := readVerifiedGraphFile(logFile, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(ioutil.Discard, newDigestReader(ioutil.NopCloser(bytes.NewReader(b)))); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", "skipper-graph")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, filepath.Base(logFile))
+	if err := ioutil.WriteFile(file, b, 0600); err != nil {
+		return nil, err
+	}
+	g, err := stepselection.OpenSQLiteGraph(file, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return g.Load()
+}
+
+// compiledGraphPath returns where "skipper compile-graph" writes the compiled
+// graph of logFile: next to it, with a .bin extension.
+func compiledGraphPath(logFile string) string {
+	return strings.TrimSuffix(logFile, filepath.Ext(logFile)) + ".bin"
+}
+
+// loadCompiledGraph loads the compiled graph of logFile, which must have been
+// compiled from its current contents, and be signed if graphs must be. logFile
+// may be a compiled graph itself.
+func loadCompiledGraph(logFile string, opts ...stepselection.Option) (*stepselection.DependencyGraph, error) {
+	var src stepselection.CompiledSource
+	compiled := logFile
+	if filepath.Ext(logFile) != ".bin" {
+		fi, err := os.Stat(logFile)
+		if err != nil {
+			return nil, err
+		}
+		src = stepselection.CompiledSource{Size: fi.Size(), ModTime: fi.ModTime()}
+		compiled = compiledGraphPath(logFile)
+	}
+	if _, err := os.Stat(compiled); err != nil {
+		return nil, err
+	}
+	key, err := graphPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	var rc io.ReadCloser
+	if key != nil {
+		b, err := readVerifiedGraphFile(compiled, key)
+		if err != nil {
+			return nil, err
+		}
+		rc = ioutil.NopCloser(bytes.NewReader(b))
+	} else if rc, err = os.Open(compiled); err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	r := bufio.NewReader(newDigestReader(rc))
+	g, err := stepselection.LoadCompiledGraph(r, src, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// The rest of the file, if any, is part of its digest.
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// stepDuration returns how long stepName took in the base build, or zero if
+// the graph doesn't know.
+func (s *stepSkipper) stepDuration(stepName []string) time.Duration {
+	g, ok := s.depGraph.(interface {
+		StepDuration(stepselection.CmdTree) (time.Duration, bool)
+	})
+	if !ok {
+		return 0
+	}
+	d, _ := g.StepDuration(stepName)
+	return d
+}
+
+// stepBuildID returns the ID of the build stepName was recorded in, or "" if
+// the graph doesn't know.
+func (s *stepSkipper) stepBuildID(stepName []string) string {
+	g, ok := s.depGraph.(interface {
+		StepBuildID(stepselection.CmdTree) (string, bool)
+	})
+	if !ok {
+		return ""
+	}
+	id, _ := g.StepBuildID(stepName)
+	return id
+}
+
+// shouldRun decides whether stepName must run. If it must, the returned
+// reason explains why, for the user's benefit.
+func (s *stepSkipper) shouldRun(stepName []string) (bool, string, error) {
+	if run, reason, ok := s.policyDecision(stepName); ok {
+		return run, reason, nil
+	}
 	updatedFiles := []string{}
 	for f := range s.updatedNodes {
 		updatedFiles = append(updatedFiles, f)
 	}
 	depends, reason, err := s.depGraph.StepDependsOnFiles(stepName, updatedFiles)
 	if err != nil {
-		return true, err
+		return true, "", err
+	}
+	return depends, reason, nil
+}
+
+// policyDecision decides stepName by everything but the files it depends on
+// in the graph: the overrides, the staleness of the graph, the changes that
+// invalidate every step, the always-run rules, the network policy, and the
+// changes of its environment and tools. ok is false if the graph decides.
+// Commands deciding many steps at once use it with a single walk of the
+// graph, see stepselection.DependencyGraph.StepsAffectedBy.
+func (s *stepSkipper) policyDecision(stepName []string) (run bool, reason string, ok bool) {
+	if run, reason, ok := stepselection.OverrideDecision(s.overrides, stepName); ok {
+		return run, reason, true
+	}
+	if s.stale != "" {
+		return true, fmt.Sprintf("step %q runs because %v", stepselection.CmdTree(stepName).Name(), s.stale), true
+	}
+	if f := invalidatingChange(s.invalidateAll, s.updatedNodes); f != "" {
+		return true, fmt.Sprintf("step %q runs because %v changed, which invalidates every step, see invalidate_all", stepselection.CmdTree(stepName).Name(), f), true
+	}
+	if r := stepselection.MatchAlwaysRun(s.alwaysRun, stepName, s.tagger); r != nil {
+		return true, fmt.Sprintf("step %q matches the %v", stepselection.CmdTree(stepName).Name(), r), true
 	}
-	if depends {
-		// TODO: move this to the calling func?
-		fmt.Println("skipper:", reason)
-		return true, nil
+	if addrs := s.network.MustRun(s.depGraph, stepName, s.tagger); addrs != nil {
+		return true, stepselection.NetworkReason(stepName, addrs), true
+	}
+	if s.env != nil {
+		if changed := stepselection.EnvironmentChanges(s.depGraph, stepName, s.envAllowlist, s.env); changed != nil {
+			return true, stepselection.EnvironmentReason(stepName, changed), true
+		}
+	}
+	if s.toolchains != nil {
+		if changed := stepselection.ToolchainChanges(s.depGraph, stepName, s.toolchains.fingerprint); changed != nil {
+			return true, stepselection.ToolchainReason(stepName, changed), true
+		}
 	}
-	return false, nil
+	return false, "", false
 }
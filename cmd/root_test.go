@@ -2,9 +2,14 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/viper"
 )
 
 func TestChildSkipperArgs(t *testing.T) {
@@ -19,3 +24,276 @@ func TestChildSkipperArgs(t *testing.T) {
 	}
 
 }
+
+func TestCurrentStepName(t *testing.T) {
+	defer os.Unsetenv(stepPathEnv)
+	for _, tc := range []struct {
+		env  string
+		want []string
+	}{
+		{"", []string{"go test ./..."}},
+		{`["make all"]`, []string{"make all", "go test ./..."}},
+		{`["make all","make -C sub"]`, []string{"make all", "make -C sub", "go test ./..."}},
+	} {
+		os.Setenv(stepPathEnv, tc.env)
+		got, err := currentStepName([]string{"go", "test", "./..."})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, tc.want); len(diff) > 0 {
+			t.Errorf("%v=%q: got %q wanted %q", stepPathEnv, tc.env, got, tc.want)
+		}
+	}
+	os.Setenv(stepPathEnv, "make all")
+	if _, err := currentStepName([]string{"true"}); err == nil {
+		t.Errorf("expected an error for an invalid %v", stepPathEnv)
+	}
+}
+
+func TestCurrentStepNameAlias(t *testing.T) {
+	t.Setenv(stepPathEnv, `["ci"]`)
+	viper.Set("step_overrides", []map[string]any{{"pattern": "^make all$", "alias": "./ci/build.sh"}})
+	defer viper.Set("step_overrides", nil)
+	got, err := currentStepName([]string{"make", "all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, []string{"ci", "./ci/build.sh"}); diff != "" {
+		t.Errorf("unexpected step name, diff: %v", diff)
+	}
+}
+
+func TestStepOverridePaths(t *testing.T) {
+	viper.Set("step_overrides", []map[string]any{{"pattern": "^go generate", "inputs": []string{"/src/api/schema.graphql"}, "outputs": []string{"api/generated.go"}}})
+	defer viper.Set("step_overrides", nil)
+	if _, err := stepOverrides(); err == nil {
+		t.Error("expected an error for a relative path outside of config files")
+	}
+
+	home, project := t.TempDir(), t.TempDir()
+	user := filepath.Join(home, ".skipper.yaml")
+	userConfig := `step_overrides:
+  - pattern: "^go generate"
+    inputs: [/src/api/schema.graphql]
+    outputs: [api/generated.go]
+`
+	if err := ioutil.WriteFile(user, []byte(userConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(dirs map[string]string) { configDirs = dirs }(configDirs)
+	configDirs = map[string]string{}
+	// The config file can't be unset.
+	defer viper.Reset()
+	viper.Set("step_overrides", nil)
+	if err := mergeConfigFile(user); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		projectConfig string
+		want          string
+	}{
+		// The overrides of the user are relative to their config.
+		{"journal_dir: journal\n", filepath.Join(home, "api", "generated.go")},
+		{"step_overrides:\n  - pattern: \"^go generate\"\n    outputs: [api/generated.go]\n", filepath.Join(project, "api", "generated.go")},
+	} {
+		file := filepath.Join(project, ".skipper.yaml")
+		if err := ioutil.WriteFile(file, []byte(tc.projectConfig), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := mergeConfigFile(file); err != nil {
+			t.Fatal(err)
+		}
+		overrides, err := stepOverrides()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]string{tc.want}, overrides[0].Outputs); diff != "" {
+			t.Errorf("%q: unexpected outputs, diff: %v", tc.projectConfig, diff)
+		}
+	}
+}
+
+func TestProjectRoot(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "skipper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	for _, d := range []string{"repo/.git", "repo/sub/dir", "repo/tool/.skipper", "repo/tool/src"} {
+		if err := os.MkdirAll(filepath.Join(tmp, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		dir, want string
+	}{
+		{"repo", "repo"},
+		{"repo/sub/dir", "repo"},
+		{"repo/tool/src", "repo/tool"},
+	} {
+		got, ok := projectRoot(filepath.Join(tmp, tc.dir))
+		if want := filepath.Join(tmp, tc.want); !ok || got != want {
+			t.Errorf("projectRoot(%v): got %q, %v wanted %q", tc.dir, got, ok, want)
+		}
+	}
+}
+
+func TestProjectConfigFile(t *testing.T) {
+	tmp := t.TempDir()
+	for _, d := range []string{"repo/.git", "repo/sub/dir", "repo/tool/.skipper", "repo/tool/src", "other"} {
+		if err := os.MkdirAll(filepath.Join(tmp, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{".skipper.yaml", "repo/.skipper.yaml", "repo/tool/.skipper.json"} {
+		if err := ioutil.WriteFile(filepath.Join(tmp, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		dir, want string
+	}{
+		{"repo", "repo/.skipper.yaml"},
+		{"repo/sub/dir", "repo/.skipper.yaml"},
+		{"repo/tool/src", "repo/tool/.skipper.json"},
+		// Outside of projects, and above the repository, there's none.
+		{"other", ""},
+	} {
+		got, ok := projectConfigFile(filepath.Join(tmp, tc.dir))
+		want := ""
+		if tc.want != "" {
+			want = filepath.Join(tmp, tc.want)
+		}
+		if got != want || ok != (want != "") {
+			t.Errorf("projectConfigFile(%v): got %q, %v wanted %q", tc.dir, got, ok, want)
+		}
+	}
+}
+
+func TestBuildIDFromEnv(t *testing.T) {
+	for _, e := range buildIDEnvs {
+		for _, name := range []string{e.name, e.attempt} {
+			if name == "" {
+				continue
+			}
+			if old, ok := os.LookupEnv(name); ok {
+				defer os.Setenv(name, old)
+			} else {
+				defer os.Unsetenv(name)
+			}
+			os.Unsetenv(name)
+		}
+	}
+	if id, name := buildIDFromEnv(); id != "" {
+		t.Errorf("got %q from %v with no CI variables", id, name)
+	}
+	os.Setenv("CI_PIPELINE_ID", "77")
+	os.Setenv("GITHUB_RUN_ID", "1234")
+	os.Setenv("GITHUB_RUN_ATTEMPT", "2")
+	if id, name := buildIDFromEnv(); id != "1234-2" || name != "GITHUB_RUN_ID" {
+		t.Errorf("got %q from %v wanted 1234-2 from GITHUB_RUN_ID", id, name)
+	}
+	os.Setenv("SKIPPER_BUILD_ID", "01ARZ3NDEKTSV4RRFFQ69G5FAV")
+	if id, _ := buildIDFromEnv(); id != "01ARZ3NDEKTSV4RRFFQ69G5FAV" {
+		t.Errorf("got %q wanted the SKIPPER_BUILD_ID", id)
+	}
+}
+
+func TestUnknownStepPolicy(t *testing.T) {
+	old := onUnknownStepFlag
+	defer func() { onUnknownStepFlag = old }()
+	for _, tc := range []struct {
+		flag, want string
+		err        bool
+	}{
+		{"", "run", false},
+		{"skip", "skip", false},
+		{"fail", "fail", false},
+		{"ignore", "", true},
+	} {
+		onUnknownStepFlag = tc.flag
+		got, err := unknownStepPolicy()
+		if got != tc.want || (err != nil) != tc.err {
+			t.Errorf("--on-unknown-step=%q: got %q, %v wanted %q", tc.flag, got, err, tc.want)
+		}
+	}
+}
+
+func TestChangedFileFlag(t *testing.T) {
+	defer func(files []string, changes string) {
+		changedFileFlag, changesFileFlag = files, changes
+	}(changedFileFlag, changesFileFlag)
+	changedFileFlag = []string{"/src/a.go", "/src/b.go"}
+	// --changes isn't read.
+	changesFileFlag = filepath.Join(t.TempDir(), "missing")
+	got, err := changedNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, map[string]bool{"/src/a.go": true, "/src/b.go": true}); diff != "" {
+		t.Errorf("unexpected changes, diff: %v", diff)
+	}
+}
+
+func TestChangeProviderConfig(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test provider is a shell script")
+	}
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "scripts"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "scripts", "changes"), []byte("#!/bin/sh\necho '{\"Path\":\"/src/p.go\"}'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	changes := filepath.Join(dir, "changes")
+	if err := ioutil.WriteFile(changes, []byte("/src/c.go\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(workspace, file string, given bool) {
+		workspaceRootFlag, changesFileFlag, changesFileGiven = workspace, file, given
+	}(workspaceRootFlag, changesFileFlag, changesFileGiven)
+	workspaceRootFlag, changesFileFlag = dir, changes
+	// Relative to the workspace root, not the current directory.
+	viper.Set("change_provider", "scripts/changes")
+	defer viper.Set("change_provider", nil)
+
+	for _, tc := range []struct {
+		given bool
+		want  string
+	}{
+		{false, "/src/p.go"},
+		// An explicit --changes isn't overridden by the config.
+		{true, "/src/c.go"},
+	} {
+		changesFileGiven = tc.given
+		got, err := changedNodes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(map[string]bool{tc.want: true}, got); diff != "" {
+			t.Errorf("--changes given %v: unexpected changes, diff: %v", tc.given, diff)
+		}
+	}
+}
+
+func TestForcedRun(t *testing.T) {
+	defer func() { forceRunFlag = false }()
+	for _, tc := range []struct {
+		flag bool
+		env  string
+		want string
+	}{
+		{false, "", ""},
+		{false, "0", ""},
+		{false, "1", "SKIPPER_DISABLE=1"},
+		{true, "", "--force-run"},
+	} {
+		forceRunFlag = tc.flag
+		t.Setenv(disableEnv, tc.env)
+		if got := forcedRun(); got != tc.want {
+			t.Errorf("--force-run=%v %v=%q: got %q wanted %q", tc.flag, disableEnv, tc.env, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/yourbase/skipper/journal"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+var runPlanJobsFlag int
+
+var runPlanCmd = &cobra.Command{
+	Use:   "run-plan <plan>",
+	Short: "Run the steps of a plan in parallel, skipping the ones that don't need to run",
+	Long: `Decides whether each step of a plan must run, like "skipper -- <command>"
+would, then runs the ones that must with up to -j steps at a time, each
+after the steps it depends on. The plan is a YAML, TOML or JSON file with a
+list of steps:
+
+	steps:
+	  - command: go generate ./...
+	  - command: go build ./...
+	    after: [go generate ./...]
+	  - name: unit tests
+	    command: go test ./...
+	    after: [go generate ./...]
+
+"after" lists the names of the steps that must finish first. A step's name
+defaults to its command. If no step declares "after", a step runs after the
+earlier steps of the plan that write files it reads in the base dependency
+graph.
+
+Commands made of plain words run directly. Others, with quotes, variables,
+pipes or redirections, run with "sh -c <command>", and the step is named
+accordingly. Once a step fails, no other step starts, and skipper exits
+with status 1 after the running steps finish.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if runPlanJobsFlag < 1 {
+			fmt.Fprintf(os.Stderr, "invalid -j %d, must be at least 1\n", runPlanJobsFlag)
+			os.Exit(1)
+		}
+		steps, err := readPlan(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		g, skipCheck, err := planSkipper()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := orderPlan(steps, g); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", args[0], err)
+			os.Exit(1)
+		}
+		if err := decidePlan(steps, skipCheck); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		buildID := buildIDFlag
+		if buildID == "" {
+			buildID, _ = buildIDFromEnv()
+		}
+		if buildID == "" {
+			if buildID, err = newBuildULID(); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not create a new build ID: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		failed := runPlan(steps, runPlanJobsFlag, func(s *planStep) error {
+			return runPlanStep(s, buildID)
+		})
+		if len(failed) > 0 {
+			for _, s := range failed {
+				fmt.Fprintf(os.Stderr, "Step %q failed: %v\n", s.Name, s.err)
+			}
+			os.Exit(1)
+		}
+	},
+}
+
+// planStep is a step of a plan given to "skipper run-plan".
+type planStep struct {
+	Name    string
+	Command string
+	After   []string
+
+	// args is the command run, and tree the step's CmdTree.
+	args []string
+	tree stepselection.CmdTree
+	// deps are the indexes of the steps that must finish first.
+	deps []int
+	// run and reason are the decision of whether the step must run.
+	run     bool
+	reason  string
+	unknown bool
+	// base is the ID of the build the step was recorded in, whose outputs
+	// are restored when it's skipped.
+	base string
+	err  error
+}
+
+// shellChars are the characters that make a command run with "sh -c".
+const shellChars = "\"'`$\\|&;<>()*?[]{}~#"
+
+// readPlan reads the steps of the plan in path.
+func readPlan(path string) ([]*planStep, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("could not read plan %v: %v", path, err)
+	}
+	var steps []*planStep
+	if err := v.UnmarshalKey("steps", &steps); err != nil {
+		return nil, fmt.Errorf("invalid plan %v: %v", path, err)
+	}
+	names := map[string]bool{}
+	for _, s := range steps {
+		if strings.TrimSpace(s.Command) == "" {
+			return nil, fmt.Errorf("invalid plan %v: a step has no command", path)
+		}
+		if strings.ContainsAny(s.Command, shellChars) || strings.Contains(s.Command, "\n") {
+			s.args = []string{"sh", "-c", s.Command}
+		} else {
+			s.args = strings.Fields(s.Command)
+		}
+		if s.Name == "" {
+			s.Name = s.Command
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("invalid plan %v: duplicate step %q", path, s.Name)
+		}
+		names[s.Name] = true
+		tree, err := currentStepName(s.args)
+		if err != nil {
+			return nil, err
+		}
+		s.tree = tree
+	}
+	return steps, nil
+}
+
+// orderPlan sets the dependencies of steps, from their "after" lists if any
+// step has one, or else from the files the steps pass on to each other in
+// g, if it isn't nil.
+func orderPlan(steps []*planStep, g *stepselection.DependencyGraph) error {
+	index := map[string]int{}
+	declared := false
+	for i, s := range steps {
+		index[s.Name] = i
+		declared = declared || len(s.After) > 0
+	}
+	if declared {
+		for _, s := range steps {
+			for _, a := range s.After {
+				i, ok := index[a]
+				if !ok {
+					return fmt.Errorf("step %q runs after unknown step %q", s.Name, a)
+				}
+				s.deps = append(s.deps, i)
+			}
+		}
+		return planCycle(steps)
+	}
+	if g == nil {
+		return nil
+	}
+	// Only earlier steps are dependencies, so cycles in the graph keep the
+	// order of the plan.
+	for j, s := range steps {
+		for i := 0; i < j; i++ {
+			if files := g.FilesBetween(steps[i].tree, s.tree); len(files) > 0 {
+				logger.Debug("ordering steps by the graph", "step", s.Name, "after", steps[i].Name, "file", files[0])
+				s.deps = append(s.deps, i)
+			}
+		}
+	}
+	return nil
+}
+
+// planCycle returns an error naming the steps of a dependency cycle, if
+// steps have one.
+func planCycle(steps []*planStep) error {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(steps))
+	var path []string
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("steps run after each other: %q", append(path, steps[i].Name))
+		case done:
+			return nil
+		}
+		state[i] = visiting
+		path = append(path, steps[i].Name)
+		for _, d := range steps[i].deps {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = done
+		return nil
+	}
+	for i := range steps {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planSkipper loads the base dependency graph for deciding the steps of a
+// plan. Both are nil if the graph is missing, and every step runs.
+func planSkipper() (*stepselection.DependencyGraph, *stepSkipper, error) {
+	opts, err := graphOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := unknownStepPolicy(); err != nil {
+		return nil, nil, err
+	}
+	if _, err := staleGraphPolicy(); err != nil {
+		return nil, nil, err
+	}
+	g, err := loadDependencyGraph(graphFileFlag, opts...)
+	if os.IsNotExist(err) {
+		logger.Info("running every step because the base dependency graph is missing", "graph", graphFileFlag)
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("could not load the base dependency graph: %v", err)
+	}
+	g.SetLookupLimits(lookupLimits())
+	g.SetFuzzyMatch(fuzzyMatch())
+	changed, err := changedNodes()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not determine the changed files: %v", err)
+	}
+	skipCheck, err := graphSkipper(g, changed)
+	if err != nil {
+		return nil, nil, err
+	}
+	return g, skipCheck, nil
+}
+
+// decidePlan decides whether each of steps must run. Without skipCheck,
+// they all do.
+func decidePlan(steps []*planStep, skipCheck *stepSkipper) error {
+	policy, _ := unknownStepPolicy()
+	for _, s := range steps {
+		if skipCheck == nil {
+			s.run, s.reason = true, "the base dependency graph is missing"
+			continue
+		}
+		s.base = skipCheck.stepBuildID(s.tree)
+		run, reason, err := skipCheck.shouldRun(s.tree)
+		switch {
+		case errors.Is(err, stepselection.ErrUnknownStep):
+			s.unknown = true
+			switch policy {
+			case "fail":
+				return fmt.Errorf("step %v isn't in the base dependency graph %v", s.tree.Name(), graphFileFlag)
+			case "skip":
+				logger.Warn("skipping because the step isn't in the base dependency graph", "step", s.Name, "unknown_step", true)
+			default:
+				logger.Warn("running because the step isn't in the base dependency graph", "step", s.Name, "unknown_step", true)
+				s.run, s.reason = true, "unknown step"
+			}
+		case err != nil:
+			logger.Warn("running because the decision failed", "step", s.Name, "err", err)
+			s.run = true
+		case run:
+			logger.Info("decided that we should run", "step", s.Name, "reason", reason)
+			s.run, s.reason = true, reason
+		default:
+			logger.Info("decided we should skip", "step", s.Name)
+		}
+	}
+	return nil
+}
+
+// runPlanStep runs s if it must, or restores its outputs from the output
+// cache, and journals the decision.
+func runPlanStep(s *planStep, buildID string) error {
+	stepID := s.tree.Name()
+	entry := &journal.Entry{BuildID: buildID, Time: time.Now(), Step: stepID, Unknown: s.unknown}
+	if !s.run {
+		if cache := outputCache(); cache != nil {
+			outputs, err := cache.Restore(s.base, stepID)
+			switch {
+			case os.IsNotExist(err):
+				// The step was never recorded with a cache.
+			case err != nil:
+				logger.Warn("running because the step outputs could not be restored", "step", s.Name, "err", err)
+				s.run = true
+			default:
+				logger.Info("restored outputs from the output cache", "step", s.Name, "outputs", len(outputs))
+			}
+		}
+	}
+	if !s.run {
+		journalDecision(entry)
+		return nil
+	}
+	entry.Run, entry.Reason = true, s.reason
+	cm := exec.Command(s.args[0], s.args[1:]...)
+	cm.Env = append(os.Environ(), stepPathEnv+"="+stepID)
+	cm.Stdout, cm.Stderr = os.Stdout, os.Stderr
+	start := time.Now()
+	err := cm.Run()
+	entry.Duration = time.Since(start)
+	journalDecision(entry)
+	return err
+}
+
+// runPlan calls do for steps, up to jobs at a time, each once the steps it
+// depends on are done. Once a call fails, no other call starts. runPlan
+// returns the failed steps, with their errors set.
+func runPlan(steps []*planStep, jobs int, do func(*planStep) error) []*planStep {
+	// waiting counts the dependencies of each step that aren't done.
+	waiting := make([]int, len(steps))
+	dependents := make([][]int, len(steps))
+	var ready []int
+	for i, s := range steps {
+		waiting[i] = len(s.deps)
+		for _, d := range s.deps {
+			dependents[d] = append(dependents[d], i)
+		}
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	type result struct {
+		i   int
+		err error
+	}
+	results := make(chan result)
+	running := 0
+	var failed []*planStep
+	for {
+		for len(failed) == 0 && len(ready) > 0 && running < jobs {
+			i := ready[0]
+			ready = ready[1:]
+			running++
+			go func() {
+				results <- result{i, do(steps[i])}
+			}()
+		}
+		if running == 0 {
+			return failed
+		}
+		r := <-results
+		running--
+		if r.err != nil {
+			steps[r.i].err = r.err
+			failed = append(failed, steps[r.i])
+			continue
+		}
+		for _, d := range dependents[r.i] {
+			if waiting[d]--; waiting[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+}
+
+func init() {
+	runPlanCmd.Flags().IntVarP(&runPlanJobsFlag, "jobs", "j", runtime.NumCPU(), "number of steps to run at a time")
+	rootCmd.AddCommand(runPlanCmd)
+}
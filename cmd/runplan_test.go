@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func TestReadPlan(t *testing.T) {
+	plan := filepath.Join(t.TempDir(), "plan.yaml")
+	if err := ioutil.WriteFile(plan, []byte(`steps:
+  - command: go generate ./...
+  - name: tests
+    command: go test ./... | tee test.log
+    after: [go generate ./...]
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	steps, err := readPlan(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 {
+		t.Fatalf("got %d steps, wanted 2", len(steps))
+	}
+	if diff := cmp.Diff([]string{"go", "generate", "./..."}, steps[0].args); diff != "" {
+		t.Errorf("plain command: (-want +got)\n%s", diff)
+	}
+	if diff := cmp.Diff(stepselection.CmdTree{"sh -c go test ./... | tee test.log"}, steps[1].tree); diff != "" {
+		t.Errorf("shell command: (-want +got)\n%s", diff)
+	}
+	if steps[0].Name != "go generate ./..." || steps[1].Name != "tests" {
+		t.Errorf("got names %q and %q", steps[0].Name, steps[1].Name)
+	}
+}
+
+func TestOrderPlan(t *testing.T) {
+	report := `{"CmdTree":["protoc a.proto"],"Mode":"R","File":"/src/a.proto"}
+{"CmdTree":["protoc a.proto"],"Mode":"W","File":"/src/a.pb.go"}
+{"CmdTree":["go build"],"Mode":"R","File":"/src/a.pb.go"}
+{"CmdTree":["go vet"],"Mode":"R","File":"/src/main.go"}
+`
+	g, err := stepselection.NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newSteps := func(after ...[]string) []*planStep {
+		var steps []*planStep
+		for i, name := range []string{"protoc a.proto", "go build", "go vet"} {
+			s := &planStep{Name: name, tree: stepselection.CmdTree{name}}
+			if i < len(after) {
+				s.After = after[i]
+			}
+			steps = append(steps, s)
+		}
+		return steps
+	}
+
+	steps := newSteps()
+	if err := orderPlan(steps, g); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([][]int{nil, {0}, nil}, [][]int{steps[0].deps, steps[1].deps, steps[2].deps}); diff != "" {
+		t.Errorf("inferred: (-want +got)\n%s", diff)
+	}
+
+	steps = newSteps(nil, nil, []string{"go build"})
+	if err := orderPlan(steps, g); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([][]int{nil, nil, {1}}, [][]int{steps[0].deps, steps[1].deps, steps[2].deps}); diff != "" {
+		t.Errorf("declared: (-want +got)\n%s", diff)
+	}
+
+	if err := orderPlan(newSteps([]string{"go vet"}, nil, []string{"protoc a.proto"}), g); err == nil {
+		t.Errorf("a cycle should fail")
+	}
+	if err := orderPlan(newSteps([]string{"make"}), g); err == nil {
+		t.Errorf("an unknown step should fail")
+	}
+}
+
+func TestRunPlan(t *testing.T) {
+	steps := []*planStep{
+		{Name: "a"},
+		{Name: "b", deps: []int{0}},
+		{Name: "c", deps: []int{0}},
+		{Name: "d", deps: []int{1, 2}},
+	}
+	var mu sync.Mutex
+	var order []string
+	failed := runPlan(steps, 2, func(s *planStep) error {
+		mu.Lock()
+		order = append(order, s.Name)
+		mu.Unlock()
+		return nil
+	})
+	if len(failed) != 0 {
+		t.Fatalf("got failures %v", failed)
+	}
+	if len(order) != 4 || order[0] != "a" || order[3] != "d" {
+		t.Errorf("got order %q", order)
+	}
+
+	order = nil
+	failed = runPlan(steps, 1, func(s *planStep) error {
+		order = append(order, s.Name)
+		if s.Name == "b" {
+			return errors.New("exit status 1")
+		}
+		return nil
+	})
+	if len(failed) != 1 || failed[0].Name != "b" || failed[0].err == nil {
+		t.Errorf("got failures %v", failed)
+	}
+	if diff := cmp.Diff([]string{"a", "b"}, order); diff != "" {
+		t.Errorf("no step should start after a failure: (-want +got)\n%s", diff)
+	}
+}
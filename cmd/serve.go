@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+var (
+	serveAddrFlag string
+	serveUIFlag   bool
+)
+
+//go:embed ui/index.html
+var uiPage []byte
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the dependency graph over HTTP for exploration",
+	Long: `Loads the dependency graph and answers queries about it over HTTP, as JSON:
+
+	GET /api/steps?q=<text>       the steps whose names contain text
+	GET /api/step?name=<step>     the files a step reads, writes and lists,
+	                              and its duration and network accesses
+	GET /api/impact?file=<file>   the steps affected by changes to the files,
+	                              with the chain from a file to each step
+
+Step names are the JSON arrays of the graph, like ["make","cc a.c"]. The
+file parameter may be repeated.
+
+With --ui, a page for browsing the steps and querying the impact of files is
+served at /, so the graph can be explored from a browser.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		opts, err := graphOptions()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		g, err := loadDependencyGraph(graphFileFlag, opts...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not load the dependency graph: %v\n", err)
+			os.Exit(1)
+		}
+		g.SetLookupLimits(lookupLimits())
+		l, err := net.Listen("tcp", serveAddrFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("skipper: serving %v on http://%v/\n", g, l.Addr())
+		if err := http.Serve(l, newGraphServer(g, serveUIFlag)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// graphServer answers the queries of "skipper serve" about g.
+type graphServer struct {
+	g *stepselection.DependencyGraph
+}
+
+func newGraphServer(g *stepselection.DependencyGraph, ui bool) http.Handler {
+	s := &graphServer{g: g}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/steps", s.steps)
+	mux.HandleFunc("/api/step", s.step)
+	mux.HandleFunc("/api/impact", s.impact)
+	if ui {
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(uiPage)
+		})
+	}
+	return mux
+}
+
+// maxServedSteps limits how many steps /api/steps returns.
+const maxServedSteps = 1000
+
+func (s *graphServer) steps(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	resp := struct {
+		Steps []string
+		// Truncated is set if more steps matched than were returned.
+		Truncated bool `json:",omitempty"`
+	}{Steps: []string{}}
+	for _, name := range s.g.Steps() {
+		if !strings.Contains(name, q) {
+			continue
+		}
+		if len(resp.Steps) == maxServedSteps {
+			resp.Truncated = true
+			break
+		}
+		resp.Steps = append(resp.Steps, name)
+	}
+	writeJSON(w, resp)
+}
+
+// servedStep is the answer of /api/step.
+type servedStep struct {
+	Name     string
+	Reads    []string
+	Writes   []string
+	Inputs   []string
+	Dirs     []string
+	Duration time.Duration `json:",omitempty"`
+	Network  []string      `json:",omitempty"`
+	// InputsError is set if the transitive inputs went over the lookup
+	// limits.
+	InputsError string `json:",omitempty"`
+}
+
+func (s *graphServer) step(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	var cmdTree stepselection.CmdTree
+	if err := json.Unmarshal([]byte(name), &cmdTree); err != nil {
+		http.Error(w, fmt.Sprintf("invalid step name %q: %v", name, err), http.StatusBadRequest)
+		return
+	}
+	reads, err := s.g.StepReads(cmdTree)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	resp := servedStep{Name: cmdTree.Name(), Reads: reads, Network: s.g.NetworkAccesses(cmdTree)}
+	resp.Writes, _ = s.g.StepWrites(cmdTree)
+	resp.Duration, _ = s.g.StepDuration(cmdTree)
+	if resp.Inputs, resp.Dirs, err = s.g.StepInputs(cmdTree); err != nil {
+		resp.InputsError = err.Error()
+	}
+	writeJSON(w, resp)
+}
+
+// impactedStep is a step in the answer of /api/impact.
+type impactedStep struct {
+	Name  string
+	Chain string
+}
+
+func (s *graphServer) impact(w http.ResponseWriter, r *http.Request) {
+	files := r.URL.Query()["file"]
+	if len(files) == 0 {
+		http.Error(w, "missing file parameter", http.StatusBadRequest)
+		return
+	}
+	resp := struct{ Steps []impactedStep }{Steps: []impactedStep{}}
+	for _, name := range s.g.StepsAffectedBy(files) {
+		step := impactedStep{Name: name}
+		var cmdTree stepselection.CmdTree
+		if err := json.Unmarshal([]byte(name), &cmdTree); err == nil {
+			if chains, err := s.g.DependencyChains(cmdTree, files); err == nil && len(chains) > 0 {
+				step.Chain = shortestChain(chains).String()
+			}
+		}
+		resp.Steps = append(resp.Steps, step)
+	}
+	writeJSON(w, resp)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Warn("could not write the response", "err", err)
+	}
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveAddrFlag, "addr", "localhost:8080", "address to listen on")
+	serveCmd.Flags().BoolVar(&serveUIFlag, "ui", false, "serve a page for exploring the graph at /")
+	rootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func TestGraphServer(t *testing.T) {
+	report := `{"CmdTree":["cc a.c"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["cc a.c"],"Mode":"W","File":"/out/a.o"}
+{"CmdTree":["ld"],"Mode":"R","File":"/out/a.o"}
+`
+	g, err := stepselection.NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newGraphServer(g, true))
+	defer srv.Close()
+	get := func(path string, v interface{}) int {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatalf("%v: %v", path, err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	var steps struct{ Steps []string }
+	get("/api/steps?q=cc", &steps)
+	if diff := cmp.Diff([]string{`["cc a.c"]`}, steps.Steps); diff != "" {
+		t.Errorf("/api/steps: (-want +got)\n%s", diff)
+	}
+
+	var step servedStep
+	get("/api/step?name="+url.QueryEscape(`["ld"]`), &step)
+	want := servedStep{Name: `["ld"]`, Reads: []string{"/out/a.o"}, Writes: []string{}, Inputs: []string{"/out/a.o", "/src/a.c"}, Dirs: []string{}}
+	if diff := cmp.Diff(want, step); diff != "" {
+		t.Errorf("/api/step: (-want +got)\n%s", diff)
+	}
+	if code := get("/api/step?name="+url.QueryEscape(`["nope"]`), nil); code != http.StatusNotFound {
+		t.Errorf("/api/step of an unknown step: got status %v", code)
+	}
+
+	var impact struct{ Steps []impactedStep }
+	get("/api/impact?file=/src/a.c", &impact)
+	if len(impact.Steps) != 2 || impact.Steps[1].Name != `["ld"]` || !strings.Contains(impact.Steps[1].Chain, "/out/a.o") {
+		t.Errorf("/api/impact: got %+v", impact.Steps)
+	}
+
+	if code := get("/", nil); code != http.StatusOK {
+		t.Errorf("/: got status %v", code)
+	}
+}
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/yourbase/skipper/shellscript"
+)
+
+var (
+	shShellFlag string
+	shPrintFlag bool
+)
+
+var shCmd = &cobra.Command{
+	Use:   "sh <script> [args]...",
+	Short: "Run a shell script, skipping the commands that don't need to run",
+	Long: `Runs a shell script with every top-level command wrapped by skipper, so that
+a CI script becomes skipper-aware by changing its shebang to
+
+	#!/usr/local/bin/skipper sh
+
+Simple commands run as "skipper -- <command>". Pipelines and commands with
+redirections run as "skipper -- <shell> -c <command>", so that a skipped
+command doesn't truncate the files it writes. Shell builtins like cd and
+export, unless piped, variable assignments, functions defined by the script,
+compound commands like if and for, and commands with here-documents always
+run, in the script's shell. Variables are exported, with set -a, so that the
+commands run by other shells see them.
+
+Flags given to skipper sh are passed on to the skipper of each command.
+With --print, the wrapped script is printed instead of run.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		b, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		self, err := os.Executable()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not find the skipper executable: %v\n", err)
+			os.Exit(1)
+		}
+		skipper := append([]string{self}, forwardedFlags(os.Args, args)...)
+		script, err := wrapScript(string(b), skipper, shShellFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", args[0], err)
+			os.Exit(1)
+		}
+		if shPrintFlag {
+			fmt.Print(script)
+			return
+		}
+		// The script is $0, as if it was run directly.
+		sh := exec.Command(shShellFlag, append([]string{"-c", script}, args...)...)
+		sh.Stdin, sh.Stdout, sh.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := sh.Run(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				os.Exit(exitErr.ExitCode())
+			}
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// shellBuiltins lists the commands that change the state of the shell or
+// are too cheap to be worth a decision, which always run in the script's
+// shell.
+var shellBuiltins = map[string]bool{
+	":": true, ".": true, "[": true, "alias": true, "bg": true, "break": true,
+	"cd": true, "command": true, "continue": true, "declare": true, "echo": true,
+	"eval": true, "exec": true, "exit": true, "export": true, "false": true,
+	"fg": true, "getopts": true, "hash": true, "jobs": true, "kill": true,
+	"let": true, "local": true, "popd": true, "printf": true, "pushd": true,
+	"pwd": true, "read": true, "readonly": true, "return": true, "set": true,
+	"shift": true, "source": true, "test": true, "times": true, "trap": true,
+	"true": true, "type": true, "typeset": true, "ulimit": true, "umask": true,
+	"unalias": true, "unset": true, "wait": true,
+}
+
+// wrapScript returns script with its top-level commands wrapped by the
+// skipper command line. Wrapped commands that need a shell of their own run
+// in shell.
+func wrapScript(script string, skipper []string, shell string) (string, error) {
+	cmds, err := shellscript.Parse(script)
+	if err != nil {
+		return "", err
+	}
+	funcs := map[string]bool{}
+	for _, c := range cmds {
+		if c.Func != "" {
+			funcs[c.Func] = true
+		}
+	}
+	var quoted []string
+	for _, arg := range skipper {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	// The prelude is on the first line, so that line numbers in errors
+	// still match the script.
+	out := new(strings.Builder)
+	fmt.Fprintf(out, "__skipper_shell=%s; set -a; ", shellQuote(shell))
+	prefix := strings.Join(quoted, " ") + " -- "
+	prev := 0
+	for _, c := range cmds {
+		out.WriteString(script[prev:c.Start])
+		prev = c.End
+		switch {
+		case c.Compound, c.Heredoc, c.Func != "", len(c.Words) == 0, funcs[c.Words[0]],
+			shellBuiltins[c.Words[0]] && !c.Pipe,
+			isAssignment(c.Words[0]) && allAssignments(c.Words):
+			out.WriteString(c.Text)
+		case c.Pipe, c.Redirect, isAssignment(c.Words[0]):
+			text := strings.Replace(c.Text, "\\\n", "", -1)
+			out.WriteString(prefix + `"$__skipper_shell" -c ` + shellQuote(text))
+		default:
+			out.WriteString(prefix + c.Text)
+		}
+	}
+	out.WriteString(script[prev:])
+	return out.String(), nil
+}
+
+func isAssignment(word string) bool {
+	i := strings.Index(word, "=")
+	if i <= 0 {
+		return false
+	}
+	for j, c := range word[:i] {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || j > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func allAssignments(words []string) bool {
+	for _, w := range words {
+		if !isAssignment(w) {
+			return false
+		}
+	}
+	return true
+}
+
+// shellQuote quotes s for the shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// forwardedFlags returns the skipper flags in osArgs, the command line of
+// skipper sh, that apply to the commands of the script: those given before
+// the script, cmdArgs[0], except for the sh command and its own flags.
+func forwardedFlags(osArgs, cmdArgs []string) []string {
+	var flags []string
+	seenSh := false
+	prefix := osArgs[1 : len(osArgs)-len(cmdArgs)]
+	for i := 0; i < len(prefix); i++ {
+		a := prefix[i]
+		switch {
+		case !seenSh && a == "sh":
+			seenSh = true
+		case seenSh && a == "--shell":
+			i++
+		case seenSh && (strings.HasPrefix(a, "--shell=") || a == "--print" || strings.HasPrefix(a, "--print=")):
+		case a == "--":
+		default:
+			flags = append(flags, a)
+		}
+	}
+	return flags
+}
+
+func init() {
+	shCmd.Flags().StringVar(&shShellFlag, "shell", "/bin/sh", "shell that runs the script and the wrapped commands that need one")
+	shCmd.Flags().BoolVar(&shPrintFlag, "print", false, "print the wrapped script instead of running it")
+	// Flags after the script are the script's.
+	shCmd.Flags().SetInterspersed(false)
+	rootCmd.AddCommand(shCmd)
+}
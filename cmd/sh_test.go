@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWrapScript(t *testing.T) {
+	script := `#!/usr/bin/skipper sh
+set -e
+PKGS=./...
+build() { go build "$@"; }
+cd src && go test $PKGS
+go vet $PKGS | tee vet.log
+GOOS=linux go build \
+  -o out/app .
+build ./cmd
+if true; then make; fi
+echo done
+`
+	got, err := wrapScript(script, []string{"/bin/skipper", "--dep-graph", "it's.gz"}, "/bin/bash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := `'/bin/skipper' '--dep-graph' 'it'\''s.gz' -- `
+	want := `__skipper_shell='/bin/bash'; set -a; #!/usr/bin/skipper sh
+set -e
+PKGS=./...
+build() { go build "$@"; }
+cd src && ` + s + `go test $PKGS
+` + s + `"$__skipper_shell" -c 'go vet $PKGS | tee vet.log'
+` + s + `"$__skipper_shell" -c 'GOOS=linux go build   -o out/app .'
+build ./cmd
+if true; then make; fi
+echo done
+`
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+	if _, err := wrapScript("if true; then", nil, "/bin/sh"); err == nil {
+		t.Errorf("got no error for an invalid script")
+	}
+}
+
+func TestForwardedFlags(t *testing.T) {
+	for _, tc := range []struct {
+		osArgs, cmdArgs, want []string
+	}{
+		{[]string{"skipper", "sh", "ci.sh"}, []string{"ci.sh"}, nil},
+		{[]string{"/bin/skipper", "sh", "ci.sh", "--print"}, []string{"ci.sh", "--print"}, nil},
+		{
+			[]string{"skipper", "--dep-graph", "g.gz", "sh", "--shell", "/bin/bash", "--print", "--tags=unit", "--", "ci.sh", "a"},
+			[]string{"ci.sh", "a"},
+			[]string{"--dep-graph", "g.gz", "--tags=unit"},
+		},
+	} {
+		if diff := cmp.Diff(tc.want, forwardedFlags(tc.osArgs, tc.cmdArgs)); diff != "" {
+			t.Errorf("%q: (-want +got)\n%s", tc.osArgs, diff)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/yourbase/skipper/signing"
+)
+
+var graphSignKeyFlag string
+
+var graphSignCmd = &cobra.Command{
+	Use:   "sign <file>...",
+	Short: "Sign graph files so that they can be verified when loaded",
+	Long: `Signs build reports, compiled graphs or SQLite graphs with an ed25519 private
+key, writing each signature next to the file with a .sig extension appended.
+Signatures must be published along with the graph files.
+
+When --graph-public-key or the "graph_public_key" config key is set, skipper
+only loads graph files whose signature matches the public key, so that a base
+graph fetched from CI can't be tampered with to skip steps. Graph files that
+fail verification aren't loaded, and every step runs. A compiled graph that
+fails verification is ignored in favor of the report.
+
+A key pair is created with "skipper graph keygen".`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if graphSignKeyFlag == "" {
+			fmt.Fprintln(os.Stderr, "--key must be given")
+			os.Exit(1)
+		}
+		for _, f := range args {
+			if err := signFile(f, graphSignKeyFlag); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not sign %v: %v\n", f, err)
+				os.Exit(1)
+			}
+			fmt.Printf("skipper: signed %v in %v\n", f, signing.SigPath(f))
+		}
+	},
+}
+
+// signFile signs file with the private key in keyFile.
+func signFile(file, keyFile string) error {
+	key, err := signing.ReadPrivateKey(keyFile)
+	if err != nil {
+		return err
+	}
+	return signing.SignFile(file, key)
+}
+
+var graphKeygenCmd = &cobra.Command{
+	Use:   "keygen <name>",
+	Short: "Create a key pair for signing graphs",
+	Long: `Creates an ed25519 key pair for "skipper graph sign": the private key in
+<name>, readable only by its owner, and the public key in <name>.pub. Keep the
+private key secret, in the CI system that builds the base graph.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pub, priv, err := signing.GenerateKey()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		for _, f := range []string{args[0], args[0] + ".pub"} {
+			if _, err := os.Stat(f); err == nil {
+				fmt.Fprintf(os.Stderr, "%v already exists\n", f)
+				os.Exit(1)
+			}
+		}
+		if err := ioutil.WriteFile(args[0], priv, 0600); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := ioutil.WriteFile(args[0]+".pub", pub, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("skipper: wrote the private key to %v and the public key to %v.pub\n", args[0], args[0])
+	},
+}
+
+func init() {
+	graphSignCmd.Flags().StringVar(&graphSignKeyFlag, "key", "", "PEM file of the ed25519 private key to sign with")
+	graphCmd.AddCommand(graphSignCmd)
+	graphCmd.AddCommand(graphKeygenCmd)
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/yourbase/skipper/signing"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func TestLoadSignedGraph(t *testing.T) {
+	dir := t.TempDir()
+	pub, priv, err := signing.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile, pubFile := filepath.Join(dir, "key"), filepath.Join(dir, "key.pub")
+	if err := os.WriteFile(keyFile, priv, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pubFile, pub, 0644); err != nil {
+		t.Fatal(err)
+	}
+	report := `{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}` + "\n"
+	graph := filepath.Join(dir, "graph.json")
+	if err := os.WriteFile(graph, []byte(report), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := signFile(graph, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	// A step re-recorded since the graph was written.
+	learned := filepath.Join(dir, "learned")
+	if err := os.Mkdir(learned, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(learned, "b-1.gz"), []byte(`{"CmdTree":["ld"],"Mode":"R","File":"/src/a.o"}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(learned, "b-1.gz"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	defer func(d string) { learnDirFlag = d }(learnDirFlag)
+	learnDirFlag = learned
+	viper.Set("graph_public_key", pubFile)
+	defer viper.Set("graph_public_key", nil)
+
+	files := []string{graph}
+	if _, err := exec.LookPath("sqlite3"); err == nil {
+		db := filepath.Join(dir, "graph.db")
+		if err := stepselection.WriteSQLiteGraph(db, strings.NewReader(report)); err != nil {
+			t.Fatal(err)
+		}
+		if err := signFile(db, keyFile); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, db)
+	}
+	for _, file := range files {
+		g, err := loadGraph(file)
+		if err != nil {
+			t.Fatalf("%v: %v", file, err)
+		}
+		if run, _, err := g.StepDependsOnFiles(stepselection.CmdTree{"cc"}, []string{"/src/a.c"}); err != nil || !run {
+			t.Errorf("%v: got %v, %v, wanted cc to depend on a.c", file, run, err)
+		}
+		// Learned reports aren't signed, so they aren't merged.
+		if _, _, err := g.StepDependsOnFiles(stepselection.CmdTree{"ld"}, nil); !errors.Is(err, stepselection.ErrUnknownStep) {
+			t.Errorf("%v: the unsigned re-recorded step was merged, got %v", file, err)
+		}
+
+		// The signature of other contents doesn't verify.
+		signed, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, append(signed, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadGraph(file); !errors.Is(err, signing.ErrBadSignature) {
+			t.Errorf("%v: loading a tampered graph: got %v, wanted ErrBadSignature", file, err)
+		}
+	}
+
+	// Without a public key, the re-recorded step is merged.
+	viper.Set("graph_public_key", nil)
+	g, err := loadGraph(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := g.StepDependsOnFiles(stepselection.CmdTree{"ld"}, nil); err != nil {
+		t.Errorf("the re-recorded step wasn't merged: %v", err)
+	}
+}
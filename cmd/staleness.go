@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/yourbase/skipper/builddata"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// readReportMetadata returns the header of the build report file, with its
+// creation time and commit, or nil if it can't be read.
+func readReportMetadata(file string) *stepselection.ReportHeader {
+	r, err := builddata.OpenFile(file)
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+	h, err := stepselection.ReadReportHeader(r)
+	if err != nil {
+		logger.Debug("could not read the report header", "file", file, "err", err)
+		return nil
+	}
+	return h
+}
+
+// graphStaleness returns why the base graph in logFile is too stale to decide
+// from, according to graphStalenessLimits, or "" if it's fresh enough. Graphs
+// whose reports don't say when and where they were recorded, like graphs read
+// from stdin or SQLite graphs, are assumed to be fresh. commitsBehind counts
+// the commits since commit.
+func graphStaleness(logFile string, now time.Time, commitsBehind func(commit string) (int, bool)) string {
+	maxAge, maxCommits := graphStalenessLimits()
+	if maxAge <= 0 && maxCommits <= 0 || logFile == stdinName {
+		return ""
+	}
+	files, err := graphFiles(logFile)
+	if err != nil {
+		return ""
+	}
+	for _, file := range files {
+		if isSQLiteGraph(file) || filepath.Ext(file) == ".bin" {
+			continue
+		}
+		h := readReportMetadata(file)
+		if h == nil {
+			continue
+		}
+		if maxAge > 0 && h.Created != nil {
+			if days := int(now.Sub(*h.Created).Hours() / 24); days > maxAge {
+				return fmt.Sprintf("the base graph %v was recorded %d days ago, more than max_graph_age_days", file, days)
+			}
+		}
+		if maxCommits > 0 && h.Commit != "" {
+			n, ok := commitsBehind(h.Commit)
+			if !ok {
+				logger.Debug("could not count the commits since the base graph", "graph", file, "commit", h.Commit)
+				continue
+			}
+			if n > maxCommits {
+				return fmt.Sprintf("the base graph %v was recorded %d commits ago, more than max_graph_commits_behind", file, n)
+			}
+		}
+	}
+	return ""
+}
+
+// gitCommitsBehind counts the commits of HEAD since commit, which must be in
+// the repository of the current directory.
+func gitCommitsBehind(commit string) (int, bool) {
+	out := gitOutput("rev-list", "--count", commit+"..HEAD")
+	n, err := strconv.Atoi(out)
+	return n, err == nil
+}
+
+// staleGraphReason applies staleGraphPolicy to the base graph in logFile, if
+// it's stale: "warn" only warns, while "run" makes every step run. It returns
+// why steps must run, or "".
+func staleGraphReason(logFile string) (string, error) {
+	policy, err := staleGraphPolicy()
+	if err != nil {
+		return "", err
+	}
+	stale := graphStaleness(logFile, time.Now(), gitCommitsBehind)
+	if stale == "" {
+		return "", nil
+	}
+	if policy == "run" {
+		return stale, nil
+	}
+	logger.Warn("deciding from a stale base graph", "reason", stale)
+	return "", nil
+}
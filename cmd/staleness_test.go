@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func TestGraphStaleness(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "report.json")
+	report := `{"SkipperReport":1,"Created":"2020-01-01T00:00:00Z","Commit":"abc123"}
+{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}
+`
+	if err := ioutil.WriteFile(file, []byte(report), 0644); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 1, 11, 0, 0, 0, 0, time.UTC)
+	behind := func(commit string) (int, bool) {
+		if commit != "abc123" {
+			t.Errorf("counted the commits since %q", commit)
+		}
+		return 20, true
+	}
+	defer viper.Set("max_graph_age_days", nil)
+	defer viper.Set("max_graph_commits_behind", nil)
+
+	for _, test := range []struct {
+		days, commits int
+		want          string
+	}{
+		{0, 0, ""},
+		{30, 50, ""},
+		{7, 0, "recorded 10 days ago"},
+		{0, 10, "recorded 20 commits ago"},
+	} {
+		viper.Set("max_graph_age_days", test.days)
+		viper.Set("max_graph_commits_behind", test.commits)
+		got := graphStaleness(file, now, behind)
+		if test.want == "" && got != "" || !strings.Contains(got, test.want) {
+			t.Errorf("%d days, %d commits: got %q, wanted %q", test.days, test.commits, got, test.want)
+		}
+	}
+	if got := graphStaleness(stdinName, now, behind); got != "" {
+		t.Errorf("stdin: got %q", got)
+	}
+}
+
+func TestStaleGraphCommands(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "report.json")
+	report := `{"SkipperReport":1,"Created":"2020-01-01T00:00:00Z"}
+{"CmdTree":["cc a.c"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["cc b.c"],"Mode":"R","File":"/src/b.c"}
+`
+	if err := ioutil.WriteFile(file, []byte(report), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(file string, changed []string) { graphFileFlag, changedFileFlag = file, changed }(graphFileFlag, changedFileFlag)
+	graphFileFlag, changedFileFlag = file, []string{"/src/a.c"}
+	defer viper.Set("max_graph_age_days", nil)
+	defer viper.Set("stale_graph", nil)
+	viper.Set("max_graph_age_days", 7)
+
+	for _, policy := range []string{"warn", "run"} {
+		viper.Set("stale_graph", policy)
+		want := []string{"cc a.c"}
+		if policy == "run" {
+			want = []string{"cc a.c", "cc b.c"}
+		}
+		got, err := filterSteps([]string{"cc a.c", "cc b.c"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("stale_graph %v: filtered %q, wanted %q", policy, got, want)
+		}
+	}
+}
+
+func TestDaemonStaleness(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "report.json")
+	report := `{"SkipperReport":1,"Created":"2020-01-01T00:00:00Z"}
+{"CmdTree":["make"],"Mode":"R","File":"/src/a.c"}
+`
+	if err := ioutil.WriteFile(file, []byte(report), 0644); err != nil {
+		t.Fatal(err)
+	}
+	g, err := stepselection.NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer viper.Set("max_graph_age_days", nil)
+	defer viper.Set("stale_graph", nil)
+	viper.Set("stale_graph", "run")
+
+	// The graph was fresh enough when the daemon started.
+	d := &daemon{graph: file, depGraph: g, staleChecked: time.Now(), metrics: newDaemonMetrics(g)}
+	viper.Set("max_graph_age_days", 7)
+	if resp := d.decide([]string{"make"}, []string{"/src/b.c"}, nil); resp.Run {
+		t.Errorf("got %+v, wanted the step to be skipped until the next check", resp)
+	}
+	d.staleChecked = time.Now().Add(-staleCheckInterval)
+	if resp := d.decide([]string{"make"}, []string{"/src/b.c"}, nil); !resp.Run || !strings.Contains(resp.Reason, "days ago") {
+		t.Errorf("got %+v, wanted the step to run on a stale graph", resp)
+	}
+}
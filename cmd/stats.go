@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yourbase/skipper/journal"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+var statsTopFlag int
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Summarize the skip decisions of past builds",
+	Long: `Reads the decisions journaled in --journal-dir and prints the skip rate, the
+steps that run most often and an estimate of the time saved by skipping,
+based on how long the skipped steps took when they ran. Decisions about steps
+missing from the base dependency graph are counted too, since many of them
+usually mean the graph doesn't match the build. With --tags, only the
+decisions about steps with at least one of the tags are summarized.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if journalDirFlag == "" {
+			fmt.Fprintln(os.Stderr, "The journal is disabled, set --journal-dir")
+			os.Exit(1)
+		}
+		entries, err := journal.Read(journalDirFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not read the journal: %v\n", err)
+			os.Exit(1)
+		}
+		if entries, err = taggedEntries(entries); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		st := journal.Summarize(entries)
+		fmt.Printf("builds: %d\n", st.Builds)
+		fmt.Printf("decisions: %d run, %d skipped (%.1f%% skip rate)\n", st.Runs, st.Skips, 100*st.SkipRate())
+		fmt.Printf("estimated time saved: %v", st.Saved.Round(time.Second))
+		if st.Unestimated > 0 {
+			fmt.Printf(", not counting %d skips of steps that never ran", st.Unestimated)
+		}
+		fmt.Println()
+		if st.Unknown > 0 {
+			fmt.Printf("unknown steps: %d decisions about %d steps missing from the base graph (%.1f%% of decisions)\n", st.Unknown, st.UnknownSteps, 100*float64(st.Unknown)/float64(st.Runs+st.Skips))
+		}
+		if len(st.Steps) == 0 {
+			return
+		}
+		fmt.Println("most frequently run steps:")
+		for i, s := range st.Steps {
+			if i == statsTopFlag || s.Runs == 0 {
+				break
+			}
+			fmt.Printf("  %v: ran %d times, skipped %d times, %v on average\n", s.Step, s.Runs, s.Skips, s.MeanDuration().Round(time.Millisecond))
+		}
+	},
+}
+
+// taggedEntries returns the entries about steps with at least one of the
+// tags of --tags, or all of them without --tags.
+func taggedEntries(entries []journal.Entry) ([]journal.Entry, error) {
+	if len(tagsFlag) == 0 {
+		return entries, nil
+	}
+	tagger, err := stepTagger()
+	if err != nil {
+		return nil, err
+	}
+	var tagged []journal.Entry
+	for _, e := range entries {
+		var step stepselection.CmdTree
+		if err := json.Unmarshal([]byte(e.Step), &step); err != nil {
+			logger.Warn("ignoring a decision about an invalid step name", "step", e.Step, "err", err)
+			continue
+		}
+		if tagger.HasAnyTag(step, tagsFlag) {
+			tagged = append(tagged, e)
+		}
+	}
+	return tagged, nil
+}
+
+// journalDecision appends e to the journal and to the audit log, if they're
+// enabled. Failing to journal doesn't affect the build.
+func journalDecision(e *journal.Entry) {
+	auditDecision(e)
+	if journalDirFlag == "" {
+		return
+	}
+	if err := journal.Append(journalDirFlag, e); err != nil {
+		logger.Warn("could not journal the decision", "step", e.Step, "err", err)
+	}
+}
+
+func defaultJournalDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "skipper", "journal")
+}
+
+func init() {
+	statsCmd.Flags().IntVar(&statsTopFlag, "top", 10, "number of most frequently run steps to list")
+	rootCmd.AddCommand(statsCmd)
+}
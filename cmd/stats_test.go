@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/viper"
+	"github.com/yourbase/skipper/journal"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func TestTaggedEntries(t *testing.T) {
+	generate := stepselection.CmdTree{"go generate ./..."}.Name()
+	testA := stepselection.CmdTree{"go test ./a"}.Name()
+	testB := stepselection.CmdTree{"make", "go test ./b"}.Name()
+	entries := []journal.Entry{
+		{Step: generate, Run: true},
+		{Step: testA, Run: true},
+		{Step: testB},
+		{Step: "not a step"},
+	}
+	defer func(tags []string) { tagsFlag = tags }(tagsFlag)
+	viper.Set("tags", []map[string]any{{"pattern": "^go test", "tags": []string{"unit-tests"}}})
+	defer viper.Set("tags", nil)
+
+	for _, tc := range []struct {
+		tags []string
+		want []string
+	}{
+		{nil, []string{generate, testA, testB, "not a step"}},
+		{[]string{"unit-tests"}, []string{testA, testB}},
+		{[]string{"codegen"}, nil},
+	} {
+		tagsFlag = tc.tags
+		tagged, err := taggedEntries(entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range tagged {
+			got = append(got, e.Step)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("--tags %v: (-want +got)\n%s", tc.tags, diff)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/yourbase/skipper/builddata"
+)
+
+// stdinName is the --dep-graph and --changes file name that reads stdin
+// instead, so that skipper can be used in pipelines:
+//
+//	git diff --name-only | skipper --changes - -- make test
+//
+// When both are read from stdin, the changes come first and end at the first
+// empty line, or empty NUL-terminated path. The build report follows.
+const stdinName = "-"
+
+var (
+	stdin = bufio.NewReader(os.Stdin)
+
+	stdinChangesOnce sync.Once
+	stdinChanges     map[string]bool
+	stdinChangesErr  error
+)
+
+// readStdinChanges reads the changes on stdin. It only reads stdin the first
+// time it's called.
+func readStdinChanges() (map[string]bool, error) {
+	stdinChangesOnce.Do(func() {
+		stdinChanges, stdinChangesErr = readChanges(stdin, changesFormatFlag, graphFileFlag == stdinName)
+	})
+	return stdinChanges, stdinChangesErr
+}
+
+// openBuildReport opens the build report in file, or on stdin if file is
+// "-". The changes are read first when they're on stdin too. Tables of
+// steps and files are converted to build reports, see graphFormat.
+func openBuildReport(file string) (io.ReadCloser, error) {
+	key, err := graphPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		b, err := readVerifiedGraphFile(file, key)
+		if err != nil {
+			return nil, err
+		}
+		rc, err := builddata.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("Could not decompress file %v: %v", file, err)
+		}
+		return convertGraphFormat(file, rc)
+	}
+	if file != stdinName {
+		rc, err := builddata.OpenFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return convertGraphFormat(file, rc)
+	}
+	if changesFileFlag == stdinName {
+		if _, err := readStdinChanges(); err != nil {
+			return nil, err
+		}
+	}
+	rc, err := builddata.NewReader(stdin)
+	if err != nil {
+		return nil, err
+	}
+	return convertGraphFormat(file, rc)
+}
@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+var (
+	testsFrameworkFlag string
+	testsSkippedFlag   bool
+	testsJSONFlag      bool
+)
+
+var testsCmd = &cobra.Command{
+	Use:   "tests",
+	Short: "Print the test targets that must run",
+	Long: `Finds the "go test" and pytest steps recorded in the graph and prints the
+packages or test files they ran that must run again because of the changes,
+one per line, ready to be passed back to the test runner:
+
+	go test $(skipper tests --framework go)
+
+With --skipped, the targets safe to skip are printed instead, and --json
+prints both. Each test step is decided on its own read set, so selection is
+only as fine as the steps recorded: record each package or test file as its
+own step, or import them with "skipper import go --package-step".
+
+A target recorded by several steps runs if any of them must. Test steps
+matching an always-run rule always run, and every test step runs if the
+graph is too stale with "stale_graph: run" or a file of the "invalidate_all"
+config key changed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		switch testsFrameworkFlag {
+		case "", "go", "pytest":
+		default:
+			fmt.Fprintf(os.Stderr, "invalid --framework %q, must be go or pytest\n", testsFrameworkFlag)
+			os.Exit(1)
+		}
+		sel, err := selectTests(testsFrameworkFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if testsJSONFlag {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(sel); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+		targets := sel.Run
+		if testsSkippedFlag {
+			targets = sel.Skip
+		}
+		for _, t := range targets {
+			fmt.Println(t)
+		}
+	},
+}
+
+// testSelection holds the test targets found in the graph, split by whether
+// they must run.
+type testSelection struct {
+	Run  []string
+	Skip []string
+}
+
+// selectTests decides which of the test targets of framework, or of every
+// framework if it's empty, must run.
+func selectTests(framework string) (*testSelection, error) {
+	changed, err := changedNodes()
+	if err != nil {
+		return nil, fmt.Errorf("could not determine the changed files: %v", err)
+	}
+	opts, err := graphOptions()
+	if err != nil {
+		return nil, err
+	}
+	g, err := loadDependencyGraph(graphFileFlag, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not load the base dependency graph: %v", err)
+	}
+	g.SetFuzzyMatch(fuzzyMatch())
+	skipCheck, err := graphSkipper(g, changed)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for f := range changed {
+		files = append(files, f)
+	}
+	affected := map[string]bool{}
+	for _, name := range g.StepsAffectedBy(files) {
+		affected[name] = true
+	}
+
+	run := map[string]bool{}
+	var order []string
+	for _, name := range g.Steps() {
+		var cmdTree stepselection.CmdTree
+		if err := json.Unmarshal([]byte(name), &cmdTree); err != nil || len(cmdTree) == 0 {
+			continue
+		}
+		fw, targets := testTargets(cmdTree[len(cmdTree)-1])
+		if fw == "" || (framework != "" && fw != framework) {
+			continue
+		}
+		mustRun, _, ok := skipCheck.policyDecision(cmdTree)
+		if !ok {
+			mustRun = affected[name]
+		}
+		for _, t := range targets {
+			if _, ok := run[t]; !ok {
+				order = append(order, t)
+			}
+			run[t] = run[t] || mustRun
+		}
+	}
+	sel := &testSelection{Run: []string{}, Skip: []string{}}
+	for _, t := range order {
+		if run[t] {
+			sel.Run = append(sel.Run, t)
+		} else {
+			sel.Skip = append(sel.Skip, t)
+		}
+	}
+	return sel, nil
+}
+
+// testValueFlags lists the flags of each test runner that take a separate
+// value, which mustn't be mistaken for a target.
+var testValueFlags = map[string]map[string]bool{
+	"go": {
+		"-run": true, "-skip": true, "-count": true, "-timeout": true, "-tags": true,
+		"-p": true, "-parallel": true, "-bench": true, "-benchtime": true, "-cpu": true,
+		"-coverprofile": true, "-coverpkg": true, "-covermode": true, "-o": true,
+		"-exec": true, "-ldflags": true, "-gcflags": true, "-shuffle": true,
+	},
+	"pytest": {
+		"-k": true, "-m": true, "-p": true, "-c": true, "-o": true, "-n": true,
+		"--rootdir": true, "--junitxml": true, "--maxfail": true, "--deselect": true,
+		"--ignore": true, "--tb": true, "--durations": true, "--cov": true,
+	},
+}
+
+// testTargets returns the test runner a step's command runs, "go" or
+// "pytest", and the packages or test files it was given, or "." if none.
+// It returns an empty framework for other commands.
+func testTargets(command string) (framework string, targets []string) {
+	args := strings.Fields(command)
+	switch {
+	case len(args) >= 2 && filepath.Base(args[0]) == "go" && args[1] == "test":
+		framework, args = "go", args[2:]
+	case len(args) >= 1 && (filepath.Base(args[0]) == "pytest" || filepath.Base(args[0]) == "py.test"):
+		framework, args = "pytest", args[1:]
+	case len(args) >= 3 && strings.HasPrefix(filepath.Base(args[0]), "python") && args[1] == "-m" && args[2] == "pytest":
+		framework, args = "pytest", args[3:]
+	default:
+		return "", nil
+	}
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if framework == "go" && (a == "-args" || a == "--args") {
+			// The rest goes to the test binary.
+			break
+		}
+		if strings.HasPrefix(a, "-") {
+			if testValueFlags[framework][a] {
+				i++
+			}
+			continue
+		}
+		targets = append(targets, a)
+	}
+	if len(targets) == 0 {
+		targets = []string{"."}
+	}
+	return framework, targets
+}
+
+func init() {
+	testsCmd.Flags().StringVar(&testsFrameworkFlag, "framework", "", "only consider the tests of this runner: go or pytest. By default both are")
+	testsCmd.Flags().BoolVar(&testsSkippedFlag, "skipped", false, "print the targets that are safe to skip instead of the ones that must run")
+	testsCmd.Flags().BoolVar(&testsJSONFlag, "json", false, `print both lists as a JSON object with "Run" and "Skip" arrays`)
+	rootCmd.AddCommand(testsCmd)
+}
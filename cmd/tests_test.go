@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTestTargets(t *testing.T) {
+	for _, tc := range []struct {
+		command       string
+		wantFramework string
+		wantTargets   []string
+	}{
+		{"go test ./a ./b/...", "go", []string{"./a", "./b/..."}},
+		{"go test -run TestA -count=1 -v example.com/m/a", "go", []string{"example.com/m/a"}},
+		{"/usr/local/go/bin/go test -args -flag x", "go", []string{"."}},
+		{"pytest -k slow -x tests/test_a.py tests/test_b.py::test_one", "pytest", []string{"tests/test_a.py", "tests/test_b.py::test_one"}},
+		{"python3 -m pytest --junitxml out.xml tests", "pytest", []string{"tests"}},
+		{"py.test", "pytest", []string{"."}},
+		{"go build ./...", "", nil},
+		{"make test", "", nil},
+	} {
+		framework, targets := testTargets(tc.command)
+		if framework != tc.wantFramework {
+			t.Errorf("%q: got framework %q, want %q", tc.command, framework, tc.wantFramework)
+		}
+		if diff := cmp.Diff(tc.wantTargets, targets); diff != "" {
+			t.Errorf("%q: (-want +got)\n%s", tc.command, diff)
+		}
+	}
+}
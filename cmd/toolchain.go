@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// toolchainCommands returns the tools whose fingerprint is recorded with the
+// steps that run them, from the "toolchains" config key. A tool given by
+// name alone is fingerprinted by the hash of its binary. A tool given with
+// arguments is fingerprinted by the hash of the output of running it with
+// them, which is cheaper for large binaries and sees the files they load.
+// Example config:
+//
+//	toolchains: [gcc, "go version", "python3 --version"]
+func toolchainCommands() []string {
+	return viper.GetStringSlice("toolchains")
+}
+
+// toolchainFingerprints computes the fingerprints of the tools of
+// toolchainCommands, by the paths steps run them with. It's safe for
+// concurrent use.
+type toolchainFingerprints struct {
+	// commands holds the commands fingerprinting each tool, by path. Tools
+	// run through a symbolic link have both paths.
+	commands map[string][]string
+
+	mu sync.Mutex
+	// sums caches the fingerprints, which are computed again when the
+	// binary is modified.
+	sums map[string]toolchainSum
+	// seen holds the tools recorded for each step by entry, see entry.
+	seen map[string]bool
+}
+
+type toolchainSum struct {
+	modTime     time.Time
+	size        int64
+	fingerprint string
+}
+
+// newToolchainFingerprints returns the fingerprints of the tools of
+// commands, or nil if there are none. Tools that can't be found are left
+// out.
+func newToolchainFingerprints(commands []string) *toolchainFingerprints {
+	t := &toolchainFingerprints{commands: map[string][]string{}, sums: map[string]toolchainSum{}, seen: map[string]bool{}}
+	for _, c := range commands {
+		args := strings.Fields(c)
+		if len(args) == 0 {
+			continue
+		}
+		path, err := exec.LookPath(args[0])
+		if err != nil {
+			logger.Debug("not fingerprinting a missing tool", "tool", args[0], "err", err)
+			continue
+		}
+		if path, err = filepath.Abs(path); err != nil {
+			continue
+		}
+		args[0] = path
+		t.commands[path] = args
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			t.commands[real] = args
+		}
+	}
+	if len(t.commands) == 0 {
+		return nil
+	}
+	return t
+}
+
+// fingerprint returns the fingerprint of the tool at path, or "" if it
+// can't be computed, like when the tool was removed. It returns false if the
+// tool isn't fingerprinted.
+func (t *toolchainFingerprints) fingerprint(path string) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	args, ok := t.commands[path]
+	if !ok {
+		return "", false
+	}
+	fi, err := os.Stat(args[0])
+	if err != nil {
+		return "", true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.sums[path]; ok && s.modTime.Equal(fi.ModTime()) && s.size == fi.Size() {
+		return s.fingerprint, true
+	}
+	fingerprint, err := toolchainFingerprint(args)
+	if err != nil {
+		logger.Warn("could not fingerprint the tool", "tool", strings.Join(args, " "), "err", err)
+	}
+	t.sums[path] = toolchainSum{modTime: fi.ModTime(), size: fi.Size(), fingerprint: fingerprint}
+	return fingerprint, true
+}
+
+// toolchainFingerprint returns the hash of the binary args[0], or of the
+// output of args if there are arguments.
+func toolchainFingerprint(args []string) (string, error) {
+	h := sha256.New()
+	if len(args) == 1 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+	} else {
+		out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err != nil {
+			return "", err
+		}
+		h.Write(out)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// entry returns the entry recording that the step of bog, an entry of a
+// recorder, ran a fingerprinted tool, or nil if bog isn't the read of one,
+// or if the tool was already recorded for the step.
+func (t *toolchainFingerprints) entry(bog *stepselection.BuildLog) *stepselection.BuildLog {
+	if t == nil || !bog.Reads() || bog.Type != "" {
+		return nil
+	}
+	path := filepath.Clean(bog.File)
+	fingerprint, ok := t.fingerprint(path)
+	if !ok || fingerprint == "" {
+		return nil
+	}
+	file := stepselection.ToolEntry(path, fingerprint)
+	key := stepselection.CmdTree(bog.CmdTree).Name() + "\x00" + file
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.seen[key] {
+		return nil
+	}
+	t.seen[key] = true
+	return &stepselection.BuildLog{CmdTree: bog.CmdTree, File: file, BuildID: bog.BuildID, Type: "tool"}
+}
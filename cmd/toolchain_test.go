@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func TestToolchainFingerprints(t *testing.T) {
+	dir := t.TempDir()
+	tool := filepath.Join(dir, "mycc")
+	if err := os.WriteFile(tool, []byte("#!/bin/sh\necho mycc 1.0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "cc")
+	if err := os.Symlink(tool, link); err != nil {
+		t.Fatal(err)
+	}
+	tools := newToolchainFingerprints([]string{link, "skipper-no-such-tool"})
+	if _, ok := tools.fingerprint(filepath.Join(dir, "other")); ok {
+		t.Errorf("fingerprinted a tool that isn't in the toolchains")
+	}
+	before, ok := tools.fingerprint(link)
+	if !ok || before == "" {
+		t.Fatalf("fingerprint(%v) = %q, %v, want a fingerprint", link, before, ok)
+	}
+	if real, _ := tools.fingerprint(tool); real != before {
+		t.Errorf("the target of the link has fingerprint %q, want %q", real, before)
+	}
+
+	bog := &stepselection.BuildLog{CmdTree: []string{"cc a.c"}, Mode: "R", File: link, BuildID: "b1"}
+	entry := tools.entry(bog)
+	if entry == nil || entry.Type != "tool" || entry.File != stepselection.ToolEntry(link, before) {
+		t.Fatalf("entry(%v) = %+v, want a tool entry", bog, entry)
+	}
+	if again := tools.entry(bog); again != nil {
+		t.Errorf("the tool was recorded twice for the step")
+	}
+
+	if err := os.WriteFile(tool, []byte("#!/bin/sh\necho mycc 2.0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Make sure the modification is seen even on coarse timestamps.
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(tool, later, later)
+	if after, _ := tools.fingerprint(link); after == before {
+		t.Errorf("the fingerprint didn't change with the tool")
+	}
+	os.Remove(tool)
+	if after, ok := tools.fingerprint(link); !ok || after != "" {
+		t.Errorf("fingerprint of a removed tool = %q, %v, want \"\", true", after, ok)
+	}
+}
+
+func TestToolchainVersionFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	tool := filepath.Join(dir, "mycc")
+	if err := os.WriteFile(tool, []byte("#!/bin/sh\necho mycc 1.0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	byVersion, _ := newToolchainFingerprints([]string{tool + " --version"}).fingerprint(tool)
+	byBinary, _ := newToolchainFingerprints([]string{tool}).fingerprint(tool)
+	if byVersion == "" || byVersion == byBinary {
+		t.Errorf("got version fingerprint %q and binary fingerprint %q, want different ones", byVersion, byBinary)
+	}
+}
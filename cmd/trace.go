@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/yourbase/skipper/tracing"
+)
+
+var (
+	// tracer exports the spans of wrapped steps, if an OTLP endpoint is
+	// configured. It's nil otherwise, which disables tracing.
+	tracer *tracing.Tracer
+	// invocationSpan covers the whole skipper invocation. The other spans
+	// are its children.
+	invocationSpan *tracing.Span
+)
+
+// startTrace starts the span of the invocation wrapping step.
+func startTrace(buildID, step string) {
+	tracer = tracing.FromEnv()
+	invocationSpan = tracer.Start("skipper", nil)
+	invocationSpan.SetAttr("skipper.build_id", buildID)
+	if step != "" {
+		invocationSpan.SetAttr("skipper.step", step)
+	}
+}
+
+// finishTrace ends the invocation span and exports the trace. Failing to
+// export it doesn't affect the build.
+func finishTrace() {
+	invocationSpan.End()
+	if err := tracer.Flush(5 * time.Second); err != nil {
+		logger.Warn("could not export the trace", "err", err)
+	}
+}
+
+// exitTraced exports the trace and exits with code.
+func exitTraced(code int) {
+	finishTrace()
+	os.Exit(code)
+}
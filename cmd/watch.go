@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+var (
+	watchDirFlag      string
+	watchDebounceFlag time.Duration
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch [-- <command>]",
+	Short: "Print the affected steps as files change",
+	Long: `Watches the workspace for changes and, after each batch of changes, prints
+the steps of the dependency graph affected by all the files changed so far,
+one per line, whenever they differ from the last batch. The files in
+--changes, if it exists, are changed from the start.
+
+With a command, the command is run after each batch that affects at least
+one step. Changes made while it runs are ignored, as are changes to files
+that steps of the graph write, so that the command's outputs don't trigger
+it again.
+
+The workspace is --dir, by default the project containing the current
+directory: the closest directory with a .skipper directory or a git
+repository.`,
+	Args: cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := watchDirFlag
+		if dir == "" {
+			cwd, err := os.Getwd()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			dir = cwd
+			if root, ok := projectRoot(cwd); ok {
+				dir = root
+			}
+		}
+		opts, err := graphOptions()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		ignore, err := ignoreMatcher()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		g, err := loadDependencyGraph(graphFileFlag, opts...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not load the base dependency graph: %v\n", err)
+			os.Exit(1)
+		}
+		w := newWatchState(g, ignore, os.Stdout)
+		changed, err := changedNodes()
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Could not determine the changed files: %v\n", err)
+			os.Exit(1)
+		}
+		var initial []string
+		for f := range changed {
+			initial = append(initial, f)
+		}
+		w.update(initial)
+
+		fw, err := fsnotify.NewWatcher()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not watch the workspace: %v\n", err)
+			os.Exit(1)
+		}
+		defer fw.Close()
+		if err := watchTree(fw, dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not watch %v: %v\n", dir, err)
+			os.Exit(1)
+		}
+		logger.Info("watching for changes", "dir", dir)
+
+		var batch []string
+		var timer <-chan time.Time
+		for {
+			select {
+			case ev := <-fw.Events:
+				if ev.Op&fsnotify.Create != 0 {
+					if fi, err := os.Lstat(ev.Name); err == nil && fi.IsDir() {
+						// Files created in the directory before it's
+						// watched are missed.
+						if err := watchTree(fw, ev.Name); err != nil {
+							logger.Warn("could not watch a new directory", "dir", ev.Name, "err", err)
+						}
+					}
+				}
+				if ev.Op == fsnotify.Chmod {
+					continue
+				}
+				batch = append(batch, ev.Name)
+				timer = time.After(watchDebounceFlag)
+			case err := <-fw.Errors:
+				logger.Warn("watch error", "err", err)
+			case <-timer:
+				timer = nil
+				affected := w.update(batch)
+				batch = nil
+				if len(args) == 0 || !affected {
+					continue
+				}
+				runWatchCommand(args, dir)
+				// Drop the changes made by the command.
+				drain(fw.Events)
+			}
+		}
+	},
+}
+
+// watchState is the live change set of "skipper watch" and the steps it
+// affects.
+type watchState struct {
+	g       *stepselection.DependencyGraph
+	ignore  *stepselection.PathMatcher
+	out     io.Writer
+	changed map[string]bool
+	// affected is the last list of affected steps printed.
+	affected []string
+}
+
+func newWatchState(g *stepselection.DependencyGraph, ignore *stepselection.PathMatcher, out io.Writer) *watchState {
+	return &watchState{g: g, ignore: ignore, out: out, changed: map[string]bool{}}
+}
+
+// update adds files to the change set and prints the affected steps if they
+// changed. It returns whether any of files affects a step. Ignored files,
+// files in .git and outputs of steps are left out.
+func (w *watchState) update(files []string) bool {
+	var relevant []string
+	for _, f := range files {
+		if w.ignore.Match(f) || isGitPath(f) || len(w.g.FileWriters(f)) > 0 {
+			continue
+		}
+		relevant = append(relevant, f)
+		w.changed[f] = true
+	}
+	if len(relevant) == 0 {
+		return false
+	}
+	var all []string
+	for f := range w.changed {
+		all = append(all, f)
+	}
+	sort.Strings(all)
+	affected := w.g.StepsAffectedBy(all)
+	if strings.Join(affected, "\n") != strings.Join(w.affected, "\n") {
+		w.affected = affected
+		fmt.Fprintf(w.out, "skipper: %d files changed, %d steps affected\n", len(all), len(affected))
+		for _, s := range affected {
+			fmt.Fprintln(w.out, s)
+		}
+	}
+	return len(w.g.StepsAffectedBy(relevant)) > 0
+}
+
+func isGitPath(p string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(p), "/") {
+		if part == ".git" {
+			return true
+		}
+	}
+	return false
+}
+
+// watchTree watches dir and its subdirectories, except .git and .skipper.
+// fsnotify doesn't watch directories recursively.
+func watchTree(fw *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// The file may have been removed since it was listed.
+			return nil
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if name := fi.Name(); p != dir && (name == ".git" || name == ".skipper") {
+			return filepath.SkipDir
+		}
+		return fw.Add(p)
+	})
+}
+
+func runWatchCommand(args []string, dir string) {
+	cm := exec.Command(args[0], args[1:]...)
+	cm.Dir = dir
+	cm.Stdin = os.Stdin
+	cm.Stdout = os.Stdout
+	cm.Stderr = os.Stderr
+	if err := cm.Run(); err != nil {
+		logger.Warn("the command failed", "err", err)
+	}
+}
+
+// drain discards the events already queued.
+func drain(events <-chan fsnotify.Event) {
+	for {
+		select {
+		case <-events:
+		case <-time.After(watchDebounceFlag):
+			return
+		}
+	}
+}
+
+func init() {
+	watchCmd.Flags().StringVar(&watchDirFlag, "dir", "", "directory to watch (default is the project containing the current directory)")
+	watchCmd.Flags().DurationVar(&watchDebounceFlag, "debounce", 200*time.Millisecond, "how long to wait for more changes before updating the affected steps")
+	rootCmd.AddCommand(watchCmd)
+}
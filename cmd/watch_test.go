@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func TestWatchState(t *testing.T) {
+	report := `{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["cc"],"Mode":"W","File":"/out/a.o"}
+{"CmdTree":["ld"],"Mode":"R","File":"/out/a.o"}
+{"CmdTree":["doc"],"Mode":"R","File":"/doc/a.md"}
+`
+	g, err := stepselection.NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	w := newWatchState(g, stepselection.MustPathMatcher([]string{"/tmp/**"}), out)
+	for _, tc := range []struct {
+		files    []string
+		affected bool
+		printed  string
+	}{
+		{[]string{"/src/a.c"}, true, "skipper: 1 files changed, 2 steps affected\n[\"cc\"]\n[\"ld\"]\n"},
+		// Outputs of steps, ignored files and git files don't count.
+		{[]string{"/out/a.o", "/tmp/x", "/src/.git/index"}, false, ""},
+		// The same steps aren't printed again.
+		{[]string{"/src/b.c"}, false, ""},
+		{[]string{"/doc/a.md"}, true, "skipper: 3 files changed, 3 steps affected\n[\"cc\"]\n[\"doc\"]\n[\"ld\"]\n"},
+	} {
+		out.Reset()
+		if got := w.update(tc.files); got != tc.affected {
+			t.Errorf("update(%v) = %v wanted %v", tc.files, got, tc.affected)
+		}
+		if out.String() != tc.printed {
+			t.Errorf("update(%v) printed %q wanted %q", tc.files, out.String(), tc.printed)
+		}
+	}
+}
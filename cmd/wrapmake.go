@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	wrapMakeFileFlag    string
+	wrapMakeOutputFlag  string
+	wrapMakeInPlaceFlag bool
+)
+
+const (
+	wrapMakeBegin = "# Begin skipper wrap-make."
+	wrapMakeEnd   = "# End skipper wrap-make."
+)
+
+var wrapMakeCmd = &cobra.Command{
+	Use:   "wrap-make",
+	Short: "Run every recipe line of a Makefile through skipper",
+	Long: `Makes GNU make run every recipe line as a skipper step, without editing the
+rules, by overriding SHELL for all targets. Make then runs each recipe line
+with "skipper make-shell -- <shell> <flags> <line>", which decides whether
+the line runs like "skipper --" does. The step of a line is named after the
+shell command, like "/bin/sh -c go build ./...".
+
+By default, a new makefile is written next to -f, including it and adding
+the override, to be used with "make -f Makefile.skipper". With --in-place,
+the override is appended to -f itself instead, replacing the one added by a
+previous run. The $(shell ...) calls made while make reads the makefiles
+aren't wrapped, since their output is needed even when nothing changed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		self, err := os.Executable()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not find the skipper executable: %v\n", err)
+			os.Exit(1)
+		}
+		out := wrapMakeOutputFlag
+		var content string
+		if wrapMakeInPlaceFlag {
+			b, err := ioutil.ReadFile(wrapMakeFileFlag)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			out = wrapMakeFileFlag
+			content = removeMakeOverride(string(b)) + makeOverride(self)
+		} else {
+			if out == "" {
+				out = wrapMakeFileFlag + ".skipper"
+			}
+			include, err := filepath.Rel(filepath.Dir(out), wrapMakeFileFlag)
+			if err != nil {
+				include = wrapMakeFileFlag
+			}
+			content = fmt.Sprintf("# Generated by \"skipper wrap-make\", use it with make -f %v.\ninclude %v\n\n%v",
+				filepath.Base(out), include, makeOverride(self))
+		}
+		if err := ioutil.WriteFile(out, []byte(content), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("skipper: wrote %v\n", out)
+	},
+}
+
+// makeOverride returns the makefile lines that run recipes through skipper.
+// The override is target-specific so that it doesn't apply to the $(shell)
+// calls made while parsing, and .SHELLFLAGS is set first so that it keeps
+// the shell and flags set by the makefile.
+func makeOverride(skipper string) string {
+	return wrapMakeBegin + "\n" +
+		"%: .SHELLFLAGS := make-shell -- $(SHELL) $(.SHELLFLAGS)\n" +
+		"%: SHELL := " + skipper + "\n" +
+		wrapMakeEnd + "\n"
+}
+
+// removeMakeOverride removes the override added by an earlier run from a
+// makefile.
+func removeMakeOverride(makefile string) string {
+	begin := strings.Index(makefile, wrapMakeBegin)
+	end := strings.Index(makefile, wrapMakeEnd)
+	if begin < 0 || end < begin {
+		if makefile != "" && !strings.HasSuffix(makefile, "\n") {
+			makefile += "\n"
+		}
+		return makefile
+	}
+	return makefile[:begin] + strings.TrimPrefix(makefile[end+len(wrapMakeEnd):], "\n")
+}
+
+var makeShellCmd = &cobra.Command{
+	Use:    "make-shell -- <shell> <flags>... <line>",
+	Short:  "Run a make recipe line as a step, used as SHELL by wrap-make",
+	Hidden: true,
+	Args:   cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		rootCmd.Run(cmd, args)
+	},
+}
+
+func init() {
+	wrapMakeCmd.Flags().StringVarP(&wrapMakeFileFlag, "file", "f", "Makefile", "makefile to wrap")
+	wrapMakeCmd.Flags().StringVarP(&wrapMakeOutputFlag, "output", "o", "", "makefile to write (default is the -f makefile with a .skipper suffix)")
+	wrapMakeCmd.Flags().BoolVar(&wrapMakeInPlaceFlag, "in-place", false, "add the override to the -f makefile instead of writing a new one")
+	rootCmd.AddCommand(wrapMakeCmd)
+	rootCmd.AddCommand(makeShellCmd)
+}
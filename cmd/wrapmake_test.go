@@ -0,0 +1,17 @@
+package cmd
+
+import "testing"
+
+func TestRemoveMakeOverride(t *testing.T) {
+	makefile := "all:\n\tgo build ./...\n"
+	wrapped := removeMakeOverride(makefile) + makeOverride("/bin/skipper")
+	if again := removeMakeOverride(wrapped) + makeOverride("/bin/skipper"); again != wrapped {
+		t.Errorf("wrapping twice changed the makefile:\n%s", again)
+	}
+	if got := removeMakeOverride(wrapped); got != makefile {
+		t.Errorf("got %q after removing the override, wanted %q", got, makefile)
+	}
+	if got := removeMakeOverride("all:\n\ttrue"); got != "all:\n\ttrue\n" {
+		t.Errorf("got %q, wanted a final new-line", got)
+	}
+}
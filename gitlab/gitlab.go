@@ -0,0 +1,105 @@
+// Package gitlab downloads the build reports of past GitLab CI pipelines,
+// so that pipelines can use the graph of the base branch without wiring
+// artifacts between them by hand.
+package gitlab
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Source identifies an artifact of the latest successful pipeline of a ref.
+type Source struct {
+	// APIURL is the URL of the GitLab API, like https://gitlab.com/api/v4.
+	APIURL string
+	// Project is the ID of the project, or its path, like group/project.
+	Project string
+	// Ref is the branch or tag whose latest successful pipeline is used.
+	Ref string
+	// Job is the name of the job that saved the artifact.
+	Job string
+	// Artifact is the path of the file in the job's artifacts archive.
+	Artifact string
+}
+
+// FromEnv fills the fields of s left empty from the predefined variables of
+// GitLab CI jobs. The ref is the target branch of merge request pipelines,
+// and the default branch otherwise.
+func (s *Source) FromEnv() {
+	fill := func(field *string, envs ...string) {
+		for _, env := range envs {
+			if *field == "" {
+				*field = os.Getenv(env)
+			}
+		}
+	}
+	fill(&s.APIURL, "CI_API_V4_URL")
+	if s.APIURL == "" {
+		s.APIURL = "https://gitlab.com/api/v4"
+	}
+	fill(&s.Project, "CI_PROJECT_ID")
+	fill(&s.Ref, "CI_MERGE_REQUEST_TARGET_BRANCH_NAME", "CI_DEFAULT_BRANCH")
+}
+
+// ErrNotFound is returned by Fetch when there's no such artifact, for
+// example because no pipeline of the ref succeeded yet.
+var ErrNotFound = errors.New("artifact not found")
+
+// Fetch downloads the artifact. It authenticates with token as a personal,
+// project or group access token or, if job is true, as the CI_JOB_TOKEN of
+// a job. The caller must close the artifact.
+func Fetch(s *Source, token string, job bool) (io.ReadCloser, error) {
+	if s.Project == "" || s.Ref == "" || s.Job == "" || s.Artifact == "" {
+		return nil, fmt.Errorf("the project, ref, job and artifact are all needed, got %+v", *s)
+	}
+	u := fmt.Sprintf("%s/projects/%s/jobs/artifacts/%s/raw/%s?job=%s",
+		strings.TrimSuffix(s.APIURL, "/"), url.PathEscape(s.Project), url.PathEscape(s.Ref),
+		escapePath(strings.TrimPrefix(s.Artifact, "/")), url.QueryEscape(s.Job))
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		if job {
+			req.Header.Set("JOB-TOKEN", token)
+		} else {
+			req.Header.Set("PRIVATE-TOKEN", token)
+		}
+	}
+	// Reports can be large, so only connecting and the response headers
+	// are bounded.
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		TLSHandshakeTimeout:   30 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %v of job %q on %v", ErrNotFound, s.Artifact, s.Job, s.Ref)
+		}
+		return nil, fmt.Errorf("GitLab API: %v: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp.Body, nil
+}
+
+// escapePath escapes the elements of a slash-separated path.
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
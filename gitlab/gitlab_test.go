@@ -0,0 +1,79 @@
+package gitlab
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/api/v4/projects/group%2Fproject/jobs/artifacts/main/raw/.skipper/base%20graph.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("job") != "build all" || r.Header.Get("JOB-TOKEN") != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("report"))
+	}))
+	defer srv.Close()
+
+	s := &Source{APIURL: srv.URL + "/api/v4/", Project: "group/project", Ref: "main", Job: "build all", Artifact: ".skipper/base graph.gz"}
+	rc, err := Fetch(s, "secret", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(b) != "report" {
+		t.Errorf("got %q, %v", b, err)
+	}
+
+	if _, err := Fetch(s, "wrong", true); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v for a wrong token", err)
+	}
+	s.Ref = "feature"
+	if _, err := Fetch(s, "secret", true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v for a missing artifact, wanted ErrNotFound", err)
+	}
+	s.Job = ""
+	if _, err := Fetch(s, "secret", true); err == nil {
+		t.Errorf("got no error without a job")
+	}
+}
+
+func TestSourceFromEnv(t *testing.T) {
+	for k, v := range map[string]string{
+		"CI_API_V4_URL":                       "https://gitlab.example.com/api/v4",
+		"CI_PROJECT_ID":                       "42",
+		"CI_MERGE_REQUEST_TARGET_BRANCH_NAME": "",
+		"CI_DEFAULT_BRANCH":                   "main",
+	} {
+		old, ok := os.LookupEnv(k)
+		os.Setenv(k, v)
+		k := k
+		defer func() {
+			if ok {
+				os.Setenv(k, old)
+			} else {
+				os.Unsetenv(k)
+			}
+		}()
+	}
+	s := &Source{Project: "7"}
+	s.FromEnv()
+	if s.APIURL != "https://gitlab.example.com/api/v4" || s.Project != "7" || s.Ref != "main" {
+		t.Errorf("got %+v", *s)
+	}
+	os.Setenv("CI_MERGE_REQUEST_TARGET_BRANCH_NAME", "release")
+	s = &Source{}
+	s.FromEnv()
+	if s.Project != "42" || s.Ref != "release" {
+		t.Errorf("got %+v", *s)
+	}
+}
@@ -0,0 +1,122 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// Coverage emits a read by step of every file with covered lines in a Go
+// coverage profile, as written by "go test -coverprofile", or an lcov
+// tracefile. Files opened by a test but never executed, like most of the
+// ones it reads from the standard library, aren't part of the profile, and
+// neither are files the test doesn't open but whose code it runs, so
+// coverage complements the file opens of a trace.
+//
+// The files of a Go profile are named by import path. Those of the module
+// whose go.mod is in dir are resolved to files in dir and the others, from
+// dependencies, are left out. Relative lcov paths are relative to dir.
+func Coverage(r io.Reader, step stepselection.CmdTree, dir string, emit func(*stepselection.BuildLog) error) error {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len("mode:"))
+	e := newEntries(step, dir, emit)
+	if string(head) == "mode:" {
+		return goCoverage(br, dir, e)
+	}
+	return lcovCoverage(br, e)
+}
+
+func goCoverage(r io.Reader, dir string, e *entries) error {
+	module, err := modulePath(dir)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if line == 1 || text == "" {
+			continue
+		}
+		// name.go:line.column,line.column statements count
+		i := strings.LastIndex(text, ":")
+		var fields []string
+		if i >= 0 {
+			fields = strings.Fields(text[i+1:])
+		}
+		if len(fields) != 3 {
+			return fmt.Errorf("line %d: invalid coverage block %q", line, text)
+		}
+		count, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid count %q", line, fields[2])
+		}
+		name := text[:i]
+		if count == 0 || !strings.HasPrefix(name, module+"/") {
+			continue
+		}
+		if err := e.add("R", filepath.FromSlash(strings.TrimPrefix(name, module+"/"))); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// modulePath returns the path of the module whose go.mod is in dir.
+func modulePath(dir string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return "", fmt.Errorf("the go.mod of the module is needed to find the files of a Go coverage profile: %v", err)
+	}
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		fields := strings.Fields(string(line))
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`), nil
+		}
+	}
+	return "", fmt.Errorf("%v has no module directive", filepath.Join(dir, "go.mod"))
+}
+
+func lcovCoverage(r io.Reader, e *entries) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	var file string
+	covered := false
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(text, "SF:"):
+			file, covered = strings.TrimPrefix(text, "SF:"), false
+		case strings.HasPrefix(text, "DA:"):
+			// DA:line,count[,checksum]
+			fields := strings.Split(strings.TrimPrefix(text, "DA:"), ",")
+			if len(fields) < 2 {
+				return fmt.Errorf("line %d: invalid line record %q", line, text)
+			}
+			count, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid count %q", line, fields[1])
+			}
+			covered = covered || count > 0
+		case text == "end_of_record":
+			if file != "" && covered {
+				if err := e.add("R", file); err != nil {
+					return err
+				}
+			}
+			file, covered = "", false
+		}
+	}
+	return scanner.Err()
+}
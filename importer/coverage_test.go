@@ -0,0 +1,79 @@
+package importer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func TestCoverageGo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skipper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/m\n\ngo 1.21\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	profile := `mode: set
+example.com/m/a/a.go:3.14,5.2 1 1
+example.com/m/a/a.go:7.14,9.2 1 0
+example.com/m/b/b.go:3.14,5.2 2 0
+example.com/m/c/c.go:3.14,5.2 2 3
+example.com/dep/d.go:3.14,5.2 2 3
+`
+	got, err := collect(t, func(emit func(*stepselection.BuildLog) error) error {
+		return Coverage(strings.NewReader(profile), stepselection.CmdTree{"go test ./a"}, dir, emit)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`["go test ./a"] R ` + filepath.Join(dir, "a/a.go"),
+		`["go test ./a"] R ` + filepath.Join(dir, "c/c.go"),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+
+	_, err = collect(t, func(emit func(*stepselection.BuildLog) error) error {
+		return Coverage(strings.NewReader("mode: set\nexample.com/m/a/a.go\n"), stepselection.CmdTree{"go test ./a"}, dir, emit)
+	})
+	if err == nil {
+		t.Error("got no error for an invalid block")
+	}
+}
+
+func TestCoverageLcov(t *testing.T) {
+	tracefile := `TN:
+SF:/src/lib/a.js
+FN:1,a
+DA:1,1
+DA:2,0
+end_of_record
+SF:lib/b.js
+DA:1,0
+end_of_record
+SF:lib/c.js
+DA:4,2,abc
+end_of_record
+`
+	got, err := collect(t, func(emit func(*stepselection.BuildLog) error) error {
+		return Coverage(strings.NewReader(tracefile), stepselection.CmdTree{"npm test"}, "/src", emit)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`["npm test"] R /src/lib/a.js`,
+		`["npm test"] R /src/lib/c.js`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
@@ -0,0 +1,120 @@
+package importer
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// Depfile reads a Makefile-style dependency file, as written by "gcc -MD" or
+// "clang -MD", and emits an entry for each target, as written by step, and
+// for each prerequisite, as read by step. Relative paths are relative to
+// dir, where the compiler ran.
+//
+// Rules without prerequisites, like the ones "-MP" adds for headers, are
+// left out: they only exist to keep make from failing on deleted headers.
+func Depfile(r io.Reader, step stepselection.CmdTree, dir string, emit func(*stepselection.BuildLog) error) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	e := newEntries(step, dir, emit)
+	s := strings.Replace(string(b), "\r\n", "\n", -1)
+	// A backslash at the end of a line continues the rule.
+	s = strings.Replace(s, "\\\n", " ", -1)
+	for _, line := range strings.Split(s, "\n") {
+		targets, prereqs := splitRule(line)
+		if len(targets) == 0 || len(prereqs) == 0 {
+			continue
+		}
+		for _, t := range targets {
+			if err := e.add("W", t); err != nil {
+				return err
+			}
+		}
+		for _, p := range prereqs {
+			if err := e.add("R", p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// splitRule splits a rule into its targets and prerequisites. The targets
+// end at the first colon followed by a space or the end of the line, so
+// that Windows drive letters aren't mistaken for it.
+func splitRule(line string) (targets, prereqs []string) {
+	words := depfileWords(line)
+	for i, w := range words {
+		if w.colon {
+			return words[:i].strings(), words[i+1:].strings()
+		}
+	}
+	return nil, nil
+}
+
+type depfileWord struct {
+	s string
+	// colon is set on the separator between targets and prerequisites.
+	colon bool
+}
+
+type depfileWordList []depfileWord
+
+func (l depfileWordList) strings() []string {
+	var out []string
+	for _, w := range l {
+		if !w.colon {
+			out = append(out, w.s)
+		}
+	}
+	return out
+}
+
+// depfileWords splits a line into words, unescaping "\ ", "\#", "\\" before
+// a space and "$$". A colon ending a word, or alone, is returned as a
+// separate word.
+func depfileWords(line string) depfileWordList {
+	var words depfileWordList
+	var cur strings.Builder
+	flush := func() {
+		w := cur.String()
+		cur.Reset()
+		if w == "" {
+			return
+		}
+		if w == ":" {
+			words = append(words, depfileWord{colon: true})
+			return
+		}
+		if strings.HasSuffix(w, ":") {
+			words = append(words, depfileWord{s: strings.TrimSuffix(w, ":")}, depfileWord{colon: true})
+			return
+		}
+		words = append(words, depfileWord{s: w})
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line) && (line[i+1] == ' ' || line[i+1] == '#'):
+			cur.WriteByte(line[i+1])
+			i++
+		case c == '\\' && i+2 < len(line) && line[i+1] == '\\' && line[i+2] == ' ':
+			// An escaped backslash ending a path.
+			cur.WriteByte('\\')
+			i++
+		case c == '$' && i+1 < len(line) && line[i+1] == '$':
+			cur.WriteByte('$')
+			i++
+		case c == ' ' || c == '\t':
+			flush()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	flush()
+	return words
+}
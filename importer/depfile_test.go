@@ -0,0 +1,36 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func TestDepfile(t *testing.T) {
+	depfile := `obj/a.o obj/a.d: ../src/a.c ../src/my\ header.h \
+  /usr/include/stdio.h ../src/cost$$.h\
+ /opt/sdk:1/sdk.h
+../src/my\ header.h:
+/usr/include/stdio.h:
+`
+	got, err := collect(t, func(emit func(*stepselection.BuildLog) error) error {
+		return Depfile(strings.NewReader(depfile), stepselection.CmdTree{"make"}, "/src/out", emit)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`["make"] W /src/out/obj/a.o`,
+		`["make"] W /src/out/obj/a.d`,
+		`["make"] R /src/src/a.c`,
+		`["make"] R /src/src/my header.h`,
+		`["make"] R /usr/include/stdio.h`,
+		`["make"] R /src/src/cost$.h`,
+		`["make"] R /opt/sdk:1/sdk.h`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
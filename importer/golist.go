@@ -0,0 +1,127 @@
+package importer
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// goPackage is the part of a package in the output of "go list -json" that
+// GoList uses.
+type goPackage struct {
+	ImportPath string
+	Dir        string
+	Standard   bool
+	DepOnly    bool
+	ForTest    string
+	Deps       []string
+	Module     *struct {
+		Main  bool
+		GoMod string
+	}
+
+	GoFiles, CgoFiles, CFiles, CXXFiles, MFiles, HFiles, FFiles, SFiles []string
+	SwigFiles, SwigCXXFiles, SysoFiles, EmbedFiles                      []string
+	TestGoFiles, TestEmbedFiles, XTestGoFiles, XTestEmbedFiles          []string
+}
+
+// files returns the source files of p. Generated files, like the main file
+// of test binaries, are absolute paths into the build cache and are left
+// out.
+func (p *goPackage) files() []string {
+	var files []string
+	for _, list := range [][]string{
+		p.GoFiles, p.CgoFiles, p.CFiles, p.CXXFiles, p.MFiles, p.HFiles, p.FFiles, p.SFiles,
+		p.SwigFiles, p.SwigCXXFiles, p.SysoFiles, p.EmbedFiles,
+		p.TestGoFiles, p.TestEmbedFiles, p.XTestGoFiles, p.XTestEmbedFiles,
+	} {
+		for _, f := range list {
+			if !filepath.IsAbs(f) {
+				files = append(files, filepath.Join(p.Dir, f))
+			}
+		}
+	}
+	if p.Module != nil && p.Module.Main && p.Module.GoMod != "" {
+		files = append(files, p.Module.GoMod, filepath.Join(filepath.Dir(p.Module.GoMod), "go.sum"))
+	}
+	return files
+}
+
+// GoList reads the output of "go list -deps -test -json <patterns>" and
+// emits the source files of every package listed, except for the standard
+// library, as read by step. Every package is built or tested with the ones
+// it depends on, so step is typically the "go test" or "go build" of the same
+// patterns.
+//
+// If packageStep isn't nil, GoList also emits, for each package matched by
+// the patterns, the source files of the package, its tests and everything
+// they depend on, as read by packageStep of the package's import path.
+func GoList(r io.Reader, step stepselection.CmdTree, packageStep func(importPath string) stepselection.CmdTree, emit func(*stepselection.BuildLog) error) error {
+	var pkgs []*goPackage
+	byPath := map[string]*goPackage{}
+	dec := json.NewDecoder(r)
+	for {
+		p := &goPackage{}
+		if err := dec.Decode(p); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		pkgs = append(pkgs, p)
+		byPath[p.ImportPath] = p
+	}
+
+	e := newEntries(step, "", emit)
+	for _, p := range pkgs {
+		if p.Standard {
+			continue
+		}
+		for _, f := range p.files() {
+			if err := e.add("R", f); err != nil {
+				return err
+			}
+		}
+	}
+	if packageStep == nil {
+		return nil
+	}
+	for _, p := range pkgs {
+		if p.Standard || p.DepOnly || p.ForTest != "" || isTestMain(p, byPath) {
+			continue
+		}
+		// The test binary depends on the package recompiled with its
+		// tests, the external test package and all their dependencies.
+		deps := p.Deps
+		if main, ok := byPath[p.ImportPath+".test"]; ok {
+			deps = main.Deps
+		}
+		closure := []*goPackage{p}
+		for _, d := range deps {
+			if dp, ok := byPath[d]; ok && !dp.Standard {
+				closure = append(closure, dp)
+			}
+		}
+		e := newEntries(packageStep(p.ImportPath), "", emit)
+		for _, dp := range closure {
+			for _, f := range dp.files() {
+				if err := e.add("R", f); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isTestMain returns whether p is the generated main package of the test
+// binary of another package.
+func isTestMain(p *goPackage, byPath map[string]*goPackage) bool {
+	if !strings.HasSuffix(p.ImportPath, ".test") {
+		return false
+	}
+	_, ok := byPath[strings.TrimSuffix(p.ImportPath, ".test")]
+	return ok
+}
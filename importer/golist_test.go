@@ -0,0 +1,63 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// goListOutput is trimmed from "go list -deps -test -json ./a" in a module
+// where package a imports b and its external tests import c.
+const goListOutput = `
+{"ImportPath": "fmt", "Dir": "/go/src/fmt", "Standard": true, "GoFiles": ["print.go"]}
+{"ImportPath": "example.com/m/b", "Dir": "/m/b", "DepOnly": true, "GoFiles": ["b.go"], "EmbedFiles": ["data.txt"], "Module": {"Main": true, "GoMod": "/m/go.mod"}, "Deps": ["fmt"]}
+{"ImportPath": "example.com/m/a", "Dir": "/m/a", "GoFiles": ["a.go"], "TestGoFiles": ["a_test.go"], "XTestGoFiles": ["x_test.go"], "Module": {"Main": true, "GoMod": "/m/go.mod"}, "Deps": ["example.com/m/b", "fmt"]}
+{"ImportPath": "example.com/m/c", "Dir": "/m/c", "DepOnly": true, "GoFiles": ["c.go"], "Module": {"Main": true, "GoMod": "/m/go.mod"}}
+{"ImportPath": "example.com/m/a [example.com/m/a.test]", "Dir": "/m/a", "ForTest": "example.com/m/a", "GoFiles": ["a.go", "a_test.go"], "Module": {"Main": true, "GoMod": "/m/go.mod"}, "Deps": ["example.com/m/b", "fmt"]}
+{"ImportPath": "example.com/m/a_test [example.com/m/a.test]", "Dir": "/m/a", "ForTest": "example.com/m/a", "GoFiles": ["x_test.go"], "Module": {"Main": true, "GoMod": "/m/go.mod"}, "Deps": ["example.com/m/a [example.com/m/a.test]", "example.com/m/c"]}
+{"ImportPath": "example.com/m/a.test", "Dir": "/root/.cache/go-build/ab", "GoFiles": ["/root/.cache/go-build/ab/testmain.go"], "Deps": ["example.com/m/a [example.com/m/a.test]", "example.com/m/a_test [example.com/m/a.test]", "example.com/m/b", "example.com/m/c", "fmt"]}
+`
+
+func TestGoList(t *testing.T) {
+	packageStep := func(importPath string) stepselection.CmdTree {
+		return stepselection.CmdTree{"go test " + importPath}
+	}
+	got, err := collect(t, func(emit func(*stepselection.BuildLog) error) error {
+		return GoList(strings.NewReader(goListOutput), stepselection.CmdTree{"go test ./..."}, packageStep, emit)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`["go test ./..."] R /m/b/b.go`,
+		`["go test ./..."] R /m/b/data.txt`,
+		`["go test ./..."] R /m/go.mod`,
+		`["go test ./..."] R /m/go.sum`,
+		`["go test ./..."] R /m/a/a.go`,
+		`["go test ./..."] R /m/a/a_test.go`,
+		`["go test ./..."] R /m/a/x_test.go`,
+		`["go test ./..."] R /m/c/c.go`,
+		`["go test example.com/m/a"] R /m/a/a.go`,
+		`["go test example.com/m/a"] R /m/a/a_test.go`,
+		`["go test example.com/m/a"] R /m/a/x_test.go`,
+		`["go test example.com/m/a"] R /m/go.mod`,
+		`["go test example.com/m/a"] R /m/go.sum`,
+		`["go test example.com/m/a"] R /m/b/b.go`,
+		`["go test example.com/m/a"] R /m/b/data.txt`,
+		`["go test example.com/m/a"] R /m/c/c.go`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func TestGoListInvalid(t *testing.T) {
+	_, err := collect(t, func(emit func(*stepselection.BuildLog) error) error {
+		return GoList(strings.NewReader(`{"ImportPath": `), stepselection.CmdTree{"go build"}, nil, emit)
+	})
+	if err == nil {
+		t.Error("got no error for truncated output")
+	}
+}
@@ -0,0 +1,37 @@
+// Package importer produces build log entries from the dependency files that
+// build tools already write, giving projects a graph source that doesn't
+// require tracing the build's system calls.
+package importer
+
+import (
+	"path/filepath"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// entries emits the entries of a single step, leaving out duplicates, which
+// dependency files have plenty of since most inputs are shared.
+type entries struct {
+	step stepselection.CmdTree
+	dir  string
+	emit func(*stepselection.BuildLog) error
+	seen map[string]bool
+}
+
+func newEntries(step stepselection.CmdTree, dir string, emit func(*stepselection.BuildLog) error) *entries {
+	return &entries{step: step, dir: dir, emit: emit, seen: map[string]bool{}}
+}
+
+// add emits an entry for file, which is made absolute by joining it to the
+// directory the tool ran in.
+func (e *entries) add(mode, file string) error {
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(e.dir, file)
+	}
+	key := mode + file
+	if e.seen[key] {
+		return nil
+	}
+	e.seen[key] = true
+	return e.emit(&stepselection.BuildLog{CmdTree: e.step, Mode: mode, File: file})
+}
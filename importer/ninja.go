@@ -0,0 +1,124 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// NinjaDepsSignature starts every ninja deps log.
+const NinjaDepsSignature = "# ninjadeps\n"
+
+// maxNinjaRecord is the largest record ninja writes.
+const maxNinjaRecord = 1<<19 - 1
+
+// NinjaDeps reads a ninja deps log, the .ninja_deps file in the build
+// directory, and emits an entry for each output, as written by step, and for
+// each of their inputs, as read by step. Relative paths are relative to dir,
+// the build directory.
+//
+// The log only has the inputs ninja learned from depfiles, such as headers,
+// in addition to the inputs of build.ninja. Versions 3 and 4 are supported.
+// Like ninja, a truncated last record is ignored.
+func NinjaDeps(r io.Reader, step stepselection.CmdTree, dir string, emit func(*stepselection.BuildLog) error) error {
+	br := bufio.NewReader(r)
+	sig := make([]byte, len(NinjaDepsSignature))
+	if _, err := io.ReadFull(br, sig); err != nil || string(sig) != NinjaDepsSignature {
+		return errors.New("not a ninja deps log")
+	}
+	var version int32
+	if err := binary.Read(br, binary.LittleEndian, &version); err != nil {
+		return fmt.Errorf("invalid ninja deps log: %v", err)
+	}
+	if version != 3 && version != 4 {
+		return fmt.Errorf("unsupported ninja deps log version %d", version)
+	}
+	// Deps records have the output's ID and the mtime, 32 bits in version
+	// 3 and 64 in version 4, before the input IDs.
+	depsHeader := 8
+	if version == 4 {
+		depsHeader = 12
+	}
+
+	// Records refer to paths by their index in paths. Outputs may have
+	// several deps records, in which case the last one wins.
+	var paths []string
+	deps := map[uint32][]uint32{}
+	var outputs []uint32
+	for {
+		var size uint32
+		if err := binary.Read(br, binary.LittleEndian, &size); err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		isDeps := size&0x80000000 != 0
+		size &^= 0x80000000
+		if size > maxNinjaRecord {
+			return fmt.Errorf("invalid ninja deps log: record of %d bytes", size)
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(br, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if isDeps {
+			if int(size) < depsHeader || size%4 != 0 {
+				return fmt.Errorf("invalid ninja deps log: deps record of %d bytes", size)
+			}
+			out := binary.LittleEndian.Uint32(buf)
+			var inputs []uint32
+			for i := depsHeader; i < len(buf); i += 4 {
+				inputs = append(inputs, binary.LittleEndian.Uint32(buf[i:]))
+			}
+			if _, ok := deps[out]; !ok {
+				outputs = append(outputs, out)
+			}
+			deps[out] = inputs
+			continue
+		}
+		name := buf
+		if version == 4 {
+			// Path records end with the one's complement of their ID.
+			if len(buf) < 4 || binary.LittleEndian.Uint32(buf[len(buf)-4:]) != ^uint32(len(paths)) {
+				return fmt.Errorf("invalid ninja deps log: bad checksum for path %d", len(paths))
+			}
+			name = buf[:len(buf)-4]
+		}
+		// Paths are padded with NULs to a multiple of 4 bytes.
+		paths = append(paths, strings.TrimRight(string(name), "\x00"))
+	}
+
+	path := func(id uint32) (string, error) {
+		if int(id) >= len(paths) {
+			return "", fmt.Errorf("invalid ninja deps log: unknown path %d", id)
+		}
+		return paths[id], nil
+	}
+	e := newEntries(step, dir, emit)
+	for _, out := range outputs {
+		p, err := path(out)
+		if err != nil {
+			return err
+		}
+		if err := e.add("W", p); err != nil {
+			return err
+		}
+		for _, in := range deps[out] {
+			p, err := path(in)
+			if err != nil {
+				return err
+			}
+			if err := e.add("R", p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
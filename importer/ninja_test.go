@@ -0,0 +1,109 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// ninjaLog writes ninja deps logs of version 4.
+type ninjaLog struct {
+	buf   bytes.Buffer
+	paths int
+}
+
+func newNinjaLog() *ninjaLog {
+	l := &ninjaLog{}
+	l.buf.WriteString(NinjaDepsSignature)
+	binary.Write(&l.buf, binary.LittleEndian, int32(4))
+	return l
+}
+
+func (l *ninjaLog) path(p string) {
+	for len(p)%4 != 0 {
+		p += "\x00"
+	}
+	binary.Write(&l.buf, binary.LittleEndian, uint32(len(p)+4))
+	l.buf.WriteString(p)
+	binary.Write(&l.buf, binary.LittleEndian, ^uint32(l.paths))
+	l.paths++
+}
+
+func (l *ninjaLog) deps(out uint32, inputs ...uint32) {
+	binary.Write(&l.buf, binary.LittleEndian, uint32(12+4*len(inputs))|0x80000000)
+	binary.Write(&l.buf, binary.LittleEndian, out)
+	binary.Write(&l.buf, binary.LittleEndian, uint64(1234))
+	for _, in := range inputs {
+		binary.Write(&l.buf, binary.LittleEndian, in)
+	}
+}
+
+func collect(t *testing.T, f func(emit func(*stepselection.BuildLog) error) error) ([]string, error) {
+	var got []string
+	err := f(func(bog *stepselection.BuildLog) error {
+		got = append(got, fmt.Sprintf("%v %v %v", stepselection.CmdTree(bog.CmdTree).Name(), bog.Mode, bog.File))
+		return nil
+	})
+	return got, err
+}
+
+func TestNinjaDeps(t *testing.T) {
+	l := newNinjaLog()
+	l.path("obj/a.o")    // 0
+	l.path("../src/a.c") // 1
+	l.path("/usr/include/stdio.h")
+	l.deps(0, 1)
+	l.path("obj/b.o") // 3
+	l.path("../src/b.c")
+	l.deps(3, 4, 2)
+	// A later record replaces the earlier one.
+	l.deps(0, 1, 2)
+	// A truncated record is ignored.
+	l.buf.Write([]byte{8, 0, 0})
+
+	step := stepselection.CmdTree{"ninja -C out"}
+	got, err := collect(t, func(emit func(*stepselection.BuildLog) error) error {
+		return NinjaDeps(bytes.NewReader(l.buf.Bytes()), step, "/src/out", emit)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`["ninja -C out"] W /src/out/obj/a.o`,
+		`["ninja -C out"] R /src/src/a.c`,
+		`["ninja -C out"] R /usr/include/stdio.h`,
+		`["ninja -C out"] W /src/out/obj/b.o`,
+		`["ninja -C out"] R /src/src/b.c`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func TestNinjaDepsErrors(t *testing.T) {
+	badChecksum := newNinjaLog()
+	binary.Write(&badChecksum.buf, binary.LittleEndian, uint32(8))
+	badChecksum.buf.WriteString("a.o\x00")
+	binary.Write(&badChecksum.buf, binary.LittleEndian, uint32(7))
+	unknownPath := newNinjaLog()
+	unknownPath.path("a.o")
+	unknownPath.deps(0, 5)
+	for name, log := range map[string]string{
+		"signature":    "# not ninja\n\x04\x00\x00\x00",
+		"version":      NinjaDepsSignature + "\x02\x00\x00\x00",
+		"checksum":     badChecksum.buf.String(),
+		"unknown path": unknownPath.buf.String(),
+	} {
+		_, err := collect(t, func(emit func(*stepselection.BuildLog) error) error {
+			return NinjaDeps(strings.NewReader(log), stepselection.CmdTree{"ninja"}, "/", emit)
+		})
+		if err == nil {
+			t.Errorf("%v: NinjaDeps succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Package journal records the decisions skipper makes during builds, so that
+// they can be summarized later: how often steps are skipped and how much time
+// that saves.
+//
+// Each build has its own file in the journal directory, named after the
+// build ID, with one JSON entry per line. Every skipper process of the build
+// appends to it. Builds delimited by "skipper build start" and "skipper build
+// finish" also have a session file, describing the build.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry is a decision about a step.
+type Entry struct {
+	BuildID string
+	Time    time.Time
+	// Step is the CmdTree name of the step.
+	Step string
+	// Run is false if the step was skipped.
+	Run    bool
+	Reason string `json:",omitempty"`
+	// Duration is how long the step took, when it ran.
+	Duration time.Duration `json:",omitempty"`
+	// Unknown is true if the step wasn't in the base dependency graph, so
+	// the decision came from the unknown step policy.
+	Unknown bool `json:",omitempty"`
+}
+
+// Append adds e to the journal of its build in dir.
+func Append(dir string, e *Entry) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, e.BuildID+".jsonl"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	// A single write, so that the lines of concurrent steps don't mix.
+	_, err = f.Write(append(b, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Read returns the entries of every build in dir, oldest first.
+func Read(dir string) ([]Entry, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), ".jsonl") {
+			continue
+		}
+		if entries, err = readFile(filepath.Join(dir, fi.Name()), entries); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries, nil
+}
+
+// ReadBuild returns the entries of the build buildID in dir, oldest first.
+// A build without decisions has none.
+func ReadBuild(dir, buildID string) ([]Entry, error) {
+	entries, err := readFile(filepath.Join(dir, buildID+".jsonl"), nil)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries, nil
+}
+
+// readFile appends the entries of the journal file name to entries.
+func readFile(name string, entries []Entry) ([]Entry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// A step killed mid-write leaves a partial line.
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// StepStats summarizes the decisions about a step.
+type StepStats struct {
+	Step  string
+	Runs  int
+	Skips int
+	// RunTime is the total duration of the runs.
+	RunTime time.Duration
+}
+
+// MeanDuration is the average duration of the step's runs, or zero if it
+// never ran.
+func (s *StepStats) MeanDuration() time.Duration {
+	if s.Runs == 0 {
+		return 0
+	}
+	return s.RunTime / time.Duration(s.Runs)
+}
+
+// Stats summarizes a journal.
+type Stats struct {
+	Builds int
+	Runs   int
+	Skips  int
+	// Saved estimates the time saved by the skips, from the mean duration
+	// of the runs of each skipped step.
+	Saved time.Duration
+	// Unestimated counts the skips of steps that never ran, whose
+	// duration is unknown.
+	Unestimated int
+	// Unknown counts the decisions about steps that weren't in the base
+	// dependency graph, and UnknownSteps the distinct such steps. Many
+	// of them usually mean the graph doesn't match the build.
+	Unknown      int
+	UnknownSteps int
+	// Steps are sorted by decreasing number of runs.
+	Steps []*StepStats
+}
+
+// SkipRate is the fraction of decisions that were skips.
+func (s *Stats) SkipRate() float64 {
+	if s.Runs+s.Skips == 0 {
+		return 0
+	}
+	return float64(s.Skips) / float64(s.Runs+s.Skips)
+}
+
+// Summarize computes the stats of entries.
+func Summarize(entries []Entry) *Stats {
+	st := &Stats{}
+	builds := map[string]bool{}
+	steps := map[string]*StepStats{}
+	unknown := map[string]bool{}
+	for _, e := range entries {
+		builds[e.BuildID] = true
+		if e.Unknown {
+			st.Unknown++
+			unknown[e.Step] = true
+		}
+		s, ok := steps[e.Step]
+		if !ok {
+			s = &StepStats{Step: e.Step}
+			steps[e.Step] = s
+			st.Steps = append(st.Steps, s)
+		}
+		if e.Run {
+			st.Runs++
+			s.Runs++
+			s.RunTime += e.Duration
+		} else {
+			st.Skips++
+			s.Skips++
+		}
+	}
+	st.Builds = len(builds)
+	st.UnknownSteps = len(unknown)
+	for _, s := range st.Steps {
+		if s.Runs == 0 {
+			st.Unestimated += s.Skips
+			continue
+		}
+		st.Saved += time.Duration(s.Skips) * s.MeanDuration()
+	}
+	sort.SliceStable(st.Steps, func(i, j int) bool {
+		if st.Steps[i].Runs != st.Steps[j].Runs {
+			return st.Steps[i].Runs > st.Steps[j].Runs
+		}
+		return st.Steps[i].Step < st.Steps[j].Step
+	})
+	return st
+}
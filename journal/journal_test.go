@@ -0,0 +1,56 @@
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skipper-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, e := range []Entry{
+		{BuildID: "B1", Step: `["build"]`, Run: true, Duration: 60 * time.Second},
+		{BuildID: "B1", Step: `["test"]`, Run: true, Duration: 30 * time.Second},
+		{BuildID: "B2", Step: `["build"]`, Run: true, Duration: 40 * time.Second},
+		{BuildID: "B2", Step: `["test"]`, Run: false},
+		{BuildID: "B3", Step: `["build"]`, Run: false},
+		{BuildID: "B3", Step: `["docs"]`, Run: false, Unknown: true},
+	} {
+		e.Time = t0.Add(time.Duration(i) * time.Minute)
+		if err := Append(dir, &e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := Read(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 6 || entries[0].Step != `["build"]` || entries[5].Step != `["docs"]` {
+		t.Fatalf("got entries %+v", entries)
+	}
+
+	st := Summarize(entries)
+	if st.Builds != 3 || st.Runs != 3 || st.Skips != 3 {
+		t.Errorf("got %d builds, %d runs and %d skips, wanted 3, 3 and 3", st.Builds, st.Runs, st.Skips)
+	}
+	// build skipped once with a mean of 50s, test once with 30s.
+	if want := 80 * time.Second; st.Saved != want {
+		t.Errorf("got %v saved wanted %v", st.Saved, want)
+	}
+	if st.Unestimated != 1 {
+		t.Errorf("got %d unestimated skips wanted 1", st.Unestimated)
+	}
+	if st.Unknown != 1 || st.UnknownSteps != 1 {
+		t.Errorf("got %d decisions about %d unknown steps wanted 1 and 1", st.Unknown, st.UnknownSteps)
+	}
+	if st.Steps[0].Step != `["build"]` || st.Steps[0].Runs != 2 {
+		t.Errorf("got most run step %+v wanted build with 2 runs", st.Steps[0])
+	}
+}
@@ -0,0 +1,117 @@
+package journal
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report summarizes the decisions of a single build, like for a pull request
+// comment.
+type Report struct {
+	BuildID string
+	// Steps counts the distinct steps evaluated, Runs and Skips the
+	// decisions about them.
+	Steps int
+	Runs  int
+	Skips int
+	// Saved estimates the time saved by the skips, from the mean duration
+	// of the runs of each skipped step in every journaled build.
+	Saved time.Duration
+	// Unestimated counts the skips of steps that never ran, whose
+	// duration is unknown.
+	Unestimated int
+	// Reasons are the reasons steps ran, by decreasing number of runs.
+	Reasons []ReasonCount `json:",omitempty"`
+}
+
+// ReasonCount is a reason steps ran and how many of them it made run.
+type ReasonCount struct {
+	Reason string
+	Runs   int
+}
+
+// BuildReport reports on the build buildID from the entries of every build,
+// which estimate the time saved.
+func BuildReport(buildID string, entries []Entry) *Report {
+	r := &Report{BuildID: buildID}
+	mean := map[string]time.Duration{}
+	for _, s := range Summarize(entries).Steps {
+		mean[s.Step] = s.MeanDuration()
+	}
+	steps := map[string]bool{}
+	runs := map[string]int{}
+	for _, e := range entries {
+		if e.BuildID != buildID {
+			continue
+		}
+		steps[e.Step] = true
+		if !e.Run {
+			r.Skips++
+			if d := mean[e.Step]; d > 0 {
+				r.Saved += d
+			} else {
+				r.Unestimated++
+			}
+			continue
+		}
+		r.Runs++
+		reason := stepReason(e.Reason)
+		if reason == "" {
+			reason = "no reason recorded"
+		}
+		runs[reason]++
+	}
+	r.Steps = len(steps)
+	for reason, n := range runs {
+		r.Reasons = append(r.Reasons, ReasonCount{Reason: reason, Runs: n})
+	}
+	sort.Slice(r.Reasons, func(i, j int) bool {
+		if r.Reasons[i].Runs != r.Reasons[j].Runs {
+			return r.Reasons[i].Runs > r.Reasons[j].Runs
+		}
+		return r.Reasons[i].Reason < r.Reasons[j].Reason
+	})
+	return r
+}
+
+// stepReason returns reason without the name of the step it's about, so that
+// steps running for the same cause, like reading the same changed file, share
+// it.
+func stepReason(reason string) string {
+	rest := strings.TrimPrefix(reason, "step ")
+	if rest == reason {
+		return reason
+	}
+	quoted, err := strconv.QuotedPrefix(rest)
+	if err != nil {
+		return reason
+	}
+	return strings.TrimSpace(rest[len(quoted):])
+}
+
+// WriteMarkdown writes r to w as Markdown, listing the top reasons steps ran.
+func (r *Report) WriteMarkdown(w io.Writer, top int) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "### skipper report for build %v\n\n", r.BuildID)
+	fmt.Fprintf(&b, "%d steps evaluated: %d decisions to run, %d to skip.\n\n", r.Steps, r.Runs, r.Skips)
+	fmt.Fprintf(&b, "Estimated time saved: %v", r.Saved.Round(time.Second))
+	if r.Unestimated > 0 {
+		fmt.Fprintf(&b, ", not counting %d skips of steps that never ran", r.Unestimated)
+	}
+	b.WriteString(".\n")
+	if len(r.Reasons) > 0 && top > 0 {
+		b.WriteString("\nTop reasons for running:\n\n")
+		for i, rc := range r.Reasons {
+			if i == top {
+				break
+			}
+			fmt.Fprintf(&b, "- %v (%d)\n", rc.Reason, rc.Runs)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
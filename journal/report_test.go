@@ -0,0 +1,55 @@
+package journal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuildReport(t *testing.T) {
+	entries := []Entry{
+		{BuildID: "B1", Step: `["make","a"]`, Run: true, Duration: 60 * time.Second},
+		{BuildID: "B1", Step: `["make","b"]`, Run: true, Duration: 20 * time.Second},
+		{BuildID: "B2", Step: `["make","a"]`, Run: false},
+		{BuildID: "B2", Step: `["make","b"]`, Run: true, Duration: 40 * time.Second, Reason: `step "[\"make\",\"b\"]" reads file "/src/x.h" which is being updated`},
+		{BuildID: "B2", Step: `["make","c"]`, Run: true, Reason: `step "[\"make\",\"c\"]" reads file "/src/x.h" which is being updated`},
+		{BuildID: "B2", Step: `["make","d"]`, Run: true, Reason: "unknown step"},
+		{BuildID: "B2", Step: `["make","e"]`, Run: false},
+	}
+	got := BuildReport("B2", entries)
+	want := &Report{
+		BuildID:     "B2",
+		Steps:       5,
+		Runs:        3,
+		Skips:       2,
+		Saved:       60 * time.Second,
+		Unestimated: 1,
+		Reasons: []ReasonCount{
+			{Reason: `reads file "/src/x.h" which is being updated`, Runs: 2},
+			{Reason: "unknown step", Runs: 1},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected report (-want +got):\n%v", diff)
+	}
+
+	var b strings.Builder
+	if err := got.WriteMarkdown(&b, 1); err != nil {
+		t.Fatal(err)
+	}
+	wantMarkdown := `### skipper report for build B2
+
+5 steps evaluated: 3 decisions to run, 2 to skip.
+
+Estimated time saved: 1m0s, not counting 1 skips of steps that never ran.
+
+Top reasons for running:
+
+- reads file "/src/x.h" which is being updated (2)
+`
+	if diff := cmp.Diff(wantMarkdown, b.String()); diff != "" {
+		t.Errorf("unexpected Markdown (-want +got):\n%v", diff)
+	}
+}
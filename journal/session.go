@@ -0,0 +1,89 @@
+package journal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Session describes a build, between its start and its finish.
+type Session struct {
+	BuildID string
+	Start   time.Time
+	// Finish is set once the build finished.
+	Finish *time.Time `json:",omitempty"`
+	// Branch and Commit are the checkout the build ran on, if known.
+	Branch string `json:",omitempty"`
+	Commit string `json:",omitempty"`
+	// User started the build.
+	User string `json:",omitempty"`
+	// CIJob identifies the CI job running the build, if any.
+	CIJob string `json:",omitempty"`
+	// Runs and Skips count the decisions of the build, once it finished.
+	Runs  int `json:",omitempty"`
+	Skips int `json:",omitempty"`
+}
+
+func sessionFile(dir, buildID string) string {
+	return filepath.Join(dir, buildID+".session.json")
+}
+
+// WriteSession writes s to dir, replacing the session of the same build.
+func WriteSession(dir string, s *Session) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Readers never see a partial file.
+	tmp := sessionFile(dir, s.BuildID) + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, sessionFile(dir, s.BuildID))
+}
+
+// ReadSession returns the session of the build buildID in dir. The error
+// satisfies os.IsNotExist if the build has none.
+func ReadSession(dir, buildID string) (*Session, error) {
+	b, err := ioutil.ReadFile(sessionFile(dir, buildID))
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// FinishSession marks the build buildID in dir as finished at t and counts
+// its decisions. Builds that weren't started get a session without a start
+// time. It returns the finished session.
+func FinishSession(dir, buildID string, t time.Time) (*Session, error) {
+	s, err := ReadSession(dir, buildID)
+	if os.IsNotExist(err) {
+		s, err = &Session{BuildID: buildID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ReadBuild(dir, buildID)
+	if err != nil {
+		return nil, err
+	}
+	s.Runs, s.Skips = 0, 0
+	for _, e := range entries {
+		if e.Run {
+			s.Runs++
+		} else {
+			s.Skips++
+		}
+	}
+	s.Finish = &t
+	return s, WriteSession(dir, s)
+}
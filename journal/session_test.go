@@ -0,0 +1,52 @@
+package journal
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSession(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := WriteSession(dir, &Session{BuildID: "B1", Start: start, Branch: "main", Commit: "abc123"}); err != nil {
+		t.Fatal(err)
+	}
+	for i, run := range []bool{true, false, false} {
+		e := &Entry{BuildID: "B1", Time: start.Add(time.Duration(i) * time.Minute), Step: `["build"]`, Run: run}
+		if err := Append(dir, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	finish := start.Add(time.Hour)
+	if _, err := FinishSession(dir, "B1", finish); err != nil {
+		t.Fatal(err)
+	}
+	s, err := ReadSession(dir, "B1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Start.Equal(start) || s.Finish == nil || !s.Finish.Equal(finish) || s.Branch != "main" || s.Runs != 1 || s.Skips != 2 {
+		t.Errorf("got session %+v", s)
+	}
+
+	// Session files aren't journal entries.
+	entries, err := Read(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("got %d entries wanted 3", len(entries))
+	}
+
+	if _, err := ReadSession(dir, "B2"); !os.IsNotExist(err) {
+		t.Errorf("ReadSession of a build without a session: got %v wanted a not exist error", err)
+	}
+	s, err = FinishSession(dir, "B2", finish)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Start.IsZero() || s.Runs != 0 || s.Skips != 0 {
+		t.Errorf("got session %+v for a build that wasn't started", s)
+	}
+}
@@ -0,0 +1,311 @@
+// Package outputcache stores the files written by build steps, so that when
+// skipper skips a step its outputs can be restored in a fresh workspace.
+//
+// File contents are stored once, addressed by their SHA-256 hash, under
+// objects/. Each step of each build has a manifest under steps/ listing its
+// output files, relative to the workspace, and their hashes. With a remote
+// cache, the outputs are shared with other machines through it, and the
+// local cache holds the ones restored.
+package outputcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yourbase/skipper/remotecache"
+)
+
+// Cache is an output cache in a local directory.
+type Cache struct {
+	Dir string
+	// Root is the workspace the outputs are written in. Their paths are
+	// stored relative to it, so that they're restored in the workspace of
+	// any machine, and never outside of it.
+	Root string
+	// Remote, if not nil, is a remote cache the outputs are also stored
+	// in, and restored from when Dir doesn't have them.
+	Remote *remotecache.Client
+}
+
+// Output is a file written by a step. Path is relative to the workspace, with
+// slashes.
+type Output struct {
+	Path string
+	Hash string
+	Mode os.FileMode
+}
+
+// Store saves the current contents of files as the outputs of step, which
+// is a CmdTree name, in the build with the given ID. Files that no longer
+// exist, such as temporary files, files that aren't regular files and files
+// outside of the workspace are left out. It returns the stored outputs.
+func (c *Cache) Store(build, step string, files []string) ([]Output, error) {
+	if build == "" {
+		return nil, errors.New("the outputs of a step must be stored with a build ID")
+	}
+	var outputs []Output
+	for _, f := range files {
+		rel, ok := c.relPath(f)
+		if !ok {
+			continue
+		}
+		fi, err := os.Lstat(f)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		hash, err := c.storeObject(f)
+		if err != nil {
+			return nil, fmt.Errorf("could not store %v: %v", f, err)
+		}
+		outputs = append(outputs, Output{Path: rel, Hash: hash, Mode: fi.Mode().Perm()})
+	}
+	b, err := json.MarshalIndent(outputs, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(c.manifestPath(build, step), b, 0644); err != nil {
+		return nil, err
+	}
+	if c.Remote != nil {
+		if err := c.push(build, step, outputs); err != nil {
+			return nil, err
+		}
+	}
+	return outputs, nil
+}
+
+// relPath returns the path of file relative to the workspace, with slashes,
+// or false if it's outside of it.
+func (c *Cache) relPath(file string) (string, bool) {
+	rel, err := filepath.Rel(c.Root, file)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// validOutput returns an error unless o is in the workspace. Manifests come
+// from remote caches other machines write to, which mustn't write files
+// elsewhere, like in the home directory.
+func validOutput(step string, o Output) error {
+	if !filepath.IsLocal(filepath.FromSlash(o.Path)) || strings.Contains(o.Path, `\`) {
+		return fmt.Errorf("invalid output manifest for step %v: path %q isn't in the workspace", step, o.Path)
+	}
+	if len(o.Hash) != sha256.Size*2 {
+		return fmt.Errorf("invalid output manifest for step %v: bad hash %q", step, o.Hash)
+	}
+	return nil
+}
+
+// remoteKey is the key of the action result listing the outputs of step in
+// the build in the remote cache. The outputs of other builds, like the ones
+// of other branches, aren't the ones the graph of the build describes.
+func remoteKey(build, step string) string {
+	return remotecache.Key("skipper step outputs\x00" + build + "\x00" + step)
+}
+
+// push stores the outputs of step in the build in the remote cache.
+func (c *Cache) push(build, step string, outputs []Output) error {
+	ar := &remotecache.ActionResult{}
+	for _, o := range outputs {
+		f, err := os.Open(c.objectPath(o.Hash))
+		if err != nil {
+			return err
+		}
+		d, err := c.Remote.PutFile(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("could not store %v in the remote cache: %v", o.Path, err)
+		}
+		ar.OutputFiles = append(ar.OutputFiles, remotecache.OutputFile{Path: o.Path, Digest: d, Executable: o.Mode&0111 != 0})
+	}
+	if err := c.Remote.PutActionResult(remoteKey(build, step), ar); err != nil {
+		return fmt.Errorf("could not store the outputs of step %v in the remote cache: %v", step, err)
+	}
+	return nil
+}
+
+// pull copies the outputs of step in the build from the remote cache into the
+// local one. It returns false if the remote cache doesn't have them.
+func (c *Cache) pull(build, step string) (bool, error) {
+	ar, err := c.Remote.GetActionResult(remoteKey(build, step))
+	if errors.Is(err, remotecache.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var outputs []Output
+	for _, f := range ar.OutputFiles {
+		mode := os.FileMode(0644)
+		if f.Executable {
+			mode = 0755
+		}
+		o := Output{Path: f.Path, Hash: f.Digest.Hash, Mode: mode}
+		if err := validOutput(step, o); err != nil {
+			return false, err
+		}
+		outputs = append(outputs, o)
+		if err := c.fetchObject(f.Digest.Hash); err != nil {
+			return false, fmt.Errorf("could not restore %v from the remote cache: %v", f.Path, err)
+		}
+	}
+	b, err := json.MarshalIndent(outputs, "", "\t")
+	if err != nil {
+		return false, err
+	}
+	return true, writeFileAtomic(c.manifestPath(build, step), b, 0644)
+}
+
+// Restore writes the outputs of step in the build with the given ID, the
+// base build, back into the workspace, fetching them from the remote cache
+// if the local one doesn't have them. It returns the restored outputs, or an
+// error satisfying os.IsNotExist if the step has no manifest.
+func (c *Cache) Restore(build, step string) ([]Output, error) {
+	outputs, err := c.Outputs(build, step)
+	if os.IsNotExist(err) && c.Remote != nil {
+		if ok, pullErr := c.pull(build, step); pullErr != nil {
+			return nil, pullErr
+		} else if ok {
+			outputs, err = c.Outputs(build, step)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range outputs {
+		if err := validOutput(step, o); err != nil {
+			return nil, err
+		}
+	}
+	for _, o := range outputs {
+		src, err := os.Open(c.objectPath(o.Hash))
+		if err != nil {
+			return nil, fmt.Errorf("could not restore %v: %v", o.Path, err)
+		}
+		err = copyFileAtomic(filepath.Join(c.Root, filepath.FromSlash(o.Path)), src, o.Mode)
+		src.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not restore %v: %v", o.Path, err)
+		}
+	}
+	return outputs, nil
+}
+
+// Outputs returns the outputs stored for step in the build.
+func (c *Cache) Outputs(build, step string) ([]Output, error) {
+	b, err := ioutil.ReadFile(c.manifestPath(build, step))
+	if err != nil {
+		return nil, err
+	}
+	var outputs []Output
+	if err := json.Unmarshal(b, &outputs); err != nil {
+		return nil, fmt.Errorf("invalid output manifest for step %v: %v", step, err)
+	}
+	return outputs, nil
+}
+
+func (c *Cache) manifestPath(build, step string) string {
+	sum := sha256.Sum256([]byte(build + "\x00" + step))
+	return filepath.Join(c.Dir, "steps", hex.EncodeToString(sum[:])+".json")
+}
+
+func (c *Cache) objectPath(hash string) string {
+	return filepath.Join(c.Dir, "objects", hash[:2], hash[2:])
+}
+
+// storeObject copies the file at path into the cache and returns its hash.
+func (c *Cache) storeObject(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	dst := c.objectPath(hash)
+	if _, err := os.Stat(dst); err == nil {
+		return hash, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hash, copyFileAtomic(dst, f, 0444)
+}
+
+// fetchObject copies the object with the hash from the remote cache, unless
+// the local cache has it.
+func (c *Cache) fetchObject(hash string) error {
+	dst := c.objectPath(hash)
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), ".skipper-tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	// GetBlob checks the hash once the blob is written.
+	if _, err := c.Remote.GetBlob(hash, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0444); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// copyFileAtomic writes the contents of r to path through a temporary file,
+// so that readers never see a partial file.
+func copyFileAtomic(path string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".skipper-tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func writeFileAtomic(path string, b []byte, mode os.FileMode) error {
+	return copyFileAtomic(path, bytes.NewReader(b), mode)
+}
@@ -0,0 +1,144 @@
+package outputcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourbase/skipper/remotecache"
+)
+
+func TestStoreRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skipper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := &Cache{Dir: filepath.Join(dir, "cache"), Root: dir}
+	out := filepath.Join(dir, "out", "app")
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(out, []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	outside := filepath.Join(t.TempDir(), "outside")
+	if err := ioutil.WriteFile(outside, []byte("elsewhere"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := c.Store("B1", `["link"]`, []string{out, filepath.Join(dir, "deleted.tmp"), filepath.Dir(out), outside})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].Path != "out/app" {
+		t.Fatalf("got stored outputs %v, wanted only out/app", stored)
+	}
+
+	// A fresh workspace.
+	if err := os.RemoveAll(filepath.Dir(out)); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := c.Restore("B1", `["link"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 1 {
+		t.Errorf("got %d restored outputs, wanted 1", len(restored))
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "binary" {
+		t.Errorf("got %q wanted %q", b, "binary")
+	}
+	if fi, err := os.Stat(out); err != nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("restored file has mode %v, wanted 0755 (%v)", fi.Mode(), err)
+	}
+
+	if _, err := c.Restore("B1", `["unknown"]`); !os.IsNotExist(err) {
+		t.Errorf("got %v for an unknown step, wanted a not exist error", err)
+	}
+	if _, err := c.Restore("B2", `["link"]`); !os.IsNotExist(err) {
+		t.Errorf("got %v for the step in another build, wanted a not exist error", err)
+	}
+}
+
+func TestRemote(t *testing.T) {
+	entries := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			entries[r.URL.Path], _ = ioutil.ReadAll(r.Body)
+			return
+		}
+		b, ok := entries[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out", "app")
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(out, []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	stored := &Cache{Dir: filepath.Join(dir, "stored"), Root: dir, Remote: &remotecache.Client{URL: srv.URL}}
+	if _, err := stored.Store("B1", `["link"]`, []string{out}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh workspace on another machine, with an empty local cache.
+	if err := os.RemoveAll(filepath.Dir(out)); err != nil {
+		t.Fatal(err)
+	}
+	c := &Cache{Dir: filepath.Join(dir, "fresh"), Root: dir, Remote: stored.Remote}
+	if _, err := c.Restore("B2", `["link"]`); !os.IsNotExist(err) {
+		t.Errorf("got %v for the step in another build, wanted a not exist error", err)
+	}
+	if _, err := c.Restore("B1", `["link"]`); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(out); err != nil || string(b) != "binary" {
+		t.Errorf("got %q, %v", b, err)
+	}
+	if fi, err := os.Stat(out); err != nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("restored file has mode %v, wanted 0755 (%v)", fi.Mode(), err)
+	}
+	// It's in the local cache now.
+	if _, err := c.Outputs("B1", `["link"]`); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := c.Restore("B1", `["unknown"]`); !os.IsNotExist(err) {
+		t.Errorf("got %v for an unknown step, wanted a not exist error", err)
+	}
+
+	// Another machine stores outputs outside of the workspace.
+	d, err := c.Remote.PutFile(strings.NewReader("echo pwned\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(t.TempDir(), ".bashrc")
+	for _, path := range []string{target, "../.bashrc", "out/../../.bashrc"} {
+		ar := &remotecache.ActionResult{OutputFiles: []remotecache.OutputFile{{Path: path, Digest: d}}}
+		if err := c.Remote.PutActionResult(remoteKey("B1", `["evil"]`), ar); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Restore("B1", `["evil"]`); err == nil || !strings.Contains(err.Error(), "isn't in the workspace") {
+			t.Errorf("%v: got %v, wanted an error", path, err)
+		}
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("restored a file outside of the workspace: %v", err)
+	}
+}
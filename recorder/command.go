@@ -0,0 +1,28 @@
+package recorder
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// killGracePeriod is how long a command has to exit once it's asked to stop,
+// before it's killed.
+const killGracePeriod = 10 * time.Second
+
+// CommandContext returns a command running name with args with the current
+// process' stdio. When ctx is done, the command is asked to terminate, and
+// killed if it's still running after a grace period. Only the command is
+// signaled, not the processes it started.
+func CommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Cancel = func() error {
+		return terminate(cmd.Process)
+	}
+	cmd.WaitDelay = killGracePeriod
+	return cmd
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package recorder
+
+import (
+	"os"
+	"syscall"
+)
+
+// terminate asks p to exit.
+func terminate(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
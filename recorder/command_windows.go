@@ -0,0 +1,8 @@
+package recorder
+
+import "os"
+
+// terminate kills p: Windows can't ask a process to exit.
+func terminate(p *os.Process) error {
+	return p.Kill()
+}
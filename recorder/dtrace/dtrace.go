@@ -0,0 +1,225 @@
+// Package dtrace records builds on macOS with DTrace. It needs root, and
+// System Integrity Protection must allow DTrace, e.g. with
+// "csrutil enable --without dtrace" from the recovery system.
+package dtrace
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/yourbase/skipper/recorder"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// script is the D program. It traces the descendants of the process whose
+// pid is given as $1 and prints events in the format read by
+// recorder.ParseEvents, translating the macOS open flags and AT_FDCWD to
+// their Linux values: O_CREAT is 0x200 on macOS and 0100 on Linux, O_TRUNC
+// is 0x400 and 01000, and AT_FDCWD is -2 and -100.
+//
+// Exec events carry the command line from pr_psargs, with its arguments
+// separated by spaces, which Record turns into the tab-separated arguments
+// ParseEvents expects.
+const script = `
+#pragma D option quiet
+#pragma D option switchrate=100hz
+#pragma D option bufsize=64m
+
+inline int LINUX_FLAGS_CREAT = 64;
+inline int LINUX_FLAGS_TRUNC = 512;
+
+BEGIN {
+	printf("READY\n");
+}
+
+proc:::create /progenyof($1)/ {
+	printf("F %d %d\n", pid, args[0]->pr_pid);
+}
+
+proc:::exit /progenyof($1)/ {
+	printf("X %d\n", pid);
+}
+
+proc:::exec /progenyof($1)/ {
+	self->exec = stringof(args[0]);
+}
+
+proc:::exec-success /progenyof($1) && self->exec != NULL/ {
+	printf("E %d %d %s\t%s\n", tid, pid, self->exec, curpsinfo->pr_psargs);
+	printf("R %d 0\n", tid);
+	self->exec = 0;
+}
+
+proc:::exec-failure /self->exec != NULL/ {
+	self->exec = 0;
+}
+
+syscall::open:entry,
+syscall::open_nocancel:entry /progenyof($1)/ {
+	this->f = (int)arg1;
+	printf("O %d %d -100 %d %s\n", tid, pid,
+	    (this->f & 3) | (this->f & 0x200 ? LINUX_FLAGS_CREAT : 0) | (this->f & 0x400 ? LINUX_FLAGS_TRUNC : 0),
+	    copyinstr(arg0));
+}
+
+syscall::openat:entry,
+syscall::openat_nocancel:entry /progenyof($1)/ {
+	this->f = (int)arg2;
+	printf("O %d %d %d %d %s\n", tid, pid, (int)arg0 == -2 ? -100 : (int)arg0,
+	    (this->f & 3) | (this->f & 0x200 ? LINUX_FLAGS_CREAT : 0) | (this->f & 0x400 ? LINUX_FLAGS_TRUNC : 0),
+	    copyinstr(arg1));
+}
+
+syscall::chdir:entry /progenyof($1)/ {
+	printf("C %d %d %s\n", tid, pid, copyinstr(arg0));
+}
+
+syscall::unlink:entry,
+syscall::rmdir:entry,
+syscall::mkdir:entry /progenyof($1)/ {
+	printf("W %d %d -100 %s\n", tid, pid, copyinstr(arg0));
+}
+
+syscall::unlinkat:entry,
+syscall::mkdirat:entry /progenyof($1)/ {
+	printf("W %d %d %d %s\n", tid, pid, (int)arg0 == -2 ? -100 : (int)arg0, copyinstr(arg1));
+}
+
+syscall::rename:entry /progenyof($1)/ {
+	printf("W %d %d -100 %s\n", tid, pid, copyinstr(arg0));
+	printf("W %d %d -100 %s\n", tid, pid, copyinstr(arg1));
+}
+
+syscall::renameat:entry /progenyof($1)/ {
+	printf("W %d %d %d %s\n", tid, pid, (int)arg0 == -2 ? -100 : (int)arg0, copyinstr(arg1));
+	printf("W %d %d %d %s\n", tid, pid, (int)arg2 == -2 ? -100 : (int)arg2, copyinstr(arg3));
+}
+
+syscall::open:return,
+syscall::open_nocancel:return,
+syscall::openat:return,
+syscall::openat_nocancel:return,
+syscall::chdir:return,
+syscall::unlink:return,
+syscall::rmdir:return,
+syscall::mkdir:return,
+syscall::unlinkat:return,
+syscall::mkdirat:return,
+syscall::rename:return,
+syscall::renameat:return /progenyof($1)/ {
+	printf("R %d %d\n", tid, (int)arg0);
+}
+`
+
+// Recorder records builds using dtrace. Command lines are taken from the
+// process table, which truncates them to 80 bytes and doesn't preserve
+// spaces inside arguments, so step names of long commands may not match the
+// ones skipper computes. Directory listings and network connections aren't
+// recorded.
+type Recorder struct{}
+
+// Record implements recorder.Recorder.
+func (Recorder) Record(ctx context.Context, args []string, emit func(*stepselection.BuildLog) error) error {
+	if _, err := exec.LookPath("dtrace"); err != nil {
+		return fmt.Errorf("the dtrace recorder needs dtrace: %v", err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile("", "skipper-*.d")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.WriteString(f, script); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	dt := exec.Command("dtrace", "-s", f.Name(), strconv.Itoa(os.Getpid()))
+	dt.Stderr = os.Stderr
+	out, err := dt.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := dt.Start(); err != nil {
+		return fmt.Errorf("could not start dtrace: %v", err)
+	}
+	br := bufio.NewReader(out)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			dt.Wait()
+			return fmt.Errorf("dtrace exited before enabling its probes")
+		}
+		if line == "READY\n" {
+			break
+		}
+	}
+
+	// The build is a child of this process, which is the root of the
+	// traced process tree.
+	t := recorder.NewTracker(cwd, emit)
+	t.Root(os.Getpid(), args)
+	t.Ignore(os.Getpid())
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(splitExecArgs(br, pw))
+	}()
+	parsed := make(chan error, 1)
+	go func() {
+		parsed <- recorder.ParseEvents(pr, t)
+		// Keep draining dtrace if parsing stopped early.
+		io.Copy(ioutil.Discard, pr)
+	}()
+
+	cmd := recorder.CommandContext(ctx, args[0], args[1:]...)
+	runErr := cmd.Run()
+
+	// dtrace drains its buffers when interrupted.
+	dt.Process.Signal(os.Interrupt)
+	parseErr := <-parsed
+	dt.Wait()
+	if parseErr != nil {
+		return parseErr
+	}
+	// Any process we didn't see being forked isn't part of the build.
+	t.DropPending()
+	if err := t.Close(); err != nil {
+		return err
+	}
+	return runErr
+}
+
+// splitExecArgs copies the events in r to w, turning the space-separated
+// command lines of exec events into tab-separated arguments.
+func splitExecArgs(r *bufio.Reader, w io.Writer) error {
+	for {
+		line, err := r.ReadString('\n')
+		if strings.HasPrefix(line, "E ") {
+			if i := strings.IndexByte(line, '\t'); i >= 0 {
+				argv := strings.Fields(line[i+1:])
+				line = line[:i+1] + strings.Join(argv, "\t") + "\n"
+			}
+		}
+		if _, werr := io.WriteString(w, line); werr != nil {
+			return werr
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package dtrace
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestSplitExecArgs(t *testing.T) {
+	in := "F 10 11\nE 11 11 /usr/local/bin/skipper\tskipper --  cc -c a.c\nR 11 0\n"
+	want := "F 10 11\nE 11 11 /usr/local/bin/skipper\tskipper\t--\tcc\t-c\ta.c\nR 11 0\n"
+	got := new(strings.Builder)
+	if err := splitExecArgs(bufio.NewReader(strings.NewReader(in)), got); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want {
+		t.Errorf("got %q wanted %q", got.String(), want)
+	}
+}
@@ -0,0 +1,167 @@
+// Package ebpf records builds with eBPF programs attached to the kernel's
+// syscall tracepoints. It has a much lower overhead than ptrace-based
+// recording, but requires root and a recent Linux kernel.
+package ebpf
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/yourbase/skipper/recorder"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// maxArgs is the number of exec arguments captured per command.
+const maxArgs = 16
+
+// script is the bpftrace program. It follows the process tree rooted at the
+// process whose pid is given as $1 and prints events in the format read by
+// recorder.ParseEvents.
+var script = `
+BEGIN {
+	@tree[$1] = 1;
+	printf("READY\n");
+}
+
+tracepoint:sched:sched_process_fork /@tree[pid]/ {
+	@tree[args->child_pid] = 1;
+	printf("F %d %d\n", pid, args->child_pid);
+}
+
+tracepoint:sched:sched_process_exit /@tree[pid] && pid == tid/ {
+	printf("X %d\n", pid);
+}
+
+tracepoint:syscalls:sys_enter_execve /@tree[pid]/ {
+	printf("E %d %d %s` + strings.Repeat(`\t%s`, maxArgs) + `\n", tid, pid, str(args->filename)` + execArgs() + `);
+}
+
+tracepoint:syscalls:sys_enter_open /@tree[pid]/ {
+	printf("O %d %d -100 %d %s\n", tid, pid, args->flags, str(args->filename));
+}
+
+tracepoint:syscalls:sys_enter_openat /@tree[pid]/ {
+	printf("O %d %d %d %d %s\n", tid, pid, args->dfd, args->flags, str(args->filename));
+}
+
+tracepoint:syscalls:sys_enter_chdir /@tree[pid]/ {
+	printf("C %d %d %s\n", tid, pid, str(args->filename));
+}
+
+tracepoint:syscalls:sys_enter_unlink,
+tracepoint:syscalls:sys_enter_rmdir,
+tracepoint:syscalls:sys_enter_mkdir /@tree[pid]/ {
+	printf("W %d %d -100 %s\n", tid, pid, str(args->pathname));
+}
+
+tracepoint:syscalls:sys_enter_unlinkat,
+tracepoint:syscalls:sys_enter_mkdirat /@tree[pid]/ {
+	printf("W %d %d %d %s\n", tid, pid, args->dfd, str(args->pathname));
+}
+
+tracepoint:syscalls:sys_enter_rename /@tree[pid]/ {
+	printf("W %d %d -100 %s\n", tid, pid, str(args->oldname));
+	printf("W %d %d -100 %s\n", tid, pid, str(args->newname));
+}
+
+tracepoint:syscalls:sys_enter_renameat2 /@tree[pid]/ {
+	printf("W %d %d %d %s\n", tid, pid, args->olddfd, str(args->oldname));
+	printf("W %d %d %d %s\n", tid, pid, args->newdfd, str(args->newname));
+}
+
+tracepoint:syscalls:sys_exit_execve,
+tracepoint:syscalls:sys_exit_open,
+tracepoint:syscalls:sys_exit_openat,
+tracepoint:syscalls:sys_exit_chdir,
+tracepoint:syscalls:sys_exit_unlink,
+tracepoint:syscalls:sys_exit_rmdir,
+tracepoint:syscalls:sys_exit_mkdir,
+tracepoint:syscalls:sys_exit_unlinkat,
+tracepoint:syscalls:sys_exit_mkdirat,
+tracepoint:syscalls:sys_exit_rename,
+tracepoint:syscalls:sys_exit_renameat2 /@tree[pid]/ {
+	printf("R %d %d\n", tid, args->ret);
+}
+
+END {
+	clear(@tree);
+}
+`
+
+func execArgs() string {
+	var b strings.Builder
+	for i := 0; i < maxArgs; i++ {
+		fmt.Fprintf(&b, ", str(*(args->argv + %d))", i)
+	}
+	return b.String()
+}
+
+// Recorder records builds using bpftrace, which must be installed. Paths are
+// truncated to 200 bytes, and only the first 16 arguments of each command
+// are captured, which affects step names of longer commands. Network
+// connections aren't recorded.
+type Recorder struct{}
+
+// Record implements recorder.Recorder.
+func (Recorder) Record(ctx context.Context, args []string, emit func(*stepselection.BuildLog) error) error {
+	if _, err := exec.LookPath("bpftrace"); err != nil {
+		return fmt.Errorf("the ebpf recorder needs bpftrace: %v", err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	bt := exec.Command("bpftrace", "-e", script, strconv.Itoa(os.Getpid()))
+	bt.Env = append(os.Environ(), "BPFTRACE_STRLEN=200")
+	bt.Stderr = os.Stderr
+	out, err := bt.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := bt.Start(); err != nil {
+		return fmt.Errorf("could not start bpftrace: %v", err)
+	}
+	br := bufio.NewReader(out)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			bt.Wait()
+			return fmt.Errorf("bpftrace exited before attaching its probes")
+		}
+		if line == "READY\n" {
+			break
+		}
+	}
+
+	// The build is a child of this process, which is the root of the
+	// traced process tree.
+	t := recorder.NewTracker(cwd, emit)
+	t.Root(os.Getpid(), args)
+	t.Ignore(os.Getpid())
+	parsed := make(chan error, 1)
+	go func() {
+		parsed <- recorder.ParseEvents(br, t)
+	}()
+
+	cmd := recorder.CommandContext(ctx, args[0], args[1:]...)
+	runErr := cmd.Run()
+
+	// bpftrace drains its buffers when interrupted.
+	bt.Process.Signal(os.Interrupt)
+	parseErr := <-parsed
+	bt.Wait()
+	if parseErr != nil {
+		return parseErr
+	}
+	// Any process we didn't see being forked isn't part of the build.
+	t.DropPending()
+	if err := t.Close(); err != nil {
+		return err
+	}
+	return runErr
+}
@@ -0,0 +1,215 @@
+package recorder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Linux open(2) flags, as printed numerically by tracers.
+const (
+	oWronly = 01
+	oRdwr   = 02
+	oCreat  = 0100
+	oTrunc  = 01000
+	atFDCWD = -100
+)
+
+// ParseEvents feeds t with the events in r, one per line, in the format
+// emitted by the tracer scripts of the kernel-based recorders and by the
+// preload interposer:
+//
+//	F <pid> <child pid>                       process forked a child
+//	A <pid> <ppid> <path>\t<arg0>\t<arg1>...  process was found running
+//	E <tid> <pid> <path>\t<arg0>\t<arg1>...   thread started an exec
+//	O <tid> <pid> <dirfd> <flags> <path>      thread started an open
+//	C <tid> <pid> <path>                      thread started a chdir
+//	W <tid> <pid> <dirfd> <path>              thread started to modify path
+//	D <tid> <pid> <dirfd> <path>              thread started to list path
+//	N <tid> <pid> <host:port>                 thread connected to the network
+//	L <tid> <pid> <path>                      thread loaded a shared library
+//	R <tid> <ret>                             thread's syscall returned
+//	X <pid>                                   process exited
+//
+// Events that start a syscall only take effect when the matching R event
+// reports success. A events register processes whose fork wasn't traced,
+// unless they're already known. Paths relative to a directory file descriptor other than
+// AT_FDCWD can't be resolved and are dropped. Other lines are ignored.
+func ParseEvents(r io.Reader, t *Tracker) error {
+	pending := map[int][]func(){}
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line = strings.TrimSuffix(line, "\n"); line != "" {
+			if perr := parseEvent(line, t, pending); perr != nil {
+				return perr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func parseEvent(line string, t *Tracker, pending map[int][]func()) error {
+	fields := strings.SplitN(line, " ", 2)
+	if len(fields) < 2 || len(fields[0]) != 1 {
+		return nil
+	}
+	kind, rest := fields[0], fields[1]
+	// nFields splits rest into n numbers followed by the remaining text.
+	nFields := func(n int) ([]int, string, error) {
+		parts := strings.SplitN(rest, " ", n+1)
+		if len(parts) < n {
+			return nil, "", fmt.Errorf("malformed event %q", line)
+		}
+		nums := make([]int, n)
+		for i := 0; i < n; i++ {
+			v, err := strconv.Atoi(parts[i])
+			if err != nil {
+				return nil, "", fmt.Errorf("malformed event %q: %v", line, err)
+			}
+			nums[i] = v
+		}
+		text := ""
+		if len(parts) > n {
+			text = parts[n]
+		}
+		return nums, text, nil
+	}
+	switch kind {
+	case "F":
+		nums, _, err := nFields(2)
+		if err != nil {
+			return err
+		}
+		t.Fork(nums[0], nums[1])
+	case "X":
+		nums, _, err := nFields(1)
+		if err != nil {
+			return err
+		}
+		t.Exit(nums[0])
+	case "R":
+		nums, _, err := nFields(2)
+		if err != nil {
+			return err
+		}
+		tid, ret := nums[0], nums[1]
+		if ret >= 0 {
+			for _, f := range pending[tid] {
+				f()
+			}
+		}
+		delete(pending, tid)
+	case "A":
+		nums, text, err := nFields(2)
+		if err != nil {
+			return err
+		}
+		path, argv := execArgv(text)
+		t.Adopt(nums[1], nums[0], argv)
+		t.Access(nums[0], "X", path)
+	case "E":
+		nums, text, err := nFields(2)
+		if err != nil {
+			return err
+		}
+		path, argv := execArgv(text)
+		pid := nums[1]
+		pending[nums[0]] = append(pending[nums[0]], func() {
+			t.Exec(pid, argv)
+			t.Access(pid, "X", path)
+		})
+	case "O":
+		nums, path, err := nFields(4)
+		if err != nil {
+			return err
+		}
+		pid, dirfd, flags := nums[1], nums[2], nums[3]
+		if !resolvable(dirfd, path) {
+			return nil
+		}
+		var modes []string
+		switch {
+		case flags&oRdwr != 0:
+			modes = []string{"R", "W"}
+		case flags&(oWronly|oCreat|oTrunc) != 0:
+			modes = []string{"W"}
+		default:
+			modes = []string{"R"}
+		}
+		pending[nums[0]] = append(pending[nums[0]], func() {
+			for _, m := range modes {
+				t.Access(pid, m, path)
+			}
+		})
+	case "C":
+		nums, path, err := nFields(2)
+		if err != nil {
+			return err
+		}
+		pid := nums[1]
+		pending[nums[0]] = append(pending[nums[0]], func() { t.Chdir(pid, path) })
+	case "W":
+		nums, path, err := nFields(3)
+		if err != nil {
+			return err
+		}
+		pid := nums[1]
+		if !resolvable(nums[2], path) {
+			return nil
+		}
+		pending[nums[0]] = append(pending[nums[0]], func() { t.Access(pid, "W", path) })
+	case "N":
+		// Connections count even if they're still in progress, so there's
+		// no R event.
+		nums, addr, err := nFields(2)
+		if err != nil {
+			return err
+		}
+		t.Connect(nums[1], addr)
+	case "L":
+		// Loads are logged once they succeed, so there's no R event.
+		nums, path, err := nFields(2)
+		if err != nil {
+			return err
+		}
+		t.Access(nums[1], "L", path)
+	case "D":
+		nums, path, err := nFields(3)
+		if err != nil {
+			return err
+		}
+		pid := nums[1]
+		if !resolvable(nums[2], path) {
+			return nil
+		}
+		pending[nums[0]] = append(pending[nums[0]], func() { t.ReadDir(pid, path) })
+	}
+	return nil
+}
+
+// execArgv splits the tab-separated path and arguments of an exec event.
+func execArgv(text string) (path string, argv []string) {
+	argv = strings.Split(text, "\t")
+	path = argv[0]
+	argv = argv[1:]
+	// Tracers print a fixed number of arguments; the first empty one marks
+	// the end of argv.
+	for i, a := range argv {
+		if a == "" {
+			return path, argv[:i]
+		}
+	}
+	return path, argv
+}
+
+func resolvable(dirfd int, path string) bool {
+	return dirfd == atFDCWD || strings.HasPrefix(path, "/")
+}
@@ -0,0 +1,156 @@
+package recorder
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func TestParseEvents(t *testing.T) {
+	events := `READY
+F 10 11
+E 11 11 /usr/bin/cc	cc	-c	a.c		HOME=/root
+R 11 0
+O 11 11 -100 524288 /lib/libc.so.6
+R 11 3
+L 11 11 /usr/lib/libplugin.so
+O 11 11 -100 0 /src/a.c
+R 11 3
+O 11 11 -100 0 missing.h
+R 11 -2
+O 11 11 -100 577 a.o
+O 11 11 5 0 relative.h
+R 11 3
+C 12 11 sub dir
+R 12 0
+W 12 11 -100 tmp
+R 12 0
+N 12 11 192.0.2.1:80
+N 12 11 127.0.0.1:5432
+O 10 10 -100 577 /out/graph.gz
+R 10 3
+O 99 99 -100 0 /etc/unrelated
+R 99 3
+`
+	var got []string
+	tracker := NewTracker("/src", func(bog *stepselection.BuildLog) error {
+		got = append(got, logLine(bog))
+		return nil
+	})
+	tracker.now = fakeClock()
+	tracker.Root(10, []string{"make"})
+	tracker.Ignore(10)
+	if err := ParseEvents(strings.NewReader(events), tracker); err != nil {
+		t.Fatal(err)
+	}
+	tracker.DropPending()
+	if err := tracker.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`["make"] X /usr/bin/cc`,
+		`["make"] L /lib/libc.so.6`,
+		`["make"] L /usr/lib/libplugin.so`,
+		`["make"] R /src/a.c`,
+		`["make"] W /src/a.o`,
+		`["make"] W /src/sub dir/tmp`,
+		`["make"] net 192.0.2.1:80`,
+		`["make"] step 7s`,
+	}
+	if diff := cmp.Diff(got, want); len(diff) > 0 {
+		t.Errorf("unexpected entries, diff: %v", diff)
+	}
+}
+
+func TestParseEventsAdopt(t *testing.T) {
+	// A statically linked skipper wrapper, 11, whose exec was seen, spawned
+	// another one, 12, which spawned cc, 13. Only cc logged events.
+	events := `F 10 11
+E 11 11 /usr/local/bin/skipper	skipper	--	cc	-c	a.c
+R 11 0
+A 12 11 /usr/local/bin/skipper	skipper	--id	X	--	cc	-c	a.c
+A 13 12 /usr/bin/cc	cc	-c	a.c
+O 13 13 -100 0 /src/a.c
+R 13 3
+D 13 13 -100 /src/include
+R 13 0
+A 13 12 /usr/bin/cc	cc	-c	a.c
+X 11
+`
+	var got []string
+	tracker := NewTracker("/src", func(bog *stepselection.BuildLog) error {
+		got = append(got, logLine(bog))
+		return nil
+	})
+	tracker.now = fakeClock()
+	tracker.Root(10, []string{"make"})
+	tracker.Ignore(10)
+	if err := ParseEvents(strings.NewReader(events), tracker); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`["cc -c a.c"] X /usr/bin/cc`,
+		`["cc -c a.c"] R /src/a.c`,
+		`["cc -c a.c"] Rdir /src/include`,
+		`["cc -c a.c"] step 3s`,
+		`["make"] step 5s`,
+	}
+	if diff := cmp.Diff(got, want); len(diff) > 0 {
+		t.Errorf("unexpected entries, diff: %v", diff)
+	}
+}
+
+func TestParseEventsRereads(t *testing.T) {
+	// make reads gen.h, regenerates it and reads it twice again.
+	events := `O 10 10 -100 0 /src/gen.h
+R 10 3
+O 10 10 -100 577 /src/gen.h
+R 10 3
+O 10 10 -100 0 /src/gen.h
+R 10 3
+O 10 10 -100 0 /src/gen.h
+R 10 3
+`
+	var got []string
+	var times []time.Time
+	tracker := NewTracker("/src", func(bog *stepselection.BuildLog) error {
+		got = append(got, logLine(bog))
+		if bog.Type == "" {
+			if bog.Time == nil {
+				t.Errorf("%s has no time", logLine(bog))
+			} else {
+				times = append(times, *bog.Time)
+			}
+		}
+		return nil
+	})
+	tracker.now = fakeClock()
+	tracker.Root(10, []string{"make"})
+	if err := ParseEvents(strings.NewReader(events), tracker); err != nil {
+		t.Fatal(err)
+	}
+	tracker.DropPending()
+	if err := tracker.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`["make"] R /src/gen.h`,
+		`["make"] W /src/gen.h`,
+		`["make"] R /src/gen.h`,
+		`["make"] step 4s`,
+	}
+	if diff := cmp.Diff(got, want); len(diff) > 0 {
+		t.Errorf("unexpected entries, diff: %v", diff)
+	}
+	for i := 1; i < len(times); i++ {
+		if !times[i].After(times[i-1]) {
+			t.Errorf("entry %d at %v, not after %v", i, times[i], times[i-1])
+		}
+	}
+}
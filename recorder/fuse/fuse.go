@@ -0,0 +1,32 @@
+// Package fuse records builds by running them in a FUSE passthrough mirror
+// of the workspace, which logs every file opened, listed or modified by the
+// process that made the request. It needs neither ptrace nor root, only
+// /dev/fuse and, for unprivileged users, fusermount, and unlike LD_PRELOAD
+// it sees statically linked tools too.
+//
+// Only accesses through the mirror are recorded: files outside the
+// workspace, workspace files accessed by their absolute path and network
+// connections aren't.
+package fuse
+
+import (
+	"context"
+	"time"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// reapInterval is how often the recorder checks for exited processes, which
+// bounds the precision of step durations.
+const reapInterval = 100 * time.Millisecond
+
+// Recorder records builds run in a FUSE mirror of the current directory.
+// The build runs in the mirror, which is mounted in a temporary directory,
+// and the accesses are reported with the paths of the mirrored files.
+// Steps start when their process is first seen accessing the workspace.
+type Recorder struct{}
+
+// Record implements recorder.Recorder.
+func (Recorder) Record(ctx context.Context, args []string, emit func(*stepselection.BuildLog) error) error {
+	return record(ctx, args, emit)
+}
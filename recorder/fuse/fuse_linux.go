@@ -0,0 +1,157 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/yourbase/skipper/recorder"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func record(ctx context.Context, args []string, emit func(*stepselection.BuildLog) error) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	mnt, err := ioutil.TempDir("", "skipper-fuse")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(mnt)
+	dev, err := mount(mnt)
+	if err != nil {
+		return fmt.Errorf("could not mount %v: %v", mnt, err)
+	}
+	defer syscall.Close(dev)
+
+	// The build is a child of this process, which is the root of the
+	// recorded process tree.
+	t := recorder.NewTracker(cwd, emit)
+	t.Root(os.Getpid(), args)
+	t.Ignore(os.Getpid())
+	p := newProcs(os.Getpid(), t)
+	s := newServer(dev, cwd, p)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.serve()
+	}()
+	done := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(reapInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				p.reap()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	cmd := recorder.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = mnt
+	cmd.Env = append(os.Environ(), "PWD="+mnt)
+	runErr := cmd.Run()
+	close(done)
+
+	if err := unmount(mnt); err != nil {
+		return fmt.Errorf("could not unmount %v: %v", mnt, err)
+	}
+	if err := <-served; err != nil {
+		return err
+	}
+	p.reap()
+	if err := t.Close(); err != nil {
+		return err
+	}
+	return runErr
+}
+
+// mount mounts a FUSE filesystem on dir and returns the descriptor it's
+// served on. Root mounts it directly, other users with fusermount.
+func mount(dir string) (int, error) {
+	if os.Geteuid() != 0 {
+		return fusermount(dir)
+	}
+	dev, err := syscall.Open("/dev/fuse", syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	opts := fmt.Sprintf("fd=%d,rootmode=40000,user_id=0,group_id=0,default_permissions,allow_other", dev)
+	if err := syscall.Mount("skipper", dir, "fuse.skipper", syscall.MS_NOSUID|syscall.MS_NODEV, opts); err != nil {
+		syscall.Close(dev)
+		return -1, err
+	}
+	return dev, nil
+}
+
+// fusermount mounts dir with the setuid fusermount helper, which passes the
+// descriptor back over a socket.
+func fusermount(dir string) (int, error) {
+	bin, err := fusermountPath()
+	if err != nil {
+		return -1, err
+	}
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	theirs := os.NewFile(uintptr(fds[0]), "fusermount")
+	defer syscall.Close(fds[1])
+	cmd := exec.Command(bin, "-o", "default_permissions,fsname=skipper,subtype=skipper", "--", dir)
+	cmd.ExtraFiles = []*os.File{theirs}
+	cmd.Env = append(os.Environ(), "_FUSE_COMMFD=3")
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	theirs.Close()
+	if err != nil {
+		return -1, fmt.Errorf("%v: %v", bin, err)
+	}
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_, oobn, _, _, err := syscall.Recvmsg(fds[1], buf, oob, 0)
+	if err != nil {
+		return -1, err
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) == 0 {
+		return -1, fmt.Errorf("%v didn't pass a descriptor", bin)
+	}
+	dev, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(dev) == 0 {
+		return -1, fmt.Errorf("%v didn't pass a descriptor", bin)
+	}
+	return dev[0], nil
+}
+
+func fusermountPath() (string, error) {
+	for _, name := range []string{"fusermount3", "fusermount"} {
+		if p, err := exec.LookPath(name); err == nil {
+			return p, nil
+		}
+	}
+	return "", errors.New("mounting without root needs fusermount")
+}
+
+// unmount lazily unmounts dir, so that processes left running by the build
+// don't keep it busy.
+func unmount(dir string) error {
+	if os.Geteuid() == 0 {
+		return syscall.Unmount(dir, syscall.MNT_DETACH)
+	}
+	bin, err := fusermountPath()
+	if err != nil {
+		return err
+	}
+	if out, err := exec.Command(bin, "-u", "-z", dir).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %v: %s", bin, err, out)
+	}
+	return nil
+}
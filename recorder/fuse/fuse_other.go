@@ -0,0 +1,14 @@
+//go:build !linux
+
+package fuse
+
+import (
+	"context"
+	"errors"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func record(ctx context.Context, args []string, emit func(*stepselection.BuildLog) error) error {
+	return errors.New("the fuse recorder only works on Linux")
+}
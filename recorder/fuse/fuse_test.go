@@ -0,0 +1,58 @@
+package fuse
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func TestRecord(t *testing.T) {
+	if runtime.GOOS != "linux" || os.Geteuid() != 0 {
+		t.Skip("mounting needs root on Linux")
+	}
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skip("no /dev/fuse")
+	}
+	dir, err := ioutil.TempDir("", "skipper-fuse-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "in.txt"), []byte("hi\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	got := map[string]bool{}
+	err = Recorder{}.Record(context.Background(), []string{"sh", "-c", "cat in.txt > out.txt && mkdir sub && mv out.txt sub/ && ls sub"}, func(bog *stepselection.BuildLog) error {
+		got[bog.Mode+bog.Type+" "+bog.File] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"R " + dir + "/in.txt", "W " + dir + "/out.txt", "W " + dir + "/sub", "W " + dir + "/sub/out.txt", "Rdir " + dir + "/sub"} {
+		if !got[want] {
+			t.Errorf("missing %q in %v", want, got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub", "out.txt")); err != nil {
+		t.Error(err)
+	}
+}
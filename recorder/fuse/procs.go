@@ -0,0 +1,159 @@
+package fuse
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/yourbase/skipper/recorder"
+)
+
+// maxAncestors bounds the walk up the process tree from a request.
+const maxAncestors = 32
+
+// procs attributes accesses to the processes of the build. FUSE only says
+// which thread made a request, so the process and its ancestors, up to the
+// recorder, are read from /proc while the request is pending.
+type procs struct {
+	root int
+
+	mu    sync.Mutex
+	t     *recorder.Tracker
+	known map[int]*proc
+}
+
+type proc struct {
+	// start is the start time of the process, which tells it apart from a
+	// later process with the same pid.
+	start string
+	argv  string
+}
+
+// procInfo is what /proc says about a process.
+type procInfo struct {
+	pid, ppid int
+	start     string
+	argv      []string
+}
+
+func newProcs(root int, t *recorder.Tracker) *procs {
+	return &procs{root: root, t: t, known: map[int]*proc{}}
+}
+
+func (p *procs) access(tid int, mode, path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pid, ok := p.identify(tid); ok {
+		p.t.Access(pid, mode, path)
+	}
+}
+
+func (p *procs) readDir(tid int, path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pid, ok := p.identify(tid); ok {
+		p.t.ReadDir(pid, path)
+	}
+}
+
+// identify returns the process of the thread tid, registering it and its
+// unknown ancestors with the tracker.
+func (p *procs) identify(tid int) (int, bool) {
+	if tid <= 0 {
+		// Requests made by the kernel itself, like writeback.
+		return 0, false
+	}
+	pid := tgid(tid)
+	if pid == p.root {
+		return pid, true
+	}
+	var chain []*procInfo
+	for cur := pid; len(chain) < maxAncestors; {
+		info, ok := readProc(cur)
+		if !ok {
+			break
+		}
+		if k, ok := p.known[cur]; ok && k.start == info.start {
+			if argv := strings.Join(info.argv, "\x00"); k.argv != argv {
+				// The process exec'ed since it was last seen.
+				k.argv = argv
+				p.t.Exec(cur, info.argv)
+			}
+			break
+		}
+		chain = append(chain, info)
+		if info.ppid == p.root || info.ppid <= 1 {
+			break
+		}
+		cur = info.ppid
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		info := chain[i]
+		p.known[info.pid] = &proc{start: info.start, argv: strings.Join(info.argv, "\x00")}
+		p.t.Fork(info.ppid, info.pid)
+		p.t.Exec(info.pid, info.argv)
+	}
+	if _, ok := p.known[pid]; !ok {
+		// The process exited before it could be read.
+		return 0, false
+	}
+	return pid, true
+}
+
+// reap ends the steps of the processes that exited.
+func (p *procs) reap() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for pid, k := range p.known {
+		if info, ok := readProc(pid); !ok || info.start != k.start {
+			p.t.Exit(pid)
+			delete(p.known, pid)
+		}
+	}
+}
+
+func tgid(tid int) int {
+	b, err := ioutil.ReadFile("/proc/" + strconv.Itoa(tid) + "/status")
+	if err != nil {
+		return tid
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "Tgid:") {
+			if pid, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Tgid:"))); err == nil {
+				return pid
+			}
+		}
+	}
+	return tid
+}
+
+func readProc(pid int) (*procInfo, bool) {
+	dir := "/proc/" + strconv.Itoa(pid) + "/"
+	stat, err := ioutil.ReadFile(dir + "stat")
+	if err != nil {
+		return nil, false
+	}
+	// The command name in parentheses may contain anything.
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return nil, false
+	}
+	// Fields after the name, starting with the third: state, ppid, ...,
+	// starttime is the 22nd.
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 20 {
+		return nil, false
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, false
+	}
+	cmdline, err := ioutil.ReadFile(dir + "cmdline")
+	if err != nil {
+		return nil, false
+	}
+	argv := strings.Split(strings.TrimSuffix(string(cmdline), "\x00"), "\x00")
+	return &procInfo{pid: pid, ppid: ppid, start: fields[19], argv: argv}, true
+}
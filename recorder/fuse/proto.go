@@ -0,0 +1,292 @@
+package fuse
+
+// The FUSE kernel protocol, as defined by linux/fuse.h, limited to what a
+// passthrough filesystem needs. Structures are encoded in the host's byte
+// order with encoding/binary; blank fields are padding.
+
+const (
+	protoMajor = 7
+	// protoMinor is the protocol version the server speaks. The kernel
+	// adapts to older servers, which keeps newer requests like STATX away.
+	protoMinor = 31
+
+	rootID = 1
+
+	// maxWrite is the largest write the kernel sends at once.
+	maxWrite = 128 * 1024
+)
+
+// Opcodes.
+const (
+	opLookup      = 1
+	opForget      = 2
+	opGetattr     = 3
+	opSetattr     = 4
+	opReadlink    = 5
+	opSymlink     = 6
+	opMknod       = 8
+	opMkdir       = 9
+	opUnlink      = 10
+	opRmdir       = 11
+	opRename      = 12
+	opLink        = 13
+	opOpen        = 14
+	opRead        = 15
+	opWrite       = 16
+	opStatfs      = 17
+	opRelease     = 18
+	opFsync       = 20
+	opFlush       = 25
+	opInit        = 26
+	opOpendir     = 27
+	opReaddir     = 28
+	opReleasedir  = 29
+	opFsyncdir    = 30
+	opAccess      = 34
+	opCreate      = 35
+	opInterrupt   = 36
+	opDestroy     = 38
+	opBatchForget = 42
+	opRename2     = 45
+)
+
+// Init flags.
+const (
+	initAsyncRead = 1 << 0
+	initBigWrites = 1 << 5
+)
+
+// Setattr valid bits.
+const (
+	fattrMode     = 1 << 0
+	fattrUID      = 1 << 1
+	fattrGID      = 1 << 2
+	fattrSize     = 1 << 3
+	fattrAtime    = 1 << 4
+	fattrMtime    = 1 << 5
+	fattrFh       = 1 << 6
+	fattrAtimeNow = 1 << 7
+	fattrMtimeNow = 1 << 8
+)
+
+type inHeader struct {
+	Len    uint32
+	Opcode uint32
+	Unique uint64
+	NodeID uint64
+	UID    uint32
+	GID    uint32
+	PID    uint32
+	_      uint16
+	_      uint16
+}
+
+type outHeader struct {
+	Len    uint32
+	Error  int32
+	Unique uint64
+}
+
+type initIn struct {
+	Major        uint32
+	Minor        uint32
+	MaxReadahead uint32
+	Flags        uint32
+}
+
+type initOut struct {
+	Major               uint32
+	Minor               uint32
+	MaxReadahead        uint32
+	Flags               uint32
+	MaxBackground       uint16
+	CongestionThreshold uint16
+	MaxWrite            uint32
+	TimeGran            uint32
+	MaxPages            uint16
+	_                   uint16
+	Flags2              uint32
+	_                   [7]uint32
+}
+
+type attr struct {
+	Ino       uint64
+	Size      uint64
+	Blocks    uint64
+	Atime     uint64
+	Mtime     uint64
+	Ctime     uint64
+	Atimensec uint32
+	Mtimensec uint32
+	Ctimensec uint32
+	Mode      uint32
+	Nlink     uint32
+	UID       uint32
+	GID       uint32
+	Rdev      uint32
+	Blksize   uint32
+	_         uint32
+}
+
+type entryOut struct {
+	NodeID         uint64
+	Generation     uint64
+	EntryValid     uint64
+	AttrValid      uint64
+	EntryValidNsec uint32
+	AttrValidNsec  uint32
+	Attr           attr
+}
+
+type attrOut struct {
+	AttrValid     uint64
+	AttrValidNsec uint32
+	_             uint32
+	Attr          attr
+}
+
+type getattrIn struct {
+	Flags uint32
+	_     uint32
+	Fh    uint64
+}
+
+type setattrIn struct {
+	Valid     uint32
+	_         uint32
+	Fh        uint64
+	Size      uint64
+	LockOwner uint64
+	Atime     uint64
+	Mtime     uint64
+	Ctime     uint64
+	Atimensec uint32
+	Mtimensec uint32
+	Ctimensec uint32
+	Mode      uint32
+	_         uint32
+	UID       uint32
+	GID       uint32
+	_         uint32
+}
+
+type openIn struct {
+	Flags uint32
+	_     uint32
+}
+
+type openOut struct {
+	Fh        uint64
+	OpenFlags uint32
+	_         uint32
+}
+
+type createIn struct {
+	Flags uint32
+	Mode  uint32
+	Umask uint32
+	_     uint32
+}
+
+type mkdirIn struct {
+	Mode  uint32
+	Umask uint32
+}
+
+type mknodIn struct {
+	Mode  uint32
+	Rdev  uint32
+	Umask uint32
+	_     uint32
+}
+
+type renameIn struct {
+	NewDir uint64
+}
+
+type rename2In struct {
+	NewDir uint64
+	Flags  uint32
+	_      uint32
+}
+
+type linkIn struct {
+	OldNodeID uint64
+}
+
+type readIn struct {
+	Fh        uint64
+	Offset    uint64
+	Size      uint32
+	ReadFlags uint32
+	LockOwner uint64
+	Flags     uint32
+	_         uint32
+}
+
+type writeIn struct {
+	Fh         uint64
+	Offset     uint64
+	Size       uint32
+	WriteFlags uint32
+	LockOwner  uint64
+	Flags      uint32
+	_          uint32
+}
+
+type writeOut struct {
+	Size uint32
+	_    uint32
+}
+
+type releaseIn struct {
+	Fh           uint64
+	Flags        uint32
+	ReleaseFlags uint32
+	LockOwner    uint64
+}
+
+type fsyncIn struct {
+	Fh         uint64
+	FsyncFlags uint32
+	_          uint32
+}
+
+type accessIn struct {
+	Mask uint32
+	_    uint32
+}
+
+type forgetIn struct {
+	Nlookup uint64
+}
+
+type batchForgetIn struct {
+	Count uint32
+	_     uint32
+}
+
+type forgetOne struct {
+	NodeID  uint64
+	Nlookup uint64
+}
+
+type statfsOut struct {
+	Blocks  uint64
+	Bfree   uint64
+	Bavail  uint64
+	Files   uint64
+	Ffree   uint64
+	Bsize   uint32
+	Namelen uint32
+	Frsize  uint32
+	_       uint32
+	_       [6]uint32
+}
+
+type direntHeader struct {
+	Ino     uint64
+	Off     uint64
+	Namelen uint32
+	Type    uint32
+}
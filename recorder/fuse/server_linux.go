@@ -0,0 +1,720 @@
+package fuse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// validity is how long, in seconds, the kernel caches entries and
+// attributes. Everything that changes the tree goes through the server, so
+// it only matters for changes made outside the mount.
+const validity = 1
+
+// server is a passthrough filesystem that mirrors the directory root and
+// reports every access to a process tree.
+type server struct {
+	dev   int
+	root  string
+	procs *procs
+
+	mu      sync.Mutex
+	nodes   map[uint64]*node
+	ids     map[string]uint64
+	nextID  uint64
+	handles map[uint64]*handle
+	nextFh  uint64
+}
+
+// node is a file known to the kernel, by its path relative to the root.
+type node struct {
+	path    string
+	lookups uint64
+}
+
+// handle is an open file or directory.
+type handle struct {
+	fd      int
+	entries []dirEntry
+}
+
+type dirEntry struct {
+	name string
+	ino  uint64
+	typ  uint32
+}
+
+func newServer(dev int, root string, p *procs) *server {
+	return &server{
+		dev:     dev,
+		root:    root,
+		procs:   p,
+		nodes:   map[uint64]*node{rootID: {path: ""}},
+		ids:     map[string]uint64{"": rootID},
+		nextID:  rootID + 1,
+		handles: map[uint64]*handle{},
+		nextFh:  1,
+	}
+}
+
+// serve handles requests until the filesystem is unmounted.
+func (s *server) serve() error {
+	buf := make([]byte, maxWrite+64*1024)
+	for {
+		n, err := syscall.Read(s.dev, buf)
+		switch err {
+		case nil:
+		case syscall.EINTR, syscall.EAGAIN, syscall.ENOENT:
+			// ENOENT is a request interrupted before it was read.
+			continue
+		case syscall.ENODEV:
+			return nil
+		default:
+			return err
+		}
+		var h inHeader
+		if n < binary.Size(h) {
+			continue
+		}
+		body, _ := decode(buf[:n], &h)
+		body = append([]byte(nil), body...)
+		switch h.Opcode {
+		case opForget, opBatchForget, opInit:
+			// Forgets must be applied in order, and the kernel sends
+			// nothing else until the init reply.
+			s.handle(&h, body)
+		case opDestroy:
+			s.reply(&h, nil)
+			return nil
+		default:
+			go s.handle(&h, body)
+		}
+	}
+}
+
+func (s *server) handle(h *inHeader, body []byte) {
+	switch h.Opcode {
+	case opInit:
+		var in initIn
+		if _, err := decode(body, &in); err != nil || in.Major != protoMajor {
+			s.reply(h, syscall.EPROTO)
+			return
+		}
+		minor := in.Minor
+		if minor > protoMinor {
+			minor = protoMinor
+		}
+		s.reply(h, nil, encode(&initOut{
+			Major:               protoMajor,
+			Minor:               minor,
+			MaxReadahead:        in.MaxReadahead,
+			Flags:               in.Flags & (initAsyncRead | initBigWrites),
+			MaxBackground:       64,
+			CongestionThreshold: 48,
+			MaxWrite:            maxWrite,
+			TimeGran:            1,
+		}))
+	case opForget:
+		var in forgetIn
+		decode(body, &in)
+		s.forget(h.NodeID, in.Nlookup)
+	case opBatchForget:
+		var in batchForgetIn
+		rest, _ := decode(body, &in)
+		for i := uint32(0); i < in.Count; i++ {
+			var f forgetOne
+			var err error
+			if rest, err = decode(rest, &f); err != nil {
+				break
+			}
+			s.forget(f.NodeID, f.Nlookup)
+		}
+	case opLookup:
+		p, ok := s.child(h.NodeID, cstring(body))
+		if !ok {
+			s.reply(h, syscall.ESTALE)
+			return
+		}
+		s.replyEntry(h, p, nil)
+	case opGetattr:
+		var in getattrIn
+		decode(body, &in)
+		var st syscall.Stat_t
+		var err error
+		if fd, ok := s.fd(in.Fh); ok && in.Flags&1 != 0 {
+			err = syscall.Fstat(fd, &st)
+		} else if p, ok := s.path(h.NodeID); ok {
+			err = syscall.Lstat(s.real(p), &st)
+		} else {
+			err = syscall.ESTALE
+		}
+		if err != nil {
+			s.reply(h, err)
+			return
+		}
+		s.reply(h, nil, encode(&attrOut{AttrValid: validity, Attr: toAttr(&st)}))
+	case opSetattr:
+		s.setattr(h, body)
+	case opReadlink:
+		p, ok := s.path(h.NodeID)
+		if !ok {
+			s.reply(h, syscall.ESTALE)
+			return
+		}
+		target, err := os.Readlink(s.real(p))
+		if err != nil {
+			s.reply(h, err)
+			return
+		}
+		s.access(h, "R", p)
+		s.reply(h, nil, []byte(target))
+	case opSymlink:
+		names := bytes.SplitN(body, []byte{0}, 3)
+		if len(names) < 2 {
+			s.reply(h, syscall.EINVAL)
+			return
+		}
+		s.create(h, string(names[0]), func(real string) error {
+			return syscall.Symlink(string(names[1]), real)
+		})
+	case opMknod:
+		var in mknodIn
+		rest, _ := decode(body, &in)
+		s.create(h, cstring(rest), func(real string) error {
+			return syscall.Mknod(real, in.Mode&^in.Umask, int(in.Rdev))
+		})
+	case opMkdir:
+		var in mkdirIn
+		rest, _ := decode(body, &in)
+		s.create(h, cstring(rest), func(real string) error {
+			return syscall.Mkdir(real, in.Mode&^in.Umask)
+		})
+	case opUnlink, opRmdir:
+		p, ok := s.child(h.NodeID, cstring(body))
+		if !ok {
+			s.reply(h, syscall.ESTALE)
+			return
+		}
+		var err error
+		if h.Opcode == opUnlink {
+			err = syscall.Unlink(s.real(p))
+		} else {
+			err = syscall.Rmdir(s.real(p))
+		}
+		if err == nil {
+			s.access(h, "W", p)
+			s.mu.Lock()
+			delete(s.ids, p)
+			s.mu.Unlock()
+		}
+		s.reply(h, err)
+	case opRename, opRename2:
+		var newDir uint64
+		var rest []byte
+		if h.Opcode == opRename {
+			var in renameIn
+			rest, _ = decode(body, &in)
+			newDir = in.NewDir
+		} else {
+			var in rename2In
+			rest, _ = decode(body, &in)
+			if in.Flags != 0 {
+				// The kernel fails renames with flags from now on.
+				s.reply(h, syscall.ENOSYS)
+				return
+			}
+			newDir = in.NewDir
+		}
+		names := bytes.SplitN(rest, []byte{0}, 3)
+		if len(names) < 2 {
+			s.reply(h, syscall.EINVAL)
+			return
+		}
+		from, ok1 := s.child(h.NodeID, string(names[0]))
+		to, ok2 := s.child(newDir, string(names[1]))
+		if !ok1 || !ok2 {
+			s.reply(h, syscall.ESTALE)
+			return
+		}
+		err := syscall.Rename(s.real(from), s.real(to))
+		if err == nil {
+			s.access(h, "W", from)
+			s.access(h, "W", to)
+			s.renamed(from, to)
+		}
+		s.reply(h, err)
+	case opLink:
+		var in linkIn
+		rest, _ := decode(body, &in)
+		old, ok := s.path(in.OldNodeID)
+		if !ok {
+			s.reply(h, syscall.ESTALE)
+			return
+		}
+		s.create(h, cstring(rest), func(real string) error {
+			return syscall.Link(s.real(old), real)
+		})
+	case opOpen, opOpendir:
+		var in openIn
+		decode(body, &in)
+		p, ok := s.path(h.NodeID)
+		if !ok {
+			s.reply(h, syscall.ESTALE)
+			return
+		}
+		flags := int(in.Flags) &^ syscall.O_NOCTTY
+		if h.Opcode == opOpendir {
+			flags = syscall.O_RDONLY | syscall.O_DIRECTORY
+		}
+		fd, err := syscall.Open(s.real(p), flags|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			s.reply(h, err)
+			return
+		}
+		hd := &handle{fd: fd}
+		if h.Opcode == opOpendir {
+			if hd.entries, err = readDir(s.real(p)); err != nil {
+				syscall.Close(fd)
+				s.reply(h, err)
+				return
+			}
+			s.readDir(h, p)
+		} else {
+			s.opened(h, p, flags)
+		}
+		s.reply(h, nil, encode(&openOut{Fh: s.addHandle(hd)}))
+	case opCreate:
+		var in createIn
+		rest, _ := decode(body, &in)
+		p, ok := s.child(h.NodeID, cstring(rest))
+		if !ok {
+			s.reply(h, syscall.ESTALE)
+			return
+		}
+		fd, err := syscall.Open(s.real(p), int(in.Flags)|syscall.O_CREAT|syscall.O_CLOEXEC, in.Mode&^in.Umask)
+		if err != nil {
+			s.reply(h, err)
+			return
+		}
+		s.access(h, "W", p)
+		s.replyEntry(h, p, encode(&openOut{Fh: s.addHandle(&handle{fd: fd})}))
+	case opRead:
+		var in readIn
+		decode(body, &in)
+		fd, ok := s.fd(in.Fh)
+		if !ok {
+			s.reply(h, syscall.EBADF)
+			return
+		}
+		buf := make([]byte, in.Size)
+		n, err := syscall.Pread(fd, buf, int64(in.Offset))
+		if err != nil {
+			s.reply(h, err)
+			return
+		}
+		s.reply(h, nil, buf[:n])
+	case opWrite:
+		var in writeIn
+		data, _ := decode(body, &in)
+		fd, ok := s.fd(in.Fh)
+		if !ok {
+			s.reply(h, syscall.EBADF)
+			return
+		}
+		if uint32(len(data)) > in.Size {
+			data = data[:in.Size]
+		}
+		n, err := syscall.Pwrite(fd, data, int64(in.Offset))
+		if err != nil {
+			s.reply(h, err)
+			return
+		}
+		s.reply(h, nil, encode(&writeOut{Size: uint32(n)}))
+	case opRelease, opReleasedir:
+		var in releaseIn
+		decode(body, &in)
+		s.mu.Lock()
+		hd, ok := s.handles[in.Fh]
+		delete(s.handles, in.Fh)
+		s.mu.Unlock()
+		if ok {
+			syscall.Close(hd.fd)
+		}
+		s.reply(h, nil)
+	case opFsync, opFsyncdir:
+		var in fsyncIn
+		decode(body, &in)
+		fd, ok := s.fd(in.Fh)
+		if !ok {
+			s.reply(h, syscall.EBADF)
+			return
+		}
+		s.reply(h, syscall.Fsync(fd))
+	case opFlush:
+		s.reply(h, nil)
+	case opReaddir:
+		s.readdir(h, body)
+	case opStatfs:
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(s.root, &st); err != nil {
+			s.reply(h, err)
+			return
+		}
+		s.reply(h, nil, encode(&statfsOut{
+			Blocks:  st.Blocks,
+			Bfree:   st.Bfree,
+			Bavail:  st.Bavail,
+			Files:   st.Files,
+			Ffree:   st.Ffree,
+			Bsize:   uint32(st.Bsize),
+			Namelen: uint32(st.Namelen),
+			Frsize:  uint32(st.Frsize),
+		}))
+	case opAccess:
+		var in accessIn
+		decode(body, &in)
+		p, ok := s.path(h.NodeID)
+		if !ok {
+			s.reply(h, syscall.ESTALE)
+			return
+		}
+		s.reply(h, syscall.Access(s.real(p), in.Mask))
+	case opInterrupt:
+		// Requests are short; they're left to complete.
+	default:
+		s.reply(h, syscall.ENOSYS)
+	}
+}
+
+func (s *server) setattr(h *inHeader, body []byte) {
+	var in setattrIn
+	decode(body, &in)
+	p, ok := s.path(h.NodeID)
+	if !ok {
+		s.reply(h, syscall.ESTALE)
+		return
+	}
+	real := s.real(p)
+	fd, hasFd := s.fd(in.Fh)
+	hasFd = hasFd && in.Valid&fattrFh != 0
+	var err error
+	if in.Valid&fattrMode != 0 && err == nil {
+		if hasFd {
+			err = syscall.Fchmod(fd, in.Mode&07777)
+		} else {
+			err = syscall.Chmod(real, in.Mode&07777)
+		}
+	}
+	if in.Valid&(fattrUID|fattrGID) != 0 && err == nil {
+		uid, gid := -1, -1
+		if in.Valid&fattrUID != 0 {
+			uid = int(in.UID)
+		}
+		if in.Valid&fattrGID != 0 {
+			gid = int(in.GID)
+		}
+		err = syscall.Lchown(real, uid, gid)
+	}
+	if in.Valid&fattrSize != 0 && err == nil {
+		if hasFd {
+			err = syscall.Ftruncate(fd, int64(in.Size))
+		} else {
+			err = syscall.Truncate(real, int64(in.Size))
+		}
+		if err == nil {
+			s.access(h, "W", p)
+		}
+	}
+	if in.Valid&(fattrAtime|fattrMtime) != 0 && err == nil {
+		const utimeNow, utimeOmit = 1<<30 - 1, 1<<30 - 2
+		ts := []syscall.Timespec{{Nsec: utimeOmit}, {Nsec: utimeOmit}}
+		if in.Valid&fattrAtime != 0 {
+			ts[0] = syscall.Timespec{Sec: int64(in.Atime), Nsec: int64(in.Atimensec)}
+			if in.Valid&fattrAtimeNow != 0 {
+				ts[0] = syscall.Timespec{Nsec: utimeNow}
+			}
+		}
+		if in.Valid&fattrMtime != 0 {
+			ts[1] = syscall.Timespec{Sec: int64(in.Mtime), Nsec: int64(in.Mtimensec)}
+			if in.Valid&fattrMtimeNow != 0 {
+				ts[1] = syscall.Timespec{Nsec: utimeNow}
+			}
+		}
+		err = syscall.UtimesNano(real, ts)
+	}
+	if err != nil {
+		s.reply(h, err)
+		return
+	}
+	var st syscall.Stat_t
+	if hasFd {
+		err = syscall.Fstat(fd, &st)
+	} else {
+		err = syscall.Lstat(real, &st)
+	}
+	if err != nil {
+		s.reply(h, err)
+		return
+	}
+	s.reply(h, nil, encode(&attrOut{AttrValid: validity, Attr: toAttr(&st)}))
+}
+
+func (s *server) readdir(h *inHeader, body []byte) {
+	var in readIn
+	decode(body, &in)
+	s.mu.Lock()
+	hd, ok := s.handles[in.Fh]
+	s.mu.Unlock()
+	if !ok {
+		s.reply(h, syscall.EBADF)
+		return
+	}
+	var out []byte
+	for i := int(in.Offset); i < len(hd.entries); i++ {
+		e := hd.entries[i]
+		size := (binary.Size(direntHeader{}) + len(e.name) + 7) &^ 7
+		if len(out)+size > int(in.Size) {
+			break
+		}
+		ent := encode(&direntHeader{Ino: e.ino, Off: uint64(i + 1), Namelen: uint32(len(e.name)), Type: e.typ})
+		ent = append(ent, e.name...)
+		out = append(out, ent...)
+		out = append(out, make([]byte, size-len(ent))...)
+	}
+	s.reply(h, nil, out)
+}
+
+// create makes the entry name in the directory of the request with mk and
+// replies with it.
+func (s *server) create(h *inHeader, name string, mk func(real string) error) {
+	p, ok := s.child(h.NodeID, name)
+	if !ok {
+		s.reply(h, syscall.ESTALE)
+		return
+	}
+	if err := mk(s.real(p)); err != nil {
+		s.reply(h, err)
+		return
+	}
+	s.access(h, "W", p)
+	s.replyEntry(h, p, nil)
+}
+
+// replyEntry replies with the entry of p, followed by extra, and counts the
+// kernel's reference to it.
+func (s *server) replyEntry(h *inHeader, p string, extra []byte) {
+	var st syscall.Stat_t
+	if err := syscall.Lstat(s.real(p), &st); err != nil {
+		s.reply(h, err)
+		return
+	}
+	s.mu.Lock()
+	id, ok := s.ids[p]
+	if !ok {
+		id = s.nextID
+		s.nextID++
+		s.ids[p] = id
+		s.nodes[id] = &node{path: p}
+	}
+	s.nodes[id].lookups++
+	s.mu.Unlock()
+	out := encode(&entryOut{NodeID: id, EntryValid: validity, AttrValid: validity, Attr: toAttr(&st)})
+	s.reply(h, nil, append(out, extra...))
+}
+
+func (s *server) forget(id, n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nd, ok := s.nodes[id]
+	if !ok || id == rootID {
+		return
+	}
+	if nd.lookups > n {
+		nd.lookups -= n
+		return
+	}
+	delete(s.nodes, id)
+	if s.ids[nd.path] == id {
+		delete(s.ids, nd.path)
+	}
+}
+
+// renamed moves the nodes under from to to.
+func (s *server) renamed(from, to string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.ids, to)
+	for id, nd := range s.nodes {
+		if nd.path == from || strings.HasPrefix(nd.path, from+"/") {
+			if s.ids[nd.path] == id {
+				delete(s.ids, nd.path)
+			}
+			nd.path = to + strings.TrimPrefix(nd.path, from)
+			s.ids[nd.path] = id
+		}
+	}
+}
+
+func (s *server) path(id uint64) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nd, ok := s.nodes[id]
+	if !ok {
+		return "", false
+	}
+	return nd.path, true
+}
+
+func (s *server) child(dir uint64, name string) (string, bool) {
+	p, ok := s.path(dir)
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return "", false
+	}
+	if p == "" {
+		return name, true
+	}
+	return p + "/" + name, true
+}
+
+func (s *server) real(p string) string {
+	return filepath.Join(s.root, p)
+}
+
+func (s *server) addHandle(hd *handle) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fh := s.nextFh
+	s.nextFh++
+	s.handles[fh] = hd
+	return fh
+}
+
+func (s *server) fd(fh uint64) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hd, ok := s.handles[fh]
+	if !ok {
+		return -1, false
+	}
+	return hd.fd, true
+}
+
+// opened records an open of p with the given flags.
+func (s *server) opened(h *inHeader, p string, flags int) {
+	switch flags & syscall.O_ACCMODE {
+	case syscall.O_RDWR:
+		s.access(h, "R", p)
+		s.access(h, "W", p)
+	case syscall.O_WRONLY:
+		s.access(h, "W", p)
+	default:
+		s.access(h, "R", p)
+	}
+}
+
+func (s *server) access(h *inHeader, mode, p string) {
+	s.procs.access(int(h.PID), mode, s.real(p))
+}
+
+func (s *server) readDir(h *inHeader, p string) {
+	s.procs.readDir(int(h.PID), s.real(p))
+}
+
+func (s *server) reply(h *inHeader, err error, data ...[]byte) {
+	out := outHeader{Unique: h.Unique}
+	if err != nil {
+		out.Error = -int32(errno(err))
+		data = nil
+	}
+	size := binary.Size(out)
+	for _, d := range data {
+		size += len(d)
+	}
+	out.Len = uint32(size)
+	msg := encode(&out)
+	for _, d := range data {
+		msg = append(msg, d...)
+	}
+	// Fails only if the request was interrupted, which is fine.
+	syscall.Write(s.dev, msg)
+}
+
+func errno(err error) syscall.Errno {
+	var e syscall.Errno
+	if errors.As(err, &e) {
+		return e
+	}
+	return syscall.EIO
+}
+
+func readDir(dir string) ([]dirEntry, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(f.Fd()), &st); err != nil {
+		return nil, err
+	}
+	entries := []dirEntry{{".", st.Ino, syscall.DT_DIR}, {"..", st.Ino, syscall.DT_DIR}}
+	for _, fi := range infos {
+		e := dirEntry{name: fi.Name(), typ: syscall.DT_UNKNOWN}
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			e.ino = st.Ino
+			e.typ = uint32(st.Mode&syscall.S_IFMT) >> 12
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func toAttr(st *syscall.Stat_t) attr {
+	return attr{
+		Ino:       uint64(st.Ino),
+		Size:      uint64(st.Size),
+		Blocks:    uint64(st.Blocks),
+		Atime:     uint64(st.Atim.Sec),
+		Mtime:     uint64(st.Mtim.Sec),
+		Ctime:     uint64(st.Ctim.Sec),
+		Atimensec: uint32(st.Atim.Nsec),
+		Mtimensec: uint32(st.Mtim.Nsec),
+		Ctimensec: uint32(st.Ctim.Nsec),
+		Mode:      uint32(st.Mode),
+		Nlink:     uint32(st.Nlink),
+		UID:       st.Uid,
+		GID:       st.Gid,
+		Rdev:      uint32(st.Rdev),
+		Blksize:   uint32(st.Blksize),
+	}
+}
+
+func decode(b []byte, v interface{}) ([]byte, error) {
+	n := binary.Size(v)
+	if len(b) < n {
+		return nil, syscall.EINVAL
+	}
+	return b[n:], binary.Read(bytes.NewReader(b[:n]), binary.NativeEndian, v)
+}
+
+func encode(v interface{}) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.NativeEndian, v)
+	return b.Bytes()
+}
+
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
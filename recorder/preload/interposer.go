@@ -0,0 +1,663 @@
+package preload
+
+// source is the C interposer, compiled into a shared library that's loaded
+// into every dynamically linked process of the build with LD_PRELOAD. It
+// wraps the libc functions that open, modify, list and execute files, load
+// shared libraries or connect to the network, and appends their results to the file named by LogEnv in the format read by
+// recorder.ParseEvents, one write per event.
+//
+// Paths are made absolute before they're logged, so the recorder doesn't
+// need to follow working directories. Forks are logged by the child, spawns
+// and exits by the parent, which is the only one to see processes that
+// aren't dynamically linked, like skipper itself. Every process also logs
+// its ancestors up to the recorder when it starts, so that processes
+// spawned by a statically linked parent end up in the right place of the
+// process tree.
+const source = `
+#define _GNU_SOURCE
+#include <arpa/inet.h>
+#include <dirent.h>
+#include <dlfcn.h>
+#include <errno.h>
+#include <fcntl.h>
+#include <limits.h>
+#include <link.h>
+#include <netinet/in.h>
+#include <spawn.h>
+#include <stdarg.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/socket.h>
+#include <sys/stat.h>
+#include <sys/syscall.h>
+#include <sys/types.h>
+#include <sys/wait.h>
+#include <unistd.h>
+
+#define LOG_ENV "SKIPPER_PRELOAD_LOG"
+#define ROOT_ENV "SKIPPER_PRELOAD_ROOT"
+#define PID_ENV "SKIPPER_PRELOAD_PID"
+#define MAX_ANCESTORS 32
+#define MAX_ARGS 256
+
+extern char **environ;
+
+static int log_fd = -1;
+static dev_t log_dev;
+static ino_t log_ino;
+
+#define REAL(name) \
+	static __typeof__(name) *real_##name; \
+	if (!real_##name) real_##name = (__typeof__(name) *)dlsym(RTLD_NEXT, #name)
+
+/* log_file returns the descriptor of the event log, reopening it if the
+   program closed it, or -1 if the build isn't being recorded. */
+static int log_file(void) {
+	struct stat st;
+	if (log_fd >= 0 && fstat(log_fd, &st) == 0 && st.st_dev == log_dev && st.st_ino == log_ino)
+		return log_fd;
+	const char *path = getenv(LOG_ENV);
+	if (!path || !*path)
+		return -1;
+	int fd = syscall(SYS_openat, AT_FDCWD, path, O_WRONLY | O_APPEND | O_CLOEXEC);
+	if (fd < 0)
+		return -1;
+	/* Out of the way of programs that expect the lowest free descriptor. */
+	int high = fcntl(fd, F_DUPFD_CLOEXEC, 512);
+	if (high >= 0) {
+		close(fd);
+		fd = high;
+	}
+	if (fstat(fd, &st) != 0) {
+		close(fd);
+		return -1;
+	}
+	log_dev = st.st_dev;
+	log_ino = st.st_ino;
+	log_fd = fd;
+	return fd;
+}
+
+struct event {
+	char buf[3 * PATH_MAX];
+	size_t len;
+};
+
+static void put(struct event *e, const char *s) {
+	for (; *s && e->len < sizeof(e->buf) - 2; s++)
+		e->buf[e->len++] = (*s == '\n' || *s == '\t') ? ' ' : *s;
+}
+
+static void putf(struct event *e, const char *format, ...) {
+	va_list ap;
+	va_start(ap, format);
+	int n = vsnprintf(e->buf + e->len, sizeof(e->buf) - e->len - 1, format, ap);
+	va_end(ap);
+	if (n > 0)
+		e->len += (size_t)n < sizeof(e->buf) - e->len - 1 ? (size_t)n : sizeof(e->buf) - e->len - 2;
+}
+
+static void put_argv(struct event *e, char *const argv[]) {
+	for (int i = 0; argv && argv[i] && i < MAX_ARGS; i++) {
+		putf(e, "\t");
+		put(e, argv[i]);
+	}
+}
+
+static void end_line(struct event *e) {
+	e->buf[e->len++] = '\n';
+}
+
+static void flush(struct event *e) {
+	int fd = log_file();
+	if (fd >= 0 && e->len > 0) {
+		ssize_t n = write(fd, e->buf, e->len);
+		(void)n;
+	}
+	e->len = 0;
+}
+
+static int tid(void) {
+	return (int)syscall(SYS_gettid);
+}
+
+/* absolute resolves path against dirfd into out. */
+static void absolute(int dirfd, const char *path, char *out, size_t size) {
+	char base[PATH_MAX];
+	if (!path) {
+		out[0] = 0;
+		return;
+	}
+	if (path[0] == '/') {
+		snprintf(out, size, "%s", path);
+		return;
+	}
+	if (dirfd == AT_FDCWD) {
+		if (!getcwd(base, sizeof(base)))
+			base[0] = 0;
+	} else {
+		char link[64];
+		snprintf(link, sizeof(link), "/proc/self/fd/%d", dirfd);
+		ssize_t n = readlink(link, base, sizeof(base) - 1);
+		base[n < 0 ? 0 : n] = 0;
+	}
+	if (!base[0]) {
+		snprintf(out, size, "%s", path);
+		return;
+	}
+	snprintf(out, size, "%s/%s", strcmp(base, "/") == 0 ? "" : base, path);
+}
+
+static void log_path(char kind, int dirfd, const char *path, int flags, long ret) {
+	int saved = errno;
+	struct event e = {.len = 0};
+	char abs[PATH_MAX];
+	absolute(dirfd, path, abs, sizeof(abs));
+	if (kind == 'O')
+		putf(&e, "O %d %d -100 %d ", tid(), getpid(), flags);
+	else
+		putf(&e, "%c %d %d -100 ", kind, tid(), getpid());
+	put(&e, abs);
+	end_line(&e);
+	putf(&e, "R %d %ld", tid(), ret);
+	end_line(&e);
+	flush(&e);
+	errno = saved;
+}
+
+/* resolve_exec finds the file executed for file by the exec functions that
+   search PATH. */
+static void resolve_exec(const char *file, char *out, size_t size) {
+	if (strchr(file, '/')) {
+		absolute(AT_FDCWD, file, out, size);
+		return;
+	}
+	const char *path = getenv("PATH");
+	if (!path)
+		path = "/bin:/usr/bin";
+	while (*path) {
+		const char *end = strchr(path, ':');
+		size_t n = end ? (size_t)(end - path) : strlen(path);
+		char candidate[PATH_MAX];
+		snprintf(candidate, sizeof(candidate), "%.*s/%s", (int)n, n ? path : ".", file);
+		if (access(candidate, X_OK) == 0) {
+			absolute(AT_FDCWD, candidate, out, size);
+			return;
+		}
+		path += n;
+		if (*path == ':')
+			path++;
+	}
+	snprintf(out, size, "%s", file);
+}
+
+static void log_exec(int pid, int thread, const char *file, int search, char *const argv[]) {
+	int saved = errno;
+	struct event e = {.len = 0};
+	char abs[PATH_MAX];
+	if (search)
+		resolve_exec(file, abs, sizeof(abs));
+	else
+		absolute(AT_FDCWD, file, abs, sizeof(abs));
+	putf(&e, "E %d %d ", thread, pid);
+	put(&e, abs);
+	put_argv(&e, argv);
+	end_line(&e);
+	putf(&e, "R %d 0", thread);
+	end_line(&e);
+	flush(&e);
+	errno = saved;
+}
+
+static void log_library(const char *path) {
+	int saved = errno;
+	struct event e = {.len = 0};
+	putf(&e, "L %d %d ", tid(), getpid());
+	put(&e, path);
+	end_line(&e);
+	flush(&e);
+	errno = saved;
+}
+
+/* log_loaded logs a library the dynamic linker loaded with the program, but
+   not the vDSO, which has no name, nor the interposer named by self. */
+static int log_loaded(struct dl_phdr_info *info, size_t size, void *self) {
+	(void)size;
+	if (info->dlpi_name && info->dlpi_name[0] == '/' && (!self || strcmp(info->dlpi_name, self) != 0))
+		log_library(info->dlpi_name);
+	return 0;
+}
+
+static void log_exit(pid_t pid, int status) {
+	if (pid <= 0 || !(WIFEXITED(status) || WIFSIGNALED(status)))
+		return;
+	int saved = errno;
+	struct event e = {.len = 0};
+	putf(&e, "X %d", pid);
+	end_line(&e);
+	flush(&e);
+	errno = saved;
+}
+
+/* read_proc reads /proc/<pid>/<name> with raw syscalls, which aren't
+   logged. */
+static ssize_t read_proc(int pid, const char *name, char *buf, size_t size) {
+	char path[64];
+	snprintf(path, sizeof(path), "/proc/%d/%s", pid, name);
+	int fd = syscall(SYS_openat, AT_FDCWD, path, O_RDONLY | O_CLOEXEC);
+	if (fd < 0)
+		return -1;
+	ssize_t n = read(fd, buf, size - 1);
+	close(fd);
+	if (n >= 0)
+		buf[n] = 0;
+	return n;
+}
+
+static int parent_of(int pid) {
+	char stat[1024];
+	if (read_proc(pid, "stat", stat, sizeof(stat)) < 0)
+		return 0;
+	/* The command name in parentheses may contain anything. */
+	char *p = strrchr(stat, ')');
+	int ppid = 0;
+	if (!p || sscanf(p + 1, " %*c %d", &ppid) != 1)
+		return 0;
+	return ppid;
+}
+
+static void log_ancestor(struct event *e, int pid, int ppid) {
+	char exe[PATH_MAX], cmdline[PATH_MAX];
+	char link[64];
+	snprintf(link, sizeof(link), "/proc/%d/exe", pid);
+	ssize_t n = readlink(link, exe, sizeof(exe) - 1);
+	exe[n < 0 ? 0 : n] = 0;
+	ssize_t len = read_proc(pid, "cmdline", cmdline, sizeof(cmdline));
+	putf(e, "A %d %d ", pid, ppid);
+	put(e, exe);
+	for (ssize_t i = 0; i < len; i += strlen(cmdline + i) + 1) {
+		putf(e, "\t");
+		put(e, cmdline + i);
+	}
+	end_line(e);
+	flush(e);
+}
+
+__attribute__((constructor)) static void skipper_preload_init(void) {
+	if (!getenv(LOG_ENV))
+		return;
+	Dl_info self;
+	dl_iterate_phdr(log_loaded, dladdr((void *)skipper_preload_init, &self) ? (void *)self.dli_fname : NULL);
+	int pid = getpid();
+	const char *prev = getenv(PID_ENV);
+	if (prev && atoi(prev) == pid)
+		/* An exec of this process, which its previous image logged. */
+		return;
+	char buf[32];
+	snprintf(buf, sizeof(buf), "%d", pid);
+	setenv(PID_ENV, buf, 1);
+
+	const char *r = getenv(ROOT_ENV);
+	int root = r ? atoi(r) : 0;
+	int chain[MAX_ANCESTORS], parents[MAX_ANCESTORS];
+	int n = 0;
+	for (int p = pid; p > 1 && n < MAX_ANCESTORS; ) {
+		int ppid = parent_of(p);
+		chain[n] = p;
+		parents[n++] = ppid;
+		if (ppid == root || ppid <= 1)
+			break;
+		p = ppid;
+	}
+	int saved = errno;
+	struct event e = {.len = 0};
+	for (int i = n - 1; i >= 0; i--)
+		log_ancestor(&e, chain[i], parents[i]);
+	errno = saved;
+}
+
+static int open_flags(const char *mode) {
+	if (strchr(mode, '+'))
+		return O_RDWR;
+	if (mode[0] == 'w' || mode[0] == 'a')
+		return O_WRONLY | O_CREAT;
+	return O_RDONLY;
+}
+
+#define OPEN_MODE(flags) \
+	mode_t mode = 0; \
+	if ((flags) & (O_CREAT | O_TMPFILE)) { \
+		va_list ap; \
+		va_start(ap, flags); \
+		mode = va_arg(ap, int); \
+		va_end(ap); \
+	}
+
+int open(const char *path, int flags, ...) {
+	OPEN_MODE(flags);
+	REAL(open);
+	int ret = real_open(path, flags, mode);
+	log_path('O', AT_FDCWD, path, flags, ret);
+	return ret;
+}
+
+int open64(const char *path, int flags, ...) {
+	OPEN_MODE(flags);
+	REAL(open64);
+	int ret = real_open64(path, flags, mode);
+	log_path('O', AT_FDCWD, path, flags, ret);
+	return ret;
+}
+
+int openat(int dirfd, const char *path, int flags, ...) {
+	OPEN_MODE(flags);
+	REAL(openat);
+	int ret = real_openat(dirfd, path, flags, mode);
+	log_path('O', dirfd, path, flags, ret);
+	return ret;
+}
+
+int openat64(int dirfd, const char *path, int flags, ...) {
+	OPEN_MODE(flags);
+	REAL(openat64);
+	int ret = real_openat64(dirfd, path, flags, mode);
+	log_path('O', dirfd, path, flags, ret);
+	return ret;
+}
+
+/* The variants called by programs built with _FORTIFY_SOURCE. */
+
+int __open_2(const char *path, int flags) {
+	REAL(__open_2);
+	int ret = real___open_2(path, flags);
+	log_path('O', AT_FDCWD, path, flags, ret);
+	return ret;
+}
+
+int __open64_2(const char *path, int flags) {
+	REAL(__open64_2);
+	int ret = real___open64_2(path, flags);
+	log_path('O', AT_FDCWD, path, flags, ret);
+	return ret;
+}
+
+int __openat_2(int dirfd, const char *path, int flags) {
+	REAL(__openat_2);
+	int ret = real___openat_2(dirfd, path, flags);
+	log_path('O', dirfd, path, flags, ret);
+	return ret;
+}
+
+int __openat64_2(int dirfd, const char *path, int flags) {
+	REAL(__openat64_2);
+	int ret = real___openat64_2(dirfd, path, flags);
+	log_path('O', dirfd, path, flags, ret);
+	return ret;
+}
+
+int creat(const char *path, mode_t mode) {
+	REAL(creat);
+	int ret = real_creat(path, mode);
+	log_path('O', AT_FDCWD, path, O_WRONLY | O_CREAT | O_TRUNC, ret);
+	return ret;
+}
+
+int creat64(const char *path, mode_t mode) {
+	REAL(creat64);
+	int ret = real_creat64(path, mode);
+	log_path('O', AT_FDCWD, path, O_WRONLY | O_CREAT | O_TRUNC, ret);
+	return ret;
+}
+
+FILE *fopen(const char *path, const char *mode) {
+	REAL(fopen);
+	FILE *f = real_fopen(path, mode);
+	log_path('O', AT_FDCWD, path, open_flags(mode), f ? 0 : -1);
+	return f;
+}
+
+FILE *fopen64(const char *path, const char *mode) {
+	REAL(fopen64);
+	FILE *f = real_fopen64(path, mode);
+	log_path('O', AT_FDCWD, path, open_flags(mode), f ? 0 : -1);
+	return f;
+}
+
+DIR *opendir(const char *path) {
+	REAL(opendir);
+	DIR *d = real_opendir(path);
+	log_path('D', AT_FDCWD, path, 0, d ? 0 : -1);
+	return d;
+}
+
+int unlink(const char *path) {
+	REAL(unlink);
+	int ret = real_unlink(path);
+	log_path('W', AT_FDCWD, path, 0, ret);
+	return ret;
+}
+
+int unlinkat(int dirfd, const char *path, int flags) {
+	REAL(unlinkat);
+	int ret = real_unlinkat(dirfd, path, flags);
+	log_path('W', dirfd, path, 0, ret);
+	return ret;
+}
+
+int rmdir(const char *path) {
+	REAL(rmdir);
+	int ret = real_rmdir(path);
+	log_path('W', AT_FDCWD, path, 0, ret);
+	return ret;
+}
+
+int mkdir(const char *path, mode_t mode) {
+	REAL(mkdir);
+	int ret = real_mkdir(path, mode);
+	log_path('W', AT_FDCWD, path, 0, ret);
+	return ret;
+}
+
+int mkdirat(int dirfd, const char *path, mode_t mode) {
+	REAL(mkdirat);
+	int ret = real_mkdirat(dirfd, path, mode);
+	log_path('W', dirfd, path, 0, ret);
+	return ret;
+}
+
+int rename(const char *from, const char *to) {
+	REAL(rename);
+	int ret = real_rename(from, to);
+	log_path('W', AT_FDCWD, from, 0, ret);
+	log_path('W', AT_FDCWD, to, 0, ret);
+	return ret;
+}
+
+int renameat(int fromfd, const char *from, int tofd, const char *to) {
+	REAL(renameat);
+	int ret = real_renameat(fromfd, from, tofd, to);
+	log_path('W', fromfd, from, 0, ret);
+	log_path('W', tofd, to, 0, ret);
+	return ret;
+}
+
+int renameat2(int fromfd, const char *from, int tofd, const char *to, unsigned int flags) {
+	REAL(renameat2);
+	int ret = real_renameat2(fromfd, from, tofd, to, flags);
+	log_path('W', fromfd, from, 0, ret);
+	log_path('W', tofd, to, 0, ret);
+	return ret;
+}
+
+void *dlopen(const char *file, int flags) {
+	REAL(dlopen);
+	void *handle = real_dlopen(file, flags);
+	struct link_map *map;
+	if (handle && file && dlinfo(handle, RTLD_DI_LINKMAP, &map) == 0 && map->l_name[0] == '/')
+		log_library(map->l_name);
+	return handle;
+}
+
+/* Execs are logged before they happen, since they don't return when they
+   succeed. */
+
+int execve(const char *path, char *const argv[], char *const envp[]) {
+	REAL(execve);
+	log_exec(getpid(), tid(), path, 0, argv);
+	return real_execve(path, argv, envp);
+}
+
+int execv(const char *path, char *const argv[]) {
+	REAL(execv);
+	log_exec(getpid(), tid(), path, 0, argv);
+	return real_execv(path, argv);
+}
+
+int execvp(const char *file, char *const argv[]) {
+	REAL(execvp);
+	log_exec(getpid(), tid(), file, 1, argv);
+	return real_execvp(file, argv);
+}
+
+int execvpe(const char *file, char *const argv[], char *const envp[]) {
+	REAL(execvpe);
+	log_exec(getpid(), tid(), file, 1, argv);
+	return real_execvpe(file, argv, envp);
+}
+
+/* COLLECT_ARGS gathers the arguments of the execl functions into argv,
+   leaving ap after the terminating NULL for execle. */
+#define COLLECT_ARGS(arg, argv, ap) \
+	char *argv[MAX_ARGS + 1]; \
+	int argc = 0; \
+	va_list ap; \
+	va_start(ap, arg); \
+	argv[argc++] = (char *)arg; \
+	while (argv[argc - 1] && argc <= MAX_ARGS) \
+		argv[argc++] = va_arg(ap, char *); \
+	argv[MAX_ARGS] = NULL;
+
+int execl(const char *path, const char *arg, ...) {
+	COLLECT_ARGS(arg, argv, ap);
+	va_end(ap);
+	return execv(path, argv);
+}
+
+int execlp(const char *file, const char *arg, ...) {
+	COLLECT_ARGS(arg, argv, ap);
+	va_end(ap);
+	return execvp(file, argv);
+}
+
+int execle(const char *path, const char *arg, ...) {
+	COLLECT_ARGS(arg, argv, ap);
+	char *const *envp = va_arg(ap, char *const *);
+	va_end(ap);
+	return execve(path, argv, envp);
+}
+
+int posix_spawn(pid_t *pid, const char *path, const posix_spawn_file_actions_t *actions,
+		const posix_spawnattr_t *attr, char *const argv[], char *const envp[]) {
+	REAL(posix_spawn);
+	pid_t child;
+	int ret = real_posix_spawn(&child, path, actions, attr, argv, envp);
+	if (ret == 0) {
+		struct event e = {.len = 0};
+		putf(&e, "F %d %d", getpid(), child);
+		end_line(&e);
+		flush(&e);
+		log_exec(child, child, path, 0, argv);
+		if (pid)
+			*pid = child;
+	}
+	return ret;
+}
+
+int posix_spawnp(pid_t *pid, const char *file, const posix_spawn_file_actions_t *actions,
+		const posix_spawnattr_t *attr, char *const argv[], char *const envp[]) {
+	REAL(posix_spawnp);
+	pid_t child;
+	int ret = real_posix_spawnp(&child, file, actions, attr, argv, envp);
+	if (ret == 0) {
+		struct event e = {.len = 0};
+		putf(&e, "F %d %d", getpid(), child);
+		end_line(&e);
+		flush(&e);
+		log_exec(child, child, file, 1, argv);
+		if (pid)
+			*pid = child;
+	}
+	return ret;
+}
+
+int connect(int fd, const struct sockaddr *addr, socklen_t len) {
+	REAL(connect);
+	int ret = real_connect(fd, addr, len);
+	if (addr && (ret == 0 || errno == EINPROGRESS)) {
+		int saved = errno;
+		char host[INET6_ADDRSTRLEN];
+		struct event e = {.len = 0};
+		if (addr->sa_family == AF_INET && len >= sizeof(struct sockaddr_in)) {
+			const struct sockaddr_in *in = (const struct sockaddr_in *)addr;
+			inet_ntop(AF_INET, &in->sin_addr, host, sizeof(host));
+			putf(&e, "N %d %d %s:%d", tid(), getpid(), host, ntohs(in->sin_port));
+		} else if (addr->sa_family == AF_INET6 && len >= sizeof(struct sockaddr_in6)) {
+			const struct sockaddr_in6 *in6 = (const struct sockaddr_in6 *)addr;
+			inet_ntop(AF_INET6, &in6->sin6_addr, host, sizeof(host));
+			putf(&e, "N %d %d [%s]:%d", tid(), getpid(), host, ntohs(in6->sin6_port));
+		}
+		if (e.len > 0) {
+			end_line(&e);
+			flush(&e);
+		}
+		errno = saved;
+	}
+	return ret;
+}
+
+pid_t fork(void) {
+	REAL(fork);
+	int parent = getpid();
+	pid_t ret = real_fork();
+	if (ret == 0) {
+		int saved = errno;
+		struct event e = {.len = 0};
+		putf(&e, "F %d %d", parent, getpid());
+		end_line(&e);
+		flush(&e);
+		errno = saved;
+	}
+	return ret;
+}
+
+pid_t wait(int *status) {
+	REAL(wait);
+	int s = 0;
+	pid_t ret = real_wait(&s);
+	log_exit(ret, s);
+	if (status)
+		*status = s;
+	return ret;
+}
+
+pid_t waitpid(pid_t pid, int *status, int options) {
+	REAL(waitpid);
+	int s = 0;
+	pid_t ret = real_waitpid(pid, &s, options);
+	log_exit(ret, s);
+	if (status)
+		*status = s;
+	return ret;
+}
+
+pid_t wait4(pid_t pid, int *status, int options, struct rusage *usage) {
+	REAL(wait4);
+	int s = 0;
+	pid_t ret = real_wait4(pid, &s, options, usage);
+	log_exit(ret, s);
+	if (status)
+		*status = s;
+	return ret;
+}
+`
@@ -0,0 +1,138 @@
+// Package preload records builds by loading an interposer library into
+// their processes with LD_PRELOAD. Unlike the strace and ebpf recorders it
+// needs neither ptrace nor privileges, so it works in unprivileged
+// containers, but it only sees what dynamically linked programs do through
+// libc: statically linked programs like the Go toolchain, and raw syscalls,
+// go unrecorded.
+package preload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/yourbase/skipper/recorder"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// Environment variables read by the interposer.
+const (
+	// LogEnv names the file events are appended to.
+	LogEnv = "SKIPPER_PRELOAD_LOG"
+	// RootEnv is the pid of the recorder, where processes stop logging
+	// their ancestors.
+	RootEnv = "SKIPPER_PRELOAD_ROOT"
+)
+
+// LibraryEnv names a prebuilt interposer library, for hosts without a C
+// compiler.
+const LibraryEnv = "SKIPPER_PRELOAD_LIBRARY"
+
+// Recorder records builds with the interposer. Paths longer than PATH_MAX
+// and arguments after the 256th are truncated.
+type Recorder struct{}
+
+// Record implements recorder.Recorder.
+func (Recorder) Record(ctx context.Context, args []string, emit func(*stepselection.BuildLog) error) error {
+	if runtime.GOOS != "linux" {
+		return errors.New("the preload recorder only works on Linux")
+	}
+	lib, err := Library()
+	if err != nil {
+		return err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	log, err := ioutil.TempFile("", "skipper-preload-*.log")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(log.Name())
+	defer log.Close()
+
+	preload := lib
+	if prev := os.Getenv("LD_PRELOAD"); prev != "" {
+		preload += ":" + prev
+	}
+	cmd := recorder.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"LD_PRELOAD="+preload,
+		LogEnv+"="+log.Name(),
+		RootEnv+"="+strconv.Itoa(os.Getpid()))
+	runErr := cmd.Run()
+
+	// The build is a child of this process, which is the root of the
+	// recorded process tree.
+	t := recorder.NewTracker(cwd, emit)
+	t.Root(os.Getpid(), args)
+	t.Ignore(os.Getpid())
+	if err := recorder.ParseEvents(log, t); err != nil {
+		return err
+	}
+	// Processes whose parent was never seen, like daemons reparented to
+	// init, are still part of the build and are attributed to it by Close.
+	if err := t.Close(); err != nil {
+		return err
+	}
+	return runErr
+}
+
+// Library returns the path of the interposer library: the one named by
+// LibraryEnv or, by default, one compiled from the embedded source with the
+// C compiler named by CC, or cc, and cached in the user's cache directory.
+func Library() (string, error) {
+	if lib := os.Getenv(LibraryEnv); lib != "" {
+		return filepath.Abs(lib)
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(source))
+	lib := filepath.Join(cacheDir, "skipper", "preload-"+hex.EncodeToString(sum[:8])+".so")
+	if _, err := os.Stat(lib); err == nil {
+		return lib, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(lib), 0755); err != nil {
+		return "", err
+	}
+	if err := compile(lib); err != nil {
+		return "", err
+	}
+	return lib, nil
+}
+
+// compile builds the interposer into lib. Concurrent compilations each
+// write their own file and rename it into place.
+func compile(lib string) error {
+	dir, err := ioutil.TempDir(filepath.Dir(lib), "build")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "preload.c")
+	if err := ioutil.WriteFile(src, []byte(source), 0644); err != nil {
+		return err
+	}
+	cc := strings.Fields(os.Getenv("CC"))
+	if len(cc) == 0 {
+		cc = []string{"cc"}
+	}
+	out := filepath.Join(dir, "preload.so")
+	args := append(cc[1:], "-shared", "-fPIC", "-O2", "-o", out, src, "-ldl")
+	if b, err := exec.Command(cc[0], args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("could not compile the preload library, set %v to a prebuilt one: %v: %s", LibraryEnv, err, b)
+	}
+	return os.Rename(out, lib)
+}
@@ -0,0 +1,60 @@
+package preload
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+func TestRecord(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("LD_PRELOAD is Linux only")
+	}
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("no C compiler")
+	}
+	dir, err := ioutil.TempDir("", "skipper-preload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lib := filepath.Join(dir, "preload.so")
+	if err := compile(lib); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(LibraryEnv, lib)
+	defer os.Unsetenv(LibraryEnv)
+
+	in, out := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt")
+	if err := ioutil.WriteFile(in, []byte("hi\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	err = Recorder{}.Record(context.Background(), []string{"sh", "-c", "cat " + in + " > " + out}, func(bog *stepselection.BuildLog) error {
+		got[bog.Mode+" "+bog.File] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"R " + in, "W " + out} {
+		if !got[want] {
+			t.Errorf("missing %q in %v", want, got)
+		}
+	}
+	// The programs load libc, but the interposer isn't part of the build.
+	libc := false
+	for entry := range got {
+		libc = libc || strings.HasPrefix(entry, "L ") && strings.Contains(entry, "/libc.")
+	}
+	if !libc || got["L "+lib] {
+		t.Errorf("got the shared libraries of %v, wanted libc without the interposer", got)
+	}
+}
@@ -0,0 +1,346 @@
+// Package recorder captures the files read and written by a build, producing
+// build logs that stepselection can load.
+package recorder
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// Recorder runs a command and reports every file access made by the
+// command and its descendants. The strace and preload recorders also report
+// their network connections.
+type Recorder interface {
+	// Record runs args with the current process' stdio and calls emit for
+	// every build log entry. It returns the command's error, if any,
+	// after all entries have been emitted. The command is stopped when ctx
+	// is done, see CommandContext.
+	Record(ctx context.Context, args []string, emit func(*stepselection.BuildLog) error) error
+}
+
+// Tracker follows a tree of processes and turns their file accesses into
+// build log entries. Recorders feed it process and file events.
+//
+// Processes inherit the step of their parent. A process that execs a skipper
+// wrapper ("skipper [flags] -- cmd args") starts a new step nested in its
+// parent's step, so the recorded CmdTrees match the names skipper computes
+// when deciding. Accesses made outside any wrapped step belong to the
+// recorded command itself. Accesses by skipper processes are ignored since
+// they aren't part of the build.
+type Tracker struct {
+	cwd   string
+	emit  func(*stepselection.BuildLog) error
+	root  *process
+	procs map[int]*process
+	// pending holds events of processes whose parent isn't known yet.
+	// Tracers can report a child's first events before the fork that
+	// created it.
+	pending map[int][]func()
+	seen    map[string]bool
+	// writes counts the writes of each file, whose reads are recorded
+	// again after each write, see Access.
+	writes map[string]int
+	err    error
+	// now returns the time of the events being processed. Recorders parse
+	// events as they happen, so it's the current time.
+	now func() time.Time
+}
+
+type process struct {
+	steps stepselection.CmdTree
+	// implicit is set while steps only has the recorded command, which
+	// isn't a step of its own when skipper wrappers are used.
+	implicit bool
+	skipper  bool
+	// ignored processes, such as the recorder itself, have their accesses
+	// ignored, but not the accesses of their children.
+	ignored bool
+	cwd     string
+	// started is set on the process that started steps, the skipper
+	// wrapper or the recorded command, to when it started. The step lasts
+	// until the process exits.
+	started time.Time
+}
+
+// NewTracker creates a Tracker for a command started in cwd.
+func NewTracker(cwd string, emit func(*stepselection.BuildLog) error) *Tracker {
+	return &Tracker{
+		cwd:     cwd,
+		emit:    emit,
+		procs:   map[int]*process{},
+		pending: map[int][]func(){},
+		seen:    map[string]bool{},
+		writes:  map[string]int{},
+		now:     time.Now,
+	}
+}
+
+// Root registers the process running the recorded command.
+func (t *Tracker) Root(pid int, argv []string) {
+	p := &process{cwd: t.cwd, implicit: true, steps: stepselection.CmdTree{strings.Join(argv, " ")}, started: t.now()}
+	t.procs[pid] = p
+	t.Exec(pid, argv)
+	root := *p
+	t.root = &root
+	t.flush(pid)
+}
+
+// Ignore makes t ignore the accesses made by pid itself.
+func (t *Tracker) Ignore(pid int) {
+	if p, ok := t.procs[pid]; ok {
+		p.ignored = true
+	}
+}
+
+// HasRoot reports whether Root was called.
+func (t *Tracker) HasRoot() bool {
+	return t.root != nil
+}
+
+// Fork registers child as a new process created by parent.
+func (t *Tracker) Fork(parent, child int) {
+	pp, ok := t.procs[parent]
+	if !ok {
+		t.later(parent, func() { t.Fork(parent, child) })
+		return
+	}
+	c := *pp
+	c.ignored = false
+	c.started = time.Time{}
+	t.procs[child] = &c
+	t.flush(child)
+}
+
+// Exec records that pid is now running argv.
+func (t *Tracker) Exec(pid int, argv []string) {
+	p, ok := t.procs[pid]
+	if !ok {
+		t.later(pid, func() { t.Exec(pid, argv) })
+		return
+	}
+	step, ok := wrappedStep(argv)
+	if !ok {
+		p.skipper = false
+		return
+	}
+	if p.skipper && len(p.steps) > 0 && p.steps[len(p.steps)-1] == step {
+		// A skipper wrapper spawning itself with a build ID.
+		return
+	}
+	var steps stepselection.CmdTree
+	if !p.implicit {
+		steps = append(steps, p.steps...)
+	}
+	p.steps = append(steps, step)
+	p.implicit = false
+	p.skipper = true
+	p.started = t.now()
+}
+
+// Adopt registers pid, a process running argv whose creation wasn't seen,
+// as a child of parent. It does nothing if pid is already known.
+func (t *Tracker) Adopt(parent, pid int, argv []string) {
+	if _, ok := t.procs[pid]; ok {
+		return
+	}
+	t.Fork(parent, pid)
+	t.Exec(pid, argv)
+}
+
+// Exit records that pid exited. If it started a step, the step's duration is
+// recorded.
+func (t *Tracker) Exit(pid int) {
+	p, ok := t.procs[pid]
+	if !ok {
+		t.later(pid, func() { t.Exit(pid) })
+		return
+	}
+	if p.started.IsZero() || t.err != nil {
+		return
+	}
+	// Not deduplicated like accesses: a step run twice takes twice as long.
+	t.err = t.emit(&stepselection.BuildLog{CmdTree: p.steps, Type: "step", Duration: t.now().Sub(p.started)})
+	p.started = time.Time{}
+}
+
+// Chdir records that pid changed its working directory.
+func (t *Tracker) Chdir(pid int, dir string) {
+	p, ok := t.procs[pid]
+	if !ok {
+		t.later(pid, func() { t.Chdir(pid, dir) })
+		return
+	}
+	p.cwd = t.abs(p, dir)
+}
+
+// Access records that pid accessed path with the given BuildLog mode, at the
+// current time. Relative paths are resolved against the process' working
+// directory. Reads of shared libraries, which is how tracers see the dynamic
+// linker load them, are recorded as loads.
+//
+// Only the first write of a file by a step is recorded, but reads are
+// recorded again after each write of the file, so that the last read of a
+// step is recorded after every write it may have read.
+func (t *Tracker) Access(pid int, mode, path string) {
+	p, ok := t.procs[pid]
+	if !ok {
+		t.later(pid, func() { t.Access(pid, mode, path) })
+		return
+	}
+	if p.skipper || p.ignored || t.err != nil {
+		return
+	}
+	if mode == "R" && sharedLibrary.MatchString(path) {
+		mode = "L"
+	}
+	file := t.abs(p, path)
+	version := ""
+	if mode == "W" {
+		t.writes[file]++
+	} else {
+		version = strconv.Itoa(t.writes[file])
+	}
+	t.record(&stepselection.BuildLog{CmdTree: p.steps, Mode: mode, File: file}, version)
+}
+
+// sharedLibrary matches the names of shared libraries, like libz.so.1.3 or
+// libz.dylib.
+var sharedLibrary = regexp.MustCompile(`\.so(\.[0-9]+)*$|\.dylib$`)
+
+// ReadDir records that pid listed the directory dir.
+func (t *Tracker) ReadDir(pid int, dir string) {
+	p, ok := t.procs[pid]
+	if !ok {
+		t.later(pid, func() { t.ReadDir(pid, dir) })
+		return
+	}
+	if p.skipper || p.ignored || t.err != nil {
+		return
+	}
+	t.record(&stepselection.BuildLog{CmdTree: p.steps, Mode: "R", File: t.abs(p, dir), Type: "dir"}, "")
+}
+
+// Connect records that pid connected to the network address addr, a host
+// and port. Loopback connections stay within the machine, like the build
+// tools' own daemons, and aren't recorded.
+func (t *Tracker) Connect(pid int, addr string) {
+	p, ok := t.procs[pid]
+	if !ok {
+		t.later(pid, func() { t.Connect(pid, addr) })
+		return
+	}
+	if p.skipper || p.ignored || t.err != nil {
+		return
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return
+	}
+	t.record(&stepselection.BuildLog{CmdTree: p.steps, File: addr, Type: "net"}, "")
+}
+
+// record emits bog unless it was already emitted for the same version of
+// its file. File accesses are emitted with their time.
+func (t *Tracker) record(bog *stepselection.BuildLog, version string) {
+	key := stepselection.CmdTree(bog.CmdTree).Name() + "\x00" + bog.Mode + bog.Type + "\x00" + bog.File + "\x00" + version
+	if t.seen[key] {
+		return
+	}
+	t.seen[key] = true
+	if bog.Type == "" {
+		now := t.now().UTC()
+		bog.Time = &now
+	}
+	t.err = t.emit(bog)
+}
+
+// Cwd returns the working directory of pid.
+func (t *Tracker) Cwd(pid int) string {
+	if p, ok := t.procs[pid]; ok {
+		return p.cwd
+	}
+	return t.cwd
+}
+
+// DropPending forgets the events of processes whose parent was never seen.
+// Recorders that trace the whole system call it before Close, since those
+// processes are most likely not part of the build.
+func (t *Tracker) DropPending() {
+	t.pending = map[int][]func(){}
+}
+
+// Close processes the events of processes whose parent was never seen,
+// attributing them to the recorded command, and returns the first error
+// returned by emit.
+func (t *Tracker) Close() error {
+	// Steps still running, like the recorded command when the recorder is
+	// its parent, end now.
+	var running []int
+	for pid, p := range t.procs {
+		if !p.started.IsZero() {
+			running = append(running, pid)
+		}
+	}
+	sort.Ints(running)
+	for _, pid := range running {
+		t.Exit(pid)
+	}
+	for pid, events := range t.pending {
+		if t.root != nil {
+			c := *t.root
+			t.procs[pid] = &c
+		} else {
+			t.procs[pid] = &process{cwd: t.cwd}
+		}
+		for _, e := range events {
+			e()
+		}
+	}
+	t.pending = map[int][]func(){}
+	return t.err
+}
+
+func (t *Tracker) later(pid int, f func()) {
+	t.pending[pid] = append(t.pending[pid], f)
+}
+
+// flush replays the pending events of pid, which must be known by now.
+func (t *Tracker) flush(pid int) {
+	events := t.pending[pid]
+	delete(t.pending, pid)
+	for _, e := range events {
+		e()
+	}
+}
+
+func (t *Tracker) abs(p *process, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(p.cwd, path)
+}
+
+// wrappedStep returns the step run by a skipper wrapper, that is the
+// arguments after "--" in a skipper command line.
+func wrappedStep(argv []string) (string, bool) {
+	if len(argv) == 0 || filepath.Base(argv[0]) != "skipper" {
+		return "", false
+	}
+	for i, a := range argv {
+		if a == "--" && i+1 < len(argv) {
+			return strings.Join(argv[i+1:], " "), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,350 @@
+package recorder
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yourbase/skipper/stepselection"
+)
+
+// Strace records builds by running them under strace. It needs strace to be
+// installed and ptrace to be permitted, which isn't the case in some
+// containers.
+type Strace struct{}
+
+// Record implements Recorder.
+func (Strace) Record(ctx context.Context, args []string, emit func(*stepselection.BuildLog) error) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	// strace writes the trace to a pipe so that it can be parsed while
+	// the build runs, instead of buffering a potentially huge file.
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	straceArgs := append([]string{
+		"-f", "-qq", "-y", "-s", "65535",
+		"-e", "trace=file,process,getdents,getdents64,connect",
+		"-o", "/dev/fd/3",
+		"--",
+	}, args...)
+	cmd := CommandContext(ctx, "strace", straceArgs...)
+	cmd.ExtraFiles = []*os.File{w}
+	if err := cmd.Start(); err != nil {
+		r.Close()
+		w.Close()
+		return fmt.Errorf("could not start strace: %v", err)
+	}
+	w.Close()
+	t := NewTracker(cwd, emit)
+	parseErr := parseStrace(r, t)
+	r.Close()
+	runErr := cmd.Wait()
+	if parseErr != nil {
+		return parseErr
+	}
+	if err := t.Close(); err != nil {
+		return err
+	}
+	return runErr
+}
+
+var straceLine = regexp.MustCompile(`^(\d+)\s+(?:\d+\.\d+\s+)?(.*)$`)
+
+// parseStrace feeds the events in the output of strace -f -y to t. Lines
+// look like:
+//
+//	1234 openat(AT_FDCWD, "a.c", O_RDONLY) = 3</src/a.c>
+//	1234 clone(child_stack=NULL, flags=SIGCHLD <unfinished ...>
+//	1235 execve("/usr/bin/cc", ["cc", "-c", "a.c"], 0x7ffd /* 20 vars */) = 0
+//	1234 <... clone resumed>) = 1235
+func parseStrace(r io.Reader, t *Tracker) error {
+	unfinished := map[int]string{}
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line = strings.TrimSuffix(line, "\n"); line != "" {
+			m := straceLine.FindStringSubmatch(line)
+			if m == nil {
+				return fmt.Errorf("unexpected strace output: %q", line)
+			}
+			pid, _ := strconv.Atoi(m[1])
+			call := m[2]
+			if strings.HasSuffix(call, " <unfinished ...>") {
+				unfinished[pid] = strings.TrimSuffix(call, " <unfinished ...>")
+				continue
+			}
+			if strings.HasPrefix(call, "<... ") {
+				i := strings.Index(call, " resumed>")
+				if i < 0 {
+					return fmt.Errorf("unexpected strace output: %q", line)
+				}
+				call = unfinished[pid] + call[i+len(" resumed>"):]
+				delete(unfinished, pid)
+			}
+			straceCall(pid, call, t)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// straceCall interprets one complete syscall line.
+func straceCall(pid int, call string, t *Tracker) {
+	open := strings.IndexByte(call, '(')
+	end := strings.LastIndex(call, ") = ")
+	if strings.HasPrefix(call, "+++ exited with ") || strings.HasPrefix(call, "+++ killed by ") {
+		t.Exit(pid)
+		return
+	}
+	if open < 0 || end < open {
+		// Signals, exits and other notices.
+		return
+	}
+	name := call[:open]
+	args := splitStraceArgs(call[open+1 : end])
+	ret := call[end+len(") = "):]
+	if name == "connect" && (ret == "0" || strings.HasPrefix(ret, "-1 EINPROGRESS ")) {
+		// Non-blocking connections are still connections.
+		if addr := straceSockaddr(call[open+1 : end]); addr != "" {
+			t.Connect(pid, addr)
+		}
+		return
+	}
+	if strings.HasPrefix(ret, "-1 ") || strings.HasPrefix(ret, "?") {
+		return
+	}
+	if !t.HasRoot() {
+		if name != "execve" || len(args) < 2 {
+			return
+		}
+		t.Root(pid, straceStrings(args[1]))
+	}
+	arg := func(i int) string {
+		if i < len(args) {
+			return straceString(args[i])
+		}
+		return ""
+	}
+	// at resolves a path relative to a directory file descriptor argument.
+	at := func(dirfd, path int) string {
+		p := arg(path)
+		if strings.HasPrefix(p, "/") || dirfd >= len(args) {
+			return p
+		}
+		if dir := fdPath(args[dirfd]); dir != "" {
+			return dir + "/" + p
+		}
+		return p
+	}
+	switch name {
+	case "clone", "clone3", "fork", "vfork":
+		if child, err := strconv.Atoi(strings.Fields(ret)[0]); err == nil {
+			t.Fork(pid, child)
+		}
+	case "execve":
+		t.Exec(pid, straceStrings(args[1]))
+		t.Access(pid, "X", arg(0))
+	case "execveat":
+		t.Exec(pid, straceStrings(args[2]))
+		t.Access(pid, "X", at(0, 1))
+	case "chdir":
+		t.Chdir(pid, arg(0))
+	case "fchdir":
+		if dir := fdPath(args[0]); dir != "" {
+			t.Chdir(pid, dir)
+		}
+	case "open", "creat", "openat", "openat2":
+		path := fdPath(ret)
+		if path == "" {
+			if name == "openat" || name == "openat2" {
+				path = at(0, 1)
+			} else {
+				path = arg(0)
+			}
+		}
+		flags := ""
+		switch name {
+		case "open":
+			flags = arg(1)
+		case "openat", "openat2":
+			flags = arg(2)
+		case "creat":
+			flags = "O_CREAT"
+		}
+		for _, mode := range openModes(flags) {
+			t.Access(pid, mode, path)
+		}
+	case "unlink", "rmdir", "mkdir", "truncate":
+		t.Access(pid, "W", arg(0))
+	case "unlinkat", "mkdirat":
+		t.Access(pid, "W", at(0, 1))
+	case "rename", "link":
+		t.Access(pid, "W", arg(0))
+		t.Access(pid, "W", arg(1))
+	case "renameat", "renameat2", "linkat":
+		t.Access(pid, "W", at(0, 1))
+		t.Access(pid, "W", at(2, 3))
+	case "symlink":
+		t.Access(pid, "W", arg(1))
+	case "symlinkat":
+		t.Access(pid, "W", at(1, 2))
+	case "getdents", "getdents64":
+		if dir := fdPath(args[0]); dir != "" {
+			t.ReadDir(pid, dir)
+		}
+	}
+}
+
+var (
+	straceInetPort = regexp.MustCompile(`sin6?_port=htons\((\d+)\)`)
+	straceInetAddr = regexp.MustCompile(`inet_addr\("([^"]*)"\)|inet_pton\(AF_INET6, "([^"]*)"`)
+)
+
+// straceSockaddr returns the host and port of the Internet address in the
+// arguments of a connect call, or "" for other address families.
+func straceSockaddr(args string) string {
+	port := straceInetPort.FindStringSubmatch(args)
+	addr := straceInetAddr.FindStringSubmatch(args)
+	if port == nil || addr == nil {
+		return ""
+	}
+	return net.JoinHostPort(addr[1]+addr[2], port[1])
+}
+
+// openModes returns the BuildLog modes for open flags. O_RDWR both reads
+// and writes.
+func openModes(flags string) []string {
+	switch {
+	case strings.Contains(flags, "O_RDWR"):
+		return []string{"R", "W"}
+	case strings.Contains(flags, "O_WRONLY"), strings.Contains(flags, "O_CREAT"), strings.Contains(flags, "O_TRUNC"):
+		return []string{"W"}
+	}
+	return []string{"R"}
+}
+
+// splitStraceArgs splits syscall arguments on top-level commas, respecting
+// quoted strings, arrays and structs.
+func splitStraceArgs(s string) []string {
+	var args []string
+	depth, start := 0, 0
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inQuote && c == '\\':
+			i++
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '[' || c == '{' || c == '(' || c == '<':
+			depth++
+		case c == ']' || c == '}' || c == ')' || c == '>':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if rest := strings.TrimSpace(s[start:]); rest != "" {
+		args = append(args, rest)
+	}
+	return args
+}
+
+// straceString decodes a C-style quoted string as printed by strace. Strings
+// truncated by strace end with "..." after the closing quote, which is
+// dropped.
+func straceString(s string) string {
+	s = strings.TrimSuffix(s, "...")
+	if len(s) < 2 || s[0] != '"' {
+		return s
+	}
+	return unescapeStrace(s[1 : len(s)-1])
+}
+
+// straceStrings decodes an array of strings such as ["cc", "-c", "a.c"].
+func straceStrings(s string) []string {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '[' {
+		return nil
+	}
+	var out []string
+	for _, a := range splitStraceArgs(s[1 : len(s)-1]) {
+		if a != "..." {
+			out = append(out, straceString(a))
+		}
+	}
+	return out
+}
+
+// fdPath returns the path strace -y printed next to a file descriptor, as in
+// "3</src/a.c>" or "AT_FDCWD</src>".
+func fdPath(s string) string {
+	i := strings.IndexByte(s, '<')
+	j := strings.LastIndexByte(s, '>')
+	if i < 0 || j < i {
+		return ""
+	}
+	return strings.TrimSuffix(unescapeStrace(s[i+1:j]), " (deleted)")
+}
+
+func unescapeStrace(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; c {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'v':
+			b.WriteByte('\v')
+		case 'f':
+			b.WriteByte('\f')
+		case 'x':
+			if i+2 < len(s) {
+				if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+					b.WriteByte(byte(v))
+					i += 2
+					continue
+				}
+			}
+			b.WriteByte(c)
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			j := i
+			for j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7' {
+				j++
+			}
+			v, _ := strconv.ParseUint(s[i:j], 8, 8)
+			b.WriteByte(byte(v))
+			i = j - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
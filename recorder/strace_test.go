@@ -0,0 +1,100 @@
+package recorder
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/yourbase/skipper/stepselection"
+)
+
+const straceOutput = `100 execve("/usr/bin/make", ["make", "all"], 0x7ffd /* 20 vars */) = 0
+100 openat(AT_FDCWD</src>, "Makefile", O_RDONLY) = 3</src/Makefile>
+100 clone(child_stack=NULL, flags=CLONE_CHILD_CLEARTID|SIGCHLD <unfinished ...>
+101 execve("/usr/local/bin/skipper", ["skipper", "--", "cc", "-c", "a.c"], 0x55 /* 20 vars */) = 0
+100 <... clone resumed>, child_tidptr=0x7f) = 101
+101 openat(AT_FDCWD</src>, "/base-graph.gz", O_RDONLY|O_CLOEXEC) = 3</base-graph.gz>
+101 clone(child_stack=NULL, flags=SIGCHLD) = 102
+102 execve("/usr/local/bin/skipper", ["skipper", "--id", "X", "--", "cc", "-c", "a.c"], 0x55 /* 20 vars */) = 0
+102 clone(child_stack=NULL, flags=SIGCHLD) = 103
+103 execve("/usr/bin/cc", ["cc", "-c", "a.c"], 0x55 /* 20 vars */) = 0
+103 chdir("obj") = 0
+103 openat(AT_FDCWD</src/obj>, "../a.c", O_RDONLY) = 3</src/a.c>
+103 openat(AT_FDCWD</src/obj>, "missing.h", O_RDONLY) = -1 ENOENT (No such file or directory)
+103 openat(AT_FDCWD</src/obj>, "a.o", O_WRONLY|O_CREAT|O_TRUNC, 0666) = 4</src/obj/a.o>
+103 unlink("tmp\x20file") = 0
+103 connect(4<TCPv6:[6]>, {sa_family=AF_INET6, sin6_port=htons(443), sin6_flowinfo=htonl(0), inet_pton(AF_INET6, "2001:db8::1", &sin6_addr), sin6_scope_id=0}, 28) = -1 EINPROGRESS (Operation now in progress)
+103 connect(5<TCP:[7]>, {sa_family=AF_INET, sin_port=htons(5432), sin_addr=inet_addr("127.0.0.1")}, 16) = 0
+103 connect(6<UNIX:[8]>, {sa_family=AF_UNIX, sun_path="/run/x.sock"}, 110) = 0
+103 connect(7<TCP:[9]>, {sa_family=AF_INET, sin_port=htons(80), sin_addr=inet_addr("192.0.2.1")}, 16) = -1 ECONNREFUSED (Connection refused)
+103 +++ exited with 0 +++
+101 +++ exited with 0 +++
+100 openat(AT_FDCWD</src>, "README", O_RDONLY) = 3</src/README>
+100 getdents64(4</src/include>, 0x55 /* 3 entries */, 32768) = 80
+`
+
+func TestParseStrace(t *testing.T) {
+	var got []string
+	tracker := NewTracker("/src", func(bog *stepselection.BuildLog) error {
+		got = append(got, logLine(bog))
+		return nil
+	})
+	tracker.now = fakeClock()
+	if err := parseStrace(strings.NewReader(straceOutput), tracker); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`["make all"] X /usr/bin/make`,
+		`["make all"] R /src/Makefile`,
+		`["cc -c a.c"] X /usr/bin/cc`,
+		`["cc -c a.c"] R /src/a.c`,
+		`["cc -c a.c"] W /src/obj/a.o`,
+		`["cc -c a.c"] W /src/obj/tmp file`,
+		`["cc -c a.c"] net [2001:db8::1]:443`,
+		`["cc -c a.c"] step 5s`,
+		`["make all"] R /src/README`,
+		`["make all"] Rdir /src/include`,
+		`["make all"] step 10s`,
+	}
+	if diff := cmp.Diff(got, want); len(diff) > 0 {
+		t.Errorf("unexpected entries, diff: %v", diff)
+	}
+}
+
+func TestWrappedStep(t *testing.T) {
+	for _, tc := range []struct {
+		argv []string
+		want string
+	}{
+		{[]string{"skipper", "--", "go", "test"}, "go test"},
+		{[]string{"/bin/skipper", "--id", "X", "--", "make"}, "make"},
+		{[]string{"skipper", "daemon"}, ""},
+		{[]string{"make", "--", "x"}, ""},
+	} {
+		if got, _ := wrappedStep(tc.argv); got != tc.want {
+			t.Errorf("wrappedStep(%q) = %q, wanted %q", tc.argv, got, tc.want)
+		}
+	}
+}
+
+// logLine formats bog for comparisons.
+func logLine(bog *stepselection.BuildLog) string {
+	line := stepselection.CmdTree(bog.CmdTree).Name() + " " + bog.Mode + bog.Type + " " + bog.File
+	if bog.Type == "step" {
+		line += bog.Duration.String()
+	}
+	return line
+}
+
+// fakeClock returns a clock that advances by a second every time it's read.
+func fakeClock() func() time.Time {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	return func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+}
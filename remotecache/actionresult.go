@@ -0,0 +1,195 @@
+package remotecache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ActionResult is the part of the build.bazel.remote.execution.v2
+// ActionResult message skipper uses: the output files of an action. Action
+// results are stored in the protocol buffer encoding, which caches may
+// check. Other fields are dropped when decoding.
+type ActionResult struct {
+	OutputFiles []OutputFile
+}
+
+// OutputFile is a file of an ActionResult. Path is relative to the root of
+// the action.
+type OutputFile struct {
+	Path       string
+	Digest     Digest
+	Executable bool
+}
+
+// Digest addresses a blob of the CAS by the SHA-256 hash of its contents and
+// their size.
+type Digest struct {
+	Hash string
+	Size int64
+}
+
+// Field numbers of the messages.
+const (
+	actionResultOutputFiles = 2
+
+	outputFilePath         = 1
+	outputFileDigest       = 2
+	outputFileIsExecutable = 4
+
+	digestHash      = 1
+	digestSizeBytes = 2
+)
+
+// Wire types of the encoding.
+const (
+	wireVarint = 0
+	wireI64    = 1
+	wireBytes  = 2
+	wireI32    = 5
+)
+
+// Marshal encodes ar.
+func (ar *ActionResult) Marshal() []byte {
+	var b []byte
+	for _, f := range ar.OutputFiles {
+		b = appendBytes(b, actionResultOutputFiles, f.marshal())
+	}
+	return b
+}
+
+func (f *OutputFile) marshal() []byte {
+	var b []byte
+	b = appendBytes(b, outputFilePath, []byte(f.Path))
+	b = appendBytes(b, outputFileDigest, f.Digest.marshal())
+	if f.Executable {
+		b = appendVarint(b, outputFileIsExecutable, 1)
+	}
+	return b
+}
+
+func (d *Digest) marshal() []byte {
+	var b []byte
+	b = appendBytes(b, digestHash, []byte(d.Hash))
+	if d.Size != 0 {
+		b = appendVarint(b, digestSizeBytes, uint64(d.Size))
+	}
+	return b
+}
+
+// Unmarshal decodes the encoding of an ActionResult into ar.
+func (ar *ActionResult) Unmarshal(b []byte) error {
+	*ar = ActionResult{}
+	return decodeFields(b, func(num int, v uint64, data []byte) error {
+		if num != actionResultOutputFiles || data == nil {
+			return nil
+		}
+		var f OutputFile
+		if err := f.unmarshal(data); err != nil {
+			return err
+		}
+		ar.OutputFiles = append(ar.OutputFiles, f)
+		return nil
+	})
+}
+
+func (f *OutputFile) unmarshal(b []byte) error {
+	err := decodeFields(b, func(num int, v uint64, data []byte) error {
+		switch num {
+		case outputFilePath:
+			f.Path = string(data)
+		case outputFileDigest:
+			return f.Digest.unmarshal(data)
+		case outputFileIsExecutable:
+			f.Executable = v != 0
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if f.Path == "" {
+		return errors.New("output file without a path")
+	}
+	return nil
+}
+
+func (d *Digest) unmarshal(b []byte) error {
+	err := decodeFields(b, func(num int, v uint64, data []byte) error {
+		switch num {
+		case digestHash:
+			d.Hash = string(data)
+		case digestSizeBytes:
+			d.Size = int64(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := hex.DecodeString(d.Hash); err != nil || len(d.Hash) != sha256.Size*2 {
+		return fmt.Errorf("invalid digest hash %q", d.Hash)
+	}
+	return nil
+}
+
+func appendTag(b []byte, num, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(wire))
+}
+
+func appendVarint(b []byte, num int, v uint64) []byte {
+	return binary.AppendUvarint(appendTag(b, num, wireVarint), v)
+}
+
+func appendBytes(b []byte, num int, data []byte) []byte {
+	b = binary.AppendUvarint(appendTag(b, num, wireBytes), uint64(len(data)))
+	return append(b, data...)
+}
+
+// decodeFields calls field with each field of the message b, with the value
+// of varint fields or the data of length-delimited ones, which is never nil.
+// Fixed-size fields are skipped.
+func decodeFields(b []byte, field func(num int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("truncated message")
+		}
+		b = b[n:]
+		num, wire := int(tag>>3), int(tag&7)
+		var v uint64
+		var data []byte
+		switch wire {
+		case wireVarint:
+			if v, n = binary.Uvarint(b); n <= 0 {
+				return errors.New("truncated message")
+			}
+			b = b[n:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return errors.New("truncated message")
+			}
+			data = b[n : n+int(l) : n+int(l)]
+			b = b[n+int(l):]
+		case wireI64, wireI32:
+			size := 8
+			if wire == wireI32 {
+				size = 4
+			}
+			if len(b) < size {
+				return errors.New("truncated message")
+			}
+			b = b[size:]
+			continue
+		default:
+			return fmt.Errorf("unsupported wire type %d", wire)
+		}
+		if err := field(num, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
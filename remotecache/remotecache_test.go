@@ -0,0 +1,121 @@
+package remotecache
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeCache serves an in-memory remote cache, for the user "ci".
+func fakeCache(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	entries := map[string][]byte{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "ci" || password != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case "GET":
+			b, ok := entries[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(b)
+		case "PUT":
+			b, err := ioutil.ReadAll(r.Body)
+			if err != nil || int64(len(b)) != r.ContentLength {
+				t.Errorf("PUT %v: got %d bytes with a length of %d, %v", r.URL.Path, len(b), r.ContentLength, err)
+			}
+			entries[r.URL.Path] = b
+		}
+	}))
+}
+
+func TestClient(t *testing.T) {
+	srv := fakeCache(t)
+	defer srv.Close()
+	c := &Client{URL: strings.Replace(srv.URL, "http://", "http://ci:secret@", 1) + "/prefix/"}
+
+	d, err := c.PutFile(strings.NewReader("contents"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Hash != Key("contents") || d.Size != 8 {
+		t.Errorf("got digest %+v", d)
+	}
+	ar := &ActionResult{OutputFiles: []OutputFile{{Path: "out/app", Digest: d, Executable: true}}}
+	key := Key("step")
+	if err := c.PutActionResult(key, ar); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetActionResult(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(ar, got); diff != "" {
+		t.Errorf("action result (-want +got):\n%v", diff)
+	}
+	buf := new(bytes.Buffer)
+	if n, err := c.GetBlob(d.Hash, buf); err != nil || n != 8 || buf.String() != "contents" {
+		t.Errorf("got blob %q, %d, %v", buf, n, err)
+	}
+
+	if _, err := c.GetActionResult(Key("unknown")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown key: got %v, wanted ErrNotFound", err)
+	}
+	// A blob whose contents don't match its hash.
+	if err := c.PutBlob(Key("other"), 8, strings.NewReader("contents")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetBlob(Key("other"), ioutil.Discard); err == nil {
+		t.Error("got no error for a corrupted blob")
+	}
+	if err := c.PutBlob(Key(""), 0, strings.NewReader("")); err != nil {
+		t.Errorf("empty blob: %v", err)
+	}
+	if _, err := c.GetBlob("../ac", ioutil.Discard); err == nil {
+		t.Error("got no error for an invalid hash")
+	}
+
+	c.URL = srv.URL
+	if _, err := c.GetActionResult(key); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("without credentials: got %v", err)
+	}
+}
+
+func TestActionResultEncoding(t *testing.T) {
+	hash := strings.Repeat("0", 64)
+	ar := &ActionResult{OutputFiles: []OutputFile{{Path: "a", Digest: Digest{Hash: hash, Size: 3}, Executable: true}}}
+	digest := append(append([]byte{0x0a, 64}, hash...), 0x10, 3)
+	file := append(append([]byte{0x0a, 1, 'a', 0x12, byte(len(digest))}, digest...), 0x20, 1)
+	want := append([]byte{0x12, byte(len(file))}, file...)
+	if got := ar.Marshal(); !bytes.Equal(got, want) {
+		t.Errorf("got encoding %x, wanted %x", got, want)
+	}
+
+	// Fields skipper doesn't use, like the exit code and execution
+	// metadata, are skipped.
+	extended := append([]byte{0x20, 1, 0x4a, 2, 0x09, 0x01}, want...)
+	got := &ActionResult{}
+	if err := got.Unmarshal(extended); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(ar, got); diff != "" {
+		t.Errorf("action result (-want +got):\n%v", diff)
+	}
+	if err := got.Unmarshal(want[:len(want)-1]); err == nil {
+		t.Error("got no error for a truncated action result")
+	}
+}
@@ -0,0 +1,466 @@
+// Package shellscript splits POSIX shell scripts into their top-level
+// commands, so that skipper can decide whether to run each of them.
+//
+// It doesn't interpret the script: it only knows enough of the shell grammar
+// to find where commands start and end, which words they have and whether
+// they are compound commands, pipelines or have redirections.
+package shellscript
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Command is a pipeline at the top level of a script, which is a command of
+// its own or an element of an && or || list.
+type Command struct {
+	// Text is the source of the command, from Start to End in the script.
+	Text       string
+	Start, End int
+	// Line is the line the command starts on, from 1.
+	Line int
+	// Words are the words of a simple command, as written. They're only
+	// set for commands that aren't compound.
+	Words []string
+	// Compound is set for commands that contain if, case, for, while,
+	// until, subshells, groups or function definitions.
+	Compound bool
+	// Pipe is set for pipelines of several commands.
+	Pipe bool
+	// Redirect is set for commands with redirections.
+	Redirect bool
+	// Heredoc is set for commands with here-documents, whose bodies follow
+	// the command's line and aren't part of Text.
+	Heredoc bool
+	// Func is the name of the function the command defines, if any.
+	Func string
+}
+
+// Parse returns the top-level commands of script, in order.
+func Parse(script string) ([]*Command, error) {
+	l := &lexer{s: script, line: 1}
+	var (
+		cmds  []*Command
+		cur   *Command
+		stack []string
+		// cmdPos is set where a reserved word would be recognized.
+		cmdPos = true
+		// casePattern is set while reading the patterns of a case item,
+		// and caseIn while waiting for the "in" of a case.
+		casePattern, caseIn bool
+		// funcName is set after the "function" reserved word.
+		funcName bool
+	)
+	pop := func(t token, open string) error {
+		if len(stack) == 0 || stack[len(stack)-1] != open {
+			return fmt.Errorf("line %d: unexpected %q", t.line, t.text)
+		}
+		stack = stack[:len(stack)-1]
+		return nil
+	}
+	for {
+		t, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind == tEOF {
+			break
+		}
+		separator := t.kind == tNewline || (t.kind == tOp && (t.text == ";" || t.text == "&" || t.text == "&&" || t.text == "||"))
+		if len(stack) == 0 {
+			if separator {
+				if cur != nil {
+					cur.Text = script[cur.Start:cur.End]
+					cmds = append(cmds, cur)
+					cur = nil
+				}
+				cmdPos = true
+				continue
+			}
+			if cur == nil {
+				cur = &Command{Start: t.start, Line: t.line}
+			}
+		}
+		cur.End = t.end
+		if t.kind == tNewline || separator {
+			cmdPos = true
+			continue
+		}
+		if t.kind == tOp {
+			switch t.text {
+			case "|":
+				if len(stack) == 0 {
+					cur.Pipe = true
+				}
+				cmdPos = !casePattern
+			case "(":
+				if casePattern {
+					continue
+				}
+				if len(stack) == 0 && len(cur.Words) == 1 && !cur.Compound {
+					cur.Func = cur.Words[0]
+				}
+				stack = append(stack, "(")
+				cur.Compound, cmdPos = true, true
+			case ")":
+				if casePattern {
+					casePattern, cmdPos = false, true
+					continue
+				}
+				if err := pop(t, "("); err != nil {
+					return nil, err
+				}
+				cmdPos = true
+			case ";;", ";&", ";;&":
+				if len(stack) == 0 || stack[len(stack)-1] != "case" {
+					return nil, fmt.Errorf("line %d: unexpected %q", t.line, t.text)
+				}
+				casePattern, cmdPos = true, false
+			case "<<", "<<-":
+				cur.Heredoc, cur.Redirect = true, true
+				cmdPos = false
+			default:
+				// The other operators are redirections.
+				cur.Redirect = true
+				cmdPos = false
+			}
+			continue
+		}
+
+		word := t.text
+		switch {
+		case funcName:
+			funcName = false
+			if len(stack) == 0 {
+				cur.Func = word
+			}
+			continue
+		case caseIn && word == "in":
+			caseIn, casePattern = false, true
+			continue
+		case casePattern && word == "esac", cmdPos && word == "esac":
+			if err := pop(t, "case"); err != nil {
+				return nil, err
+			}
+			casePattern, cmdPos = false, false
+			continue
+		case casePattern:
+			continue
+		}
+		if cmdPos && reserved[word] {
+			cur.Compound = true
+			cmdPos = true
+			var err error
+			switch word {
+			case "if", "{":
+				stack = append(stack, word)
+			case "while", "until":
+				stack = append(stack, "loop")
+			case "do":
+				if err = pop(t, "loop"); err == nil {
+					stack = append(stack, "do")
+				}
+			case "fi":
+				err = pop(t, "if")
+				cmdPos = false
+			case "done":
+				err = pop(t, "do")
+				cmdPos = false
+			case "}":
+				err = pop(t, "{")
+				cmdPos = false
+			case "case":
+				stack = append(stack, "case")
+				caseIn, cmdPos = true, false
+			case "for", "select":
+				stack = append(stack, "loop")
+				cmdPos = false
+			case "function":
+				funcName, cmdPos = true, false
+			case "[[", "]]":
+				cmdPos = false
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if len(stack) == 0 && !cur.Compound {
+			cur.Words = append(cur.Words, word)
+		}
+		cmdPos = false
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("unterminated %q", stack[len(stack)-1])
+	}
+	if cur != nil {
+		cur.Text = script[cur.Start:cur.End]
+		cmds = append(cmds, cur)
+	}
+	return cmds, nil
+}
+
+// reserved holds the reserved words of the shell that affect how commands
+// nest. "then", "else", "elif", "while", "until" and "!" are only there
+// because a reserved word follows them.
+var reserved = map[string]bool{
+	"if": true, "then": true, "else": true, "elif": true, "fi": true,
+	"case": true, "esac": true,
+	"for": true, "select": true, "while": true, "until": true, "do": true, "done": true,
+	"{": true, "}": true, "!": true, "function": true, "[[": true, "]]": true,
+}
+
+const (
+	tWord = iota
+	tOp
+	tNewline
+	tEOF
+)
+
+type token struct {
+	kind       int
+	text       string
+	start, end int
+	line       int
+}
+
+type heredoc struct {
+	delim string
+	// tabs is set for <<-, which strips leading tabs.
+	tabs bool
+}
+
+type lexer struct {
+	s    string
+	i    int
+	line int
+	// pending holds the here-documents whose bodies start after the
+	// current line.
+	pending []heredoc
+	// delim is set after a here-document operator, whose delimiter is the
+	// next word.
+	delim     bool
+	delimTabs bool
+}
+
+var operators = []string{"&&", "||", ";;&", ";;", ";&", "<<-", "<<", ">>", "<&", ">&", "<>", ">|", "&", "|", ";", "<", ">", "(", ")"}
+
+func (l *lexer) next() (token, error) {
+	l.skipBlanks()
+	if l.i >= len(l.s) {
+		return token{kind: tEOF, start: l.i, end: l.i, line: l.line}, nil
+	}
+	start, line := l.i, l.line
+	if l.s[l.i] == '\n' {
+		l.i++
+		l.line++
+		if err := l.heredocBodies(); err != nil {
+			return token{}, err
+		}
+		return token{kind: tNewline, text: "\n", start: start, end: start + 1, line: line}, nil
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(l.s[l.i:], op) {
+			l.i += len(op)
+			if op == "<<" || op == "<<-" {
+				l.delim, l.delimTabs = true, op == "<<-"
+			}
+			return token{kind: tOp, text: op, start: start, end: l.i, line: line}, nil
+		}
+	}
+	if err := l.word(); err != nil {
+		return token{}, err
+	}
+	text := l.s[start:l.i]
+	if l.delim {
+		l.delim = false
+		l.pending = append(l.pending, heredoc{delim: unquote(text), tabs: l.delimTabs})
+	}
+	return token{kind: tWord, text: text, start: start, end: l.i, line: line}, nil
+}
+
+// skipBlanks advances past blanks, line continuations and comments.
+func (l *lexer) skipBlanks() {
+	for l.i < len(l.s) {
+		switch {
+		case l.s[l.i] == ' ' || l.s[l.i] == '\t' || l.s[l.i] == '\r':
+			l.i++
+		case strings.HasPrefix(l.s[l.i:], "\\\n"):
+			l.i += 2
+			l.line++
+		case l.s[l.i] == '#':
+			for l.i < len(l.s) && l.s[l.i] != '\n' {
+				l.i++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// word advances past a word.
+func (l *lexer) word() error {
+	for l.i < len(l.s) {
+		c := l.s[l.i]
+		switch {
+		case strings.IndexByte(" \t\r\n|&;<>()", c) >= 0:
+			return nil
+		case c == '\\':
+			if l.i+1 < len(l.s) && l.s[l.i+1] == '\n' {
+				l.line++
+			}
+			l.i += 2
+		case c == '\'':
+			end := strings.IndexByte(l.s[l.i+1:], '\'')
+			if end < 0 {
+				return fmt.Errorf("line %d: unterminated single quote", l.line)
+			}
+			l.line += strings.Count(l.s[l.i:l.i+1+end], "\n")
+			l.i += end + 2
+		case c == '"':
+			if err := l.doubleQuoted(); err != nil {
+				return err
+			}
+		case c == '`':
+			if err := l.backquoted(); err != nil {
+				return err
+			}
+		case c == '$' && l.i+1 < len(l.s) && (l.s[l.i+1] == '(' || l.s[l.i+1] == '{'):
+			if err := l.expansion(); err != nil {
+				return err
+			}
+		default:
+			l.i++
+		}
+	}
+	if l.i > len(l.s) {
+		l.i = len(l.s)
+	}
+	return nil
+}
+
+func (l *lexer) doubleQuoted() error {
+	line := l.line
+	l.i++
+	for l.i < len(l.s) {
+		switch c := l.s[l.i]; {
+		case c == '"':
+			l.i++
+			return nil
+		case c == '\\':
+			l.i += 2
+		case c == '`':
+			if err := l.backquoted(); err != nil {
+				return err
+			}
+		case c == '$' && l.i+1 < len(l.s) && (l.s[l.i+1] == '(' || l.s[l.i+1] == '{'):
+			if err := l.expansion(); err != nil {
+				return err
+			}
+		default:
+			if c == '\n' {
+				l.line++
+			}
+			l.i++
+		}
+	}
+	return fmt.Errorf("line %d: unterminated double quote", line)
+}
+
+func (l *lexer) backquoted() error {
+	line := l.line
+	for l.i++; l.i < len(l.s); l.i++ {
+		switch l.s[l.i] {
+		case '\\':
+			l.i++
+		case '\n':
+			l.line++
+		case '`':
+			l.i++
+			return nil
+		}
+	}
+	return fmt.Errorf("line %d: unterminated backquote", line)
+}
+
+// expansion advances past a $(...), $((...)) or ${...} expansion.
+func (l *lexer) expansion() error {
+	line := l.line
+	open := l.s[l.i+1]
+	close := byte(')')
+	if open == '{' {
+		close = '}'
+	}
+	l.i += 2
+	depth := 1
+	for l.i < len(l.s) {
+		switch c := l.s[l.i]; {
+		case c == open:
+			depth++
+			l.i++
+		case c == close:
+			depth--
+			l.i++
+			if depth == 0 {
+				return nil
+			}
+		case c == '\\':
+			l.i += 2
+		case c == '\'':
+			end := strings.IndexByte(l.s[l.i+1:], '\'')
+			if end < 0 {
+				return fmt.Errorf("line %d: unterminated single quote", l.line)
+			}
+			l.line += strings.Count(l.s[l.i:l.i+1+end], "\n")
+			l.i += end + 2
+		case c == '"':
+			if err := l.doubleQuoted(); err != nil {
+				return err
+			}
+		case c == '`':
+			if err := l.backquoted(); err != nil {
+				return err
+			}
+		default:
+			if c == '\n' {
+				l.line++
+			}
+			l.i++
+		}
+	}
+	return fmt.Errorf("line %d: unterminated $%c", line, open)
+}
+
+// heredocBodies advances past the bodies of the pending here-documents, at
+// the start of the line following their operators.
+func (l *lexer) heredocBodies() error {
+	for _, h := range l.pending {
+		for {
+			if l.i >= len(l.s) {
+				return fmt.Errorf("unterminated here-document %q", h.delim)
+			}
+			end := strings.IndexByte(l.s[l.i:], '\n')
+			var line string
+			if end < 0 {
+				line, l.i = l.s[l.i:], len(l.s)
+			} else {
+				line, l.i = l.s[l.i:l.i+end], l.i+end+1
+			}
+			l.line++
+			if h.tabs {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if line == h.delim {
+				break
+			}
+		}
+	}
+	l.pending = nil
+	return nil
+}
+
+// unquote removes the quotes of a here-document delimiter.
+func unquote(word string) string {
+	r := strings.NewReplacer(`'`, "", `"`, "", `\`, "")
+	return r.Replace(word)
+}
@@ -0,0 +1,91 @@
+package shellscript
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	script := `#!/usr/bin/skipper sh
+set -eu
+# Build everything.
+export GOFLAGS=-mod=mod
+cd src && go build ./... || echo "failed; retrying" # comment
+go test -run 'Test(A|B)' \
+  ./pkg/...
+go vet $(go list ./... | grep -v /vendor/) | tee vet.log
+if [ -n "$CI" ]; then
+  upload "a b"
+fi
+for f in *.txt; do cat "$f"; done > all.txt
+case "$OS" in
+  linux|darwin) echo unix ;;
+  (windows) echo win ;;
+esac
+build() {
+  make -C "$1"
+}
+build lib &
+cat <<-'EOF' > out.txt
+	if (
+	EOF
+echo ` + "`date`" + ` done
+`
+	cmds, err := Parse(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type cmd struct {
+		Line                              int
+		Text                              string
+		Words                             []string
+		Compound, Pipe, Redirect, Heredoc bool
+		Func                              string
+	}
+	var got []cmd
+	for _, c := range cmds {
+		got = append(got, cmd{c.Line, c.Text, c.Words, c.Compound, c.Pipe, c.Redirect, c.Heredoc, c.Func})
+		if script[c.Start:c.End] != c.Text {
+			t.Errorf("line %d: Start and End don't match the text", c.Line)
+		}
+	}
+	want := []cmd{
+		{Line: 2, Text: "set -eu", Words: []string{"set", "-eu"}},
+		{Line: 4, Text: "export GOFLAGS=-mod=mod", Words: []string{"export", "GOFLAGS=-mod=mod"}},
+		{Line: 5, Text: "cd src", Words: []string{"cd", "src"}},
+		{Line: 5, Text: "go build ./...", Words: []string{"go", "build", "./..."}},
+		{Line: 5, Text: `echo "failed; retrying"`, Words: []string{"echo", `"failed; retrying"`}},
+		{Line: 6, Text: "go test -run 'Test(A|B)' \\\n  ./pkg/...", Words: []string{"go", "test", "-run", "'Test(A|B)'", "./pkg/..."}},
+		{Line: 8, Text: "go vet $(go list ./... | grep -v /vendor/) | tee vet.log", Words: []string{"go", "vet", "$(go list ./... | grep -v /vendor/)", "tee", "vet.log"}, Pipe: true},
+		{Line: 9, Text: "if [ -n \"$CI\" ]; then\n  upload \"a b\"\nfi", Compound: true},
+		{Line: 12, Text: `for f in *.txt; do cat "$f"; done > all.txt`, Compound: true, Redirect: true},
+		{Line: 13, Text: "case \"$OS\" in\n  linux|darwin) echo unix ;;\n  (windows) echo win ;;\nesac", Compound: true},
+		{Line: 17, Text: "build() {\n  make -C \"$1\"\n}", Words: []string{"build"}, Compound: true, Func: "build"},
+		{Line: 20, Text: "build lib", Words: []string{"build", "lib"}},
+		{Line: 21, Text: "cat <<-'EOF' > out.txt", Words: []string{"cat", "'EOF'", "out.txt"}, Redirect: true, Heredoc: true},
+		{Line: 24, Text: "echo `date` done", Words: []string{"echo", "`date`", "done"}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, script := range []string{
+		"echo 'unterminated",
+		`echo "unterminated`,
+		"echo $(unterminated",
+		"if true; then echo",
+		"echo a; fi",
+		"cat <<EOF\nno end\n",
+		"( echo",
+	} {
+		if _, err := Parse(script); err == nil {
+			t.Errorf("%q: got no error", script)
+		} else if strings.Contains(err.Error(), "panic") {
+			t.Errorf("%q: %v", script, err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Package signing signs files with ed25519 keys and verifies them, so that
+// a dependency graph fetched from a CI build can be checked before skipper
+// trusts it to skip steps.
+//
+// The signature of a file is kept next to it, with a .sig extension
+// appended, as base64 text. Files are hashed with SHA-512 and signed with
+// Ed25519ph, so that large graphs don't have to fit in memory. Keys are PEM
+// files: PKCS #8 private keys and PKIX public keys.
+package signing
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// ErrBadSignature is returned, wrapped, when a file doesn't match its
+// signature.
+var ErrBadSignature = errors.New("bad signature")
+
+var options = &ed25519.Options{Hash: crypto.SHA512}
+
+// SigPath returns where the signature of file is kept.
+func SigPath(file string) string {
+	return file + ".sig"
+}
+
+// GenerateKey returns a new key pair, PEM-encoded.
+func GenerateKey() (publicKey, privateKey []byte, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), nil
+}
+
+// ReadPublicKey reads an ed25519 public key from the PEM file path.
+func ReadPublicKey(path string) (ed25519.PublicKey, error) {
+	der, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %v: %v", path, err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid public key %v: not an ed25519 key", path)
+	}
+	return pub, nil
+}
+
+// ReadPrivateKey reads an ed25519 private key from the PEM file path.
+func ReadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key %v: %v", path, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid private key %v: not an ed25519 key", path)
+	}
+	return priv, nil
+}
+
+func readPEM(path, typ string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != typ {
+		return nil, fmt.Errorf("%v has no PEM block of type %q", path, typ)
+	}
+	return block.Bytes, nil
+}
+
+// SignFile signs file with key, and writes the signature to SigPath(file).
+func SignFile(file string, key ed25519.PrivateKey) error {
+	digest, err := hashFile(file)
+	if err != nil {
+		return err
+	}
+	sig, err := key.Sign(nil, digest, options)
+	if err != nil {
+		return err
+	}
+	out := SigPath(file)
+	tmp := out + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(base64.StdEncoding.EncodeToString(sig)+"\n"), 0644); err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return os.Rename(tmp, out)
+}
+
+// VerifyFile checks that file matches its signature by key. The file may
+// change once checked: to use the bytes checked, see Verify.
+func VerifyFile(file string, key ed25519.PublicKey) error {
+	digest, err := hashFile(file)
+	if err != nil {
+		return err
+	}
+	return verify(file, digest, key)
+}
+
+// Verify checks that data, read from file, matches the signature of file by
+// key.
+func Verify(file string, data []byte, key ed25519.PublicKey) error {
+	digest := sha512.Sum512(data)
+	return verify(file, digest[:], key)
+}
+
+// verify checks digest, the hash of file, against the signature of file by
+// key.
+func verify(file string, digest []byte, key ed25519.PublicKey) error {
+	b, err := ioutil.ReadFile(SigPath(file))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%v isn't signed: %v is missing", file, SigPath(file))
+	} else if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(b)))
+	if err != nil {
+		return fmt.Errorf("%w: invalid signature file %v: %v", ErrBadSignature, SigPath(file), err)
+	}
+	if err := ed25519.VerifyWithOptions(key, digest, sig, options); err != nil {
+		return fmt.Errorf("%w: %v doesn't match %v", ErrBadSignature, file, SigPath(file))
+	}
+	return nil
+}
+
+func hashFile(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha512.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
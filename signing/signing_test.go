@@ -0,0 +1,82 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestSignFile(t *testing.T) {
+	dir := t.TempDir()
+	pubPEM, privPEM, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubFile, privFile := filepath.Join(dir, "key.pub"), filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(pubFile, pubPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(privFile, privPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ReadPublicKey(pubFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := ReadPrivateKey(privFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadPublicKey(privFile); err == nil {
+		t.Errorf("a private key shouldn't be read as a public key")
+	}
+
+	graph := filepath.Join(dir, "graph.bin")
+	if err := ioutil.WriteFile(graph, []byte("graph"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyFile(graph, pub); err == nil {
+		t.Errorf("an unsigned file shouldn't verify")
+	}
+	if err := SignFile(graph, priv); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyFile(graph, pub); err != nil {
+		t.Errorf("VerifyFile of a signed file: %v", err)
+	}
+
+	if err := ioutil.WriteFile(graph, []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyFile(graph, pub); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("VerifyFile of a tampered file: got %v, wanted ErrBadSignature", err)
+	}
+
+	otherPEM, _, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pubFile, otherPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	other, err := ReadPublicKey(pubFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SignFile(graph, priv); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyFile(graph, other); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("VerifyFile with another key: got %v, wanted ErrBadSignature", err)
+	}
+
+	// The bytes read are checked, not the file.
+	if err := Verify(graph, []byte("tampered"), priv.Public().(ed25519.PublicKey)); err != nil {
+		t.Errorf("Verify of the signed bytes: %v", err)
+	}
+	if err := Verify(graph, []byte("graph"), priv.Public().(ed25519.PublicKey)); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Verify of other bytes: got %v, wanted ErrBadSignature", err)
+	}
+}
@@ -0,0 +1,66 @@
+package stepselection
+
+// StepsAffectedBy returns the names of the steps that depend on any of
+// changedFiles, sorted. A step depends on the files exactly as in
+// StepDependsOnFiles, but the graph is walked once for all the steps,
+// backwards from the changed files, instead of once per step.
+func (g *DependencyGraph) StepsAffectedBy(changedFiles []string) []string {
+	readers := map[string][]*step{}
+	writes := map[*step][]string{}
+	var listers []*step
+	for _, s := range g.steps {
+		for f := range s.readFiles {
+			readers[f] = append(readers[f], s)
+		}
+		if len(s.readDirs) > 0 {
+			listers = append(listers, s)
+		}
+	}
+	for f, writers := range g.fileWriters {
+		for _, s := range writers {
+			writes[s] = append(writes[s], f)
+		}
+	}
+
+	affected := map[string]bool{}
+	var queue []*step
+	mark := func(s *step) {
+		if !affected[s.name] {
+			affected[s.name] = true
+			queue = append(queue, s)
+		}
+	}
+	for _, f := range g.changedPaths(changedFiles) {
+		for _, s := range readers[f] {
+			mark(s)
+		}
+		for _, s := range listers {
+			for dir := range s.readDirs {
+				if inDir(f, dir) {
+					mark(s)
+					break
+				}
+			}
+		}
+	}
+	// Steps that read what an affected step writes are affected too.
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for _, f := range writes[s] {
+			for _, r := range readers[f] {
+				if produces(s, r, f) {
+					mark(r)
+				}
+			}
+		}
+	}
+	return sortedKeys(affected)
+}
+
+// HasStep reports whether cmdTree was recorded in the graph, or matches a
+// recorded step if fuzzy matching is enabled.
+func (g *DependencyGraph) HasStep(cmdTree CmdTree) bool {
+	_, _, ok := g.find(cmdTree)
+	return ok
+}
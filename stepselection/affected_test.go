@@ -0,0 +1,62 @@
+package stepselection
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStepsAffectedBy(t *testing.T) {
+	report := `{"CmdTree":["gen"],"Mode":"R","File":"/src/api.proto"}
+{"CmdTree":["gen"],"Mode":"W","File":"/out/api.go"}
+{"CmdTree":["build"],"Mode":"R","File":"/out/api.go"}
+{"CmdTree":["build"],"Mode":"R","File":"/src/main.go"}
+{"CmdTree":["build"],"Mode":"W","File":"/out/app"}
+{"CmdTree":["test"],"Mode":"R","File":"/out/app"}
+{"CmdTree":["docs"],"Mode":"R","File":"/src/docs","Type":"dir"}
+{"CmdTree":["lint"],"Mode":"R","File":"/src/main.go"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		changed []string
+		want    []string
+	}{
+		{[]string{"/src/api.proto"}, []string{`["build"]`, `["gen"]`, `["test"]`}},
+		{[]string{"/src/main.go"}, []string{`["build"]`, `["lint"]`, `["test"]`}},
+		{[]string{"/src/docs/new.md"}, []string{`["docs"]`}},
+		{[]string{"/src/other.go"}, []string{}},
+	} {
+		got := g.StepsAffectedBy(tc.changed)
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%v changed: (-want +got)\n%s", tc.changed, diff)
+		}
+		// The batch answer must agree with the per-step one.
+		for name := range g.steps {
+			var cmdTree CmdTree
+			if err := json.Unmarshal([]byte(name), &cmdTree); err != nil {
+				t.Fatal(err)
+			}
+			depends, _, err := g.StepDependsOnFiles(cmdTree, append([]string(nil), tc.changed...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if depends != contains(got, name) {
+				t.Errorf("%v changed: StepDependsOnFiles(%v) = %v, but StepsAffectedBy disagrees", tc.changed, name, depends)
+			}
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
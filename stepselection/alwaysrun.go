@@ -0,0 +1,55 @@
+package stepselection
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// AlwaysRunRule forces steps to run regardless of the graph, for steps whose
+// effects the graph can't capture, such as deploys or steps with network side
+// effects. A rule matches a step if Pattern matches the step's own command or
+// if the step has one of Tags. Reason explains the rule to the user.
+type AlwaysRunRule struct {
+	Pattern *regexp.Regexp
+	Tags    []string
+	Reason  string
+}
+
+// Matches reports whether the rule applies to cmdTree. t gives the step's
+// tags and may be nil.
+func (r *AlwaysRunRule) Matches(cmdTree CmdTree, t *Tagger) bool {
+	if len(cmdTree) == 0 {
+		return false
+	}
+	if r.Pattern != nil && r.Pattern.MatchString(cmdTree[len(cmdTree)-1]) {
+		return true
+	}
+	return len(r.Tags) > 0 && t.HasAnyTag(cmdTree, r.Tags)
+}
+
+func (r *AlwaysRunRule) String() string {
+	var parts []string
+	if r.Pattern != nil {
+		parts = append(parts, fmt.Sprintf("pattern %q", r.Pattern))
+	}
+	if len(r.Tags) > 0 {
+		parts = append(parts, fmt.Sprintf("tags %q", r.Tags))
+	}
+	s := "always-run rule with " + strings.Join(parts, " and ")
+	if r.Reason != "" {
+		s += ": " + r.Reason
+	}
+	return s
+}
+
+// MatchAlwaysRun returns the first rule in rules that applies to cmdTree, or
+// nil.
+func MatchAlwaysRun(rules []AlwaysRunRule, cmdTree CmdTree, t *Tagger) *AlwaysRunRule {
+	for i := range rules {
+		if rules[i].Matches(cmdTree, t) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package stepselection
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestMatchAlwaysRun(t *testing.T) {
+	tagger := &Tagger{Manifest: map[string][]string{"curl example.com": {"network"}}}
+	rules := []AlwaysRunRule{
+		{Pattern: regexp.MustCompile("^deploy"), Reason: "deploys have side effects"},
+		{Tags: []string{"network"}},
+	}
+	for _, tc := range []struct {
+		step CmdTree
+		want string
+	}{
+		{CmdTree{"make all", "deploy prod"}, `always-run rule with pattern "^deploy": deploys have side effects`},
+		{CmdTree{"curl example.com"}, `always-run rule with tags ["network"]`},
+		{CmdTree{"deploy prod", "go build"}, ""},
+		{nil, ""},
+	} {
+		got := ""
+		if r := MatchAlwaysRun(rules, tc.step, tagger); r != nil {
+			got = r.String()
+		}
+		if got != tc.want {
+			t.Errorf("%q: got %q wanted %q", tc.step, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package stepselection
+
+import (
+	"hash/fnv"
+	"path"
+)
+
+// bloomFilter is a Bloom filter over strings. It may report strings it
+// doesn't hold, but never misses the ones it does.
+type bloomFilter struct {
+	bits []uint64
+	k    uint64
+}
+
+// Ten bits and seven hashes per element give about 1% false positives.
+const (
+	bloomBitsPerElement = 10
+	bloomHashes         = 7
+)
+
+func newBloomFilter(n int) *bloomFilter {
+	words := (n*bloomBitsPerElement + 63) / 64
+	if words == 0 {
+		words = 1
+	}
+	return &bloomFilter{bits: make([]uint64, words), k: bloomHashes}
+}
+
+// hashes returns the two hashes the k bit positions of s are derived from,
+// see Kirsch and Mitzenmacher, "Less Hashing, Same Performance".
+func (b *bloomFilter) hashes(s string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	h1 := h.Sum64()
+	return h1, h1>>33 | h1<<31 | 1
+}
+
+func (b *bloomFilter) add(s string) {
+	h1, h2 := b.hashes(s)
+	n := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % n
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (b *bloomFilter) mayContain(s string) bool {
+	h1, h2 := b.hashes(s)
+	n := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % n
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// mayDependOn reports whether any step of the graph may depend on one of
+// the normalized changed paths, that is whether one of them, or one of
+// their directories, is a file or a listed directory of the graph. If it
+// returns false, no step depends on them and lookups don't need to walk the
+// graph, which is the common case for changes outside the build, like
+// documentation. The filter is built with the first lookup.
+func (g *DependencyGraph) mayDependOn(changed []string) bool {
+	g.mu.Lock()
+	if g.bloom == nil {
+		n := len(g.fileWriters)
+		for _, s := range g.steps {
+			n += len(s.readFiles) + len(s.readDirs)
+		}
+		g.bloom = newBloomFilter(n)
+		g.forEachFile(g.bloom.add)
+		for _, s := range g.steps {
+			for dir := range s.readDirs {
+				g.bloom.add(dir)
+			}
+		}
+	}
+	b := g.bloom
+	g.mu.Unlock()
+	for _, f := range changed {
+		for p := f; ; p = path.Dir(p) {
+			if b.mayContain(p) {
+				return true
+			}
+			if d := path.Dir(p); d == p || d == "." {
+				break
+			}
+		}
+	}
+	return false
+}
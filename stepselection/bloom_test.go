@@ -0,0 +1,59 @@
+package stepselection
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	b := newBloomFilter(1000)
+	for i := 0; i < 1000; i++ {
+		b.add(fmt.Sprintf("/src/file%d.c", i))
+	}
+	for i := 0; i < 1000; i++ {
+		if f := fmt.Sprintf("/src/file%d.c", i); !b.mayContain(f) {
+			t.Fatalf("%q was added but isn't in the filter", f)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		if b.mayContain(fmt.Sprintf("/docs/page%d.md", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 50 {
+		t.Errorf("got %d false positives out of 1000, want about 10", falsePositives)
+	}
+}
+
+func TestMayDependOn(t *testing.T) {
+	report := `{"CmdTree":["gen"],"Mode":"R","File":"/src/protos","Type":"dir"}
+{"CmdTree":["gen"],"Mode":"W","File":"/out/all.go"}
+{"CmdTree":["build"],"Mode":"R","File":"/out/all.go"}
+{"CmdTree":["build"],"Mode":"R","File":"/src/main.c"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		changed string
+		want    bool
+	}{
+		{"/src/main.c", true},
+		{"/src/protos/new.proto", true},
+		{"/docs/README.md", false},
+	} {
+		if got := g.mayDependOn([]string{tc.changed}); got != tc.want {
+			t.Errorf("mayDependOn(%q) = %v, want %v", tc.changed, got, tc.want)
+		}
+	}
+	run, _, err := g.StepDependsOnFiles(CmdTree{"build"}, []string{"/docs/README.md"})
+	if err != nil || run {
+		t.Errorf("StepDependsOnFiles(build, README.md) = %v, %v, want false, nil", run, err)
+	}
+	if _, _, err := g.StepDependsOnFiles(CmdTree{"unknown"}, []string{"/docs/README.md"}); err == nil {
+		t.Errorf("StepDependsOnFiles(unknown) succeeded, want an error")
+	}
+}
@@ -0,0 +1,104 @@
+package stepselection
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ChangeSet is a set of changed files. Files are normalized once, when they're
+// added, the same way as the files of the build report: made absolute and
+// with their symlinks resolved.
+type ChangeSet struct {
+	files map[string]bool
+}
+
+// NewChangeSet returns a ChangeSet with files.
+func NewChangeSet(files ...string) *ChangeSet {
+	c := &ChangeSet{files: map[string]bool{}}
+	for _, f := range files {
+		c.Add(f)
+	}
+	return c
+}
+
+// Add adds file to the set.
+func (c *ChangeSet) Add(file string) {
+	c.files[normalizePath(file)] = true
+}
+
+// Contains returns whether file is in the set.
+func (c *ChangeSet) Contains(file string) bool {
+	return c.files[normalizePath(file)]
+}
+
+// Len returns the number of files in the set.
+func (c *ChangeSet) Len() int {
+	return len(c.files)
+}
+
+// Files returns the normalized files of the set, sorted.
+func (c *ChangeSet) Files() []string {
+	files := make([]string, 0, len(c.files))
+	for f := range c.files {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// ChangeType is the kind of a Change.
+type ChangeType string
+
+const (
+	Added    ChangeType = "add"
+	Modified ChangeType = "modify"
+	Deleted  ChangeType = "delete"
+	Renamed  ChangeType = "rename"
+)
+
+// Change is a change to a file. It's the line format of JSON changes files:
+//
+//	{"Path":"/src/new.go","Type":"rename","OldPath":"/src/old.go"}
+//
+// Path can be a directory, in which case every file under it changed.
+type Change struct {
+	Path string
+	// Type is Modified if empty.
+	Type ChangeType `json:",omitempty"`
+	// OldPath is the previous path of renamed files.
+	OldPath string `json:",omitempty"`
+}
+
+// Paths returns the paths affected by the change: both paths of a rename,
+// since the steps that read the old path must run too, and the path of
+// other changes.
+func (ch Change) Paths() ([]string, error) {
+	if ch.Path == "" {
+		return nil, fmt.Errorf("change without a path: %+v", ch)
+	}
+	switch ch.Type {
+	case "", Added, Modified, Deleted:
+		if ch.OldPath != "" {
+			return nil, fmt.Errorf("%v: OldPath is only valid for renames", ch.Path)
+		}
+		return []string{ch.Path}, nil
+	case Renamed:
+		if ch.OldPath == "" {
+			return nil, fmt.Errorf("%v: rename without an OldPath", ch.Path)
+		}
+		return []string{ch.OldPath, ch.Path}, nil
+	}
+	return nil, fmt.Errorf("%v: unknown change type %q", ch.Path, ch.Type)
+}
+
+// AddChange adds the paths of ch to the set.
+func (c *ChangeSet) AddChange(ch Change) error {
+	paths, err := ch.Paths()
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		c.Add(p)
+	}
+	return nil
+}
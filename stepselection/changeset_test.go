@@ -0,0 +1,126 @@
+package stepselection
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExplain(t *testing.T) {
+	report := `{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["cc"],"Mode":"W","File":"/out/a.o"}
+{"CmdTree":["ld"],"Mode":"R","File":"/out/a.o"}
+{"CmdTree":["ld"],"Mode":"W","File":"/out/a"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := NewChangeSet("/src/a.c", "/src/../src/b.c")
+	if diff := cmp.Diff([]string{"/src/a.c", "/src/b.c"}, changes.Files()); diff != "" {
+		t.Errorf("Files (-want +got)\n%s", diff)
+	}
+	if !changes.Contains("/src/./b.c") {
+		t.Errorf("Contains(/src/./b.c) = false")
+	}
+
+	e, err := g.Explain(CmdTree{"ld"}, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Explanation{
+		Step:   `["ld"]`,
+		Run:    true,
+		Reason: `step "[\"ld\"]" has a dependency that uses "/src/a.c"`,
+		Chains: []Chain{{
+			{File: "/src/a.c", Step: `["cc"]`},
+			{File: "/out/a.o", Step: `["ld"]`},
+		}},
+	}
+	if diff := cmp.Diff(want, e); diff != "" {
+		t.Errorf("Explain (-want +got)\n%s", diff)
+	}
+
+	e, err = g.Explain(CmdTree{"ld"}, NewChangeSet("/src/c.c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Run || len(e.Chains) > 0 {
+		t.Errorf("Explain with unrelated changes = %+v, wanted a skip", e)
+	}
+}
+
+func ExampleDependencyGraph_Explain() {
+	report := strings.NewReader(`{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["cc"],"Mode":"W","File":"/out/a.o"}
+{"CmdTree":["ld"],"Mode":"R","File":"/out/a.o"}
+`)
+	g, err := NewDependencyGraph(report)
+	if err != nil {
+		panic(err)
+	}
+	e, err := g.Explain(CmdTree{"ld"}, NewChangeSet("/src/a.c"))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(e.Run)
+	for _, c := range e.Chains {
+		fmt.Println(c)
+	}
+	// Output:
+	// true
+	// /src/a.c -> read by ["cc"], which writes /out/a.o -> read by ["ld"]
+}
+
+func TestChangeTypes(t *testing.T) {
+	report := `{"CmdTree":["cc"],"Mode":"R","File":"/src/pkg/a.c"}
+{"CmdTree":["cc"],"Mode":"W","File":"/out/a.o"}
+{"CmdTree":["ld"],"Mode":"R","File":"/out/a.o"}
+{"CmdTree":["doc"],"Mode":"R","File":"/doc/old.md"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		change Change
+		want   []string
+	}{
+		// Deleting a directory deletes the files in it.
+		{Change{Path: "/src/pkg", Type: Deleted}, []string{`["cc"]`, `["ld"]`}},
+		{Change{Path: "/src", Type: Deleted}, []string{`["cc"]`, `["ld"]`}},
+		{Change{Path: "/src/pk", Type: Deleted}, []string{}},
+		// Renaming a file changes both paths.
+		{Change{Path: "/doc/new.md", Type: Renamed, OldPath: "/doc/old.md"}, []string{`["doc"]`}},
+		{Change{Path: "/doc/new.md", Type: Added}, []string{}},
+	} {
+		c := NewChangeSet()
+		if err := c.AddChange(tc.change); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(tc.want, g.StepsAffectedBy(c.Files())); diff != "" {
+			t.Errorf("%+v: StepsAffectedBy (-want +got)\n%s", tc.change, diff)
+		}
+		for _, step := range []string{"cc", "ld", "doc"} {
+			e, err := g.Explain(CmdTree{step}, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := false
+			for _, w := range tc.want {
+				want = want || w == e.Step
+			}
+			if e.Run != want {
+				t.Errorf("%+v: step %v runs: got %v wanted %v", tc.change, step, e.Run, want)
+			}
+			if e.Run && len(e.Chains) == 0 {
+				t.Errorf("%+v: step %v runs without a chain", tc.change, step)
+			}
+		}
+	}
+	if err := NewChangeSet().AddChange(Change{Path: "/a", Type: "move"}); err == nil {
+		t.Errorf("AddChange with an unknown type succeeded")
+	}
+}
@@ -0,0 +1,311 @@
+package stepselection
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// compiledGraphVersion is bumped whenever the compiled format changes, so
+// that old files are recompiled instead of misread.
+const compiledGraphVersion = 9
+
+// ErrStaleCompiledGraph is returned by LoadCompiledGraph when the compiled
+// graph wasn't built from the given source, or with the same options.
+var ErrStaleCompiledGraph = errors.New("compiled graph is out of date")
+
+// CompiledSource identifies the build report a compiled graph was built from.
+type CompiledSource struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// compiledHeader is written before the graph, so that stale graphs are
+// detected without decoding them.
+type compiledHeader struct {
+	Version int
+	Source  CompiledSource
+	Options string
+}
+
+// compiledGraph is a DependencyGraph with its file names interned. Steps
+// and files are referred to by their index.
+type compiledGraph struct {
+	Files   []string
+	Steps   []compiledStep
+	Writers [][]int32
+}
+
+type compiledStep struct {
+	Name          string
+	Build         string
+	Reads         []int32
+	ReadDirs      []int32
+	OverlayReads  map[int32]string
+	OverlayWrites map[int32]string
+	Duration      time.Duration
+	Network       []string
+	Env           []string
+	Tools         []string
+	ReadTimes     map[int32]compiledStamp
+	WriteTimes    map[int32]compiledStamp
+}
+
+// compiledStamp is a stamp, with exported fields for gob.
+type compiledStamp struct {
+	Build string
+	At    int64
+}
+
+// CompileGraph builds the graph of buildReport and writes it to w in a
+// binary format that LoadCompiledGraph loads much faster than the report.
+// src is recorded so that LoadCompiledGraph can tell whether the report
+// changed since.
+func CompileGraph(w io.Writer, buildReport io.Reader, src CompiledSource, opts ...Option) error {
+	g, err := NewDependencyGraph(buildReport, opts...)
+	if err != nil {
+		return err
+	}
+	h := &compiledHeader{Version: compiledGraphVersion, Source: src, Options: optionsFingerprint(opts)}
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(h); err != nil {
+		return err
+	}
+	return enc.Encode(g.compile())
+}
+
+// LoadCompiledGraph reads a graph written by CompileGraph. It returns
+// ErrStaleCompiledGraph unless the graph was compiled from src with the same
+// options. A zero src matches any source.
+func LoadCompiledGraph(r io.Reader, src CompiledSource, opts ...Option) (*DependencyGraph, error) {
+	start := time.Now()
+	dec := gob.NewDecoder(r)
+	h := &compiledHeader{}
+	if err := dec.Decode(h); err != nil {
+		return nil, fmt.Errorf("invalid compiled graph: %v", err)
+	}
+	if h.Version > compiledGraphVersion {
+		return nil, fmt.Errorf("%w: the compiled graph has version %d, but this version of skipper reads versions up to %d", ErrUnsupportedVersion, h.Version, compiledGraphVersion)
+	}
+	if h.Version != compiledGraphVersion || h.Options != optionsFingerprint(opts) {
+		return nil, ErrStaleCompiledGraph
+	}
+	if src != (CompiledSource{}) && (h.Source.Size != src.Size || !h.Source.ModTime.Equal(src.ModTime)) {
+		return nil, ErrStaleCompiledGraph
+	}
+	c := &compiledGraph{}
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("invalid compiled graph: %v", err)
+	}
+	g, err := c.graph()
+	if err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+	g.normalizer, g.hermetic = o.normalizer, o.hermetic
+	logger.Info("dep graph loaded", "duration", time.Since(start))
+	return g, nil
+}
+
+func (g *DependencyGraph) compile() *compiledGraph {
+	c := &compiledGraph{}
+	fileIDs := map[string]int32{}
+	intern := func(f string) int32 {
+		id, ok := fileIDs[f]
+		if !ok {
+			id = int32(len(c.Files))
+			fileIDs[f] = id
+			c.Files = append(c.Files, f)
+		}
+		return id
+	}
+	stepIDs := map[string]int32{}
+	names := make([]string, 0, len(g.steps))
+	for name := range g.steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		s := g.steps[name]
+		stepIDs[name] = int32(i)
+		cs := compiledStep{Name: name, Build: s.build, Duration: s.duration, Network: sortedKeys(s.network), Env: s.environment(), Tools: s.toolchains()}
+		for _, f := range sortedKeys(s.readFiles) {
+			cs.Reads = append(cs.Reads, intern(f))
+		}
+		for _, d := range sortedKeys(s.readDirs) {
+			cs.ReadDirs = append(cs.ReadDirs, intern(d))
+		}
+		if s.overlayReads != nil {
+			cs.OverlayReads = map[int32]string{}
+			for f, o := range s.overlayReads {
+				cs.OverlayReads[intern(f)] = o
+			}
+		}
+		if s.overlayWrites != nil {
+			cs.OverlayWrites = map[int32]string{}
+			for f, o := range s.overlayWrites {
+				cs.OverlayWrites[intern(f)] = o
+			}
+		}
+		cs.ReadTimes = compileStamps(s.readTimes, intern)
+		cs.WriteTimes = compileStamps(s.writeTimes, intern)
+		c.Steps = append(c.Steps, cs)
+	}
+	files := make([]string, 0, len(g.fileWriters))
+	for f := range g.fileWriters {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		id := intern(f)
+		for int(id) >= len(c.Writers) {
+			c.Writers = append(c.Writers, nil)
+		}
+		for _, s := range g.fileWriters[f] {
+			c.Writers[id] = append(c.Writers[id], stepIDs[s.name])
+		}
+	}
+	return c
+}
+
+func (c *compiledGraph) graph() (*DependencyGraph, error) {
+	g := &DependencyGraph{
+		steps:       make(map[string]*step, len(c.Steps)),
+		fileWriters: make(map[string][]*step, len(c.Writers)),
+	}
+	file := func(id int32) (string, error) {
+		if id < 0 || int(id) >= len(c.Files) {
+			return "", fmt.Errorf("invalid compiled graph: unknown file %d", id)
+		}
+		return c.Files[id], nil
+	}
+	steps := make([]*step, len(c.Steps))
+	for i, cs := range c.Steps {
+		s := &step{name: cs.Name, build: cs.Build, duration: cs.Duration, readFiles: make(map[string]bool, len(cs.Reads))}
+		for _, addr := range cs.Network {
+			if s.network == nil {
+				s.network = map[string]bool{}
+			}
+			s.network[addr] = true
+		}
+		for _, e := range cs.Env {
+			if s.env == nil {
+				s.env = map[string]string{}
+			}
+			s.env[envName(e)] = e
+		}
+		for _, t := range cs.Tools {
+			if s.tools == nil {
+				s.tools = map[string]string{}
+			}
+			path, fingerprint := splitToolEntry(t)
+			s.tools[path] = fingerprint
+		}
+		for _, id := range cs.Reads {
+			f, err := file(id)
+			if err != nil {
+				return nil, err
+			}
+			s.readFiles[f] = true
+		}
+		for _, id := range cs.ReadDirs {
+			d, err := file(id)
+			if err != nil {
+				return nil, err
+			}
+			if s.readDirs == nil {
+				s.readDirs = map[string]bool{}
+			}
+			s.readDirs[d] = true
+		}
+		for id, o := range cs.OverlayReads {
+			f, err := file(id)
+			if err != nil {
+				return nil, err
+			}
+			if s.overlayReads == nil {
+				s.overlayReads = map[string]string{}
+			}
+			s.overlayReads[f] = o
+		}
+		for id, o := range cs.OverlayWrites {
+			f, err := file(id)
+			if err != nil {
+				return nil, err
+			}
+			if s.overlayWrites == nil {
+				s.overlayWrites = map[string]string{}
+			}
+			s.overlayWrites[f] = o
+		}
+		var err error
+		if s.readTimes, err = c.stamps(cs.ReadTimes); err != nil {
+			return nil, err
+		}
+		if s.writeTimes, err = c.stamps(cs.WriteTimes); err != nil {
+			return nil, err
+		}
+		steps[i] = s
+		g.steps[cs.Name] = s
+	}
+	for id, writers := range c.Writers {
+		for _, sid := range writers {
+			if sid < 0 || int(sid) >= len(steps) {
+				return nil, fmt.Errorf("invalid compiled graph: unknown step %d", sid)
+			}
+			g.fileWriters[c.Files[id]] = append(g.fileWriters[c.Files[id]], steps[sid])
+		}
+	}
+	return g, nil
+}
+
+func compileStamps(times map[string]stamp, intern func(string) int32) map[int32]compiledStamp {
+	if times == nil {
+		return nil
+	}
+	c := make(map[int32]compiledStamp, len(times))
+	for f, at := range times {
+		c[intern(f)] = compiledStamp{Build: at.build, At: at.at}
+	}
+	return c
+}
+
+// stamps returns the times of a step, by file.
+func (c *compiledGraph) stamps(times map[int32]compiledStamp) (map[string]stamp, error) {
+	if times == nil {
+		return nil, nil
+	}
+	m := make(map[string]stamp, len(times))
+	for id, at := range times {
+		if id < 0 || int(id) >= len(c.Files) {
+			return nil, fmt.Errorf("invalid compiled graph: unknown file %d", id)
+		}
+		m[c.Files[id]] = stamp{build: at.Build, at: at.At}
+	}
+	return m, nil
+}
+
+// optionsFingerprint identifies the overlays, ignored and temporary files,
+// name rules and root mapping in opts, so that a graph compiled with
+// different options isn't used. Hermetic files are left out of lookups, not
+// of the graph, so they don't matter.
+func optionsFingerprint(opts []Option) string {
+	o := newOptions(opts)
+	b, err := json.Marshal(struct {
+		Overlays []*Overlay
+		Ignore   string
+		Temp     string `json:",omitempty"`
+		Names    string `json:",omitempty"`
+		Roots    string `json:",omitempty"`
+		Steps    string `json:",omitempty"`
+	}{o.overlays, o.ignore.String(), o.temp.String(), o.normalizer.String(), o.roots.String(), overridesFingerprint(o.overrides)})
+	if err != nil {
+		return "?"
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
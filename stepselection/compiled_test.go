@@ -0,0 +1,60 @@
+package stepselection
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompiledGraph(t *testing.T) {
+	report := `{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["cc"],"Mode":"W","File":"/out/a.o"}
+{"CmdTree":["link"],"Mode":"R","File":"/out/a.o"}
+{"CmdTree":["link"],"Mode":"W","File":"/out/a"}
+`
+	overlay := WithOverlay(&Overlay{Source: "fixes.yaml", Add: []OverlayEdit{{Step: CmdTree{"link"}, Reads: []string{"/src/link.ld"}}}})
+	src := CompiledSource{Size: int64(len(report)), ModTime: time.Unix(1500000000, 0)}
+	buf := new(bytes.Buffer)
+	if err := CompileGraph(buf, strings.NewReader(report), src, overlay); err != nil {
+		t.Fatal(err)
+	}
+	compiled := buf.Bytes()
+
+	g, err := LoadCompiledGraph(bytes.NewReader(compiled), src, overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewDependencyGraph(strings.NewReader(report), overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotDOT, wantDOT bytes.Buffer
+	g.WriteDOT(&gotDOT)
+	want.WriteDOT(&wantDOT)
+	if gotDOT.String() != wantDOT.String() {
+		t.Errorf("got graph\n%v\nwanted\n%v", gotDOT.String(), wantDOT.String())
+	}
+	_, reason, err := g.StepDependsOnFiles(CmdTree{"link"}, []string{"/src/link.ld"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wantReason := `step "[\"link\"]" reads file "/src/link.ld" which is being updated (edge added by overlay fixes.yaml)`; reason != wantReason {
+		t.Errorf("got %q wanted %q", reason, wantReason)
+	}
+
+	if _, err := LoadCompiledGraph(bytes.NewReader(compiled), CompiledSource{}, overlay); err != nil {
+		t.Errorf("a zero source should match: %v", err)
+	}
+	for name, tc := range map[string]struct {
+		src  CompiledSource
+		opts []Option
+	}{
+		"changed report":   {CompiledSource{Size: src.Size + 1, ModTime: src.ModTime}, []Option{overlay}},
+		"changed overlays": {src, nil},
+	} {
+		if _, err := LoadCompiledGraph(bytes.NewReader(compiled), tc.src, tc.opts...); err != ErrStaleCompiledGraph {
+			t.Errorf("%v: got %v wanted %v", name, err, ErrStaleCompiledGraph)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package stepselection
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// csvColumns are the columns of the records of CSV build reports, which may
+// start with them as a header.
+var csvColumns = []string{"StepName", "FilePath", "Mode"}
+
+// ConvertCSVReport writes the build report of the table r to enc, after a
+// header. Each record of r is a StepName,FilePath,Mode triple, separated by
+// comma, where Mode is R if the step reads the file and W if it writes it,
+// or X and L if it executes or loads it, see BuildLog.
+// The step name is a command, or the JSON array of a CmdTree for nested
+// steps. Lines starting with # are comments. Tables are meant for graphs
+// written by hand or by scripts, for tests and for bootstrapping.
+func ConvertCSVReport(enc Encoder, r io.Reader, comma rune) error {
+	cr := csv.NewReader(r)
+	cr.Comma = comma
+	cr.Comment = '#'
+	cr.FieldsPerRecord = len(csvColumns)
+	cr.TrimLeadingSpace = true
+	// Tab-separated values don't quote their fields.
+	cr.LazyQuotes = comma == '\t'
+	if err := WriteReportHeader(enc); err != nil {
+		return err
+	}
+	for first := true; ; first = false {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid build report: %w", err)
+		}
+		if first && isCSVHeader(record) {
+			continue
+		}
+		bog, err := csvEntry(record)
+		if err != nil {
+			line, _ := cr.FieldPos(0)
+			return fmt.Errorf("invalid build report: line %d: %w", line, err)
+		}
+		if err := enc.Encode(bog); err != nil {
+			return err
+		}
+	}
+}
+
+func isCSVHeader(record []string) bool {
+	for i, c := range csvColumns {
+		if !strings.EqualFold(strings.TrimSpace(record[i]), c) {
+			return false
+		}
+	}
+	return true
+}
+
+// csvEntry returns the entry of a record of a CSV build report.
+func csvEntry(record []string) (*BuildLog, error) {
+	step := strings.TrimSpace(record[0])
+	bog := &BuildLog{
+		CmdTree: []string{step},
+		File:    strings.TrimSpace(record[1]),
+		Mode:    strings.ToUpper(strings.TrimSpace(record[2])),
+	}
+	if strings.HasPrefix(step, "[") {
+		bog.CmdTree = nil
+		if err := json.Unmarshal([]byte(step), &bog.CmdTree); err != nil {
+			return nil, fmt.Errorf("invalid step %s: %v", step, err)
+		}
+	}
+	if step == "" {
+		return nil, errors.New("entry without a step")
+	}
+	return bog, bog.validate()
+}
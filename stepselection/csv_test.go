@@ -0,0 +1,50 @@
+package stepselection
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestConvertCSVReport(t *testing.T) {
+	table := `StepName,FilePath,Mode
+# The link step reads what the compile step writes.
+ld, /out/a, W
+ld, /out/a.o, R
+"cc -c a.c",/out/a.o,w
+"[""make"",""cc -c a.c""]",/src/a.c,R
+`
+	report := new(bytes.Buffer)
+	if err := ConvertCSVReport(json.NewEncoder(report), strings.NewReader(table), ','); err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewDependencyGraph(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps, _, err := g.StepDependsOnFiles(CmdTree{"ld"}, []string{"/src/a.c"})
+	if err != nil || deps {
+		t.Errorf("got %v, %v, wanted ld not to depend on /src/a.c, read by another step", deps, err)
+	}
+	deps, _, err = g.StepDependsOnFiles(CmdTree{"make", "cc -c a.c"}, []string{"/src/a.c"})
+	if err != nil || !deps {
+		t.Errorf("got %v, %v, wanted the nested step to depend on /src/a.c", deps, err)
+	}
+
+	tsv := "ld\t/out/a.o\tR\ncc \"quoted\"\t/out/a.o\tW\n"
+	report.Reset()
+	if err := ConvertCSVReport(json.NewEncoder(report), strings.NewReader(tsv), '\t'); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report.String(), `"cc \"quoted\""`) {
+		t.Errorf("got report %s, wanted the quotes of the TSV step kept", report)
+	}
+
+	for _, bad := range []string{"ld,/out/a\n", "ld,/out/a,Q\n", ",/out/a,R\n", "[,/out/a,R\n"} {
+		err := ConvertCSVReport(json.NewEncoder(new(bytes.Buffer)), strings.NewReader("# comment\n"+bad), ',')
+		if err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("converting %q: got %v, wanted an error on line 2", bad, err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package stepselection
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Cycle is a loop of steps: each link's Step writes the File of the next
+// link, and the last link's Step writes the File of the first one.
+type Cycle []ChainLink
+
+func (c Cycle) String() string {
+	if len(c) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%v, which writes %v", Chain(c), c[0].File)
+}
+
+// maxReportedCycles limits how many cycles are reported when loading a graph.
+const maxReportedCycles = 10
+
+// reportCycles warns about the cycles in g. Cycles don't break lookups, but
+// they usually mean the build report is wrong, or that a step modifies its
+// own inputs and will always depend on itself.
+func (g *DependencyGraph) reportCycles() {
+	cycles := g.Cycles()
+	if len(cycles) == 0 {
+		return
+	}
+	logger.Warn("the dependency graph has cycles", "count", len(cycles))
+	for i, c := range cycles {
+		if i == maxReportedCycles {
+			break
+		}
+		logger.Warn("dependency cycle", "cycle", c.String())
+	}
+}
+
+// Cycles returns a cycle for each group of steps that depend on each other,
+// that is each strongly connected component of the step graph. A step
+// depends on another step if it reads a file the other step writes.
+//
+// Steps that read their own outputs, and ancestors that inherit the files of
+// their descendants, aren't considered cycles: they're common and harmless.
+func (g *DependencyGraph) Cycles() []Cycle {
+	successors := g.stepSuccessors()
+
+	// Tarjan's strongly connected components algorithm.
+	index := map[*step]int{}
+	lowlink := map[*step]int{}
+	onStack := map[*step]bool{}
+	var stack []*step
+	var components [][]*step
+	var visit func(s *step)
+	visit = func(s *step) {
+		index[s] = len(index)
+		lowlink[s] = index[s]
+		stack = append(stack, s)
+		onStack[s] = true
+		successors(s, func(_ string, t *step) {
+			if _, ok := index[t]; !ok {
+				visit(t)
+				if lowlink[t] < lowlink[s] {
+					lowlink[s] = lowlink[t]
+				}
+			} else if onStack[t] && index[t] < lowlink[s] {
+				lowlink[s] = index[t]
+			}
+		})
+		if lowlink[s] != index[s] {
+			return
+		}
+		var component []*step
+		for {
+			t := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[t] = false
+			component = append(component, t)
+			if t == s {
+				break
+			}
+		}
+		if len(component) > 1 {
+			components = append(components, component)
+		}
+	}
+	names := make([]string, 0, len(g.steps))
+	for name := range g.steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := index[g.steps[name]]; !ok {
+			visit(g.steps[name])
+		}
+	}
+
+	var cycles []Cycle
+	for _, component := range components {
+		cycles = append(cycles, g.shortestCycle(component, successors))
+	}
+	return cycles
+}
+
+// stepSuccessors returns a function calling f for each step t that depends on
+// s through file, in a stable order. Steps don't depend on themselves, nor on
+// their ancestors and descendants.
+func (g *DependencyGraph) stepSuccessors() func(s *step, f func(file string, t *step)) {
+	readers := map[string][]*step{}
+	for _, s := range g.steps {
+		for f := range s.readFiles {
+			readers[f] = append(readers[f], s)
+		}
+	}
+	writes := map[*step][]string{}
+	for f, writers := range g.fileWriters {
+		for _, s := range writers {
+			writes[s] = append(writes[s], f)
+		}
+	}
+	// Sort the edges so that the same cycles and chains are reported every
+	// time.
+	for _, r := range readers {
+		sort.Slice(r, func(i, j int) bool { return r[i].name < r[j].name })
+	}
+	for _, w := range writes {
+		sort.Strings(w)
+	}
+	return func(s *step, f func(file string, t *step)) {
+		for _, file := range writes[s] {
+			for _, t := range readers[file] {
+				if t != s && !related(s.name, t.name) {
+					f(file, t)
+				}
+			}
+		}
+	}
+}
+
+// shortestCycle finds the shortest cycle through the first step of
+// component, in alphabetical order, staying inside component.
+func (g *DependencyGraph) shortestCycle(component []*step, successors func(*step, func(string, *step))) Cycle {
+	in := map[*step]bool{}
+	start := component[0]
+	for _, s := range component {
+		in[s] = true
+		if s.name < start.name {
+			start = s
+		}
+	}
+	type edge struct {
+		from *step
+		file string
+	}
+	parent := map[*step]edge{}
+	queue := []*step{start}
+	var closing *edge
+	for len(queue) > 0 && closing == nil {
+		s := queue[0]
+		queue = queue[1:]
+		successors(s, func(file string, t *step) {
+			if closing != nil || !in[t] {
+				return
+			}
+			if t == start {
+				closing = &edge{s, file}
+				return
+			}
+			if _, seen := parent[t]; !seen {
+				parent[t] = edge{s, file}
+				queue = append(queue, t)
+			}
+		})
+	}
+	if closing == nil {
+		// Can't happen in a strongly connected component.
+		return nil
+	}
+	c := Cycle{{File: closing.file, Step: start.name, Overlay: start.overlayReads[closing.file]}}
+	for s := closing.from; s != start; s = parent[s].from {
+		e := parent[s]
+		c = append(Cycle{{File: e.file, Step: s.name, Overlay: s.overlayReads[e.file]}}, c...)
+	}
+	return c
+}
+
+// related reports whether the steps called a and b are ancestor and
+// descendant. Step names are JSON arrays, so an ancestor's name is a prefix
+// of its descendants' names without the closing bracket.
+func related(a, b string) bool {
+	return strings.HasPrefix(b, strings.TrimSuffix(a, "]")+",") ||
+		strings.HasPrefix(a, strings.TrimSuffix(b, "]")+",")
+}
@@ -0,0 +1,56 @@
+package stepselection
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCycles(t *testing.T) {
+	report := `{"CmdTree":["gen"],"Mode":"R","File":"/src/b.h"}
+{"CmdTree":["gen"],"Mode":"W","File":"/src/a.h"}
+{"CmdTree":["fix"],"Mode":"R","File":"/src/a.h"}
+{"CmdTree":["fix"],"Mode":"W","File":"/src/b.h"}
+{"CmdTree":["fmt"],"Mode":"R","File":"/src/c.go"}
+{"CmdTree":["fmt"],"Mode":"W","File":"/src/c.go"}
+{"CmdTree":["make","cc"],"Mode":"R","File":"/src/d.c"}
+{"CmdTree":["make","cc"],"Mode":"W","File":"/out/d.o"}
+{"CmdTree":["make","link"],"Mode":"R","File":"/out/d.o"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cycles := g.Cycles()
+	if len(cycles) != 1 {
+		t.Fatalf("got cycles %q, wanted one", cycles)
+	}
+	want := `/src/b.h -> read by ["gen"], which writes /src/a.h -> read by ["fix"], which writes /src/b.h`
+	if got := cycles[0].String(); got != want {
+		t.Errorf("got %q wanted %q", got, want)
+	}
+
+	// Lookups terminate and still find dependencies through the cycle.
+	depends, _, err := g.StepDependsOnFiles(CmdTree{"fix"}, []string{"/src/b.h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !depends {
+		t.Errorf("expected fix to depend on its own input through gen")
+	}
+}
+
+func TestRelated(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want bool
+	}{
+		{`["make"]`, `["make","cc"]`, true},
+		{`["make","cc"]`, `["make"]`, true},
+		{`["make"]`, `["maker","cc"]`, false},
+		{`["make","cc"]`, `["make","link"]`, false},
+	} {
+		if got := related(tc.a, tc.b); got != tc.want {
+			t.Errorf("related(%v, %v): got %v wanted %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
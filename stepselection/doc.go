@@ -0,0 +1,25 @@
+// Package stepselection decides which build steps should be run for a build.
+//
+// A DependencyGraph is built from a build report, which lists the files each
+// step of a previous build read and wrote, one BuildLog per line. Steps are
+// identified by their CmdTree: the commands of the step and of the steps it's
+// nested in. A step must run if it reads a changed file, or if it reads a file
+// written by a step that must run:
+//
+//	g, err := stepselection.NewDependencyGraph(report)
+//	if err != nil {
+//		return err
+//	}
+//	e, err := g.Explain(stepselection.CmdTree{"make", "go test ./..."}, stepselection.NewChangeSet("main.go"))
+//	if err != nil {
+//		return err
+//	}
+//	if e.Run {
+//		fmt.Println(e.Chains)
+//	}
+//
+// NewDependencyGraph, its Options, ChangeSet, Explain and the BuildLog format
+// are stable: they only change in backwards compatible ways. The package
+// doesn't write to stdout or stderr; it logs to the logger given to
+// SetLogger, if any.
+package stepselection
@@ -0,0 +1,63 @@
+package stepselection
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// WriteDOT writes the graph in Graphviz DOT format. Steps are boxes and files
+// are ellipses. An edge from a file to a step means the step reads the file,
+// and an edge from a step to a file means the step writes it. Dashed edges
+// come from directories the step lists. The output is
+// sorted so that it's stable for a given graph.
+func (g *DependencyGraph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph skipper {")
+	fmt.Fprintln(bw, "\tnode [shape=ellipse];")
+
+	var stepNames []string
+	for name := range g.steps {
+		stepNames = append(stepNames, name)
+	}
+	sort.Strings(stepNames)
+	for _, name := range stepNames {
+		fmt.Fprintf(bw, "\t%v [shape=box];\n", strconv.Quote(name))
+	}
+	for _, name := range stepNames {
+		for _, f := range sortedKeys(g.steps[name].readFiles) {
+			fmt.Fprintf(bw, "\t%v -> %v;\n", strconv.Quote(f), strconv.Quote(name))
+		}
+		for _, d := range sortedKeys(g.steps[name].readDirs) {
+			fmt.Fprintf(bw, "\t%v -> %v [style=dashed];\n", strconv.Quote(d), strconv.Quote(name))
+		}
+	}
+
+	var files []string
+	for f := range g.fileWriters {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		writers := map[string]bool{}
+		for _, s := range g.fileWriters[f] {
+			writers[s.name] = true
+		}
+		for _, name := range sortedKeys(writers) {
+			fmt.Fprintf(bw, "\t%v -> %v;\n", strconv.Quote(name), strconv.Quote(f))
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
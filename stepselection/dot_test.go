@@ -0,0 +1,32 @@
+package stepselection
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteDOT(t *testing.T) {
+	report := `{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["cc"],"Mode":"W","File":"/out/a.o"}
+{"CmdTree":["cc"],"Mode":"W","File":"/out/a.o"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := new(bytes.Buffer)
+	if err := g.WriteDOT(got); err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph skipper {
+	node [shape=ellipse];
+	"[\"cc\"]" [shape=box];
+	"/src/a.c" -> "[\"cc\"]";
+	"[\"cc\"]" -> "/out/a.o";
+}
+`
+	if got.String() != want {
+		t.Errorf("got %q wanted %q", got.String(), want)
+	}
+}
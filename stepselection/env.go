@@ -0,0 +1,122 @@
+package stepselection
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EnvAllowlist lists the environment variables recorded with each step,
+// because they change what steps do, like CC or GOFLAGS. Names ending in *
+// match every variable with that prefix.
+type EnvAllowlist []string
+
+// DefaultEnvAllowlist holds the variables of common toolchains.
+var DefaultEnvAllowlist = EnvAllowlist{
+	"CC", "CXX", "CPPFLAGS", "CFLAGS", "CXXFLAGS", "LDFLAGS",
+	"GOFLAGS", "GOOS", "GOARCH", "CGO_ENABLED", "GOEXPERIMENT",
+	"RUSTFLAGS", "CARGO_BUILD_TARGET",
+	"NODE_ENV", "NODE_OPTIONS",
+	"JAVA_HOME", "PYTHONPATH",
+}
+
+// Match reports whether the variable name is in the allowlist.
+func (l EnvAllowlist) Match(name string) bool {
+	for _, p := range l {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok && strings.HasPrefix(name, prefix) || p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Snapshot returns the entries of the variables of environ, a list of
+// NAME=value like os.Environ returns, that are in the allowlist, sorted.
+// The exact names of the allowlist that aren't set are included too, as
+// NAME, so that setting them later counts as a change.
+func (l EnvAllowlist) Snapshot(environ []string) []string {
+	set := map[string]bool{}
+	var entries []string
+	for _, kv := range environ {
+		name, _, ok := strings.Cut(kv, "=")
+		if !ok || !l.Match(name) || set[name] {
+			continue
+		}
+		set[name] = true
+		entries = append(entries, kv)
+	}
+	for _, p := range l {
+		if !strings.HasSuffix(p, "*") && !set[p] {
+			set[p] = true
+			entries = append(entries, p)
+		}
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// envName returns the name of the variable of an entry of type "env".
+func envName(entry string) string {
+	name, _, _ := strings.Cut(entry, "=")
+	return name
+}
+
+// environment returns the entries of the environment of s, sorted, or nil
+// if it wasn't recorded.
+func (s *step) environment() []string {
+	if len(s.env) == 0 {
+		return nil
+	}
+	entries := make([]string, 0, len(s.env))
+	for _, e := range s.env {
+		entries = append(entries, e)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// envGraph is implemented by the graphs that record the environment of
+// steps.
+type envGraph interface {
+	StepEnvironment(cmdTree CmdTree) []string
+}
+
+// EnvironmentChanges returns the variables of the allowlist whose value in
+// env, a list of NAME=value, differs from the base build, sorted. It
+// returns nil if they're all the same, or if g doesn't know the
+// environment cmdTree ran with.
+func EnvironmentChanges(g Graph, cmdTree CmdTree, l EnvAllowlist, env []string) []string {
+	eg, ok := g.(envGraph)
+	if !ok {
+		return nil
+	}
+	recorded := eg.StepEnvironment(cmdTree)
+	if len(recorded) == 0 {
+		return nil
+	}
+	current := map[string]string{}
+	for _, kv := range env {
+		current[envName(kv)] = kv
+	}
+	var changed []string
+	for _, entry := range recorded {
+		name := envName(entry)
+		if !l.Match(name) {
+			continue
+		}
+		now, ok := current[name]
+		if !ok {
+			now = name
+		}
+		if now != entry {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// EnvironmentReason explains why cmdTree runs because the variables names
+// changed.
+func EnvironmentReason(cmdTree CmdTree, names []string) string {
+	return fmt.Sprintf("step %q runs with a different %v than in the base build", cmdTree.Name(), strings.Join(names, ", "))
+}
@@ -0,0 +1,90 @@
+package stepselection
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const envReport = `{"CmdTree":["make"],"Mode":"R","File":"/src/Makefile"}
+{"CmdTree":["make"],"Type":"env","File":"CC=gcc"}
+{"CmdTree":["make","cc a.c"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["make","cc a.c"],"Type":"env","File":"CC=clang"}
+{"CmdTree":["make","cc a.c"],"Type":"env","File":"GOFLAGS"}
+`
+
+func TestEnvAllowlist(t *testing.T) {
+	l := EnvAllowlist{"CC", "MYAPP_*", "GOFLAGS"}
+	for name, want := range map[string]bool{"CC": true, "MYAPP_MODE": true, "CCC": false, "PATH": false} {
+		if got := l.Match(name); got != want {
+			t.Errorf("Match(%q) = %v, want %v", name, got, want)
+		}
+	}
+	got := l.Snapshot([]string{"PATH=/bin", "MYAPP_MODE=dev", "CC=gcc", "CC=ignored"})
+	want := []string{"CC=gcc", "GOFLAGS", "MYAPP_MODE=dev"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected snapshot, diff: %v", diff)
+	}
+}
+
+func TestEnvironmentChanges(t *testing.T) {
+	g, err := NewDependencyGraph(strings.NewReader(envReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := CmdTree{"make", "cc a.c"}
+	for _, tc := range []struct {
+		name string
+		step CmdTree
+		l    EnvAllowlist
+		env  []string
+		want []string
+	}{
+		{"same", cc, DefaultEnvAllowlist, []string{"CC=clang", "PATH=/bin"}, nil},
+		{"changed", cc, DefaultEnvAllowlist, []string{"CC=gcc"}, []string{"CC"}},
+		{"set", cc, DefaultEnvAllowlist, []string{"CC=clang", "GOFLAGS=-race"}, []string{"GOFLAGS"}},
+		{"unset", cc, DefaultEnvAllowlist, nil, []string{"CC"}},
+		{"not allowed anymore", cc, EnvAllowlist{"GOFLAGS"}, []string{"CC=gcc"}, nil},
+		// Ancestors have their own environment.
+		{"ancestor", CmdTree{"make"}, DefaultEnvAllowlist, []string{"CC=gcc"}, nil},
+		{"unknown step", CmdTree{"deploy"}, DefaultEnvAllowlist, nil, nil},
+	} {
+		if diff := cmp.Diff(EnvironmentChanges(g, tc.step, tc.l, tc.env), tc.want); diff != "" {
+			t.Errorf("%v: unexpected changes, diff: %v", tc.name, diff)
+		}
+	}
+
+	// Environments survive writing the graph back and compiling it.
+	report := new(bytes.Buffer)
+	if err := g.WriteReport(report); err != nil {
+		t.Fatal(err)
+	}
+	written, err := NewDependencyGraph(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled := new(bytes.Buffer)
+	if err := CompileGraph(compiled, strings.NewReader(envReport), CompiledSource{Size: 1, ModTime: time.Unix(1, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCompiledGraph(compiled, CompiledSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, other := range map[string]*DependencyGraph{"written": written, "compiled": loaded} {
+		if diff := cmp.Diff(other.StepEnvironment(cc), []string{"CC=clang", "GOFLAGS"}); diff != "" {
+			t.Errorf("%v graph: unexpected environment, diff: %v", name, diff)
+		}
+	}
+}
+
+func TestEnvironmentReason(t *testing.T) {
+	got := EnvironmentReason(CmdTree{"cc a.c"}, []string{"CC", "CFLAGS"})
+	want := `step "[\"cc a.c\"]" runs with a different CC, CFLAGS than in the base build`
+	if got != want {
+		t.Errorf("got %q wanted %q", got, want)
+	}
+}
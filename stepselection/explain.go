@@ -0,0 +1,134 @@
+package stepselection
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ChainLink says that Step reads File. Overlay names the overlay that added
+// the edge, if any. Dir is set when Step doesn't read File but lists Dir,
+// which contains File.
+type ChainLink struct {
+	File    string
+	Step    string
+	Overlay string
+	Dir     string
+}
+
+// Chain connects a changed file to a step. The first link's File is the
+// changed file and the last link's Step is the step being explained. Each
+// link's Step writes the File of the next link.
+type Chain []ChainLink
+
+func (c Chain) String() string {
+	if len(c) == 0 {
+		return ""
+	}
+	buf := new(strings.Builder)
+	buf.WriteString(c[0].File)
+	for i, l := range c {
+		if i > 0 {
+			fmt.Fprintf(buf, ", which writes %v", l.File)
+		}
+		if l.Dir != "" {
+			fmt.Fprintf(buf, " -> in directory %v listed by %v", l.Dir, l.Step)
+			continue
+		}
+		fmt.Fprintf(buf, " -> read by %v%v", l.Step, overlayNote(l.Overlay))
+	}
+	return buf.String()
+}
+
+// DependencyChains returns, for each changed file that cmdTree depends on, the
+// shortest chain of steps and files connecting the file to the step. It walks
+// the same edges as StepDependsOnFiles, so it explains its decisions.
+func (g *DependencyGraph) DependencyChains(cmdTree CmdTree, changedFiles []string) ([]Chain, error) {
+	target, _, ok := g.find(cmdTree)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownStep, cmdTree)
+	}
+	changed := map[string]bool{}
+	for _, f := range g.changedPaths(changedFiles) {
+		changed[f] = true
+	}
+
+	// Breadth-first search backwards from the step, through the producers
+	// of the files it reads. paths holds, for each step reached, the chain
+	// from that step to the target.
+	paths := map[*step]Chain{target: nil}
+	queue := []*step{target}
+	// via holds the file each step was reached through.
+	via := map[*step]string{}
+	var chains []Chain
+	reported := map[string]bool{}
+	// listed adds the chains of the changed files in the directories s
+	// lists. rest is the chain from s to the target.
+	listed := func(s *step, rest Chain) {
+		for _, dir := range sortedKeys(s.readDirs) {
+			for _, c := range sortedKeys(changed) {
+				if !reported[c] && inDir(c, dir) {
+					reported[c] = true
+					chains = append(chains, append(Chain{{File: c, Step: s.name, Dir: dir}}, rest...))
+				}
+			}
+		}
+	}
+	listed(target, nil)
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for _, read := range sortedKeys(s.readFiles) {
+			overlay := s.overlayReads[read]
+			if overlay == "" && s != target {
+				overlay = s.overlayWrites[via[s]]
+			}
+			chain := append(Chain{{File: read, Step: s.name, Overlay: overlay}}, paths[s]...)
+			if changed[read] && !reported[read] {
+				reported[read] = true
+				chains = append(chains, chain)
+			}
+			for _, writer := range g.fileWriters[read] {
+				if _, seen := paths[writer]; seen || !produces(writer, s, read) {
+					continue
+				}
+				paths[writer] = chain
+				via[writer] = read
+				listed(writer, chain)
+				queue = append(queue, writer)
+			}
+		}
+	}
+	return chains, nil
+}
+
+// Explanation is the decision for a step, with the chains of steps and files
+// that led to it.
+type Explanation struct {
+	// Step is the name of the step.
+	Step string
+	// Run is whether the step must run.
+	Run bool
+	// Reason is the reason of StepDependsOnFiles. It's for people and may
+	// change at any point.
+	Reason string
+	// Chains connects each changed file the step depends on to the step.
+	// It's empty when the step can be skipped.
+	Chains []Chain
+}
+
+// Explain decides whether cmdTree must run for changes, like
+// StepDependsOnFiles, and explains the decision with DependencyChains.
+func (g *DependencyGraph) Explain(cmdTree CmdTree, changes *ChangeSet) (*Explanation, error) {
+	files := changes.Files()
+	run, reason, err := g.StepDependsOnFiles(cmdTree, files)
+	if err != nil {
+		return nil, err
+	}
+	e := &Explanation{Step: cmdTree.Name(), Run: run, Reason: reason}
+	if run {
+		if e.Chains, err = g.DependencyChains(cmdTree, files); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
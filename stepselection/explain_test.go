@@ -0,0 +1,35 @@
+package stepselection
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDependencyChains(t *testing.T) {
+	report := `{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["cc"],"Mode":"W","File":"/out/a.o"}
+{"CmdTree":["link"],"Mode":"R","File":"/out/a.o"}
+{"CmdTree":["link"],"Mode":"R","File":"/src/main.c"}
+{"CmdTree":["link"],"Mode":"W","File":"/out/app"}
+{"CmdTree":["test"],"Mode":"R","File":"/out/app"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chains, err := g.DependencyChains(CmdTree{"test"}, []string{"/src/a.c", "/src/unrelated.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chains) != 1 {
+		t.Fatalf("got %d chains, wanted 1: %v", len(chains), chains)
+	}
+	want := `/src/a.c -> read by ["cc"], which writes /out/a.o -> read by ["link"], which writes /out/app -> read by ["test"]`
+	if got := chains[0].String(); got != want {
+		t.Errorf("got %q wanted %q", got, want)
+	}
+
+	if _, err := g.DependencyChains(CmdTree{"nope"}, nil); err == nil {
+		t.Errorf("expected an error for an unknown step")
+	}
+}
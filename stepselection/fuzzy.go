@@ -0,0 +1,125 @@
+package stepselection
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// FuzzyMatch configures how lookups of steps that aren't in the graph fall
+// back on a similar recorded step, for steps whose commands change slightly
+// from build to build. The strategies are tried in the order of the fields.
+// The zero FuzzyMatch only finds exact matches.
+type FuzzyMatch struct {
+	// Command matches the only recorded step with the same command under
+	// other ancestors, e.g. ["make", "cc a.c"] for ["make all", "cc a.c"].
+	Command bool
+	// MinSimilarity matches the recorded sibling of the step whose
+	// command shares the most arguments with the step's, measured as the
+	// Jaccard index of their sets of arguments, if it's at least
+	// MinSimilarity. Zero disables it.
+	MinSimilarity float64
+	// Prefix matches the closest recorded ancestor of the step. Ancestors
+	// depend on the files of all their descendants, so the match errs on
+	// the side of running the step.
+	Prefix bool
+}
+
+func (f FuzzyMatch) enabled() bool {
+	return f.Command || f.MinSimilarity > 0 || f.Prefix
+}
+
+// SetFuzzyMatch sets how later lookups treat steps that aren't in the graph.
+func (g *DependencyGraph) SetFuzzyMatch(f FuzzyMatch) {
+	g.mu.Lock()
+	g.fuzzy = f
+	g.mu.Unlock()
+}
+
+// indexedStep is a step of the graph with its CmdTree, for fuzzy matching.
+type indexedStep struct {
+	step *step
+	tree CmdTree
+}
+
+// find returns the step cmdTree, or the recorded step that fuzzy matching
+// settled on. In the latter case, note explains the match.
+func (g *DependencyGraph) find(cmdTree CmdTree) (s *step, note string, ok bool) {
+	cmdTree = g.normalizer.CmdTree(cmdTree)
+	if s, ok := g.steps[cmdTree.Name()]; ok {
+		return s, "", true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.fuzzy.enabled() || len(cmdTree) == 0 {
+		return nil, "", false
+	}
+	if g.index == nil {
+		for name, s := range g.steps {
+			var tree CmdTree
+			if err := json.Unmarshal([]byte(name), &tree); err == nil && len(tree) > 0 {
+				g.index = append(g.index, indexedStep{step: s, tree: tree})
+			}
+		}
+	}
+	command := cmdTree[len(cmdTree)-1]
+	if g.fuzzy.Command {
+		var match *step
+		n := 0
+		for _, c := range g.index {
+			if c.tree[len(c.tree)-1] == command {
+				match = c.step
+				n++
+			}
+		}
+		if n == 1 {
+			return match, fmt.Sprintf("step %q wasn't recorded, so it was matched with %q, which has the same command", cmdTree.Name(), match.name), true
+		}
+	}
+	if g.fuzzy.MinSimilarity > 0 {
+		parent := cmdTree[:len(cmdTree)-1].Name()
+		var match *step
+		best := 0.0
+		for _, c := range g.index {
+			if len(c.tree) != len(cmdTree) || c.tree[:len(c.tree)-1].Name() != parent {
+				continue
+			}
+			sim := jaccard(strings.Fields(command), strings.Fields(c.tree[len(c.tree)-1]))
+			if sim > best || (sim == best && match != nil && c.step.name < match.name) {
+				match, best = c.step, sim
+			}
+		}
+		if match != nil && best >= g.fuzzy.MinSimilarity {
+			return match, fmt.Sprintf("step %q wasn't recorded, so it was matched with %q, which shares %.0f%% of its arguments", cmdTree.Name(), match.name, best*100), true
+		}
+	}
+	if g.fuzzy.Prefix {
+		for i := len(cmdTree) - 1; i > 0; i-- {
+			if s, ok := g.steps[cmdTree[:i].Name()]; ok {
+				return s, fmt.Sprintf("step %q wasn't recorded, so it was matched with its closest recorded ancestor %q", cmdTree.Name(), s.name), true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// jaccard returns the Jaccard index of the sets of words a and b.
+func jaccard(a, b []string) float64 {
+	set := map[string]int{}
+	for _, w := range a {
+		set[w] |= 1
+	}
+	for _, w := range b {
+		set[w] |= 2
+	}
+	if len(set) == 0 {
+		return 1
+	}
+	both := 0
+	for _, in := range set {
+		if in == 3 {
+			both++
+		}
+	}
+	return float64(both) / float64(len(set))
+}
@@ -0,0 +1,86 @@
+package stepselection
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	report := `{"CmdTree":["make all"],"Mode":"R","File":"/src/Makefile"}
+{"CmdTree":["make all","cc -O2 -c a.c"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["make all","cc -O2 -c b.c"],"Mode":"R","File":"/src/b.c"}
+{"CmdTree":["make all","go vet ./..."],"Mode":"R","File":"/src/main.go"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown := CmdTree{"make all", "cc -O2 -g -c a.c"}
+	if _, _, err := g.StepDependsOnFiles(unknown, nil); !errors.Is(err, ErrUnknownStep) {
+		t.Errorf("without fuzzy matching: got %v wanted %v", err, ErrUnknownStep)
+	}
+
+	for _, tc := range []struct {
+		fuzzy   FuzzyMatch
+		step    CmdTree
+		changed string
+		run     bool
+		reason  string
+	}{
+		{FuzzyMatch{Command: true}, CmdTree{"make", "go vet ./..."}, "/src/main.go", true, `step "[\"make all\",\"go vet ./...\"]" reads file "/src/main.go" which is being updated (step "[\"make\",\"go vet ./...\"]" wasn't recorded, so it was matched with "[\"make all\",\"go vet ./...\"]", which has the same command)`},
+		{FuzzyMatch{Command: true}, CmdTree{"make", "go vet ./..."}, "/src/a.c", false, `step "[\"make\",\"go vet ./...\"]" wasn't recorded, so it was matched with "[\"make all\",\"go vet ./...\"]", which has the same command`},
+		{FuzzyMatch{MinSimilarity: 0.5}, unknown, "/src/a.c", true, `step "[\"make all\",\"cc -O2 -c a.c\"]" reads file "/src/a.c" which is being updated (step "[\"make all\",\"cc -O2 -g -c a.c\"]" wasn't recorded, so it was matched with "[\"make all\",\"cc -O2 -c a.c\"]", which shares 80% of its arguments)`},
+		{FuzzyMatch{MinSimilarity: 0.9}, unknown, "/src/a.c", false, ""},
+		{FuzzyMatch{Prefix: true}, unknown, "/src/b.c", true, `step "[\"make all\"]" reads file "/src/b.c" which is being updated (step "[\"make all\",\"cc -O2 -g -c a.c\"]" wasn't recorded, so it was matched with its closest recorded ancestor "[\"make all\"]")`},
+		{FuzzyMatch{Prefix: true}, CmdTree{"ninja"}, "/src/a.c", false, ""},
+	} {
+		g.SetFuzzyMatch(tc.fuzzy)
+		run, reason, err := g.StepDependsOnFiles(tc.step, []string{tc.changed})
+		if tc.reason == "" && !tc.run {
+			if !errors.Is(err, ErrUnknownStep) {
+				t.Errorf("%+v %q: got %v wanted %v", tc.fuzzy, tc.step, err, ErrUnknownStep)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if run != tc.run || reason != tc.reason {
+			t.Errorf("%+v %q: got %v, %q wanted %v, %q", tc.fuzzy, tc.step, run, reason, tc.run, tc.reason)
+		}
+		if !g.HasStep(tc.step) {
+			t.Errorf("%+v %q: HasStep should find the match", tc.fuzzy, tc.step)
+		}
+	}
+}
+
+func TestFuzzyMatchAmbiguousCommand(t *testing.T) {
+	report := `{"CmdTree":["make a","go test ./..."],"Mode":"R","File":"/src/a.go"}
+{"CmdTree":["make b","go test ./..."],"Mode":"R","File":"/src/b.go"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.SetFuzzyMatch(FuzzyMatch{Command: true})
+	if g.HasStep(CmdTree{"make", "go test ./..."}) {
+		t.Errorf("a command recorded under several ancestors shouldn't match")
+	}
+}
+
+func TestJaccard(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want float64
+	}{
+		{"cc -c a.c", "cc -c a.c", 1},
+		{"cc -c a.c", "cc -c b.c", 0.5},
+		{"cc", "ld", 0},
+		{"", "", 1},
+	} {
+		if got := jaccard(strings.Fields(tc.a), strings.Fields(tc.b)); got != tc.want {
+			t.Errorf("jaccard(%q, %q): got %v wanted %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
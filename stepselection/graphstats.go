@@ -0,0 +1,180 @@
+package stepselection
+
+import "sort"
+
+// GraphStats describes the size and shape of a dependency graph, to tell
+// whether its build report is too noisy, with steps depending on most files,
+// or too sparse, with steps reading no files.
+type GraphStats struct {
+	Steps int
+	// Files counts the distinct files read or written, and ListedDirs the
+	// distinct directories listed.
+	Files      int
+	ListedDirs int
+	// Reads, Writes and Lists count the edges between steps and the files
+	// they read and write and the directories they list. Like every edge
+	// of the graph, they include the edges inherited from nested steps.
+	Reads  int
+	Writes int
+	Lists  int
+	// NoReads counts the steps that read no files, which are always
+	// skipped.
+	NoReads int
+	// MostRead are the files with the most readers, and MostWritten the
+	// files with the most writers, by decreasing count.
+	MostRead    []FileCount
+	MostWritten []FileCount
+	// DeepestChains are the longest chains of steps each depending on the
+	// previous one, longest first. Chains through cycles are cut where
+	// they would loop.
+	DeepestChains []Chain
+	// Memory roughly estimates the bytes of memory the loaded graph uses,
+	// not counting the caches built by lookups.
+	Memory int64
+}
+
+// FileCount is a file and how many steps read or write it.
+type FileCount struct {
+	File  string
+	Steps int
+}
+
+// Rough sizes of the parts of a loaded graph, for GraphStats.Memory: a step
+// and its maps, an entry of a map keyed by file, and a pointer to a step.
+const (
+	stepBytes     = 512
+	mapEntryBytes = 48
+	pointerBytes  = 8
+)
+
+// Stats computes the stats of g, listing the top files and chains of each
+// kind.
+func (g *DependencyGraph) Stats(top int) *GraphStats {
+	st := &GraphStats{Steps: len(g.steps)}
+	readers := map[string]int{}
+	dirs := map[string]bool{}
+	for name, s := range g.steps {
+		st.Memory += stepBytes + int64(len(name))
+		st.Reads += len(s.readFiles)
+		st.Lists += len(s.readDirs)
+		st.Memory += int64(len(s.readFiles)+len(s.readDirs)) * mapEntryBytes
+		if len(s.readFiles) == 0 {
+			st.NoReads++
+		}
+		for f := range s.readFiles {
+			readers[f]++
+		}
+		for d := range s.readDirs {
+			dirs[d] = true
+		}
+	}
+	files := map[string]bool{}
+	for f := range readers {
+		files[f] = true
+	}
+	writers := map[string]int{}
+	for f, ws := range g.fileWriters {
+		files[f] = true
+		writers[f] = len(ws)
+		st.Writes += len(ws)
+		st.Memory += mapEntryBytes + int64(len(ws))*pointerBytes
+	}
+	st.Files = len(files)
+	st.ListedDirs = len(dirs)
+	// File names are interned, so each is stored once.
+	for f := range files {
+		st.Memory += int64(len(f))
+	}
+	for d := range dirs {
+		st.Memory += int64(len(d))
+	}
+	st.MostRead = topFiles(readers, top)
+	st.MostWritten = topFiles(writers, top)
+	st.DeepestChains = g.deepestChains(top)
+	return st
+}
+
+// topFiles returns the n files with the highest counts, by decreasing count.
+func topFiles(counts map[string]int, n int) []FileCount {
+	var fc []FileCount
+	for f, c := range counts {
+		fc = append(fc, FileCount{File: f, Steps: c})
+	}
+	sort.Slice(fc, func(i, j int) bool {
+		if fc[i].Steps != fc[j].Steps {
+			return fc[i].Steps > fc[j].Steps
+		}
+		return fc[i].File < fc[j].File
+	})
+	if len(fc) > n {
+		fc = fc[:n]
+	}
+	return fc
+}
+
+// deepestChains returns the n longest chains of at least two steps, longest
+// first. Chains that are part of a longer one aren't repeated.
+func (g *DependencyGraph) deepestChains(n int) []Chain {
+	successors := g.stepSuccessors()
+	type link struct {
+		file string
+		next *step
+	}
+	depth := map[*step]int{}
+	next := map[*step]link{}
+	onStack := map[*step]bool{}
+	var visit func(s *step) int
+	visit = func(s *step) int {
+		if d, ok := depth[s]; ok {
+			return d
+		}
+		onStack[s] = true
+		best := 1
+		successors(s, func(file string, t *step) {
+			if onStack[t] {
+				// A cycle.
+				return
+			}
+			if d := visit(t) + 1; d > best {
+				best = d
+				next[s] = link{file, t}
+			}
+		})
+		onStack[s] = false
+		depth[s] = best
+		return best
+	}
+	steps := make([]*step, 0, len(g.steps))
+	for _, s := range g.steps {
+		steps = append(steps, s)
+	}
+	sort.Slice(steps, func(i, j int) bool { return steps[i].name < steps[j].name })
+	for _, s := range steps {
+		visit(s)
+	}
+	sort.SliceStable(steps, func(i, j int) bool { return depth[steps[i]] > depth[steps[j]] })
+
+	var chains []Chain
+	inChain := map[*step]bool{}
+	for _, s := range steps {
+		if len(chains) == n || depth[s] < 2 {
+			break
+		}
+		if inChain[s] {
+			continue
+		}
+		var c Chain
+		if reads := sortedKeys(s.readFiles); len(reads) > 0 {
+			c = append(c, ChainLink{File: reads[0], Step: s.name})
+		} else {
+			c = append(c, ChainLink{Step: s.name})
+		}
+		inChain[s] = true
+		for l, ok := next[s]; ok; l, ok = next[l.next] {
+			c = append(c, ChainLink{File: l.file, Step: l.next.name})
+			inChain[l.next] = true
+		}
+		chains = append(chains, c)
+	}
+	return chains
+}
@@ -0,0 +1,62 @@
+package stepselection
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGraphStats(t *testing.T) {
+	report := `{"CmdTree":["gen"],"Mode":"R","File":"/src/gen.y"}
+{"CmdTree":["gen"],"Mode":"W","File":"/src/parser.c"}
+{"CmdTree":["cc"],"Mode":"R","File":"/src/parser.c"}
+{"CmdTree":["cc"],"Mode":"R","File":"/src/x.h"}
+{"CmdTree":["cc"],"Mode":"W","File":"/out/parser.o"}
+{"CmdTree":["link"],"Mode":"R","File":"/out/parser.o"}
+{"CmdTree":["link"],"Mode":"R","File":"/src/x.h"}
+{"CmdTree":["link"],"Mode":"W","File":"/out/bin"}
+{"CmdTree":["fmt"],"Mode":"W","File":"/out/bin"}
+{"CmdTree":["docs"],"Mode":"R","Type":"dir","File":"/src"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := g.Stats(1)
+	if st.Steps != 5 || st.NoReads != 2 || st.Files != 5 || st.ListedDirs != 1 || st.Reads != 5 || st.Writes != 4 || st.Lists != 1 {
+		t.Errorf("got stats %+v", st)
+	}
+	if diff := cmp.Diff([]FileCount{{"/src/x.h", 2}}, st.MostRead); diff != "" {
+		t.Errorf("unexpected most read files (-want +got):\n%v", diff)
+	}
+	if diff := cmp.Diff([]FileCount{{"/out/bin", 2}}, st.MostWritten); diff != "" {
+		t.Errorf("unexpected most written files (-want +got):\n%v", diff)
+	}
+	if len(st.DeepestChains) != 1 {
+		t.Fatalf("got chains %q wanted one", st.DeepestChains)
+	}
+	want := `/src/gen.y -> read by ["gen"], which writes /src/parser.c -> read by ["cc"], which writes /out/parser.o -> read by ["link"]`
+	if got := st.DeepestChains[0].String(); got != want {
+		t.Errorf("got chain %q wanted %q", got, want)
+	}
+	if st.Memory <= 0 {
+		t.Errorf("got memory estimate %d", st.Memory)
+	}
+}
+
+func TestDeepestChainsCycle(t *testing.T) {
+	report := `{"CmdTree":["gen"],"Mode":"R","File":"/src/b.h"}
+{"CmdTree":["gen"],"Mode":"W","File":"/src/a.h"}
+{"CmdTree":["fix"],"Mode":"R","File":"/src/a.h"}
+{"CmdTree":["fix"],"Mode":"W","File":"/src/b.h"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chains := g.Stats(5).DeepestChains
+	if len(chains) != 1 || len(chains[0]) != 2 {
+		t.Errorf("got chains %q wanted one of two steps", chains)
+	}
+}
@@ -0,0 +1,127 @@
+package stepselection
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// DefaultIgnorePatterns are the files ignored unless configured otherwise.
+// They're pseudo-files that processes read and write all the time without
+// creating dependencies between them.
+var DefaultIgnorePatterns = []string{"/dev/**", "/proc/**", "/sys/**"}
+
+var defaultIgnore = MustPathMatcher(DefaultIgnorePatterns)
+
+// DefaultHermeticPatterns are the files no step depends on unless configured
+// otherwise: system files and toolchain caches, which only change when the
+// toolchain does. Their changes are left out of lookups, which keeps steps
+// from depending on files no change of the workspace touches.
+var DefaultHermeticPatterns = []string{
+	"/usr/**", "/lib/**", "/lib64/**", "/etc/**",
+	"**/.cache/go-build/**", "**/go/pkg/mod/**", "**/.cargo/registry/**",
+	"**/.m2/repository/**", "**/.gradle/caches/**",
+}
+
+var defaultHermetic = MustPathMatcher(DefaultHermeticPatterns)
+
+// PathMatcher matches paths against glob patterns. Patterns are like
+// filepath.Match patterns, with a few additions:
+//
+//   - "**" matches any number of directories, so "/proc/**" matches /proc
+//     and everything under it and "/src/**/*.log" matches logs at any depth
+//     under /src.
+//   - Patterns that don't start with "/" match at any depth, so "*.log"
+//     matches every log file.
+//
+// A nil PathMatcher matches nothing.
+type PathMatcher struct {
+	patterns []string
+	re       *regexp.Regexp
+}
+
+// NewPathMatcher compiles patterns.
+func NewPathMatcher(patterns []string) (*PathMatcher, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	var exprs []string
+	for _, p := range patterns {
+		if p == "" {
+			return nil, fmt.Errorf("invalid empty pattern")
+		}
+		exprs = append(exprs, globToRegexp(toNodePath(p)))
+	}
+	re, err := regexp.Compile("^(?:" + strings.Join(exprs, "|") + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid patterns %q: %v", patterns, err)
+	}
+	return &PathMatcher{patterns: patterns, re: re}, nil
+}
+
+// MustPathMatcher is like NewPathMatcher but panics on invalid patterns.
+func MustPathMatcher(patterns []string) *PathMatcher {
+	m, err := NewPathMatcher(patterns)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Match reports whether p matches any of the patterns. Relative paths are
+// made absolute first, and Windows paths are matched in their node form, e.g.
+// /c:/src/a.c.
+func (m *PathMatcher) Match(p string) bool {
+	if m == nil {
+		return false
+	}
+	return m.re.MatchString(path.Clean(absoluteNodePath(p)))
+}
+
+func (m *PathMatcher) String() string {
+	if m == nil {
+		return ""
+	}
+	return strings.Join(m.patterns, ",")
+}
+
+// globToRegexp translates a glob pattern into a regular expression.
+func globToRegexp(glob string) string {
+	if !strings.HasPrefix(glob, "/") && !strings.HasPrefix(glob, "**") {
+		glob = "**/" + glob
+	}
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("(?:/.*)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			j := strings.IndexByte(glob[i:], ']')
+			if j < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += j
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
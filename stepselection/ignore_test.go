@@ -0,0 +1,110 @@
+package stepselection
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPathMatcher(t *testing.T) {
+	m, err := NewPathMatcher([]string{"/proc/**", "**/*.log", "/src/**/gen/*.go", "*.tm?", "/out/[!a]*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		path string
+		want bool
+	}{
+		{"/proc", true},
+		{"/proc/self/status", true},
+		{"/process", false},
+		{"/var/log/build.log", true},
+		{"/build.log", true},
+		{"/src/gen/a.go", true},
+		{"/src/x/y/gen/a.go", true},
+		{"/src/gen/sub/a.go", false},
+		{"/a/b.tmp", true},
+		{"/a/b.tmpx", false},
+		{"/out/b", true},
+		{"/out/a", false},
+		{"/src/main.go", false},
+	} {
+		if got := m.Match(tc.path); got != tc.want {
+			t.Errorf("Match(%q): got %v wanted %v", tc.path, got, tc.want)
+		}
+	}
+
+	var nilMatcher *PathMatcher
+	if nilMatcher.Match("/proc") {
+		t.Errorf("a nil matcher shouldn't match anything")
+	}
+}
+
+func TestIgnoredEntries(t *testing.T) {
+	report := `{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["cc"],"Mode":"R","File":"/dev/urandom"}
+{"CmdTree":["cc"],"Mode":"W","File":"/src/cc.log"}
+`
+	for _, tc := range []struct {
+		opts  []Option
+		reads []string
+	}{
+		{nil, []string{"/src/a.c"}},
+		{[]Option{WithIgnore(MustPathMatcher([]string{"*.c"}))}, []string{"/dev/urandom"}},
+		{[]Option{WithIgnore(nil)}, []string{"/dev/urandom", "/src/a.c"}},
+	} {
+		g, err := NewDependencyGraph(strings.NewReader(report), tc.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sortedKeys(g.steps[`["cc"]`].readFiles); strings.Join(got, " ") != strings.Join(tc.reads, " ") {
+			t.Errorf("got reads %q wanted %q", got, tc.reads)
+		}
+	}
+
+	g, err := NewDependencyGraph(strings.NewReader(report), WithIgnore(MustPathMatcher([]string{"*.log"})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.fileWriters) != 0 {
+		t.Errorf("ignored writes are still in the graph: %v", g.fileWriters)
+	}
+}
+
+func TestHermeticEntries(t *testing.T) {
+	report := `{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["cc"],"Mode":"R","File":"/usr/include/stdio.h"}
+{"CmdTree":["cc"],"Mode":"R","File":"/usr/include","Type":"dir"}
+{"CmdTree":["cc"],"Mode":"R","File":"/home/ci/.cache/go-build/ab/abc-d"}
+{"CmdTree":["install"],"Mode":"W","File":"/usr/local/bin/tool"}
+`
+	// The reads are kept, for explaining the graph, but steps don't
+	// depend on them.
+	reads := []string{"/home/ci/.cache/go-build/ab/abc-d", "/src/a.c", "/usr/include/stdio.h"}
+	for _, tc := range []struct {
+		opts    []Option
+		changed string
+		run     bool
+	}{
+		{nil, "/usr/include/stdio.h", false},
+		{nil, "/usr/include", false},
+		{nil, "/src/a.c", true},
+		{[]Option{WithHermetic(nil)}, "/usr/include/stdio.h", true},
+	} {
+		g, err := NewDependencyGraph(strings.NewReader(report), tc.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sortedKeys(g.steps[`["cc"]`].readFiles); strings.Join(got, " ") != strings.Join(reads, " ") {
+			t.Errorf("got reads %q wanted %q", got, reads)
+		}
+		if run, _, err := g.StepDependsOnFiles(CmdTree{"cc"}, []string{tc.changed}); err != nil || run != tc.run {
+			t.Errorf("StepDependsOnFiles(%q): got %v, %v wanted %v", tc.changed, run, err, tc.run)
+		}
+		if affected := g.StepsAffectedBy([]string{tc.changed}); (len(affected) > 0) != tc.run {
+			t.Errorf("StepsAffectedBy(%q): got %q", tc.changed, affected)
+		}
+		if len(g.fileWriters["/usr/local/bin/tool"]) != 1 {
+			t.Errorf("writes of hermetic files should be kept")
+		}
+	}
+}
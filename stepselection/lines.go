@@ -0,0 +1,66 @@
+package stepselection
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// lineScanner reads the lines of a build report like a bufio.Scanner, but
+// without a limit on their length: entries of steps with huge argument
+// lists can be megabytes long.
+type lineScanner struct {
+	r    *bufio.Reader
+	line []byte
+	err  error
+}
+
+func newLineScanner(r io.Reader) *lineScanner {
+	return &lineScanner{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// Scan reads the next line, which Bytes returns. It returns false at the end
+// of the input or on errors, which Err returns.
+func (s *lineScanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	s.line = s.line[:0]
+	for {
+		chunk, err := s.r.ReadSlice('\n')
+		s.line = append(s.line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			s.err = err
+			if len(s.line) == 0 {
+				return false
+			}
+			// A last line without a newline.
+		}
+		break
+	}
+	s.line = bytes.TrimSuffix(s.line, []byte("\n"))
+	s.line = bytes.TrimSuffix(s.line, []byte("\r"))
+	return true
+}
+
+// Bytes returns the last line read, without its line ending. It's
+// overwritten by the next call to Scan.
+func (s *lineScanner) Bytes() []byte {
+	return s.line
+}
+
+// Text returns the last line read as a string.
+func (s *lineScanner) Text() string {
+	return string(s.line)
+}
+
+// Err returns the error that stopped Scan, or nil at the end of the input.
+func (s *lineScanner) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
@@ -0,0 +1,46 @@
+package stepselection
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLongLines(t *testing.T) {
+	// A step with a huge argument list, like a linker given every object.
+	arg := strings.Repeat("x", 2<<20)
+	report := `{"SkipperReport":1}` + "\n" +
+		`{"CmdTree":["ld ` + arg + `"],"Mode":"R","File":"/out/a.o"}` + "\r\n" +
+		`{"CmdTree":["cc"],"Mode":"W","File":"/out/a.o"}` + "\n" +
+		`{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps, _, err := g.StepDependsOnFiles([]string{"ld " + arg}, []string{"/src/a.c"})
+	if err != nil || !deps {
+		t.Errorf("got %v, %v, wanted the long step to depend on /src/a.c", deps, err)
+	}
+
+	upgraded := new(bytes.Buffer)
+	if _, err := UpgradeReport(upgraded, strings.NewReader(report)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDependencyGraph(upgraded); err != nil {
+		t.Errorf("loading the upgraded report: %v", err)
+	}
+	if _, err := Lint(strings.NewReader(report), LintOptions{}); err != nil {
+		t.Errorf("linting: %v", err)
+	}
+}
+
+func TestLineScanner(t *testing.T) {
+	s := newLineScanner(strings.NewReader("a\r\n\nb"))
+	var lines []string
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	if s.Err() != nil || strings.Join(lines, "|") != "a||b" {
+		t.Errorf("got lines %q, %v", lines, s.Err())
+	}
+}
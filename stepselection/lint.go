@@ -0,0 +1,192 @@
+package stepselection
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Finding is a problem found in a build report by Lint.
+type Finding struct {
+	// Check identifies the kind of problem: "parse", "relative-path",
+	// "no-reads", "self-write", "many-writers" or "broad-read", or
+	// "nondeterministic" for LintNondeterminism.
+	Check string
+	// Line is the line of the report with the problem, for problems with
+	// a single entry.
+	Line    int    `json:",omitempty"`
+	Step    string `json:",omitempty"`
+	File    string `json:",omitempty"`
+	Message string
+}
+
+func (f Finding) String() string {
+	if f.Line > 0 {
+		return fmt.Sprintf("line %d: %v: %v", f.Line, f.Check, f.Message)
+	}
+	return fmt.Sprintf("%v: %v", f.Check, f.Message)
+}
+
+// LintOptions tune the checks of Lint.
+type LintOptions struct {
+	// MaxWriters is the number of unrelated steps that can write the same
+	// file. Steps nested in one another aren't unrelated.
+	MaxWriters int
+	// BroadReadFraction is the fraction of all the files read in the
+	// build above which a single step's reads are suspiciously broad.
+	// Small builds, with fewer than BroadReadMinFiles files, aren't
+	// checked.
+	BroadReadFraction float64
+	BroadReadMinFiles int
+}
+
+// DefaultLintOptions are the options used by "skipper graph lint".
+var DefaultLintOptions = LintOptions{MaxWriters: 5, BroadReadFraction: 0.5, BroadReadMinFiles: 100}
+
+// Lint checks a build report for entries and steps that are likely to make
+// decisions wrong, such as entries that don't parse, which would make loading
+// the report fail, or steps that read nothing, which would always be skipped.
+// Findings about single entries come first, in the order of the report, then
+// the ones about whole steps and files. The error is only set if reading the
+// report fails.
+func Lint(buildReport io.Reader, opts LintOptions) ([]Finding, error) {
+	var findings []Finding
+	// reads and writes hold the entries of each step, without the ones of
+	// their nested steps.
+	reads := map[string]map[string]bool{}
+	lists := map[string]bool{}
+	writers := map[string]map[string]CmdTree{}
+	allReads := map[string]bool{}
+	steps := map[string]CmdTree{}
+
+	scanner := newLineScanner(buildReport)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		bog, err := decodeEntry(bytes.TrimSpace(scanner.Bytes()))
+		if err != nil {
+			f := Finding{Check: "parse", Line: line, Message: err.Error()}
+			if bog != nil && len(bog.CmdTree) > 0 {
+				f.Step, f.File = CmdTree(bog.CmdTree).Name(), bog.File
+			}
+			findings = append(findings, f)
+			continue
+		}
+		if bog == nil {
+			continue
+		}
+		name := CmdTree(bog.CmdTree).Name()
+		steps[name] = bog.CmdTree
+		if bog.Type == "step" || bog.Type == "net" || bog.Type == "env" || bog.Type == "tool" {
+			continue
+		}
+		if !path.IsAbs(toNodePath(bog.File)) {
+			findings = append(findings, Finding{Check: "relative-path", Line: line, Step: name, File: bog.File,
+				Message: fmt.Sprintf("%q is relative and the entry has no Cwd, so it's resolved against skipper's working directory instead of the step's", bog.File)})
+		}
+		switch {
+		case bog.Type == "dir":
+			lists[name] = true
+			if path.Clean(bog.File) == "/" {
+				findings = append(findings, Finding{Check: "broad-read", Line: line, Step: name, File: bog.File,
+					Message: fmt.Sprintf("step %v lists the root directory, so it depends on every file", name)})
+			}
+		case bog.Reads():
+			if reads[name] == nil {
+				reads[name] = map[string]bool{}
+			}
+			reads[name][bog.File] = true
+			allReads[bog.File] = true
+		default:
+			if writers[bog.File] == nil {
+				writers[bog.File] = map[string]CmdTree{}
+			}
+			writers[bog.File][name] = bog.CmdTree
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// A step reads what its nested steps read.
+	readsSomething := map[string]bool{}
+	for name, cmdTree := range steps {
+		if len(reads[name]) > 0 || lists[name] {
+			walkUpStepTree(cmdTree, func(t CmdTree) { readsSomething[t.Name()] = true })
+		}
+	}
+	for _, name := range sortedSteps(steps) {
+		if !readsSomething[name] {
+			findings = append(findings, Finding{Check: "no-reads", Step: name,
+				Message: fmt.Sprintf("step %v reads no files, so it's always skipped", name)})
+		}
+	}
+
+	for _, name := range sortedSteps(steps) {
+		for _, f := range sortedKeys(reads[name]) {
+			if _, ok := writers[f][name]; ok {
+				findings = append(findings, Finding{Check: "self-write", Step: name, File: f,
+					Message: fmt.Sprintf("step %v reads and writes %v, so it runs whenever %v changes, even when it changed it itself", name, f, f)})
+			}
+		}
+	}
+
+	files := make([]string, 0, len(writers))
+	for f := range writers {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		unrelated := unrelatedSteps(writers[f])
+		if opts.MaxWriters > 0 && len(unrelated) > opts.MaxWriters {
+			findings = append(findings, Finding{Check: "many-writers", File: f,
+				Message: fmt.Sprintf("%v is written by %d unrelated steps, which makes every step reading it depend on all of them", f, len(unrelated))})
+		}
+	}
+
+	if len(allReads) >= opts.BroadReadMinFiles && opts.BroadReadFraction > 0 {
+		for _, name := range sortedSteps(steps) {
+			if n := len(reads[name]); float64(n) > opts.BroadReadFraction*float64(len(allReads)) {
+				findings = append(findings, Finding{Check: "broad-read", Step: name,
+					Message: fmt.Sprintf("step %v reads %d of the %d files read in the build", name, n, len(allReads))})
+			}
+		}
+	}
+
+	return findings, nil
+}
+
+// unrelatedSteps returns the steps that don't have any of the other steps
+// nested in them, since writes are attributed to the ancestors of a step too.
+func unrelatedSteps(steps map[string]CmdTree) []string {
+	var out []string
+	for name, t := range steps {
+		nested := false
+		for other, o := range steps {
+			if other != name && len(o) > len(t) && CmdTree(o[:len(t)]).Name() == name {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func sortedSteps(steps map[string]CmdTree) []string {
+	names := make([]string, 0, len(steps))
+	for name := range steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,42 @@
+package stepselection
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLint(t *testing.T) {
+	report := `{"CmdTree":["make","cc"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["make","cc"],"Mode":"W","File":"/out/log"}
+not json
+{"CmdTree":["make","ld"],"Mode":"R","File":"a.o"}
+{"CmdTree":["make","ld"],"Mode":"W","File":"/out/log"}
+{"CmdTree":["stamp"],"Mode":"W","File":"/out/log"}
+{"CmdTree":["find"],"Mode":"R","File":"/","Type":"dir"}
+{"CmdTree":["make","cc"],"Mode":"Q","File":"/src/a.c"}
+{"CmdTree":["fmt"],"Mode":"R","File":"/src/b.go"}
+{"CmdTree":["fmt"],"Mode":"W","File":"/src/b.go"}
+`
+	findings, err := Lint(strings.NewReader(report), LintOptions{MaxWriters: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range findings {
+		got = append(got, f.Check+" "+f.Step+f.File)
+	}
+	want := []string{
+		"parse ",
+		`relative-path ["make","ld"]a.o`,
+		`broad-read ["find"]/`,
+		`parse ["make","cc"]/src/a.c`,
+		`no-reads ["stamp"]`,
+		`self-write ["fmt"]/src/b.go`,
+		`many-writers /out/log`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want +got)\n%s", diff)
+	}
+}
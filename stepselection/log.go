@@ -0,0 +1,13 @@
+package stepselection
+
+import "log/slog"
+
+// logger receives the tracing of dependency lookups, at debug level. It
+// discards everything until SetLogger is called.
+var logger = slog.New(slog.DiscardHandler)
+
+// SetLogger sets the logger used by the package. Lookups are traced at debug
+// level, which is verbose: every file and step explored is logged.
+func SetLogger(l *slog.Logger) {
+	logger = l
+}
@@ -0,0 +1,144 @@
+package stepselection
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// Merge folds a new build report into the graph, so the graph stays fresh
+// without re-recording the whole build. Steps recorded in the new report
+// replace their previous edges. Their ancestors keep their edges and gain
+// the new ones, since the new report may only cover some of their
+// descendants. Other steps are left untouched.
+func (g *DependencyGraph) Merge(buildReport io.Reader) error {
+	var entries []*BuildLog
+	opts := []Option{WithNormalizer(g.normalizer)}
+	if err := readEntries(buildReport, opts, func(bog *BuildLog, provenance string) {
+		entries = append(entries, bog)
+	}); err != nil {
+		return err
+	}
+	recorded := map[string]bool{}
+	for _, bog := range entries {
+		recorded[CmdTree(bog.CmdTree).Name()] = true
+	}
+	for name := range recorded {
+		if s, ok := g.steps[name]; ok {
+			s.readFiles = map[string]bool{}
+			s.overlayReads = nil
+			s.overlayWrites = nil
+			s.build = ""
+			s.readDirs = nil
+			s.duration = 0
+			s.network = nil
+			s.env = nil
+			s.tools = nil
+			s.readTimes = nil
+			s.writeTimes = nil
+		}
+	}
+	for file, writers := range g.fileWriters {
+		kept := writers[:0]
+		for _, s := range writers {
+			if !recorded[s.name] {
+				kept = append(kept, s)
+			}
+		}
+		if len(kept) == 0 {
+			delete(g.fileWriters, file)
+		} else {
+			g.fileWriters[file] = kept
+		}
+	}
+	for _, bog := range entries {
+		g.add(bog, "")
+	}
+	g.resetDeps()
+	return nil
+}
+
+// SetReportMetadata sets the creation time and commit WriteReport writes in
+// the header of the report, usually the ones of the report the graph was
+// loaded from, see ReadReportHeader.
+func (g *DependencyGraph) SetReportMetadata(h *ReportHeader) {
+	g.metadata = h
+}
+
+// WriteReport writes the graph as a build report that NewDependencyGraph
+// can load. Every edge is written, including the edges ancestors inherit
+// from their descendants, which loading the report adds again anyway.
+func (g *DependencyGraph) WriteReport(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	header := &ReportHeader{SkipperReport: ReportVersion}
+	if g.metadata != nil {
+		header.Created, header.Commit = g.metadata.Created, g.metadata.Commit
+	}
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(g.steps))
+	for name := range g.steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writes := map[*step][]string{}
+	for file, writers := range g.fileWriters {
+		for _, s := range writers {
+			writes[s] = append(writes[s], file)
+		}
+	}
+	for _, name := range names {
+		s := g.steps[name]
+		var cmdTree CmdTree
+		if err := json.Unmarshal([]byte(name), &cmdTree); err != nil {
+			return err
+		}
+		// Graphs don't tell executions and loads from reads, which
+		// decide the same.
+		for _, f := range sortedKeys(s.readFiles) {
+			if err := enc.Encode(&BuildLog{CmdTree: cmdTree, Mode: "R", File: f, BuildID: s.build, Time: s.entryTime(s.readTimes[f])}); err != nil {
+				return err
+			}
+		}
+		for _, d := range sortedKeys(s.readDirs) {
+			if err := enc.Encode(&BuildLog{CmdTree: cmdTree, Mode: "R", File: d, BuildID: s.build, Type: "dir"}); err != nil {
+				return err
+			}
+		}
+		if s.duration > 0 {
+			if err := enc.Encode(&BuildLog{CmdTree: cmdTree, BuildID: s.build, Type: "step", Duration: s.duration}); err != nil {
+				return err
+			}
+		}
+		for _, addr := range sortedKeys(s.network) {
+			if err := enc.Encode(&BuildLog{CmdTree: cmdTree, File: addr, BuildID: s.build, Type: "net"}); err != nil {
+				return err
+			}
+		}
+		for _, e := range s.environment() {
+			if err := enc.Encode(&BuildLog{CmdTree: cmdTree, File: e, BuildID: s.build, Type: "env"}); err != nil {
+				return err
+			}
+		}
+		for _, t := range s.toolchains() {
+			if err := enc.Encode(&BuildLog{CmdTree: cmdTree, File: t, BuildID: s.build, Type: "tool"}); err != nil {
+				return err
+			}
+		}
+		files := writes[s]
+		sort.Strings(files)
+		for i, f := range files {
+			if i > 0 && files[i-1] == f {
+				continue
+			}
+			if err := enc.Encode(&BuildLog{CmdTree: cmdTree, Mode: "W", File: f, BuildID: s.build, Time: s.entryTime(s.writeTimes[f])}); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,54 @@
+package stepselection
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	base := `{"CmdTree":["make","cc"],"Mode":"R","File":"/src/old.c"}
+{"CmdTree":["make","cc"],"Mode":"W","File":"/out/a.o"}
+{"CmdTree":["make","link"],"Mode":"R","File":"/out/a.o"}
+{"CmdTree":["make","link"],"Mode":"W","File":"/out/a"}
+`
+	update := `{"CmdTree":["make","cc"],"Mode":"R","File":"/src/new.c"}
+{"CmdTree":["make","cc"],"Mode":"W","File":"/out/b.o"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Merge(strings.NewReader(update)); err != nil {
+		t.Fatal(err)
+	}
+	got := new(bytes.Buffer)
+	if err := g.WriteReport(got); err != nil {
+		t.Fatal(err)
+	}
+	// cc's old edges are gone, while make keeps them along with the new
+	// ones, and link is untouched.
+	want := `{"SkipperReport":1}
+{"CmdTree":["make","cc"],"Mode":"R","File":"/src/new.c"}
+{"CmdTree":["make","cc"],"Mode":"W","File":"/out/b.o"}
+{"CmdTree":["make","link"],"Mode":"R","File":"/out/a.o"}
+{"CmdTree":["make","link"],"Mode":"W","File":"/out/a"}
+{"CmdTree":["make"],"Mode":"R","File":"/out/a.o"}
+{"CmdTree":["make"],"Mode":"R","File":"/src/new.c"}
+{"CmdTree":["make"],"Mode":"R","File":"/src/old.c"}
+{"CmdTree":["make"],"Mode":"W","File":"/out/a"}
+{"CmdTree":["make"],"Mode":"W","File":"/out/a.o"}
+{"CmdTree":["make"],"Mode":"W","File":"/out/b.o"}
+`
+	if got.String() != want {
+		t.Errorf("got\n%v\nwanted\n%v", got.String(), want)
+	}
+
+	depends, _, err := g.StepDependsOnFiles(CmdTree{"make", "cc"}, []string{"/src/old.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if depends {
+		t.Errorf("merged step still depends on a file it no longer reads")
+	}
+}
@@ -0,0 +1,55 @@
+package stepselection
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NetworkPolicy decides what to do with steps that connected to the network
+// in the base build. Their results may depend on more than their files, so
+// they can't be proven hermetic, and by default they always run.
+type NetworkPolicy struct {
+	// Ignore treats steps that connected to the network like any other.
+	Ignore bool
+	// Hermetic lists the steps whose network accesses don't affect their
+	// results, like downloads of pinned dependencies, which are treated
+	// like any other step.
+	Hermetic []AlwaysRunRule
+}
+
+// networkGraph is implemented by the graphs that record network accesses.
+type networkGraph interface {
+	NetworkAccesses(cmdTree CmdTree) []string
+}
+
+// MustRun returns the network addresses that force cmdTree to run, or nil
+// if the policy doesn't force it. g must record network accesses for the
+// policy to force anything. t gives the step's tags and may be nil.
+func (p *NetworkPolicy) MustRun(g Graph, cmdTree CmdTree, t *Tagger) []string {
+	if p == nil || p.Ignore {
+		return nil
+	}
+	ng, ok := g.(networkGraph)
+	if !ok {
+		return nil
+	}
+	addrs := ng.NetworkAccesses(cmdTree)
+	if len(addrs) == 0 || MatchAlwaysRun(p.Hermetic, cmdTree, t) != nil {
+		return nil
+	}
+	return addrs
+}
+
+// NetworkReason explains why cmdTree runs because it connected to addrs.
+func NetworkReason(cmdTree CmdTree, addrs []string) string {
+	const max = 3
+	shown := addrs
+	if len(shown) > max {
+		shown = shown[:max]
+	}
+	list := strings.Join(shown, ", ")
+	if len(addrs) > max {
+		list += fmt.Sprintf(" and %d more", len(addrs)-max)
+	}
+	return fmt.Sprintf("step %q connected to the network (%v) in the base build, so it can't be proven hermetic", cmdTree.Name(), list)
+}
@@ -0,0 +1,97 @@
+package stepselection
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const networkReport = `{"CmdTree":["make"],"Mode":"R","File":"/src/Makefile"}
+{"CmdTree":["make","go mod download"],"Type":"net","File":"proxy.golang.org:443"}
+{"CmdTree":["make","curl example.com"],"Type":"net","File":"93.184.216.34:443"}
+{"CmdTree":["make","go build"],"Mode":"R","File":"/src/main.go"}
+`
+
+func TestNetworkAccesses(t *testing.T) {
+	g, err := NewDependencyGraph(strings.NewReader(networkReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		step CmdTree
+		want []string
+	}{
+		{CmdTree{"make", "curl example.com"}, []string{"93.184.216.34:443"}},
+		{CmdTree{"make"}, []string{"93.184.216.34:443", "proxy.golang.org:443"}},
+		{CmdTree{"make", "go build"}, nil},
+	} {
+		if diff := cmp.Diff(g.NetworkAccesses(tc.step), tc.want); diff != "" {
+			t.Errorf("%q: unexpected accesses, diff: %v", tc.step, diff)
+		}
+	}
+
+	// Network accesses survive writing the graph back and compiling it.
+	report := new(bytes.Buffer)
+	if err := g.WriteReport(report); err != nil {
+		t.Fatal(err)
+	}
+	written, err := NewDependencyGraph(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled := new(bytes.Buffer)
+	if err := CompileGraph(compiled, strings.NewReader(networkReport), CompiledSource{Size: 1, ModTime: time.Unix(1, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCompiledGraph(compiled, CompiledSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, other := range map[string]*DependencyGraph{"written": written, "compiled": loaded} {
+		step := CmdTree{"make"}
+		if diff := cmp.Diff(other.NetworkAccesses(step), g.NetworkAccesses(step)); diff != "" {
+			t.Errorf("%v graph: unexpected accesses, diff: %v", name, diff)
+		}
+	}
+}
+
+func TestNetworkPolicy(t *testing.T) {
+	g, err := NewDependencyGraph(strings.NewReader(networkReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagger := &Tagger{Manifest: map[string][]string{"go mod download": {"fetch"}}}
+	curl := CmdTree{"make", "curl example.com"}
+	download := CmdTree{"make", "go mod download"}
+	for _, tc := range []struct {
+		name   string
+		policy *NetworkPolicy
+		step   CmdTree
+		want   []string
+	}{
+		{"default", &NetworkPolicy{}, curl, []string{"93.184.216.34:443"}},
+		{"no network", &NetworkPolicy{}, CmdTree{"make", "go build"}, nil},
+		{"unknown step", &NetworkPolicy{}, CmdTree{"deploy"}, nil},
+		{"ignored", &NetworkPolicy{Ignore: true}, curl, nil},
+		{"nil policy", nil, curl, nil},
+		{"hermetic by tag", &NetworkPolicy{Hermetic: []AlwaysRunRule{{Tags: []string{"fetch"}}}}, download, nil},
+		{"hermetic by pattern", &NetworkPolicy{Hermetic: []AlwaysRunRule{{Pattern: regexp.MustCompile("^curl")}}}, curl, nil},
+		{"other step hermetic", &NetworkPolicy{Hermetic: []AlwaysRunRule{{Tags: []string{"fetch"}}}}, curl, []string{"93.184.216.34:443"}},
+	} {
+		if diff := cmp.Diff(tc.policy.MustRun(g, tc.step, tagger), tc.want); diff != "" {
+			t.Errorf("%v: unexpected result, diff: %v", tc.name, diff)
+		}
+	}
+}
+
+func TestNetworkReason(t *testing.T) {
+	got := NetworkReason(CmdTree{"fetch"}, []string{"a:1", "b:2", "c:3", "d:4", "e:5"})
+	want := `step "[\"fetch\"]" connected to the network (a:1, b:2, c:3 and 2 more) in the base build, so it can't be proven hermetic`
+	if got != want {
+		t.Errorf("got %q wanted %q", got, want)
+	}
+}
@@ -0,0 +1,120 @@
+package stepselection
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// maxNondeterministicExamples limits how many of the files that differ are
+// named in a "nondeterministic" finding.
+const maxNondeterministicExamples = 3
+
+// stepFiles are the files a step read and wrote in a build, without the ones
+// of its nested steps.
+type stepFiles struct {
+	reads  map[string]bool
+	writes map[string]bool
+}
+
+// LintNondeterminism compares build reports recorded by several runs of the
+// same build, without changes in between, and reports the steps that read or
+// wrote different files in some of them, like steps reading timestamps,
+// caches or temporary files with random names. Their edges in any single
+// report are partly accidental, so decisions about them are unreliable. Steps
+// missing from some of the reports aren't compared.
+func LintNondeterminism(buildReports []io.Reader) ([]Finding, error) {
+	if len(buildReports) < 2 {
+		return nil, nil
+	}
+	var runs []map[string]*stepFiles
+	for i, r := range buildReports {
+		steps, err := readStepFiles(r)
+		if err != nil {
+			return nil, fmt.Errorf("build report %d: %v", i+1, err)
+		}
+		runs = append(runs, steps)
+	}
+	var names []string
+	for name := range runs[0] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var findings []Finding
+	for _, name := range names {
+		var files []*stepFiles
+		for _, steps := range runs {
+			if f, ok := steps[name]; ok {
+				files = append(files, f)
+			}
+		}
+		if len(files) < len(runs) {
+			continue
+		}
+		reads := differingFiles(files, func(f *stepFiles) map[string]bool { return f.reads })
+		writes := differingFiles(files, func(f *stepFiles) map[string]bool { return f.writes })
+		if len(reads) == 0 && len(writes) == 0 {
+			continue
+		}
+		examples := append(reads, writes...)
+		if len(examples) > maxNondeterministicExamples {
+			examples = examples[:maxNondeterministicExamples]
+		}
+		findings = append(findings, Finding{Check: "nondeterministic", Step: name, File: examples[0],
+			Message: fmt.Sprintf("step %v read %d files and wrote %d files in only some of the %d builds, like %v, so its decisions are unreliable", name, len(reads), len(writes), len(runs), strings.Join(examples, ", "))})
+	}
+	return findings, nil
+}
+
+// readStepFiles returns the files of each step of buildReport, by name.
+// Entries that don't parse are left to Lint.
+func readStepFiles(buildReport io.Reader) (map[string]*stepFiles, error) {
+	steps := map[string]*stepFiles{}
+	scanner := newLineScanner(buildReport)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		bog, err := decodeEntry(line)
+		if err != nil || bog == nil {
+			continue
+		}
+		name := CmdTree(bog.CmdTree).Name()
+		s, ok := steps[name]
+		if !ok {
+			s = &stepFiles{reads: map[string]bool{}, writes: map[string]bool{}}
+			steps[name] = s
+		}
+		if bog.Type != "" {
+			continue
+		}
+		if bog.Reads() {
+			s.reads[bog.File] = true
+		} else {
+			s.writes[bog.File] = true
+		}
+	}
+	return steps, scanner.Err()
+}
+
+// differingFiles returns the files, sorted, that set returns for some of
+// runs but not all of them.
+func differingFiles(runs []*stepFiles, set func(*stepFiles) map[string]bool) []string {
+	count := map[string]int{}
+	for _, r := range runs {
+		for f := range set(r) {
+			count[f]++
+		}
+	}
+	var out []string
+	for f, n := range count {
+		if n < len(runs) {
+			out = append(out, f)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
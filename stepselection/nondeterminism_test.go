@@ -0,0 +1,44 @@
+package stepselection
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLintNondeterminism(t *testing.T) {
+	reports := []string{
+		`{"CmdTree":["make","cc"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["make","cc"],"Mode":"W","File":"/out/a.o"}
+{"CmdTree":["make","test"],"Mode":"R","File":"/out/a.o"}
+{"CmdTree":["make","test"],"Mode":"W","File":"/tmp/test-1234"}
+{"CmdTree":["make","stamp"],"Mode":"R","File":"/etc/timezone"}
+{"CmdTree":["make","cc"],"Type":"step","Duration":1000}
+`,
+		`{"CmdTree":["make","cc"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["make","cc"],"Mode":"W","File":"/out/a.o"}
+{"CmdTree":["make","test"],"Mode":"R","File":"/out/a.o"}
+{"CmdTree":["make","test"],"Mode":"W","File":"/tmp/test-5678"}
+{"CmdTree":["make","cc"],"Type":"step","Duration":2000}
+`,
+	}
+	var readers []io.Reader
+	for _, r := range reports {
+		readers = append(readers, strings.NewReader(r))
+	}
+	findings, err := LintNondeterminism(readers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Finding{{
+		Check:   "nondeterministic",
+		Step:    `["make","test"]`,
+		File:    "/tmp/test-1234",
+		Message: `step ["make","test"] read 0 files and wrote 2 files in only some of the 2 builds, like /tmp/test-1234, /tmp/test-5678, so its decisions are unreliable`,
+	}}
+	if diff := cmp.Diff(want, findings); diff != "" {
+		t.Errorf("unexpected findings (-want +got):\n%v", diff)
+	}
+}
@@ -0,0 +1,71 @@
+package stepselection
+
+import (
+	"regexp"
+	"strings"
+)
+
+// NameRule rewrites the parts of a command that match Pattern with
+// Replacement, as regexp.ReplaceAllString does, so that runs of a step that
+// only differ by noise get the same name.
+type NameRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// DefaultNameRules are the name rules applied unless configured otherwise.
+// They replace the parts of commands that change from run to run without
+// changing what the step does with "*".
+var DefaultNameRules = []NameRule{
+	// Temporary paths, like /tmp/go-build123456/b001/main.o.
+	{regexp.MustCompile(`(^|[\s='"])(/tmp|/var/tmp|/var/folders/[^\s/'"]+/[^\s/'"]+/T)/[^\s'"]+`), "${1}${2}/*"},
+	// Job counts, like make -j8 or ninja -j 16.
+	{regexp.MustCompile(`(^|\s)(-j\s*|--jobs[=\s])[0-9]+\b`), "${1}${2}*"},
+	// Random seeds, like pytest --randomly-seed=1234 or go test
+	// -test.shuffle=1234.
+	{regexp.MustCompile(`(^|\s)(--?[\w.-]*(?:seed|shuffle)[=\s])[0-9]+\b`), "${1}${2}*"},
+}
+
+var defaultNormalizer = &Normalizer{Rules: DefaultNameRules}
+
+// Normalizer applies name rules to the commands of steps, both when a graph
+// is loaded and when a step is looked up, so the recorded name and the one
+// looked up match. A nil Normalizer keeps names as they are.
+type Normalizer struct {
+	Rules []NameRule
+}
+
+// Command applies the rules, in order, to a single command.
+func (n *Normalizer) Command(command string) string {
+	if n == nil {
+		return command
+	}
+	for _, r := range n.Rules {
+		command = r.Pattern.ReplaceAllString(command, r.Replacement)
+	}
+	return command
+}
+
+// CmdTree applies the rules to each command of cmdTree. cmdTree itself is
+// left untouched.
+func (n *Normalizer) CmdTree(cmdTree CmdTree) CmdTree {
+	if n == nil || len(n.Rules) == 0 {
+		return cmdTree
+	}
+	normalized := make(CmdTree, len(cmdTree))
+	for i, command := range cmdTree {
+		normalized[i] = n.Command(command)
+	}
+	return normalized
+}
+
+func (n *Normalizer) String() string {
+	if n == nil {
+		return ""
+	}
+	var rules []string
+	for _, r := range n.Rules {
+		rules = append(rules, r.Pattern.String()+" => "+r.Replacement)
+	}
+	return strings.Join(rules, "\n")
+}
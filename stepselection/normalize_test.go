@@ -0,0 +1,97 @@
+package stepselection
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDefaultNameRules(t *testing.T) {
+	for _, tc := range []struct {
+		command, want string
+	}{
+		{"make -j8 all", "make -j* all"},
+		{"ninja -j 16", "ninja -j *"},
+		{"make --jobs=4 -j2", "make --jobs=* -j*"},
+		{"tar -jxf a.tar.bz2", "tar -jxf a.tar.bz2"},
+		{"ld -o /tmp/go-build123/b001/exe/a.out main.o", "ld -o /tmp/* main.o"},
+		{"cc -MF=/var/tmp/ccX1.d a.c", "cc -MF=/var/tmp/* a.c"},
+		{"cp /var/folders/x1/y2/T/tmp.a /tmpfile", "cp /var/folders/x1/y2/T/* /tmpfile"},
+		{"pytest --randomly-seed=1234 tests", "pytest --randomly-seed=* tests"},
+		{"go test -test.shuffle=99 ./...", "go test -test.shuffle=* ./..."},
+		{"go test -shuffle=on ./...", "go test -shuffle=on ./..."},
+		{"go test ./...", "go test ./..."},
+	} {
+		if got := defaultNormalizer.Command(tc.command); got != tc.want {
+			t.Errorf("Command(%q): got %q wanted %q", tc.command, got, tc.want)
+		}
+	}
+
+	var nilNormalizer *Normalizer
+	if got := nilNormalizer.Command("make -j8"); got != "make -j8" {
+		t.Errorf("a nil normalizer shouldn't change names, got %q", got)
+	}
+}
+
+func TestNormalizedLookups(t *testing.T) {
+	report := `{"CmdTree":["make -j8","cc -o /tmp/x1/a.o a.c"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["make -j8","cc -o /tmp/x1/a.o a.c"],"Mode":"W","File":"/src/a.o"}
+{"CmdTree":["make -j8","ld a.o --seed 42"],"Mode":"R","File":"/src/a.o"}
+`
+	seed := NameRule{Pattern: regexp.MustCompile(`--seed [0-9]+`), Replacement: "--seed *"}
+	opts := []Option{WithNormalizer(&Normalizer{Rules: append(append([]NameRule{}, DefaultNameRules...), seed)})}
+	g, err := NewDependencyGraph(strings.NewReader(report), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another run of the same build.
+	ld := CmdTree{"make -j4", "ld a.o --seed 7"}
+	run, _, err := g.StepDependsOnFiles(ld, []string{"/src/a.c"})
+	if err != nil || !run {
+		t.Errorf("StepDependsOnFiles(%q): got %v, %v wanted true", ld, run, err)
+	}
+	if !g.HasStep(CmdTree{"make -j16", "cc -o /tmp/x2/a.o a.c"}) {
+		t.Errorf("the compile step wasn't found under another temp dir")
+	}
+
+	open := func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(report)), nil
+	}
+	sub, err := NewStepDependencyGraph(open, ld, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run, _, err := sub.StepDependsOnFiles(ld, []string{"/src/a.c"}); err != nil || !run {
+		t.Errorf("step graph: got %v, %v wanted true", run, err)
+	}
+
+	raw, err := NewDependencyGraph(strings.NewReader(report), WithNormalizer(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.HasStep(ld) {
+		t.Errorf("steps shouldn't match without name rules")
+	}
+}
+
+func TestCompiledGraphNameRules(t *testing.T) {
+	report := `{"CmdTree":["make -j8"],"Mode":"R","File":"/src/a.c"}
+`
+	compiled := new(bytes.Buffer)
+	if err := CompileGraph(compiled, strings.NewReader(report), CompiledSource{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCompiledGraph(bytes.NewReader(compiled.Bytes()), CompiledSource{}, WithNormalizer(nil)); err != ErrStaleCompiledGraph {
+		t.Errorf("a graph compiled with other name rules: got %v wanted %v", err, ErrStaleCompiledGraph)
+	}
+	g, err := LoadCompiledGraph(bytes.NewReader(compiled.Bytes()), CompiledSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !g.HasStep(CmdTree{"make -j2"}) {
+		t.Errorf("the compiled graph doesn't normalize lookups")
+	}
+}
@@ -0,0 +1,96 @@
+package stepselection
+
+// Option configures how NewDependencyGraph builds a graph.
+type Option func(*options)
+
+type options struct {
+	overlays   []*Overlay
+	ignore     *PathMatcher
+	hermetic   *PathMatcher
+	temp       *PathMatcher
+	normalizer *Normalizer
+	roots      *RootMapping
+	overrides  []StepOverride
+	// skipCorrupt skips the lines of the build report that don't parse.
+	skipCorrupt bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{ignore: defaultIgnore, hermetic: defaultHermetic, temp: defaultTemp, normalizer: defaultNormalizer}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithOverlay applies the edits in o on top of the build report. Overlays
+// are applied in the order they're given.
+func WithOverlay(o *Overlay) Option {
+	return func(opts *options) {
+		opts.overlays = append(opts.overlays, o)
+	}
+}
+
+// WithIgnore drops the build report entries whose files match m, instead
+// of the files matching DefaultIgnorePatterns. A nil m keeps every entry.
+func WithIgnore(m *PathMatcher) Option {
+	return func(opts *options) {
+		opts.ignore = m
+	}
+}
+
+// WithHermetic makes lookups leave out the changes of the files that match
+// m, instead of the files matching DefaultHermeticPatterns. Unlike ignored
+// files, they stay in the graph, for explaining it. A nil m leaves out
+// nothing, for deciding which steps a toolchain change affects.
+func WithHermetic(m *PathMatcher) Option {
+	return func(opts *options) {
+		opts.hermetic = m
+	}
+}
+
+// WithTemp drops the entries of the files that match m and that no step
+// reads but the ones writing them, instead of the files matching
+// DefaultTempPatterns. Such scratch files, like the intermediate files of a
+// compiler, make graphs larger and create edges between unrelated steps
+// that happen to use the same temporary names. A nil m keeps every entry.
+func WithTemp(m *PathMatcher) Option {
+	return func(opts *options) {
+		opts.temp = m
+	}
+}
+
+// WithNormalizer rewrites step names with n, instead of with
+// DefaultNameRules. A nil n keeps names as recorded.
+func WithNormalizer(n *Normalizer) Option {
+	return func(opts *options) {
+		opts.normalizer = n
+	}
+}
+
+// WithRootMapping rewrites the files of the build report with m before
+// they're normalized, so a report recorded in another checkout matches the
+// files of this one. Overlays are taken to be about this checkout already.
+func WithRootMapping(m *RootMapping) Option {
+	return func(opts *options) {
+		opts.roots = m
+	}
+}
+
+// WithStepOverrides adds the declared inputs and outputs of the overrides to
+// the steps of the build report they apply to.
+func WithStepOverrides(overrides []StepOverride) Option {
+	return func(opts *options) {
+		opts.overrides = overrides
+	}
+}
+
+// WithSkipCorruptLines skips the lines of the build report that don't parse,
+// like a line truncated by an interrupted upload, instead of failing to load
+// the report. The skipped lines are counted and logged with their line
+// numbers. Headers of unsupported versions still fail.
+func WithSkipCorruptLines() Option {
+	return func(opts *options) {
+		opts.skipCorrupt = true
+	}
+}
@@ -0,0 +1,108 @@
+package stepselection
+
+import "time"
+
+// stamp is when a step accessed a file, in Unix nanoseconds, in the build
+// that recorded the access. Only the times of the same build are compared:
+// builds merged into a graph ran at different times, and shards of a build
+// on clocks that may disagree. The zero stamp is an unknown time.
+type stamp struct {
+	build string
+	at    int64
+}
+
+// entryStamp returns when the access of bog happened, if it was recorded.
+func entryStamp(bog *BuildLog) stamp {
+	if bog.Time == nil || bog.BuildID == "" {
+		return stamp{}
+	}
+	return stamp{build: bog.BuildID, at: bog.Time.UnixNano()}
+}
+
+// time returns the time of s, or nil if it's unknown.
+func (s stamp) time() *time.Time {
+	if s.at == 0 {
+		return nil
+	}
+	t := time.Unix(0, s.at).UTC()
+	return &t
+}
+
+// mergeStamps returns the later of a and b, or the earlier unless later is
+// set. Times that can't be compared merge into an unknown time.
+func mergeStamps(a, b stamp, later bool) stamp {
+	if a.at == 0 || b.at == 0 || a.build != b.build {
+		return stamp{}
+	}
+	if (b.at > a.at) == later {
+		return b
+	}
+	return a
+}
+
+// recordRead records that s read file at, keeping the last read, after
+// which a write of the file can't have been read.
+func (s *step) recordRead(file string, at stamp) {
+	if at.at == 0 && s.readTimes == nil {
+		return
+	}
+	if prev, ok := s.readTimes[file]; ok {
+		at = mergeStamps(prev, at, true)
+	} else if s.readFiles[file] {
+		// Read before at an unknown time.
+		at = stamp{}
+	}
+	if s.readTimes == nil {
+		s.readTimes = map[string]stamp{}
+	}
+	s.readTimes[file] = at
+}
+
+// recordWrite records that s wrote file at, keeping the first write, before
+// which no step read what s wrote. wrote is whether s already wrote file.
+func (s *step) recordWrite(file string, at stamp, wrote bool) {
+	if at.at == 0 && s.writeTimes == nil {
+		return
+	}
+	if prev, ok := s.writeTimes[file]; ok {
+		at = mergeStamps(prev, at, false)
+	} else if wrote {
+		at = stamp{}
+	}
+	if s.writeTimes == nil {
+		s.writeTimes = map[string]stamp{}
+	}
+	s.writeTimes[file] = at
+}
+
+// produces reports whether w may have written file before r read it, which
+// makes w a producer of r. Without comparable times, it's assumed to. A
+// write after the last read is of a new version of the file, like a log the
+// build appends to or an output a later step overwrites, which r didn't use.
+func produces(w, r *step, file string) bool {
+	wrote := w.writeTimes[file]
+	read := r.readTimes[file]
+	if wrote.at == 0 || read.at == 0 || wrote.build != read.build {
+		return true
+	}
+	return wrote.at <= read.at
+}
+
+// entryTime returns the time of at for the entries of s, which are written
+// with the build of s, or nil if at is of another build.
+func (s *step) entryTime(at stamp) *time.Time {
+	if at.build != s.build {
+		return nil
+	}
+	return at.time()
+}
+
+// hasStep reports whether steps has s.
+func hasStep(steps []*step, s *step) bool {
+	for _, x := range steps {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
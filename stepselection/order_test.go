@@ -0,0 +1,91 @@
+package stepselection
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestHappensBefore(t *testing.T) {
+	// gen writes the header cc reads, then fmt rewrites it after cc is
+	// done, so cc doesn't depend on what fmt reads.
+	entries := `{"CmdTree":["gen"],"Mode":"R","File":"/src/gen.py","BuildID":"b1","Time":"2020-01-01T00:00:01Z"}
+{"CmdTree":["gen"],"Mode":"W","File":"/out/cfg.h","BuildID":"b1","Time":"2020-01-01T00:00:02Z"}
+{"CmdTree":["cc"],"Mode":"R","File":"/out/cfg.h","BuildID":"b1","Time":"2020-01-01T00:00:03Z"}
+{"CmdTree":["cc"],"Mode":"W","File":"/out/a.o","BuildID":"b1","Time":"2020-01-01T00:00:04Z"}
+{"CmdTree":["fmt"],"Mode":"R","File":"/src/fmt.toml","BuildID":"FMT","Time":"2020-01-01T00:00:05Z"}
+{"CmdTree":["fmt"],"Mode":"W","File":"/out/cfg.h","BuildID":"FMT","Time":"2020-01-01T00:00:06Z"}
+`
+	// fmtTimes matches the times of the entries of fmt.
+	fmtTimes := regexp.MustCompile(`,"Time":"2020-01-01T00:00:0[56]Z"`)
+	for _, tc := range []struct {
+		name, fmtBuild string
+		fmtTimes       bool
+		dependsOnFmt   bool
+	}{
+		{"same build", "b1", true, false},
+		// Times of different builds can't be compared.
+		{"other build", "b2", true, true},
+		{"no times", "b1", false, true},
+	} {
+		report := strings.ReplaceAll(entries, "FMT", tc.fmtBuild)
+		if !tc.fmtTimes {
+			report = fmtTimes.ReplaceAllString(report, "")
+		}
+		g, err := NewDependencyGraph(strings.NewReader(report))
+		if err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+		compiled := new(bytes.Buffer)
+		if err := CompileGraph(compiled, strings.NewReader(report), CompiledSource{}); err != nil {
+			t.Fatal(err)
+		}
+		cg, err := LoadCompiledGraph(compiled, CompiledSource{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		written := new(bytes.Buffer)
+		if err := g.WriteReport(written); err != nil {
+			t.Fatal(err)
+		}
+		wg, err := NewDependencyGraph(written)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, g := range map[string]*DependencyGraph{"loaded": g, "compiled": cg, "written": wg} {
+			if depends, _, err := g.StepDependsOnFiles(CmdTree{"cc"}, []string{"/src/gen.py"}); err != nil || !depends {
+				t.Errorf("%v, %v graph: got %v, %v, wanted cc to depend on gen.py", tc.name, name, depends, err)
+			}
+			depends, _, err := g.StepDependsOnFiles(CmdTree{"cc"}, []string{"/src/fmt.toml"})
+			if err != nil || depends != tc.dependsOnFmt {
+				t.Errorf("%v, %v graph: got %v, %v, wanted cc depending on fmt.toml to be %v", tc.name, name, depends, err, tc.dependsOnFmt)
+			}
+		}
+		affected := strings.Join(g.StepsAffectedBy([]string{"/src/fmt.toml"}), " ")
+		if strings.Contains(affected, `"cc"`) != tc.dependsOnFmt {
+			t.Errorf("%v: got steps affected %v", tc.name, affected)
+		}
+		chains, err := g.DependencyChains(CmdTree{"cc"}, []string{"/src/fmt.toml"})
+		if err != nil || (len(chains) > 0) != tc.dependsOnFmt {
+			t.Errorf("%v: got chains %q, %v", tc.name, chains, err)
+		}
+	}
+}
+
+func TestReadTimes(t *testing.T) {
+	// The last read counts, and a read without a time could be any time.
+	s := &step{readFiles: map[string]bool{}}
+	for _, at := range []int64{5, 9, 7} {
+		s.recordRead("/a", stamp{"b", at})
+		s.readFiles["/a"] = true
+	}
+	if got := s.readTimes["/a"]; got.at != 9 {
+		t.Errorf("got read time %v, wanted 9", got)
+	}
+	s.readFiles["/b"] = true
+	s.recordRead("/b", stamp{"b", 3})
+	if got := s.readTimes["/b"]; got.at != 0 {
+		t.Errorf("got read time %v for a file read at an unknown time, wanted none", got)
+	}
+}
@@ -0,0 +1,113 @@
+package stepselection
+
+import "fmt"
+
+// Overlay corrects a build report at load time, so known capture errors can
+// be fixed declaratively and kept in version control. Edits are applied to
+// the report's entries before they become graph edges, so they propagate to
+// ancestor steps exactly like regular entries do.
+type Overlay struct {
+	// Source identifies the overlay, usually by its file name. Decisions
+	// that depend on edges added by the overlay mention it.
+	Source string
+	Add    []OverlayEdit
+	Remove []OverlayEdit
+}
+
+// OverlayEdit describes edges to add to or remove from a graph.
+//
+// When removing, an empty Step matches every step and a Step matches its
+// descendants too. An edit without Reads, Writes or Files removes every edge
+// of the step, which removes the step itself. Files matches edges in either
+// direction, so an edit with only Files removes those file nodes entirely.
+type OverlayEdit struct {
+	Step   CmdTree
+	Reads  []string
+	Writes []string
+	Files  []string
+}
+
+// matches reports whether the edit removes bog. The edit's files must have
+// been normalized by removals.
+func (e *OverlayEdit) matches(bog *BuildLog) bool {
+	if len(bog.CmdTree) < len(e.Step) {
+		return false
+	}
+	for i := range e.Step {
+		if bog.CmdTree[i] != e.Step[i] {
+			return false
+		}
+	}
+	if len(e.Reads) == 0 && len(e.Writes) == 0 && len(e.Files) == 0 {
+		return true
+	}
+	files := e.Files
+	if bog.Reads() {
+		files = append(files[:len(files):len(files)], e.Reads...)
+	} else {
+		files = append(files[:len(files):len(files)], e.Writes...)
+	}
+	for _, f := range files {
+		if f == bog.File {
+			return true
+		}
+	}
+	return false
+}
+
+// entries returns the build log entries added by the overlay.
+func (o *Overlay) entries() []*BuildLog {
+	var logs []*BuildLog
+	for _, e := range o.Add {
+		for _, f := range e.Reads {
+			logs = append(logs, &BuildLog{CmdTree: e.Step, Mode: "R", File: normalizePath(f)})
+		}
+		for _, f := range e.Writes {
+			logs = append(logs, &BuildLog{CmdTree: e.Step, Mode: "W", File: normalizePath(f)})
+		}
+	}
+	return logs
+}
+
+// removals returns the Remove edits of overlays, with their files
+// normalized once rather than for every build log entry.
+func removals(overlays []*Overlay) []OverlayEdit {
+	var edits []OverlayEdit
+	for _, o := range overlays {
+		for _, e := range o.Remove {
+			edits = append(edits, OverlayEdit{
+				Step:   e.Step,
+				Reads:  normalizePaths(e.Reads),
+				Writes: normalizePaths(e.Writes),
+				Files:  normalizePaths(e.Files),
+			})
+		}
+	}
+	return edits
+}
+
+func normalizePaths(files []string) []string {
+	var out []string
+	for _, f := range files {
+		out = append(out, normalizePath(f))
+	}
+	return out
+}
+
+func removedByOverlay(edits []OverlayEdit, bog *BuildLog) bool {
+	for i := range edits {
+		if edits[i].matches(bog) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlayNote explains that a decision depends on an edge added by overlay
+// source. It returns an empty string if source is empty.
+func overlayNote(source string) string {
+	if source == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (edge added by overlay %v)", source)
+}
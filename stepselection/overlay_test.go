@@ -0,0 +1,55 @@
+package stepselection
+
+import (
+	"strings"
+	"testing"
+)
+
+const overlayTestReport = `{"CmdTree":["make"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["make"],"Mode":"W","File":"/out/a.o"}
+{"CmdTree":["make"],"Mode":"R","File":"/tmp/noise"}
+{"CmdTree":["link"],"Mode":"R","File":"/out/a.o"}
+`
+
+func TestOverlay(t *testing.T) {
+	overlay := &Overlay{
+		Source: "fixes.yaml",
+		Add: []OverlayEdit{
+			{Step: CmdTree{"link"}, Reads: []string{"/src/linker.ld"}},
+		},
+		Remove: []OverlayEdit{
+			{Files: []string{"/tmp/noise"}},
+		},
+	}
+	g, err := NewDependencyGraph(strings.NewReader(overlayTestReport), WithOverlay(overlay))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	depends, _, err := g.StepDependsOnFiles(CmdTree{"make"}, []string{"/tmp/noise"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if depends {
+		t.Errorf("the overlay should have removed the dependency on /tmp/noise")
+	}
+
+	depends, reason, err := g.StepDependsOnFiles(CmdTree{"link"}, []string{"/src/linker.ld"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !depends {
+		t.Errorf("the overlay should have added a dependency on /src/linker.ld")
+	}
+	if !strings.Contains(reason, "fixes.yaml") {
+		t.Errorf("reason %q does not mention the overlay", reason)
+	}
+
+	depends, reason, err = g.StepDependsOnFiles(CmdTree{"link"}, []string{"/src/a.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !depends || strings.Contains(reason, "overlay") {
+		t.Errorf("got depends=%v reason %q, wanted a dependency that doesn't come from the overlay", depends, reason)
+	}
+}
@@ -0,0 +1,109 @@
+package stepselection
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// StepOverride changes how the steps whose own command matches Pattern are
+// named and decided, for steps the graph can't describe well on its own.
+type StepOverride struct {
+	Pattern *regexp.Regexp
+	// Alias is the command the step has in the graph, if it differs from
+	// the current one, like when the base build was recorded through a
+	// wrapper script. Steps named Alias match the override too.
+	Alias string
+	// Run is "always" to run the steps regardless of the graph, "never"
+	// to always skip them, or empty to decide them from the graph.
+	Run    string
+	Reason string
+	// Inputs and Outputs are files the steps read and write in addition
+	// to the ones recorded in the graph.
+	Inputs  []string
+	Outputs []string
+}
+
+// Matches reports whether the override applies to cmdTree.
+func (o *StepOverride) Matches(cmdTree CmdTree) bool {
+	if len(cmdTree) == 0 {
+		return false
+	}
+	command := cmdTree[len(cmdTree)-1]
+	return o.Pattern.MatchString(command) || o.Alias != "" && o.Alias == command
+}
+
+func (o *StepOverride) String() string {
+	s := fmt.Sprintf("step override with pattern %q", o.Pattern)
+	if o.Reason != "" {
+		s += ": " + o.Reason
+	}
+	return s
+}
+
+// MatchOverride returns the first override in overrides that applies to
+// cmdTree, or nil.
+func MatchOverride(overrides []StepOverride, cmdTree CmdTree) *StepOverride {
+	for i := range overrides {
+		if overrides[i].Matches(cmdTree) {
+			return &overrides[i]
+		}
+	}
+	return nil
+}
+
+// AliasStep returns cmdTree with its own command replaced by the Alias of
+// the first override that applies to it, if any.
+func AliasStep(overrides []StepOverride, cmdTree CmdTree) CmdTree {
+	o := MatchOverride(overrides, cmdTree)
+	if o == nil || o.Alias == "" {
+		return cmdTree
+	}
+	aliased := append(CmdTree(nil), cmdTree...)
+	aliased[len(aliased)-1] = o.Alias
+	return aliased
+}
+
+// OverrideDecision returns whether cmdTree must run according to the first
+// override that applies to it, and why. decided is false if no override
+// decides cmdTree, and the graph must.
+func OverrideDecision(overrides []StepOverride, cmdTree CmdTree) (run bool, reason string, decided bool) {
+	o := MatchOverride(overrides, cmdTree)
+	if o == nil || o.Run == "" {
+		return false, "", false
+	}
+	verb := "runs"
+	if o.Run == "never" {
+		verb = "is skipped"
+	}
+	return o.Run == "always", fmt.Sprintf("step %q %v because of the %v", cmdTree.Name(), verb, o), true
+}
+
+// addOverrideEntries calls add with the entries adding the declared inputs
+// and outputs of overrides to the steps of cmdTrees they apply to.
+func addOverrideEntries(overrides []StepOverride, cmdTrees []CmdTree, add func(bog *BuildLog, provenance string)) {
+	for _, cmdTree := range cmdTrees {
+		o := MatchOverride(overrides, cmdTree)
+		if o == nil {
+			continue
+		}
+		for _, f := range o.Inputs {
+			add(&BuildLog{CmdTree: cmdTree, Mode: "R", File: intern(normalizePath(f))}, o.String())
+		}
+		for _, f := range o.Outputs {
+			add(&BuildLog{CmdTree: cmdTree, Mode: "W", File: intern(normalizePath(f))}, o.String())
+		}
+	}
+}
+
+// overridesFingerprint describes the overrides that change the graph, for
+// optionsFingerprint.
+func overridesFingerprint(overrides []StepOverride) string {
+	var parts []string
+	for _, o := range overrides {
+		if len(o.Inputs) > 0 || len(o.Outputs) > 0 {
+			parts = append(parts, fmt.Sprintf("%q %q %q %q", o.Pattern, o.Alias, o.Inputs, o.Outputs))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
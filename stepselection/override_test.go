@@ -0,0 +1,64 @@
+package stepselection
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStepOverrides(t *testing.T) {
+	overrides := []StepOverride{
+		{Pattern: regexp.MustCompile("^make all$"), Alias: "./ci/build.sh"},
+		{Pattern: regexp.MustCompile("^deploy"), Run: "always", Reason: "deploys have side effects"},
+		{Pattern: regexp.MustCompile("^lint-docs"), Run: "never"},
+	}
+	if diff := cmp.Diff(AliasStep(overrides, CmdTree{"ci", "make all"}), CmdTree{"ci", "./ci/build.sh"}); diff != "" {
+		t.Errorf("unexpected alias, diff: %v", diff)
+	}
+	if diff := cmp.Diff(AliasStep(overrides, CmdTree{"make test"}), CmdTree{"make test"}); diff != "" {
+		t.Errorf("unexpected alias, diff: %v", diff)
+	}
+	// Aliased steps still match their override.
+	if o := MatchOverride(overrides, CmdTree{"./ci/build.sh"}); o != &overrides[0] {
+		t.Errorf("got override %v for the alias, wanted the first one", o)
+	}
+	for _, tc := range []struct {
+		step    CmdTree
+		run     bool
+		reason  string
+		decided bool
+	}{
+		{CmdTree{"deploy prod"}, true, `step "[\"deploy prod\"]" runs because of the step override with pattern "^deploy": deploys have side effects`, true},
+		{CmdTree{"lint-docs"}, false, `step "[\"lint-docs\"]" is skipped because of the step override with pattern "^lint-docs"`, true},
+		{CmdTree{"make all"}, false, "", false},
+		{CmdTree{"go build"}, false, "", false},
+	} {
+		run, reason, decided := OverrideDecision(overrides, tc.step)
+		if run != tc.run || reason != tc.reason || decided != tc.decided {
+			t.Errorf("OverrideDecision(%q) = %v, %q, %v, wanted %v, %q, %v", tc.step, run, reason, decided, tc.run, tc.reason, tc.decided)
+		}
+	}
+}
+
+func TestStepOverrideInputs(t *testing.T) {
+	report := `{"CmdTree":["go generate"],"Mode":"W","File":"/src/api/generated.go"}
+{"CmdTree":["go build"],"Mode":"R","File":"/src/api/generated.go"}
+`
+	overrides := []StepOverride{{Pattern: regexp.MustCompile("^go generate"), Inputs: []string{"/src/api/schema.graphql"}}}
+	g, err := NewDependencyGraph(strings.NewReader(report), WithStepOverrides(overrides))
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, reason, err := g.StepDependsOnFiles(CmdTree{"go build"}, []string{"/src/api/schema.graphql"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !run || !strings.Contains(reason, `step override with pattern "^go generate"`) {
+		t.Errorf("got %v, %q, wanted the step to run because of the override", run, reason)
+	}
+	if optionsFingerprint(nil) == optionsFingerprint([]Option{WithStepOverrides(overrides)}) {
+		t.Errorf("the overrides don't change the options fingerprint")
+	}
+}
@@ -0,0 +1,127 @@
+package stepselection
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+)
+
+// parseBatchSize is how many lines of a build report a worker parses at a
+// time. Batches amortize the synchronization between the workers.
+const parseBatchSize = 256
+
+// parsedLine is a line of a build report, decoded and normalized.
+type parsedLine struct {
+	line int
+	// bog is nil for blank lines and headers, and keep false for the
+	// entries the options drop.
+	bog  *BuildLog
+	keep bool
+	err  error
+}
+
+// parseBatch is a run of lines of a build report, starting at line start,
+// parsed once done is closed.
+type parseBatch struct {
+	start  int
+	lines  [][]byte
+	parsed []parsedLine
+	done   chan struct{}
+}
+
+// parseLines calls f with the lines of buildReport parsed by parse, in
+// order. Decoding and normalizing entries dominate the time it takes to
+// load large reports, so lines are parsed by a worker per CPU, while f
+// runs on the calling goroutine. It stops at the first error of f.
+func parseLines(buildReport io.Reader, parse func(b []byte) parsedLine, f func(p *parsedLine) error) error {
+	workers := runtime.GOMAXPROCS(0)
+	work := make(chan *parseBatch)
+	// ordered holds the batches in the order of the report, bounding how
+	// far the workers get ahead of f.
+	ordered := make(chan *parseBatch, 2*workers)
+	stop := make(chan struct{})
+	defer close(stop)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for batch := range work {
+				batch.parsed = make([]parsedLine, len(batch.lines))
+				for i, b := range batch.lines {
+					batch.parsed[i] = parse(b)
+					batch.parsed[i].line = batch.start + i
+				}
+				close(batch.done)
+			}
+		}()
+	}
+	var scanErr error
+	go func() {
+		defer close(ordered)
+		defer close(work)
+		scanner := newLineScanner(buildReport)
+		batch := &parseBatch{start: 1, done: make(chan struct{})}
+		send := func() bool {
+			select {
+			case ordered <- batch:
+			case <-stop:
+				return false
+			}
+			select {
+			case work <- batch:
+			case <-stop:
+				return false
+			}
+			batch = &parseBatch{start: batch.start + len(batch.lines), done: make(chan struct{})}
+			return true
+		}
+		for scanner.Scan() {
+			// The scanner reuses its buffer.
+			batch.lines = append(batch.lines, append([]byte(nil), scanner.Bytes()...))
+			if len(batch.lines) == parseBatchSize && !send() {
+				return
+			}
+		}
+		if len(batch.lines) > 0 && !send() {
+			return
+		}
+		scanErr = scanner.Err()
+	}()
+	for batch := range ordered {
+		<-batch.done
+		for i := range batch.parsed {
+			if err := f(&batch.parsed[i]); err != nil {
+				return err
+			}
+		}
+	}
+	// ordered is closed after scanErr is set.
+	return scanErr
+}
+
+// parseLine decodes and normalizes the line b of a build report, applying
+// the options. removed are the entries the overlays remove.
+func (o *options) parseLine(b []byte, removed []OverlayEdit) parsedLine {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		// Reports concatenated by hand often have blank lines.
+		return parsedLine{}
+	}
+	bog, err := decodeEntry(b)
+	if err != nil || bog == nil {
+		return parsedLine{err: err}
+	}
+	bog.CmdTree = o.normalizer.CmdTree(bog.CmdTree)
+	bog.BuildID = intern(bog.BuildID)
+	if bog.Type == "step" || bog.Type == "net" || bog.Type == "env" || bog.Type == "tool" {
+		bog.File = intern(bog.File)
+		return parsedLine{bog: bog, keep: !removedByOverlay(removed, bog)}
+	}
+	// normalizePath is very important here. If the graph says a process
+	// is working on file "F1", we normalize that to an absolute path based
+	// on the current path. That's not ideal, see the comment in
+	// absoluteNodePath.
+	bog.File = intern(normalizePath(o.roots.Map(bog.File)))
+	if o.ignore.Match(bog.File) || removedByOverlay(removed, bog) {
+		return parsedLine{bog: bog}
+	}
+	return parsedLine{bog: bog, keep: true}
+}
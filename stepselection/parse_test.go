@@ -0,0 +1,53 @@
+package stepselection
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseLinesInOrder(t *testing.T) {
+	// Enough lines for every worker to get batches.
+	n := 20*parseBatchSize + 7
+	var report strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&report, "%d\n", i)
+	}
+	next := 1
+	err := parseLines(strings.NewReader(report.String()), func(b []byte) parsedLine {
+		return parsedLine{err: errors.New(string(b))}
+	}, func(p *parsedLine) error {
+		if p.line != next || p.err.Error() != fmt.Sprint(next) {
+			return fmt.Errorf("got line %d, %v, wanted line %d", p.line, p.err, next)
+		}
+		next++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != n+1 {
+		t.Errorf("got %d lines, wanted %d", next-1, n)
+	}
+}
+
+func TestParseFirstInvalidLine(t *testing.T) {
+	var report strings.Builder
+	report.WriteString(`{"SkipperReport":1}` + "\n")
+	for i := 0; i < 5*parseBatchSize; i++ {
+		if i%100 == 42 {
+			report.WriteString("{corrupt\n")
+			continue
+		}
+		fmt.Fprintf(&report, `{"CmdTree":["cc %d"],"Mode":"W","File":"/out/%d.o"}`+"\n", i, i)
+	}
+	// Later batches are often parsed first, but the error is still the one
+	// of the first invalid line.
+	for i := 0; i < 10; i++ {
+		_, err := NewDependencyGraph(strings.NewReader(report.String()))
+		if err == nil || !strings.Contains(err.Error(), "line 44:") {
+			t.Fatalf("got %v, wanted an error on line 44", err)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package stepselection
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// normalizePath turns a path into the form used for graph nodes: absolute,
+// clean and with symlinks resolved, so that a file reached through a
+// symlinked path matches the real path recorded in the build log. Every path
+// that is compared with graph nodes, whether it comes from a build report,
+// an overlay or the changed files, must go through it.
+//
+// Paths that don't exist, such as deleted files, keep their missing
+// components as they are, after resolving their longest existing parent.
+//
+// Nodes are always slash-separated. See toNodePath for Windows paths.
+func normalizePath(p string) string {
+	return nodePaths.normalize(absoluteNodePath(p))
+}
+
+var nodePaths = &pathNormalizer{dirs: map[string]string{}}
+
+// pathNormalizer resolves symlinks, caching the resolved directories since
+// a build report has many files in few directories.
+type pathNormalizer struct {
+	mu   sync.Mutex
+	dirs map[string]string
+}
+
+func (n *pathNormalizer) normalize(p string) string {
+	p = path.Clean(p)
+	if !path.IsAbs(p) || p == "/" {
+		return p
+	}
+	n.mu.Lock()
+	dir := n.resolveDir(path.Dir(p))
+	n.mu.Unlock()
+	p = path.Join(dir, path.Base(p))
+	if fi, err := os.Lstat(nativePath(p)); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		if resolved, err := filepath.EvalSymlinks(nativePath(p)); err == nil {
+			return toNodePath(resolved)
+		}
+	}
+	return p
+}
+
+// resolveDir returns dir with its symlinks resolved. n.mu must be held.
+func (n *pathNormalizer) resolveDir(dir string) string {
+	if resolved, ok := n.dirs[dir]; ok {
+		return resolved
+	}
+	resolved, err := filepath.EvalSymlinks(nativePath(dir))
+	resolved = toNodePath(resolved)
+	if err != nil {
+		resolved = dir
+		if dir != "/" {
+			resolved = path.Join(n.resolveDir(path.Dir(dir)), path.Base(dir))
+		}
+	}
+	n.dirs[dir] = resolved
+	return resolved
+}
+
+// toNodePath converts a native path to the form of graph nodes. It only
+// changes Windows paths, see windowsNodePath.
+func toNodePath(p string) string {
+	if runtime.GOOS == "windows" {
+		return windowsNodePath(p)
+	}
+	return p
+}
+
+// nativePath converts a graph node back to a path for the filesystem.
+func nativePath(node string) string {
+	if runtime.GOOS == "windows" {
+		return windowsNativePath(node)
+	}
+	return node
+}
+
+// windowsNodePath turns a Windows path into a node: separators become
+// slashes and paths on a drive are rooted under it, so C:\src\a.c becomes
+// /c:/src/a.c and can be handled like a POSIX path. Windows filesystems are
+// case-insensitive, so the path is lowercased too, and the same file always
+// maps to the same node however it was spelled.
+func windowsNodePath(p string) string {
+	p = strings.ToLower(strings.Replace(p, `\`, "/", -1))
+	if hasDriveLetter(p) {
+		p = "/" + p
+	}
+	return p
+}
+
+// windowsNativePath is the inverse of windowsNodePath, except for the case
+// of the path, which doesn't matter on Windows.
+func windowsNativePath(node string) string {
+	if strings.HasPrefix(node, "/") && hasDriveLetter(node[1:]) {
+		node = node[1:]
+		if len(node) == 2 {
+			// The root of the drive, not its current directory.
+			node += "/"
+		}
+	}
+	return strings.Replace(node, "/", `\`, -1)
+}
+
+func hasDriveLetter(p string) bool {
+	if len(p) < 2 || p[1] != ':' {
+		return false
+	}
+	c := p[0] | 0x20
+	return 'a' <= c && c <= 'z'
+}
+
+// RootMapping moves paths from the checkout a graph was recorded in to the
+// current checkout, so that a graph recorded under /workspace/project can be
+// used in a checkout at /home/ci/project. A nil RootMapping leaves paths as
+// they are.
+type RootMapping struct {
+	// Graph is the root of the checkout the graph was recorded in. Empty
+	// leaves absolute paths as they are.
+	Graph string
+	// Workspace is the root of the current checkout.
+	Workspace string
+}
+
+// Map rewrites p for the current checkout, in the form of graph nodes.
+// Paths under Graph are moved under Workspace, and relative paths are
+// relative to Workspace instead of the current directory. Other paths are
+// unchanged.
+func (m *RootMapping) Map(p string) string {
+	if m == nil {
+		return p
+	}
+	node := toNodePath(p)
+	workspace := path.Clean(toNodePath(m.Workspace))
+	if !path.IsAbs(node) {
+		return path.Join(workspace, node)
+	}
+	if m.Graph == "" {
+		return node
+	}
+	node = path.Clean(node)
+	graph := path.Clean(toNodePath(m.Graph))
+	if node == graph {
+		return workspace
+	}
+	if inDir(node, graph) {
+		return path.Join(workspace, strings.TrimPrefix(node, graph))
+	}
+	return node
+}
+
+func (m *RootMapping) String() string {
+	if m == nil {
+		return ""
+	}
+	return m.Graph + "=" + m.Workspace
+}
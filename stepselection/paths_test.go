@@ -0,0 +1,149 @@
+package stepselection
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizePath(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "skipper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	// The temporary directory may itself be behind a symlink.
+	dir, err := filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "real", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "real", "a.c"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "real"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a.c", filepath.Join(dir, "real", "b.c")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct{ in, want string }{
+		{dir + "/link/a.c", dir + "/real/a.c"},
+		{dir + "/real/b.c", dir + "/real/a.c"},
+		{dir + "/link/sub/../a.c", dir + "/real/a.c"},
+		{dir + "/link/missing/x.c", dir + "/real/missing/x.c"},
+		{"/dev/null", "/dev/null"},
+	} {
+		if got := normalizePath(tc.in); got != tc.want {
+			t.Errorf("normalizePath(%q): got %q wanted %q", tc.in, got, tc.want)
+		}
+	}
+
+	report := `{"CmdTree":["cc"],"Mode":"R","File":"` + dir + `/real/a.c"}`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	depends, _, err := g.StepDependsOnFiles(CmdTree{"cc"}, []string{dir + "/link/a.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !depends {
+		t.Errorf("a change through a symlinked path didn't match the real path")
+	}
+}
+
+func TestWindowsNodePath(t *testing.T) {
+	for _, tc := range []struct{ native, node, back string }{
+		{`C:\src\Main.c`, "/c:/src/main.c", `c:\src\main.c`},
+		{`c:/src/main.c`, "/c:/src/main.c", `c:\src\main.c`},
+		{`D:`, "/d:", `d:\`},
+		{`src\Util.h`, "src/util.h", `src\util.h`},
+	} {
+		node := windowsNodePath(tc.native)
+		if node != tc.node {
+			t.Errorf("windowsNodePath(%q): got %q wanted %q", tc.native, node, tc.node)
+		}
+		if got := windowsNativePath(node); got != tc.back {
+			t.Errorf("windowsNativePath(%q): got %q wanted %q", node, got, tc.back)
+		}
+	}
+}
+
+func TestRootMapping(t *testing.T) {
+	m := &RootMapping{Graph: "/workspace/project", Workspace: "/home/ci/project"}
+	for _, tc := range []struct{ in, want string }{
+		{"/workspace/project/src/a.c", "/home/ci/project/src/a.c"},
+		{"/workspace/project", "/home/ci/project"},
+		{"/workspace/project2/a.c", "/workspace/project2/a.c"},
+		{"/usr/include/stdio.h", "/usr/include/stdio.h"},
+		{"src/a.c", "/home/ci/project/src/a.c"},
+		{"../other/a.c", "/home/ci/other/a.c"},
+	} {
+		if got := m.Map(tc.in); got != tc.want {
+			t.Errorf("Map(%q): got %q wanted %q", tc.in, got, tc.want)
+		}
+	}
+	var nilMapping *RootMapping
+	if got := nilMapping.Map("src/a.c"); got != "src/a.c" {
+		t.Errorf("a nil mapping shouldn't change paths, got %q", got)
+	}
+
+	report := `{"CmdTree":["cc"],"Mode":"R","File":"/workspace/project/src/a.c"}
+{"CmdTree":["cc"],"Mode":"R","File":"include/a.h"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report), WithRootMapping(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, changed := range []string{"/home/ci/project/src/a.c", "/home/ci/project/include/a.h"} {
+		if depends, _, err := g.StepDependsOnFiles(CmdTree{"cc"}, []string{changed}); err != nil || !depends {
+			t.Errorf("StepDependsOnFiles(%q): got %v, %v wanted true", changed, depends, err)
+		}
+	}
+}
+
+func TestEntryCwd(t *testing.T) {
+	// Relative files are relative to the Cwd of their entry, wherever
+	// skipper runs.
+	t.Chdir(t.TempDir())
+	report := `{"CmdTree":["cc"],"Mode":"R","File":"a.c","Cwd":"/workspace/project/src"}
+{"CmdTree":["cc"],"Mode":"R","File":"../include/a.h","Cwd":"/workspace/project/src"}
+{"CmdTree":["cc"],"Mode":"W","File":"/workspace/project/out/a.o","Cwd":"/elsewhere"}
+`
+	for _, tc := range []struct {
+		mapping *RootMapping
+		root    string
+	}{
+		{nil, "/workspace/project"},
+		{&RootMapping{Graph: "/workspace/project", Workspace: "/home/ci/project"}, "/home/ci/project"},
+	} {
+		g, err := NewDependencyGraph(strings.NewReader(report), WithRootMapping(tc.mapping))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, changed := range []string{tc.root + "/src/a.c", tc.root + "/include/a.h"} {
+			if depends, _, err := g.StepDependsOnFiles(CmdTree{"cc"}, []string{changed}); err != nil || !depends {
+				t.Errorf("StepDependsOnFiles(%q): got %v, %v wanted true", changed, depends, err)
+			}
+		}
+	}
+
+	findings, err := Lint(strings.NewReader(report), LintOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range findings {
+		if f.Check == "relative-path" {
+			t.Errorf("got finding %+v for a relative path with a Cwd", f)
+		}
+	}
+	if _, err := NewDependencyGraph(strings.NewReader(`{"CmdTree":["cc"],"Mode":"R","File":"a.c","Cwd":"src"}`)); err == nil {
+		t.Errorf("loading an entry with a relative Cwd worked")
+	}
+}
@@ -0,0 +1,98 @@
+package stepselection
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// PlanStep is a step of a build plan.
+type PlanStep struct {
+	// Name is the name of the step in the graph.
+	Name string
+	// Command is the last command of the step's CmdTree.
+	Command string
+	// After lists the names of the earlier steps of the plan that write
+	// files the step reads, sorted.
+	After []string `json:",omitempty"`
+}
+
+// Plan orders the steps called names so that each comes after the steps
+// that write files it reads, ties broken by name. The steps of a cycle are
+// ordered by name, and each only runs after the ones before it. Names that
+// aren't in the graph are left out.
+func (g *DependencyGraph) Plan(names []string) []PlanStep {
+	in := map[*step]bool{}
+	for _, n := range names {
+		if s, ok := g.steps[n]; ok {
+			in[s] = true
+		}
+	}
+	// deps holds, for each planned step, the planned steps it reads files
+	// from, and dependents the reverse.
+	deps := map[*step]map[*step]bool{}
+	dependents := map[*step][]*step{}
+	for r := range in {
+		deps[r] = map[*step]bool{}
+		for f := range r.readFiles {
+			for _, w := range g.fileWriters[f] {
+				if in[w] && w != r && !related(w.name, r.name) && !deps[r][w] {
+					deps[r][w] = true
+					dependents[w] = append(dependents[w], r)
+				}
+			}
+		}
+	}
+
+	var plan []PlanStep
+	placed := map[*step]bool{}
+	waiting := map[*step]int{}
+	var ready, rest []*step
+	for s := range in {
+		waiting[s] = len(deps[s])
+		if waiting[s] == 0 {
+			ready = append(ready, s)
+		}
+		rest = append(rest, s)
+	}
+	byName := func(steps []*step) {
+		sort.Slice(steps, func(i, j int) bool { return steps[i].name < steps[j].name })
+	}
+	byName(rest)
+	for len(placed) < len(in) {
+		var s *step
+		if len(ready) > 0 {
+			byName(ready)
+			s, ready = ready[0], ready[1:]
+		} else {
+			// Only cycles are left: break them at the first step by name.
+			for _, r := range rest {
+				if !placed[r] {
+					s = r
+					break
+				}
+			}
+		}
+		if placed[s] {
+			continue
+		}
+		placed[s] = true
+		p := PlanStep{Name: s.name}
+		var tree CmdTree
+		if err := json.Unmarshal([]byte(s.name), &tree); err == nil && len(tree) > 0 {
+			p.Command = tree[len(tree)-1]
+		}
+		for d := range deps[s] {
+			if placed[d] {
+				p.After = append(p.After, d.name)
+			}
+		}
+		sort.Strings(p.After)
+		plan = append(plan, p)
+		for _, d := range dependents[s] {
+			if waiting[d]--; waiting[d] == 0 && !placed[d] {
+				ready = append(ready, d)
+			}
+		}
+	}
+	return plan
+}
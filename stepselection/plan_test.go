@@ -0,0 +1,47 @@
+package stepselection
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPlan(t *testing.T) {
+	report := `{"CmdTree":["ld -o app"],"Mode":"R","File":"/out/a.o"}
+{"CmdTree":["ld -o app"],"Mode":"W","File":"/out/app"}
+{"CmdTree":["cc a.c"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["cc a.c"],"Mode":"R","File":"/out/a.h"}
+{"CmdTree":["cc a.c"],"Mode":"W","File":"/out/a.o"}
+{"CmdTree":["gen"],"Mode":"W","File":"/out/a.h"}
+{"CmdTree":["gen","gen-header"],"Mode":"R","File":"/out/a.h"}
+{"CmdTree":["lint"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["x"],"Mode":"R","File":"/out/y"}
+{"CmdTree":["x"],"Mode":"W","File":"/out/x"}
+{"CmdTree":["y"],"Mode":"R","File":"/out/x"}
+{"CmdTree":["y"],"Mode":"W","File":"/out/y"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := g.Plan([]string{`["ld -o app"]`, `["lint"]`, `["cc a.c"]`, `["gen"]`, `["nope"]`})
+	want := []PlanStep{
+		{Name: `["gen"]`, Command: "gen"},
+		{Name: `["cc a.c"]`, Command: "cc a.c", After: []string{`["gen"]`}},
+		{Name: `["ld -o app"]`, Command: "ld -o app", After: []string{`["cc a.c"]`}},
+		{Name: `["lint"]`, Command: "lint"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Plan: (-want +got)\n%s", diff)
+	}
+
+	got = g.Plan([]string{`["y"]`, `["x"]`})
+	want = []PlanStep{
+		{Name: `["x"]`, Command: "x"},
+		{Name: `["y"]`, Command: "y", After: []string{`["x"]`}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Plan of a cycle: (-want +got)\n%s", diff)
+	}
+}
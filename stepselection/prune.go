@@ -0,0 +1,58 @@
+package stepselection
+
+import (
+	"errors"
+	"sort"
+)
+
+// Prune removes the steps that weren't recorded by any of the last keep
+// builds, along with the file nodes only they used, so that long-lived
+// graphs don't grow without bound. Steps without a build ID count as one
+// build, older than every other. It returns the number of steps removed.
+//
+// Ancestors of removed steps that are kept still depend on the files of the
+// removed steps, which is conservative but safe.
+func (g *DependencyGraph) Prune(keep int) (int, error) {
+	if keep < 1 {
+		return 0, errors.New("at least one build must be kept")
+	}
+	seen := map[string]bool{}
+	var builds []string
+	for _, s := range g.steps {
+		if !seen[s.build] {
+			seen[s.build] = true
+			builds = append(builds, s.build)
+		}
+	}
+	if len(builds) == 1 && builds[0] == "" {
+		return 0, errors.New("the graph has no build IDs")
+	}
+	if len(builds) <= keep {
+		return 0, nil
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(builds)))
+	oldest := builds[keep-1]
+	removed := map[*step]bool{}
+	for name, s := range g.steps {
+		if s.build < oldest {
+			removed[s] = true
+			delete(g.steps, name)
+		}
+	}
+	for file, writers := range g.fileWriters {
+		kept := writers[:0]
+		for _, s := range writers {
+			if !removed[s] {
+				kept = append(kept, s)
+			}
+		}
+		if len(kept) == 0 {
+			delete(g.fileWriters, file)
+		} else {
+			g.fileWriters[file] = kept
+		}
+	}
+
+	g.resetDeps()
+	return len(removed), nil
+}
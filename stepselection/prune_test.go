@@ -0,0 +1,59 @@
+package stepselection
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPrune(t *testing.T) {
+	report := `{"CmdTree":["old"],"Mode":"R","File":"/src/old.c"}
+{"CmdTree":["old"],"Mode":"W","File":"/out/shared.o"}
+{"CmdTree":["b1"],"Mode":"R","File":"/src/b1.c","BuildID":"01B1"}
+{"CmdTree":["b2"],"Mode":"R","File":"/src/b2.c","BuildID":"01B2"}
+{"CmdTree":["b2"],"Mode":"W","File":"/out/shared.o","BuildID":"01B2"}
+{"CmdTree":["b3"],"Mode":"R","File":"/out/shared.o","BuildID":"01B3"}
+`
+	for _, tc := range []struct {
+		keep    int
+		removed int
+		steps   []string
+	}{
+		{1, 3, []string{`["b3"]`}},
+		{2, 2, []string{`["b2"]`, `["b3"]`}},
+		{3, 1, []string{`["b1"]`, `["b2"]`, `["b3"]`}},
+		{4, 0, []string{`["b1"]`, `["b2"]`, `["b3"]`, `["old"]`}},
+	} {
+		g, err := NewDependencyGraph(strings.NewReader(report))
+		if err != nil {
+			t.Fatal(err)
+		}
+		removed, err := g.Prune(tc.keep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var steps []string
+		for name := range g.steps {
+			steps = append(steps, name)
+		}
+		sort.Strings(steps)
+		if removed != tc.removed || !cmp.Equal(steps, tc.steps) {
+			t.Errorf("keep %d: got %d removed, steps %q, wanted %d removed, steps %q", tc.keep, removed, steps, tc.removed, tc.steps)
+		}
+		for _, w := range g.fileWriters["/out/shared.o"] {
+			if g.steps[w.name] != w {
+				t.Errorf("keep %d: pruned step is still a writer", tc.keep)
+			}
+		}
+	}
+
+	g, err := NewDependencyGraph(strings.NewReader(`{"CmdTree":["old"],"Mode":"R","File":"/src/old.c"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Prune(1); err == nil {
+		t.Errorf("expected an error pruning a graph without build IDs")
+	}
+}
@@ -0,0 +1,143 @@
+package stepselection
+
+import "fmt"
+
+// StepReads returns the files read by cmdTree, sorted. Like every edge of the
+// graph, they include the files read by the steps nested in it.
+func (g *DependencyGraph) StepReads(cmdTree CmdTree) ([]string, error) {
+	s, ok := g.lookup(cmdTree)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownStep, cmdTree)
+	}
+	return sortedKeys(s.readFiles), nil
+}
+
+// StepWrites returns the files written by cmdTree, sorted, including the
+// files written by the steps nested in it.
+func (g *DependencyGraph) StepWrites(cmdTree CmdTree) ([]string, error) {
+	s, ok := g.lookup(cmdTree)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownStep, cmdTree)
+	}
+	files := map[string]bool{}
+	for f, writers := range g.fileWriters {
+		for _, w := range writers {
+			if w == s {
+				files[f] = true
+				break
+			}
+		}
+	}
+	return sortedKeys(files), nil
+}
+
+// StepInputs returns the files, sorted, that cmdTree reads and that the
+// steps writing them read, transitively, along with the directories those
+// steps list. Lookups are bound by the graph's LookupLimits.
+func (g *DependencyGraph) StepInputs(cmdTree CmdTree) (files, dirs []string, err error) {
+	s, ok := g.lookup(cmdTree)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %v", ErrUnknownStep, cmdTree)
+	}
+	d := g.transitiveDeps(s)
+	if d.err != nil {
+		return nil, nil, d.err
+	}
+	all := map[string]bool{}
+	for f := range s.readFiles {
+		all[f] = true
+	}
+	for f := range d.files {
+		all[f] = true
+	}
+	listed := map[string]bool{}
+	for dir := range s.readDirs {
+		listed[dir] = true
+	}
+	for dir := range d.dirs {
+		listed[dir] = true
+	}
+	return sortedKeys(all), sortedKeys(listed), nil
+}
+
+// FileWriters returns the names of the steps that write file, sorted.
+func (g *DependencyGraph) FileWriters(file string) []string {
+	names := map[string]bool{}
+	for _, s := range g.fileWriters[normalizePath(file)] {
+		names[s.name] = true
+	}
+	return sortedKeys(names)
+}
+
+// FilesBetween returns the files, sorted, that writer writes and reader
+// reads, which make reader depend on writer. It's empty if either step isn't
+// in the graph.
+func (g *DependencyGraph) FilesBetween(writer, reader CmdTree) []string {
+	w, ok := g.lookup(writer)
+	if !ok {
+		return nil
+	}
+	r, ok := g.lookup(reader)
+	if !ok {
+		return nil
+	}
+	files := map[string]bool{}
+	for f := range r.readFiles {
+		for _, s := range g.fileWriters[f] {
+			if s == w {
+				files[f] = true
+				break
+			}
+		}
+	}
+	return sortedKeys(files)
+}
+
+// FileReaders returns the names of the steps that read file, sorted.
+func (g *DependencyGraph) FileReaders(file string) []string {
+	file = normalizePath(file)
+	names := map[string]bool{}
+	for name, s := range g.steps {
+		if s.readFiles[file] {
+			names[name] = true
+		}
+	}
+	return sortedKeys(names)
+}
+
+// Steps returns the names of the steps in the graph, sorted, including the
+// ones only known as ancestors of nested steps.
+func (g *DependencyGraph) Steps() []string {
+	names := map[string]bool{}
+	for name := range g.steps {
+		names[name] = true
+	}
+	return sortedKeys(names)
+}
+
+// Size returns the number of steps and of files in the graph.
+func (g *DependencyGraph) Size() (steps, files int) {
+	seen := map[string]bool{}
+	g.forEachFile(func(file string) { seen[file] = true })
+	return len(g.steps), len(seen)
+}
+
+// Files returns the files read or written in the graph, sorted, as native
+// paths.
+func (g *DependencyGraph) Files() []string {
+	seen := map[string]bool{}
+	g.forEachFile(func(file string) { seen[nativePath(file)] = true })
+	return sortedKeys(seen)
+}
+
+// ListedDirs returns the directories listed by the steps of the graph,
+// sorted, as native paths.
+func (g *DependencyGraph) ListedDirs() []string {
+	seen := map[string]bool{}
+	for _, s := range g.steps {
+		for dir := range s.readDirs {
+			seen[nativePath(dir)] = true
+		}
+	}
+	return sortedKeys(seen)
+}
@@ -0,0 +1,73 @@
+package stepselection
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestQueries(t *testing.T) {
+	report := `{"CmdTree":["make","gen"],"Mode":"R","File":"/src/a.proto"}
+{"CmdTree":["make","gen"],"Mode":"W","File":"/out/a.go"}
+{"CmdTree":["make","cc"],"Mode":"R","File":"/out/a.go"}
+{"CmdTree":["make","cc"],"Mode":"R","File":"/src/main.go"}
+{"CmdTree":["make","cc"],"Mode":"R","File":"/src/include","Type":"dir"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reads, err := g.StepReads(CmdTree{"make", "cc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"/out/a.go", "/src/main.go"}, reads); diff != "" {
+		t.Errorf("StepReads: (-want +got)\n%s", diff)
+	}
+	if _, err := g.StepReads(CmdTree{"nope"}); !errors.Is(err, ErrUnknownStep) {
+		t.Errorf("StepReads of an unknown step: got %v, wanted ErrUnknownStep", err)
+	}
+	if diff := cmp.Diff([]string{`["make","gen"]`, `["make"]`}, g.FileWriters("/out/a.go")); diff != "" {
+		t.Errorf("FileWriters: (-want +got)\n%s", diff)
+	}
+	writes, err := g.StepWrites(CmdTree{"make"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"/out/a.go"}, writes); diff != "" {
+		t.Errorf("StepWrites: (-want +got)\n%s", diff)
+	}
+	files, dirs, err := g.StepInputs(CmdTree{"make", "cc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"/out/a.go", "/src/a.proto", "/src/main.go"}, files); diff != "" {
+		t.Errorf("StepInputs files: (-want +got)\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"/src/include"}, dirs); diff != "" {
+		t.Errorf("StepInputs dirs: (-want +got)\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"/out/a.go"}, g.FilesBetween(CmdTree{"make", "gen"}, CmdTree{"make", "cc"})); diff != "" {
+		t.Errorf("FilesBetween: (-want +got)\n%s", diff)
+	}
+	if got := g.FilesBetween(CmdTree{"make", "cc"}, CmdTree{"make", "gen"}); len(got) != 0 {
+		t.Errorf("FilesBetween in the wrong order: got %q, wanted none", got)
+	}
+	if diff := cmp.Diff([]string{`["make","cc"]`, `["make"]`}, g.FileReaders("/out/a.go")); diff != "" {
+		t.Errorf("FileReaders: (-want +got)\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{`["make","cc"]`, `["make","gen"]`, `["make"]`}, g.Steps()); diff != "" {
+		t.Errorf("Steps: (-want +got)\n%s", diff)
+	}
+	if steps, files := g.Size(); steps != 3 || files != 3 {
+		t.Errorf("Size: got %d steps and %d files, wanted 3 and 3", steps, files)
+	}
+	if diff := cmp.Diff([]string{"/out/a.go", "/src/a.proto", "/src/main.go"}, g.Files()); diff != "" {
+		t.Errorf("Files: (-want +got)\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"/src/include"}, g.ListedDirs()); diff != "" {
+		t.Errorf("ListedDirs: (-want +got)\n%s", diff)
+	}
+}
@@ -0,0 +1,83 @@
+package stepselection
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SelfWrite is a file a step both reads and writes, like a file it formats
+// in place or a cache it updates.
+type SelfWrite struct {
+	Step string
+	File string
+}
+
+func (w SelfWrite) String() string {
+	return fmt.Sprintf("step %v reads and writes %v", w.Step, w.File)
+}
+
+// SelfWrites returns the files that steps both read and write, sorted by step
+// and file. Ancestors, which inherit the files of their nested steps, are
+// left out when a nested step reads or writes the file.
+//
+// Such steps depend on their own outputs: they run whenever the files they
+// write are among the changes, even if they changed them themselves, and the
+// steps reading their outputs depend on those files too.
+func (g *DependencyGraph) SelfWrites() []SelfWrite {
+	readers := map[string]map[string]bool{}
+	for _, s := range g.steps {
+		for f := range s.readFiles {
+			if readers[f] == nil {
+				readers[f] = map[string]bool{}
+			}
+			readers[f][s.name] = true
+		}
+	}
+	var out []SelfWrite
+	for f, writers := range g.fileWriters {
+		touching := readers[f]
+		if len(touching) == 0 {
+			continue
+		}
+		for _, s := range writers {
+			touching[s.name] = true
+		}
+		reported := map[*step]bool{}
+		for _, s := range writers {
+			if s.readFiles[f] && !reported[s] && !hasNestedStep(s.name, touching) {
+				reported[s] = true
+				out = append(out, SelfWrite{Step: s.name, File: f})
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Step != out[j].Step {
+			return out[i].Step < out[j].Step
+		}
+		return out[i].File < out[j].File
+	})
+	return out
+}
+
+// hasNestedStep reports whether one of the steps in names is nested in the
+// step called name.
+func hasNestedStep(name string, names map[string]bool) bool {
+	prefix := strings.TrimSuffix(name, "]") + ","
+	for other := range names {
+		if strings.HasPrefix(other, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// reportSelfWrites warns about the steps that read and write the same files,
+// which are easy to miss since they don't form cycles of several steps.
+func (g *DependencyGraph) reportSelfWrites() {
+	self := g.SelfWrites()
+	if len(self) == 0 {
+		return
+	}
+	logger.Warn("steps read files they write, so they run whenever those files change, see skipper graph lint", "count", len(self), "first", self[0].String())
+}
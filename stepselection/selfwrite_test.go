@@ -0,0 +1,50 @@
+package stepselection
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSelfWrites(t *testing.T) {
+	report := `{"CmdTree":["make","fmt"],"Mode":"R","File":"/src/a.go"}
+{"CmdTree":["make","fmt"],"Mode":"W","File":"/src/a.go"}
+{"CmdTree":["make","fmt"],"Mode":"W","File":"/src/a.go"}
+{"CmdTree":["make","gen"],"Mode":"R","File":"/src/b.go"}
+{"CmdTree":["make","gen"],"Mode":"W","File":"/src/c.go"}
+{"CmdTree":["make","vet"],"Mode":"R","File":"/src/c.go"}
+{"CmdTree":["make","vet"],"Mode":"R","File":"/src/a.go"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// make reads and writes /src/a.go too, through fmt.
+	want := []SelfWrite{{Step: `["make","fmt"]`, File: "/src/a.go"}}
+	if diff := cmp.Diff(want, g.SelfWrites()); diff != "" {
+		t.Errorf("unexpected self writes (-want +got):\n%v", diff)
+	}
+}
+
+func TestSelfWriteDependencies(t *testing.T) {
+	// gen edits /src/a.go in place and writes /src/b.go from it. Whichever
+	// of the two files use is explored through first, it depends on
+	// /src/a.go through gen.
+	report := `{"CmdTree":["gen"],"Mode":"R","File":"/src/a.go"}
+{"CmdTree":["gen"],"Mode":"W","File":"/src/a.go"}
+{"CmdTree":["gen"],"Mode":"W","File":"/src/b.go"}
+{"CmdTree":["use"],"Mode":"R","File":"/src/a.go"}
+{"CmdTree":["use"],"Mode":"R","File":"/src/b.go"}
+`
+	for i := 0; i < 20; i++ {
+		g, err := NewDependencyGraph(strings.NewReader(report))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, _ := g.lookup(CmdTree{"use"})
+		if d := g.transitiveDeps(s); !d.files["/src/a.go"] {
+			t.Fatalf("got dependencies %v, wanted /src/a.go", d.files)
+		}
+	}
+}
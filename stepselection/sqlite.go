@@ -0,0 +1,264 @@
+package stepselection
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// SQLiteGraph is a Graph persisted in a SQLite database, so that large build
+// reports don't have to be parsed and held in memory by every skipper
+// invocation. Lookups run as queries in the database, through the sqlite3
+// command which must be installed.
+type SQLiteGraph struct {
+	path       string
+	normalizer *Normalizer
+	hermetic   *PathMatcher
+}
+
+// The reads, writes and dirs tables hold the edges of every step, including
+// the edges inherited by ancestor steps. source is the overlay that added the
+// edge, if any. build and at are the stamp of the last read and of the first
+// write, see recordRead and recordWrite, with at 0 for unknown times. The
+// primary keys double as the indexes used for lookups: reads and dirs by
+// step, and writes by file.
+const sqliteSchema = `CREATE TABLE steps (name TEXT PRIMARY KEY) WITHOUT ROWID;
+CREATE TABLE reads (step TEXT NOT NULL, file TEXT NOT NULL, source TEXT NOT NULL, build TEXT NOT NULL, at INTEGER NOT NULL, PRIMARY KEY (step, file)) WITHOUT ROWID;
+CREATE TABLE writes (file TEXT NOT NULL, step TEXT NOT NULL, source TEXT NOT NULL, build TEXT NOT NULL, at INTEGER NOT NULL, PRIMARY KEY (file, step)) WITHOUT ROWID;
+CREATE TABLE dirs (step TEXT NOT NULL, dir TEXT NOT NULL, PRIMARY KEY (step, dir)) WITHOUT ROWID;
+`
+
+// WriteSQLiteGraph creates a SQLite database at path with the graph of the
+// build report. An existing database is replaced.
+func WriteSQLiteGraph(path string, buildReport io.Reader, opts ...Option) error {
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	cmd := exec.Command("sqlite3", "-bail", tmp)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not run sqlite3: %v", err)
+	}
+	w := bufio.NewWriter(stdin)
+	io.WriteString(w, "PRAGMA journal_mode=OFF;\nPRAGMA synchronous=OFF;\nBEGIN;\n"+sqliteSchema)
+	readErr := readEntries(buildReport, opts, func(bog *BuildLog, provenance string) {
+		if bog.Type == "step" || bog.Type == "net" || bog.Type == "env" || bog.Type == "tool" {
+			// Durations, network accesses, environments and tools
+			// aren't stored.
+			return
+		}
+		at := entryStamp(bog)
+		walkUpStepTree(bog.CmdTree, func(cmdTree CmdTree) {
+			name := sqlQuote(cmdTree.Name())
+			fmt.Fprintf(w, "INSERT OR IGNORE INTO steps VALUES (%v);\n", name)
+			if bog.Type == "dir" {
+				fmt.Fprintf(w, "INSERT OR IGNORE INTO dirs VALUES (%v, %v);\n", name, sqlQuote(bog.File))
+			} else if bog.Reads() {
+				fmt.Fprintf(w, "INSERT INTO reads VALUES (%v, %v, %v, %v, %d) %v;\n", name, sqlQuote(bog.File), sqlQuote(provenance), sqlQuote(at.build), at.at, sqlMergeStamps("step, file", "max"))
+			} else {
+				fmt.Fprintf(w, "INSERT INTO writes VALUES (%v, %v, %v, %v, %d) %v;\n", sqlQuote(bog.File), name, sqlQuote(provenance), sqlQuote(at.build), at.at, sqlMergeStamps("file, step", "min"))
+			}
+		})
+	})
+	if readErr == nil {
+		io.WriteString(w, "COMMIT;\n")
+	}
+	flushErr := w.Flush()
+	stdin.Close()
+	waitErr := cmd.Wait()
+	switch {
+	case readErr != nil:
+		os.Remove(tmp)
+		return readErr
+	case waitErr != nil:
+		os.Remove(tmp)
+		return fmt.Errorf("sqlite3: %v: %s", waitErr, bytes.TrimSpace(stderr.Bytes()))
+	case flushErr != nil:
+		os.Remove(tmp)
+		return flushErr
+	}
+	return os.Rename(tmp, path)
+}
+
+// sqlMergeStamps returns the upsert clause that merges the stamp of an edge
+// already in a table with primary key key like mergeStamps does, keeping the
+// stamp chosen by pick, max or min. An overlay's source replaces the edge's.
+func sqlMergeStamps(key, pick string) string {
+	unknown := "at = 0 OR excluded.at = 0 OR build != excluded.build"
+	return fmt.Sprintf("ON CONFLICT (%v) DO UPDATE SET "+
+		"source = CASE WHEN excluded.source != '' THEN excluded.source ELSE source END, "+
+		"build = CASE WHEN %v THEN '' ELSE build END, "+
+		"at = CASE WHEN %v THEN 0 ELSE %v(at, excluded.at) END",
+		key, unknown, unknown, pick)
+}
+
+// OpenSQLiteGraph opens a graph created by WriteSQLiteGraph. Only the name
+// rules and hermetic files of opts matter. The name rules should be the ones
+// the graph was written with: names in the database are normalized once,
+// when it's written.
+func OpenSQLiteGraph(path string, opts ...Option) (*SQLiteGraph, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return nil, fmt.Errorf("SQLite graphs need the sqlite3 command: %v", err)
+	}
+	o := newOptions(opts)
+	return &SQLiteGraph{path: path, normalizer: o.normalizer, hermetic: o.hermetic}, nil
+}
+
+func (g *SQLiteGraph) String() string {
+	return fmt.Sprintf("SQLite graph %v", g.path)
+}
+
+// StepDependsOnFiles implements Graph. It follows the same edges as
+// DependencyGraph.StepDependsOnFiles, using a recursive query.
+func (g *SQLiteGraph) StepDependsOnFiles(cmdTree CmdTree, changedFiles []string) (bool, string, error) {
+	name := g.normalizer.CmdTree(cmdTree).Name()
+	script := fmt.Sprintf("SELECT 'step' AS kind, name AS file FROM steps WHERE name = %v;\n", sqlQuote(name))
+	given := normalizePaths(changedFiles)
+	if len(given) > 0 {
+		// Like DependencyGraph.expandDirs, directories of the graph
+		// change every file in them.
+		var values []string
+		for _, f := range given {
+			values = append(values, "("+sqlQuote(f)+")")
+		}
+		script += fmt.Sprintf("WITH given(file) AS (VALUES %v) "+
+			"SELECT DISTINCT 'file' AS kind, f.file AS file FROM given c JOIN (SELECT file FROM reads UNION SELECT file FROM writes) f "+
+			"ON c.file != '/' AND substr(f.file, 1, length(c.file) + 1) = c.file || '/';\n",
+			strings.Join(values, ", "))
+	}
+	rows, err := g.query(script)
+	if err != nil {
+		return false, "", err
+	}
+	if len(rows) == 0 || rows[0]["kind"] != "step" {
+		return false, "", fmt.Errorf("%w: %v", ErrUnknownStep, cmdTree)
+	}
+	for _, r := range rows[1:] {
+		given = append(given, r["file"])
+	}
+	var values []string
+	for _, f := range given {
+		if !g.hermetic.Match(f) {
+			values = append(values, "("+sqlQuote(f)+")")
+		}
+	}
+	if len(values) == 0 {
+		return false, "", nil
+	}
+	changed := "changed(file) AS (VALUES " + strings.Join(values, ", ") + ")"
+	// deps holds the files read by the step and by the steps that produced
+	// them, see produces, with the reader and the stamp of its read.
+	deps := fmt.Sprintf("deps(step, file, build, at, source) AS ("+
+		"SELECT step, file, build, at, '' FROM reads WHERE step = %v "+
+		"UNION SELECT r.step, r.file, r.build, r.at, CASE WHEN r.source != '' THEN r.source ELSE w.source END "+
+		"FROM deps d JOIN writes w ON w.file = d.file AND %v JOIN reads r ON r.step = w.step)",
+		sqlQuote(name), sqlProduces)
+	script = fmt.Sprintf("WITH %v SELECT 'direct' AS kind, r.file AS file, r.source AS source FROM reads r JOIN changed c ON c.file = r.file WHERE r.step = %v LIMIT 1;\n", changed, sqlQuote(name))
+	script += fmt.Sprintf("WITH RECURSIVE %v, %v "+
+		"SELECT 'transitive' AS kind, d.file AS file, d.source AS source FROM deps d JOIN changed c ON c.file = d.file LIMIT 1;\n",
+		changed, deps)
+	// Steps depend on the changed files under the directories listed by
+	// themselves or by the steps they depend on.
+	under := "substr(c.file, 1, length(x.dir) + 1) = x.dir || '/' OR x.dir = '/'"
+	script += fmt.Sprintf("WITH %v SELECT 'direct-dir' AS kind, c.file AS file, x.dir AS source FROM dirs x JOIN changed c ON %v WHERE x.step = %v LIMIT 1;\n", changed, under, sqlQuote(name))
+	script += fmt.Sprintf("WITH RECURSIVE %v, %v, "+
+		"reached(step) AS (SELECT w.step FROM deps d JOIN writes w ON w.file = d.file AND %v) "+
+		"SELECT 'transitive-dir' AS kind, c.file AS file, x.dir AS source FROM dirs x JOIN reached USING (step) JOIN changed c ON %v LIMIT 1;\n",
+		changed, deps, sqlProduces, under)
+	rows, err = g.query(script)
+	if err != nil {
+		return false, "", err
+	}
+	for _, kind := range []string{"direct-dir", "direct", "transitive", "transitive-dir"} {
+		for _, r := range rows {
+			if r["kind"] != kind {
+				continue
+			}
+			switch kind {
+			case "direct":
+				return true, fmt.Sprintf("step %q reads file %q which is being updated%s", name, r["file"], overlayNote(r["source"])), nil
+			case "transitive":
+				return true, fmt.Sprintf("step %q has a dependency that uses %q%s", name, r["file"], overlayNote(r["source"])), nil
+			case "direct-dir":
+				return true, fmt.Sprintf("step %q lists directory %q where %q is being updated", name, r["source"], r["file"]), nil
+			default:
+				return true, fmt.Sprintf("step %q has a dependency that lists directory %q where %q is being updated", name, r["source"], r["file"]), nil
+			}
+		}
+	}
+	return false, "", nil
+}
+
+// sqlProduces is produces for the write w of the file the step of deps d
+// read.
+const sqlProduces = "(w.at = 0 OR d.at = 0 OR w.build != d.build OR w.at <= d.at)"
+
+// Load reads the whole graph into memory, for operations that
+// SQLiteGraph doesn't support directly.
+func (g *SQLiteGraph) Load() (*DependencyGraph, error) {
+	rows, err := g.query("SELECT 'R' AS kind, step, file, source, build, CAST(at AS TEXT) AS at FROM reads;\nSELECT 'W' AS kind, step, file, source, build, CAST(at AS TEXT) AS at FROM writes;\nSELECT 'dir' AS kind, step, dir AS file, '' AS source, '' AS build, '0' AS at FROM dirs;\n")
+	if err != nil {
+		return nil, err
+	}
+	dg := &DependencyGraph{
+		steps:       map[string]*step{},
+		fileWriters: map[string][]*step{},
+		normalizer:  g.normalizer,
+		hermetic:    g.hermetic,
+	}
+	for _, r := range rows {
+		if r["kind"] == "dir" {
+			dg.addDir(r["step"], intern(r["file"]))
+			continue
+		}
+		at, err := strconv.ParseInt(r["at"], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected sqlite3 output: %v", err)
+		}
+		dg.addEdge(r["step"], r["kind"] == "R", intern(r["file"]), r["source"], stamp{build: r["build"], at: at})
+	}
+	return dg, nil
+}
+
+// query runs a read-only SQL script and returns the rows of all its
+// statements.
+func (g *SQLiteGraph) query(script string) ([]map[string]string, error) {
+	cmd := exec.Command("sqlite3", "-bail", "-readonly", "-json", g.path)
+	cmd.Stdin = strings.NewReader(script)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("sqlite3: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	var rows []map[string]string
+	// Each statement that returns rows prints one JSON array.
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var batch []map[string]string
+		if err := dec.Decode(&batch); err == io.EOF {
+			return rows, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("unexpected sqlite3 output: %v", err)
+		}
+		rows = append(rows, batch...)
+	}
+}
+
+// sqlQuote returns s as a SQL string literal.
+func sqlQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
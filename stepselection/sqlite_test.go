@@ -0,0 +1,143 @@
+package stepselection
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSQLiteGraph(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 is not installed")
+	}
+	dir, err := ioutil.TempDir("", "skipper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	report := `{"CmdTree":["cc"],"Mode":"R","File":"/src/it's.c"}
+{"CmdTree":["cc"],"Mode":"W","File":"/out/a.o"}
+{"CmdTree":["link"],"Mode":"R","File":"/out/a.o"}
+{"CmdTree":["cc"],"Mode":"R","File":"/src/include","Type":"dir"}
+`
+	path := filepath.Join(dir, "graph.db")
+	overlay := &Overlay{Source: "fixes.yaml", Add: []OverlayEdit{{Step: CmdTree{"link"}, Reads: []string{"/src/link.ld"}}}}
+	if err := WriteSQLiteGraph(path, strings.NewReader(report), WithOverlay(overlay)); err != nil {
+		t.Fatal(err)
+	}
+	g, err := OpenSQLiteGraph(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		changed []string
+		depends bool
+		reason  string
+	}{
+		{[]string{"/src/it's.c"}, true, `step "[\"link\"]" has a dependency that uses "/src/it's.c"`},
+		{[]string{"/out/a.o"}, true, `step "[\"link\"]" reads file "/out/a.o" which is being updated`},
+		{[]string{"/src/link.ld"}, true, `step "[\"link\"]" reads file "/src/link.ld" which is being updated (edge added by overlay fixes.yaml)`},
+		{[]string{"/src/other.c"}, false, ""},
+		{[]string{"/src/include/new.h"}, true, `step "[\"link\"]" has a dependency that lists directory "/src/include" where "/src/include/new.h" is being updated`},
+		{nil, false, ""},
+	} {
+		depends, reason, err := g.StepDependsOnFiles(CmdTree{"link"}, tc.changed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if depends != tc.depends || reason != tc.reason {
+			t.Errorf("changed %q: got %v %q, wanted %v %q", tc.changed, depends, reason, tc.depends, tc.reason)
+		}
+	}
+	if _, _, err := g.StepDependsOnFiles(CmdTree{"nope"}, nil); err == nil {
+		t.Errorf("expected an error for an unknown step")
+	}
+
+	mem, err := g.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mem.String(), "graph with 2 steps"; got != want {
+		t.Errorf("got %q wanted %q", got, want)
+	}
+}
+
+// backendReport is the build report of the StepDependsOnFiles cases that
+// every Graph implementation must decide alike. fmt rewrites the header cc
+// reads after cc is done, see TestHappensBefore.
+const backendReport = `{"CmdTree":["gen"],"Mode":"R","File":"/src/gen.py","BuildID":"b1","Time":"2020-01-01T00:00:01Z"}
+{"CmdTree":["gen"],"Mode":"R","File":"/src/templates","Type":"dir","BuildID":"b1","Time":"2020-01-01T00:00:01Z"}
+{"CmdTree":["gen"],"Mode":"W","File":"/out/cfg.h","BuildID":"b1","Time":"2020-01-01T00:00:02Z"}
+{"CmdTree":["make","cc"],"Mode":"R","File":"/out/cfg.h","BuildID":"b1","Time":"2020-01-01T00:00:03Z"}
+{"CmdTree":["make","cc"],"Mode":"R","File":"/src/lib/a.c","BuildID":"b1","Time":"2020-01-01T00:00:03Z"}
+{"CmdTree":["make","cc"],"Mode":"R","File":"/src/include","Type":"dir","BuildID":"b1","Time":"2020-01-01T00:00:03Z"}
+{"CmdTree":["make","cc"],"Mode":"R","File":"/usr/include/stdio.h","BuildID":"b1","Time":"2020-01-01T00:00:03Z"}
+{"CmdTree":["make","cc"],"Mode":"W","File":"/out/a.o","BuildID":"b1","Time":"2020-01-01T00:00:04Z"}
+{"CmdTree":["fmt"],"Mode":"R","File":"/src/fmt.toml","BuildID":"b1","Time":"2020-01-01T00:00:05Z"}
+{"CmdTree":["fmt"],"Mode":"W","File":"/out/cfg.h","BuildID":"b1","Time":"2020-01-01T00:00:06Z"}
+{"CmdTree":["link"],"Mode":"R","File":"/out/a.o","BuildID":"b2"}
+{"CmdTree":["link"],"Mode":"W","File":"/out/app","BuildID":"b2"}
+`
+
+var backendCases = []struct {
+	step    CmdTree
+	changed []string
+	depends bool
+}{
+	{CmdTree{"make", "cc"}, []string{"/src/lib/a.c"}, true},
+	{CmdTree{"make", "cc"}, []string{"/src/gen.py"}, true},
+	{CmdTree{"make", "cc"}, []string{"/src/include/b.h"}, true},
+	{CmdTree{"make", "cc"}, []string{"/src/templates/cfg.tmpl"}, true},
+	// fmt wrote the header after cc read it.
+	{CmdTree{"make", "cc"}, []string{"/src/fmt.toml"}, false},
+	{CmdTree{"make", "cc"}, []string{"/src/other.c"}, false},
+	// Changed directories change the files of the graph in them.
+	{CmdTree{"make", "cc"}, []string{"/src/lib"}, true},
+	{CmdTree{"make", "cc"}, []string{"/src/li"}, false},
+	{CmdTree{"make"}, []string{"/src"}, true},
+	// Steps don't depend on hermetic files.
+	{CmdTree{"make", "cc"}, []string{"/usr/include/stdio.h"}, false},
+	{CmdTree{"make", "cc"}, []string{"/usr/include"}, false},
+	// Times of different builds can't be compared, but cc's can.
+	{CmdTree{"link"}, []string{"/src/gen.py"}, true},
+	{CmdTree{"link"}, []string{"/src/fmt.toml"}, false},
+	{CmdTree{"link"}, nil, false},
+}
+
+func TestGraphBackends(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 is not installed")
+	}
+	mem, err := NewDependencyGraph(strings.NewReader(backendReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "graph.db")
+	if err := WriteSQLiteGraph(path, strings.NewReader(backendReport)); err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenSQLiteGraph(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := db.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, g := range map[string]Graph{"memory": mem, "sqlite": db, "loaded from sqlite": loaded} {
+		for _, tc := range backendCases {
+			depends, reason, err := g.StepDependsOnFiles(tc.step, tc.changed)
+			if err != nil {
+				t.Fatalf("%v graph: %v", name, err)
+			}
+			if depends != tc.depends {
+				t.Errorf("%v graph: %v with %q changed: got %v (%v), wanted %v", name, tc.step, tc.changed, depends, reason, tc.depends)
+			}
+		}
+	}
+}
@@ -1,8 +1,6 @@
-// Package stepselection decides which build steps should be run for a build.
 package stepselection
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
@@ -11,15 +9,17 @@ import (
 	"os"
 	"path"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
+	"unique"
 )
 
-// TODO(nictuku): make this a flag?
-const debug = false
-
 var re = regexp.MustCompile("^skipper (?:--id [^ ]+ )?-- ")
 
+// StepFromSkipperArgs removes the skipper invocation from the start of a
+// command, so that a step has the same name whether or not it was wrapped.
 func StepFromSkipperArgs(s string) string {
 	return re.ReplaceAllString(s, "")
 }
@@ -27,21 +27,81 @@ func StepFromSkipperArgs(s string) string {
 type step struct {
 	name      string // for debugging
 	readFiles map[string]bool
+	// overlayReads and overlayWrites record which overlay added an edge,
+	// keyed by file. They're nil for steps untouched by overlays.
+	overlayReads  map[string]string
+	overlayWrites map[string]string
+	// build is the most recent build that recorded the step, if known.
+	build string
+	// readDirs holds the directories the step listed. It's nil for most
+	// steps.
+	readDirs map[string]bool
+	// duration is how long the step took in the base build, if known.
+	duration time.Duration
+	// network holds the network addresses the step connected to. It's nil
+	// for most steps.
+	network map[string]bool
+	// env holds the environment variables of the allowlist the step ran
+	// with, as recorded in entries of type "env", by name. It's nil for
+	// steps recorded without their environment.
+	env map[string]string
+	// tools holds the fingerprints of the tools the step ran, as recorded
+	// in entries of type "tool", by path. It's nil for most steps.
+	tools map[string]string
+	// readTimes and writeTimes hold when the step last read and first
+	// wrote files, by file, see produces. They're nil for steps recorded
+	// without times.
+	readTimes  map[string]stamp
+	writeTimes map[string]stamp
 }
 
-var ignoreFiles = map[string]bool{
-	"/dev/null": true,
+// Graph answers whether steps depend on changed files. DependencyGraph
+// holds the whole graph in memory, while SQLiteGraph queries a database.
+type Graph interface {
+	StepDependsOnFiles(cmdTree CmdTree, changedFiles []string) (bool, string, error)
+	String() string
 }
 
+// DependencyGraph holds the files read and written by the steps of a build.
+// It's safe for concurrent lookups, but not for lookups concurrent with Merge
+// or Prune.
 type DependencyGraph struct {
 	steps       map[string]*step
 	fileWriters map[string][]*step
+
+	// mu guards deps, which caches the transitive dependencies of the
+	// steps looked up so far, limits and fuzzy. Methods that change the
+	// graph reset deps.
+	mu     sync.Mutex
+	deps   map[string]*stepDeps
+	limits LookupLimits
+	fuzzy  FuzzyMatch
+	// dirs holds the directories of the files in the graph, once needed.
+	// It's guarded by mu and reset along with deps, like index, which
+	// holds the steps for fuzzy matching.
+	dirs  map[string]bool
+	index []indexedStep
+	// bloom holds the files and listed directories of the graph, once
+	// needed. It's guarded by mu and reset along with deps.
+	bloom *bloomFilter
+	// normalizer rewrites the names of the steps looked up, like the names
+	// of the steps in the graph were when it was loaded.
+	normalizer *Normalizer
+	// hermetic matches the changed files no step depends on, see
+	// WithHermetic.
+	hermetic *PathMatcher
+	// metadata is written in the header of the reports of the graph, see
+	// SetReportMetadata.
+	metadata *ReportHeader
 }
 
 func absoluteNodePath(node string) string {
 	// TODO(nictuku): Remove this when the build log is fixed to only provide full paths.
 	// This is not always correct because it relies on the current skipper working
 	// directory to be the same as when the build log was created.
+	// WithRootMapping avoids it by making paths relative to a workspace root,
+	// and entries with a Cwd are made absolute when they're decoded.
+	node = toNodePath(node)
 	if path.IsAbs(node) {
 		return node
 	}
@@ -49,11 +109,14 @@ func absoluteNodePath(node string) string {
 	if err != nil {
 		return node
 	}
-	return path.Join(cwd, node)
+	return path.Join(toNodePath(cwd), node)
 }
 
+// CmdTree identifies a step by its command and the commands of its ancestors,
+// outermost first.
 type CmdTree []string
 
+// Name returns the name of the step, a JSON array of its CmdTree.
 func (c CmdTree) Name() string {
 	buf := new(bytes.Buffer)
 	enc := json.NewEncoder(buf)
@@ -67,10 +130,60 @@ func (c CmdTree) Name() string {
 	return strings.TrimSuffix(buf.String(), "\n")
 }
 
+// BuildLog is an entry of a build report. Mode is "R" if the step read File
+// and "W" if it wrote it. It's "X" if the step executed the binary File and
+// "L" if it loaded the shared library File, which are reads too, see Reads:
+// upgrading a compiler or a library must run the steps using it.
 type BuildLog struct {
 	CmdTree []string
 	Mode    string
 	File    string
+	// BuildID identifies the build that recorded the entry. IDs are ULIDs,
+	// so they sort by time. Older reports don't have them.
+	BuildID string `json:",omitempty"`
+	// Type is "dir" for entries where the step listed the directory File
+	// instead of reading it. Such steps depend on every file added to or
+	// removed from the directory, so they depend on any changed file under
+	// it. It's "step" for entries that only record the Duration of the
+	// step, which have no File. It's "net" for entries where the step
+	// connected to the network address File, a host and port, which makes
+	// it impossible to prove hermetic. It's "env" for entries where the
+	// step ran with the environment variable File, as NAME=value, or NAME
+	// if it wasn't set. It's "tool" for entries where the step ran a tool
+	// of the toolchain, with File the fingerprint of the tool and its path,
+	// see ToolEntry. It's empty for regular files.
+	Type string `json:",omitempty"`
+	// Duration is how long the step took to run, in entries of type
+	// "step". A step run several times has an entry for each run.
+	Duration time.Duration `json:",omitempty"`
+	// Time is when the step accessed File, if recorded. Reads and writes
+	// of the same build with times are ordered: a step that wrote File
+	// after another step last read it didn't produce what that step read.
+	// SQLite graphs don't keep times.
+	Time *time.Time `json:",omitempty"`
+	// Cwd is the working directory of the step when it accessed File, for
+	// entries whose File is relative, which is relative to Cwd rather than
+	// to the directory skipper runs in. Entries are loaded with their File
+	// made absolute, see resolveCwd.
+	Cwd string `json:",omitempty"`
+}
+
+// Reads reports whether the entry records that the step read File, executed
+// it or loaded it.
+func (bog *BuildLog) Reads() bool {
+	return bog.Mode == "R" || bog.Mode == "X" || bog.Mode == "L"
+}
+
+// resolveCwd makes the relative File of bog absolute by joining it to its
+// Cwd, which is cleared once used.
+func (bog *BuildLog) resolveCwd() {
+	if bog.Cwd == "" {
+		return
+	}
+	if file := toNodePath(bog.File); !path.IsAbs(file) {
+		bog.File = path.Join(toNodePath(bog.Cwd), file)
+	}
+	bog.Cwd = ""
 }
 
 // walkUpStepTree runs f on each step of a step tree, identified in the build
@@ -85,96 +198,472 @@ func walkUpStepTree(step CmdTree, f func(subTree CmdTree)) {
 }
 
 // NewDependencyGraph creates a DependencyGraph which can be used for looking
-// up whether a step depends on certain files. A buildReport must be provided,
-// which is currently obtained by running `stepanalysis` on a build log. The
-// buid log is the output of buildsnoop.py.
-func NewDependencyGraph(buildReport io.Reader) (*DependencyGraph, error) {
+// up whether a step depends on certain files. buildReport has a JSON BuildLog
+// per line, after a ReportHeader, as written by "skipper record".
+func NewDependencyGraph(buildReport io.Reader, opts ...Option) (*DependencyGraph, error) {
+	o := newOptions(opts)
 	g := &DependencyGraph{
 		steps:       map[string]*step{},
 		fileWriters: map[string][]*step{},
+		normalizer:  o.normalizer,
+		hermetic:    o.hermetic,
 	}
 	start := time.Now()
+	if err := readEntries(buildReport, opts, g.add); err != nil {
+		return nil, err
+	}
+	logger.Info("dep graph built", "duration", time.Since(start))
+	g.reportCycles()
+	g.reportSelfWrites()
+	return g, nil
+}
+
+// readEntries calls add for each entry of the build report, after
+// normalizing its file and step names and applying the options. provenance
+// is the name of the overlay that created the entry, if any. The strings of
+// the entries are interned, see intern.
+func readEntries(buildReport io.Reader, opts []Option, add func(bog *BuildLog, provenance string)) error {
+	o := newOptions(opts)
 	if buildReport == nil {
-		return nil, errors.New("invalid build report")
-	}
-	scanner := bufio.NewScanner(buildReport)
-	for scanner.Scan() {
-		bog := &BuildLog{}
-		if err := json.Unmarshal(scanner.Bytes(), bog); err != nil {
-			return nil, err
-		}
-		mode := bog.Mode
-		// absoluteNodePath is very important here. If the graph says a
-		// process is working on file "F1", we normalize that to an
-		// absolute path based on the current path. That's not ideal,
-		// see the comment in absoluteNodePath.
-		node := absoluteNodePath(bog.File)
-		steps := bog.CmdTree
-		walkUpStepTree(steps, func(cmdTree CmdTree) {
-			// We add this node to all ancestor steps to
-			// effectively make them depend on these files, too.
-			s, ok := g.steps[cmdTree.Name()]
-			if !ok {
-				s = &step{readFiles: map[string]bool{}, name: cmdTree.Name()}
+		return errors.New("invalid build report")
+	}
+	removed := removals(o.overlays)
+	for i := range removed {
+		removed[i].Step = o.normalizer.CmdTree(removed[i].Step)
+	}
+	// steps holds the steps of the report, for the overrides.
+	var steps map[string]CmdTree
+	if len(o.overrides) > 0 {
+		steps = map[string]CmdTree{}
+	}
+	corrupt := &corruptLines{}
+	temp := newTempFiles()
+	err := parseLines(buildReport, func(b []byte) parsedLine {
+		return o.parseLine(b, removed)
+	}, func(p *parsedLine) error {
+		if p.err != nil && o.skipCorrupt && !errors.Is(p.err, ErrUnsupportedVersion) {
+			corrupt.add(p.line, p.err)
+			return nil
+		}
+		if p.err != nil {
+			return fmt.Errorf("invalid build report: line %d: %w", p.line, p.err)
+		}
+		if p.bog == nil {
+			return nil
+		}
+		if steps != nil {
+			steps[CmdTree(p.bog.CmdTree).Name()] = p.bog.CmdTree
+		}
+		if p.keep && !temp.hold(o.temp, p.bog) {
+			add(p.bog, "")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	corrupt.report()
+	temp.flush(add)
+	if steps != nil {
+		names := make([]string, 0, len(steps))
+		for name := range steps {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		cmdTrees := make([]CmdTree, 0, len(names))
+		for _, name := range names {
+			cmdTrees = append(cmdTrees, steps[name])
+		}
+		addOverrideEntries(o.overrides, cmdTrees, add)
+	}
+	for _, overlay := range o.overlays {
+		for _, bog := range overlay.entries() {
+			bog.CmdTree = o.normalizer.CmdTree(bog.CmdTree)
+			bog.File = intern(bog.File)
+			add(bog, overlay.Source)
+		}
+	}
+	return nil
+}
+
+// intern returns the canonical copy of s. Decoding and normalizing allocate
+// a new string for every entry, and every ancestor of the step keeps it, so
+// without interning a file read by thousands of steps is stored thousands of
+// times.
+func intern(s string) string {
+	return unique.Make(s).Value()
+}
+
+// add records a build log entry in the graph. provenance is the name of the
+// overlay that created the entry, if any.
+func (g *DependencyGraph) add(bog *BuildLog, provenance string) {
+	if bog.Type == "step" {
+		// Ancestors have durations of their own, which include this one.
+		s := g.step(CmdTree(bog.CmdTree).Name())
+		s.duration += bog.Duration
+		if bog.BuildID > s.build {
+			s.build = bog.BuildID
+		}
+		return
+	}
+	if bog.Type == "env" {
+		// Unlike files, the environment of a step isn't its ancestors':
+		// they record their own.
+		s := g.step(CmdTree(bog.CmdTree).Name())
+		if s.env == nil {
+			s.env = map[string]string{}
+		}
+		s.env[envName(bog.File)] = bog.File
+		return
+	}
+	if bog.Type == "tool" {
+		// Ancestors ran the tool too, through their descendant.
+		path, fingerprint := splitToolEntry(bog.File)
+		walkUpStepTree(bog.CmdTree, func(cmdTree CmdTree) {
+			s := g.step(cmdTree.Name())
+			if s.tools == nil {
+				s.tools = map[string]string{}
 			}
-			if mode == "R" {
-				s.readFiles[node] = true
-			} else {
-				g.fileWriters[node] = append(g.fileWriters[node], s)
+			s.tools[path] = fingerprint
+		})
+		return
+	}
+	if bog.Type == "net" {
+		// Ancestors can't be proven hermetic either.
+		walkUpStepTree(bog.CmdTree, func(cmdTree CmdTree) {
+			s := g.step(cmdTree.Name())
+			if s.network == nil {
+				s.network = map[string]bool{}
 			}
-			g.steps[cmdTree.Name()] = s
+			s.network[bog.File] = true
 		})
+		return
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	walkUpStepTree(bog.CmdTree, func(cmdTree CmdTree) {
+		// We add this node to all ancestor steps to
+		// effectively make them depend on these files, too.
+		var s *step
+		if bog.Type == "dir" {
+			s = g.addDir(cmdTree.Name(), bog.File)
+		} else {
+			s = g.addEdge(cmdTree.Name(), bog.Reads(), bog.File, provenance, entryStamp(bog))
+		}
+		if bog.BuildID > s.build {
+			s.build = bog.BuildID
+		}
+	})
+}
+
+// addEdge records that the step called name reads node, or writes it, at
+// at, and returns the step.
+func (g *DependencyGraph) addEdge(name string, read bool, node, provenance string, at stamp) *step {
+	s := g.step(name)
+	if read {
+		s.recordRead(node, at)
+		s.readFiles[node] = true
+		if provenance != "" {
+			if s.overlayReads == nil {
+				s.overlayReads = map[string]string{}
+			}
+			s.overlayReads[node] = provenance
+		}
+		return s
+	}
+	if at.at != 0 || s.writeTimes != nil {
+		s.recordWrite(node, at, hasStep(g.fileWriters[node], s))
+	}
+	g.fileWriters[node] = append(g.fileWriters[node], s)
+	if provenance != "" {
+		if s.overlayWrites == nil {
+			s.overlayWrites = map[string]string{}
+		}
+		s.overlayWrites[node] = provenance
+	}
+	return s
+}
+
+// addDir records that the step called name listed dir, and returns the step.
+func (g *DependencyGraph) addDir(name, dir string) *step {
+	s := g.step(name)
+	if s.readDirs == nil {
+		s.readDirs = map[string]bool{}
+	}
+	s.readDirs[dir] = true
+	return s
+}
+
+// step returns the step called name, creating it if needed.
+func (g *DependencyGraph) step(name string) *step {
+	s, ok := g.steps[name]
+	if !ok {
+		s = &step{readFiles: map[string]bool{}, name: name}
+		g.steps[name] = s
+	}
+	return s
+}
+
+// lookup returns the step cmdTree, with its name normalized like the names
+// of the steps in the graph.
+func (g *DependencyGraph) lookup(cmdTree CmdTree) (*step, bool) {
+	s, ok := g.steps[g.normalizer.CmdTree(cmdTree).Name()]
+	return s, ok
+}
+
+// StepDuration returns how long cmdTree took to run in the base build. It
+// returns false if the duration wasn't recorded.
+func (g *DependencyGraph) StepDuration(cmdTree CmdTree) (time.Duration, bool) {
+	s, ok := g.lookup(cmdTree)
+	if !ok || s.duration == 0 {
+		return 0, false
+	}
+	return s.duration, true
+}
+
+// StepBuildID returns the ID of the build cmdTree was last recorded in, whose
+// outputs are the ones of the base build. It returns false if the graph
+// doesn't know it, like for reports without build IDs.
+func (g *DependencyGraph) StepBuildID(cmdTree CmdTree) (string, bool) {
+	s, ok := g.lookup(cmdTree)
+	if !ok || s.build == "" {
+		return "", false
+	}
+	return s.build, true
+}
+
+// NetworkAccesses returns the network addresses cmdTree connected to in the
+// base build, sorted, or nil if it made no connections.
+func (g *DependencyGraph) NetworkAccesses(cmdTree CmdTree) []string {
+	s, _, ok := g.find(cmdTree)
+	if !ok || len(s.network) == 0 {
+		return nil
+	}
+	return sortedKeys(s.network)
+}
+
+// StepEnvironment returns the environment variables cmdTree ran with in the
+// base build, as entries of type "env", sorted, or nil if they weren't
+// recorded.
+func (g *DependencyGraph) StepEnvironment(cmdTree CmdTree) []string {
+	s, _, ok := g.find(cmdTree)
+	if !ok {
+		return nil
+	}
+	return s.environment()
+}
+
+// inDir reports whether file is under dir.
+func inDir(file, dir string) bool {
+	return dir == "/" || strings.HasPrefix(file, dir+"/")
+}
+
+// changedPaths returns the normalized changed paths that steps may depend
+// on: the files of the graph in the changed directories are added, see
+// expandDirs, and the hermetic files are left out.
+func (g *DependencyGraph) changedPaths(changed []string) []string {
+	var out []string
+	for _, f := range g.expandDirs(normalizePaths(changed)) {
+		if !g.hermetic.Match(f) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// expandDirs adds to the normalized changed paths the files of the graph in
+// the ones that are directories of the graph. A deleted or renamed directory
+// changes every file in it, but changes often only list the directory.
+func (g *DependencyGraph) expandDirs(changed []string) []string {
+	g.mu.Lock()
+	if g.dirs == nil {
+		g.dirs = map[string]bool{}
+		g.forEachFile(func(f string) {
+			for d := path.Dir(f); !g.dirs[d] && d != "/"; d = path.Dir(d) {
+				g.dirs[d] = true
+			}
+		})
+	}
+	var dirs []string
+	for _, f := range changed {
+		if g.dirs[f] {
+			dirs = append(dirs, f)
+		}
+	}
+	g.mu.Unlock()
+	if len(dirs) == 0 {
+		return changed
+	}
+	out := append([]string(nil), changed...)
+	seen := map[string]bool{}
+	g.forEachFile(func(f string) {
+		for _, d := range dirs {
+			if !seen[f] && inDir(f, d) {
+				seen[f] = true
+				out = append(out, f)
+			}
+		}
+	})
+	return out
+}
+
+// forEachFile calls f for each file read or written in the graph. Files
+// read or written by several steps are passed several times.
+func (g *DependencyGraph) forEachFile(f func(file string)) {
+	for _, s := range g.steps {
+		for file := range s.readFiles {
+			f(file)
+		}
+	}
+	for file := range g.fileWriters {
+		f(file)
 	}
-	fmt.Println("dep graph build time:", time.Since(start))
-	return g, nil
 }
 
 func (g *DependencyGraph) String() string {
 	return fmt.Sprintf("graph with %d steps", len(g.steps))
 }
 
-type lookupState struct {
-	stepChecked map[string]bool
+// stepDeps holds what a step depends on through the steps that wrote the
+// files it reads, directly or not.
+type stepDeps struct {
+	files map[string]bool
+	// overlay records the overlay that introduced the edge leading to
+	// each file, if any.
+	overlay map[string]string
+	// dirs holds the directories listed by those steps.
+	dirs map[string]bool
+	// err is set if the lookup went over the limits.
+	err error
 }
 
-func (g *DependencyGraph) fileDeps(s *lookupState, filePath string) []string {
-	if _, ok := ignoreFiles[filePath]; ok {
-		return nil
+// ErrUnknownStep is returned, wrapped, when asked about a step that isn't in
+// the graph.
+var ErrUnknownStep = errors.New("unknown step")
+
+// ErrLookupLimit is returned, wrapped, by lookups that reach their
+// LookupLimits.
+var ErrLookupLimit = errors.New("lookup limit reached")
+
+// LookupLimits bound the transitive lookups of StepDependsOnFiles, so that
+// huge or pathological graphs can't make a decision arbitrarily slow. Zero
+// fields are unlimited. A lookup that reaches a limit fails, and the step
+// should be run.
+type LookupLimits struct {
+	// MaxDepth is the number of steps followed from a step through the
+	// files they write, e.g. 1 only looks at the steps writing the files
+	// the step reads.
+	MaxDepth int
+	// MaxFiles is the number of files explored for a step.
+	MaxFiles int
+}
+
+// SetLookupLimits sets the limits of later lookups.
+func (g *DependencyGraph) SetLookupLimits(l LookupLimits) {
+	g.mu.Lock()
+	g.limits = l
+	g.deps = nil
+	g.mu.Unlock()
+}
+
+// transitiveDeps returns the transitive dependencies of st. They're computed
+// once and shared by every later lookup, so deciding many steps of the same
+// build only walks each part of the graph once per step.
+func (g *DependencyGraph) transitiveDeps(st *step) *stepDeps {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if d, ok := g.deps[st.name]; ok {
+		return d
+	}
+	d := g.walkDeps(st)
+	if g.deps == nil {
+		g.deps = map[string]*stepDeps{}
+	}
+	g.deps[st.name] = d
+	return d
+}
+
+// resetDeps forgets the cached transitive dependencies, which must be done
+// whenever the graph changes.
+func (g *DependencyGraph) resetDeps() {
+	g.mu.Lock()
+	g.deps = nil
+	g.dirs = nil
+	g.index = nil
+	g.bloom = nil
+	g.mu.Unlock()
+}
+
+// walkDeps explores the graph breadth-first from the files st reads, through
+// the steps that produced them and the files those steps read, and so on.
+// The graph may have cycles, so each step is explored at most once, which
+// also guarantees termination. Whether a writer produced a file depends on
+// when its reader read it, see produces, so files are explored for each of
+// their readers, until all their writers are explored.
+func (g *DependencyGraph) walkDeps(st *step) *stepDeps {
+	d := &stepDeps{files: map[string]bool{}, overlay: map[string]string{}, dirs: map[string]bool{}}
+	type item struct {
+		step  *step
+		depth int
 	}
-	var files []string
-	if debug {
-		fmt.Printf("\tfileDeps(%v)\n", filePath)
-	}
-	for _, step := range g.fileWriters[filePath] {
-		if debug {
-			fmt.Printf("\t\tdepends on step %q (step writes to %v)\n", step.name, filePath)
-		}
-		// Note that the original filePath is irrelevant from here on,
-		// so we can cache the step dependencies as a whole,
-		// independently of which file is being checked.
-		if s.stepChecked[step.name] {
-			// This step and its dependencies have been checked.
-			// Either they don't have any relevant file reads, or
-			// it's already marked to be checked.
-			// fmt.Printf("\t\t\tstep %q, skipped\n", step.name)
-			continue
-		}
-		s.stepChecked[step.name] = true
-		for file := range step.readFiles {
-			if file == filePath {
+	queue := []item{{st, 0}}
+	fileChecked := map[string]bool{}
+	for f := range st.readFiles {
+		fileChecked[f] = true
+	}
+	// fileDone holds the files whose writers are all explored.
+	fileDone := map[string]bool{}
+	stepChecked := map[string]bool{st.name: true}
+	for len(queue) > 0 {
+		it := queue[0]
+		queue = queue[1:]
+		for read := range it.step.readFiles {
+			if fileDone[read] {
 				continue
 			}
-			if debug {
-				fmt.Printf("\t\t\tstep %q, readFiles %v\n", step.name, file)
+			done := true
+			for _, w := range g.fileWriters[read] {
+				if stepChecked[w.name] {
+					// The step's reads are already queued.
+					continue
+				}
+				if !produces(w, it.step, read) {
+					done = false
+					continue
+				}
+				stepChecked[w.name] = true
+				logger.Debug("depends on step", "step", w.name, "writes", read)
+				if g.limits.MaxDepth > 0 && it.depth >= g.limits.MaxDepth {
+					d.err = fmt.Errorf("%w: the dependencies of step %q are more than %d steps deep", ErrLookupLimit, st.name, g.limits.MaxDepth)
+					return d
+				}
+				for dir := range w.readDirs {
+					d.dirs[dir] = true
+				}
+				// Files the writer reads back, like files it edits in
+				// place, are its dependencies too, whichever file it was
+				// reached through, so that lookups don't depend on the
+				// order files are explored in.
+				for file := range w.readFiles {
+					if o := w.overlayWrites[read]; o != "" {
+						d.overlay[file] = o
+					} else if o := w.overlayReads[file]; o != "" {
+						d.overlay[file] = o
+					}
+					d.files[file] = true
+					if fileChecked[file] {
+						continue
+					}
+					fileChecked[file] = true
+					if g.limits.MaxFiles > 0 && len(fileChecked) > g.limits.MaxFiles {
+						d.err = fmt.Errorf("%w: step %q depends on more than %d files", ErrLookupLimit, st.name, g.limits.MaxFiles)
+						return d
+					}
+				}
+				queue = append(queue, item{w, it.depth + 1})
 			}
-			files = append(files, file)
-			files = append(files, g.fileDeps(s, file)...)
+			fileDone[read] = done
 		}
 	}
-	return files
+	return d
 }
 
 // StepDependsOnFile returns true if the cmdTree depends on changedFiles,
@@ -192,7 +681,8 @@ func (g *DependencyGraph) StepDependsOnFiles(cmdTree CmdTree, changedFiles []str
 	// We want to be able to say "true" when asked if step3 depends
 	// (transitively) on F1.
 	//
-	// This implementation assumes there are no loops.
+	// The graph may have loops, see Cycles. The lookup explores each file
+	// and step at most once, so it terminates regardless.
 	//
 	// A step depends on a file F if the step has read from F or if it
 	// depends on another step who read from F.
@@ -208,32 +698,54 @@ func (g *DependencyGraph) StepDependsOnFiles(cmdTree CmdTree, changedFiles []str
 
 	// TODO(nictuku): We should require all inputs to be absolute because
 	// relative paths obviously change when the cwd changes, and that's unreliable.
-	for i, f := range changedFiles {
-		changedFiles[i] = absoluteNodePath(f)
-	}
+	changedFiles = g.changedPaths(changedFiles)
 
-	step, ok := g.steps[cmdTree.Name()]
+	step, note, ok := g.find(cmdTree)
 	if !ok {
-		return false, "", fmt.Errorf("unknown step: %v", cmdTree)
+		return false, "", fmt.Errorf("%w: %v", ErrUnknownStep, cmdTree)
 	}
-	if debug {
-		fmt.Printf("=> step %q\n", step.name)
+	if !g.mayDependOn(changedFiles) {
+		logger.Debug("no changed file is in the graph", "step", step.name, "changed", len(changedFiles))
+		return false, note, nil
 	}
-	s := &lookupState{stepChecked: map[string]bool{}}
-	for stepReadFile := range step.readFiles {
-		if debug {
-			fmt.Printf("\tstep %q -> %v\n", step.name, stepReadFile)
-		}
+	logger.Debug("checking step", "step", step.name, "changed", len(changedFiles))
+	run, reason, err := g.stepDependsOnFiles(step, changedFiles)
+	if err != nil || note == "" {
+		return run, reason, err
+	}
+	if run {
+		return true, reason + " (" + note + ")", nil
+	}
+	return false, note, nil
+}
+
+// stepDependsOnFiles is StepDependsOnFiles for a step of the graph.
+func (g *DependencyGraph) stepDependsOnFiles(step *step, changedFiles []string) (bool, string, error) {
+	for dir := range step.readDirs {
 		for _, changedFile := range changedFiles {
-			if changedFile == stepReadFile {
-				return true, fmt.Sprintf("step %q reads file %q which is being updated", step.name, stepReadFile), nil
+			if inDir(changedFile, dir) {
+				return true, fmt.Sprintf("step %q lists directory %q where %q is being updated", step.name, dir, changedFile), nil
 			}
 		}
-		for _, transitiveDep := range g.fileDeps(s, stepReadFile) {
-			for _, changedFile := range changedFiles {
-				if transitiveDep == changedFile {
-					return true, fmt.Sprintf("step %q has a dependency that uses %q", step.name, changedFile), nil
-				}
+	}
+	for _, changedFile := range changedFiles {
+		if step.readFiles[changedFile] {
+			return true, fmt.Sprintf("step %q reads file %q which is being updated%s", step.name, changedFile, overlayNote(step.overlayReads[changedFile])), nil
+		}
+	}
+	deps := g.transitiveDeps(step)
+	if deps.err != nil {
+		return false, "", deps.err
+	}
+	for _, changedFile := range changedFiles {
+		if deps.files[changedFile] {
+			return true, fmt.Sprintf("step %q has a dependency that uses %q%s", step.name, changedFile, overlayNote(deps.overlay[changedFile])), nil
+		}
+	}
+	for dir := range deps.dirs {
+		for _, changedFile := range changedFiles {
+			if inDir(changedFile, dir) {
+				return true, fmt.Sprintf("step %q has a dependency that lists directory %q where %q is being updated", step.name, dir, changedFile), nil
 			}
 		}
 	}
@@ -2,7 +2,11 @@ package stepselection
 
 import (
 	"bytes"
+	"errors"
+	"strings"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 func TestWalkUpStepTree(t *testing.T) {
@@ -21,3 +25,197 @@ func TestWalkUpStepTree(t *testing.T) {
 		t.Errorf("got %q wanted %q", gotString, want)
 	}
 }
+
+func TestDirectoryReads(t *testing.T) {
+	report := `{"CmdTree":["gen"],"Mode":"R","File":"/src/protos","Type":"dir"}
+{"CmdTree":["gen"],"Mode":"W","File":"/out/all.go"}
+{"CmdTree":["build"],"Mode":"R","File":"/out/all.go"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		step    CmdTree
+		changed string
+		depends bool
+		reason  string
+	}{
+		{CmdTree{"gen"}, "/src/protos/new.proto", true, `step "[\"gen\"]" lists directory "/src/protos" where "/src/protos/new.proto" is being updated`},
+		{CmdTree{"gen"}, "/src/protos-old/a.proto", false, ""},
+		{CmdTree{"build"}, "/src/protos/sub/a.proto", true, `step "[\"build\"]" has a dependency that lists directory "/src/protos" where "/src/protos/sub/a.proto" is being updated`},
+		{CmdTree{"build"}, "/src/protos", false, ""},
+	} {
+		depends, reason, err := g.StepDependsOnFiles(tc.step, []string{tc.changed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if depends != tc.depends || reason != tc.reason {
+			t.Errorf("%v with %v changed: got %v %q, wanted %v %q", tc.step, tc.changed, depends, reason, tc.depends, tc.reason)
+		}
+	}
+
+	chains, err := g.DependencyChains(CmdTree{"build"}, []string{"/src/protos/new.proto"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `/src/protos/new.proto -> in directory /src/protos listed by ["gen"], which writes /out/all.go -> read by ["build"]`
+	if len(chains) != 1 || chains[0].String() != want {
+		t.Errorf("got chains %q wanted %q", chains, want)
+	}
+}
+
+func TestExecAndLibraryReads(t *testing.T) {
+	// Outside of the hermetic paths, like a toolchain checked in the
+	// workspace.
+	report := `{"CmdTree":["cc"],"Mode":"X","File":"/src/tools/cc"}
+{"CmdTree":["cc"],"Mode":"L","File":"/src/tools/libz.so.1"}
+{"CmdTree":["cc"],"Mode":"W","File":"/out/a.o"}
+{"CmdTree":["ld"],"Mode":"R","File":"/out/a.o"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, changed := range []string{"/src/tools/cc", "/src/tools/libz.so.1"} {
+		for _, step := range []CmdTree{{"cc"}, {"ld"}} {
+			depends, _, err := g.StepDependsOnFiles(step, []string{changed})
+			if err != nil || !depends {
+				t.Errorf("%v with %v changed: got %v, %v, wanted it to run", step, changed, depends, err)
+			}
+		}
+	}
+	if _, err := NewDependencyGraph(strings.NewReader(`{"CmdTree":["ls"],"Mode":"X","File":"/src","Type":"dir"}`)); err == nil {
+		t.Errorf("loading a directory executed worked")
+	}
+}
+
+func TestTransitiveDepsCache(t *testing.T) {
+	report := `{"CmdTree":["gen"],"Mode":"R","File":"/src/a.proto"}
+{"CmdTree":["gen"],"Mode":"W","File":"/out/a.go"}
+{"CmdTree":["build"],"Mode":"R","File":"/out/a.go"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(changed string, want bool) {
+		t.Helper()
+		depends, _, err := g.StepDependsOnFiles(CmdTree{"build"}, []string{changed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if depends != want {
+			t.Errorf("with %v changed: got %v wanted %v", changed, depends, want)
+		}
+	}
+	check("/src/a.proto", true)
+	check("/src/b.proto", false)
+
+	// The cached dependencies of build must not survive a merge.
+	if err := g.Merge(strings.NewReader(`{"CmdTree":["gen"],"Mode":"R","File":"/src/b.proto"}
+{"CmdTree":["gen"],"Mode":"W","File":"/out/a.go"}
+`)); err != nil {
+		t.Fatal(err)
+	}
+	check("/src/a.proto", false)
+	check("/src/b.proto", true)
+}
+
+func TestLookupLimits(t *testing.T) {
+	// d <- c <- b <- a, through the files each step writes.
+	report := `{"CmdTree":["a"],"Mode":"R","File":"/src/a.in"}
+{"CmdTree":["a"],"Mode":"W","File":"/out/a"}
+{"CmdTree":["b"],"Mode":"R","File":"/out/a"}
+{"CmdTree":["b"],"Mode":"W","File":"/out/b"}
+{"CmdTree":["c"],"Mode":"R","File":"/out/b"}
+{"CmdTree":["c"],"Mode":"W","File":"/out/c"}
+{"CmdTree":["d"],"Mode":"R","File":"/out/c"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		limits  LookupLimits
+		wantErr bool
+	}{
+		{LookupLimits{}, false},
+		{LookupLimits{MaxDepth: 3}, false},
+		{LookupLimits{MaxDepth: 2}, true},
+		{LookupLimits{MaxFiles: 4}, false},
+		{LookupLimits{MaxFiles: 3}, true},
+	} {
+		g.SetLookupLimits(tc.limits)
+		depends, _, err := g.StepDependsOnFiles(CmdTree{"d"}, []string{"/src/a.in"})
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%+v: got error %v, wanted error: %v", tc.limits, err, tc.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrLookupLimit) {
+			t.Errorf("%+v: got error %v, wanted an ErrLookupLimit", tc.limits, err)
+		}
+		if err == nil && !depends {
+			t.Errorf("%+v: d doesn't depend on /src/a.in", tc.limits)
+		}
+	}
+}
+
+func TestStepDuration(t *testing.T) {
+	report := `{"CmdTree":["make"],"Type":"step","Duration":90000000000}
+{"CmdTree":["make","cc"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["make","cc"],"Type":"step","Duration":1000000000}
+{"CmdTree":["make","cc"],"Type":"step","Duration":2000000000}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Writing and loading the report again must keep the durations.
+	buf := new(bytes.Buffer)
+	if err := g.WriteReport(buf); err != nil {
+		t.Fatal(err)
+	}
+	g2, err := NewDependencyGraph(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		step CmdTree
+		want time.Duration
+		ok   bool
+	}{
+		{CmdTree{"make"}, 90 * time.Second, true},
+		{CmdTree{"make", "cc"}, 3 * time.Second, true},
+		{CmdTree{"test"}, 0, false},
+	} {
+		for _, g := range []*DependencyGraph{g, g2} {
+			got, ok := g.StepDuration(tc.step)
+			if got != tc.want || ok != tc.ok {
+				t.Errorf("StepDuration(%v): got %v, %v wanted %v, %v", tc.step, got, ok, tc.want, tc.ok)
+			}
+		}
+	}
+}
+
+func TestInternedPaths(t *testing.T) {
+	report := `{"CmdTree":["make","cc a.c"],"Mode":"R","File":"/src/common.h"}
+{"CmdTree":["make","cc b.c"],"Mode":"R","File":"/src/common.h"}
+`
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data []*byte
+	for _, cmdTree := range []CmdTree{{"make", "cc a.c"}, {"make", "cc b.c"}, {"make"}} {
+		s, ok := g.lookup(cmdTree)
+		if !ok {
+			t.Fatalf("step %v not found", cmdTree)
+		}
+		for f := range s.readFiles {
+			data = append(data, unsafe.StringData(f))
+		}
+	}
+	if len(data) != 3 || data[0] != data[1] || data[1] != data[2] {
+		t.Errorf("the steps should share the path of the file they read")
+	}
+}
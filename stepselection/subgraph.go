@@ -0,0 +1,83 @@
+package stepselection
+
+import (
+	"io"
+	"time"
+)
+
+// NewStepDependencyGraph creates a DependencyGraph with only the part of the
+// build report that cmdTree may depend on: the step, the steps that write the
+// files it reads, the steps that write the files those read, and so on. Its
+// lookups for cmdTree give the same answers as the ones of NewDependencyGraph,
+// but memory is proportional to the relevant slice of the build instead of
+// the whole report.
+//
+// The report is read several times, once per level of dependencies plus
+// once to load the entries, so open is called for each pass. Loading is
+// therefore slower than NewDependencyGraph for reports that fit in memory.
+func NewStepDependencyGraph(open func() (io.ReadCloser, error), cmdTree CmdTree, opts ...Option) (*DependencyGraph, error) {
+	start := time.Now()
+	o := newOptions(opts)
+	normalizer := o.normalizer
+	cmdTree = normalizer.CmdTree(cmdTree)
+	// steps holds the names of the steps cmdTree depends on, and files the
+	// files they read. Writes are attributed to the ancestors of a step, so
+	// an ancestor of a writer is a writer too.
+	steps := map[string]bool{cmdTree.Name(): true}
+	files := map[string]bool{}
+	pass := func(add func(bog *BuildLog, provenance string)) error {
+		r, err := open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return readEntries(r, opts, add)
+	}
+	for passes := 1; ; passes++ {
+		grew := false
+		err := pass(func(bog *BuildLog, _ string) {
+			if bog.Type == "step" {
+				return
+			}
+			walkUpStepTree(bog.CmdTree, func(t CmdTree) {
+				name := t.Name()
+				switch {
+				case bog.Reads() && bog.Type == "" && steps[name] && !files[bog.File]:
+					files[bog.File] = true
+					grew = true
+				case bog.Mode == "W" && files[bog.File] && !steps[name]:
+					steps[name] = true
+					grew = true
+				}
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !grew {
+			logger.Debug("found the dependencies of the step", "step", cmdTree.Name(), "passes", passes, "steps", len(steps), "files", len(files))
+			break
+		}
+	}
+
+	g := &DependencyGraph{
+		steps:       map[string]*step{},
+		fileWriters: map[string][]*step{},
+		normalizer:  normalizer,
+		hermetic:    o.hermetic,
+	}
+	err := pass(func(bog *BuildLog, provenance string) {
+		relevant := false
+		walkUpStepTree(bog.CmdTree, func(t CmdTree) {
+			relevant = relevant || steps[t.Name()]
+		})
+		if relevant {
+			g.add(bog, provenance)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("dep graph built", "duration", time.Since(start), "steps", len(g.steps))
+	return g, nil
+}
@@ -0,0 +1,59 @@
+package stepselection
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestNewStepDependencyGraph(t *testing.T) {
+	// b depends on a through x, and the steps of "other" are unrelated.
+	// The entries are out of order so that finding the writer of x takes
+	// more than one pass.
+	report := `{"CmdTree":["make","b"],"Mode":"R","File":"/x"}
+{"CmdTree":["make","b"],"Mode":"W","File":"/y"}
+{"CmdTree":["gen","a"],"Mode":"W","File":"/x"}
+{"CmdTree":["gen","a"],"Mode":"R","File":"/a.in"}
+{"CmdTree":["gen","z"],"Mode":"R","File":"/z.in"}
+{"CmdTree":["other"],"Mode":"R","File":"/x"}
+{"CmdTree":["other"],"Mode":"W","File":"/o"}
+{"CmdTree":["other","c"],"Mode":"R","File":"/c.in"}
+{"CmdTree":["other","c"],"Mode":"W","File":"/c.out"}
+`
+	opens := 0
+	open := func() (io.ReadCloser, error) {
+		opens++
+		return ioutil.NopCloser(strings.NewReader(report)), nil
+	}
+	g, err := NewStepDependencyGraph(open, CmdTree{"make", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opens < 2 {
+		t.Errorf("the report was read %d times", opens)
+	}
+	for _, s := range []CmdTree{{"other"}, {"other", "c"}} {
+		if g.HasStep(s) {
+			t.Errorf("unrelated step %v was loaded", s.Name())
+		}
+	}
+	full, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// gen writes /x, so it depends on everything its steps read.
+	for _, f := range []string{"/x", "/a.in", "/z.in", "/c.in", "/y"} {
+		want, _, err := full.StepDependsOnFiles(CmdTree{"make", "b"}, []string{f})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _, err := g.StepDependsOnFiles(CmdTree{"make", "b"}, []string{f})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("depends on %v: got %v wanted %v", f, got, want)
+		}
+	}
+}
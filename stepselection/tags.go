@@ -0,0 +1,67 @@
+package stepselection
+
+import (
+	"regexp"
+	"sort"
+)
+
+// TagRule attaches Tags to every step whose command matches Pattern.
+type TagRule struct {
+	Pattern *regexp.Regexp
+	Tags    []string
+}
+
+// Tagger decides which tags apply to a step. Tags come from pattern rules,
+// usually defined in .skipper.yaml, and from a manifest listing steps by
+// name. A nil Tagger attaches no tags.
+type Tagger struct {
+	Rules []TagRule
+	// Manifest maps a step to its tags. Keys can be either the step's
+	// command, as in "go test ./...", or its full CmdTree name.
+	Manifest map[string][]string
+}
+
+// Tags returns the sorted set of tags for cmdTree. Pattern rules are matched
+// against the step's own command, which is the last element of its tree.
+func (t *Tagger) Tags(cmdTree CmdTree) []string {
+	if t == nil || len(cmdTree) == 0 {
+		return nil
+	}
+	command := cmdTree[len(cmdTree)-1]
+	set := map[string]bool{}
+	for _, tag := range t.Manifest[command] {
+		set[tag] = true
+	}
+	for _, tag := range t.Manifest[cmdTree.Name()] {
+		set[tag] = true
+	}
+	for _, r := range t.Rules {
+		if r.Pattern.MatchString(command) {
+			for _, tag := range r.Tags {
+				set[tag] = true
+			}
+		}
+	}
+	tags := make([]string, 0, len(set))
+	for tag := range set {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// HasAnyTag reports whether cmdTree carries at least one of the tags in
+// filter. An empty filter matches every step.
+func (t *Tagger) HasAnyTag(cmdTree CmdTree, filter []string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, tag := range t.Tags(cmdTree) {
+		for _, want := range filter {
+			if tag == want {
+				return true
+			}
+		}
+	}
+	return false
+}
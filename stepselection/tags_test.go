@@ -0,0 +1,41 @@
+package stepselection
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTagger(t *testing.T) {
+	tagger := &Tagger{
+		Rules: []TagRule{
+			{Pattern: regexp.MustCompile("^go test"), Tags: []string{"unit-tests"}},
+			{Pattern: regexp.MustCompile("generate"), Tags: []string{"codegen"}},
+		},
+		Manifest: map[string][]string{
+			"go test ./integration/...": {"slow"},
+		},
+	}
+
+	got := tagger.Tags(CmdTree{"make all", "go test ./integration/..."})
+	want := []string{"slow", "unit-tests"}
+	if diff := cmp.Diff(got, want); len(diff) > 0 {
+		t.Errorf("got %q wanted %q", got, want)
+	}
+
+	if !tagger.HasAnyTag(CmdTree{"go generate ./..."}, []string{"unit-tests", "codegen"}) {
+		t.Errorf("go generate should have the codegen tag")
+	}
+	if tagger.HasAnyTag(CmdTree{"make lint"}, []string{"unit-tests"}) {
+		t.Errorf("make lint should have no tags")
+	}
+	if !tagger.HasAnyTag(CmdTree{"make lint"}, nil) {
+		t.Errorf("an empty filter should match every step")
+	}
+
+	var nilTagger *Tagger
+	if tags := nilTagger.Tags(CmdTree{"make"}); len(tags) != 0 {
+		t.Errorf("nil Tagger returned tags %q", tags)
+	}
+}
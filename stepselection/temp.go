@@ -0,0 +1,71 @@
+package stepselection
+
+// DefaultTempPatterns are the scratch files whose entries are dropped unless
+// configured otherwise, if no step but the ones writing them reads them.
+var DefaultTempPatterns = []string{"/tmp/**", "**/.cache/**"}
+
+var defaultTemp = MustPathMatcher(DefaultTempPatterns)
+
+// tempFiles holds the entries of the temporary files of a build report until
+// the whole report is read, since a step may read a file written by another
+// one anywhere in the report. See WithTemp.
+type tempFiles struct {
+	entries []*BuildLog
+	// writers and readers are the names of the steps that wrote and read
+	// each file.
+	writers map[string]map[string]bool
+	readers map[string]map[string]bool
+}
+
+func newTempFiles() *tempFiles {
+	return &tempFiles{writers: map[string]map[string]bool{}, readers: map[string]map[string]bool{}}
+}
+
+// hold keeps bog for later if it's about a temporary file, returning whether
+// it did.
+func (t *tempFiles) hold(m *PathMatcher, bog *BuildLog) bool {
+	if bog.Type != "" || !m.Match(bog.File) {
+		return false
+	}
+	t.entries = append(t.entries, bog)
+	steps := t.readers
+	if bog.Mode == "W" {
+		steps = t.writers
+	}
+	if steps[bog.File] == nil {
+		steps[bog.File] = map[string]bool{}
+	}
+	steps[bog.File][CmdTree(bog.CmdTree).Name()] = true
+	return true
+}
+
+// scratch reports whether file is a scratch file, written and only read by
+// the steps that wrote it.
+func (t *tempFiles) scratch(file string) bool {
+	writers := t.writers[file]
+	if len(writers) == 0 {
+		return false
+	}
+	for name := range t.readers[file] {
+		if !writers[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// flush calls add with the held entries of the files that aren't scratch
+// files, in the order of the report.
+func (t *tempFiles) flush(add func(bog *BuildLog, provenance string)) {
+	dropped := 0
+	for _, bog := range t.entries {
+		if t.scratch(bog.File) {
+			dropped++
+			continue
+		}
+		add(bog, "")
+	}
+	if dropped > 0 {
+		logger.Debug("dropped the entries of scratch files", "entries", dropped)
+	}
+}
@@ -0,0 +1,52 @@
+package stepselection
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTempEntries(t *testing.T) {
+	// cc writes and reads its own scratch file, and so does ld, with the
+	// same name. gen writes a header in /tmp that cc reads.
+	report := `{"CmdTree":["cc a.c"],"Mode":"W","File":"/tmp/cc.s"}
+{"CmdTree":["cc a.c"],"Mode":"R","File":"/tmp/cc.s"}
+{"CmdTree":["cc a.c"],"Mode":"R","File":"/tmp/gen.h"}
+{"CmdTree":["cc a.c"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["cc a.c"],"Mode":"W","File":"/src/a.o"}
+{"CmdTree":["ld"],"Mode":"W","File":"/tmp/cc.s"}
+{"CmdTree":["ld"],"Mode":"R","File":"/tmp/cc.s"}
+{"CmdTree":["ld"],"Mode":"W","File":"/home/ci/.cache/ld/index"}
+{"CmdTree":["ld"],"Mode":"R","File":"/src/a.o"}
+{"CmdTree":["gen"],"Mode":"W","File":"/tmp/gen.h"}
+`
+	for _, tc := range []struct {
+		opts  []Option
+		reads []string
+		// scratch are the writers of /tmp/cc.s.
+		scratch []string
+	}{
+		{nil, []string{"/src/a.c", "/tmp/gen.h"}, nil},
+		{[]Option{WithTemp(nil)}, []string{"/src/a.c", "/tmp/cc.s", "/tmp/gen.h"}, []string{`["cc a.c"]`, `["ld"]`}},
+	} {
+		g, err := NewDependencyGraph(strings.NewReader(report), tc.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sortedKeys(g.steps[`["cc a.c"]`].readFiles); strings.Join(got, " ") != strings.Join(tc.reads, " ") {
+			t.Errorf("got reads %q wanted %q", got, tc.reads)
+		}
+		if got := g.FileWriters("/tmp/cc.s"); strings.Join(got, " ") != strings.Join(tc.scratch, " ") {
+			t.Errorf("got writers of /tmp/cc.s %q wanted %q", got, tc.scratch)
+		}
+		if got := g.FileWriters("/tmp/gen.h"); len(got) != 1 || got[0] != `["gen"]` {
+			t.Errorf("got writers of /tmp/gen.h %q wanted gen", got)
+		}
+	}
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := g.FileWriters("/home/ci/.cache/ld/index"); len(got) != 0 {
+		t.Errorf("got writers of a cache file nobody reads %q", got)
+	}
+}
@@ -0,0 +1,76 @@
+package stepselection
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ToolEntry returns the File of an entry of type "tool", recording that a
+// step ran the tool at path, whose fingerprint was fingerprint.
+func ToolEntry(path, fingerprint string) string {
+	return fingerprint + " " + path
+}
+
+// splitToolEntry returns the path and fingerprint of an entry of type
+// "tool". Fingerprints have no spaces, but paths may.
+func splitToolEntry(entry string) (path, fingerprint string) {
+	fingerprint, path, _ = strings.Cut(entry, " ")
+	return path, fingerprint
+}
+
+// toolchains returns the entries of the tools s ran, sorted, or nil if none
+// was recorded.
+func (s *step) toolchains() []string {
+	if len(s.tools) == 0 {
+		return nil
+	}
+	entries := make([]string, 0, len(s.tools))
+	for path, fingerprint := range s.tools {
+		entries = append(entries, ToolEntry(path, fingerprint))
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// StepToolchains returns the fingerprints of the tools cmdTree or its
+// descendants ran in the base build, by path, or nil if none was recorded.
+func (g *DependencyGraph) StepToolchains(cmdTree CmdTree) map[string]string {
+	s, _, ok := g.find(cmdTree)
+	if !ok || len(s.tools) == 0 {
+		return nil
+	}
+	return s.tools
+}
+
+// toolchainGraph is implemented by the graphs that record the tools steps
+// ran.
+type toolchainGraph interface {
+	StepToolchains(cmdTree CmdTree) map[string]string
+}
+
+// ToolchainChanges returns the paths of the tools cmdTree ran in the base
+// build whose fingerprint changed since, sorted. fingerprint returns the
+// current fingerprint of a tool, and false for tools that are no longer
+// fingerprinted, which are left out. It returns nil if none changed, or if
+// g doesn't know the tools cmdTree ran.
+func ToolchainChanges(g Graph, cmdTree CmdTree, fingerprint func(path string) (string, bool)) []string {
+	tg, ok := g.(toolchainGraph)
+	if !ok {
+		return nil
+	}
+	var changed []string
+	for path, recorded := range tg.StepToolchains(cmdTree) {
+		if now, ok := fingerprint(path); ok && now != recorded {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// ToolchainReason explains why cmdTree runs because the tools at paths
+// changed.
+func ToolchainReason(cmdTree CmdTree, paths []string) string {
+	return fmt.Sprintf("step %q runs a different %v than in the base build", cmdTree.Name(), strings.Join(paths, ", "))
+}
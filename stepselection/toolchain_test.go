@@ -0,0 +1,86 @@
+package stepselection
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const toolchainReport = `{"CmdTree":["make"],"Mode":"R","File":"/src/Makefile"}
+{"CmdTree":["make","cc a.c"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["make","cc a.c"],"Type":"tool","File":"aaaa /usr/bin/gcc"}
+{"CmdTree":["make","py gen.py"],"Type":"tool","File":"bbbb /usr/bin/python3"}
+`
+
+func TestToolchainChanges(t *testing.T) {
+	g, err := NewDependencyGraph(strings.NewReader(toolchainReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := map[string]string{"/usr/bin/gcc": "aaaa", "/usr/bin/python3": "cccc"}
+	fingerprint := func(path string) (string, bool) {
+		f, ok := current[path]
+		return f, ok
+	}
+	for _, tc := range []struct {
+		step CmdTree
+		want []string
+	}{
+		{CmdTree{"make", "cc a.c"}, nil},
+		{CmdTree{"make", "py gen.py"}, []string{"/usr/bin/python3"}},
+		// Ancestors ran the tools of their descendants.
+		{CmdTree{"make"}, []string{"/usr/bin/python3"}},
+		{CmdTree{"deploy"}, nil},
+	} {
+		if diff := cmp.Diff(ToolchainChanges(g, tc.step, fingerprint), tc.want); diff != "" {
+			t.Errorf("%v: unexpected changes, diff: %v", tc.step, diff)
+		}
+	}
+	// Tools that are no longer fingerprinted don't count.
+	delete(current, "/usr/bin/python3")
+	if changed := ToolchainChanges(g, CmdTree{"make"}, fingerprint); changed != nil {
+		t.Errorf("got changes %v for tools that aren't fingerprinted", changed)
+	}
+
+	// Fingerprints survive writing the graph back and compiling it.
+	report := new(bytes.Buffer)
+	if err := g.WriteReport(report); err != nil {
+		t.Fatal(err)
+	}
+	written, err := NewDependencyGraph(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled := new(bytes.Buffer)
+	if err := CompileGraph(compiled, strings.NewReader(toolchainReport), CompiledSource{Size: 1, ModTime: time.Unix(1, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCompiledGraph(compiled, CompiledSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, other := range map[string]*DependencyGraph{"written": written, "compiled": loaded} {
+		want := map[string]string{"/usr/bin/gcc": "aaaa", "/usr/bin/python3": "bbbb"}
+		if diff := cmp.Diff(other.StepToolchains(CmdTree{"make"}), want); diff != "" {
+			t.Errorf("%v graph: unexpected tools, diff: %v", name, diff)
+		}
+	}
+}
+
+func TestInvalidToolEntry(t *testing.T) {
+	report := `{"CmdTree":["cc a.c"],"Type":"tool","File":"/usr/bin/gcc"}`
+	if _, err := NewDependencyGraph(strings.NewReader(report)); err == nil {
+		t.Errorf("loaded a tool entry without a fingerprint")
+	}
+}
+
+func TestToolchainReason(t *testing.T) {
+	got := ToolchainReason(CmdTree{"cc a.c"}, []string{"/usr/bin/gcc"})
+	want := `step "[\"cc a.c\"]" runs a different /usr/bin/gcc than in the base build`
+	if got != want {
+		t.Errorf("got %q wanted %q", got, want)
+	}
+}
@@ -0,0 +1,243 @@
+package stepselection
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportVersion is the version of the build report format. Reports start
+// with a ReportHeader line giving their version. Reports without one are
+// version 0, from before reports were versioned, and are still read. Reports
+// concatenated together have several headers, each applying to the entries
+// after it.
+const ReportVersion = 1
+
+// ErrUnsupportedVersion is returned, wrapped, when reading a build report or
+// compiled graph written by a newer version of skipper.
+var ErrUnsupportedVersion = errors.New("unsupported format version")
+
+// ReportHeader is the first line of a build report.
+type ReportHeader struct {
+	// SkipperReport is the version of the report. It's the first key of
+	// the line, which tells headers and entries apart.
+	SkipperReport int
+	// Created is when the build was recorded, and Commit the commit of
+	// the checkout it was recorded in. They tell how stale the graph is,
+	// and are missing from reports written without them, like by older
+	// versions of skipper.
+	Created *time.Time `json:",omitempty"`
+	Commit  string     `json:",omitempty"`
+}
+
+var headerPrefix = []byte(`{"SkipperReport":`)
+
+// Encoder writes the lines of a build report, like a json.Encoder or a
+// builddata.Writer.
+type Encoder interface {
+	Encode(v any) error
+}
+
+// WriteReportHeader writes the header of a build report of the current
+// version to enc.
+func WriteReportHeader(enc Encoder) error {
+	return enc.Encode(&ReportHeader{SkipperReport: ReportVersion})
+}
+
+// WriteRecordedReportHeader writes the header of a build report of the
+// current version to enc, for a build recorded at created in the checkout of
+// commit, which may be unknown.
+func WriteRecordedReportHeader(enc Encoder, created time.Time, commit string) error {
+	created = created.UTC()
+	return enc.Encode(&ReportHeader{SkipperReport: ReportVersion, Created: &created, Commit: commit})
+}
+
+// ReadReportHeader returns the header of the build report r, or an empty
+// header if r has none, like version 0 reports. Only the first line of r is
+// read.
+func ReadReportHeader(r io.Reader) (*ReportHeader, error) {
+	line, err := bufio.NewReader(r).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	line = bytes.TrimSpace(line)
+	h := &ReportHeader{}
+	if !bytes.HasPrefix(line, headerPrefix) {
+		return h, nil
+	}
+	if err := json.Unmarshal(line, h); err != nil {
+		return nil, fmt.Errorf("invalid report header: %v", err)
+	}
+	return h, nil
+}
+
+// decodeEntry decodes a line of a build report. It returns nil for headers.
+// Entries with unknown fields, modes or types are rejected rather than
+// misread. Relative files of entries with a Cwd are made absolute.
+func decodeEntry(line []byte) (*BuildLog, error) {
+	if bytes.HasPrefix(line, headerPrefix) {
+		h := &ReportHeader{}
+		if err := json.Unmarshal(line, h); err != nil {
+			return nil, fmt.Errorf("invalid report header: %v", err)
+		}
+		if h.SkipperReport < 1 || h.SkipperReport > ReportVersion {
+			return nil, fmt.Errorf("%w: the build report has version %d, but this version of skipper reads versions up to %d", ErrUnsupportedVersion, h.SkipperReport, ReportVersion)
+		}
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.DisallowUnknownFields()
+	bog := &BuildLog{}
+	if err := dec.Decode(bog); err != nil {
+		return nil, err
+	}
+	if err := bog.validate(); err != nil {
+		return bog, err
+	}
+	if bog.Type == "" || bog.Type == "dir" {
+		bog.resolveCwd()
+	}
+	return bog, nil
+}
+
+// validate checks that the entry makes sense for its type.
+func (bog *BuildLog) validate() error {
+	if len(bog.CmdTree) == 0 {
+		return errors.New("entry without a CmdTree")
+	}
+	if bog.Cwd != "" && !path.IsAbs(toNodePath(bog.Cwd)) {
+		return fmt.Errorf("relative Cwd %q", bog.Cwd)
+	}
+	switch bog.Type {
+	case "step":
+		return nil
+	case "net":
+		if bog.File == "" {
+			return errors.New("network entry without an address")
+		}
+		return nil
+	case "env":
+		if envName(bog.File) == "" {
+			return errors.New("environment entry without a variable")
+		}
+		return nil
+	case "tool":
+		if path, fingerprint := splitToolEntry(bog.File); path == "" || fingerprint == "" {
+			return errors.New("tool entry without a fingerprint and path")
+		}
+		return nil
+	case "", "dir":
+	default:
+		return fmt.Errorf("unknown entry type %q", bog.Type)
+	}
+	switch {
+	case bog.Mode == "R" || bog.Mode == "W":
+	case (bog.Mode == "X" || bog.Mode == "L") && bog.Type == "":
+	default:
+		return fmt.Errorf("unknown mode %q", bog.Mode)
+	}
+	if bog.File == "" {
+		return errors.New("entry without a File")
+	}
+	return nil
+}
+
+// UpgradeReport copies the build report r to w in the current format, and
+// returns the version r had, the highest if it was made of several reports.
+// The entries are copied as they are, without applying any option.
+func UpgradeReport(w io.Writer, r io.Reader) (from int, err error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	// The header is written before the first entry, with the metadata of
+	// the first header of r, which comes before it.
+	header := &ReportHeader{SkipperReport: ReportVersion}
+	headerWritten := false
+	writeHeader := func() error {
+		if headerWritten {
+			return nil
+		}
+		headerWritten = true
+		return enc.Encode(header)
+	}
+	scanner := newLineScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		bog, err := decodeEntry(b)
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+		if bog == nil {
+			h := &ReportHeader{}
+			json.Unmarshal(b, h)
+			if h.SkipperReport > from {
+				from = h.SkipperReport
+			}
+			if !headerWritten && header.Created == nil {
+				header.Created, header.Commit = h.Created, h.Commit
+			}
+			continue
+		}
+		if err := writeHeader(); err != nil {
+			return 0, err
+		}
+		if err := enc.Encode(bog); err != nil {
+			return 0, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if err := writeHeader(); err != nil {
+		return 0, err
+	}
+	return from, bw.Flush()
+}
+
+// maxCorruptLines limits how many line numbers of corrupt lines are logged.
+const maxCorruptLines = 10
+
+// corruptLines counts the lines of a build report skipped because they don't
+// parse.
+type corruptLines struct {
+	count int
+	lines []int
+	first error
+}
+
+func (c *corruptLines) add(line int, err error) {
+	if c.count == 0 {
+		c.first = err
+	}
+	c.count++
+	if len(c.lines) < maxCorruptLines {
+		c.lines = append(c.lines, line)
+	}
+}
+
+// report warns about the skipped lines, if any.
+func (c *corruptLines) report() {
+	if c.count == 0 {
+		return
+	}
+	lines := make([]string, len(c.lines))
+	for i, l := range c.lines {
+		lines[i] = strconv.Itoa(l)
+	}
+	if c.count > len(c.lines) {
+		lines = append(lines, "...")
+	}
+	logger.Warn("skipped corrupt lines of the build report", "count", c.count, "lines", strings.Join(lines, ","), "first_err", c.first)
+}
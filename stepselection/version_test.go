@@ -0,0 +1,154 @@
+package stepselection
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportVersion(t *testing.T) {
+	entries := `{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["cc"],"Mode":"W","File":"/out/a.o"}
+`
+	for name, report := range map[string]string{
+		"unversioned": entries,
+		"current":     `{"SkipperReport":1}` + "\n" + entries,
+		"concatenated": `{"SkipperReport":1}` + "\n" + entries +
+			`{"SkipperReport":1}` + "\n" + `{"CmdTree":["ld"],"Mode":"R","File":"/out/a.o"}` + "\n",
+	} {
+		if _, err := NewDependencyGraph(strings.NewReader(report)); err != nil {
+			t.Errorf("%v: %v", name, err)
+		}
+	}
+
+	_, err := NewDependencyGraph(strings.NewReader(`{"SkipperReport":2}` + "\n" + entries))
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("future version: got %v, wanted ErrUnsupportedVersion", err)
+	}
+	for name, entry := range map[string]string{
+		"unknown field": `{"CmdTree":["cc"],"Mode":"R","Path":"/src/a.c"}`,
+		"unknown mode":  `{"CmdTree":["cc"],"Mode":"RW","File":"/src/a.c"}`,
+		"unknown type":  `{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c","Type":"socket"}`,
+		"no file":       `{"CmdTree":["cc"],"Mode":"R"}`,
+		"no step":       `{"Mode":"R","File":"/src/a.c"}`,
+	} {
+		if _, err := NewDependencyGraph(strings.NewReader(entries + entry + "\n")); err == nil || !strings.Contains(err.Error(), "line 3") {
+			t.Errorf("%v: got %v, wanted an error on line 3", name, err)
+		}
+	}
+}
+
+func TestUpgradeReport(t *testing.T) {
+	report := `{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}
+
+{"CmdTree":["cc"],"Duration":1000,"Type":"step"}
+`
+	got := new(bytes.Buffer)
+	from, err := UpgradeReport(got, strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != 0 {
+		t.Errorf("got version %d, wanted 0", from)
+	}
+	want := `{"SkipperReport":1}
+{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["cc"],"Mode":"","File":"","Type":"step","Duration":1000}
+`
+	if got.String() != want {
+		t.Errorf("got\n%v\nwanted\n%v", got.String(), want)
+	}
+
+	again := new(bytes.Buffer)
+	if from, err := UpgradeReport(again, got); err != nil || from != ReportVersion {
+		t.Errorf("upgrading a current report: got version %d, %v", from, err)
+	}
+	if again.String() != want {
+		t.Errorf("upgrading a current report changed it:\n%v", again.String())
+	}
+}
+
+func TestCompiledGraphFutureVersion(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(&compiledHeader{Version: compiledGraphVersion + 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCompiledGraph(buf, CompiledSource{}); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("got %v, wanted ErrUnsupportedVersion", err)
+	}
+}
+
+func TestReportMetadata(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	if err := WriteRecordedReportHeader(enc, created.In(time.FixedZone("", 3600)), "abc123"); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString(`{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}` + "\n")
+	report := buf.String()
+	if _, err := NewDependencyGraph(strings.NewReader(report)); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := ReadReportHeader(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.SkipperReport != ReportVersion || h.Created == nil || !h.Created.Equal(created) || h.Commit != "abc123" {
+		t.Errorf("got header %+v", h)
+	}
+	if h, err := ReadReportHeader(strings.NewReader(`{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}`)); err != nil || h.SkipperReport != 0 || h.Created != nil {
+		t.Errorf("unversioned report: got header %+v, %v", h, err)
+	}
+
+	// Upgrading and rewriting the graph keep the metadata.
+	upgraded := new(bytes.Buffer)
+	if _, err := UpgradeReport(upgraded, strings.NewReader(report)); err != nil {
+		t.Fatal(err)
+	}
+	if h, err := ReadReportHeader(upgraded); err != nil || h.Created == nil || h.Commit != "abc123" {
+		t.Errorf("upgraded report: got header %+v, %v", h, err)
+	}
+	g, err := NewDependencyGraph(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.SetReportMetadata(h)
+	written := new(bytes.Buffer)
+	if err := g.WriteReport(written); err != nil {
+		t.Fatal(err)
+	}
+	if h, err := ReadReportHeader(written); err != nil || h.Created == nil || !h.Created.Equal(created) || h.Commit != "abc123" {
+		t.Errorf("written report: got header %+v, %v", h, err)
+	}
+}
+
+func TestSkipCorruptLines(t *testing.T) {
+	report := `{"SkipperReport":1}
+{"CmdTree":["cc"],"Mode":"R","File":"/src/a.c"}
+{"CmdTree":["cc"],"Mode":"R","Fi
+{"CmdTree":["ld"],"Mode":"R","File":"/out/a.o"}
+{"CmdTree":["cc"],"Mode":"W","File":"/out/a.o"}
+{"CmdTree":["ld"],"Mode":"W","Fi`
+	if _, err := NewDependencyGraph(strings.NewReader(report)); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("got %v, wanted an error on line 3", err)
+	}
+	g, err := NewDependencyGraph(strings.NewReader(report), WithSkipCorruptLines())
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps, _, err := g.StepDependsOnFiles([]string{"ld"}, []string{"/src/a.c"})
+	if err != nil || !deps {
+		t.Errorf("got %v, %v, wanted ld to depend on /src/a.c through the lines around the corrupt ones", deps, err)
+	}
+
+	future := `{"SkipperReport":2}` + "\n" + report
+	if _, err := NewDependencyGraph(strings.NewReader(future), WithSkipCorruptLines()); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("future version: got %v, wanted ErrUnsupportedVersion", err)
+	}
+}
@@ -0,0 +1,294 @@
+// Package tracing records OpenTelemetry spans and exports them with OTLP
+// over HTTP, encoded as JSON, so that skipper shows up in the traces of CI
+// pipelines without depending on the OpenTelemetry SDK.
+//
+// It's configured by the standard environment variables:
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or OTEL_EXPORTER_OTLP_ENDPOINT,
+// OTEL_EXPORTER_OTLP_HEADERS, OTEL_SERVICE_NAME, OTEL_SDK_DISABLED and
+// OTEL_TRACES_EXPORTER. Spans continue the trace given by the W3C
+// TRACEPARENT environment variable, which is how nested skipper invocations
+// join the trace of their parent.
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentEnv is the environment variable that carries the parent span
+// to child processes.
+const TraceparentEnv = "TRACEPARENT"
+
+// Tracer collects the spans of a process until Flush exports them. A nil
+// Tracer, returned when tracing isn't configured, records nothing.
+type Tracer struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	// traceID and parentID come from TRACEPARENT, if set.
+	traceID  string
+	parentID string
+
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// FromEnv returns a Tracer configured by the environment, or nil if no OTLP
+// endpoint is set or tracing is disabled.
+func FromEnv() *Tracer {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") || os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return nil
+	}
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return nil
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	t := &Tracer{endpoint: endpoint, headers: map[string]string{}, service: os.Getenv("OTEL_SERVICE_NAME")}
+	if t.service == "" {
+		t.service = "skipper"
+	}
+	for _, h := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if i := strings.Index(h, "="); i > 0 {
+			k, _ := url.QueryUnescape(strings.TrimSpace(h[:i]))
+			v, _ := url.QueryUnescape(strings.TrimSpace(h[i+1:]))
+			t.headers[k] = v
+		}
+	}
+	t.traceID, t.parentID = parseTraceparent(os.Getenv(TraceparentEnv))
+	if t.traceID == "" {
+		t.traceID = randomHex(16)
+	}
+	return t
+}
+
+// parseTraceparent returns the trace and span IDs of a W3C traceparent
+// header, or empty strings if it isn't valid.
+func parseTraceparent(tp string) (traceID, spanID string) {
+	parts := strings.Split(tp, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", ""
+	}
+	if _, err := hex.DecodeString(parts[1] + parts[2]); err != nil {
+		return "", ""
+	}
+	if parts[1] == strings.Repeat("0", 32) || parts[2] == strings.Repeat("0", 16) {
+		return "", ""
+	}
+	return parts[1], parts[2]
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		// Spans with a zero ID are invalid, but that's no reason to fail
+		// the build.
+		return strings.Repeat("0", 2*n)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Span is an operation of the process. The methods of a nil Span do
+// nothing.
+type Span struct {
+	t        *Tracer
+	name     string
+	id       string
+	parentID string
+	start    time.Time
+	end      time.Time
+	attrs    []attr
+	errMsg   string
+}
+
+type attr struct {
+	key   string
+	value interface{}
+}
+
+// Start begins a span, a child of parent or, if parent is nil, of the span
+// given by TRACEPARENT.
+func (t *Tracer) Start(name string, parent *Span) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{t: t, name: name, id: randomHex(8), parentID: t.parentID, start: time.Now()}
+	if parent != nil {
+		s.parentID = parent.id
+	}
+	return s
+}
+
+// SetAttr sets an attribute of the span. Values are strings, bools, ints or
+// durations, which are recorded in milliseconds.
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.attrs = append(s.attrs, attr{key, value})
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.errMsg = err.Error()
+}
+
+// End ends the span. Only ended spans are exported.
+func (s *Span) End() {
+	if s == nil || !s.end.IsZero() {
+		return
+	}
+	s.end = time.Now()
+	s.t.mu.Lock()
+	s.t.spans = append(s.t.spans, s)
+	s.t.mu.Unlock()
+}
+
+// Traceparent returns the W3C traceparent of the span, to pass it to child
+// processes in TRACEPARENT. It's empty for a nil Span.
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", s.t.traceID, s.id)
+}
+
+// Flush exports the spans ended so far, waiting at most timeout.
+func (t *Tracer) Flush(timeout time.Duration) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(t.request(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := (&http.Client{Timeout: timeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%v: %v", t.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// The types below are the OTLP JSON encoding of an
+// ExportTraceServiceRequest, limited to what skipper records.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+func otlpValue(v interface{}) json.RawMessage {
+	var b []byte
+	switch v := v.(type) {
+	case bool:
+		b, _ = json.Marshal(map[string]bool{"boolValue": v})
+	case int:
+		b, _ = json.Marshal(map[string]string{"intValue": strconv.Itoa(v)})
+	case int64:
+		b, _ = json.Marshal(map[string]string{"intValue": strconv.FormatInt(v, 10)})
+	case time.Duration:
+		b, _ = json.Marshal(map[string]string{"intValue": strconv.FormatInt(v.Milliseconds(), 10)})
+	default:
+		b, _ = json.Marshal(map[string]string{"stringValue": fmt.Sprint(v)})
+	}
+	return b
+}
+
+func (t *Tracer) request(spans []*Span) *otlpRequest {
+	ss := otlpScopeSpans{}
+	ss.Scope.Name = "github.com/yourbase/skipper"
+	for _, s := range spans {
+		sp := otlpSpan{
+			TraceID:           t.traceID,
+			SpanID:            s.id,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              1, // SPAN_KIND_INTERNAL
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		for _, a := range s.attrs {
+			sp.Attributes = append(sp.Attributes, otlpAttr{a.key, otlpValue(a.value)})
+		}
+		if s.errMsg != "" {
+			sp.Status = otlpStatus{Code: 2, Message: s.errMsg} // STATUS_CODE_ERROR
+		}
+		ss.Spans = append(ss.Spans, sp)
+	}
+	return &otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttr{{"service.name", otlpValue(t.service)}}},
+		ScopeSpans: []otlpScopeSpans{ss},
+	}}}
+}
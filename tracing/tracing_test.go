@@ -0,0 +1,118 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func setenv(t *testing.T, env map[string]string) {
+	for k, v := range env {
+		old, ok := os.LookupEnv(k)
+		os.Setenv(k, v)
+		k := k
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(k, old)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
+
+func TestFromEnvDisabled(t *testing.T) {
+	setenv(t, map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": ""})
+	tr := FromEnv()
+	if tr != nil {
+		t.Fatalf("got a tracer without an endpoint")
+	}
+	// A nil tracer and its spans do nothing.
+	s := tr.Start("skipper", nil)
+	s.SetAttr("k", "v")
+	s.End()
+	if s.Traceparent() != "" {
+		t.Errorf("got a traceparent for a nil span")
+	}
+	if err := tr.Flush(time.Second); err != nil {
+		t.Error(err)
+	}
+
+	setenv(t, map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318", "OTEL_SDK_DISABLED": "true"})
+	if FromEnv() != nil {
+		t.Errorf("got a tracer with OTEL_SDK_DISABLED")
+	}
+}
+
+func TestExport(t *testing.T) {
+	var got otlpRequest
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			http.NotFound(w, r)
+			return
+		}
+		auth = r.Header.Get("Authorization")
+		b, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+	parentTrace, parentSpan := "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	setenv(t, map[string]string{
+		"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "",
+		"OTEL_EXPORTER_OTLP_ENDPOINT":        srv.URL + "/",
+		"OTEL_EXPORTER_OTLP_HEADERS":         "Authorization=Bearer%20secret",
+		"OTEL_SDK_DISABLED":                  "",
+		TraceparentEnv:                       "00-" + parentTrace + "-" + parentSpan + "-01",
+	})
+	tr := FromEnv()
+	if tr == nil {
+		t.Fatal("got no tracer")
+	}
+	root := tr.Start("skipper", nil)
+	root.SetAttr("skipper.build_id", "b1")
+	child := tr.Start("decision", root)
+	child.SetAttr("skipper.run", true)
+	child.SetError(errors.New("unknown step"))
+	child.End()
+	root.End()
+	if want := "00-" + parentTrace + "-" + root.id + "-01"; root.Traceparent() != want {
+		t.Errorf("got traceparent %v, wanted %v", root.Traceparent(), want)
+	}
+	if err := tr.Flush(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if auth != "Bearer secret" {
+		t.Errorf("got Authorization %q", auth)
+	}
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("got %+v", got)
+	}
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, wanted 2", len(spans))
+	}
+	decision, skipper := spans[0], spans[1]
+	for _, s := range spans {
+		if s.TraceID != parentTrace {
+			t.Errorf("span %v has trace ID %v, wanted %v", s.Name, s.TraceID, parentTrace)
+		}
+	}
+	if skipper.ParentSpanID != parentSpan || decision.ParentSpanID != skipper.SpanID {
+		t.Errorf("wrong parents: skipper %v, decision %v", skipper.ParentSpanID, decision.ParentSpanID)
+	}
+	if decision.Status.Code != 2 || decision.Status.Message != "unknown step" {
+		t.Errorf("got status %+v", decision.Status)
+	}
+	if len(skipper.Attributes) != 1 || string(skipper.Attributes[0].Value) != `{"stringValue":"b1"}` {
+		t.Errorf("got attributes %+v", skipper.Attributes)
+	}
+}
@@ -0,0 +1,108 @@
+// Package upload uploads files to S3 and GCS buckets and to HTTP servers
+// accepting PUT requests, so that CI jobs can publish build reports without
+// the tools of each storage service.
+package upload
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var client = &http.Client{Transport: &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	TLSHandshakeTimeout:   30 * time.Second,
+	ResponseHeaderTimeout: time.Minute,
+}}
+
+// Put uploads the size bytes of body as the file name under dest, which is
+// one of:
+//
+//	s3://bucket/prefix      authenticated with AWS_ACCESS_KEY_ID,
+//	                        AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, in
+//	                        AWS_REGION or else us-east-1, through
+//	                        AWS_ENDPOINT_URL if set, like for MinIO
+//	gs://bucket/prefix      authenticated with the OAuth2 access token in
+//	                        GOOGLE_OAUTH_ACCESS_TOKEN, like the one printed
+//	                        by "gcloud auth print-access-token"
+//	https://host/prefix     authenticated with the credentials of the URL, if
+//	                        any
+func Put(dest, name string, body io.ReadSeeker, size int64) error {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return err
+	}
+	var req *http.Request
+	switch u.Scheme {
+	case "s3":
+		req, err = s3Request(u, name, body, size)
+	case "gs":
+		req, err = gcsRequest(u, name, body)
+	case "http", "https":
+		req, err = http.NewRequest("PUT", strings.TrimSuffix(u.String(), "/")+"/"+escapePath(name), body)
+	default:
+		return fmt.Errorf("unsupported destination %q, want an s3://, gs:// or https:// URL", dest)
+	}
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		// Otherwise the length counts as unknown.
+		req.Body = http.NoBody
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("uploading %v: %v: %s", name, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// object returns the name of the object for the file name under the prefix
+// of u, the path of a bucket URL.
+func object(u *url.URL, name string) string {
+	prefix := strings.Trim(u.Path, "/")
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
+
+// gcsRequest returns the request uploading to the bucket URL u, through the
+// XML API of GCS, or the emulator of STORAGE_EMULATOR_HOST.
+func gcsRequest(u *url.URL, name string, body io.Reader) (*http.Request, error) {
+	endpoint := "https://storage.googleapis.com"
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		endpoint = strings.TrimSuffix(host, "/")
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+	}
+	req, err := http.NewRequest("PUT", endpoint+"/"+url.PathEscape(u.Host)+"/"+escapePath(object(u, name)), body)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// escapePath escapes the elements of a slash-separated path.
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}